@@ -17,7 +17,12 @@
 package bigipclient
 
 import (
+	"bytes"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"time"
@@ -27,6 +32,14 @@ import (
 //go:generate counterfeiter -o fakes/fake_client.go . Client
 type Client interface {
 	Get(url, user, pass string) ([]byte, error)
+	GetWithToken(url, token string) ([]byte, error)
+	Login(url, user, pass, loginProvider string) (Token, error)
+}
+
+// Token iControl REST auth token and how long it is valid for
+type Token struct {
+	Token   string
+	Timeout time.Duration
 }
 
 // BigIPClient is a wrapper around an http client
@@ -51,6 +64,42 @@ func DefaultClient() *BigIPClient {
 	}
 }
 
+// NewClient returns a BIG-IP client which verifies the BIG-IP certificate
+// when sslVerify is set, against the CA certificates in caCertFile when one is
+// given and the system roots otherwise
+func NewClient(sslVerify bool, caCertFile string) (*BigIPClient, error) {
+	c := DefaultClient()
+	if !sslVerify {
+		return c, nil
+	}
+
+	tlsConfig := &tls.Config{}
+	if "" != caCertFile {
+		pool, err := LoadCACerts(caCertFile)
+		if nil != err {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	c.Client.Transport = &http.Transport{
+		TLSClientConfig: tlsConfig,
+	}
+	return c, nil
+}
+
+// LoadCACerts reads the PEM encoded CA certificates in path
+func LoadCACerts(path string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(path)
+	if nil != err {
+		return nil, fmt.Errorf("ca_cert_file %s is not readable: %v", path, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("ca_cert_file %s holds no PEM certificates", path)
+	}
+	return pool, nil
+}
+
 // Get will attempt a HTTP GET request to the given URL and return a []byte
 // with the response or an error.
 func (c *BigIPClient) Get(url, user, pass string) ([]byte, error) {
@@ -76,3 +125,77 @@ func (c *BigIPClient) Get(url, user, pass string) ([]byte, error) {
 
 	return data, nil
 }
+
+// GetWithToken will attempt a HTTP GET request to the given URL authenticated
+// with an iControl REST auth token and return a []byte with the response or
+// an error.
+func (c *BigIPClient) GetWithToken(url, token string) ([]byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-F5-Auth-Token", token)
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// Login obtains an iControl REST auth token from the login URL of the BIG-IP
+// with the credentials of a user of the login provider
+func (c *BigIPClient) Login(url, user, pass, loginProvider string) (Token, error) {
+	body, err := json.Marshal(map[string]string{
+		"username":          user,
+		"password":          pass,
+		"loginProviderName": loginProvider,
+	})
+	if err != nil {
+		return Token{}, err
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return Token{}, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return Token{}, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return Token{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Token{}, fmt.Errorf("login failed with status %d: %s", resp.StatusCode, data)
+	}
+
+	var login struct {
+		Token struct {
+			Token   string `json:"token"`
+			Timeout int    `json:"timeout"`
+		} `json:"token"`
+	}
+	err = json.Unmarshal(data, &login)
+	if err != nil {
+		return Token{}, err
+	}
+	if login.Token.Token == "" || login.Token.Timeout <= 0 {
+		return Token{}, errors.New("login response is missing the token or its timeout")
+	}
+
+	return Token{
+		Token:   login.Token.Token,
+		Timeout: time.Duration(login.Token.Timeout) * time.Second,
+	}, nil
+}
@@ -23,6 +23,36 @@ type FakeClient struct {
 		result1 []byte
 		result2 error
 	}
+	GetWithTokenStub        func(url, token string) ([]byte, error)
+	getWithTokenMutex       sync.RWMutex
+	getWithTokenArgsForCall []struct {
+		url   string
+		token string
+	}
+	getWithTokenReturns struct {
+		result1 []byte
+		result2 error
+	}
+	getWithTokenReturnsOnCall map[int]struct {
+		result1 []byte
+		result2 error
+	}
+	LoginStub        func(url, user, pass, loginProvider string) (bigipclient.Token, error)
+	loginMutex       sync.RWMutex
+	loginArgsForCall []struct {
+		url           string
+		user          string
+		pass          string
+		loginProvider string
+	}
+	loginReturns struct {
+		result1 bigipclient.Token
+		result2 error
+	}
+	loginReturnsOnCall map[int]struct {
+		result1 bigipclient.Token
+		result2 error
+	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
@@ -80,11 +110,121 @@ func (fake *FakeClient) GetReturnsOnCall(i int, result1 []byte, result2 error) {
 	}{result1, result2}
 }
 
+func (fake *FakeClient) GetWithToken(url string, token string) ([]byte, error) {
+	fake.getWithTokenMutex.Lock()
+	ret, specificReturn := fake.getWithTokenReturnsOnCall[len(fake.getWithTokenArgsForCall)]
+	fake.getWithTokenArgsForCall = append(fake.getWithTokenArgsForCall, struct {
+		url   string
+		token string
+	}{url, token})
+	fake.recordInvocation("GetWithToken", []interface{}{url, token})
+	fake.getWithTokenMutex.Unlock()
+	if fake.GetWithTokenStub != nil {
+		return fake.GetWithTokenStub(url, token)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.getWithTokenReturns.result1, fake.getWithTokenReturns.result2
+}
+
+func (fake *FakeClient) GetWithTokenCallCount() int {
+	fake.getWithTokenMutex.RLock()
+	defer fake.getWithTokenMutex.RUnlock()
+	return len(fake.getWithTokenArgsForCall)
+}
+
+func (fake *FakeClient) GetWithTokenArgsForCall(i int) (string, string) {
+	fake.getWithTokenMutex.RLock()
+	defer fake.getWithTokenMutex.RUnlock()
+	return fake.getWithTokenArgsForCall[i].url, fake.getWithTokenArgsForCall[i].token
+}
+
+func (fake *FakeClient) GetWithTokenReturns(result1 []byte, result2 error) {
+	fake.GetWithTokenStub = nil
+	fake.getWithTokenReturns = struct {
+		result1 []byte
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeClient) GetWithTokenReturnsOnCall(i int, result1 []byte, result2 error) {
+	fake.GetWithTokenStub = nil
+	if fake.getWithTokenReturnsOnCall == nil {
+		fake.getWithTokenReturnsOnCall = make(map[int]struct {
+			result1 []byte
+			result2 error
+		})
+	}
+	fake.getWithTokenReturnsOnCall[i] = struct {
+		result1 []byte
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeClient) Login(url string, user string, pass string, loginProvider string) (bigipclient.Token, error) {
+	fake.loginMutex.Lock()
+	ret, specificReturn := fake.loginReturnsOnCall[len(fake.loginArgsForCall)]
+	fake.loginArgsForCall = append(fake.loginArgsForCall, struct {
+		url           string
+		user          string
+		pass          string
+		loginProvider string
+	}{url, user, pass, loginProvider})
+	fake.recordInvocation("Login", []interface{}{url, user, pass, loginProvider})
+	fake.loginMutex.Unlock()
+	if fake.LoginStub != nil {
+		return fake.LoginStub(url, user, pass, loginProvider)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fake.loginReturns.result1, fake.loginReturns.result2
+}
+
+func (fake *FakeClient) LoginCallCount() int {
+	fake.loginMutex.RLock()
+	defer fake.loginMutex.RUnlock()
+	return len(fake.loginArgsForCall)
+}
+
+func (fake *FakeClient) LoginArgsForCall(i int) (string, string, string, string) {
+	fake.loginMutex.RLock()
+	defer fake.loginMutex.RUnlock()
+	return fake.loginArgsForCall[i].url, fake.loginArgsForCall[i].user, fake.loginArgsForCall[i].pass, fake.loginArgsForCall[i].loginProvider
+}
+
+func (fake *FakeClient) LoginReturns(result1 bigipclient.Token, result2 error) {
+	fake.LoginStub = nil
+	fake.loginReturns = struct {
+		result1 bigipclient.Token
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeClient) LoginReturnsOnCall(i int, result1 bigipclient.Token, result2 error) {
+	fake.LoginStub = nil
+	if fake.loginReturnsOnCall == nil {
+		fake.loginReturnsOnCall = make(map[int]struct {
+			result1 bigipclient.Token
+			result2 error
+		})
+	}
+	fake.loginReturnsOnCall[i] = struct {
+		result1 bigipclient.Token
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *FakeClient) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
 	fake.getMutex.RLock()
 	defer fake.getMutex.RUnlock()
+	fake.getWithTokenMutex.RLock()
+	defer fake.getWithTokenMutex.RUnlock()
+	fake.loginMutex.RLock()
+	defer fake.loginMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
 	for key, value := range fake.invocations {
 		copiedInvocations[key] = value
@@ -92,6 +92,7 @@ type VcapComponent struct {
 	Varz       *health.Varz `json:"-"`
 	Health     http.Handler
 	InfoRoutes map[string]json.Marshaler `json:"-"`
+	Handlers   map[string]http.Handler   `json:"-"`
 	Logger     logger.Logger             `json:"-"`
 
 	listener net.Listener
@@ -236,6 +237,10 @@ func (c *VcapComponent) ListenAndServe(brokerHandler http.Handler) {
 		})
 	}
 
+	for path, handler := range c.Handlers {
+		hs.Handle(path, handler)
+	}
+
 	f := func(user, password string) bool {
 		return user == c.Varz.Credentials[0] && password == c.Varz.Credentials[1]
 	}
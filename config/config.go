@@ -48,6 +48,58 @@ const (
 
 var LoadBalancingStrategies = []string{LOAD_BALANCE_RR, LOAD_BALANCE_LC}
 
+const (
+	STALE_UPDATE_REJECT string = "reject"
+	STALE_UPDATE_ACCEPT string = "accept"
+)
+
+var StaleUpdateActions = []string{STALE_UPDATE_REJECT, STALE_UPDATE_ACCEPT}
+
+const (
+	GUID_CHANGE_REPLACE string = "replace"
+	GUID_CHANGE_KEEP    string = "keep"
+)
+
+var GUIDChangeActions = []string{GUID_CHANGE_REPLACE, GUID_CHANGE_KEEP}
+
+const (
+	PERSISTENCE_NONE        string = "none"
+	PERSISTENCE_COOKIE      string = "cookie"
+	PERSISTENCE_SOURCE_ADDR string = "source_addr"
+)
+
+var PersistenceTypes = []string{PERSISTENCE_NONE, PERSISTENCE_COOKIE, PERSISTENCE_SOURCE_ADDR}
+
+const (
+	MONITOR_HTTP  string = "http"
+	MONITOR_HTTPS string = "https"
+)
+
+var MonitorTypes = []string{MONITOR_HTTP, MONITOR_HTTPS}
+
+const (
+	SNAT_NONE    string = "none"
+	SNAT_AUTOMAP string = "automap"
+	SNAT_POOL    string = "snat"
+)
+
+var SNATTypes = []string{SNAT_NONE, SNAT_AUTOMAP, SNAT_POOL}
+
+const (
+	VLAN_ALL      string = "all"
+	VLAN_ENABLED  string = "enabled"
+	VLAN_DISABLED string = "disabled"
+)
+
+var VLANModes = []string{VLAN_ALL, VLAN_ENABLED, VLAN_DISABLED}
+
+const (
+	DRIVER_EXIT_STOP    string = "stop"
+	DRIVER_EXIT_RESTART string = "restart"
+)
+
+var DriverExitActions = []string{DRIVER_EXIT_STOP, DRIVER_EXIT_RESTART}
+
 // ServiceBrokerConfig configuration parameters
 type ServiceBrokerConfig struct {
 	ID               string
@@ -91,13 +143,322 @@ type BigIPConfig struct {
 	Partitions        []string `yaml:"partition" json:"partitions"`
 	LoadBalancingMode string   `yaml:"load_balancing_mode" json:"-"`
 	VerifyInterval    int      `yaml:"verify_interval" json:"-"`
-	ExternalAddr      string   `yaml:"external_addr" json:"-"`
 	SSLProfiles       []string `yaml:"ssl_profiles" json:"-"`
+	DefaultClientSSL  string   `yaml:"default_client_ssl" json:"-"`
+	DefaultPool       string   `yaml:"default_pool" json:"-"`
 	Policies          []string `yaml:"policies" json:"-"`
 	Profiles          []string `yaml:"profiles" json:"-"`
+	IRules            []string `yaml:"irules" json:"-"`
 	HealthMonitors    []string `yaml:"health_monitors" json:"-"`
 	DriverCmd         string   `yaml:"driver_path" json:"-"`
+	DriverPython      string   `yaml:"driver_python" json:"-"`
 	Tier2IPRange      string   `yaml:"tier2_ip_range" json:"-"`
+	Metadata          []string `yaml:"metadata" json:"-"`
+	RouteWeightTag    string   `yaml:"route_weight_tag" json:"-"`
+	PoolMemberWarning int      `yaml:"pool_member_warning" json:"-"`
+
+	// LoadBalancingModeTag names the route tag holding a load balancing mode
+	// which overrides LoadBalancingMode for the route's pool
+	LoadBalancingModeTag string `yaml:"load_balancing_mode_tag" json:"-"`
+
+	// ExternalAddr addresses of the virtuals, a single address or a list of
+	// them with the virtuals created on each
+	ExternalAddr AddressList `yaml:"external_addr" json:"-"`
+	// FQDNMembers pool members on host names as FQDN nodes
+	FQDNMembers FQDNMembersConfig `yaml:"fqdn_members" json:"-"`
+	// SharedNodes write a node object once for every member address of a
+	// partition with the pool members referencing it by name
+	SharedNodes bool `yaml:"shared_nodes" json:"-"`
+
+	// ExternalMask netmask of the virtuals on the external addresses making
+	// them network virtuals, empty keeps them on the host addresses
+	ExternalMask string `yaml:"external_mask" json:"-"`
+
+	// PriorityGroupTag names the route tag holding the priority group of the
+	// pool member, MinActiveMembers activates the next lower priority group
+	// once fewer members of the higher groups are available
+	PriorityGroupTag string `yaml:"priority_group_tag" json:"-"`
+	MinActiveMembers int    `yaml:"min_active_members" json:"-"`
+
+	HTTPMonitor HTTPMonitorConfig `yaml:"http_monitor" json:"-"`
+	SNIRouting  bool              `yaml:"sni_routing" json:"-"`
+	Persistence PersistenceConfig `yaml:"persistence" json:"-"`
+
+	// HTTPSRedirect redirect HTTP requests of routes to HTTPS
+	HTTPSRedirect HTTPSRedirectConfig `yaml:"https_redirect" json:"-"`
+
+	// ForwardedHeaders insert the X-Forwarded-For and X-Forwarded-Proto
+	// headers on the routing virtuals
+	ForwardedHeaders bool `yaml:"forwarded_headers" json:"-"`
+
+	ConnectionLimit    int    `yaml:"connection_limit" json:"-"`
+	ConnectionLimitTag string `yaml:"connection_limit_tag" json:"-"`
+	MaxConnectionLimit int    `yaml:"max_connection_limit" json:"-"`
+
+	// RateLimit connections per second accepted by the client facing
+	// virtuals, 0 does not limit the connection rate
+	RateLimit int `yaml:"rate_limit" json:"-"`
+	// ConnectionMirroring mirror the connections of the generated virtuals
+	// to the peer device for stateful failover, it has no effect without an
+	// HA pair. ConnectionMirroringTag names the route tag overriding it for
+	// the route
+	ConnectionMirroring    bool   `yaml:"connection_mirroring" json:"-"`
+	ConnectionMirroringTag string `yaml:"connection_mirroring_tag" json:"-"`
+
+	// TCPProfile full path of the TCP profile replacing /Common/tcp on the
+	// generated virtuals
+	TCPProfile string `yaml:"tcp_profile" json:"-"`
+	// IdleTimeout in seconds of the connections to the client facing
+	// virtuals, 0 keeps the idle timeout of the TCP profile
+	IdleTimeout int `yaml:"idle_timeout" json:"-"`
+	// RequestLoggingProfile full path of the request logging profile attached
+	// to the HTTP virtuals, none is attached when empty
+	RequestLoggingProfile string `yaml:"request_logging_profile" json:"-"`
+	// OneConnectProfile full path of the OneConnect profile attached to the
+	// HTTP virtuals to reuse the server side connections, none is attached
+	// when empty
+	OneConnectProfile string `yaml:"oneconnect_profile" json:"-"`
+	// FallbackPool full path of the pool taking the requests of the HTTP
+	// routes whose pool has no available members, such as a pool serving a
+	// sorry page, the routes have no fallback when empty
+	FallbackPool string `yaml:"fallback_pool" json:"-"`
+	// DefaultRoute URI of a managed route whose pool is the default pool of
+	// the routing virtuals while the route has members, replacing
+	// DefaultPool which is used otherwise
+	DefaultRoute string `yaml:"default_route" json:"-"`
+	// HTTPProfile replacing /Common/http on the HTTP virtuals
+	HTTPProfile HTTPProfileConfig `yaml:"http_profile" json:"-"`
+	// RouteFilter hosts of the HTTP routes managed by the controller, the
+	// other routes are ignored so controllers sharing a BIG-IP each manage
+	// their own routes
+	RouteFilter RouteFilterConfig `yaml:"route_filter" json:"-"`
+
+	VirtualServer VirtualServerConfig `yaml:"virtual_server" json:"-"`
+
+	// IncrementalConfig hand writers supporting it only the objects changed
+	// since the last written config, other writers always get the full config
+	IncrementalConfig bool `yaml:"incremental_config" json:"-"`
+
+	// SNAT source address translation of the generated virtual servers
+	SNAT SNATConfig `yaml:"snat" json:"-"`
+
+	// VLANs the client facing virtual servers listen on
+	VLANs VLANConfig `yaml:"vlans" json:"-"`
+
+	// WAF policy attached to the route virtual servers
+	WAF WAFConfig `yaml:"waf" json:"-"`
+
+	// Reencrypt the traffic of the route virtual servers to TLS backends
+	Reencrypt ReencryptConfig `yaml:"reencrypt" json:"-"`
+
+	PartitionTag string `yaml:"partition_tag" json:"-"`
+	// SharedPartition of the monitors shared by the routes, the objects of
+	// the routes are placed in the other partitions. Empty keeps the
+	// monitors with the routes.
+	SharedPartition string `yaml:"shared_partition" json:"-"`
+
+	// DrainTimeout in seconds a removed HTTP route member stays disabled in its
+	// pool before it is removed, 0 removes members right away
+	DrainTimeout int `yaml:"drain_timeout" json:"-"`
+	// ShutdownDrainTimeout in seconds to wait on shutdown after writing a
+	// config with every pool member disabled, 0 stops without draining
+	ShutdownDrainTimeout int `yaml:"shutdown_drain_timeout" json:"-"`
+	// MaxPendingUpdates route updates waiting to be processed before more
+	// updates wait for room, 0 leaves the pending updates unbounded
+	MaxPendingUpdates int `yaml:"max_pending_updates" json:"-"`
+
+	// UserFile and PassFile files holding the basic credentials, UserEnv and
+	// PassEnv names of the environment variables holding them. They are read
+	// at startup when User or Pass is not set inline.
+	UserFile string `yaml:"user_file" json:"-"`
+	PassFile string `yaml:"pass_file" json:"-"`
+	UserEnv  string `yaml:"user_env" json:"-"`
+	PassEnv  string `yaml:"pass_env" json:"-"`
+
+	Token *TokenConfig `yaml:"token" json:"token,omitempty"`
+
+	// SSLVerify verify the certificate of the BIG-IP management interface,
+	// against the CA certificates in CACertFile when set and the system roots
+	// otherwise
+	SSLVerify  bool   `yaml:"ssl_verify" json:"ssl_verify"`
+	CACertFile string `yaml:"ca_cert_file" json:"ca_cert_file,omitempty"`
+}
+
+// AddressList one or more addresses, read from either a single address or a
+// list of them
+type AddressList []string
+
+// UnmarshalYAML reads a single address as well as a list of addresses
+func (al *AddressList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var addr string
+	if err := unmarshal(&addr); nil == err {
+		*al = nil
+		if "" != addr {
+			*al = AddressList{addr}
+		}
+		return nil
+	}
+	var addrs []string
+	if err := unmarshal(&addrs); nil != err {
+		return err
+	}
+	*al = addrs
+	return nil
+}
+
+// TokenConfig iControl REST token authentication, the controller and driver
+// read the token from File. With LoginProvider set the controller obtains the
+// token by logging in with the basic credentials, writes it to File and
+// refreshes it before it expires, the password is then not passed to the
+// driver.
+type TokenConfig struct {
+	File          string `yaml:"file" json:"file"`
+	LoginProvider string `yaml:"login_provider" json:"-"`
+}
+
+// VirtualServerConfig listen address and ports of the HTTP and HTTPS routing
+// virtuals, Address defaults to ExternalAddr. The HTTPS virtual is only
+// created when a client SSL profile is configured.
+type VirtualServerConfig struct {
+	Address   string `yaml:"address"`
+	HTTPPort  uint16 `yaml:"http_port"`
+	HTTPSPort uint16 `yaml:"https_port"`
+}
+
+var defaultVirtualServerConfig = VirtualServerConfig{
+	HTTPPort:  80,
+	HTTPSPort: 443,
+}
+
+// PersistenceConfig session persistence of the route virtuals, Tag names the
+// route tag overriding Type for a route. CookieName and Timeout replace the
+// BIG-IP profile defaults for cookie and source_addr persistence.
+type PersistenceConfig struct {
+	Type       string `yaml:"type"`
+	CookieName string `yaml:"cookie_name"`
+	Timeout    int    `yaml:"timeout"`
+	Tag        string `yaml:"tag"`
+}
+
+var defaultPersistenceConfig = PersistenceConfig{
+	Type: PERSISTENCE_NONE,
+	Tag:  "persistence",
+}
+
+// SNATConfig source address translation of the virtuals, automap translates
+// to the BIG-IP self IPs, snat to the addresses of the SNAT pool named by Pool
+// and none keeps the client address.
+type SNATConfig struct {
+	Type string `yaml:"type"`
+	Pool string `yaml:"pool"`
+}
+
+var defaultSNATConfig = SNATConfig{
+	Type: SNAT_AUTOMAP,
+}
+
+// RouteFilterConfig host glob patterns of the managed HTTP routes, a route
+// is managed when its host matches one of Include, or Include is empty, and
+// none of Exclude. TCP routes have no host and are always managed.
+type RouteFilterConfig struct {
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+}
+
+// VLANConfig VLAN restriction of the client facing virtuals, all listens on
+// every VLAN, enabled only on the VLANs named by Names and disabled on every
+// VLAN but those.
+type VLANConfig struct {
+	Mode  string   `yaml:"mode"`
+	Names []string `yaml:"names"`
+}
+
+var defaultVLANConfig = VLANConfig{
+	Mode: VLAN_ALL,
+}
+
+// WAFConfig BIG-IP LTM policy enabling an ASM (WAF) security policy which is
+// attached to the route virtuals, for every route when Enabled. Tag names the
+// route tag holding the policy of a route, or none to leave the route without
+// one, overriding the configured Policy.
+type WAFConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Policy  string `yaml:"policy"`
+	Tag     string `yaml:"tag"`
+}
+
+var defaultWAFConfig = WAFConfig{
+	Tag: "waf_policy",
+}
+
+// ReencryptConfig re-encrypts the traffic of the route virtuals to TLS
+// backends with the ServerSSL profile when Enabled. Tag names the route tag
+// holding true or false to mark a route's backends as TLS, routes without it
+// are TLS when their backends listen on port 443.
+type ReencryptConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	ServerSSL string `yaml:"server_ssl"`
+	Tag       string `yaml:"tag"`
+}
+
+var defaultReencryptConfig = ReencryptConfig{
+	Tag: "tls",
+}
+
+// HTTPProfileConfig HTTP profile of the HTTP virtuals. Name is the full path
+// of an existing profile, the driver can not create profiles. MaxHeaderSize
+// and MaxHeaderCount are the header limits of that profile, recorded on the
+// routing virtuals when set.
+type HTTPProfileConfig struct {
+	Name           string `yaml:"name"`
+	MaxHeaderSize  int    `yaml:"max_header_size"`
+	MaxHeaderCount int    `yaml:"max_header_count"`
+}
+
+// FQDNMembersConfig pool members on host names become FQDN nodes the BIG-IP
+// resolves and re-resolves when Enabled, Autopopulate adds a member for each
+// address the name resolves to. Members on IP addresses are not changed.
+type FQDNMembersConfig struct {
+	Enabled      bool `yaml:"enabled"`
+	Autopopulate bool `yaml:"autopopulate"`
+}
+
+// HTTPSRedirectConfig 301 redirect of the requests to the HTTP routing virtual
+// to the HTTPS routing virtual, for every route when Enabled. Tag names the
+// route tag holding true or false which overrides Enabled for a route.
+type HTTPSRedirectConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Tag     string `yaml:"tag"`
+}
+
+// HTTPMonitorConfig HTTP health monitor attached to every HTTP route pool, the
+// monitor is only created when a send string is set. ContextPath checks
+// routes with a context path on that path instead of using Send. An https
+// monitor checks the members over TLS with the ServerSSL profile, the
+// endpoint's Tag selects the monitor type of a route. Routes with the
+// PathTag or ExpectTag get their own monitor checking the path and receive
+// string the tags hold, routes with the same values share it.
+type HTTPMonitorConfig struct {
+	Send        string `yaml:"send"`
+	Recv        string `yaml:"recv"`
+	Interval    int    `yaml:"interval"`
+	Timeout     int    `yaml:"timeout"`
+	ContextPath bool   `yaml:"context_path"`
+	Type        string `yaml:"type"`
+	ServerSSL   string `yaml:"server_ssl"`
+	Cipherlist  string `yaml:"cipherlist"`
+	Tag         string `yaml:"tag"`
+	PathTag     string `yaml:"path_tag"`
+	ExpectTag   string `yaml:"expect_tag"`
+}
+
+var defaultHTTPMonitorConfig = HTTPMonitorConfig{
+	Interval: 5,
+	Timeout:  16,
+	Type:     MONITOR_HTTP,
+
+	PathTag:   "healthcheck-path",
+	ExpectTag: "healthcheck-expect",
 }
 
 var defaultBigIPConfig = BigIPConfig{
@@ -107,12 +468,37 @@ var defaultBigIPConfig = BigIPConfig{
 	Partitions:        []string{},
 	LoadBalancingMode: LoadBalancingStrategies[0],
 	VerifyInterval:    30,
-	ExternalAddr:      "",
+	ExternalAddr:      AddressList{},
 	SSLProfiles:       []string{},
+	DefaultClientSSL:  "",
+	DefaultPool:       "",
 	Policies:          []string{},
 	Profiles:          []string{},
+	IRules:            []string{},
 	DriverCmd:         "",
 	Tier2IPRange:      DefaultTier2IPRange,
+	Metadata:          []string{},
+	RouteWeightTag:    "weight",
+	PriorityGroupTag:  "priority_group",
+
+	LoadBalancingModeTag: "f5.loadBalancingMode",
+
+	HTTPMonitor: defaultHTTPMonitorConfig,
+	Persistence: defaultPersistenceConfig,
+
+	ConnectionLimitTag: "connection_limit",
+
+	ConnectionMirroringTag: "connection_mirroring",
+
+	VirtualServer: defaultVirtualServerConfig,
+	SNAT:          defaultSNATConfig,
+	VLANs:         defaultVLANConfig,
+	WAF:           defaultWAFConfig,
+	Reencrypt:     defaultReencryptConfig,
+
+	PartitionTag: "partition",
+
+	SSLVerify: true,
 }
 
 var defaultStatusConfig = StatusConfig{
@@ -170,31 +556,192 @@ type Tracing struct {
 	EnableZipkin bool `yaml:"enable_zipkin"`
 }
 
+// FileSDConfig configuration for writing the route members as Prometheus
+// file based service discovery targets, disabled when the path is empty
+type FileSDConfig struct {
+	Path     string        `yaml:"path"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+var defaultFileSDConfig = FileSDConfig{
+	Path:     "",
+	Interval: 30 * time.Second,
+}
+
+// DryRunConfig configuration for generating the config without handing it to
+// the driver, the config is logged and also written to the file when set
+type DryRunConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	File    string `yaml:"file"`
+}
+
+// RouteEventsConfig configuration for publishing a JSON event for every route
+// member added or removed, the events are appended to the file when set and
+// logged otherwise
+type RouteEventsConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	File    string `yaml:"file"`
+}
+
+// HTTPWriterConfig posts the config to URL for a driver reading it from an
+// HTTP source instead of the config file, with the Headers such as for
+// authorization. A failed post is retried up to Retries times with a backoff
+// doubling from Backoff up to MaxBackoff.
+type HTTPWriterConfig struct {
+	URL        string            `yaml:"url"`
+	Headers    map[string]string `yaml:"headers"`
+	Retries    int               `yaml:"retries"`
+	Backoff    time.Duration     `yaml:"backoff"`
+	MaxBackoff time.Duration     `yaml:"max_backoff"`
+}
+
+var defaultHTTPWriterConfig = HTTPWriterConfig{
+	Retries:    3,
+	Backoff:    1 * time.Second,
+	MaxBackoff: 10 * time.Second,
+}
+
+// DriverStartupConfig retries starting the config driver when it exits before
+// it has run for the stable period, such as when the BIG-IP is briefly
+// unreachable, the backoff doubles after each failed start up to the max
+type DriverStartupConfig struct {
+	Retries      int           `yaml:"retries"`
+	Backoff      time.Duration `yaml:"backoff"`
+	MaxBackoff   time.Duration `yaml:"max_backoff"`
+	StablePeriod time.Duration `yaml:"stable_period"`
+}
+
+var defaultDriverStartupConfig = DriverStartupConfig{
+	Retries:      0,
+	Backoff:      1 * time.Second,
+	MaxBackoff:   30 * time.Second,
+	StablePeriod: 5 * time.Second,
+}
+
+// DriverRestartConfig relaunches the config driver when it exits with a
+// failing status after it started, instead of exiting the controller. The
+// backoff doubles after each restart up to the max, the restarts are counted
+// again once the driver runs for the driver startup stable period.
+// OnCleanExit restarts a driver exiting with status 0 as well when set to
+// restart, those restarts are not limited by MaxRestarts.
+type DriverRestartConfig struct {
+	MaxRestarts int           `yaml:"max_restarts"`
+	Backoff     time.Duration `yaml:"backoff"`
+	MaxBackoff  time.Duration `yaml:"max_backoff"`
+	OnCleanExit string        `yaml:"on_clean_exit"`
+}
+
+var defaultDriverRestartConfig = DriverRestartConfig{
+	MaxRestarts: 0,
+	Backoff:     1 * time.Second,
+	MaxBackoff:  30 * time.Second,
+	OnCleanExit: DRIVER_EXIT_STOP,
+}
+
+// InitialWriteConfig retries writing the initial config when the writer fails
+// at startup, the backoff doubles after each failed write up to the max. The
+// controller exits once the retries are used up.
+type InitialWriteConfig struct {
+	Retries    int           `yaml:"retries"`
+	Backoff    time.Duration `yaml:"backoff"`
+	MaxBackoff time.Duration `yaml:"max_backoff"`
+}
+
+var defaultInitialWriteConfig = InitialWriteConfig{
+	Retries:    3,
+	Backoff:    1 * time.Second,
+	MaxBackoff: 10 * time.Second,
+}
+
+// ApplyCommandConfig runs a command after each config write, such as tooling
+// which applies the config in place of the config driver. The {{.ConfigFile}}
+// template in the command is replaced with the path of the config file.
+type ApplyCommandConfig struct {
+	Cmd           string        `yaml:"cmd"`
+	ReplaceDriver bool          `yaml:"replace_driver"`
+	Timeout       time.Duration `yaml:"timeout"`
+}
+
+var defaultApplyCommandConfig = ApplyCommandConfig{
+	Cmd:           "",
+	ReplaceDriver: false,
+	Timeout:       60 * time.Second,
+}
+
+// EndpointFilterConfig selects the endpoints which become pool members by
+// their tags, an endpoint needs one of the allowed values of every allow tag
+// and none of the denied values of any deny tag
+type EndpointFilterConfig struct {
+	Allow map[string][]string `yaml:"allow"`
+	Deny  map[string][]string `yaml:"deny"`
+}
+
+var defaultEndpointFilterConfig = EndpointFilterConfig{
+	Allow: map[string][]string{},
+	Deny:  map[string][]string{},
+}
+
+// Matches returns true if the tags pass the filter
+func (f EndpointFilterConfig) Matches(tags map[string]string) bool {
+	for tag, values := range f.Allow {
+		value, ok := tags[tag]
+		if !ok || !containsString(values, value) {
+			return false
+		}
+	}
+	for tag, values := range f.Deny {
+		value, ok := tags[tag]
+		if ok && containsString(values, value) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
 var defaultLoggingConfig = LoggingConfig{
 	Level:         "info",
 	MetronAddress: "localhost:3457",
 }
 
 type Config struct {
-	BigIP                    BigIPConfig         `yaml:"bigip"`
-	Status                   StatusConfig        `yaml:"status"`
-	Broker                   ServiceBrokerConfig `yaml:"broker"`
-	Nats                     []NatsConfig        `yaml:"nats"`
-	Logging                  LoggingConfig       `yaml:"logging"`
-	Port                     uint16              `yaml:"port"`
-	Index                    uint                `yaml:"index"`
-	Zone                     string              `yaml:"zone"`
-	GoMaxProcs               int                 `yaml:"go_max_procs,omitempty"`
-	Tracing                  Tracing             `yaml:"tracing"`
-	TraceKey                 string              `yaml:"trace_key"`
-	AccessLog                AccessLog           `yaml:"access_log"`
-	EnableAccessLogStreaming bool                `yaml:"enable_access_log_streaming"`
-	DebugAddr                string              `yaml:"debug_addr"`
-	EnablePROXY              bool                `yaml:"enable_proxy"`
-	EnableSSL                bool                `yaml:"enable_ssl"`
-	SSLPort                  uint16              `yaml:"ssl_port"`
-	SSLCertPath              string              `yaml:"ssl_cert_path"`
-	SSLKeyPath               string              `yaml:"ssl_key_path"`
+	BigIP                    BigIPConfig          `yaml:"bigip"`
+	Status                   StatusConfig         `yaml:"status"`
+	Broker                   ServiceBrokerConfig  `yaml:"broker"`
+	Nats                     []NatsConfig         `yaml:"nats"`
+	Logging                  LoggingConfig        `yaml:"logging"`
+	Port                     uint16               `yaml:"port"`
+	Index                    uint                 `yaml:"index"`
+	Zone                     string               `yaml:"zone"`
+	GoMaxProcs               int                  `yaml:"go_max_procs,omitempty"`
+	Tracing                  Tracing              `yaml:"tracing"`
+	FileSD                   FileSDConfig         `yaml:"file_sd"`
+	DryRun                   DryRunConfig         `yaml:"dry_run"`
+	RouteEvents              RouteEventsConfig    `yaml:"route_events"`
+	HTTPWriter               HTTPWriterConfig     `yaml:"http_writer"`
+	EndpointFilter           EndpointFilterConfig `yaml:"endpoint_filter"`
+	DriverStartup            DriverStartupConfig  `yaml:"driver_startup"`
+	DriverRestart            DriverRestartConfig  `yaml:"driver_restart"`
+	InitialWrite             InitialWriteConfig   `yaml:"initial_write"`
+	ApplyCommand             ApplyCommandConfig   `yaml:"apply_command"`
+	TraceKey                 string               `yaml:"trace_key"`
+	AccessLog                AccessLog            `yaml:"access_log"`
+	EnableAccessLogStreaming bool                 `yaml:"enable_access_log_streaming"`
+	DebugAddr                string               `yaml:"debug_addr"`
+	MetricsAddr              string               `yaml:"metrics_addr"`
+	EnablePROXY              bool                 `yaml:"enable_proxy"`
+	EnableSSL                bool                 `yaml:"enable_ssl"`
+	SSLPort                  uint16               `yaml:"ssl_port"`
+	SSLCertPath              string               `yaml:"ssl_cert_path"`
+	SSLKeyPath               string               `yaml:"ssl_key_path"`
 	SSLCertificate           tls.Certificate
 	SkipSSLValidation        bool `yaml:"skip_ssl_validation"`
 	ForceForwardedProtoHttps bool `yaml:"force_forwarded_proto_https"`
@@ -206,6 +753,7 @@ type Config struct {
 	PublishStartMessageInterval     time.Duration `yaml:"publish_start_message_interval"`
 	SuspendPruningIfNatsUnavailable bool          `yaml:"suspend_pruning_if_nats_unavailable"`
 	PruneStaleDropletsInterval      time.Duration `yaml:"prune_stale_droplets_interval"`
+	MinFetchRoutesInterval          time.Duration `yaml:"min_fetch_routes_interval"`
 	DropletStaleThreshold           time.Duration `yaml:"droplet_stale_threshold"`
 	PublishActiveAppsInterval       time.Duration `yaml:"publish_active_apps_interval"`
 	StartResponseDelayInterval      time.Duration `yaml:"start_response_delay_interval"`
@@ -213,6 +761,8 @@ type Config struct {
 	RouteServiceTimeout             time.Duration `yaml:"route_services_timeout"`
 	RouteMode                       string        `yaml:"route_mode"`
 	BrokerMode                      bool          `yaml:"broker_mode"`
+	EnableConfigEndpoint            bool          `yaml:"enable_config_endpoint"`
+	EnableStateEndpoint             bool          `yaml:"enable_state_endpoint"`
 	RoutingMode                     RoutingMode
 
 	DrainWait          time.Duration `yaml:"drain_wait,omitempty"`
@@ -240,6 +790,9 @@ type Config struct {
 	PidFile     string `yaml:"pid_file"`
 	LoadBalance string `yaml:"balancing_algorithm"`
 
+	StaleUpdateAction string `yaml:"stale_update_action"`
+	GUIDChangeAction  string `yaml:"guid_change_action"`
+
 	SessionPersistence bool `yaml:"session_persistence"`
 
 	DisableKeepAlives   bool `yaml:"disable_keep_alives"`
@@ -253,6 +806,14 @@ var defaultConfig = Config{
 	Status:  defaultStatusConfig,
 	Nats:    []NatsConfig{defaultNatsConfig},
 	Logging: defaultLoggingConfig,
+	FileSD:  defaultFileSDConfig,
+
+	EndpointFilter: defaultEndpointFilterConfig,
+	HTTPWriter:     defaultHTTPWriterConfig,
+	DriverStartup:  defaultDriverStartupConfig,
+	DriverRestart:  defaultDriverRestartConfig,
+	InitialWrite:   defaultInitialWriteConfig,
+	ApplyCommand:   defaultApplyCommandConfig,
 
 	Port:        8081,
 	Index:       0,
@@ -266,17 +827,23 @@ var defaultConfig = Config{
 
 	PublishStartMessageInterval:               30 * time.Second,
 	PruneStaleDropletsInterval:                30 * time.Second,
+	MinFetchRoutesInterval:                    0 * time.Second,
 	DropletStaleThreshold:                     120 * time.Second,
 	PublishActiveAppsInterval:                 0 * time.Second,
 	StartResponseDelayInterval:                5 * time.Second,
 	TokenFetcherMaxRetries:                    3,
 	TokenFetcherRetryInterval:                 5 * time.Second,
 	TokenFetcherExpirationBufferTimeInSeconds: 30,
-	RouteMode:  HTTP.String(),
-	BrokerMode: false,
+	RouteMode:            HTTP.String(),
+	BrokerMode:           false,
+	EnableConfigEndpoint: false,
+	EnableStateEndpoint:  false,
 
 	LoadBalance: LOAD_BALANCE_RR,
 
+	StaleUpdateAction: STALE_UPDATE_REJECT,
+	GUIDChangeAction:  GUID_CHANGE_REPLACE,
+
 	SessionPersistence: true,
 
 	DisableKeepAlives:   true,
@@ -345,6 +912,30 @@ func (c *Config) Process() {
 		panic(errMsg)
 	}
 
+	validStaleAction := false
+	for _, action := range StaleUpdateActions {
+		if c.StaleUpdateAction == action {
+			validStaleAction = true
+			break
+		}
+	}
+	if !validStaleAction {
+		errMsg := fmt.Sprintf("Invalid stale update action %s. Allowed values are %s", c.StaleUpdateAction, StaleUpdateActions)
+		panic(errMsg)
+	}
+
+	validGUIDAction := false
+	for _, action := range GUIDChangeActions {
+		if c.GUIDChangeAction == action {
+			validGUIDAction = true
+			break
+		}
+	}
+	if !validGUIDAction {
+		errMsg := fmt.Sprintf("Invalid guid change action %s. Allowed values are %s", c.GUIDChangeAction, GUIDChangeActions)
+		panic(errMsg)
+	}
+
 	if c.RouterGroupName != "" && !c.RoutingApiEnabled() {
 		errMsg := fmt.Sprintf("Routing API must be enabled to assign Router Group")
 		panic(errMsg)
@@ -379,6 +970,67 @@ func (c *Config) Process() {
 	if c.BrokerMode && (c.Status.User == "" || c.Status.Pass == "") {
 		panic("status user and pass must be set to run in service_broker mode")
 	}
+
+	if c.FileSD.Path != "" && c.FileSD.Interval <= 0 {
+		panic("file_sd interval must be greater than 0")
+	}
+
+	if c.HTTPWriter.URL != "" {
+		u, err := url.Parse(c.HTTPWriter.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			panic("http_writer url must be an http or https URL")
+		}
+		if c.HTTPWriter.Retries < 0 {
+			panic("http_writer retries must not be negative")
+		}
+		if c.HTTPWriter.Retries > 0 && (c.HTTPWriter.Backoff <= 0 ||
+			c.HTTPWriter.MaxBackoff <= 0) {
+			panic("http_writer backoff and max_backoff must be greater than 0")
+		}
+	}
+
+	if c.DriverStartup.Retries < 0 {
+		panic("driver_startup retries must not be negative")
+	}
+	if c.DriverStartup.Retries > 0 && (c.DriverStartup.Backoff <= 0 ||
+		c.DriverStartup.MaxBackoff <= 0 || c.DriverStartup.StablePeriod <= 0) {
+		panic("driver_startup backoff, max_backoff and stable_period must be greater than 0")
+	}
+
+	if c.DriverRestart.MaxRestarts < 0 {
+		panic("driver_restart max_restarts must not be negative")
+	}
+	validDriverExitAction := false
+	for _, action := range DriverExitActions {
+		if c.DriverRestart.OnCleanExit == action {
+			validDriverExitAction = true
+			break
+		}
+	}
+	if !validDriverExitAction {
+		errMsg := fmt.Sprintf("Invalid driver_restart on_clean_exit %s. Allowed values are %s",
+			c.DriverRestart.OnCleanExit, DriverExitActions)
+		panic(errMsg)
+	}
+	restarts := c.DriverRestart.MaxRestarts > 0 || DRIVER_EXIT_RESTART == c.DriverRestart.OnCleanExit
+	if restarts && (c.DriverRestart.Backoff <= 0 || c.DriverRestart.MaxBackoff <= 0) {
+		panic("driver_restart backoff and max_backoff must be greater than 0")
+	}
+
+	if c.InitialWrite.Retries < 0 {
+		panic("initial_write retries must not be negative")
+	}
+	if c.InitialWrite.Retries > 0 && (c.InitialWrite.Backoff <= 0 ||
+		c.InitialWrite.MaxBackoff <= 0) {
+		panic("initial_write backoff and max_backoff must be greater than 0")
+	}
+
+	if c.ApplyCommand.ReplaceDriver && c.ApplyCommand.Cmd == "" {
+		panic("apply_command replace_driver requires a cmd")
+	}
+	if c.ApplyCommand.Cmd != "" && c.ApplyCommand.Timeout <= 0 {
+		panic("apply_command timeout must be greater than 0")
+	}
 }
 
 func (c *Config) processCipherSuites() []uint16 {
@@ -48,6 +48,782 @@ balancing_algorithm: foo-bar
 			})
 		})
 
+		Context("route weight config", func() {
+			It("reads route weights from the weight tag by default", func() {
+				Expect(config.BigIP.RouteWeightTag).To(Equal("weight"))
+			})
+
+			It("can override the route weight tag", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`
+bigip:
+  route_weight_tag: traffic_weight
+`)
+				cfg.Initialize(b)
+				cfg.Process()
+				Expect(cfg.BigIP.RouteWeightTag).To(Equal("traffic_weight"))
+			})
+		})
+
+		Context("route load balancing mode config", func() {
+			It("reads route load balancing modes from the f5.loadBalancingMode tag by default", func() {
+				Expect(config.BigIP.LoadBalancingModeTag).To(Equal("f5.loadBalancingMode"))
+			})
+
+			It("can override the load balancing mode tag", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`
+bigip:
+  load_balancing_mode_tag: lb_mode
+`)
+				cfg.Initialize(b)
+				cfg.Process()
+				Expect(cfg.BigIP.LoadBalancingModeTag).To(Equal("lb_mode"))
+			})
+		})
+
+		Context("shared partition config", func() {
+			It("keeps the monitors with the routes by default", func() {
+				Expect(config.BigIP.SharedPartition).To(Equal(""))
+			})
+
+			It("sets the shared partition", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`
+bigip:
+  partition:
+  - cf
+  - cf-shared
+  shared_partition: cf-shared
+`)
+				cfg.Initialize(b)
+				cfg.Process()
+				Expect(cfg.BigIP.SharedPartition).To(Equal("cf-shared"))
+			})
+		})
+
+		Context("external address config", func() {
+			It("reads a single external address", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`
+bigip:
+  external_addr: 10.1.1.10
+`)
+				cfg.Initialize(b)
+				cfg.Process()
+				Expect(cfg.BigIP.ExternalAddr).To(Equal(AddressList{"10.1.1.10"}))
+			})
+
+			It("reads a list of external addresses", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`
+bigip:
+  external_addr:
+  - 10.1.1.10
+  - 10.1.1.11
+`)
+				cfg.Initialize(b)
+				cfg.Process()
+				Expect(cfg.BigIP.ExternalAddr).To(Equal(AddressList{"10.1.1.10", "10.1.1.11"}))
+			})
+
+			It("reads the external mask", func() {
+				Expect(config.BigIP.ExternalMask).To(BeEmpty())
+
+				cfg := DefaultConfig()
+				var b = []byte(`
+bigip:
+  external_addr: 10.1.0.0
+  external_mask: 255.255.0.0
+`)
+				cfg.Initialize(b)
+				cfg.Process()
+				Expect(cfg.BigIP.ExternalMask).To(Equal("255.255.0.0"))
+			})
+
+			It("rejects an external address that is neither a string nor a list", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`
+bigip:
+  external_addr:
+    address: 10.1.1.10
+`)
+				Expect(cfg.Initialize(b)).NotTo(Succeed())
+			})
+		})
+
+		Context("shutdown drain config", func() {
+			It("does not drain on shutdown by default", func() {
+				Expect(config.BigIP.ShutdownDrainTimeout).To(Equal(0))
+			})
+
+			It("sets the shutdown drain timeout", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`
+bigip:
+  shutdown_drain_timeout: 30
+`)
+				cfg.Initialize(b)
+				cfg.Process()
+				Expect(cfg.BigIP.ShutdownDrainTimeout).To(Equal(30))
+			})
+		})
+
+		Context("request logging profile config", func() {
+			It("does not attach a request logging profile by default", func() {
+				Expect(config.BigIP.RequestLoggingProfile).To(Equal(""))
+			})
+
+			It("sets the request logging profile", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`
+bigip:
+  request_logging_profile: /Common/cf-request-log
+`)
+				cfg.Initialize(b)
+				cfg.Process()
+				Expect(cfg.BigIP.RequestLoggingProfile).To(Equal("/Common/cf-request-log"))
+			})
+		})
+
+		Context("oneconnect profile config", func() {
+			It("does not attach a OneConnect profile by default", func() {
+				Expect(config.BigIP.OneConnectProfile).To(Equal(""))
+			})
+
+			It("sets the OneConnect profile", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`
+bigip:
+  oneconnect_profile: /Common/oneconnect
+`)
+				cfg.Initialize(b)
+				cfg.Process()
+				Expect(cfg.BigIP.OneConnectProfile).To(Equal("/Common/oneconnect"))
+			})
+		})
+
+		Context("route filter config", func() {
+			It("manages every route by default", func() {
+				Expect(config.BigIP.RouteFilter.Include).To(BeEmpty())
+				Expect(config.BigIP.RouteFilter.Exclude).To(BeEmpty())
+			})
+
+			It("sets the route filter", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`
+bigip:
+  route_filter:
+    include:
+    - "*.apps.example.com"
+    exclude:
+    - "internal.apps.example.com"
+`)
+				cfg.Initialize(b)
+				cfg.Process()
+				Expect(cfg.BigIP.RouteFilter.Include).To(Equal([]string{"*.apps.example.com"}))
+				Expect(cfg.BigIP.RouteFilter.Exclude).To(Equal([]string{"internal.apps.example.com"}))
+			})
+		})
+
+		Context("default route config", func() {
+			It("does not use a route as the default pool by default", func() {
+				Expect(config.BigIP.DefaultRoute).To(Equal(""))
+			})
+
+			It("sets the default route", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`
+bigip:
+  default_route: catch-all.cf.com
+`)
+				cfg.Initialize(b)
+				cfg.Process()
+				Expect(cfg.BigIP.DefaultRoute).To(Equal("catch-all.cf.com"))
+			})
+		})
+
+		Context("fqdn members config", func() {
+			It("writes address members by default", func() {
+				Expect(config.BigIP.FQDNMembers).To(Equal(FQDNMembersConfig{}))
+			})
+
+			It("sets the FQDN members", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`
+bigip:
+  fqdn_members:
+    enabled: true
+    autopopulate: true
+`)
+				cfg.Initialize(b)
+				cfg.Process()
+				Expect(cfg.BigIP.FQDNMembers).To(Equal(FQDNMembersConfig{
+					Enabled:      true,
+					Autopopulate: true,
+				}))
+			})
+		})
+
+		Context("shared nodes config", func() {
+			It("writes the member addresses by default", func() {
+				Expect(config.BigIP.SharedNodes).To(BeFalse())
+			})
+
+			It("sets the shared nodes", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`
+bigip:
+  shared_nodes: true
+`)
+				cfg.Initialize(b)
+				cfg.Process()
+				Expect(cfg.BigIP.SharedNodes).To(BeTrue())
+			})
+		})
+
+		Context("http profile config", func() {
+			It("keeps /Common/http by default", func() {
+				Expect(config.BigIP.HTTPProfile).To(Equal(HTTPProfileConfig{}))
+			})
+
+			It("sets the HTTP profile and header limits", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`
+bigip:
+  http_profile:
+    name: /Common/cf-http-large-headers
+    max_header_size: 65536
+    max_header_count: 128
+`)
+				cfg.Initialize(b)
+				cfg.Process()
+				Expect(cfg.BigIP.HTTPProfile).To(Equal(HTTPProfileConfig{
+					Name:           "/Common/cf-http-large-headers",
+					MaxHeaderSize:  65536,
+					MaxHeaderCount: 128,
+				}))
+			})
+		})
+
+		Context("reencrypt config", func() {
+			It("does not re-encrypt by default", func() {
+				Expect(config.BigIP.Reencrypt.Enabled).To(BeFalse())
+				Expect(config.BigIP.Reencrypt.ServerSSL).To(Equal(""))
+				Expect(config.BigIP.Reencrypt.Tag).To(Equal("tls"))
+			})
+
+			It("sets the server SSL profile", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`
+bigip:
+  reencrypt:
+    enabled: true
+    server_ssl: /Common/serverssl
+`)
+				cfg.Initialize(b)
+				cfg.Process()
+				Expect(cfg.BigIP.Reencrypt).To(Equal(ReencryptConfig{
+					Enabled:   true,
+					ServerSSL: "/Common/serverssl",
+					Tag:       "tls",
+				}))
+			})
+		})
+
+		Context("waf config", func() {
+			It("does not attach a waf policy by default", func() {
+				Expect(config.BigIP.WAF.Enabled).To(BeFalse())
+				Expect(config.BigIP.WAF.Policy).To(Equal(""))
+				Expect(config.BigIP.WAF.Tag).To(Equal("waf_policy"))
+			})
+
+			It("sets the waf policy", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`
+bigip:
+  waf:
+    enabled: true
+    policy: /Common/waf
+    tag: waf
+`)
+				cfg.Initialize(b)
+				cfg.Process()
+				Expect(cfg.BigIP.WAF).To(Equal(WAFConfig{
+					Enabled: true,
+					Policy:  "/Common/waf",
+					Tag:     "waf",
+				}))
+			})
+		})
+
+		Context("priority group config", func() {
+			It("reads priority groups from the priority_group tag by default", func() {
+				Expect(config.BigIP.PriorityGroupTag).To(Equal("priority_group"))
+				Expect(config.BigIP.MinActiveMembers).To(Equal(0))
+			})
+
+			It("sets the priority group tag and min active members", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`
+bigip:
+  priority_group_tag: priority
+  min_active_members: 2
+`)
+				cfg.Initialize(b)
+				cfg.Process()
+				Expect(cfg.BigIP.PriorityGroupTag).To(Equal("priority"))
+				Expect(cfg.BigIP.MinActiveMembers).To(Equal(2))
+			})
+		})
+
+		Context("connection limit config", func() {
+			It("does not limit connections by default", func() {
+				Expect(config.BigIP.ConnectionLimit).To(Equal(0))
+				Expect(config.BigIP.MaxConnectionLimit).To(Equal(0))
+				Expect(config.BigIP.ConnectionLimitTag).To(Equal("connection_limit"))
+			})
+
+			It("sets the connection limits", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`
+bigip:
+  connection_limit: 100
+  connection_limit_tag: max_conns
+  max_connection_limit: 500
+`)
+				cfg.Initialize(b)
+				cfg.Process()
+				Expect(cfg.BigIP.ConnectionLimit).To(Equal(100))
+				Expect(cfg.BigIP.ConnectionLimitTag).To(Equal("max_conns"))
+				Expect(cfg.BigIP.MaxConnectionLimit).To(Equal(500))
+			})
+
+			It("does not limit the connection rate by default", func() {
+				Expect(config.BigIP.RateLimit).To(Equal(0))
+			})
+
+			It("sets the rate limit", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`
+bigip:
+  rate_limit: 1000
+`)
+				cfg.Initialize(b)
+				cfg.Process()
+				Expect(cfg.BigIP.RateLimit).To(Equal(1000))
+			})
+
+			It("does not mirror connections by default", func() {
+				Expect(config.BigIP.ConnectionMirroring).To(BeFalse())
+				Expect(config.BigIP.ConnectionMirroringTag).To(Equal("connection_mirroring"))
+			})
+
+			It("sets the connection mirroring", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`
+bigip:
+  connection_mirroring: true
+  connection_mirroring_tag: mirror
+`)
+				cfg.Initialize(b)
+				cfg.Process()
+				Expect(cfg.BigIP.ConnectionMirroring).To(BeTrue())
+				Expect(cfg.BigIP.ConnectionMirroringTag).To(Equal("mirror"))
+			})
+
+			It("uses SNAT automap by default", func() {
+				Expect(config.BigIP.SNAT.Type).To(Equal(SNAT_AUTOMAP))
+				Expect(config.BigIP.SNAT.Pool).To(BeEmpty())
+			})
+
+			It("sets the SNAT pool", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`
+bigip:
+  snat:
+    type: snat
+    pool: /Common/cf-snatpool
+`)
+				cfg.Initialize(b)
+				cfg.Process()
+				Expect(cfg.BigIP.SNAT.Type).To(Equal(SNAT_POOL))
+				Expect(cfg.BigIP.SNAT.Pool).To(Equal("/Common/cf-snatpool"))
+			})
+
+			It("listens on all VLANs by default", func() {
+				Expect(config.BigIP.VLANs.Mode).To(Equal(VLAN_ALL))
+				Expect(config.BigIP.VLANs.Names).To(BeEmpty())
+			})
+
+			It("sets the VLAN restriction", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`
+bigip:
+  vlans:
+    mode: enabled
+    names:
+    - /Common/external
+    - /Common/dmz
+`)
+				cfg.Initialize(b)
+				cfg.Process()
+				Expect(cfg.BigIP.VLANs.Mode).To(Equal(VLAN_ENABLED))
+				Expect(cfg.BigIP.VLANs.Names).To(Equal([]string{"/Common/external", "/Common/dmz"}))
+			})
+
+			It("keeps the TCP profile idle timeout by default", func() {
+				Expect(config.BigIP.TCPProfile).To(BeEmpty())
+				Expect(config.BigIP.IdleTimeout).To(Equal(0))
+			})
+
+			It("sets the TCP profile and idle timeout", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`
+bigip:
+  tcp_profile: /Common/cf-tcp
+  idle_timeout: 3600
+`)
+				cfg.Initialize(b)
+				cfg.Process()
+				Expect(cfg.BigIP.TCPProfile).To(Equal("/Common/cf-tcp"))
+				Expect(cfg.BigIP.IdleTimeout).To(Equal(3600))
+			})
+		})
+
+		Context("token config", func() {
+			It("uses basic auth by default", func() {
+				Expect(config.BigIP.Token).To(BeNil())
+			})
+
+			It("sets the token provider", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`
+bigip:
+  token:
+    file: /var/vcap/data/cf-bigip-ctlr/token
+    login_provider: tmos
+`)
+				cfg.Initialize(b)
+				cfg.Process()
+				Expect(cfg.BigIP.Token).To(Equal(&TokenConfig{
+					File:          "/var/vcap/data/cf-bigip-ctlr/token",
+					LoginProvider: "tmos",
+				}))
+			})
+		})
+
+		Context("credential sources config", func() {
+			It("sets the credential files and environment variables", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`
+bigip:
+  user_file: /var/vcap/jobs/cf-bigip-ctlr/config/bigip-user
+  pass_file: /var/vcap/jobs/cf-bigip-ctlr/config/bigip-pass
+  user_env: BIGIP_USER
+  pass_env: BIGIP_PASS
+`)
+				cfg.Initialize(b)
+				cfg.Process()
+				Expect(cfg.BigIP.UserFile).To(Equal("/var/vcap/jobs/cf-bigip-ctlr/config/bigip-user"))
+				Expect(cfg.BigIP.PassFile).To(Equal("/var/vcap/jobs/cf-bigip-ctlr/config/bigip-pass"))
+				Expect(cfg.BigIP.UserEnv).To(Equal("BIGIP_USER"))
+				Expect(cfg.BigIP.PassEnv).To(Equal("BIGIP_PASS"))
+			})
+		})
+
+		Context("drain timeout config", func() {
+			It("removes members right away by default", func() {
+				Expect(config.BigIP.DrainTimeout).To(Equal(0))
+			})
+
+			It("sets the drain timeout", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`
+bigip:
+  drain_timeout: 30
+`)
+				cfg.Initialize(b)
+				cfg.Process()
+				Expect(cfg.BigIP.DrainTimeout).To(Equal(30))
+			})
+		})
+
+		Context("driver python config", func() {
+			It("runs the driver directly by default", func() {
+				Expect(config.BigIP.DriverPython).To(BeEmpty())
+			})
+
+			It("sets the driver interpreter", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`
+bigip:
+  driver_python: /usr/bin/python3
+`)
+				cfg.Initialize(b)
+				cfg.Process()
+				Expect(cfg.BigIP.DriverPython).To(Equal("/usr/bin/python3"))
+			})
+		})
+
+		Context("ssl verify config", func() {
+			It("verifies the BIG-IP certificate by default", func() {
+				Expect(config.BigIP.SSLVerify).To(BeTrue())
+				Expect(config.BigIP.CACertFile).To(BeEmpty())
+			})
+
+			It("sets the verification options", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`
+bigip:
+  ssl_verify: false
+  ca_cert_file: /var/vcap/jobs/cf-bigip-ctlr/config/bigip-ca.pem
+`)
+				cfg.Initialize(b)
+				cfg.Process()
+				Expect(cfg.BigIP.SSLVerify).To(BeFalse())
+				Expect(cfg.BigIP.CACertFile).To(Equal("/var/vcap/jobs/cf-bigip-ctlr/config/bigip-ca.pem"))
+			})
+		})
+
+		Context("partition config", func() {
+			It("reads route partitions from the partition tag by default", func() {
+				Expect(config.BigIP.PartitionTag).To(Equal("partition"))
+			})
+
+			It("sets the partition tag", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`
+bigip:
+  partition: [cf, cf-apps]
+  partition_tag: bigip_partition
+`)
+				cfg.Initialize(b)
+				cfg.Process()
+				Expect(cfg.BigIP.Partitions).To(Equal([]string{"cf", "cf-apps"}))
+				Expect(cfg.BigIP.PartitionTag).To(Equal("bigip_partition"))
+			})
+		})
+
+		Context("virtual server config", func() {
+			It("listens on ports 80 and 443 by default", func() {
+				Expect(config.BigIP.VirtualServer.Address).To(Equal(""))
+				Expect(config.BigIP.VirtualServer.HTTPPort).To(Equal(uint16(80)))
+				Expect(config.BigIP.VirtualServer.HTTPSPort).To(Equal(uint16(443)))
+			})
+
+			It("sets the virtual server address and ports", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`
+bigip:
+  virtual_server:
+    address: 2001:db8::10
+    http_port: 8080
+    https_port: 8443
+`)
+				cfg.Initialize(b)
+				cfg.Process()
+				Expect(cfg.BigIP.VirtualServer.Address).To(Equal("2001:db8::10"))
+				Expect(cfg.BigIP.VirtualServer.HTTPPort).To(Equal(uint16(8080)))
+				Expect(cfg.BigIP.VirtualServer.HTTPSPort).To(Equal(uint16(8443)))
+			})
+		})
+
+		Context("sni routing config", func() {
+			It("does not route on the server name by default", func() {
+				Expect(config.BigIP.SNIRouting).To(BeFalse())
+			})
+
+			It("can enable sni routing", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`
+bigip:
+  sni_routing: true
+`)
+				cfg.Initialize(b)
+				cfg.Process()
+				Expect(cfg.BigIP.SNIRouting).To(BeTrue())
+			})
+		})
+
+		Context("https redirect config", func() {
+			It("does not redirect by default", func() {
+				Expect(config.BigIP.HTTPSRedirect).To(Equal(HTTPSRedirectConfig{}))
+			})
+
+			It("sets the https redirect", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`
+bigip:
+  https_redirect:
+    enabled: true
+    tag: https_only
+`)
+				cfg.Initialize(b)
+				cfg.Process()
+				Expect(cfg.BigIP.HTTPSRedirect).To(Equal(HTTPSRedirectConfig{
+					Enabled: true,
+					Tag:     "https_only",
+				}))
+			})
+		})
+
+		Context("forwarded headers config", func() {
+			It("does not insert the forwarded headers by default", func() {
+				Expect(config.BigIP.ForwardedHeaders).To(BeFalse())
+			})
+
+			It("can enable the forwarded headers", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`
+bigip:
+  forwarded_headers: true
+`)
+				cfg.Initialize(b)
+				cfg.Process()
+				Expect(cfg.BigIP.ForwardedHeaders).To(BeTrue())
+			})
+		})
+
+		Context("persistence config", func() {
+			It("does not persist sessions by default", func() {
+				Expect(config.BigIP.Persistence).To(Equal(PersistenceConfig{
+					Type: PERSISTENCE_NONE,
+					Tag:  "persistence",
+				}))
+			})
+
+			It("sets the persistence", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`
+bigip:
+  persistence:
+    type: cookie
+    cookie_name: cf-sticky
+`)
+				cfg.Initialize(b)
+				cfg.Process()
+				Expect(cfg.BigIP.Persistence).To(Equal(PersistenceConfig{
+					Type:       PERSISTENCE_COOKIE,
+					CookieName: "cf-sticky",
+					Tag:        "persistence",
+				}))
+			})
+		})
+
+		Context("http monitor config", func() {
+			It("does not set a send string by default", func() {
+				Expect(config.BigIP.HTTPMonitor.Send).To(BeEmpty())
+				Expect(config.BigIP.HTTPMonitor.Interval).To(Equal(5))
+				Expect(config.BigIP.HTTPMonitor.Timeout).To(Equal(16))
+				Expect(config.BigIP.HTTPMonitor.PathTag).To(Equal("healthcheck-path"))
+				Expect(config.BigIP.HTTPMonitor.ExpectTag).To(Equal("healthcheck-expect"))
+			})
+
+			It("sets the http monitor", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`
+bigip:
+  http_monitor:
+    send: "GET /health HTTP/1.0\\r\\n\\r\\n"
+    recv: 200 OK
+    interval: 10
+    context_path: true
+`)
+				cfg.Initialize(b)
+				cfg.Process()
+				Expect(cfg.BigIP.HTTPMonitor).To(Equal(HTTPMonitorConfig{
+					Send:        "GET /health HTTP/1.0\\r\\n\\r\\n",
+					Recv:        "200 OK",
+					Interval:    10,
+					Timeout:     16,
+					ContextPath: true,
+					Type:        MONITOR_HTTP,
+					PathTag:     "healthcheck-path",
+					ExpectTag:   "healthcheck-expect",
+				}))
+			})
+
+			It("sets the route health check tags", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`
+bigip:
+  http_monitor:
+    path_tag: health-path
+    expect_tag: ""
+`)
+				cfg.Initialize(b)
+				cfg.Process()
+				Expect(cfg.BigIP.HTTPMonitor.PathTag).To(Equal("health-path"))
+				Expect(cfg.BigIP.HTTPMonitor.ExpectTag).To(BeEmpty())
+			})
+
+			It("sets the https monitor", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`
+bigip:
+  http_monitor:
+    send: "GET /health HTTP/1.0\\r\\n\\r\\n"
+    type: https
+    server_ssl: /Common/serverssl
+    cipherlist: DEFAULT
+    tag: monitor
+`)
+				cfg.Initialize(b)
+				cfg.Process()
+				Expect(cfg.BigIP.HTTPMonitor.Type).To(Equal(MONITOR_HTTPS))
+				Expect(cfg.BigIP.HTTPMonitor.ServerSSL).To(Equal("/Common/serverssl"))
+				Expect(cfg.BigIP.HTTPMonitor.Cipherlist).To(Equal("DEFAULT"))
+				Expect(cfg.BigIP.HTTPMonitor.Tag).To(Equal("monitor"))
+			})
+		})
+
+		Context("stale update config", func() {
+			It("rejects stale updates by default", func() {
+				Expect(config.StaleUpdateAction).To(Equal(STALE_UPDATE_REJECT))
+			})
+
+			It("can override the stale update action", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`stale_update_action: accept`)
+
+				cfg.Initialize(b)
+				cfg.Process()
+				Expect(cfg.StaleUpdateAction).To(Equal(STALE_UPDATE_ACCEPT))
+			})
+
+			It("does not allow an invalid stale update action", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`stale_update_action: ignore`)
+
+				cfg.Initialize(b)
+				Expect(cfg.Process).To(Panic())
+			})
+		})
+
+		Context("guid change config", func() {
+			It("replaces endpoints on a guid change by default", func() {
+				Expect(config.GUIDChangeAction).To(Equal(GUID_CHANGE_REPLACE))
+			})
+
+			It("can override the guid change action", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`guid_change_action: keep`)
+
+				cfg.Initialize(b)
+				cfg.Process()
+				Expect(cfg.GUIDChangeAction).To(Equal(GUID_CHANGE_KEEP))
+			})
+
+			It("does not allow an invalid guid change action", func() {
+				cfg := DefaultConfig()
+				var b = []byte(`guid_change_action: both`)
+
+				cfg.Initialize(b)
+				Expect(cfg.Process).To(Panic())
+			})
+		})
+
 		Context("session persistence config", func() {
 			It("sets default session persistence", func() {
 				Expect(config.SessionPersistence).To(Equal(true))
@@ -446,6 +1222,32 @@ enable_proxy: true
 		})
 	})
 
+	Describe("EndpointFilterConfig", func() {
+		It("matches everything when empty", func() {
+			Expect(config.EndpointFilter.Matches(nil)).To(BeTrue())
+			Expect(config.EndpointFilter.Matches(map[string]string{"tier": "staging"})).To(BeTrue())
+		})
+
+		It("matches the allowed and not denied tags", func() {
+			var b = []byte(`
+endpoint_filter:
+  allow:
+    tier: [production, preprod]
+  deny:
+    canary: ["true"]
+`)
+			err := config.Initialize(b)
+			Expect(err).ToNot(HaveOccurred())
+
+			filter := config.EndpointFilter
+			Expect(filter.Matches(map[string]string{"tier": "production"})).To(BeTrue())
+			Expect(filter.Matches(map[string]string{"tier": "preprod", "canary": "false"})).To(BeTrue())
+			Expect(filter.Matches(map[string]string{"tier": "staging"})).To(BeFalse())
+			Expect(filter.Matches(map[string]string{"tier": "production", "canary": "true"})).To(BeFalse())
+			Expect(filter.Matches(nil)).To(BeFalse())
+		})
+	})
+
 	Describe("Process", func() {
 		It("panics if user and pass not set with broker_mode enabled", func() {
 			var b = []byte(`
@@ -459,6 +1261,297 @@ status:
 			Expect(config.Process).To(Panic())
 		})
 
+		It("panics if the file_sd interval is not positive with a path set", func() {
+			var b = []byte(`
+file_sd:
+  path: /tmp/targets.json
+  interval: 0s
+`)
+			err := config.Initialize(b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.FileSD.Path).To(Equal("/tmp/targets.json"))
+			Expect(config.Process).To(Panic())
+		})
+
+		It("sets the http writer config", func() {
+			Expect(config.HTTPWriter).To(Equal(HTTPWriterConfig{
+				Retries:    3,
+				Backoff:    time.Second,
+				MaxBackoff: 10 * time.Second,
+			}))
+
+			var b = []byte(`
+http_writer:
+  url: https://driver.example.com/config
+  headers:
+    Authorization: Bearer secret
+  retries: 5
+  backoff: 500ms
+  max_backoff: 5s
+`)
+			err := config.Initialize(b)
+			Expect(err).ToNot(HaveOccurred())
+			config.Process()
+			Expect(config.HTTPWriter).To(Equal(HTTPWriterConfig{
+				URL:        "https://driver.example.com/config",
+				Headers:    map[string]string{"Authorization": "Bearer secret"},
+				Retries:    5,
+				Backoff:    500 * time.Millisecond,
+				MaxBackoff: 5 * time.Second,
+			}))
+		})
+
+		It("panics when the http writer config is invalid", func() {
+			var b = []byte(`
+http_writer:
+  url: driver.example.com/config
+`)
+			err := config.Initialize(b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.Process).To(Panic())
+
+			config = DefaultConfig()
+			b = []byte(`
+http_writer:
+  url: http://driver.example.com/config
+  backoff: 0s
+`)
+			err = config.Initialize(b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.Process).To(Panic())
+
+			config = DefaultConfig()
+			b = []byte(`
+http_writer:
+  url: http://driver.example.com/config
+  max_backoff: 0s
+`)
+			err = config.Initialize(b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.Process).To(Panic())
+		})
+
+		It("sets the dry run config", func() {
+			Expect(config.DryRun).To(Equal(DryRunConfig{}))
+
+			var b = []byte(`
+dry_run:
+  enabled: true
+  file: /tmp/dry-run.json
+`)
+			err := config.Initialize(b)
+			Expect(err).ToNot(HaveOccurred())
+			config.Process()
+			Expect(config.DryRun).To(Equal(DryRunConfig{
+				Enabled: true,
+				File:    "/tmp/dry-run.json",
+			}))
+		})
+
+		It("sets the route events config", func() {
+			Expect(config.RouteEvents).To(Equal(RouteEventsConfig{}))
+
+			var b = []byte(`
+route_events:
+  enabled: true
+  file: /tmp/route-events.json
+`)
+			err := config.Initialize(b)
+			Expect(err).ToNot(HaveOccurred())
+			config.Process()
+			Expect(config.RouteEvents).To(Equal(RouteEventsConfig{
+				Enabled: true,
+				File:    "/tmp/route-events.json",
+			}))
+		})
+
+		It("sets the driver startup config", func() {
+			Expect(config.DriverStartup.Retries).To(Equal(0))
+
+			var b = []byte(`
+driver_startup:
+  retries: 5
+  backoff: 2s
+  max_backoff: 1m
+  stable_period: 10s
+`)
+			err := config.Initialize(b)
+			Expect(err).ToNot(HaveOccurred())
+			config.Process()
+			Expect(config.DriverStartup).To(Equal(DriverStartupConfig{
+				Retries:      5,
+				Backoff:      2 * time.Second,
+				MaxBackoff:   time.Minute,
+				StablePeriod: 10 * time.Second,
+			}))
+		})
+
+		It("sets the initial write config", func() {
+			Expect(config.InitialWrite).To(Equal(InitialWriteConfig{
+				Retries:    3,
+				Backoff:    time.Second,
+				MaxBackoff: 10 * time.Second,
+			}))
+
+			var b = []byte(`
+initial_write:
+  retries: 5
+  backoff: 2s
+  max_backoff: 1m
+`)
+			err := config.Initialize(b)
+			Expect(err).ToNot(HaveOccurred())
+			config.Process()
+			Expect(config.InitialWrite).To(Equal(InitialWriteConfig{
+				Retries:    5,
+				Backoff:    2 * time.Second,
+				MaxBackoff: time.Minute,
+			}))
+		})
+
+		It("panics if the initial write retries are not valid", func() {
+			var b = []byte(`
+initial_write:
+  retries: 5
+  backoff: 0s
+`)
+			err := config.Initialize(b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.Process).To(Panic())
+
+			config = DefaultConfig()
+			b = []byte(`
+initial_write:
+  retries: -1
+`)
+			err = config.Initialize(b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.Process).To(Panic())
+		})
+
+		It("panics if the driver startup retries are not valid", func() {
+			var b = []byte(`
+driver_startup:
+  retries: 5
+  stable_period: 0s
+`)
+			err := config.Initialize(b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.Process).To(Panic())
+
+			config = DefaultConfig()
+			b = []byte(`
+driver_startup:
+  retries: -1
+`)
+			err = config.Initialize(b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.Process).To(Panic())
+		})
+
+		It("sets the driver restart config", func() {
+			Expect(config.DriverRestart).To(Equal(DriverRestartConfig{
+				Backoff:     time.Second,
+				MaxBackoff:  30 * time.Second,
+				OnCleanExit: DRIVER_EXIT_STOP,
+			}))
+
+			var b = []byte(`
+driver_restart:
+  max_restarts: 3
+  backoff: 5s
+  max_backoff: 2m
+  on_clean_exit: restart
+`)
+			err := config.Initialize(b)
+			Expect(err).ToNot(HaveOccurred())
+			config.Process()
+			Expect(config.DriverRestart).To(Equal(DriverRestartConfig{
+				MaxRestarts: 3,
+				Backoff:     5 * time.Second,
+				MaxBackoff:  2 * time.Minute,
+				OnCleanExit: DRIVER_EXIT_RESTART,
+			}))
+		})
+
+		It("panics if the driver restarts are not valid", func() {
+			var b = []byte(`
+driver_restart:
+  max_restarts: 3
+  backoff: 0s
+`)
+			err := config.Initialize(b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.Process).To(Panic())
+
+			config = DefaultConfig()
+			b = []byte(`
+driver_restart:
+  max_restarts: -1
+`)
+			err = config.Initialize(b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.Process).To(Panic())
+
+			config = DefaultConfig()
+			b = []byte(`
+driver_restart:
+  on_clean_exit: exit
+`)
+			err = config.Initialize(b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.Process).To(Panic())
+
+			config = DefaultConfig()
+			b = []byte(`
+driver_restart:
+  on_clean_exit: restart
+  backoff: 0s
+`)
+			err = config.Initialize(b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.Process).To(Panic())
+		})
+
+		It("sets the apply command config", func() {
+			Expect(config.ApplyCommand).To(Equal(ApplyCommandConfig{Timeout: 60 * time.Second}))
+
+			var b = []byte(`
+apply_command:
+  cmd: /usr/local/bin/apply --file {{.ConfigFile}}
+  replace_driver: true
+  timeout: 10s
+`)
+			err := config.Initialize(b)
+			Expect(err).ToNot(HaveOccurred())
+			config.Process()
+			Expect(config.ApplyCommand).To(Equal(ApplyCommandConfig{
+				Cmd:           "/usr/local/bin/apply --file {{.ConfigFile}}",
+				ReplaceDriver: true,
+				Timeout:       10 * time.Second,
+			}))
+		})
+
+		It("panics if the apply command is not valid", func() {
+			var b = []byte(`
+apply_command:
+  replace_driver: true
+`)
+			err := config.Initialize(b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.Process).To(Panic())
+
+			config = DefaultConfig()
+			b = []byte(`
+apply_command:
+  cmd: /usr/local/bin/apply
+  timeout: 0s
+`)
+			err = config.Initialize(b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(config.Process).To(Panic())
+		})
+
 		It("converts intervals to durations", func() {
 			var b = []byte(`
 publish_start_message_interval: 1s
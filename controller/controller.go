@@ -58,6 +58,8 @@ func NewController(
 	routingTable *routingtable.RoutingTable,
 	v varz.Varz,
 	brokerHandler http.Handler,
+	routeHandlers map[string]http.Handler,
+	healthChecks ...func() bool,
 ) (*Controller, error) {
 	var host string
 
@@ -91,7 +93,7 @@ func NewController(
 	}
 
 	var heartbeatOK int32
-	health := handlers.NewHealthcheck(&heartbeatOK, logger)
+	health := handlers.NewHealthcheck(&heartbeatOK, logger, healthChecks...)
 	component := &common.VcapComponent{
 		Config: cfg,
 		Varz:   varz,
@@ -99,7 +101,8 @@ func NewController(
 		InfoRoutes: map[string]json.Marshaler{
 			"/routes": r,
 		},
-		Logger: logger,
+		Handlers: routeHandlers,
+		Logger:   logger,
 	}
 
 	if err := component.Start(brokerHandler); err != nil {
@@ -21,6 +21,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/F5Networks/cf-bigip-ctlr/route"
 )
@@ -30,6 +31,13 @@ type (
 	GlobalConfig struct {
 		LogLevel       string `json:"log-level"`
 		VerifyInterval int    `json:"verify-interval"`
+		// Generation of the config, increased with every write after a route
+		// change, the initial config has none
+		Generation uint64 `json:"generation,omitempty"`
+		// FullSync marks the first config with resources after startup, the
+		// driver prunes the objects in the managed partitions not in it.
+		// Later configs are incremental.
+		FullSync bool `json:"full-sync,omitempty"`
 	}
 
 	// VirtualAddress is frontend bindaddr and port
@@ -57,6 +65,7 @@ type (
 	// Resources is what gets written to and dumped out for the python side
 	Resources struct {
 		Virtuals           []*Virtual           `json:"virtualServers,omitempty"`
+		Nodes              []*Node              `json:"nodes,omitempty"`
 		Pools              []*Pool              `json:"pools,omitempty"`
 		Monitors           []*Monitor           `json:"monitors,omitempty"`
 		Policies           []*Policy            `json:"l7Policies,omitempty"`
@@ -71,37 +80,90 @@ type (
 		Mode                  string                `json:"ipProtocol,omitempty"`
 		Enabled               bool                  `json:"enabled,omitempty"`
 		Destination           string                `json:"destination,omitempty"`
+		Mask                  string                `json:"mask,omitempty"`
 		SourceAddress         string                `json:"source,omitempty"`
 		Policies              []*NameRef            `json:"policies,omitempty"`
 		Profiles              []*ProfileRef         `json:"profiles,omitempty"`
 		IRules                []string              `json:"rules,omitempty"`
 		SourceAddrTranslation SourceAddrTranslation `json:"sourceAddressTranslation,omitempty"`
+		Persistence           []*NameRef            `json:"persist,omitempty"`
+		FallbackPersistence   string                `json:"fallbackPersistence,omitempty"`
+		Nat64                 string                `json:"nat64,omitempty"`
+		Metadata              []*Metadata           `json:"metadata,omitempty"`
+		RateLimit             int                   `json:"rateLimit,omitempty"`
+		ConnectionMirroring   bool                  `json:"connectionMirroring,omitempty"`
+		VLANs                 []string              `json:"vlans,omitempty"`
+		VLANsEnabled          bool                  `json:"vlansEnabled,omitempty"`
+		VLANsDisabled         bool                  `json:"vlansDisabled,omitempty"`
+		// WAFPolicy the WAF policy reference in Policies, kept when a plan
+		// replaces the policies of the virtual
+		WAFPolicy *NameRef `json:"-"`
+		// ServerSSL the server SSL profile reference in Profiles re-encrypting
+		// to TLS backends, kept when a plan replaces the profiles of the
+		// virtual
+		ServerSSL *ProfileRef `json:"-"`
 	}
 
-	// Pool Member
+	// Pool Member, a member referencing a Node by name leaves out its
+	// address
 	Member struct {
+		Address         string `json:"address,omitempty"`
+		Node            string `json:"node,omitempty"`
+		Port            uint16 `json:"port"`
+		Session         string `json:"session,omitempty"`
+		State           string `json:"state,omitempty"`
+		Ratio           int    `json:"ratio,omitempty"`
+		ConnectionLimit int    `json:"connectionLimit,omitempty"`
+		PriorityGroup   int    `json:"priorityGroup,omitempty"`
+		// FQDN node settings of a member on a host name, the BIG-IP resolves
+		// the name itself
+		FQDN *MemberFQDN `json:"fqdn,omitempty"`
+	}
+
+	// MemberFQDN host name of an FQDN pool member, Autopopulate enabled
+	// adds a member for each address the name resolves to
+	MemberFQDN struct {
+		Name         string `json:"tmName"`
+		Autopopulate string `json:"autopopulate"`
+	}
+
+	// Node backend address shared by the pool members referencing it
+	Node struct {
+		Name    string `json:"name"`
 		Address string `json:"address"`
-		Port    uint16 `json:"port"`
-		Session string `json:"session,omitempty"`
 	}
 
 	// Pool backend
 	Pool struct {
-		Name         string   `json:"name"`
-		Balance      string   `json:"loadBalancingMode"`
-		Members      []Member `json:"members"`
-		MonitorNames []string `json:"monitors"`
-		Description  string   `json:"description"`
+		Name                   string      `json:"name"`
+		Balance                string      `json:"loadBalancingMode"`
+		Members                []Member    `json:"members"`
+		MonitorNames           []string    `json:"monitors"`
+		Description            string      `json:"description"`
+		ServiceDownAction      string      `json:"serviceDownAction,omitempty"`
+		QueueOnConnectionLimit string      `json:"queueOnConnectionLimit,omitempty"`
+		QueueDepthLimit        int         `json:"queueDepthLimit,omitempty"`
+		QueueTimeLimit         int         `json:"queueTimeLimit,omitempty"`
+		MinActiveMembers       int         `json:"minActiveMembers,omitempty"`
+		Metadata               []*Metadata `json:"metadata,omitempty"`
+	}
+
+	// Metadata key/value entry attached to a BIG-IP object
+	Metadata struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
 	}
 
 	// backend health monitor
 	Monitor struct {
-		Name     string `json:"name"`
-		Interval int    `json:"interval,omitempty"`
-		Type     string `json:"type"`
-		Send     string `json:"send,omitempty"`
-		Recv     string `json:"recv,omitempty"`
-		Timeout  int    `json:"timeout,omitempty"`
+		Name       string `json:"name"`
+		Interval   int    `json:"interval,omitempty"`
+		Type       string `json:"type"`
+		Send       string `json:"send,omitempty"`
+		Recv       string `json:"recv,omitempty"`
+		Timeout    int    `json:"timeout,omitempty"`
+		SSLProfile string `json:"sslProfile,omitempty"`
+		Cipherlist string `json:"cipherlist,omitempty"`
 	}
 
 	// Action for a rule
@@ -114,6 +176,16 @@ type (
 		TmName      string `json:"tmName,omitempty"`
 		Tcl         bool   `json:"tcl,omitempty"`
 		SetVariable bool   `json:"setVariable,omitempty"`
+		Reset       bool   `json:"reset,omitempty"`
+		Drop        bool   `json:"drop,omitempty"`
+		// SSLClientHello runs the action on the TLS client hello instead of
+		// the request
+		SSLClientHello bool `json:"sslClientHello,omitempty"`
+		// HTTPReply with Redirect answers the request with a redirect to
+		// Location
+		HTTPReply bool   `json:"httpReply,omitempty"`
+		Redirect  bool   `json:"redirect,omitempty"`
+		Location  string `json:"location,omitempty"`
 	}
 
 	// Condition for a rule
@@ -121,14 +193,21 @@ type (
 		Equals      bool     `json:"equals,omitempty"`
 		StartsWith  bool     `json:"startsWith,omitempty"`
 		EndsWith    bool     `json:"endsWith,omitempty"`
+		Exists      bool     `json:"exists,omitempty"`
 		Host        bool     `json:"host,omitempty"`
+		HTTPHeader  bool     `json:"httpHeader,omitempty"`
 		HTTPHost    bool     `json:"httpHost,omitempty"`
 		HTTPURI     bool     `json:"httpUri,omitempty"`
 		PathSegment bool     `json:"pathSegment,omitempty"`
+		TmName      string   `json:"tmName,omitempty"`
 		Name        string   `json:"name"`
 		Index       int      `json:"index"`
 		Request     bool     `json:"request"`
 		Values      []string `json:"values"`
+		// SNI match on the TLS server name of the client hello
+		SSLExtension   bool `json:"sslExtension,omitempty"`
+		ServerName     bool `json:"serverName,omitempty"`
+		SSLClientHello bool `json:"sslClientHello,omitempty"`
 	}
 
 	// Rule builds up a Policy
@@ -173,18 +252,65 @@ type (
 	// SourceAddrTranslation is the Virtual Server Source Address Translation
 	SourceAddrTranslation struct {
 		Type string `json:"type"`
+		Pool string `json:"pool,omitempty"`
 	}
 
 	Policies []*Policy
 	Rules    []*Rule
 	RouteMap map[route.Uri]*Pool
 	RuleMap  map[route.Uri]*Rule
+
+	// RulesByPrecedence sorts rules in the order a first match policy must
+	// evaluate them
+	RulesByPrecedence []*Rule
 )
 
 func (r Rules) Len() int           { return len(r) }
 func (r Rules) Less(i, j int) bool { return r[i].FullURI < r[j].FullURI }
 func (r Rules) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
 
+func (r RulesByPrecedence) Len() int      { return len(r) }
+func (r RulesByPrecedence) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+
+// Less ranks exact hosts before wildcards and wildcards with a longer literal
+// host before broader ones, so the longest match is found first. Rules of the
+// same rank are in reverse FullURI order which puts longer paths of a host
+// first.
+func (r RulesByPrecedence) Less(i, j int) bool {
+	hi, hj := ruleHost(r[i].FullURI), ruleHost(r[j].FullURI)
+	wi, wj := strings.Contains(hi, "*"), strings.Contains(hj, "*")
+	if wi != wj {
+		return !wi
+	}
+	if wi {
+		li := len(hi) - strings.Count(hi, "*")
+		lj := len(hj) - strings.Count(hj, "*")
+		if li != lj {
+			return li > lj
+		}
+	}
+	return r[i].FullURI > r[j].FullURI
+}
+
+// ruleHost returns the host part of a rule's URI
+func ruleHost(uri string) string {
+	if i := strings.Index(uri, "/"); -1 != i {
+		return uri[:i]
+	}
+	return uri
+}
+
+// MarshalJSON leaves the address out of a member referencing a node, the
+// member keeps it for matching route updates
+func (m Member) MarshalJSON() ([]byte, error) {
+	type member Member
+	mm := member(m)
+	if "" != mm.Node {
+		mm.Address = ""
+	}
+	return json.Marshal(mm)
+}
+
 func (va VirtualAddress) String() string {
 	return fmt.Sprintf("%s:%s", va.BindAddr, strconv.Itoa(int(va.Port)))
 }
@@ -0,0 +1,39 @@
+/*-
+ * Copyright (c) 2018, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bigipResources
+
+import "fmt"
+
+const (
+	// FallbackPoolIRuleName on BIG-IP, attached to the route virtuals
+	FallbackPoolIRuleName = "cf-fallback-pool"
+
+	// FallbackPoolIRule sends the request to the fallback pool, such as one
+	// serving a sorry page, when the virtual's pool has no available members
+	FallbackPoolIRule = `
+when HTTP_REQUEST {
+  if { [active_members [LB::server pool]] < 1 } {
+    pool %s
+  }
+}`
+)
+
+// MakeFallbackPoolIRule returns the iRule code switching to the fallback pool
+// when no pool members are available
+func MakeFallbackPoolIRule(pool string) string {
+	return fmt.Sprintf(FallbackPoolIRule, TclQuote(pool))
+}
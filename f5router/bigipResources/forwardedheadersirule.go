@@ -0,0 +1,38 @@
+/*-
+ * Copyright (c) 2018, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bigipResources
+
+const (
+	// ForwardedHeadersIRuleName on BIG-IP, attached to the routing virtuals
+	ForwardedHeadersIRuleName = "cf-forwarded-headers"
+
+	// ForwardedHeadersIRule appends the client address to X-Forwarded-For and
+	// sets X-Forwarded-Proto to the scheme the client connected with
+	ForwardedHeadersIRule = `
+when HTTP_REQUEST {
+  if {[HTTP::header exists "X-Forwarded-For"]} {
+    HTTP::header replace "X-Forwarded-For" "[HTTP::header X-Forwarded-For], [IP::client_addr]"
+  } else {
+    HTTP::header insert "X-Forwarded-For" [IP::client_addr]
+  }
+  if {[PROFILE::exists clientssl]} {
+    HTTP::header replace "X-Forwarded-Proto" "https"
+  } else {
+    HTTP::header replace "X-Forwarded-Proto" "http"
+  }
+}`
+)
@@ -0,0 +1,47 @@
+/*-
+ * Copyright (c) 2018, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bigipResources
+
+import "fmt"
+
+const (
+	// HTTPSRedirectIRuleName on BIG-IP, attached to the HTTP routing virtual
+	HTTPSRedirectIRuleName = "cf-https-redirect"
+	// HTTPSRedirectVariable set by the routing policy rule of a route
+	// redirected to HTTPS
+	HTTPSRedirectVariable = "https_redirect"
+
+	// HTTPSRedirectIRule redirects the request to HTTPS on the port when the
+	// routing policy set the redirect variable, the variable is cleared so the
+	// next request on the connection is matched again
+	HTTPSRedirectIRule = `
+when HTTP_REQUEST {
+  if {[info exists https_redirect]} {
+    unset https_redirect
+    HTTP::respond 301 Location "https://[getfield [HTTP::host] ":" 1]%s[HTTP::uri]"
+  }
+}`
+)
+
+// MakeHTTPSRedirectIRule returns the iRule code redirecting to HTTPS on port,
+// the port is left out of the location for 443
+func MakeHTTPSRedirectIRule(port int) string {
+	if 443 == port {
+		return fmt.Sprintf(HTTPSRedirectIRule, "")
+	}
+	return fmt.Sprintf(HTTPSRedirectIRule, fmt.Sprintf(":%d", port))
+}
@@ -0,0 +1,37 @@
+/*-
+ * Copyright (c) 2018, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bigipResources
+
+import "fmt"
+
+const (
+	// IdleTimeoutIRuleName on BIG-IP, attached to the client facing virtuals
+	IdleTimeoutIRuleName = "cf-idle-timeout"
+
+	// IdleTimeoutIRule replaces the idle timeout of the TCP profile for the
+	// client connection, in seconds
+	IdleTimeoutIRule = `
+when CLIENT_ACCEPTED {
+  IP::idle_timeout %d
+}`
+)
+
+// MakeIdleTimeoutIRule returns the iRule code setting the connection idle
+// timeout to timeout seconds
+func MakeIdleTimeoutIRule(timeout int) string {
+	return fmt.Sprintf(IdleTimeoutIRule, timeout)
+}
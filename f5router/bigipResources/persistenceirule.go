@@ -0,0 +1,53 @@
+/*-
+ * Copyright (c) 2018, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bigipResources
+
+import "fmt"
+
+const (
+	// CookiePersistenceIRuleName on BIG-IP, names the cookie inserted by the
+	// cookie persistence profile
+	CookiePersistenceIRuleName = "cf-cookie-persistence"
+	// SourceAddrPersistenceIRuleName on BIG-IP, sets the timeout of the source
+	// address persistence profile
+	SourceAddrPersistenceIRuleName = "cf-source-addr-persistence"
+
+	// CookiePersistenceIRule inserts the named persistence cookie
+	CookiePersistenceIRule = `
+when HTTP_REQUEST {
+  persist cookie insert "%s" 0
+}`
+
+	// SourceAddrPersistenceIRule persists on the full client address for the
+	// timeout in seconds
+	SourceAddrPersistenceIRule = `
+when CLIENT_ACCEPTED {
+  persist source_addr 255.255.255.255 %d
+}`
+)
+
+// MakeCookiePersistenceIRule returns the iRule code inserting the named
+// persistence cookie
+func MakeCookiePersistenceIRule(cookieName string) string {
+	return fmt.Sprintf(CookiePersistenceIRule, cookieName)
+}
+
+// MakeSourceAddrPersistenceIRule returns the iRule code persisting on the
+// client address for timeout seconds
+func MakeSourceAddrPersistenceIRule(timeout int) string {
+	return fmt.Sprintf(SourceAddrPersistenceIRule, timeout)
+}
@@ -0,0 +1,56 @@
+/*-
+ * Copyright (c) 2018, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bigipResources
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// PoolDownIRuleSuffix suffix of a route's pool down iRule name
+	PoolDownIRuleSuffix = "-pool-down"
+
+	// PoolDownIRule responds to the client when the virtual's pool has no
+	// available members, the response commands are filled in per route
+	PoolDownIRule = `
+when HTTP_REQUEST {
+  if { [active_members [LB::server pool]] < 1 } {
+    %s
+    return
+  }
+}`
+)
+
+// MakePoolDownIRule returns the pool down iRule code running the response
+// command when no pool members are available
+func MakePoolDownIRule(response string) string {
+	return fmt.Sprintf(PoolDownIRule, response)
+}
+
+// TclQuote quotes a string for use as a single word in an iRule
+func TclQuote(s string) string {
+	r := strings.NewReplacer(
+		`\`, `\\`,
+		`"`, `\"`,
+		`$`, `\$`,
+		`[`, `\[`,
+		`]`, `\]`,
+		"\n", `\n`,
+	)
+	return `"` + r.Replace(s) + `"`
+}
@@ -0,0 +1,57 @@
+/*-
+ * Copyright (c) 2018, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bigipResources
+
+import "fmt"
+
+const (
+	// SizeLimitIRuleSuffix suffix of a route's size limit iRule name
+	SizeLimitIRuleSuffix = "-size-limit"
+
+	// MaxRequestIRule rejects requests whose Content-Length is over the limit
+	// with a 413
+	MaxRequestIRule = `
+when HTTP_REQUEST {
+  if { [HTTP::header exists "Content-Length"] && [HTTP::header "Content-Length"] > %d } {
+    HTTP::respond 413 "Connection" "Close"
+    return
+  }
+}`
+
+	// MaxResponseIRule replaces responses whose Content-Length is over the
+	// limit with a 502
+	MaxResponseIRule = `
+when HTTP_RESPONSE {
+  if { [HTTP::header exists "Content-Length"] && [HTTP::header "Content-Length"] > %d } {
+    HTTP::respond 502 "Connection" "Close"
+    return
+  }
+}`
+)
+
+// MakeSizeLimitIRule returns the size limit iRule code for the limits which
+// are set, a limit of zero is not enforced
+func MakeSizeLimitIRule(maxRequestBytes, maxResponseBytes int64) string {
+	var code string
+	if maxRequestBytes > 0 {
+		code += fmt.Sprintf(MaxRequestIRule, maxRequestBytes)
+	}
+	if maxResponseBytes > 0 {
+		code += fmt.Sprintf(MaxResponseIRule, maxResponseBytes)
+	}
+	return code
+}
@@ -0,0 +1,286 @@
+/*-
+ * Copyright (c) 2018, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package f5router
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/F5Networks/cf-bigip-ctlr/f5router/bigipResources"
+)
+
+// DiffWriter a Writer which also accepts only the objects changed since the
+// last config it was given, like Write it must not retain the input
+type DiffWriter interface {
+	Writer
+	WriteDiff(input []byte) (n int, err error)
+}
+
+// Operations of a config change
+const (
+	ChangeAdd    = "add"
+	ChangeModify = "modify"
+	ChangeRemove = "remove"
+)
+
+// resourceKinds the kinds of objects in the field order of
+// bigipResources.Resources
+var resourceKinds = []string{
+	"virtualServers",
+	"nodes",
+	"pools",
+	"monitors",
+	"l7Policies",
+	"iRules",
+	"internalDataGroups",
+}
+
+type (
+	// objectKey identifies an object of a written config, kind is the name of
+	// the resources field holding it
+	objectKey struct {
+		partition string
+		kind      string
+		name      string
+	}
+
+	// configObjects the JSON of every object of a config
+	configObjects map[objectKey][]byte
+
+	// configChange an object added, modified or removed since the last
+	// written config, removed objects are written without the object
+	configChange struct {
+		Op        string          `json:"op"`
+		Partition string          `json:"partition"`
+		Kind      string          `json:"kind"`
+		Name      string          `json:"name"`
+		Object    json.RawMessage `json:"object,omitempty"`
+	}
+
+	// configDiff the changes written in place of the resources of a config
+	configDiff struct {
+		BigIP   json.RawMessage `json:"bigip"`
+		Global  json.RawMessage `json:"global"`
+		Changes []configChange  `json:"changes"`
+	}
+
+	// rawConfig a full config with the objects of each partition by kind
+	rawConfig struct {
+		BigIP     json.RawMessage                         `json:"bigip"`
+		Global    json.RawMessage                         `json:"global"`
+		Resources map[string]map[string][]json.RawMessage `json:"resources"`
+	}
+
+	// rawObject an object of a config with its name
+	rawObject struct {
+		name string
+		data json.RawMessage
+	}
+)
+
+// makeConfigObjects marshals each object of the partitions on its own
+func makeConfigObjects(pm bigipResources.PartitionMap) (configObjects, error) {
+	objects := make(configObjects)
+	add := func(partition string, kind string, name string, obj interface{}) error {
+		data, err := json.Marshal(obj)
+		if nil != err {
+			return err
+		}
+		objects[objectKey{partition: partition, kind: kind, name: name}] = data
+		return nil
+	}
+
+	for partition, rs := range pm {
+		if nil == rs {
+			continue
+		}
+		for _, vs := range rs.Virtuals {
+			if err := add(partition, "virtualServers", vs.VirtualServerName, vs); nil != err {
+				return nil, err
+			}
+		}
+		for _, node := range rs.Nodes {
+			if err := add(partition, "nodes", node.Name, node); nil != err {
+				return nil, err
+			}
+		}
+		for _, pool := range rs.Pools {
+			if err := add(partition, "pools", pool.Name, pool); nil != err {
+				return nil, err
+			}
+		}
+		for _, monitor := range rs.Monitors {
+			if err := add(partition, "monitors", monitor.Name, monitor); nil != err {
+				return nil, err
+			}
+		}
+		for _, policy := range rs.Policies {
+			if err := add(partition, "l7Policies", policy.Name, policy); nil != err {
+				return nil, err
+			}
+		}
+		for _, rule := range rs.IRules {
+			if err := add(partition, "iRules", rule.Name, rule); nil != err {
+				return nil, err
+			}
+		}
+		for _, dg := range rs.InternalDataGroups {
+			if err := add(partition, "internalDataGroups", dg.Name, dg); nil != err {
+				return nil, err
+			}
+		}
+	}
+	return objects, nil
+}
+
+// diffConfigObjects returns the changes turning the last objects into the
+// current ones, ordered by partition, kind and name
+func diffConfigObjects(last configObjects, current configObjects) []configChange {
+	changes := []configChange{}
+	for key, data := range current {
+		lastData, ok := last[key]
+		if !ok {
+			changes = append(changes, makeConfigChange(ChangeAdd, key, data))
+		} else if !bytes.Equal(lastData, data) {
+			changes = append(changes, makeConfigChange(ChangeModify, key, data))
+		}
+	}
+	for key := range last {
+		if _, ok := current[key]; !ok {
+			changes = append(changes, makeConfigChange(ChangeRemove, key, nil))
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		a, b := changes[i], changes[j]
+		if a.Partition != b.Partition {
+			return a.Partition < b.Partition
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Name < b.Name
+	})
+	return changes
+}
+
+func makeConfigChange(op string, key objectKey, data []byte) configChange {
+	return configChange{
+		Op:        op,
+		Partition: key.partition,
+		Kind:      key.kind,
+		Name:      key.name,
+		Object:    data,
+	}
+}
+
+// applyConfigDiff returns the full config with the changes of a diff applied,
+// the same output as writing the full config of the changed resources
+func applyConfigDiff(full []byte, diff []byte) ([]byte, error) {
+	var cfg rawConfig
+	if err := json.Unmarshal(full, &cfg); nil != err {
+		return nil, fmt.Errorf("invalid config: %v", err)
+	}
+	var d configDiff
+	if err := json.Unmarshal(diff, &d); nil != err {
+		return nil, fmt.Errorf("invalid config diff: %v", err)
+	}
+
+	objects := make(map[string]map[string][]rawObject)
+	for partition, kinds := range cfg.Resources {
+		objects[partition] = make(map[string][]rawObject)
+		for kind, list := range kinds {
+			for _, data := range list {
+				var obj struct {
+					Name string `json:"name"`
+				}
+				if err := json.Unmarshal(data, &obj); nil != err {
+					return nil, fmt.Errorf("invalid %s object in partition %s: %v", kind, partition, err)
+				}
+				objects[partition][kind] = append(objects[partition][kind], rawObject{obj.Name, data})
+			}
+		}
+	}
+
+	for _, change := range d.Changes {
+		if _, ok := objects[change.Partition]; !ok {
+			objects[change.Partition] = make(map[string][]rawObject)
+		}
+		list := objects[change.Partition][change.Kind]
+		i := sort.Search(len(list), func(i int) bool { return list[i].name >= change.Name })
+		found := i < len(list) && list[i].name == change.Name
+		switch {
+		case ChangeRemove == change.Op && found:
+			list = append(list[:i], list[i+1:]...)
+		case ChangeRemove != change.Op && found:
+			list[i].data = change.Object
+		case ChangeRemove != change.Op:
+			list = append(list, rawObject{})
+			copy(list[i+1:], list[i:])
+			list[i] = rawObject{change.Name, change.Object}
+		default:
+			return nil, fmt.Errorf("removed %s %s is not in partition %s",
+				change.Kind, change.Name, change.Partition)
+		}
+		objects[change.Partition][change.Kind] = list
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(`{"bigip":`)
+	buf.Write(d.BigIP)
+	buf.WriteString(`,"global":`)
+	buf.Write(d.Global)
+	buf.WriteString(`,"resources":{`)
+	partitions := make([]string, 0, len(objects))
+	for partition := range objects {
+		partitions = append(partitions, partition)
+	}
+	sort.Strings(partitions)
+	for i, partition := range partitions {
+		if 0 != i {
+			buf.WriteByte(',')
+		}
+		if err := writeMarshaled(&buf, partition); nil != err {
+			return nil, err
+		}
+		buf.WriteString(`:{`)
+		var written int
+		for _, kind := range resourceKinds {
+			list := objects[partition][kind]
+			if 0 == len(list) {
+				continue
+			}
+			if 0 != written {
+				buf.WriteByte(',')
+			}
+			written++
+			buf.WriteString(`"` + kind + `":`)
+			err := writeList(&buf, len(list), func(i int) error {
+				_, err := buf.Write(list[i].data)
+				return err
+			})
+			if nil != err {
+				return nil, err
+			}
+		}
+		buf.WriteByte('}')
+	}
+	buf.WriteString(`}}`)
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,389 @@
+/*-
+ * Copyright (c) 2018, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package f5router
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	fakeClient "github.com/F5Networks/cf-bigip-ctlr/bigipclient/fakes"
+	"github.com/F5Networks/cf-bigip-ctlr/config"
+	"github.com/F5Networks/cf-bigip-ctlr/f5router/bigipResources"
+	"github.com/F5Networks/cf-bigip-ctlr/f5router/routeUpdate"
+	"github.com/F5Networks/cf-bigip-ctlr/route"
+	"github.com/F5Networks/cf-bigip-ctlr/test_util"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gbytes"
+)
+
+// mockDiffWriter records the full configs and diffs it is given
+type mockDiffWriter struct {
+	MockWriter
+	mutex   sync.Mutex
+	diffs   [][]byte
+	fulls   int
+	diffErr error
+}
+
+func (dw *mockDiffWriter) Write(input []byte) (int, error) {
+	dw.mutex.Lock()
+	dw.fulls++
+	dw.mutex.Unlock()
+	return dw.MockWriter.Write(input)
+}
+
+func (dw *mockDiffWriter) WriteDiff(input []byte) (int, error) {
+	dw.mutex.Lock()
+	defer dw.mutex.Unlock()
+	if nil != dw.diffErr {
+		return 0, dw.diffErr
+	}
+	diff := make([]byte, len(input))
+	copy(diff, input)
+	dw.diffs = append(dw.diffs, diff)
+	return len(input), nil
+}
+
+func (dw *mockDiffWriter) setDiffErr(err error) {
+	dw.mutex.Lock()
+	defer dw.mutex.Unlock()
+	dw.diffErr = err
+}
+
+func (dw *mockDiffWriter) fullCount() int {
+	dw.mutex.Lock()
+	defer dw.mutex.Unlock()
+	return dw.fulls
+}
+
+func (dw *mockDiffWriter) diffCount() int {
+	dw.mutex.Lock()
+	defer dw.mutex.Unlock()
+	return len(dw.diffs)
+}
+
+// lastChanges returns the op, kind and name of the changes of the last diff
+func (dw *mockDiffWriter) lastChanges() []string {
+	dw.mutex.Lock()
+	defer dw.mutex.Unlock()
+	var diff struct {
+		Global  bigipResources.GlobalConfig `json:"global"`
+		Changes []configChange              `json:"changes"`
+	}
+	Expect(dw.diffs).NotTo(BeEmpty())
+	Expect(json.Unmarshal(dw.diffs[len(dw.diffs)-1], &diff)).To(Succeed())
+	Expect(diff.Global.FullSync).To(BeFalse())
+	changes := []string{}
+	for _, change := range diff.Changes {
+		Expect(change.Partition).To(Equal("cf"))
+		if ChangeRemove == change.Op {
+			Expect(change.Object).To(BeEmpty())
+		} else {
+			Expect(change.Object).NotTo(BeEmpty())
+		}
+		changes = append(changes, change.Op+" "+change.Kind+" "+change.Name)
+	}
+	return changes
+}
+
+var _ = Describe("config diffs", func() {
+	var (
+		logger *test_util.TestZapLogger
+		c      *config.Config
+		dw     *mockDiffWriter
+		writer Writer
+		router *F5Router
+		stop   func()
+	)
+
+	update := func(op routeUpdate.Operation, uri route.Uri, addr string) {
+		ru, err := NewUpdate(logger, op, uri, makeEndpoint(addr), "")
+		Expect(err).NotTo(HaveOccurred())
+		router.UpdateRoute(ru)
+	}
+
+	pools := func() []*bigipResources.Pool {
+		return dw.getResources("cf").Pools
+	}
+
+	BeforeEach(func() {
+		logger = test_util.NewTestZapLogger("config-diff-test")
+		c = makeConfig()
+		c.BigIP.IncrementalConfig = true
+		dw = &mockDiffWriter{}
+		writer = dw
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		router, err = NewF5Router(logger, c, writer, &fakeClient.FakeClient{})
+		Expect(err).NotTo(HaveOccurred())
+		stop = runRouter(router)
+	})
+
+	AfterEach(func() {
+		stop()
+		if nil != logger {
+			logger.Close()
+		}
+	})
+
+	It("should write the changed objects after the first full config", func() {
+		foo := makeObjectName("foo.cf.com")
+		update(routeUpdate.Add, "foo.cf.com", "127.0.0.1")
+		Eventually(pools).Should(HaveLen(1))
+		fulls := dw.fullCount()
+		Expect(dw.diffCount()).To(BeZero())
+
+		By("adding a route")
+		bar := makeObjectName("bar.cf.com")
+		update(routeUpdate.Add, "bar.cf.com", "127.0.0.2")
+		Eventually(dw.diffCount).Should(Equal(1))
+		Expect(dw.lastChanges()).To(Equal([]string{
+			"modify internalDataGroups " + InternalDataGroupName,
+			"modify l7Policies " + CFRoutingPolicyName,
+			"add pools " + bar,
+			"add virtualServers " + bar,
+		}))
+
+		By("adding a member to a route")
+		update(routeUpdate.Add, "foo.cf.com", "127.0.0.3")
+		Eventually(dw.diffCount).Should(Equal(2))
+		Expect(dw.lastChanges()).To(Equal([]string{"modify pools " + foo}))
+
+		By("removing a route")
+		update(routeUpdate.Remove, "bar.cf.com", "127.0.0.2")
+		Eventually(dw.diffCount).Should(Equal(3))
+		Expect(dw.lastChanges()).To(Equal([]string{
+			"modify internalDataGroups " + InternalDataGroupName,
+			"modify l7Policies " + CFRoutingPolicyName,
+			"remove pools " + bar,
+			"remove virtualServers " + bar,
+		}))
+		Expect(dw.fullCount()).To(Equal(fulls))
+		Expect(logger).To(Say(`f5router-config-written.*"diff":true`))
+	})
+
+	It("should diff against the last config written", func() {
+		update(routeUpdate.Add, "foo.cf.com", "127.0.0.1")
+		Eventually(pools).Should(HaveLen(1))
+
+		dw.setDiffErr(errors.New("driver busy"))
+		bar := makeObjectName("bar.cf.com")
+		update(routeUpdate.Add, "bar.cf.com", "127.0.0.2")
+		Eventually(logger).Should(Say("f5router-config-write-error"))
+		Expect(dw.diffCount()).To(BeZero())
+
+		// The retried write holds the route of the failed one
+		dw.setDiffErr(nil)
+		Eventually(dw.diffCount).Should(Equal(1))
+		Expect(dw.lastChanges()).To(Equal([]string{
+			"modify internalDataGroups " + InternalDataGroupName,
+			"modify l7Policies " + CFRoutingPolicyName,
+			"add pools " + bar,
+			"add virtualServers " + bar,
+		}))
+
+		baz := makeObjectName("baz.cf.com")
+		update(routeUpdate.Add, "baz.cf.com", "127.0.0.3")
+		Eventually(dw.diffCount).Should(Equal(2))
+		Expect(dw.lastChanges()).To(Equal([]string{
+			"modify internalDataGroups " + InternalDataGroupName,
+			"modify l7Policies " + CFRoutingPolicyName,
+			"add pools " + baz,
+			"add virtualServers " + baz,
+		}))
+	})
+
+	It("should change a shared node only with its address", func() {
+		c.BigIP.SharedNodes = true
+		update(routeUpdate.Add, "foo.cf.com", "127.0.0.1")
+		Eventually(pools).Should(HaveLen(1))
+
+		bar := makeObjectName("bar.cf.com")
+		update(routeUpdate.Add, "bar.cf.com", "127.0.0.1")
+		Eventually(dw.diffCount).Should(Equal(1))
+		Expect(dw.lastChanges()).To(Equal([]string{
+			"modify internalDataGroups " + InternalDataGroupName,
+			"modify l7Policies " + CFRoutingPolicyName,
+			"add pools " + bar,
+			"add virtualServers " + bar,
+		}))
+
+		update(routeUpdate.Add, "bar.cf.com", "127.0.0.2")
+		Eventually(dw.diffCount).Should(Equal(2))
+		Expect(dw.lastChanges()).To(Equal([]string{
+			"add nodes 127.0.0.2",
+			"modify pools " + bar,
+		}))
+
+		update(routeUpdate.Remove, "bar.cf.com", "127.0.0.2")
+		Eventually(dw.diffCount).Should(Equal(3))
+		Expect(dw.lastChanges()).To(Equal([]string{
+			"remove nodes 127.0.0.2",
+			"modify pools " + bar,
+		}))
+	})
+
+	Context("without incremental configs", func() {
+		BeforeEach(func() {
+			c.BigIP.IncrementalConfig = false
+		})
+
+		It("should write the full config", func() {
+			update(routeUpdate.Add, "foo.cf.com", "127.0.0.1")
+			Eventually(pools).Should(HaveLen(1))
+			fulls := dw.fullCount()
+			update(routeUpdate.Add, "bar.cf.com", "127.0.0.2")
+			Eventually(pools).Should(HaveLen(2))
+			Expect(dw.fullCount()).To(Equal(fulls + 1))
+			Expect(dw.diffCount()).To(BeZero())
+		})
+	})
+
+	Context("through the config handler", func() {
+		var handler *ConfigHandler
+
+		// served returns the resources of the config the handler serves
+		served := func() bigipResources.PartitionMap {
+			req, err := http.NewRequest("GET", ConfigEndpointPath, nil)
+			Expect(err).NotTo(HaveOccurred())
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			var config struct {
+				Resources bigipResources.PartitionMap `json:"resources"`
+			}
+			Expect(json.Unmarshal(rec.Body.Bytes(), &config)).To(Succeed())
+			return config.Resources
+		}
+
+		BeforeEach(func() {
+			handler = NewConfigHandler(logger, dw)
+			writer = handler
+		})
+
+		It("should pass the diffs through to a writer taking them", func() {
+			update(routeUpdate.Add, "foo.cf.com", "127.0.0.1")
+			Eventually(pools).Should(HaveLen(1))
+			fulls := dw.fullCount()
+
+			update(routeUpdate.Add, "bar.cf.com", "127.0.0.2")
+			Eventually(dw.diffCount).Should(Equal(1))
+			Expect(dw.fullCount()).To(Equal(fulls))
+			Eventually(func() int {
+				return len(served()["cf"].Pools)
+			}).Should(Equal(2))
+		})
+
+		Context("wrapping a writer not taking diffs", func() {
+			var mw *MockWriter
+
+			BeforeEach(func() {
+				mw = &MockWriter{}
+				handler = NewConfigHandler(logger, mw)
+				writer = handler
+			})
+
+			It("should serve the full config with the diffs applied", func() {
+				// A router writing full configs gives the expected resources
+				fc := *c
+				fc.BigIP.IncrementalConfig = false
+				fw := &MockWriter{}
+				full, err := NewF5Router(logger, &fc, fw, &fakeClient.FakeClient{})
+				Expect(err).NotTo(HaveOccurred())
+				stopFull := runRouter(full)
+				defer stopFull()
+
+				both := func(op routeUpdate.Operation, uri route.Uri, addr string) {
+					ru, err := NewUpdate(logger, op, uri, makeEndpoint(addr), "")
+					Expect(err).NotTo(HaveOccurred())
+					router.UpdateRoute(ru)
+					full.UpdateRoute(ru)
+				}
+				// expectServedConfig waits for the full config to hold the given
+				// number of members and checks the handler serves the same
+				expectServedConfig := func(members int) {
+					Eventually(func() int {
+						n := 0
+						for _, pool := range fw.getResources("cf").Pools {
+							n += len(pool.Members)
+						}
+						return n
+					}).Should(Equal(members))
+					Eventually(served).Should(Equal(fw.getInput().Resources))
+				}
+
+				both(routeUpdate.Add, "foo.cf.com", "127.0.0.1")
+				both(routeUpdate.Add, "bar.cf.com", "127.0.0.2")
+				both(routeUpdate.Add, "*.cf.com", "127.0.0.3")
+				expectServedConfig(3)
+				both(routeUpdate.Add, "foo.cf.com", "127.0.0.4")
+				expectServedConfig(4)
+				both(routeUpdate.Remove, "bar.cf.com", "127.0.0.2")
+				expectServedConfig(3)
+				both(routeUpdate.Remove, "foo.cf.com", "127.0.0.1")
+				both(routeUpdate.Remove, "foo.cf.com", "127.0.0.4")
+				expectServedConfig(1)
+				Expect(logger).To(Say(`f5router-config-written.*"diff":true`))
+
+				// A writer not taking diffs gets the full config
+				Expect(mw.getInput().Resources).To(Equal(served()))
+			})
+		})
+
+		It("should reject a diff without a config to apply it to", func() {
+			handler = NewConfigHandler(logger, &MockWriter{})
+			_, err := handler.WriteDiff([]byte(`{"bigip":{},"global":{},"changes":[]}`))
+			Expect(err).To(MatchError("no config to apply the diff to"))
+
+			_, err = handler.Write([]byte(`{"bigip":{},"global":{},"resources":{"cf":{}}}`))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = handler.WriteDiff([]byte(`{"bigip":{},"global":{},"changes":[` +
+				`{"op":"remove","partition":"cf","kind":"pools","name":"foo"}]}`))
+			Expect(err).To(MatchError("removed pools foo is not in partition cf"))
+		})
+	})
+
+	It("should order the changes", func() {
+		last := configObjects{
+			{"cf", "pools", "b"}:      []byte(`{"name":"b"}`),
+			{"cf", "pools", "c"}:      []byte(`{"name":"c"}`),
+			{"cf-apps", "pools", "a"}: []byte(`{"name":"a"}`),
+		}
+		current := configObjects{
+			{"cf", "pools", "a"}:          []byte(`{"name":"a"}`),
+			{"cf", "pools", "b"}:          []byte(`{"name":"b","balance":"ratio"}`),
+			{"cf", "pools", "c"}:          []byte(`{"name":"c"}`),
+			{"cf", "virtualServers", "a"}: []byte(`{"name":"a"}`),
+		}
+		data, err := json.Marshal(diffConfigObjects(last, current))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(MatchJSON(`[
+			{"op":"add","partition":"cf","kind":"pools","name":"a","object":{"name":"a"}},
+			{"op":"modify","partition":"cf","kind":"pools","name":"b","object":{"name":"b","balance":"ratio"}},
+			{"op":"add","partition":"cf","kind":"virtualServers","name":"a","object":{"name":"a"}},
+			{"op":"remove","partition":"cf-apps","kind":"pools","name":"a"}
+		]`))
+		Expect(diffConfigObjects(current, current)).To(BeEmpty())
+	})
+})
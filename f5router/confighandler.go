@@ -0,0 +1,153 @@
+/*-
+ * Copyright (c) 2018, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package f5router
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/F5Networks/cf-bigip-ctlr/logger"
+
+	"github.com/uber-go/zap"
+)
+
+const (
+	// ConfigEndpointPath path the generated config is served on
+	ConfigEndpointPath = "/config"
+)
+
+// ConfigHandler Writer which keeps the last written config and serves it
+// over HTTP so the driver can pull it instead of reading the config file
+type ConfigHandler struct {
+	sync.RWMutex
+	writer Writer
+	logger logger.Logger
+	config []byte
+	etag   string
+}
+
+// NewConfigHandler creates a config handler wrapping the provided writer
+func NewConfigHandler(logger logger.Logger, writer Writer) *ConfigHandler {
+	return &ConfigHandler{
+		writer: writer,
+		logger: logger,
+	}
+}
+
+// GetOutputFilename return config filename of the wrapped writer
+func (ch *ConfigHandler) GetOutputFilename() string {
+	if nil == ch.writer {
+		return ""
+	}
+	return ch.writer.GetOutputFilename()
+}
+
+// Write outputs the config to the wrapped writer and saves it for serving
+func (ch *ConfigHandler) Write(input []byte) (n int, err error) {
+	if nil != ch.writer {
+		n, err = ch.writer.Write(input)
+		if nil != err {
+			return n, err
+		}
+	} else {
+		n = len(input)
+	}
+
+	config := make([]byte, len(input))
+	copy(config, input)
+	ch.save(config)
+	return n, err
+}
+
+// WriteDiff applies the changes to the saved config, the wrapped writer gets
+// the diff when it takes diffs and the full config otherwise
+func (ch *ConfigHandler) WriteDiff(input []byte) (n int, err error) {
+	ch.RLock()
+	last := ch.config
+	ch.RUnlock()
+	if nil == last {
+		return 0, errors.New("no config to apply the diff to")
+	}
+	config, err := applyConfigDiff(last, input)
+	if nil != err {
+		return 0, err
+	}
+
+	if dw, ok := ch.writer.(DiffWriter); ok {
+		_, err = dw.WriteDiff(input)
+	} else if nil != ch.writer {
+		_, err = ch.writer.Write(config)
+	}
+	if nil != err {
+		return 0, err
+	}
+	ch.save(config)
+	return len(input), nil
+}
+
+// save keeps the config for serving
+func (ch *ConfigHandler) save(config []byte) {
+	sum := sha256.Sum256(config)
+	ch.Lock()
+	ch.config = config
+	ch.etag = fmt.Sprintf("\"%x\"", sum)
+	ch.Unlock()
+}
+
+// ETag returns the entity tag of the current config
+func (ch *ConfigHandler) ETag() string {
+	ch.RLock()
+	defer ch.RUnlock()
+	return ch.etag
+}
+
+// ServeHTTP returns the current config, or 304 when the ETag has not changed
+func (ch *ConfigHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	ch.RLock()
+	config := ch.config
+	etag := ch.etag
+	ch.RUnlock()
+
+	if nil == config {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+	if req.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if req.Method == http.MethodGet {
+		_, err := w.Write(config)
+		if nil != err {
+			ch.logger.Warn("f5router-config-handler-write-error", zap.Error(err))
+		}
+	}
+}
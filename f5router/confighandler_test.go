@@ -0,0 +1,121 @@
+/*-
+ * Copyright (c) 2018, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package f5router
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/F5Networks/cf-bigip-ctlr/test_util"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ConfigHandler", func() {
+	var (
+		logger *test_util.TestZapLogger
+		mw     *MockWriter
+		ch     *ConfigHandler
+	)
+
+	serve := func(method string, etag string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, ConfigEndpointPath, nil)
+		Expect(err).NotTo(HaveOccurred())
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rec := httptest.NewRecorder()
+		ch.ServeHTTP(rec, req)
+		return rec
+	}
+
+	BeforeEach(func() {
+		logger = test_util.NewTestZapLogger("config-handler-test")
+		mw = &MockWriter{}
+		ch = NewConfigHandler(logger, mw)
+	})
+
+	AfterEach(func() {
+		if nil != logger {
+			logger.Close()
+		}
+	})
+
+	It("should pass writes through to the wrapped writer", func() {
+		Expect(ch.GetOutputFilename()).To(Equal("mock-file"))
+
+		n, err := ch.Write([]byte(`{"global":{}}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n).To(Equal(13))
+		Expect(mw.input).To(Equal([]byte(`{"global":{}}`)))
+	})
+
+	It("should be unavailable until a config has been written", func() {
+		rec := serve("GET", "")
+		Expect(rec.Code).To(Equal(http.StatusServiceUnavailable))
+		Expect(rec.Header().Get("ETag")).To(BeEmpty())
+	})
+
+	It("should serve the config with an ETag", func() {
+		_, err := ch.Write([]byte(`{"global":{}}`))
+		Expect(err).NotTo(HaveOccurred())
+
+		rec := serve("GET", "")
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Header().Get("Content-Type")).To(Equal("application/json"))
+		Expect(rec.Header().Get("ETag")).To(Equal(ch.ETag()))
+		Expect(rec.Body.String()).To(Equal(`{"global":{}}`))
+
+		rec = serve("HEAD", "")
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Header().Get("ETag")).To(Equal(ch.ETag()))
+		Expect(rec.Body.Len()).To(Equal(0))
+	})
+
+	It("should return not modified when the ETag matches", func() {
+		_, err := ch.Write([]byte(`{"global":{}}`))
+		Expect(err).NotTo(HaveOccurred())
+		etag := ch.ETag()
+
+		rec := serve("GET", etag)
+		Expect(rec.Code).To(Equal(http.StatusNotModified))
+		Expect(rec.Header().Get("ETag")).To(Equal(etag))
+		Expect(rec.Body.Len()).To(Equal(0))
+
+		// Writing the same config keeps the same ETag
+		_, err = ch.Write([]byte(`{"global":{}}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ch.ETag()).To(Equal(etag))
+		rec = serve("GET", etag)
+		Expect(rec.Code).To(Equal(http.StatusNotModified))
+
+		// A new config changes the ETag
+		_, err = ch.Write([]byte(`{"global":{"log-level":"debug"}}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ch.ETag()).NotTo(Equal(etag))
+		rec = serve("GET", etag)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Body.String()).To(Equal(`{"global":{"log-level":"debug"}}`))
+	})
+
+	It("should only allow reads", func() {
+		rec := serve("POST", "")
+		Expect(rec.Code).To(Equal(http.StatusMethodNotAllowed))
+		Expect(rec.Header().Get("Allow")).To(Equal("GET, HEAD"))
+	})
+})
@@ -21,23 +21,34 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/F5Networks/cf-bigip-ctlr/logger"
 
 	"github.com/uber-go/zap"
 )
 
-// Writer interface to support unit testing
+// Writer interface to support unit testing, like io.Writer implementations
+// must not retain the input since the router reuses it for the next config
 type Writer interface {
 	GetOutputFilename() string
 	Write(input []byte) (n int, err error)
 }
 
+// DefaultCheckInterval how often the ConfigWriter checks the config file
+// still exists
+const DefaultCheckInterval = 5 * time.Second
+
 // ConfigWriter Writer instance to output configuration
 type ConfigWriter struct {
+	CheckInterval time.Duration
+
 	configFile string
 	logger     logger.Logger
+	mutex      sync.Mutex
+	lastConfig []byte
 }
 
 // Without a File interface unit testing becomes difficult,
@@ -60,8 +71,9 @@ func NewConfigWriter(logger logger.Logger) (*ConfigWriter, error) {
 	tmpfn := filepath.Join(dir, "config.json")
 
 	cw := &ConfigWriter{
-		configFile: tmpfn,
-		logger:     logger,
+		CheckInterval: DefaultCheckInterval,
+		configFile:    tmpfn,
+		logger:        logger,
 	}
 
 	logger.Info("f5router-configwriter-started",
@@ -82,8 +94,66 @@ func (cw *ConfigWriter) GetOutputFilename() string {
 	return cw.configFile
 }
 
-// Write creates file lock and outputs byte slice
+// Write creates file lock and outputs byte slice, the input is kept so the
+// config can be restored if the file is removed
 func (cw *ConfigWriter) Write(input []byte) (n int, err error) {
+	cw.mutex.Lock()
+	defer cw.mutex.Unlock()
+
+	if nil != cw.lastConfig && !cw.fileExists() {
+		cw.logger.Warn("f5router-configwriter-file-missing",
+			zap.String("file", cw.configFile))
+	}
+	n, err = cw.write(input)
+	if nil == err {
+		cw.lastConfig = append(cw.lastConfig[:0], input...)
+	}
+	return n, err
+}
+
+// Restore writes the last config again if the config file was removed,
+// returning true if the file was restored
+func (cw *ConfigWriter) Restore() (bool, error) {
+	cw.mutex.Lock()
+	defer cw.mutex.Unlock()
+
+	if nil == cw.lastConfig || cw.fileExists() {
+		return false, nil
+	}
+	cw.logger.Warn("f5router-configwriter-restoring-file",
+		zap.String("file", cw.configFile))
+	_, err := cw.write(cw.lastConfig)
+	if nil != err {
+		return false, err
+	}
+	return true, nil
+}
+
+// Run checks the config file on an interval and restores it if removed
+func (cw *ConfigWriter) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	ticker := time.NewTicker(cw.CheckInterval)
+	defer ticker.Stop()
+	close(ready)
+
+	for {
+		select {
+		case <-ticker.C:
+			_, err := cw.Restore()
+			if nil != err {
+				cw.logger.Error("f5router-configwriter-restore-error", zap.Error(err))
+			}
+		case <-signals:
+			return nil
+		}
+	}
+}
+
+func (cw *ConfigWriter) fileExists() bool {
+	_, err := os.Stat(cw.configFile)
+	return !os.IsNotExist(err)
+}
+
+func (cw *ConfigWriter) write(input []byte) (n int, err error) {
 	f, err := os.OpenFile(cw.configFile, os.O_WRONLY|os.O_CREATE, 0644)
 	if nil != err {
 		return n, err
@@ -22,11 +22,14 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/F5Networks/cf-bigip-ctlr/test_util"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gbytes"
+	"github.com/tedsuo/ifrit"
 )
 
 var _ = Describe("Configwriter", func() {
@@ -153,6 +156,81 @@ var _ = Describe("Configwriter", func() {
 			Expect(written).To(Equal(expected))
 		})
 
+		Context("when the config file is removed", func() {
+			var (
+				f    string
+				full []byte
+			)
+
+			BeforeEach(func() {
+				f = cw.GetOutputFilename()
+				full = []byte(`{"resources":{"cf":{}}}`)
+
+				_, err = cw.Write([]byte(`{"resources":{}}`))
+				Expect(err).NotTo(HaveOccurred())
+				_, err = cw.Write(full)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(os.Remove(f)).To(Succeed())
+			})
+
+			It("should restore the full config", func() {
+				restored, err := cw.Restore()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(restored).To(BeTrue())
+
+				written, err := ioutil.ReadFile(f)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(written).To(Equal(full))
+
+				restored, err = cw.Restore()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(restored).To(BeFalse())
+			})
+
+			It("should write the full config on the next write", func() {
+				next := []byte(`{"resources":{"cf":{"pools":[]}}}`)
+				_, err = cw.Write(next)
+				Expect(err).NotTo(HaveOccurred())
+
+				written, err := ioutil.ReadFile(f)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(written).To(Equal(next))
+				Eventually(logger).Should(Say("f5router-configwriter-file-missing"))
+			})
+
+			It("should not keep the caller's input", func() {
+				full[0] = 'x'
+
+				_, err = cw.Restore()
+				Expect(err).NotTo(HaveOccurred())
+				written, err := ioutil.ReadFile(f)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(written).To(Equal([]byte(`{"resources":{"cf":{}}}`)))
+			})
+
+			It("should restore the file while running", func() {
+				cw.CheckInterval = 10 * time.Millisecond
+				process := ifrit.Invoke(cw)
+				defer func() {
+					process.Signal(os.Interrupt)
+					Eventually(process.Wait()).Should(Receive(BeNil()))
+				}()
+
+				Eventually(f).Should(BeARegularFile())
+				written, err := ioutil.ReadFile(f)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(written).To(Equal(full))
+			})
+		})
+
+		It("should not restore before the first write", func() {
+			restored, err := cw.Restore()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(restored).To(BeFalse())
+			Expect(cw.GetOutputFilename()).NotTo(BeAnExistingFile())
+		})
+
 		Context("fail cases", func() {
 			It("should error when encountering a bad FD", func() {
 				// go does not have an idea of a File interface, doing the best
@@ -0,0 +1,59 @@
+/*-
+ * Copyright (c) 2018, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package f5router
+
+import (
+	"github.com/uber-go/zap"
+)
+
+// applyDefaultRoute points the routing virtuals at the pool of the configured
+// default route while the route is in the current route set, the configured
+// default pool takes its place otherwise. The virtuals are only changed when
+// the pool does, a missing route is reported on the first check and whenever
+// the route goes away.
+func (r *F5Router) applyDefaultRoute() {
+	if "" == r.c.BigIP.DefaultRoute {
+		return
+	}
+
+	pool := r.defaultPool
+	name := makeObjectName(r.c.BigIP.DefaultRoute)
+	if vs, ok := r.virtualResources[name]; ok && "" != vs.PoolName {
+		pool = vs.PoolName
+	}
+	if pool == r.appliedDefaultPool && r.defaultRouteChecked {
+		return
+	}
+	r.defaultRouteChecked = true
+
+	if pool == r.defaultPool {
+		r.logger.Warn("f5router-default-route-missing",
+			zap.String("route", r.c.BigIP.DefaultRoute),
+			zap.String("pool", pool),
+		)
+	} else {
+		r.logger.Info("f5router-default-route-applied",
+			zap.String("route", r.c.BigIP.DefaultRoute),
+			zap.String("pool", pool),
+		)
+	}
+	for _, vsName := range r.routingVirtuals {
+		r.virtualResources[vsName].PoolName = pool
+		r.cache.invalidate(virtualCacheKey(r.objectPartition(vsName), vsName))
+	}
+	r.appliedDefaultPool = pool
+}
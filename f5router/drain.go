@@ -0,0 +1,205 @@
+/*-
+ * Copyright (c) 2018, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package f5router
+
+import (
+	"os"
+	"time"
+
+	"github.com/F5Networks/cf-bigip-ctlr/f5router/bigipResources"
+
+	"github.com/uber-go/zap"
+)
+
+type (
+	// drainKey pool member being drained
+	drainKey struct {
+		pool    string
+		address string
+		port    uint16
+	}
+
+	// drainingMember removal waiting for the member to drain, seq tells the
+	// current drain apart from an earlier one of the same member
+	drainingMember struct {
+		ru  updateHTTP
+		seq uint64
+	}
+
+	// drainExpired work item queued once a member's drain timeout passes
+	drainExpired struct {
+		key drainKey
+		seq uint64
+	}
+
+	// shutdownDrain work item disabling the members of every pool before the
+	// router stops, written is closed after the next config write
+	shutdownDrain struct {
+		written chan struct{}
+	}
+
+	// ShutdownDrainer ifrit runner draining the router once it is signaled.
+	// Run it after the config writer and driver so it is stopped before them
+	// and the drained config still reaches the BIG-IP.
+	ShutdownDrainer struct {
+		router *F5Router
+	}
+)
+
+// drainMember disables a removed member and keeps it in its pool until the
+// drain timeout passes, false when the member is to be removed right away
+func (r *F5Router) drainMember(ru updateHTTP, pool *bigipResources.Pool) bool {
+	if 0 == r.c.BigIP.DrainTimeout {
+		return false
+	}
+	p, exists := r.poolResources[pool.Name]
+	if !exists {
+		return false
+	}
+	// Currently only a single update comes through at a time so we always
+	// know to look at the first addr
+	member := pool.Members[0]
+	for i := range p.Members {
+		if !sameMember(p.Members[i], member) {
+			continue
+		}
+		key := drainKey{pool: pool.Name, address: member.Address, port: member.Port}
+		if _, draining := r.drainingMembers[key]; draining {
+			return true
+		}
+		r.cache.invalidate(poolCacheKey(r.objectPartition(pool.Name), pool.Name))
+		p.Members[i].Session = "user-disabled"
+		p.Members[i].State = "user-down"
+
+		r.drainSeq++
+		r.drainingMembers[key] = drainingMember{ru: ru, seq: r.drainSeq}
+		timeout := time.Duration(r.c.BigIP.DrainTimeout) * time.Second
+		r.queue.AddAfter(drainExpired{key: key, seq: r.drainSeq}, timeout)
+		r.logger.Info("f5router-member-draining",
+			zap.String("route", ru.Route()),
+			zap.String("address", member.Address),
+			zap.Int("port", int(member.Port)),
+			zap.Duration("timeout", timeout),
+		)
+		return true
+	}
+	return false
+}
+
+// cancelDrain stops draining a member added back to its pool
+func (r *F5Router) cancelDrain(pool string, member bigipResources.Member) {
+	key := drainKey{pool: pool, address: member.Address, port: member.Port}
+	if _, draining := r.drainingMembers[key]; draining {
+		delete(r.drainingMembers, key)
+		r.logger.Info("f5router-member-drain-canceled",
+			zap.String("pool", pool),
+			zap.String("address", member.Address),
+			zap.Int("port", int(member.Port)),
+		)
+	}
+}
+
+// processDrainExpired removes a drained member, unless the drain was canceled
+// or replaced by a later one in the meantime
+func (r *F5Router) processDrainExpired(de drainExpired) {
+	drain, exists := r.drainingMembers[de.key]
+	if !exists || drain.seq != de.seq {
+		return
+	}
+	delete(r.drainingMembers, de.key)
+
+	ru := drain.ru
+	ru.partition = r.routePartition(ru.Name())
+	rs, err := ru.CreateResources(r.c)
+	if nil != err {
+		r.logger.Error("process-HTTP-route-remove-error", zap.Error(err))
+		return
+	}
+	r.logger.Info("f5router-member-drained",
+		zap.String("route", ru.Route()),
+		zap.String("address", de.key.address),
+		zap.Int("port", int(de.key.port)),
+	)
+	r.removeRoute(ru, rs)
+}
+
+// DrainForShutdown writes out a config with the members of every pool
+// disabled and waits the shutdown drain timeout for their connections to
+// finish. The router only drains once, later calls return right away.
+func (r *F5Router) DrainForShutdown() {
+	if 0 == r.c.BigIP.ShutdownDrainTimeout {
+		return
+	}
+	r.shutdownOnce.Do(func() {
+		timeout := time.Duration(r.c.BigIP.ShutdownDrainTimeout) * time.Second
+		deadline := time.After(timeout)
+		written := make(chan struct{})
+		r.queue.Add(shutdownDrain{written: written})
+
+		// The worker may already be gone, the wait is bounded by the timeout
+		select {
+		case <-written:
+			r.logger.Info("f5router-shutdown-drain-waiting", zap.Duration("timeout", timeout))
+		case <-deadline:
+			r.logger.Warn("f5router-shutdown-drain-not-written", zap.Duration("timeout", timeout))
+			return
+		}
+		<-deadline
+		r.logger.Info("f5router-shutdown-drained")
+	})
+}
+
+// processShutdownDrain disables the members of every pool, the pools are left
+// as they are from then on
+func (r *F5Router) processShutdownDrain(sd shutdownDrain) {
+	r.shuttingDown = true
+	r.shutdownWritten = sd.written
+	for name, pool := range r.poolResources {
+		r.cache.invalidate(poolCacheKey(r.objectPartition(name), name))
+		for i := range pool.Members {
+			pool.Members[i].Session = "user-disabled"
+			pool.Members[i].State = "user-down"
+		}
+	}
+	r.logger.Info("f5router-shutdown-draining", zap.Int("pools", len(r.poolResources)))
+}
+
+// skipForShutdown returns true for the updates which are not processed once
+// the pools are drained for shutdown
+func (r *F5Router) skipForShutdown(item interface{}) bool {
+	if !r.shuttingDown {
+		return false
+	}
+	switch item.(type) {
+	case retryWrite, shutdownDrain:
+		return false
+	}
+	return true
+}
+
+// NewShutdownDrainer creates the runner draining the router on shutdown
+func NewShutdownDrainer(router *F5Router) *ShutdownDrainer {
+	return &ShutdownDrainer{router: router}
+}
+
+// Run waits for a signal and drains the router
+func (sd *ShutdownDrainer) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	close(ready)
+	<-signals
+	sd.router.DrainForShutdown()
+	return nil
+}
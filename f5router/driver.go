@@ -18,12 +18,16 @@ package f5router
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"strings"
 	"sync/atomic"
 	"syscall"
+	"text/template"
+	"time"
 
 	"github.com/F5Networks/cf-bigip-ctlr/config"
 	"github.com/F5Networks/cf-bigip-ctlr/logger"
@@ -34,92 +38,160 @@ import (
 const (
 	// DefaultCmd default config driver
 	DefaultCmd = "bigipconfigdriver.py"
+	// DefaultPython interpreter running a driver script other than the default
+	DefaultPython = "python"
 )
 
 // Driver type which provides ifrit process interface
 type Driver struct {
 	fname     string
-	bigIP     config.BigIPConfig
 	driverCmd string
+	python    string
 	logger    logger.Logger
 	stopping  uint32
+	// BigIP certificate verification settings passed on the driver command
+	// line, the driver verifies the BIG-IP certificate by default
+	BigIP config.BigIPConfig
+	// Startup retries starting the driver, the driver is started once by
+	// default
+	Startup config.DriverStartupConfig
+	// Restart relaunches the driver when it exits with a failing status, the
+	// controller exits instead by default. With OnCleanExit set to restart a
+	// driver exiting normally is relaunched too, it stays stopped by default.
+	Restart        config.DriverRestartConfig
+	restarts       int
+	restartBackoff time.Duration
+	// Apply runs a command after each config write, in addition to the
+	// driver unless it replaces the driver
+	Apply        config.ApplyCommandConfig
+	applyPending chan struct{}
+	// pid of the running driver, 0 while it is not running
+	pid         int32
+	applyFailed int32
 }
 
-// NewDriver create ifrit process instance
+// NewDriver create ifrit process instance, the driver script is run by the
+// python interpreter unless it is the default driver and no interpreter is
+// given. The interpreter and script are checked up front so a missing one
+// fails the startup instead of the first driver run.
 func NewDriver(
 	configFile string,
 	driverCmd string,
+	python string,
 	logger logger.Logger,
-) *Driver {
-	return &Driver{
-		fname:     configFile,
-		driverCmd: driverCmd,
-		logger:    logger,
+) (*Driver, error) {
+	d := &Driver{
+		fname:        configFile,
+		driverCmd:    driverCmd,
+		python:       python,
+		logger:       logger,
+		applyPending: make(chan struct{}, 1),
+		BigIP:        config.BigIPConfig{SSLVerify: true},
 	}
+	err := d.validateCmd()
+	if nil != err {
+		return nil, err
+	}
+	return d, nil
 }
 
-func (d *Driver) createDriverCmd() *exec.Cmd {
-	var cmd *exec.Cmd
+// interpreter python interpreter running the driver script, empty when the
+// default driver is run directly
+func (d *Driver) interpreter() string {
+	if "" != d.python {
+		return d.python
+	}
+	if DefaultCmd == d.driverCmd {
+		return ""
+	}
+	return DefaultPython
+}
 
-	if d.driverCmd == DefaultCmd {
-		cmdArgs := []string{
-			"--config-file", d.fname,
-			"--ctlr-prefix", "cf",
+func (d *Driver) validateCmd() error {
+	python := d.interpreter()
+	if "" == python || DefaultCmd == d.driverCmd {
+		// The default driver is found on the PATH, also when it is handed to
+		// an interpreter
+		path, err := exec.LookPath(d.driverCmd)
+		if nil != err {
+			return fmt.Errorf("driver %s is not executable: %v", d.driverCmd, err)
 		}
-		cmd = exec.Command(d.driverCmd, cmdArgs...)
-	} else {
-		cmdName := "python"
-		cmdArgs := []string{
-			d.driverCmd,
-			"--config-file", d.fname,
-			"--ctlr-prefix", "cf",
+		if "" == python {
+			return nil
 		}
-		cmd = exec.Command(cmdName, cmdArgs...)
+		d.driverCmd = path
 	}
 
-	return cmd
+	_, err := exec.LookPath(python)
+	if nil != err {
+		return fmt.Errorf("driver python interpreter %s is not executable: %v", python, err)
+	}
+	info, err := os.Stat(d.driverCmd)
+	if nil != err {
+		return fmt.Errorf("driver script %s not found: %v", d.driverCmd, err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("driver script %s is a directory", d.driverCmd)
+	}
+	return nil
 }
 
-func (d *Driver) runBigIPDriver(
-	pid chan<- int,
-	done chan<- struct{},
-	cmd *exec.Cmd,
-) {
-	defer close(pid)
+func (d *Driver) createDriverCmd() *exec.Cmd {
+	cmdArgs := []string{
+		"--config-file", d.fname,
+		"--ctlr-prefix", "cf",
+	}
+	cmdArgs = append(cmdArgs, d.sslArgs()...)
+
+	python := d.interpreter()
+	if "" == python {
+		return exec.Command(d.driverCmd, cmdArgs...)
+	}
+	return exec.Command(python, append([]string{d.driverCmd}, cmdArgs...)...)
+}
+
+// sslArgs driver arguments for verifying the BIG-IP certificate
+func (d *Driver) sslArgs() []string {
+	if !d.BigIP.SSLVerify {
+		return []string{"--no-ssl-verify"}
+	}
+	args := []string{"--ssl-verify"}
+	if "" != d.BigIP.CACertFile {
+		args = append(args, "--ca-cert-file", d.BigIP.CACertFile)
+	}
+	return args
+}
 
+// startProcess starts the driver or apply command process, forwarding its
+// logging, the result of waiting on the process is sent on the returned
+// channel
+func (d *Driver) startProcess(cmd *exec.Cmd) (<-chan error, error) {
 	// the config driver python logging goes to stderr by default
 	cmdOut, err := cmd.StderrPipe()
 	if nil != err {
-		d.logger.Fatal("f5router-driver-pipe-failed", zap.Error(err))
+		return nil, err
 	}
 
 	err = cmd.Start()
 	if nil != err {
-		d.logger.Fatal("f5router-driver-failed-start", zap.Error(err))
-	}
-	d.logger.Info("f5router-driver-process-pid", zap.Int("pid", cmd.Process.Pid))
-
-	pid <- cmd.Process.Pid
-
-	scanOut := bufio.NewScanner(cmdOut)
-	for true {
-		if scanOut.Scan() {
-			if strings.Contains(scanOut.Text(), "DEBUG]") {
-				d.logger.Debug(scanOut.Text())
-			} else if strings.Contains(scanOut.Text(), "Warn]") {
-				d.logger.Warn(scanOut.Text())
-			} else if strings.Contains(scanOut.Text(), "ERROR]") {
-				d.logger.Error(scanOut.Text())
-			} else if strings.Contains(scanOut.Text(), "CRITICAL]") {
-				d.logger.Error(scanOut.Text())
-			} else {
-				d.logger.Info(scanOut.Text())
-			}
-		} else {
-			break
-		}
+		return nil, err
 	}
-	err = cmd.Wait()
+
+	exited := make(chan error, 1)
+	go func() {
+		scanOut := bufio.NewScanner(cmdOut)
+		for scanOut.Scan() {
+			d.logDriverLine(scanOut.Text())
+		}
+		exited <- cmd.Wait()
+	}()
+
+	return exited, nil
+}
+
+// driverExited logs the exit of a started driver, exiting unless the driver
+// was stopped
+func (d *Driver) driverExited(cmd *exec.Cmd, err error) {
 	var waitStatus syscall.WaitStatus
 	if exitError, ok := err.(*exec.ExitError); ok {
 		waitStatus = exitError.Sys().(syscall.WaitStatus)
@@ -146,25 +218,316 @@ func (d *Driver) runBigIPDriver(
 			zap.Int("exit-status", waitStatus.ExitStatus()),
 		)
 	}
+}
+
+// startWithRetry starts the driver, retrying with backoff when it fails to
+// start or exits within the stable period, the driver is only started once
+// when no retries are configured. It returns an error once the retries are
+// used up and false when signaled while starting.
+func (d *Driver) startWithRetry(
+	signals <-chan os.Signal,
+) (*exec.Cmd, <-chan error, bool, error) {
+	backoff := d.Startup.Backoff
+	for attempt := 0; ; attempt++ {
+		cmd := d.createDriverCmd()
+		exited, err := d.startProcess(cmd)
+		if nil == err {
+			d.logger.Info("f5router-driver-process-pid", zap.Int("pid", cmd.Process.Pid))
+			if attempt >= d.Startup.Retries {
+				return cmd, exited, true, nil
+			}
+			select {
+			case err = <-exited:
+				if nil == err {
+					err = errors.New("driver exited during startup")
+				}
+			case <-time.After(d.Startup.StablePeriod):
+				return cmd, exited, true, nil
+			case sig := <-signals:
+				atomic.StoreUint32(&d.stopping, 1)
+				cmd.Process.Signal(sig)
+				<-exited
+				return nil, nil, false, nil
+			}
+		}
 
-	close(done)
+		if attempt >= d.Startup.Retries {
+			d.logger.Error("f5router-driver-failed-start",
+				zap.Int("attempts", attempt+1),
+				zap.Error(err),
+			)
+			return nil, nil, false, fmt.Errorf(
+				"config driver failed to start after %d attempts: %v", attempt+1, err)
+		}
+		d.logger.Warn("f5router-driver-startup-retry",
+			zap.Int("attempt", attempt+1),
+			zap.Duration("backoff", backoff),
+			zap.Error(err),
+		)
+		select {
+		case <-time.After(backoff):
+		case <-signals:
+			return nil, nil, false, nil
+		}
+		backoff *= 2
+		if backoff > d.Startup.MaxBackoff {
+			backoff = d.Startup.MaxBackoff
+		}
+	}
+}
+
+// restartable is true for a driver which exited with a failing status, rather
+// than being signaled or exiting normally
+func restartable(err error) bool {
+	exitError, ok := err.(*exec.ExitError)
+	if !ok {
+		return false
+	}
+	waitStatus := exitError.Sys().(syscall.WaitStatus)
+	return !waitStatus.Signaled() && 0 != waitStatus.ExitStatus()
+}
+
+// restartOnExit is true when the driver is relaunched after exiting with err,
+// a failing status when restarts are configured and a normal exit when the
+// clean exit policy restarts the driver
+func (d *Driver) restartOnExit(err error) bool {
+	if nil == err {
+		return config.DRIVER_EXIT_RESTART == d.Restart.OnCleanExit
+	}
+	return 0 != d.Restart.MaxRestarts && restartable(err)
+}
+
+// restartDriver relaunches the driver after it exited, waiting the backoff
+// before each attempt. Only the restarts after a failure count against the
+// max restarts, a nil err is a normal exit. It returns an error once the
+// restarts are used up and false when signaled while waiting.
+func (d *Driver) restartDriver(
+	signals <-chan os.Signal,
+	err error,
+) (*exec.Cmd, <-chan error, bool, error) {
+	for {
+		if nil == err {
+			d.logger.Info("f5router-driver-restarting-after-exit",
+				zap.Duration("backoff", d.restartBackoff),
+			)
+		} else {
+			if d.restarts >= d.Restart.MaxRestarts {
+				d.logger.Error("f5router-driver-restarts-exhausted",
+					zap.Int("restarts", d.restarts),
+					zap.Error(err),
+				)
+				return nil, nil, false, fmt.Errorf(
+					"config driver failed after %d restarts: %v", d.restarts, err)
+			}
+			d.restarts++
+			d.logger.Warn("f5router-driver-restarting",
+				zap.Int("restart", d.restarts),
+				zap.Duration("backoff", d.restartBackoff),
+				zap.Error(err),
+			)
+		}
+		select {
+		case <-time.After(d.restartBackoff):
+		case <-signals:
+			return nil, nil, false, nil
+		}
+		d.restartBackoff *= 2
+		if d.restartBackoff > d.Restart.MaxBackoff {
+			d.restartBackoff = d.Restart.MaxBackoff
+		}
+
+		cmd := d.createDriverCmd()
+		var exited <-chan error
+		exited, err = d.startProcess(cmd)
+		if nil == err {
+			d.logger.Info("f5router-driver-process-pid", zap.Int("pid", cmd.Process.Pid))
+			return cmd, exited, true, nil
+		}
+	}
+}
+
+// ConfigWritten queues running the apply command after a config write, it has
+// the signature of the F5Router OnWrite callback. Writes made while the
+// command runs are applied together by its next run.
+func (d *Driver) ConfigWritten(output []byte, err error) {
+	if "" == d.Apply.Cmd || nil != err {
+		return
+	}
+	select {
+	case d.applyPending <- struct{}{}:
+	default:
+	}
+}
+
+// createApplyCmd renders the apply command template for the config file
+func (d *Driver) createApplyCmd() (*exec.Cmd, error) {
+	data := struct{ ConfigFile string }{ConfigFile: d.fname}
+	var args []string
+	for _, field := range strings.Fields(d.Apply.Cmd) {
+		tmpl, err := template.New("apply-command").Option("missingkey=error").Parse(field)
+		if nil != err {
+			return nil, err
+		}
+		var arg bytes.Buffer
+		if err = tmpl.Execute(&arg, data); nil != err {
+			return nil, err
+		}
+		args = append(args, arg.String())
+	}
+	if 0 == len(args) {
+		return nil, errors.New("empty apply command")
+	}
+	return exec.Command(args[0], args[1:]...), nil
+}
+
+// runApplyCmd runs the apply command once, stopping it after the timeout
+func (d *Driver) runApplyCmd() {
+	cmd, err := d.createApplyCmd()
+	if nil != err {
+		d.logger.Error("f5router-apply-command-invalid", zap.Error(err))
+		return
+	}
+	exited, err := d.startProcess(cmd)
+	if nil != err {
+		d.logger.Error("f5router-apply-command-failed-start", zap.Error(err))
+		return
+	}
+
+	select {
+	case err = <-exited:
+	case <-time.After(d.Apply.Timeout):
+		cmd.Process.Kill()
+		<-exited
+		err = fmt.Errorf("timed out after %s", d.Apply.Timeout)
+	}
+	if nil != err {
+		atomic.StoreInt32(&d.applyFailed, 1)
+		d.logger.Error("f5router-apply-command-failed",
+			zap.String("command", strings.Join(cmd.Args, " ")),
+			zap.Error(err),
+		)
+		return
+	}
+	atomic.StoreInt32(&d.applyFailed, 0)
+	d.logger.Info("f5router-apply-command-succeeded",
+		zap.String("command", strings.Join(cmd.Args, " ")),
+	)
+}
+
+// applyConfigs runs the apply command for the queued config writes until done
+// is closed
+func (d *Driver) applyConfigs(done <-chan struct{}) {
+	for {
+		select {
+		case <-d.applyPending:
+			d.runApplyCmd()
+		case <-done:
+			return
+		}
+	}
+}
+
+// Healthy reports whether the driver process is running and the last apply
+// command succeeded, only the apply command is checked when it replaces the
+// driver
+func (d *Driver) Healthy() bool {
+	if 1 == atomic.LoadInt32(&d.applyFailed) {
+		return false
+	}
+	if "" != d.Apply.Cmd && d.Apply.ReplaceDriver {
+		return true
+	}
+
+	pid := atomic.LoadInt32(&d.pid)
+	if 0 == pid {
+		return false
+	}
+	proc, err := os.FindProcess(int(pid))
+	if nil != err {
+		return false
+	}
+	// signal 0 checks the process still exists without signaling it
+	return nil == proc.Signal(syscall.Signal(0))
 }
 
 // Run start the F5Router configuration driver
 func (d *Driver) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
-	d.logger.Info("f5router-driver-starting")
+	if "" != d.Apply.Cmd {
+		applyDone := make(chan struct{})
+		defer close(applyDone)
+		go d.applyConfigs(applyDone)
 
-	pidCh := make(chan int)
-	done := make(chan struct{})
-	go d.runBigIPDriver(pidCh, done, d.createDriverCmd())
+		if d.Apply.ReplaceDriver {
+			close(ready)
+			d.logger.Info("f5router-driver-replaced-by-apply-command")
+			<-signals
+			d.logger.Info("f5router-driver-stopped")
+			return nil
+		}
+	}
 
-	pid := <-pidCh
+	d.logger.Info("f5router-driver-starting")
+
+	cmd, exited, started, err := d.startWithRetry(signals)
+	if nil != err {
+		return err
+	}
+	if !started {
+		d.logger.Info("f5router-driver-stopped")
+		return nil
+	}
 	close(ready)
 	d.logger.Info("f5router-driver-started")
 
-	sig := <-signals
+	d.restarts = 0
+	d.restartBackoff = d.Restart.Backoff
+	for {
+		var exitErr error
+		startedAt := time.Now()
+		atomic.StoreInt32(&d.pid, int32(cmd.Process.Pid))
+		select {
+		case sig := <-signals:
+			return d.stopDriver(cmd, exited, sig)
+		case exitErr = <-exited:
+		}
+		atomic.StoreInt32(&d.pid, 0)
+
+		if !d.restartOnExit(exitErr) {
+			d.driverExited(cmd, exitErr)
+			<-signals
+			d.logger.Info("f5router-driver-stopped")
+			return nil
+		}
+		if nil == exitErr {
+			d.driverExited(cmd, exitErr)
+		}
+		if time.Since(startedAt) >= d.Startup.StablePeriod {
+			d.restarts = 0
+			d.restartBackoff = d.Restart.Backoff
+		}
+
+		cmd, exited, started, err = d.restartDriver(signals, exitErr)
+		if nil != err {
+			return err
+		}
+		if !started {
+			d.logger.Info("f5router-driver-stopped")
+			return nil
+		}
+		d.logger.Info("f5router-driver-restarted")
+	}
+}
+
+// stopDriver forwards the signal to the running driver and waits for it to
+// exit
+func (d *Driver) stopDriver(
+	cmd *exec.Cmd,
+	exited <-chan error,
+	sig os.Signal,
+) error {
 	atomic.StoreUint32(&d.stopping, 1)
 
+	pid := cmd.Process.Pid
 	proc, err := os.FindProcess(pid)
 	if nil != err {
 		d.logger.Warn("f5router-driver-failed-finding-process", zap.Error(err))
@@ -179,7 +542,8 @@ func (d *Driver) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
 		)
 		return err
 	}
-	<-done
+	d.driverExited(cmd, <-exited)
+	atomic.StoreInt32(&d.pid, 0)
 	d.logger.Info("f5router-driver-stopped")
 
 	return nil
@@ -17,8 +17,15 @@
 package f5router
 
 import (
+	"errors"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"github.com/F5Networks/cf-bigip-ctlr/config"
 	"github.com/F5Networks/cf-bigip-ctlr/test_util"
 
 	. "github.com/onsi/ginkgo"
@@ -39,7 +46,9 @@ var _ = Describe("Python Driver", func() {
 			driverCmd := "../testdata/fake_driver.py"
 			fileName := "fake.json"
 			logger = test_util.NewTestZapLogger("driver-test")
-			driver = NewDriver(fileName, driverCmd, logger)
+			var err error
+			driver, err = NewDriver(fileName, driverCmd, "", logger)
+			Expect(err).NotTo(HaveOccurred())
 		})
 
 		AfterEach(func() {
@@ -77,4 +86,572 @@ var _ = Describe("Python Driver", func() {
 		})
 
 	})
+
+	Describe("driver command", func() {
+		var driver *Driver
+
+		BeforeEach(func() {
+			var err error
+			driver, err = NewDriver("fake.json", "../testdata/fake_driver.py", "", test_util.NewTestZapLogger("driver-test"))
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should verify the BIG-IP certificate by default", func() {
+			Expect(driver.createDriverCmd().Args).To(Equal([]string{
+				DefaultPython,
+				"../testdata/fake_driver.py",
+				"--config-file", "fake.json",
+				"--ctlr-prefix", "cf",
+				"--ssl-verify",
+			}))
+		})
+
+		It("should pass the certificate verification settings", func() {
+			driver.BigIP.CACertFile = "/etc/bigip-ca.pem"
+			args := driver.createDriverCmd().Args
+			Expect(args[6:]).To(Equal([]string{
+				"--ssl-verify", "--ca-cert-file", "/etc/bigip-ca.pem",
+			}))
+
+			driver.BigIP.SSLVerify = false
+			args = driver.createDriverCmd().Args
+			Expect(args[6:]).To(Equal([]string{"--no-ssl-verify"}))
+		})
+	})
+
+	Describe("driver interpreter", func() {
+		var logger *test_util.TestZapLogger
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "driver-test")
+			Expect(err).NotTo(HaveOccurred())
+			logger = test_util.NewTestZapLogger("driver-test")
+		})
+
+		AfterEach(func() {
+			if nil != logger {
+				logger.Close()
+			}
+			os.RemoveAll(dir)
+		})
+
+		It("should fail when the interpreter or script is missing", func() {
+			_, err := NewDriver("fake.json", "../testdata/fake_driver.py", filepath.Join(dir, "python3"), logger)
+			Expect(err).To(MatchError(HavePrefix("driver python interpreter " + filepath.Join(dir, "python3"))))
+
+			notExecutable := filepath.Join(dir, "python3")
+			Expect(ioutil.WriteFile(notExecutable, []byte("#!/bin/sh\n"), 0644)).To(Succeed())
+			_, err = NewDriver("fake.json", "../testdata/fake_driver.py", notExecutable, logger)
+			Expect(err).To(MatchError(HavePrefix("driver python interpreter " + notExecutable)))
+
+			_, err = NewDriver("fake.json", "../testdata/missing_driver.py", "", logger)
+			Expect(err).To(MatchError(HavePrefix("driver script ../testdata/missing_driver.py not found")))
+
+			_, err = NewDriver("fake.json", "../testdata", "", logger)
+			Expect(err).To(MatchError("driver script ../testdata is a directory"))
+		})
+
+		It("should run the driver with the configured interpreter", func() {
+			// The stub records its arguments before handing the script to the
+			// real interpreter
+			stub := filepath.Join(dir, "python3")
+			argsFile := filepath.Join(dir, "args")
+			Expect(ioutil.WriteFile(stub, []byte(
+				"#!/bin/sh\necho \"$@\" > "+argsFile+"\nexec python \"$@\"\n"), 0755)).To(Succeed())
+
+			driver, err := NewDriver("fake.json", "../testdata/fake_driver.py", stub, logger)
+			Expect(err).NotTo(HaveOccurred())
+
+			signals := make(chan os.Signal)
+			ready := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				driver.Run(signals, ready)
+			}()
+			Eventually(ready).Should(BeClosed())
+			Eventually(logger).Should(Say("f5router-driver-started"))
+			Eventually(func() string {
+				args, _ := ioutil.ReadFile(argsFile)
+				return string(args)
+			}).Should(HavePrefix("../testdata/fake_driver.py --config-file fake.json"))
+
+			signals <- os.Interrupt
+			Eventually(logger).Should(Say("f5router-driver-stopped"))
+		})
+	})
+
+	Describe("retrying driver startup", func() {
+		var logger *test_util.TestZapLogger
+		var driver *Driver
+		var signals chan os.Signal
+		var ready chan struct{}
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "driver-test")
+			Expect(err).NotTo(HaveOccurred())
+
+			signals = make(chan os.Signal)
+			ready = make(chan struct{})
+			logger = test_util.NewTestZapLogger("driver-test")
+			driver, err = NewDriver(filepath.Join(dir, "fake.json"), "../testdata/flaky_driver.py", "", logger)
+			Expect(err).NotTo(HaveOccurred())
+			driver.Startup = config.DriverStartupConfig{
+				Retries:      3,
+				Backoff:      10 * time.Millisecond,
+				MaxBackoff:   20 * time.Millisecond,
+				StablePeriod: 500 * time.Millisecond,
+			}
+		})
+
+		AfterEach(func() {
+			if nil != logger {
+				logger.Close()
+			}
+			os.RemoveAll(dir)
+		})
+
+		It("should retry until the driver stays up", func() {
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				Expect(driver.Run(signals, ready)).To(Succeed())
+				close(done)
+			}()
+
+			Eventually(logger).Should(Say(`"f5router-driver-startup-retry".*"attempt":1`))
+			Eventually(logger).Should(Say(`"f5router-driver-startup-retry".*"attempt":2`))
+			Consistently(ready, 300*time.Millisecond).ShouldNot(BeClosed())
+			Eventually(ready).Should(BeClosed())
+			Eventually(logger).Should(Say("f5router-driver-started"))
+
+			starts, err := ioutil.ReadFile(filepath.Join(dir, "fake.json.starts"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(starts)).To(Equal("3"))
+
+			signals <- os.Interrupt
+			Eventually(done).Should(BeClosed())
+			Expect(logger).To(Say("f5router-driver-stopped"))
+		})
+
+		It("should return an error once the retries are used up", func() {
+			driver.Startup.Retries = 1
+			// The interpreter goes away after the driver was created
+			driver.python = filepath.Join(dir, "python")
+
+			errs := make(chan error, 1)
+			go func() {
+				errs <- driver.Run(signals, ready)
+			}()
+
+			var err error
+			Eventually(errs).Should(Receive(&err))
+			Expect(err).To(MatchError(ContainSubstring("config driver failed to start after 2 attempts")))
+			Expect(logger).To(SatisfyAll(
+				Say(`"f5router-driver-startup-retry".*"attempt":1`),
+				Say(`"f5router-driver-failed-start".*"attempts":2`),
+			))
+			Expect(ready).NotTo(BeClosed())
+		})
+
+		It("should stop while waiting to retry", func() {
+			driver.Startup.Backoff = time.Minute
+			driver.Startup.MaxBackoff = time.Minute
+
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				Expect(driver.Run(signals, ready)).To(Succeed())
+				close(done)
+			}()
+
+			Eventually(logger).Should(Say("f5router-driver-startup-retry"))
+			signals <- os.Interrupt
+			Eventually(done).Should(BeClosed())
+			Expect(ready).NotTo(BeClosed())
+		})
+	})
+
+	Describe("restarting the driver", func() {
+		var logger *test_util.TestZapLogger
+		var driver *Driver
+		var signals chan os.Signal
+		var ready chan struct{}
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "driver-test")
+			Expect(err).NotTo(HaveOccurred())
+
+			signals = make(chan os.Signal)
+			ready = make(chan struct{})
+			logger = test_util.NewTestZapLogger("driver-test")
+			driver, err = NewDriver(filepath.Join(dir, "fake.json"), "../testdata/flaky_driver.py", "", logger)
+			Expect(err).NotTo(HaveOccurred())
+			driver.Startup.StablePeriod = time.Minute
+			driver.Restart = config.DriverRestartConfig{
+				MaxRestarts: 3,
+				Backoff:     10 * time.Millisecond,
+				MaxBackoff:  20 * time.Millisecond,
+			}
+		})
+
+		AfterEach(func() {
+			if nil != logger {
+				logger.Close()
+			}
+			os.RemoveAll(dir)
+		})
+
+		starts := func() string {
+			data, _ := ioutil.ReadFile(filepath.Join(dir, "fake.json.starts"))
+			return string(data)
+		}
+
+		It("should relaunch a driver which exits with a failing status", func() {
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				Expect(driver.Run(signals, ready)).To(Succeed())
+				close(done)
+			}()
+
+			Eventually(ready).Should(BeClosed())
+			Eventually(logger).Should(Say(`"f5router-driver-restarting".*"restart":1.*"backoff":10000000`))
+			Eventually(logger).Should(Say(`"f5router-driver-restarting".*"restart":2.*"backoff":20000000`))
+			Eventually(logger).Should(Say("f5router-driver-restarted"))
+			Eventually(starts).Should(Equal("3"))
+			Consistently(done, 200*time.Millisecond).ShouldNot(BeClosed())
+
+			signals <- os.Interrupt
+			Eventually(done).Should(BeClosed())
+			Expect(logger).To(SatisfyAll(
+				Say("f5router-driver-exited-normally"),
+				Say("f5router-driver-stopped"),
+			))
+		})
+
+		It("should return an error once the restarts are used up", func() {
+			driver.Restart.MaxRestarts = 1
+
+			errs := make(chan error, 1)
+			go func() {
+				errs <- driver.Run(signals, ready)
+			}()
+
+			var err error
+			Eventually(errs).Should(Receive(&err))
+			Expect(err).To(MatchError(ContainSubstring("config driver failed after 1 restarts")))
+			Expect(logger).To(Say("f5router-driver-restarts-exhausted"))
+			Expect(starts()).To(Equal("2"))
+		})
+
+		It("should count the restarts again after the driver ran stable", func() {
+			driver.Restart.MaxRestarts = 1
+			driver.Startup.StablePeriod = 0
+
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				Expect(driver.Run(signals, ready)).To(Succeed())
+				close(done)
+			}()
+
+			Eventually(starts).Should(Equal("3"))
+			Eventually(logger).Should(Say("f5router-driver-restarted"))
+			Expect(logger).NotTo(Say("f5router-driver-restarts-exhausted"))
+
+			signals <- os.Interrupt
+			Eventually(done).Should(BeClosed())
+		})
+
+		It("should stop while waiting to restart", func() {
+			driver.Restart.Backoff = time.Minute
+			driver.Restart.MaxBackoff = time.Minute
+
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				Expect(driver.Run(signals, ready)).To(Succeed())
+				close(done)
+			}()
+
+			Eventually(logger).Should(Say("f5router-driver-restarting"))
+			signals <- os.Interrupt
+			Eventually(done).Should(BeClosed())
+			Expect(logger).To(Say("f5router-driver-stopped"))
+			Expect(starts()).To(Equal("1"))
+		})
+	})
+
+	Describe("driver exiting normally", func() {
+		var logger *test_util.TestZapLogger
+		var driver *Driver
+		var signals chan os.Signal
+		var ready chan struct{}
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "driver-test")
+			Expect(err).NotTo(HaveOccurred())
+
+			signals = make(chan os.Signal)
+			ready = make(chan struct{})
+			logger = test_util.NewTestZapLogger("driver-test")
+			driver, err = NewDriver(filepath.Join(dir, "fake.json"), "../testdata/exiting_driver.py", "", logger)
+			Expect(err).NotTo(HaveOccurred())
+			driver.Startup.StablePeriod = time.Minute
+			driver.Restart = config.DriverRestartConfig{
+				Backoff:     10 * time.Millisecond,
+				MaxBackoff:  20 * time.Millisecond,
+				OnCleanExit: config.DRIVER_EXIT_STOP,
+			}
+		})
+
+		AfterEach(func() {
+			if nil != logger {
+				logger.Close()
+			}
+			os.RemoveAll(dir)
+		})
+
+		starts := func() string {
+			data, _ := ioutil.ReadFile(filepath.Join(dir, "fake.json.starts"))
+			return string(data)
+		}
+
+		run := func() chan struct{} {
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				Expect(driver.Run(signals, ready)).To(Succeed())
+				close(done)
+			}()
+			return done
+		}
+
+		It("should stay stopped by default", func() {
+			done := run()
+
+			Eventually(ready).Should(BeClosed())
+			Eventually(logger).Should(Say("f5router-driver-exited-normally"))
+			Consistently(starts, 200*time.Millisecond).Should(Equal("1"))
+			Expect(logger).NotTo(Say("f5router-driver-restarting"))
+			Expect(done).NotTo(BeClosed())
+
+			signals <- os.Interrupt
+			Eventually(done).Should(BeClosed())
+			Expect(logger).To(Say("f5router-driver-stopped"))
+		})
+
+		It("should relaunch the driver when set to restart", func() {
+			driver.Restart.OnCleanExit = config.DRIVER_EXIT_RESTART
+			done := run()
+
+			Eventually(ready).Should(BeClosed())
+			Eventually(logger).Should(Say(`"f5router-driver-restarting-after-exit".*"backoff":10000000`))
+			Eventually(logger).Should(Say(`"f5router-driver-restarting-after-exit".*"backoff":20000000`))
+			Eventually(logger).Should(Say("f5router-driver-restarted"))
+			Eventually(starts).Should(Equal("3"))
+			// Clean exits do not use up the restarts after failures
+			Expect(logger).NotTo(Say("f5router-driver-restarts-exhausted"))
+			Consistently(done, 200*time.Millisecond).ShouldNot(BeClosed())
+
+			signals <- os.Interrupt
+			Eventually(done).Should(BeClosed())
+			Expect(logger).To(Say("f5router-driver-stopped"))
+		})
+
+		It("should stop while waiting to relaunch", func() {
+			driver.Restart.OnCleanExit = config.DRIVER_EXIT_RESTART
+			driver.Restart.Backoff = time.Minute
+			driver.Restart.MaxBackoff = time.Minute
+			done := run()
+
+			Eventually(logger).Should(Say("f5router-driver-restarting-after-exit"))
+			signals <- os.Interrupt
+			Eventually(done).Should(BeClosed())
+			Expect(logger).To(Say("f5router-driver-stopped"))
+			Expect(starts()).To(Equal("1"))
+		})
+	})
+
+	Describe("reporting health", func() {
+		var logger *test_util.TestZapLogger
+		var driver *Driver
+		var signals chan os.Signal
+		var ready chan struct{}
+		var done chan struct{}
+		var dir string
+		var configFile string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "driver-test")
+			Expect(err).NotTo(HaveOccurred())
+			configFile = filepath.Join(dir, "fake.json")
+
+			signals = make(chan os.Signal)
+			ready = make(chan struct{})
+			done = make(chan struct{})
+			logger = test_util.NewTestZapLogger("driver-test")
+			driver, err = NewDriver(configFile, "../testdata/fake_driver.py", "", logger)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			signals <- os.Interrupt
+			Eventually(done).Should(BeClosed())
+			if nil != logger {
+				logger.Close()
+			}
+			os.RemoveAll(dir)
+		})
+
+		run := func() {
+			go func() {
+				defer GinkgoRecover()
+				driver.Run(signals, ready)
+				close(done)
+			}()
+			Eventually(ready).Should(BeClosed())
+		}
+
+		It("should be healthy while the driver runs", func() {
+			Expect(driver.Healthy()).To(BeFalse())
+			run()
+			Eventually(driver.Healthy).Should(BeTrue())
+
+			// The fake driver exits when it receives a signal
+			pid := int(atomic.LoadInt32(&driver.pid))
+			Expect(syscall.Kill(pid, syscall.SIGTERM)).To(Succeed())
+			Eventually(logger).Should(Say("f5router-driver-exited-normally"))
+			Eventually(driver.Healthy).Should(BeFalse())
+		})
+
+		It("should be unhealthy after the apply command fails", func() {
+			driver.Apply = config.ApplyCommandConfig{
+				Cmd:     "python ../testdata/fake_apply.py --file {{.ConfigFile}} --fail",
+				Timeout: 10 * time.Second,
+			}
+			run()
+			Eventually(driver.Healthy).Should(BeTrue())
+
+			driver.ConfigWritten([]byte("{}"), nil)
+			Eventually(logger).Should(Say("f5router-apply-command-failed"))
+			Expect(driver.Healthy()).To(BeFalse())
+		})
+
+		It("should only check the apply command when it replaces the driver", func() {
+			driver.Apply = config.ApplyCommandConfig{
+				Cmd:           "python ../testdata/fake_apply.py --file {{.ConfigFile}}",
+				ReplaceDriver: true,
+				Timeout:       10 * time.Second,
+			}
+			run()
+			Expect(driver.Healthy()).To(BeTrue())
+
+			driver.ConfigWritten([]byte("{}"), nil)
+			Eventually(logger).Should(Say("f5router-apply-command-succeeded"))
+			Expect(driver.Healthy()).To(BeTrue())
+		})
+	})
+
+	Describe("running an apply command", func() {
+		var logger *test_util.TestZapLogger
+		var driver *Driver
+		var signals chan os.Signal
+		var ready chan struct{}
+		var done chan struct{}
+		var dir string
+		var configFile string
+
+		applied := func() string {
+			data, _ := ioutil.ReadFile(configFile + ".applied")
+			return string(data)
+		}
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "driver-test")
+			Expect(err).NotTo(HaveOccurred())
+			configFile = filepath.Join(dir, "fake.json")
+
+			signals = make(chan os.Signal)
+			ready = make(chan struct{})
+			done = make(chan struct{})
+			logger = test_util.NewTestZapLogger("driver-test")
+			driver, err = NewDriver(configFile, "../testdata/fake_driver.py", "", logger)
+			Expect(err).NotTo(HaveOccurred())
+			driver.Apply = config.ApplyCommandConfig{
+				Cmd:           "python ../testdata/fake_apply.py --file {{.ConfigFile}}",
+				ReplaceDriver: true,
+				Timeout:       10 * time.Second,
+			}
+		})
+
+		AfterEach(func() {
+			signals <- os.Interrupt
+			Eventually(done).Should(BeClosed())
+			if nil != logger {
+				logger.Close()
+			}
+			os.RemoveAll(dir)
+		})
+
+		run := func() {
+			go func() {
+				defer GinkgoRecover()
+				Expect(driver.Run(signals, ready)).To(Succeed())
+				close(done)
+			}()
+			Eventually(ready).Should(BeClosed())
+		}
+
+		It("should run the command with the config path after a write", func() {
+			run()
+			Expect(logger).To(Say("f5router-driver-replaced-by-apply-command"))
+			Consistently(applied).Should(BeEmpty())
+
+			driver.ConfigWritten([]byte("{}"), nil)
+			Eventually(applied).Should(Equal(configFile + "\n"))
+			Eventually(logger).Should(SatisfyAll(
+				Say(`"log_level":1,.*"message":"applied .*fake\.json"`),
+				Say("f5router-apply-command-succeeded"),
+			))
+
+			driver.ConfigWritten([]byte("{}"), nil)
+			Eventually(applied).Should(Equal(configFile + "\n" + configFile + "\n"))
+		})
+
+		It("should not run the command after a failed write", func() {
+			run()
+			driver.ConfigWritten(nil, errors.New("disk full"))
+			Consistently(applied).Should(BeEmpty())
+		})
+
+		It("should log a failing command", func() {
+			driver.Apply.Cmd += " --fail"
+			run()
+			driver.ConfigWritten([]byte("{}"), nil)
+			Eventually(logger).Should(SatisfyAll(
+				Say("BIG-IP rejected the config"),
+				Say("f5router-apply-command-failed"),
+			))
+		})
+
+		It("should run the command in addition to the driver", func() {
+			driver.Apply.ReplaceDriver = false
+			run()
+			Eventually(logger).Should(Say("f5router-driver-started"))
+
+			driver.ConfigWritten([]byte("{}"), nil)
+			Eventually(applied).Should(Equal(configFile + "\n"))
+		})
+	})
 })
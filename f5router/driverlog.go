@@ -0,0 +1,84 @@
+/*-
+ * Copyright (c) 2018, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package f5router
+
+import (
+	"regexp"
+
+	"github.com/uber-go/zap"
+)
+
+// driverLogFormat python logging format of the driver,
+// "[<asctime> <name> <levelname>] <message>", scripts logging without a
+// formatter leave out the timestamp and logger name
+var driverLogFormat = regexp.MustCompile(
+	`^\[(?:(\d{4}-\d{2}-\d{2} [\d:,.]+) (\S+) )?([A-Z]+)\] ?(.*)$`)
+
+// driverLogLevels zap levels of the python logging levels, critical is logged
+// as an error since a fatal log would exit the controller
+var driverLogLevels = map[string]zap.Level{
+	"DEBUG":    zap.DebugLevel,
+	"INFO":     zap.InfoLevel,
+	"WARN":     zap.WarnLevel,
+	"WARNING":  zap.WarnLevel,
+	"ERROR":    zap.ErrorLevel,
+	"CRITICAL": zap.ErrorLevel,
+}
+
+type driverLogEntry struct {
+	level     zap.Level
+	timestamp string
+	name      string
+	message   string
+}
+
+// parseDriverLog splits a driver log line into its parts, false when the line
+// is not in the driver's log format
+func parseDriverLog(line string) (driverLogEntry, bool) {
+	m := driverLogFormat.FindStringSubmatch(line)
+	if nil == m {
+		return driverLogEntry{}, false
+	}
+	level, ok := driverLogLevels[m[3]]
+	if !ok {
+		return driverLogEntry{}, false
+	}
+	return driverLogEntry{
+		level:     level,
+		timestamp: m[1],
+		name:      m[2],
+		message:   m[4],
+	}, true
+}
+
+// logDriverLine logs a line of driver output at the level the driver logged
+// it with, lines not in the driver's log format are logged as info
+func (d *Driver) logDriverLine(line string) {
+	entry, ok := parseDriverLog(line)
+	if !ok {
+		d.logger.Info(line)
+		return
+	}
+	var fields []zap.Field
+	if "" != entry.timestamp {
+		fields = append(fields,
+			zap.String("timestamp", entry.timestamp),
+			zap.String("logger", entry.name),
+		)
+	}
+	d.logger.Log(entry.level, entry.message, fields...)
+}
@@ -0,0 +1,82 @@
+/*-
+ * Copyright (c) 2018, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package f5router
+
+import (
+	"regexp"
+
+	"github.com/F5Networks/cf-bigip-ctlr/test_util"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("driver log lines", func() {
+	var (
+		logger *test_util.TestZapLogger
+		driver *Driver
+	)
+
+	BeforeEach(func() {
+		logger = test_util.NewTestZapLogger("driver-test")
+		var err error
+		driver, err = NewDriver("fake.json", "../testdata/fake_driver.py", "", logger)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		if nil != logger {
+			logger.Close()
+		}
+	})
+
+	It("should log each level at the matching zap level", func() {
+		for _, l := range []struct {
+			line     string
+			logLevel string
+			message  string
+		}{
+			{"[2018-03-01 12:00:00,123 __main__ DEBUG] fetching config", "0", "fetching config"},
+			{"[2018-03-01 12:00:01,123 __main__ INFO] config applied", "1", "config applied"},
+			{"[2018-03-01 12:00:02,123 f5_cccl WARNING] pool has no members", "2", "pool has no members"},
+			{"[2018-03-01 12:00:03,123 __main__ ERROR] BIG-IP rejected the config", "3", "BIG-IP rejected the config"},
+			{"[2018-03-01 12:00:04,123 __main__ CRITICAL] lost the BIG-IP", "3", "lost the BIG-IP"},
+		} {
+			driver.logDriverLine(l.line)
+			Expect(logger).To(Say(
+				`"log_level":%s,.*"message":"%s".*"data":{"timestamp":"2018-03-01 12:00:0\d,123","logger":"\S+"}`,
+				l.logLevel, l.message))
+		}
+	})
+
+	It("should parse lines without a timestamp and logger name", func() {
+		driver.logDriverLine("[ERROR] BIG-IP is unreachable")
+		Expect(logger).To(Say(`"log_level":3,.*"message":"BIG-IP is unreachable","source":"driver-test","data":{}`))
+	})
+
+	It("should log malformed lines as info", func() {
+		for _, line := range []string{
+			"Traceback (most recent call last):",
+			"[2018-03-01 12:00:00,123 __main__ NOTICE] unknown level",
+			"pool [DEBUG] in the middle",
+		} {
+			driver.logDriverLine(line)
+			Expect(logger).To(Say(`"log_level":1,.*"message":"%s"`, regexp.QuoteMeta(line)))
+		}
+	})
+})
@@ -0,0 +1,78 @@
+/*-
+ * Copyright (c) 2018, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package f5router
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/F5Networks/cf-bigip-ctlr/logger"
+
+	"github.com/uber-go/zap"
+)
+
+// DryRunWriter Writer for dry runs, the config is logged instead of being
+// handed to the driver and is also written to a side file when one is set so
+// operators can diff the expected output
+type DryRunWriter struct {
+	file   string
+	logger logger.Logger
+}
+
+// NewDryRunWriter creates a dry run writer, an empty file only logs the config
+func NewDryRunWriter(logger logger.Logger, file string) *DryRunWriter {
+	return &DryRunWriter{
+		file:   file,
+		logger: logger,
+	}
+}
+
+// GetOutputFilename return the side file, empty when the config is only logged
+func (dw *DryRunWriter) GetOutputFilename() string {
+	return dw.file
+}
+
+// Write logs the config and replaces the side file with it
+func (dw *DryRunWriter) Write(input []byte) (n int, err error) {
+	sum := sha256.Sum256(input)
+	checksum := fmt.Sprintf("%x", sum)
+
+	if "" == dw.file {
+		dw.logger.Info("f5router-dry-run-config",
+			zap.String("checksum", checksum),
+			zap.String("config", string(input)),
+		)
+		return len(input), nil
+	}
+
+	tmp := dw.file + ".tmp"
+	err = ioutil.WriteFile(tmp, input, 0644)
+	if nil != err {
+		return 0, err
+	}
+	err = os.Rename(tmp, dw.file)
+	if nil != err {
+		return 0, err
+	}
+	dw.logger.Info("f5router-dry-run-config",
+		zap.String("checksum", checksum),
+		zap.String("file", dw.file),
+	)
+	return len(input), nil
+}
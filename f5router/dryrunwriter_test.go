@@ -0,0 +1,150 @@
+/*-
+ * Copyright (c) 2018, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package f5router
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	fakeClient "github.com/F5Networks/cf-bigip-ctlr/bigipclient/fakes"
+	"github.com/F5Networks/cf-bigip-ctlr/f5router/bigipResources"
+	"github.com/F5Networks/cf-bigip-ctlr/f5router/routeUpdate"
+	"github.com/F5Networks/cf-bigip-ctlr/route"
+	"github.com/F5Networks/cf-bigip-ctlr/test_util"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("DryRunWriter", func() {
+	var (
+		logger *test_util.TestZapLogger
+		dir    string
+	)
+
+	BeforeEach(func() {
+		logger = test_util.NewTestZapLogger("dry-run-test")
+		var err error
+		dir, err = ioutil.TempDir("", "dry-run-test")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		if nil != logger {
+			logger.Close()
+		}
+		os.RemoveAll(dir)
+	})
+
+	It("should log the config without a side file", func() {
+		dw := NewDryRunWriter(logger, "")
+		Expect(dw.GetOutputFilename()).To(BeEmpty())
+
+		n, err := dw.Write([]byte(`{"global":{}}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n).To(Equal(13))
+		Expect(logger).To(Say(`"message":"f5router-dry-run-config".*"config":"{\\"global\\":{}}"`))
+	})
+
+	It("should replace the side file with the config", func() {
+		file := filepath.Join(dir, "config.json")
+		dw := NewDryRunWriter(logger, file)
+		Expect(dw.GetOutputFilename()).To(Equal(file))
+
+		_, err := dw.Write([]byte(`{"global":{"a":1}}`))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = dw.Write([]byte(`{}`))
+		Expect(err).NotTo(HaveOccurred())
+
+		data, err := ioutil.ReadFile(file)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(data)).To(Equal(`{}`))
+		Expect(logger).To(Say(`"message":"f5router-dry-run-config".*"file":"%s"`, file))
+	})
+
+	It("should fail when the side file cannot be written", func() {
+		dw := NewDryRunWriter(logger, filepath.Join(dir, "missing", "config.json"))
+		_, err := dw.Write([]byte(`{}`))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should keep the router state for a later live write", func() {
+		file := filepath.Join(dir, "config.json")
+		mw := &MockWriter{}
+		sw := &switchWriter{writer: NewDryRunWriter(logger, file)}
+		router, err := NewF5Router(logger, makeConfig(), sw, &fakeClient.FakeClient{})
+		Expect(err).NotTo(HaveOccurred())
+		stop := runRouter(router)
+		defer stop()
+
+		update := func(uri route.Uri, addr string) {
+			ru, err := NewUpdate(logger, routeUpdate.Add, uri, makeEndpoint(addr), "")
+			Expect(err).NotTo(HaveOccurred())
+			router.UpdateRoute(ru)
+		}
+		update("foo.cf.com", "127.0.0.1")
+		update("bar.cf.com", "127.0.0.2")
+
+		Eventually(func() []*bigipResources.Pool {
+			data, err := ioutil.ReadFile(file)
+			if nil != err {
+				return nil
+			}
+			var written configMatcher
+			Expect(json.Unmarshal(data, &written)).To(Succeed())
+			if rs, ok := written.Resources["cf"]; ok {
+				return rs.Pools
+			}
+			return nil
+		}).Should(HaveLen(2))
+
+		// Switching to a live writer writes the routes seen during the dry run
+		sw.set(mw)
+		update("baz.cf.com", "127.0.0.3")
+		Eventually(func() []*bigipResources.Pool {
+			return mw.getResources("cf").Pools
+		}).Should(HaveLen(3))
+	})
+})
+
+// switchWriter lets a test swap the writer of a running router
+type switchWriter struct {
+	sync.Mutex
+	writer Writer
+}
+
+func (sw *switchWriter) set(w Writer) {
+	sw.Lock()
+	defer sw.Unlock()
+	sw.writer = w
+}
+
+func (sw *switchWriter) GetOutputFilename() string {
+	sw.Lock()
+	defer sw.Unlock()
+	return sw.writer.GetOutputFilename()
+}
+
+func (sw *switchWriter) Write(input []byte) (int, error) {
+	sw.Lock()
+	defer sw.Unlock()
+	return sw.writer.Write(input)
+}
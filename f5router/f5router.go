@@ -22,13 +22,16 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"net"
 	"net/url"
 	"os"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/F5Networks/cf-bigip-ctlr/bigipclient"
@@ -36,6 +39,7 @@ import (
 	"github.com/F5Networks/cf-bigip-ctlr/f5router/bigipResources"
 	"github.com/F5Networks/cf-bigip-ctlr/f5router/routeUpdate"
 	"github.com/F5Networks/cf-bigip-ctlr/logger"
+	"github.com/F5Networks/cf-bigip-ctlr/metrics"
 	"github.com/F5Networks/cf-bigip-ctlr/route"
 	"github.com/F5Networks/cf-bigip-ctlr/servicebroker/planResources"
 	"github.com/uber-go/zap"
@@ -49,12 +53,88 @@ const (
 	HTTPSRouterName = "routing-vip-https"
 	// CFRoutingPolicyName Policy name for CF routing
 	CFRoutingPolicyName = "cf-routing-policy"
+	// CFSNIRoutingPolicyName on BIG-IP, attached to the HTTPS virtual when
+	// routing on the TLS server name
+	CFSNIRoutingPolicyName = "cf-sni-routing-policy"
 	// InternalDataGroupName on BIG-IP
 	InternalDataGroupName = "cf-ctlr-data-group"
 	// BrokerDataGroupName on BIG-IP
 	BrokerDataGroupName = "cf-broker-data-group"
+	// RoutePolicySuffix suffix of a route's own policy name
+	RoutePolicySuffix = "-route-policy"
+	// MetadataRouteKey metadata key for the CF route GUID, read from the
+	// endpoint's RouteGUIDTag
+	MetadataRouteKey = "route"
+	// MetadataRouteURIKey metadata key for the route URI
+	MetadataRouteURIKey = "route_uri"
+	// RouteGUIDTag endpoint tag holding the CF route GUID
+	RouteGUIDTag = "route_guid"
+	// MetadataAppGUIDKey metadata key for the app GUID
+	MetadataAppGUIDKey = "app_guid"
+	// MetadataMaxHeaderSizeKey metadata key for the max header size of the
+	// HTTP profile
+	MetadataMaxHeaderSizeKey = "http_max_header_size"
+	// MetadataMaxHeaderCountKey metadata key for the max header count of the
+	// HTTP profile
+	MetadataMaxHeaderCountKey = "http_max_header_count"
+	// RatioMemberMode load balancing mode used for pools with weighted members
+	RatioMemberMode = "ratio-member"
+	// HTTPMonitorName on BIG-IP, shared by every HTTP route pool
+	HTTPMonitorName = "cf-http-monitor"
+	// HTTPSMonitorName on BIG-IP, shared by every HTTP route pool checked
+	// over TLS
+	HTTPSMonitorName = "cf-https-monitor"
 )
 
+// maxMemberRatio largest ratio BIG-IP accepts for a pool member
+const maxMemberRatio = 65535
+
+// maxPriorityGroup largest priority group BIG-IP accepts for a pool member
+const maxPriorityGroup = 65535
+
+// LoadBalancingModes are the BIG-IP pool load balancing modes accepted for
+// the configured default and per route overrides
+var LoadBalancingModes = []string{
+	"round-robin",
+	"ratio-member",
+	"ratio-node",
+	"ratio-session",
+	"ratio-least-connections-member",
+	"ratio-least-connections-node",
+	"least-connections-member",
+	"least-connections-node",
+	"least-sessions",
+	"weighted-least-connections-member",
+	"weighted-least-connections-node",
+	"fastest-node",
+	"fastest-app-response",
+	"observed-member",
+	"observed-node",
+	"predictive-member",
+	"predictive-node",
+	"dynamic-ratio-member",
+	"dynamic-ratio-node",
+}
+
+// routeIRuleSuffixes suffixes of the iRules created for a route from its plan
+var routeIRuleSuffixes = []string{
+	bigipResources.PoolDownIRuleSuffix,
+	bigipResources.SizeLimitIRuleSuffix,
+}
+
+// routeObjectSuffixes suffixes of the objects named after a route besides its
+// virtual and pool
+var routeObjectSuffixes = append([]string{RoutePolicySuffix}, routeIRuleSuffixes...)
+
+// partitionIRules shared iRules referenced by route virtuals, written to every
+// partition holding routes
+var partitionIRules = []string{
+	bigipResources.JsessionidIRuleName,
+	bigipResources.CookiePersistenceIRuleName,
+	bigipResources.SourceAddrPersistenceIRuleName,
+	bigipResources.FallbackPoolIRuleName,
+}
+
 // concurrent safe map of service broker plans
 type mutexPlansMap struct {
 	lock  sync.Mutex
@@ -71,11 +151,10 @@ type mutexBindIDRouteURIPlanNameMap struct {
 type tier2VSInfo struct {
 	// map of ip/ports that are currently in use
 	usedPorts map[string]*bigipResources.VirtualAddress
-	// ip/ports that were assigned but now ununsed, used before new ones are assigned
-	reapedPorts []*bigipResources.VirtualAddress
-	// port that will be assigned next for tier2 dest port
-	holderPort int32
-	// tier2 dest ip currently being assigned to the tier2 vips
+	// range of ports assigned for tier2 dest ports
+	minPort int32
+	maxPort int32
+	// first tier2 dest ip assigned to the tier2 vips
 	holderIP net.IP
 	// tier2 net the holderIP belongs to
 	ipNet *net.IPNet
@@ -106,13 +185,58 @@ type F5Router struct {
 	poolResources             map[string]*bigipResources.Pool
 	ruleResources             map[string]*bigipResources.IRule
 	monitorResources          map[string][]*bigipResources.Monitor
+	policyResources           map[string]*bigipResources.Policy
 	internalDataGroup         map[string]*bigipResources.InternalDataGroupRecord
 	tier2VSInfo               tier2VSInfo
 	firstSyncDone             bool
-	unmappedResourcesMap      map[string]bigipResources.Resources
+	unmappedPlans             map[string]planResources.Plan
+	routePartitions           map[string]string
+	monitorOnly               map[string]bool
+	externalPools             map[string]bool
+	scaleWatches              map[string]*scaleWatch
+	poolMemberWarnings        map[string]bool
+	drainingMembers           map[drainKey]drainingMember
+	pendingLock               sync.Mutex
+	pendingCond               *sync.Cond
+	disabledRoutes            map[string]bool
+	routingVirtuals           []string
+	defaultPool               string
+	appliedDefaultPool        string
+	defaultRouteChecked       bool
+	drainSeq                  uint64
+	shutdownOnce              sync.Once
+	shuttingDown              bool
+	shutdownWritten           chan struct{}
+	stateLock                 sync.RWMutex
 	plansMap                  mutexPlansMap
 	bindIDRouteURIPlanNameMap mutexBindIDRouteURIPlanNameMap
 	bigIPClient               bigipclient.Client
+	routePolicy               *bigipResources.Policy
+	sniPolicy                 *bigipResources.Policy
+	cache                     *resourceCache
+	output                    bytes.Buffer
+	writeFailed               int32
+	generation                uint64
+	writtenObjects            configObjects
+	pendingObjects            configObjects
+	pendingDiff               bool
+	writtenSum                [sha256.Size]byte
+	pendingSum                [sha256.Size]byte
+	onWrite                   func(output []byte, err error)
+	onScaleSignal             func(signal ScaleSignal)
+	onRouteEvent              func(event RouteEvent)
+	reporter                  metrics.RouterReporter
+	metrics                   *Metrics
+}
+
+// retryWrite work item queued to write out the config again after a failed
+// write
+type retryWrite struct{}
+
+// routeSet work item holding a complete set of desired routes, queued as a
+// pointer since the queue only accepts hashable types
+type routeSet struct {
+	routes []routeUpdate.RouteUpdate
 }
 
 func verifyRouteURI(ru updateHTTP) error {
@@ -123,6 +247,75 @@ func verifyRouteURI(ru updateHTTP) error {
 	return nil
 }
 
+// hostnameLabel a label of an RFC 1123 host name
+var hostnameLabel = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?$`)
+
+// validateMember checks a pool member address and port before they are
+// written, the address is an IPv4 or IPv6 address with an optional route
+// domain or a host name
+func validateMember(address string, port uint16) error {
+	if 0 == port {
+		return fmt.Errorf("invalid member port %d for %s", port, address)
+	}
+	ip, _ := splitIPWithRouteDomain(normalizeAddress(address))
+	if nil != net.ParseIP(ip) {
+		return nil
+	}
+	host := strings.TrimSuffix(address, ".")
+	labels := strings.Split(host, ".")
+	if 0 == len(host) || len(host) > 253 {
+		return fmt.Errorf("invalid member address %q", address)
+	}
+	for _, label := range labels {
+		if !hostnameLabel.MatchString(label) {
+			return fmt.Errorf("invalid member address %q", address)
+		}
+	}
+	// A numeric top level label is a malformed IPv4 address
+	if _, err := strconv.Atoi(labels[len(labels)-1]); nil == err {
+		return fmt.Errorf("invalid member address %q", address)
+	}
+	return nil
+}
+
+// makeMemberFQDN returns the FQDN node settings of a pool member on a host
+// name when FQDN members are enabled, nil for IP addresses
+func makeMemberFQDN(c *config.Config, address string) *bigipResources.MemberFQDN {
+	if !c.BigIP.FQDNMembers.Enabled {
+		return nil
+	}
+	ip, _ := splitIPWithRouteDomain(address)
+	if nil != net.ParseIP(ip) {
+		return nil
+	}
+	autopopulate := "disabled"
+	if c.BigIP.FQDNMembers.Autopopulate {
+		autopopulate = "enabled"
+	}
+	return &bigipResources.MemberFQDN{
+		Name:         strings.TrimSuffix(address, "."),
+		Autopopulate: autopopulate,
+	}
+}
+
+// makeMemberNode returns the name of the node a pool member on an IP address
+// references when shared nodes are enabled, empty otherwise. The node is named
+// after its address like the nodes the BIG-IP creates for members.
+func makeMemberNode(c *config.Config, address string) string {
+	if !c.BigIP.SharedNodes {
+		return ""
+	}
+	ip, _ := splitIPWithRouteDomain(address)
+	if nil == net.ParseIP(ip) {
+		return ""
+	}
+	return address
+}
+
+// makeObjectName returns the name of the BIG-IP objects for a route. The name
+// only depends on the route's URI so a route keeps the same name no matter the
+// order routes are added in, wildcard routes use the URI itself while the
+// others use its first label with the start of the URI's SHA256 sum.
 func makeObjectName(uri string) string {
 	var name string
 	if strings.HasPrefix(uri, "*.") {
@@ -131,9 +324,12 @@ func makeObjectName(uri string) string {
 		name = "cf-" + strings.Replace(uri, "*", "_", -1)
 	} else {
 		sum := sha256.Sum256([]byte(uri))
-		index := strings.Index(uri, ".")
+		label := uri
+		if index := strings.Index(uri, "."); -1 != index {
+			label = uri[:index]
+		}
 
-		name = fmt.Sprintf("cf-%s-%x", uri[:index], sum[:8])
+		name = fmt.Sprintf("cf-%s-%x", label, sum[:8])
 	}
 	return name
 }
@@ -161,6 +357,41 @@ func makeDescription(uri string, appID string) string {
 	return s
 }
 
+// Make the metadata entries for the configured keys, the route URI and app
+// GUID are known to the controller while the route GUID and anything else are
+// looked up in the endpoint tags (organization_name, space_name, app_name,
+// etc.)
+func makeMetadata(keys []string, uri string, ep *route.Endpoint) []*bigipResources.Metadata {
+	var metadata []*bigipResources.Metadata
+	for _, key := range keys {
+		var value string
+		switch key {
+		case MetadataRouteKey:
+			if nil != ep {
+				value = ep.Tags[RouteGUIDTag]
+			}
+		case MetadataRouteURIKey:
+			value = uri
+		case MetadataAppGUIDKey:
+			if nil != ep {
+				value = ep.ApplicationId
+			}
+		default:
+			if nil != ep {
+				value = ep.Tags[key]
+			}
+		}
+		if value == "" {
+			continue
+		}
+		metadata = append(metadata, &bigipResources.Metadata{
+			Name:  key,
+			Value: value,
+		})
+	}
+	return metadata
+}
+
 func generateProfileList(names []string, context string) ([]*bigipResources.ProfileRef, error) {
 	var refs []*bigipResources.ProfileRef
 	nameRefs, err := generateNameList(names)
@@ -214,21 +445,31 @@ func NewF5Router(
 		poolResources:             make(map[string]*bigipResources.Pool),
 		ruleResources:             make(map[string]*bigipResources.IRule),
 		monitorResources:          make(map[string][]*bigipResources.Monitor),
-		unmappedResourcesMap:      make(map[string]bigipResources.Resources),
+		policyResources:           make(map[string]*bigipResources.Policy),
+		unmappedPlans:             make(map[string]planResources.Plan),
+		routePartitions:           make(map[string]string),
+		monitorOnly:               make(map[string]bool),
+		externalPools:             make(map[string]bool),
+		scaleWatches:              make(map[string]*scaleWatch),
+		poolMemberWarnings:        make(map[string]bool),
+		drainingMembers:           make(map[drainKey]drainingMember),
+		disabledRoutes:            make(map[string]bool),
 		plansMap:                  mutexPlansMap{plans: make(map[string]planResources.Plan)},
 		bindIDRouteURIPlanNameMap: mutexBindIDRouteURIPlanNameMap{data: make(map[string]string)},
-		tier2VSInfo:               tier2VSInfo{usedPorts: make(map[string]*bigipResources.VirtualAddress), holderPort: 10000},
+		tier2VSInfo:               tier2VSInfo{usedPorts: make(map[string]*bigipResources.VirtualAddress), minPort: 10000, maxPort: 65535},
 		bigIPClient:               client,
+		cache:                     newResourceCache(),
 	}
+	r.pendingCond = sync.NewCond(&r.pendingLock)
 
 	err := r.validateConfig()
 	if nil != err {
 		return nil, err
 	}
 
-	err = r.writeInitialConfig()
-	if nil != err {
-		return nil, err
+	if 0 != c.BigIP.IdleTimeout {
+		r.initiRule(bigipResources.IdleTimeoutIRuleName,
+			bigipResources.MakeIdleTimeoutIRule(c.BigIP.IdleTimeout))
 	}
 
 	// Create the HTTP virtuals if we are not in TCP only mode
@@ -238,6 +479,21 @@ func NewF5Router(
 			return nil, err
 		}
 		r.initiRule(bigipResources.HTTPForwardingiRuleName, bigipResources.ForwardToVIPiRule)
+		if "" != c.BigIP.HTTPMonitor.Send {
+			r.initHTTPMonitor()
+		}
+		if "" != c.BigIP.Persistence.CookieName {
+			r.initiRule(bigipResources.CookiePersistenceIRuleName,
+				bigipResources.MakeCookiePersistenceIRule(c.BigIP.Persistence.CookieName))
+		}
+		if 0 != c.BigIP.Persistence.Timeout {
+			r.initiRule(bigipResources.SourceAddrPersistenceIRuleName,
+				bigipResources.MakeSourceAddrPersistenceIRule(c.BigIP.Persistence.Timeout))
+		}
+		if "" != c.BigIP.FallbackPool {
+			r.initiRule(bigipResources.FallbackPoolIRuleName,
+				bigipResources.MakeFallbackPoolIRule(c.BigIP.FallbackPool))
+		}
 	}
 
 	return &r, nil
@@ -292,7 +548,30 @@ func (r *F5Router) GetRouteURIFromBindID(bindID string) string {
 
 // Run start the F5Router controller
 func (r *F5Router) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
-	r.logger.Info("f5router-starting")
+	r.logger.Info("f5router-starting",
+		zap.Int("rate-limit", r.c.BigIP.RateLimit),
+		zap.String("snat", r.c.BigIP.SNAT.Type),
+		zap.Int("idle-timeout", r.c.BigIP.IdleTimeout),
+		zap.Bool("connection-mirroring", r.c.BigIP.ConnectionMirroring),
+	)
+	if _, ok := r.diffWriter(); r.c.BigIP.IncrementalConfig && !ok {
+		r.logger.Warn("f5router-incremental-config-unsupported",
+			zap.String("writer", r.writer.GetOutputFilename()),
+		)
+	}
+
+	// Log in for a token before anything is read from the BIG-IP, the driver
+	// reads the token file once it is started
+	var tokenRefresh *time.Timer
+	if r.tokenLogin() {
+		delay, err := r.refreshToken()
+		if nil != err {
+			return fmt.Errorf("failed logging in for a BIG-IP token: %v", err)
+		}
+		tokenRefresh = time.NewTimer(delay)
+		defer tokenRefresh.Stop()
+	}
+	r.checkConnectionMirroring()
 
 	// See if there is an existing data group on the BIG-IP, this is used to store
 	// our tier2 vip ip:port information so the controller doesn't end up in a bad
@@ -327,19 +606,49 @@ func (r *F5Router) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
 		}
 	}
 
+	// Write the initial config before the driver is started so it has a config
+	// to read, no routes have been processed yet
+	err = r.retryInitialConfig(signals)
+	if nil != err {
+		return err
+	}
+
 	done := make(chan struct{})
 	go r.runWorker(done)
 
 	close(ready)
 
 	r.logger.Info("f5router-started")
-	<-signals
-	r.queue.ShutDown()
+	r.waitForSignal(signals, tokenRefresh)
+	r.DrainForShutdown()
+	r.shutDownQueue()
 	<-done
 	r.logger.Info("f5router-exited")
 	return nil
 }
 
+// waitForSignal blocks until signaled, refreshing the token whenever the
+// refresh timer fires
+func (r *F5Router) waitForSignal(signals <-chan os.Signal, tokenRefresh *time.Timer) {
+	var refresh <-chan time.Time
+	if nil != tokenRefresh {
+		refresh = tokenRefresh.C
+	}
+	for {
+		select {
+		case <-signals:
+			return
+		case <-refresh:
+			delay, err := r.refreshToken()
+			if nil != err {
+				r.logger.Warn("f5router-token-refresh-error", zap.Error(err))
+				delay = tokenRetryInterval
+			}
+			tokenRefresh.Reset(delay)
+		}
+	}
+}
+
 func validateTier2Range(s string) (net.IP, *net.IPNet, error) {
 	var bits int
 	var ones int
@@ -391,23 +700,73 @@ func (r *F5Router) validateConfig() error {
 		return errors.New("no functional writer provided")
 	}
 
+	// The virtual server address and a single ExternalAddr stand in for each
+	// other so configs setting only one of them keep working
+	vs := &r.c.BigIP.VirtualServer
+	if "" == vs.Address && 1 == len(r.c.BigIP.ExternalAddr) {
+		vs.Address = r.c.BigIP.ExternalAddr[0]
+	}
+	if 0 == len(r.c.BigIP.ExternalAddr) && "" != vs.Address {
+		r.c.BigIP.ExternalAddr = config.AddressList{vs.Address}
+	}
+
 	if 0 == len(r.c.BigIP.URL) ||
-		0 == len(r.c.BigIP.User) ||
-		0 == len(r.c.BigIP.Pass) ||
 		0 == len(r.c.BigIP.Partitions) ||
 		0 == len(r.c.BigIP.ExternalAddr) {
 		return fmt.Errorf(
-			"required parameter missing; URL, User, Pass, Partitions, ExternalAddr, "+
-				"must have value: %+v", r.c.BigIP)
+			"required parameter missing; URL, Partitions, "+
+				"ExternalAddr or VirtualServer.Address, must have value: %+v", r.driverBigIP())
+	}
+
+	if err := validateSharedPartition(r.c.BigIP); nil != err {
+		return err
+	}
+
+	if err := resolveCredentials(&r.c.BigIP); nil != err {
+		return err
+	}
+	if err := validateAuth(r.c.BigIP); nil != err {
+		return err
 	}
 
-	// Verify the ExternalAddr provided is a valid IP address
+	if "" != r.c.BigIP.CACertFile {
+		if _, err := bigipclient.LoadCACerts(r.c.BigIP.CACertFile); nil != err {
+			return err
+		}
+	}
+
+	if 0 == vs.HTTPPort {
+		vs.HTTPPort = 80
+	}
+	if 0 == vs.HTTPSPort {
+		vs.HTTPSPort = 443
+	}
+	if vs.HTTPPort == vs.HTTPSPort {
+		return fmt.Errorf("virtual_server http_port and https_port must differ: %d",
+			vs.HTTPPort)
+	}
+
+	// Verify the addresses provided are valid IP addresses
 	va := &bigipResources.VirtualAddress{
-		BindAddr: r.c.BigIP.ExternalAddr,
-		Port:     int32(80),
+		BindAddr: vs.Address,
+		Port:     int32(vs.HTTPPort),
 	}
-	_, err := verifyDestAddress(va, r.c.BigIP.Partitions[0])
-	if nil != err {
+	if "" != vs.Address {
+		_, err := verifyDestAddress(va, r.c.BigIP.Partitions[0])
+		if nil != err {
+			return err
+		}
+	}
+	if err := validateExternalAddrs(r.c.BigIP.ExternalAddr); nil != err {
+		return err
+	}
+	// The virtuals are named after and written with the canonical addresses
+	vs.Address = normalizeAddress(vs.Address)
+	for i, addr := range r.c.BigIP.ExternalAddr {
+		r.c.BigIP.ExternalAddr[i] = normalizeAddress(addr)
+	}
+	if err := validateExternalMask(r.c.BigIP.ExternalMask,
+		append(r.routingAddresses(), r.c.BigIP.ExternalAddr...)); nil != err {
 		return err
 	}
 
@@ -417,204 +776,938 @@ func (r *F5Router) validateConfig() error {
 			fmt.Sprintf("tier2_ip_range not set in config using default: %s", config.DefaultTier2IPRange))
 	}
 
-	ipAddr, ipNet, err := validateTier2Range(r.c.BigIP.Tier2IPRange)
-	if nil != err {
+	if err := validateHTTPMonitor(r.c.BigIP.HTTPMonitor); nil != err {
 		return err
 	}
 
-	r.tier2VSInfo.holderIP = ipAddr
-	r.tier2VSInfo.ipNet = ipNet
+	if err := validatePersistence(r.c.BigIP.Persistence); nil != err {
+		return err
+	}
 
-	if 0 == len(r.c.BigIP.HealthMonitors) {
-		r.c.BigIP.HealthMonitors = []string{"/Common/tcp_half_open"}
+	if !isLoadBalancingMode(r.c.BigIP.LoadBalancingMode) {
+		return fmt.Errorf("invalid load_balancing_mode %s, allowed values are %s",
+			r.c.BigIP.LoadBalancingMode, LoadBalancingModes)
 	}
 
-	if 0 == len(r.c.BigIP.Profiles) {
-		r.c.BigIP.Profiles = []string{"/Common/http", "/Common/tcp"}
-	} else {
-		exist := checkForString(r.c.BigIP.Profiles, "/Common/tcp")
-		if !exist {
-			r.c.BigIP.Profiles = append(r.c.BigIP.Profiles, "/Common/tcp")
-		}
+	if r.c.BigIP.SNIRouting && 0 == len(r.c.BigIP.SSLProfiles) && "" == r.c.BigIP.DefaultClientSSL {
+		return errors.New("sni_routing requires ssl_profiles or default_client_ssl for the HTTPS virtual")
 	}
 
-	return nil
-}
+	if r.httpsRedirect() && 0 == len(r.c.BigIP.SSLProfiles) && "" == r.c.BigIP.DefaultClientSSL {
+		return errors.New("https_redirect requires ssl_profiles or default_client_ssl for the HTTPS virtual")
+	}
 
-func (r *F5Router) initiRule(name string, code string) {
-	iRule := bigipResources.IRule{
-		Name: name,
-		Code: code,
+	if r.c.BigIP.ConnectionLimit < 0 || r.c.BigIP.MaxConnectionLimit < 0 {
+		return fmt.Errorf("connection_limit and max_connection_limit must not be negative: %d, %d",
+			r.c.BigIP.ConnectionLimit, r.c.BigIP.MaxConnectionLimit)
+	}
+	if max := r.c.BigIP.MaxConnectionLimit; 0 != max && r.c.BigIP.ConnectionLimit > max {
+		return fmt.Errorf("connection_limit %d exceeds max_connection_limit %d",
+			r.c.BigIP.ConnectionLimit, max)
 	}
-	r.ruleResources[name] = &iRule
-}
 
-func (r *F5Router) createHTTPVirtuals() error {
-	plcs, err := generateNameList(r.c.BigIP.Policies)
-	if err != nil {
-		r.logger.Warn("f5router-skipping-policy-names", zap.Error(err))
+	if err := validateRouteFilter(r.c); nil != err {
+		return err
 	}
-	plcs = append(plcs, &bigipResources.NameRef{
-		Name:      CFRoutingPolicyName,
-		Partition: r.c.BigIP.Partitions[0], // FIXME handle multiple partitions
-	})
-	prfls, err := generateProfileList(r.c.BigIP.Profiles, "all")
-	if err != nil {
-		r.logger.Warn("f5router-skipping-profile-names", zap.Error(err))
+
+	if err := validateSNAT(r.c.BigIP.SNAT); nil != err {
+		return err
 	}
-	iRulePath, err := joinBigipPath(r.c.BigIP.Partitions[0], bigipResources.HTTPForwardingiRuleName)
-	if nil != err {
+
+	if err := validateVLANs(r.c.BigIP.VLANs); nil != err {
 		return err
 	}
-	iRule := []string{iRulePath}
 
-	if r.c.SessionPersistence {
-		r.initiRule(bigipResources.JsessionidIRuleName, bigipResources.JsessionidIRule)
+	if err := validateWAF(r.c.BigIP.WAF); nil != err {
+		return err
+	}
+
+	if err := validateReencrypt(r.c.BigIP.Reencrypt); nil != err {
+		return err
 	}
 
-	srcAddrTrans := bigipResources.SourceAddrTranslation{Type: "automap"}
+	if r.c.BigIP.FQDNMembers.Autopopulate && !r.c.BigIP.FQDNMembers.Enabled {
+		return errors.New("fqdn_members autopopulate requires fqdn_members enabled")
+	}
 
-	va := &bigipResources.VirtualAddress{
-		BindAddr: r.c.BigIP.ExternalAddr,
-		Port:     80,
+	if r.c.BigIP.RateLimit < 0 {
+		return fmt.Errorf("rate_limit must not be negative: %d", r.c.BigIP.RateLimit)
+	}
+
+	if r.c.BigIP.MinActiveMembers < 0 || r.c.BigIP.MinActiveMembers > maxPriorityGroup {
+		return fmt.Errorf("min_active_members must be between 0 and %d: %d",
+			maxPriorityGroup, r.c.BigIP.MinActiveMembers)
+	}
+
+	if r.c.BigIP.PoolMemberWarning < 0 {
+		return fmt.Errorf("pool_member_warning must not be negative: %d", r.c.BigIP.PoolMemberWarning)
+	}
+
+	if r.c.BigIP.DrainTimeout < 0 {
+		return fmt.Errorf("drain_timeout must not be negative: %d", r.c.BigIP.DrainTimeout)
+	}
+
+	if r.c.BigIP.ShutdownDrainTimeout < 0 {
+		return fmt.Errorf("shutdown_drain_timeout must not be negative: %d",
+			r.c.BigIP.ShutdownDrainTimeout)
+	}
+
+	if r.c.BigIP.MaxPendingUpdates < 0 {
+		return fmt.Errorf("max_pending_updates must not be negative: %d",
+			r.c.BigIP.MaxPendingUpdates)
 	}
-	dest, err := verifyDestAddress(va, r.c.BigIP.Partitions[0])
+
+	ipAddr, ipNet, err := validateTier2Range(r.c.BigIP.Tier2IPRange)
 	if nil != err {
 		return err
 	}
 
-	r.virtualResources[HTTPRouterName] = &bigipResources.Virtual{
-		VirtualServerName:     HTTPRouterName,
-		Mode:                  "tcp",
-		Enabled:               true,
-		Destination:           dest,
-		Policies:              plcs,
-		Profiles:              prfls,
-		IRules:                iRule,
-		SourceAddrTranslation: srcAddrTrans,
+	r.tier2VSInfo.holderIP = ipAddr
+	r.tier2VSInfo.ipNet = ipNet
+
+	if 0 == len(r.c.BigIP.HealthMonitors) {
+		r.c.BigIP.HealthMonitors = []string{"/Common/tcp_half_open"}
+	}
+
+	if r.c.BigIP.IdleTimeout < 0 {
+		return fmt.Errorf("idle_timeout must not be negative: %d", r.c.BigIP.IdleTimeout)
 	}
 
-	if 0 != len(r.c.BigIP.SSLProfiles) {
-		sslProfiles, err := generateProfileList(r.c.BigIP.SSLProfiles, "clientside")
-		if err != nil {
-			r.logger.Warn("f5router-skipping-sslProfile-names", zap.Error(err))
+	if "" != r.c.BigIP.RequestLoggingProfile {
+		_, err := generateNameList([]string{r.c.BigIP.RequestLoggingProfile})
+		if nil != err {
+			return fmt.Errorf("invalid request_logging_profile: %v", err)
 		}
-		prfls = append(prfls, sslProfiles...)
+	}
 
-		va := &bigipResources.VirtualAddress{
-			BindAddr: r.c.BigIP.ExternalAddr,
-			Port:     443,
-		}
-		dest, err := verifyDestAddress(va, r.c.BigIP.Partitions[0])
+	if "" != r.c.BigIP.OneConnectProfile {
+		_, err := generateNameList([]string{r.c.BigIP.OneConnectProfile})
 		if nil != err {
-			return err
+			return fmt.Errorf("invalid oneconnect_profile: %v", err)
 		}
+	}
 
-		r.virtualResources[HTTPSRouterName] = &bigipResources.Virtual{
-			VirtualServerName:     HTTPSRouterName,
-			Mode:                  "tcp",
-			Enabled:               true,
-			Destination:           dest,
-			Policies:              plcs,
-			Profiles:              prfls,
-			IRules:                iRule,
-			SourceAddrTranslation: srcAddrTrans,
+	if err := validateHTTPProfile(r.c.BigIP.HTTPProfile); nil != err {
+		return err
+	}
+	httpProfile := "/Common/http"
+	if "" != r.c.BigIP.HTTPProfile.Name {
+		httpProfile = r.c.BigIP.HTTPProfile.Name
+		for i, name := range r.c.BigIP.Profiles {
+			if "/Common/http" == name {
+				r.c.BigIP.Profiles[i] = httpProfile
+			}
 		}
 	}
-	return nil
-}
 
-func (r *F5Router) writeInitialConfig() error {
-	sections := make(map[string]interface{})
-	sections["global"] = bigipResources.GlobalConfig{
-		LogLevel:       r.c.Logging.Level,
-		VerifyInterval: r.c.BigIP.VerifyInterval,
+	if "" != r.c.BigIP.FallbackPool {
+		_, err := generateNameList([]string{r.c.BigIP.FallbackPool})
+		if nil != err {
+			return fmt.Errorf("invalid fallback_pool: %v", err)
+		}
 	}
-	sections["bigip"] = r.c.BigIP
 
-	output, err := json.Marshal(sections)
-	if nil != err {
-		return fmt.Errorf("failed marshaling initial config: %v", err)
+	if "" != r.c.BigIP.DefaultRoute {
+		if config.TCP == r.c.RoutingMode {
+			return errors.New("default_route requires HTTP routing")
+		}
+		if strings.Count(r.c.BigIP.DefaultRoute, "*") > 1 {
+			return fmt.Errorf("invalid default_route: %s multiple wildcards are not supported",
+				r.c.BigIP.DefaultRoute)
+		}
+		// The default pool takes the place of a missing default route, it is
+		// not skipped like a lone default pool would be
+		if "" != r.c.BigIP.DefaultPool {
+			_, err := generateNameList([]string{r.c.BigIP.DefaultPool})
+			if nil != err {
+				return fmt.Errorf("invalid default_pool for the default_route: %v", err)
+			}
+		}
 	}
-	n, err := r.writer.Write(output)
-	if nil != err {
-		return fmt.Errorf("failed writing initial config: %v", err)
-	} else if len(output) != n {
-		return fmt.Errorf("short write from initial config")
+
+	tcpProfile := "/Common/tcp"
+	if "" != r.c.BigIP.TCPProfile {
+		_, err := generateNameList([]string{r.c.BigIP.TCPProfile})
+		if nil != err {
+			return fmt.Errorf("invalid tcp_profile: %v", err)
+		}
+		tcpProfile = r.c.BigIP.TCPProfile
+		for i, name := range r.c.BigIP.Profiles {
+			if "/Common/tcp" == name {
+				r.c.BigIP.Profiles[i] = tcpProfile
+			}
+		}
+	}
+
+	if 0 == len(r.c.BigIP.Profiles) {
+		r.c.BigIP.Profiles = []string{httpProfile, tcpProfile}
+	} else {
+		exist := checkForString(r.c.BigIP.Profiles, tcpProfile)
+		if !exist {
+			r.c.BigIP.Profiles = append(r.c.BigIP.Profiles, tcpProfile)
+		}
 	}
 
 	return nil
 }
 
-func (r *F5Router) runWorker(done chan<- struct{}) {
-	r.logger.Debug("f5router-starting-worker")
-	for r.process() {
+// validateExternalAddrs checks the external addresses are valid IP addresses
+// and each is only given once
+func validateExternalAddrs(addrs config.AddressList) error {
+	seen := make(map[string]bool)
+	for _, addr := range addrs {
+		_, err := verifyDestAddress(&bigipResources.VirtualAddress{BindAddr: addr}, "")
+		if nil != err {
+			return err
+		}
+		key := normalizeAddress(addr)
+		if seen[key] {
+			return fmt.Errorf("duplicate external_addr: %s", addr)
+		}
+		seen[key] = true
 	}
-	r.logger.Debug("f5router-stopping-worker")
-	close(done)
+	return nil
 }
 
-func (r *F5Router) createPolicies(pm bigipResources.PartitionMap, partition string, wg *sync.WaitGroup) {
-	defer wg.Done()
-	if len(r.wildcards) != 0 || len(r.r) != 0 {
-		pm[partition].Policies = bigipResources.Policies{
-			r.makeRoutePolicy(CFRoutingPolicyName),
+// validateExternalMask checks the external mask is a contiguous netmask of
+// the address family of each address which leaves none of its host bits set
+func validateExternalMask(mask string, addrs []string) error {
+	if "" == mask {
+		return nil
+	}
+	ip := net.ParseIP(mask)
+	if nil == ip {
+		return fmt.Errorf("invalid external_mask %s", mask)
+	}
+	for _, addr := range addrs {
+		a := net.ParseIP(addr)
+		var m net.IPMask
+		if nil != a.To4() {
+			if nil == ip.To4() {
+				return fmt.Errorf("external_mask %s is not an IPv4 mask for %s", mask, addr)
+			}
+			m = net.IPMask(ip.To4())
+		} else {
+			if nil != ip.To4() {
+				return fmt.Errorf("external_mask %s is not an IPv6 mask for %s", mask, addr)
+			}
+			m = net.IPMask(ip.To16())
+		}
+		if _, bits := m.Size(); 0 == bits {
+			return fmt.Errorf("invalid external_mask %s, the mask bits must be contiguous", mask)
+		}
+		if !a.Mask(m).Equal(a) {
+			return fmt.Errorf("external_addr %s has host bits set outside external_mask %s", addr, mask)
 		}
 	}
+	return nil
 }
 
-func (r *F5Router) createVirtuals(pm bigipResources.PartitionMap, partition string, wg *sync.WaitGroup) {
-	defer wg.Done()
-
-	for _, virtual := range r.virtualResources {
-		pm[partition].Virtuals = append(pm[partition].Virtuals, virtual)
+// routingAddresses returns the addresses of the HTTP and HTTPS routing
+// virtuals, the virtual server address replaces the external addresses
+func (r *F5Router) routingAddresses() []string {
+	if "" != r.c.BigIP.VirtualServer.Address {
+		return []string{r.c.BigIP.VirtualServer.Address}
 	}
+	return r.c.BigIP.ExternalAddr
 }
 
-func (r *F5Router) createPools(pm bigipResources.PartitionMap, partition string, wg *sync.WaitGroup) {
-	defer wg.Done()
-
-	for _, pool := range r.poolResources {
-		pm[partition].Pools = append(pm[partition].Pools, pool)
+// addressVirtualName returns the name of the virtual on the i-th of several
+// addresses, the virtual on the first address keeps the plain name
+func addressVirtualName(name string, i int, addr string) string {
+	if 0 == i {
+		return name
 	}
+	return name + "-" + addressNameReplacer.Replace(addr)
 }
 
-func (r *F5Router) createiRules(pm bigipResources.PartitionMap, partition string, wg *sync.WaitGroup) {
-	defer wg.Done()
+// addressNameReplacer replaces the characters of an address BIG-IP does not
+// accept in object names
+var addressNameReplacer = strings.NewReplacer(":", ".", "%", "_")
 
-	for _, rule := range r.ruleResources {
-		pm[partition].IRules = append(pm[partition].IRules, rule)
-	}
+// httpsRedirect returns true when routes may be redirected to HTTPS, for
+// every route or the routes tagged for it
+func (r *F5Router) httpsRedirect() bool {
+	return r.c.BigIP.HTTPSRedirect.Enabled || "" != r.c.BigIP.HTTPSRedirect.Tag
 }
 
-func (r *F5Router) createMonitors(pm bigipResources.PartitionMap, partition string, wg *sync.WaitGroup) {
-	defer wg.Done()
+// makeTCPProfile returns the TCP profile of the generated virtuals, the
+// configured tcp_profile replaces the BIG-IP default
+func makeTCPProfile(c *config.Config) *bigipResources.ProfileRef {
+	if "" != c.BigIP.TCPProfile {
+		refs, err := generateProfileList([]string{c.BigIP.TCPProfile}, "all")
+		if nil == err {
+			return refs[0]
+		}
+	}
+	return &bigipResources.ProfileRef{
+		Name:      "tcp",
+		Partition: "Common",
+		Context:   "all",
+	}
+}
 
-	for _, monitors := range r.monitorResources {
-		for _, monitor := range monitors {
-			var found bool
-			for _, addedMonitor := range pm[partition].Monitors {
-				if addedMonitor.Name == monitor.Name {
-					found = true
-					break
-				}
-			}
-			if !found {
-				pm[partition].Monitors = append(pm[partition].Monitors, monitor)
-			}
+// makeHTTPProfiles returns the configured optional profiles of the HTTP
+// virtuals, the OneConnect profile before the request logging profile
+func makeHTTPProfiles(c *config.Config) []*bigipResources.ProfileRef {
+	var paths []string
+	for _, path := range []string{
+		c.BigIP.OneConnectProfile,
+		c.BigIP.RequestLoggingProfile,
+	} {
+		if "" != path {
+			paths = append(paths, path)
 		}
 	}
+	if 0 == len(paths) {
+		return nil
+	}
+	refs, err := generateProfileList(paths, "all")
+	if nil != err {
+		return nil
+	}
+	return refs
 }
 
-func (r *F5Router) createInternalDataGroups(
-	dataGroups map[string]map[string]*bigipResources.InternalDataGroupRecord,
-	pm bigipResources.PartitionMap,
+// validateHTTPProfile checks the configured HTTP profile and its header limits
+func validateHTTPProfile(hp config.HTTPProfileConfig) error {
+	if hp.MaxHeaderSize < 0 || hp.MaxHeaderCount < 0 {
+		return fmt.Errorf("http_profile max_header_size and max_header_count must not be negative: %d, %d",
+			hp.MaxHeaderSize, hp.MaxHeaderCount)
+	}
+	if "" == hp.Name {
+		if 0 != hp.MaxHeaderSize || 0 != hp.MaxHeaderCount {
+			return errors.New("http_profile header limits require the name of the profile setting them")
+		}
+		return nil
+	}
+	_, err := generateNameList([]string{hp.Name})
+	if nil != err {
+		return fmt.Errorf("invalid http_profile name: %v", err)
+	}
+	return nil
+}
+
+// makeHTTPProfile returns the HTTP profile of the generated virtuals, the
+// configured http_profile replaces the BIG-IP default
+func makeHTTPProfile(c *config.Config) *bigipResources.ProfileRef {
+	if "" != c.BigIP.HTTPProfile.Name {
+		refs, err := generateProfileList([]string{c.BigIP.HTTPProfile.Name}, "all")
+		if nil == err {
+			return refs[0]
+		}
+	}
+	return &bigipResources.ProfileRef{
+		Name:      "http",
+		Partition: "Common",
+		Context:   "all",
+	}
+}
+
+// makeHeaderLimitsMetadata returns the header limits of the HTTP profile
+// recorded on the routing virtuals, nil without limits
+func makeHeaderLimitsMetadata(hp config.HTTPProfileConfig) []*bigipResources.Metadata {
+	var metadata []*bigipResources.Metadata
+	if 0 != hp.MaxHeaderSize {
+		metadata = append(metadata, &bigipResources.Metadata{
+			Name:  MetadataMaxHeaderSizeKey,
+			Value: strconv.Itoa(hp.MaxHeaderSize),
+		})
+	}
+	if 0 != hp.MaxHeaderCount {
+		metadata = append(metadata, &bigipResources.Metadata{
+			Name:  MetadataMaxHeaderCountKey,
+			Value: strconv.Itoa(hp.MaxHeaderCount),
+		})
+	}
+	return metadata
+}
+
+// validateSNAT checks the configured source address translation
+func validateSNAT(s config.SNATConfig) error {
+	switch s.Type {
+	case config.SNAT_NONE, config.SNAT_AUTOMAP:
+		return nil
+	case config.SNAT_POOL:
+		if "" == s.Pool {
+			return errors.New("snat type snat requires a snat pool")
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid snat type %s, allowed values are %s", s.Type, config.SNATTypes)
+}
+
+// validateVLANs checks the configured VLAN restriction
+func validateVLANs(v config.VLANConfig) error {
+	switch v.Mode {
+	case config.VLAN_ALL, config.VLAN_DISABLED:
+	case config.VLAN_ENABLED:
+		if 0 == len(v.Names) {
+			return errors.New("vlans mode enabled requires vlan names")
+		}
+	default:
+		return fmt.Errorf("invalid vlans mode %s, allowed values are %s", v.Mode, config.VLANModes)
+	}
+	for _, name := range v.Names {
+		if "" == name {
+			return errors.New("vlans names must not be empty")
+		}
+	}
+	return nil
+}
+
+// validateWAF checks the configured WAF policy
+func validateWAF(w config.WAFConfig) error {
+	if w.Enabled && "" == w.Policy {
+		return errors.New("waf requires a policy when enabled")
+	}
+	if "" != w.Policy {
+		_, err := generateNameList([]string{w.Policy})
+		if nil != err {
+			return fmt.Errorf("invalid waf policy: %v", err)
+		}
+	}
+	return nil
+}
+
+// validateReencrypt checks the configured server SSL profile of the
+// re-encryption to TLS backends
+func validateReencrypt(re config.ReencryptConfig) error {
+	if re.Enabled && "" == re.ServerSSL {
+		return errors.New("reencrypt requires a server_ssl profile when enabled")
+	}
+	if "" != re.ServerSSL {
+		_, err := generateNameList([]string{re.ServerSSL})
+		if nil != err {
+			return fmt.Errorf("invalid reencrypt server_ssl profile: %v", err)
+		}
+	}
+	return nil
+}
+
+// makeSourceAddrTranslation returns the source address translation set on the
+// generated virtuals
+func makeSourceAddrTranslation(c *config.Config) bigipResources.SourceAddrTranslation {
+	sat := bigipResources.SourceAddrTranslation{Type: c.BigIP.SNAT.Type}
+	if config.SNAT_POOL == sat.Type {
+		sat.Pool = c.BigIP.SNAT.Pool
+	}
+	return sat
+}
+
+// setVLANs restricts the client facing virtual vs to the configured VLANs,
+// it is left listening on all VLANs by default
+func setVLANs(c *config.Config, vs *bigipResources.Virtual) {
+	switch c.BigIP.VLANs.Mode {
+	case config.VLAN_ENABLED:
+		vs.VLANsEnabled = true
+	case config.VLAN_DISABLED:
+		vs.VLANsDisabled = true
+	default:
+		return
+	}
+	vs.VLANs = c.BigIP.VLANs.Names
+}
+
+func (r *F5Router) initiRule(name string, code string) {
+	iRule := bigipResources.IRule{
+		Name: name,
+		Code: code,
+	}
+	r.ruleResources[name] = &iRule
+}
+
+// initHTTPMonitor creates the HTTP monitor shared by the HTTP route pools, it
+// is kept with the plan monitors so it is written out the same way. Routes can
+// select the other monitor type with the monitor tag so both are created when
+// the tag is set.
+func (r *F5Router) initHTTPMonitor() {
+	hm := r.c.BigIP.HTTPMonitor
+	types := []string{hm.Type}
+	if "" != hm.Tag {
+		types = []string{config.MONITOR_HTTP}
+		if "" != hm.ServerSSL {
+			types = append(types, config.MONITOR_HTTPS)
+		}
+	}
+	for _, monitorType := range types {
+		name := httpMonitorName(monitorType)
+		r.addMonitors(name, []*bigipResources.Monitor{
+			makeHTTPMonitor(name, monitorType, hm.Send, hm),
+		})
+	}
+}
+
+// httpMonitorName returns the name of the shared monitor of a monitor type
+func httpMonitorName(monitorType string) string {
+	if config.MONITOR_HTTPS == monitorType {
+		return HTTPSMonitorName
+	}
+	return HTTPMonitorName
+}
+
+// makeHTTPMonitor creates an HTTP or HTTPS monitor with the configured receive
+// string and timing, an HTTPS monitor also gets the server SSL profile and
+// cipher list
+func makeHTTPMonitor(
+	name string,
+	monitorType string,
+	send string,
+	hm config.HTTPMonitorConfig,
+) *bigipResources.Monitor {
+	monitor := &bigipResources.Monitor{
+		Name:     name,
+		Type:     monitorType,
+		Send:     send,
+		Recv:     hm.Recv,
+		Interval: hm.Interval,
+		Timeout:  hm.Timeout,
+	}
+	if config.MONITOR_HTTPS == monitorType {
+		monitor.SSLProfile = hm.ServerSSL
+		monitor.Cipherlist = hm.Cipherlist
+	}
+	return monitor
+}
+
+func (r *F5Router) createHTTPVirtuals() error {
+	plcs, err := generateNameList(r.c.BigIP.Policies)
+	if err != nil {
+		r.logger.Warn("f5router-skipping-policy-names", zap.Error(err))
+	}
+	plcs = append(plcs, &bigipResources.NameRef{
+		Name:      CFRoutingPolicyName,
+		Partition: r.c.BigIP.Partitions[0],
+	})
+	prfls, err := generateProfileList(r.c.BigIP.Profiles, "all")
+	if err != nil {
+		r.logger.Warn("f5router-skipping-profile-names", zap.Error(err))
+	}
+	prfls = append(prfls, makeHTTPProfiles(r.c)...)
+	iRulePath, err := joinBigipPath(r.c.BigIP.Partitions[0], bigipResources.HTTPForwardingiRuleName)
+	if nil != err {
+		return err
+	}
+	iRule := []string{iRulePath}
+
+	if r.c.BigIP.ForwardedHeaders {
+		r.initiRule(bigipResources.ForwardedHeadersIRuleName, bigipResources.ForwardedHeadersIRule)
+		path, _ := joinBigipPath(r.c.BigIP.Partitions[0], bigipResources.ForwardedHeadersIRuleName)
+		iRule = append(iRule, path)
+	}
+
+	if 0 != r.c.BigIP.IdleTimeout {
+		path, _ := joinBigipPath(r.c.BigIP.Partitions[0], bigipResources.IdleTimeoutIRuleName)
+		iRule = append(iRule, path)
+	}
+
+	// Operator iRules run after the forwarding iRule in the configured order
+	if 0 != len(r.c.BigIP.IRules) {
+		iRuleRefs, err := generateNameList(r.c.BigIP.IRules)
+		if nil != err {
+			return fmt.Errorf("invalid irules: %v", err)
+		}
+		for _, ref := range iRuleRefs {
+			if "" == ref.Partition || "" == ref.Name {
+				return fmt.Errorf("invalid irules: blank partition or name in %v", r.c.BigIP.IRules)
+			}
+			path, _ := joinBigipPath(ref.Partition, ref.Name)
+			iRule = append(iRule, path)
+		}
+		r.logger.Debug("f5router-routing-irules-attached", zap.Object("irules", iRule))
+	}
+
+	// Every route is matched by its own routing policy rule so the default
+	// pool of the routing virtuals is only used for the configured catch-all
+	var defaultPool string
+	if "" != r.c.BigIP.DefaultPool {
+		pools, err := generateNameList([]string{r.c.BigIP.DefaultPool})
+		if nil != err {
+			r.logger.Warn("f5router-skipping-default-pool", zap.Error(err))
+		} else {
+			defaultPool, err = joinBigipPath(pools[0].Partition, pools[0].Name)
+			if nil != err {
+				return err
+			}
+		}
+	}
+	r.defaultPool = defaultPool
+	r.appliedDefaultPool = defaultPool
+
+	if r.c.SessionPersistence {
+		r.initiRule(bigipResources.JsessionidIRuleName, bigipResources.JsessionidIRule)
+	}
+
+	srcAddrTrans := makeSourceAddrTranslation(r.c)
+	metadata := makeHeaderLimitsMetadata(r.c.BigIP.HTTPProfile)
+
+	vs := r.c.BigIP.VirtualServer
+	addrs := r.routingAddresses()
+
+	// The redirect runs before the forwarding iRule selects the tier2 virtual
+	httpIRule := iRule
+	if r.httpsRedirect() {
+		r.initiRule(bigipResources.HTTPSRedirectIRuleName,
+			bigipResources.MakeHTTPSRedirectIRule(int(vs.HTTPSPort)))
+		path, _ := joinBigipPath(r.c.BigIP.Partitions[0], bigipResources.HTTPSRedirectIRuleName)
+		httpIRule = append([]string{path}, iRule...)
+	}
+
+	// Each address gets its own routing virtuals
+	for i, addr := range addrs {
+		va := &bigipResources.VirtualAddress{
+			BindAddr: addr,
+			Port:     int32(vs.HTTPPort),
+		}
+		dest, err := verifyDestAddress(va, r.c.BigIP.Partitions[0])
+		if nil != err {
+			return err
+		}
+		name := addressVirtualName(HTTPRouterName, i, addr)
+		r.routingVirtuals = append(r.routingVirtuals, name)
+		r.virtualResources[name] = &bigipResources.Virtual{
+			VirtualServerName:     name,
+			PoolName:              defaultPool,
+			Mode:                  "tcp",
+			Enabled:               true,
+			Destination:           dest,
+			Mask:                  r.c.BigIP.ExternalMask,
+			Policies:              plcs,
+			Profiles:              prfls,
+			IRules:                httpIRule,
+			SourceAddrTranslation: srcAddrTrans,
+			RateLimit:             r.c.BigIP.RateLimit,
+			ConnectionMirroring:   r.c.BigIP.ConnectionMirroring,
+			Metadata:              metadata,
+		}
+		setVLANs(r.c, r.virtualResources[name])
+		logHTTPProfiles(r.logger, r.c, name)
+	}
+
+	// The default clientssl profile only applies when no SSL profiles are
+	// explicitly configured for the HTTPS virtual
+	sslProfileNames := r.c.BigIP.SSLProfiles
+	if 0 == len(sslProfileNames) && "" != r.c.BigIP.DefaultClientSSL {
+		sslProfileNames = []string{r.c.BigIP.DefaultClientSSL}
+	}
+	if 0 != len(sslProfileNames) {
+		sslProfiles, err := generateProfileList(sslProfileNames, "clientside")
+		// TLS is terminated on the HTTPS virtual, it must not be created
+		// without a client SSL profile
+		if 0 == len(sslProfiles) {
+			return fmt.Errorf("no valid client SSL profile for the HTTPS virtual: %v", err)
+		}
+		if err != nil {
+			r.logger.Warn("f5router-skipping-sslProfile-names", zap.Error(err))
+		}
+		prfls = append(prfls, sslProfiles...)
+
+		httpsPlcs := plcs
+		if r.c.BigIP.SNIRouting {
+			httpsPlcs = append(httpsPlcs[:len(httpsPlcs):len(httpsPlcs)], &bigipResources.NameRef{
+				Name:      CFSNIRoutingPolicyName,
+				Partition: r.c.BigIP.Partitions[0],
+			})
+		}
+
+		for i, addr := range addrs {
+			va := &bigipResources.VirtualAddress{
+				BindAddr: addr,
+				Port:     int32(vs.HTTPSPort),
+			}
+			dest, err := verifyDestAddress(va, r.c.BigIP.Partitions[0])
+			if nil != err {
+				return err
+			}
+			name := addressVirtualName(HTTPSRouterName, i, addr)
+			r.routingVirtuals = append(r.routingVirtuals, name)
+			r.virtualResources[name] = &bigipResources.Virtual{
+				VirtualServerName:     name,
+				PoolName:              defaultPool,
+				Mode:                  "tcp",
+				Enabled:               true,
+				Destination:           dest,
+				Mask:                  r.c.BigIP.ExternalMask,
+				Policies:              httpsPlcs,
+				Profiles:              prfls,
+				IRules:                iRule,
+				SourceAddrTranslation: srcAddrTrans,
+				RateLimit:             r.c.BigIP.RateLimit,
+				ConnectionMirroring:   r.c.BigIP.ConnectionMirroring,
+				Metadata:              metadata,
+			}
+			setVLANs(r.c, r.virtualResources[name])
+			logHTTPProfiles(r.logger, r.c, name)
+		}
+	}
+	return nil
+}
+
+// logHTTPProfiles logs the optional profiles attached to an HTTP virtual
+func logHTTPProfiles(l logger.Logger, c *config.Config, virtual string) {
+	if "" != c.BigIP.HTTPProfile.Name {
+		l.Debug("f5router-http-profile-attached",
+			zap.String("virtual", virtual),
+			zap.String("profile", c.BigIP.HTTPProfile.Name),
+			zap.Int("max-header-size", c.BigIP.HTTPProfile.MaxHeaderSize),
+			zap.Int("max-header-count", c.BigIP.HTTPProfile.MaxHeaderCount),
+		)
+	}
+	if "" != c.BigIP.OneConnectProfile {
+		l.Debug("f5router-oneconnect-profile-attached",
+			zap.String("virtual", virtual),
+			zap.String("profile", c.BigIP.OneConnectProfile),
+		)
+	}
+	if "" != c.BigIP.RequestLoggingProfile {
+		l.Debug("f5router-request-logging-profile-attached",
+			zap.String("virtual", virtual),
+			zap.String("profile", c.BigIP.RequestLoggingProfile),
+		)
+	}
+}
+
+func (r *F5Router) writeInitialConfig() error {
+	sections := make(map[string]interface{})
+	sections["global"] = bigipResources.GlobalConfig{
+		LogLevel:       r.c.Logging.Level,
+		VerifyInterval: r.c.BigIP.VerifyInterval,
+	}
+	sections["bigip"] = r.driverBigIP()
+
+	output, err := json.Marshal(sections)
+	if nil != err {
+		return fmt.Errorf("failed marshaling initial config: %v", err)
+	}
+	err = r.writeConfig(output)
+	if nil != err {
+		return fmt.Errorf("failed writing initial config: %v", err)
+	}
+
+	return nil
+}
+
+// retryInitialConfig writes the initial config, retrying failed writes with a
+// backoff doubling up to the max. It gives up once the retries are used up or
+// when a signal arrives while waiting.
+func (r *F5Router) retryInitialConfig(signals <-chan os.Signal) error {
+	iw := r.c.InitialWrite
+	backoff := iw.Backoff
+	for attempt := 0; ; attempt++ {
+		err := r.writeInitialConfig()
+		if nil == err {
+			return nil
+		}
+		if attempt == iw.Retries {
+			if 0 != attempt {
+				err = fmt.Errorf("%v, gave up after %d attempts", err, attempt+1)
+			}
+			return err
+		}
+		r.logger.Warn("f5router-initial-config-write-retrying",
+			zap.Int("attempt", attempt+1),
+			zap.Duration("backoff", backoff),
+			zap.Error(err),
+		)
+		timer := time.NewTimer(backoff)
+		select {
+		case sig := <-signals:
+			timer.Stop()
+			return fmt.Errorf("%v, stopped retrying on signal %s", err, sig)
+		case <-timer.C:
+		}
+		backoff *= 2
+		if backoff > iw.MaxBackoff {
+			backoff = iw.MaxBackoff
+		}
+	}
+}
+
+// SetReporter sets the reporter for the router's metrics
+func (r *F5Router) SetReporter(reporter metrics.RouterReporter) {
+	r.reporter = reporter
+}
+
+// SetMetrics sets the metrics counting the router's route updates and config
+// writes
+func (r *F5Router) SetMetrics(m *Metrics) {
+	r.metrics = m
+}
+
+// OnWrite sets a callback run after each config write with the config and the
+// write error, starting with the initial config written by Run. It must be set
+// before Run is called.
+func (r *F5Router) OnWrite(cb func(output []byte, err error)) {
+	r.onWrite = cb
+}
+
+// writeConfig hands the config to the writer, a writer which has become
+// unusable returns an error instead of taking down the worker
+func (r *F5Router) writeConfig(output []byte) (err error) {
+	if nil != r.onWrite {
+		defer func() {
+			r.onWrite(output, err)
+		}()
+	}
+	if nil == r.writer {
+		return errors.New("no functional writer provided")
+	}
+	defer func() {
+		if p := recover(); nil != p {
+			err = fmt.Errorf("writer %s failed: %v", r.writer.GetOutputFilename(), p)
+		}
+	}()
+
+	var n int
+	if dw, ok := r.diffWriter(); ok && r.pendingDiff {
+		n, err = dw.WriteDiff(output)
+	} else {
+		n, err = r.writer.Write(output)
+	}
+	if nil != err {
+		return err
+	} else if len(output) != n {
+		return fmt.Errorf("short write to %s: wrote %d of %d bytes",
+			r.writer.GetOutputFilename(), n, len(output))
+	}
+	return nil
+}
+
+// timedWriteConfig writes the config recording the time taken in the metrics
+func (r *F5Router) timedWriteConfig(output []byte) error {
+	if nil == r.metrics {
+		return r.writeConfig(output)
+	}
+	start := time.Now()
+	err := r.writeConfig(output)
+	r.metrics.observeWrite(time.Since(start), err)
+	return err
+}
+
+// Healthy reports whether the last config write succeeded
+func (r *F5Router) Healthy() bool {
+	return 0 == atomic.LoadInt32(&r.writeFailed)
+}
+
+func (r *F5Router) runWorker(done chan<- struct{}) {
+	r.logger.Debug("f5router-starting-worker")
+	for r.process() {
+	}
+	r.logger.Debug("f5router-stopping-worker")
+	close(done)
+}
+
+func (r *F5Router) createPolicies(pm bigipResources.PartitionMap, partition string, wg *sync.WaitGroup) {
+	defer wg.Done()
+	if len(r.wildcards) != 0 || len(r.r) != 0 {
+		// The routing policy is only rebuilt after its rules change
+		if nil == r.routePolicy {
+			r.routePolicy = r.makeRoutePolicy(CFRoutingPolicyName)
+		}
+		pm[partition].Policies = bigipResources.Policies{r.routePolicy}
+	}
+	if _, ok := r.virtualResources[HTTPSRouterName]; ok && r.c.BigIP.SNIRouting {
+		if nil == r.sniPolicy {
+			r.sniPolicy = r.makeSNIPolicy()
+		}
+		pm[partition].Policies = append(pm[partition].Policies, r.sniPolicy)
+	}
+
+	for name, policy := range r.policyResources {
+		rs := pm[r.objectPartition(name)]
+		rs.Policies = append(rs.Policies, policy)
+	}
+}
+
+func (r *F5Router) createVirtuals(pm bigipResources.PartitionMap, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for name, virtual := range r.virtualResources {
+		if r.monitorOnly[name] {
+			continue
+		}
+		rs := pm[r.objectPartition(name)]
+		rs.Virtuals = append(rs.Virtuals, virtual)
+	}
+}
+
+func (r *F5Router) createPools(pm bigipResources.PartitionMap, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for name, pool := range r.poolResources {
+		// The route virtual uses a pool managed outside the controller
+		if r.externalPools[name] {
+			continue
+		}
+		if r.disabledRoutes[name] {
+			pool = disabledPool(pool)
+		}
+		rs := pm[r.objectPartition(name)]
+		rs.Pools = append(rs.Pools, pool)
+	}
+}
+
+func (r *F5Router) createiRules(pm bigipResources.PartitionMap, used []string, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for name, rule := range r.ruleResources {
+		partitions := []string{r.objectPartition(name)}
+		if checkForString(partitionIRules, name) {
+			partitions = used
+		}
+		for _, partition := range partitions {
+			pm[partition].IRules = append(pm[partition].IRules, rule)
+		}
+	}
+}
+
+func (r *F5Router) createMonitors(pm bigipResources.PartitionMap, used []string, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	// Monitors are deduplicated by name, going through the routes in order
+	// keeps the monitor written for a name the same between writes
+	names := make([]string, 0, len(r.monitorResources))
+	for name := range r.monitorResources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		monitors := r.monitorResources[name]
+		// The shared HTTP monitor is referenced by the pools of every partition
+		partitions := []string{r.objectPartition(name)}
+		if HTTPMonitorName == name || HTTPSMonitorName == name {
+			partitions = used
+		}
+		if "" != r.c.BigIP.SharedPartition {
+			partitions = []string{r.c.BigIP.SharedPartition}
+		}
+		for _, partition := range partitions {
+			for _, monitor := range monitors {
+				var found bool
+				for _, addedMonitor := range pm[partition].Monitors {
+					if addedMonitor.Name == monitor.Name {
+						found = true
+						break
+					}
+				}
+				if !found {
+					pm[partition].Monitors = append(pm[partition].Monitors, monitor)
+				}
+			}
+		}
+	}
+}
+
+func (r *F5Router) createInternalDataGroups(
+	dataGroups map[string]map[string]*bigipResources.InternalDataGroupRecord,
+	pm bigipResources.PartitionMap,
 	partition string, wg *sync.WaitGroup,
 ) {
 	defer wg.Done()
 
 	for name, dataGroup := range dataGroups {
 		internalDataGroup := bigipResources.NewInternalDataGroup(name)
+		internalDataGroup.Records = make([]*bigipResources.InternalDataGroupRecord, 0, len(dataGroup))
 		for _, record := range dataGroup {
 			internalDataGroup.Records = append(internalDataGroup.Records, record)
 		}
@@ -622,6 +1715,128 @@ func (r *F5Router) createInternalDataGroups(
 	}
 }
 
+// validateSharedPartition checks the shared partition is one of the
+// configured partitions and leaves another one for the route objects
+func validateSharedPartition(bigip config.BigIPConfig) error {
+	if "" == bigip.SharedPartition {
+		return nil
+	}
+	if !checkForString(bigip.Partitions, bigip.SharedPartition) {
+		return fmt.Errorf("shared_partition %s is not one of the configured partitions %v",
+			bigip.SharedPartition, bigip.Partitions)
+	}
+	if 1 == len(bigip.Partitions) {
+		return fmt.Errorf("shared_partition %s requires another partition for the route objects",
+			bigip.SharedPartition)
+	}
+	return nil
+}
+
+// routeObjectPartitions returns the configured partitions the objects of the
+// routes may be placed in, all but the shared partition
+func routeObjectPartitions(c *config.Config) []string {
+	if "" == c.BigIP.SharedPartition {
+		return c.BigIP.Partitions
+	}
+	var partitions []string
+	for _, partition := range c.BigIP.Partitions {
+		if partition != c.BigIP.SharedPartition {
+			partitions = append(partitions, partition)
+		}
+	}
+	return partitions
+}
+
+// monitorPartition returns the partition of the monitors the controller
+// creates for a route in the partition, the shared partition when configured
+func monitorPartition(c *config.Config, partition string) string {
+	if "" != c.BigIP.SharedPartition {
+		return c.BigIP.SharedPartition
+	}
+	return partition
+}
+
+// usedPartitions returns the first partition along with the other configured
+// partitions holding routes
+func (r *F5Router) usedPartitions() []string {
+	if 1 == len(r.c.BigIP.Partitions) {
+		return r.c.BigIP.Partitions
+	}
+	used := make(map[string]bool)
+	for _, partition := range r.routePartitions {
+		used[partition] = true
+	}
+	partitions := []string{r.c.BigIP.Partitions[0]}
+	for _, partition := range r.c.BigIP.Partitions[1:] {
+		if used[partition] {
+			partitions = append(partitions, partition)
+		}
+	}
+	return partitions
+}
+
+// objectPartition returns the partition of a route's virtual, pool, monitors,
+// policy or iRules from the object's name, every other object is in the first
+// partition
+func (r *F5Router) objectPartition(name string) string {
+	if 1 == len(r.c.BigIP.Partitions) {
+		return r.c.BigIP.Partitions[0]
+	}
+	if partition, ok := r.routePartitions[name]; ok {
+		return partition
+	}
+	for _, suffix := range routeObjectSuffixes {
+		if !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		if partition, ok := r.routePartitions[strings.TrimSuffix(name, suffix)]; ok {
+			return partition
+		}
+	}
+	return r.c.BigIP.Partitions[0]
+}
+
+// routePartition returns the partition a route was placed in, or the
+// partition it would be placed in without a partition tag
+func (r *F5Router) routePartition(name string) string {
+	if partition, ok := r.routePartitions[name]; ok {
+		return partition
+	}
+	partitions := routeObjectPartitions(r.c)
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return partitions[h.Sum32()%uint32(len(partitions))]
+}
+
+// placeRoute returns the partition for the objects of a route being added,
+// the endpoint's partition tag pins the route to one of the configured
+// partitions otherwise routes are spread across them by name. Routes stay in
+// their partition until they are removed.
+func (r *F5Router) placeRoute(ru updateHTTP) (string, error) {
+	partition := r.routePartition(ru.Name())
+	tag := r.c.BigIP.PartitionTag
+	if "" == tag || nil == ru.endpoint {
+		return partition, nil
+	}
+	pinned, ok := ru.endpoint.Tags[tag]
+	if !ok {
+		return partition, nil
+	}
+	if pinned == r.c.BigIP.SharedPartition {
+		return "", fmt.Errorf("route %s can not be placed in the shared partition %s",
+			ru.Route(), pinned)
+	}
+	if !checkForString(r.c.BigIP.Partitions, pinned) {
+		return "", fmt.Errorf("route %s partition %s is not one of the configured partitions %v",
+			ru.Route(), pinned, r.c.BigIP.Partitions)
+	}
+	if placed, ok := r.routePartitions[ru.Name()]; ok && placed != pinned {
+		return "", fmt.Errorf("route %s is already in partition %s, not moving it to %s",
+			ru.Route(), placed, pinned)
+	}
+	return pinned, nil
+}
+
 // Create a partition entry in the map if it doesn't exist
 func initPartitionData(pm bigipResources.PartitionMap, partition string) {
 	if _, ok := pm[partition]; !ok {
@@ -647,12 +1862,17 @@ func populateBrokerDataGroup(brokerData *mutexBindIDRouteURIPlanNameMap) map[str
 }
 
 func (r *F5Router) createResources() bigipResources.PartitionMap {
-	// Organize the data as a map of arrays of resources (per partition)
+	// Organize the data as a map of arrays of resources (per partition), every
+	// configured partition is written out so objects leaving it get removed
 	pm := bigipResources.PartitionMap{}
+	for _, partition := range r.c.BigIP.Partitions {
+		initPartitionData(pm, partition)
+	}
 
-	//FIXME need to handle multiple partitions
+	// The routing virtuals with their policies and data groups are kept in
+	// the first partition
 	partition := r.c.BigIP.Partitions[0]
-	initPartitionData(pm, partition)
+	used := r.usedPartitions()
 
 	var wg sync.WaitGroup
 
@@ -660,16 +1880,16 @@ func (r *F5Router) createResources() bigipResources.PartitionMap {
 	go r.createPolicies(pm, partition, &wg)
 
 	wg.Add(1)
-	go r.createVirtuals(pm, partition, &wg)
+	go r.createVirtuals(pm, &wg)
 
 	wg.Add(1)
-	go r.createPools(pm, partition, &wg)
+	go r.createPools(pm, &wg)
 
 	wg.Add(1)
-	go r.createiRules(pm, partition, &wg)
+	go r.createiRules(pm, used, &wg)
 
 	wg.Add(1)
-	go r.createMonitors(pm, partition, &wg)
+	go r.createMonitors(pm, used, &wg)
 
 	dataGroups := make(map[string]map[string]*bigipResources.InternalDataGroupRecord)
 	if r.c.BrokerMode {
@@ -683,11 +1903,171 @@ func (r *F5Router) createResources() bigipResources.PartitionMap {
 
 	wg.Wait()
 
+	if r.c.BigIP.SharedNodes {
+		createNodes(pm)
+	}
+	for _, rs := range pm {
+		sortResources(rs)
+	}
 	return pm
 }
 
+// createNodes adds a node for each address the pool members of a partition
+// reference, once no matter how many pools share the address
+func createNodes(pm bigipResources.PartitionMap) {
+	for _, rs := range pm {
+		nodes := make(map[string]*bigipResources.Node)
+		for _, pool := range rs.Pools {
+			for _, member := range pool.Members {
+				if "" == member.Node {
+					continue
+				}
+				if _, ok := nodes[member.Node]; !ok {
+					nodes[member.Node] = &bigipResources.Node{
+						Name:    member.Node,
+						Address: member.Address,
+					}
+				}
+			}
+		}
+		for _, node := range nodes {
+			rs.Nodes = append(rs.Nodes, node)
+		}
+	}
+}
+
+// sortResources orders a partition's objects by name, the objects are
+// collected from maps so without it the config would change between writes of
+// the same routes
+func sortResources(rs *bigipResources.Resources) {
+	sort.Slice(rs.Virtuals, func(i, j int) bool {
+		return rs.Virtuals[i].VirtualServerName < rs.Virtuals[j].VirtualServerName
+	})
+	sort.Slice(rs.Nodes, func(i, j int) bool {
+		return rs.Nodes[i].Name < rs.Nodes[j].Name
+	})
+	sort.Slice(rs.Pools, func(i, j int) bool {
+		return rs.Pools[i].Name < rs.Pools[j].Name
+	})
+	sort.Slice(rs.Monitors, func(i, j int) bool {
+		return rs.Monitors[i].Name < rs.Monitors[j].Name
+	})
+	sort.Slice(rs.Policies, func(i, j int) bool {
+		return rs.Policies[i].Name < rs.Policies[j].Name
+	})
+	sort.Slice(rs.IRules, func(i, j int) bool {
+		return rs.IRules[i].Name < rs.IRules[j].Name
+	})
+	sort.Slice(rs.InternalDataGroups, func(i, j int) bool {
+		return rs.InternalDataGroups[i].Name < rs.InternalDataGroups[j].Name
+	})
+	for _, dg := range rs.InternalDataGroups {
+		records := dg.Records
+		sort.Slice(records, func(i, j int) bool {
+			return records[i].Name < records[j].Name
+		})
+	}
+}
+
+// diffWriter returns the writer when it takes config diffs and incremental
+// configs are enabled
+func (r *F5Router) diffWriter() (DiffWriter, bool) {
+	if !r.c.BigIP.IncrementalConfig {
+		return nil, false
+	}
+	dw, ok := r.writer.(DiffWriter)
+	return dw, ok
+}
+
+// marshalConfig generates the config sections for the driver with the next
+// generation, the returned slice is reused by the next call. Until the first
+// write succeeds the config is marked as a full sync so objects left behind
+// by routes removed while the controller was down are pruned. Writers taking
+// diffs get the objects changed since the last written config instead.
+func (r *F5Router) marshalConfig() ([]byte, error) {
+	sections := make(map[string]interface{})
+
+	global := bigipResources.GlobalConfig{
+		LogLevel:       r.c.Logging.Level,
+		VerifyInterval: r.c.BigIP.VerifyInterval,
+		Generation:     r.generation + 1,
+		FullSync:       0 == r.generation,
+	}
+	sections["global"] = global
+
+	bigip := r.driverBigIP()
+	sections["bigip"] = bigip
+
+	resources := r.createResources()
+	sections["resources"] = resources
+
+	r.logger.Debug("f5router-drain", zap.Object("writing", sections))
+
+	// Writers taking diffs get the changed objects once a full config was
+	// written to them
+	r.pendingDiff = false
+	r.pendingObjects = nil
+	if _, ok := r.diffWriter(); ok {
+		objects, err := makeConfigObjects(resources)
+		if nil != err {
+			return nil, err
+		}
+		r.pendingObjects = objects
+		if nil != r.writtenObjects {
+			data, err := json.Marshal(map[string]interface{}{
+				"bigip":   bigip,
+				"global":  global,
+				"changes": diffConfigObjects(r.writtenObjects, objects),
+			})
+			if nil != err {
+				return nil, err
+			}
+			r.pendingDiff = true
+			r.output.Reset()
+			r.output.Write(data)
+			return r.output.Bytes(), r.setPendingSum(global)
+		}
+	}
+
+	r.output.Reset()
+	err := r.cache.writeConfig(&r.output, bigip, global, resources)
+	if nil != err {
+		return nil, err
+	}
+	return r.output.Bytes(), r.setPendingSum(global)
+}
+
+// setPendingSum sets the checksum of the marshaled config without the
+// generation and full sync flag of its global section, so a config which
+// only differs from the last written one in them is found unchanged
+func (r *F5Router) setPendingSum(global bigipResources.GlobalConfig) error {
+	versioned, err := json.Marshal(global)
+	if nil != err {
+		return err
+	}
+	global.Generation = 0
+	global.FullSync = false
+	unversioned, err := json.Marshal(global)
+	if nil != err {
+		return err
+	}
+
+	output := r.output.Bytes()
+	h := sha256.New()
+	if i := bytes.Index(output, versioned); -1 != i {
+		h.Write(output[:i])
+		h.Write(unversioned)
+		h.Write(output[i+len(versioned):])
+	} else {
+		h.Write(output)
+	}
+	copy(r.pendingSum[:], h.Sum(nil))
+	return nil
+}
+
 func (r *F5Router) process() bool {
 	item, quit := r.queue.Get()
+	r.pendingRoom()
 	if quit {
 		r.logger.Debug("f5router-quit-signal-received")
 		return false
@@ -697,27 +2077,56 @@ func (r *F5Router) process() bool {
 
 	var err error
 	r.logger.Debug("f5router-received-update-request")
+	// The pools stay drained until the router stops, updates arriving in the
+	// meantime only write the config again
+	if r.skipForShutdown(item) {
+		r.logger.Debug("f5router-shutdown-skipping-update")
+		item = retryWrite{}
+	}
+	// State reads a snapshot between the work items
+	r.stateLock.Lock()
 	switch ru := item.(type) {
 	case updateHTTP:
 		if ru.Op() == routeUpdate.Add {
 			r.processRouteAdd(ru)
+			r.routeEvent(ru)
 		} else if ru.Op() == routeUpdate.Remove {
 			r.processRouteRemove(ru)
+			r.routeEvent(ru)
 		} else if ru.Op() == routeUpdate.Bind {
 			r.processRouteBind(ru)
 		} else if ru.Op() == routeUpdate.Unbind {
 			r.processRouteUnbind(ru)
+		} else if ru.Op() == routeUpdate.Disable || ru.Op() == routeUpdate.Enable {
+			r.processRouteDisable(ru)
 		}
 	case updateTCP:
 		if ru.Op() == routeUpdate.Add {
 			r.processTCPRouteAdd(ru)
 		} else if ru.Op() == routeUpdate.Remove {
 			r.processTCPRouteRemove(ru)
+		} else if ru.Op() == routeUpdate.Disable || ru.Op() == routeUpdate.Enable {
+			r.processRouteDisable(ru)
 		}
+		r.routeEvent(ru)
+	case *routeSet:
+		r.processRouteSet(ru)
+	case retryWrite:
+		r.logger.Debug("f5router-retrying-config-write")
+	case drainExpired:
+		r.processDrainExpired(ru)
+	case shutdownDrain:
+		r.processShutdownDrain(ru)
 	default:
 		r.logger.Warn("f5router-unknown-workitem",
 			zap.Error(errors.New("workqueue delivered unsupported work type")))
 	}
+	// The default route is looked up once the routes of the first sync are
+	// in, then after every update
+	if r.firstSyncDone || 0 == r.queue.Len() {
+		r.applyDefaultRoute()
+	}
+	r.stateLock.Unlock()
 
 	if nil != err {
 		r.logger.Warn("f5router-process-error", zap.Error(err))
@@ -728,29 +2137,42 @@ func (r *F5Router) process() bool {
 				r.truncateInternalDataGroup()
 				r.firstSyncDone = true
 			}
-			sections := make(map[string]interface{})
-
-			sections["global"] = bigipResources.GlobalConfig{
-				LogLevel:       r.c.Logging.Level,
-				VerifyInterval: r.c.BigIP.VerifyInterval,
+			start := time.Now()
+			output, err := r.marshalConfig()
+			if nil != r.metrics {
+				r.metrics.observeMarshal(time.Since(start))
+				r.metrics.setResources(len(r.poolResources), len(r.r)+len(r.wildcards))
 			}
-
-			sections["bigip"] = r.c.BigIP
-
-			sections["resources"] = r.createResources()
-
-			r.logger.Debug("f5router-drain", zap.Object("writing", sections))
-
-			output, err := json.Marshal(sections)
 			if nil != err {
 				r.logger.Warn("f5router-config-marshal-error", zap.Error(err))
+			} else if r.pendingSum == r.writtenSum {
+				// The writer and driver already have this config
+				r.writtenObjects = r.pendingObjects
+				r.logger.Debug("f5router-config-unchanged-skipping-write",
+					zap.Uint64("generation", r.generation),
+				)
+			} else if err = r.timedWriteConfig(output); nil != err {
+				r.logger.Error("f5router-config-write-error", zap.Error(err))
+				// Nothing is known about what the writer has now
+				r.writtenSum = [sha256.Size]byte{}
+				atomic.StoreInt32(&r.writeFailed, 1)
+				// Try again once the writer may be usable
+				r.queue.AddRateLimited(retryWrite{})
 			} else {
-				n, err := r.writer.Write(output)
-				if nil != err {
-					r.logger.Warn("f5router-config-write-error", zap.Error(err))
-				} else if len(output) != n {
-					r.logger.Warn("f5router-config-short-write", zap.Error(err))
-				}
+				atomic.StoreInt32(&r.writeFailed, 0)
+				r.queue.Forget(retryWrite{})
+				r.generation++
+				r.writtenObjects = r.pendingObjects
+				r.writtenSum = r.pendingSum
+				r.logger.Info("f5router-config-written",
+					zap.Uint64("generation", r.generation),
+					zap.Int("bytes", len(output)),
+					zap.Bool("diff", r.pendingDiff),
+				)
+			}
+			if nil != r.shutdownWritten {
+				close(r.shutdownWritten)
+				r.shutdownWritten = nil
 			}
 		} else {
 			r.logger.Debug("f5router-write-not-ready",
@@ -780,11 +2202,11 @@ func makePool(
 }
 
 func verifyDestAddress(va *bigipResources.VirtualAddress, partition string) (string, error) {
-	ip, rd := splitIPWithRouteDomain(va.BindAddr)
+	ip, rd := splitIPWithRouteDomain(normalizeAddress(va.BindAddr))
 	if len(rd) > 0 {
 		rd = "%" + rd
 	}
-	addr := net.ParseIP(va.BindAddr)
+	addr := net.ParseIP(ip)
 	if nil != addr {
 		var format string
 		if nil != addr.To4() {
@@ -835,7 +2257,7 @@ func (r *F5Router) fetchExistingDataGroup(c *config.Config, name string) (*bigip
 		r.c.BigIP.Partitions[0],
 		name,
 	)
-	data, err := r.bigIPClient.Get(url, r.c.BigIP.User, r.c.BigIP.Pass)
+	data, err := r.getBigIP(url)
 	if nil != err {
 		// Encountered a timeout or temporary connection error so enter retry backoff
 		if err, ok := err.(net.Error); ok && (err.Timeout() || err.Temporary()) {
@@ -853,7 +2275,7 @@ func (r *F5Router) fetchExistingDataGroup(c *config.Config, name string) (*bigip
 					<-timer.C
 
 					// Attempt to connect to the BIG-IP signal channel if there is not a temporary or timeout error
-					rvs.data, rvs.err = r.bigIPClient.Get(url, r.c.BigIP.User, r.c.BigIP.Pass)
+					rvs.data, rvs.err = r.getBigIP(url)
 					if doErr, ok := rvs.err.(net.Error); ok && !(doErr.Timeout() || doErr.Temporary()) {
 						rvs.data = nil
 						done <- rvs
@@ -896,7 +2318,7 @@ func (r *F5Router) fetchExistingDataGroup(c *config.Config, name string) (*bigip
 
 // assignVSPort creates the holder BindAddr and Port the vs will use, these
 // virtuals are being routed to through an iRule using the virtual command
-func (r *F5Router) assignVSPort(vs *bigipResources.Virtual) error {
+func (r *F5Router) assignVSPort(vs *bigipResources.Virtual, partition string) error {
 	var va *bigipResources.VirtualAddress
 	var err error
 	key := vs.VirtualServerName
@@ -908,7 +2330,7 @@ func (r *F5Router) assignVSPort(vs *bigipResources.Virtual) error {
 		dgr, exist := r.internalDataGroup[key]
 		// the mapping doesn't exist yet so assign it
 		if !exist {
-			va, err = r.getAvailableVirtualAddress()
+			va, err = r.getAvailableVirtualAddress(key)
 			if nil != err {
 				return err
 			}
@@ -929,7 +2351,7 @@ func (r *F5Router) assignVSPort(vs *bigipResources.Virtual) error {
 			}
 		}
 
-		dest, err := verifyDestAddress(va, r.c.BigIP.Partitions[0])
+		dest, err := verifyDestAddress(va, partition)
 		if err != nil {
 			return err
 		}
@@ -942,49 +2364,41 @@ func (r *F5Router) assignVSPort(vs *bigipResources.Virtual) error {
 	return nil
 }
 
-func (r *F5Router) getAvailableVirtualAddress() (*bigipResources.VirtualAddress, error) {
-	var va *bigipResources.VirtualAddress
-	// Use a reaped va if we have one
-	if len(r.tier2VSInfo.reapedPorts) != 0 {
-		va = r.tier2VSInfo.reapedPorts[0]
-		// delete from a slice https://github.com/golang/go/wiki/SliceTricks
-		r.tier2VSInfo.reapedPorts[0] = r.tier2VSInfo.reapedPorts[len(r.tier2VSInfo.reapedPorts)-1]
-		r.tier2VSInfo.reapedPorts[len(r.tier2VSInfo.reapedPorts)-1] = nil
-		r.tier2VSInfo.reapedPorts = r.tier2VSInfo.reapedPorts[:len(r.tier2VSInfo.reapedPorts)-1]
-		return va, nil
-	}
-	// No reaped va so check for the next available one
-	va = &bigipResources.VirtualAddress{
-		BindAddr: r.tier2VSInfo.holderIP.String(),
-		Port:     r.tier2VSInfo.holderPort,
-	}
-	_, found := r.tier2VSInfo.usedPorts[va.String()]
-	for found {
-		// check if we are at the last address valid for a va
-		if r.tier2VSInfo.holderPort == 65535 {
-			// increement the holderip to the next address
-			err := r.incrementHolderIP()
-			if nil != err {
-				return nil, err
+// getAvailableVirtualAddress assigns the tier2 destination of a virtual. The
+// port is derived from the virtual's name so routes get the same destinations
+// no matter the order they are added in, a port in use is skipped for the next
+// one and the next address is used once every port of an address is in use.
+func (r *F5Router) getAvailableVirtualAddress(name string) (*bigipResources.VirtualAddress, error) {
+	info := &r.tier2VSInfo
+	span := info.maxPort - info.minPort + 1
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	start := int32(h.Sum32() % uint32(span))
+
+	ip := info.holderIP
+	for {
+		for i := int32(0); i < span; i++ {
+			va := &bigipResources.VirtualAddress{
+				BindAddr: ip.String(),
+				Port:     info.minPort + (start+i)%span,
 			}
-			// reset our ports back to our starting point
-			r.tier2VSInfo.holderPort = 10000
-		} else {
-			r.tier2VSInfo.holderPort = r.tier2VSInfo.holderPort + 1
-			va = &bigipResources.VirtualAddress{
-				BindAddr: r.tier2VSInfo.holderIP.String(),
-				Port:     r.tier2VSInfo.holderPort,
+			if _, found := info.usedPorts[va.String()]; !found {
+				info.usedPorts[va.String()] = va
+				return va, nil
 			}
-			_, found = r.tier2VSInfo.usedPorts[va.String()]
+		}
+		var err error
+		ip, err = r.nextTier2IP(ip)
+		if nil != err {
+			return nil, err
 		}
 	}
-	r.tier2VSInfo.usedPorts[va.String()] = va
-	return va, nil
 }
 
-func (r *F5Router) incrementHolderIP() error {
-	ipCopy := make(net.IP, len(r.tier2VSInfo.holderIP))
-	copy(ipCopy, r.tier2VSInfo.holderIP)
+// nextTier2IP returns the address following ip in the tier2 range
+func (r *F5Router) nextTier2IP(ip net.IP) (net.IP, error) {
+	ipCopy := make(net.IP, len(ip))
+	copy(ipCopy, ip)
 	// https://groups.google.com/d/msg/golang-nuts/zlcYA4qk-94/TWRFHeXJCcYJ
 	for i := len(ipCopy) - 1; i >= 0; i-- {
 		ipCopy[i]++
@@ -995,10 +2409,9 @@ func (r *F5Router) incrementHolderIP() error {
 	// verify the ip is in the range provided
 	contains := r.tier2VSInfo.ipNet.Contains(ipCopy)
 	if !contains {
-		return errors.New("Ran out of available IP addresses. Restart the controller with a larger address space")
+		return nil, errors.New("Ran out of available IP addresses. Restart the controller with a larger address space")
 	}
-	r.tier2VSInfo.holderIP = ipCopy
-	return nil
+	return ipCopy, nil
 }
 
 // processBrokerDataGroup uses the cached broker data group off the BIG-IP to populate
@@ -1037,7 +2450,11 @@ func (r *F5Router) processBrokerDataGroup(dg *bigipResources.InternalDataGroup)
 			r.logger.Warn("process-broker-data-group-new-update-error", zap.Error(err))
 			continue
 		}
-		r.UpdateRoute(ru)
+		if !r.routeManaged(ru) {
+			continue
+		}
+		// The worker is not running yet, waiting for room would block
+		r.addRouteUpdate(ru)
 	}
 
 	r.logger.Debug(
@@ -1077,9 +2494,8 @@ func (r *F5Router) processCachedDataGroup(dg *bigipResources.InternalDataGroup)
 }
 
 // truncateInternalDataGroup cleans up after our first drain of the queue
-// Create a new data group and list of used ports then compare the old list
-// of used ports to the new, anything not in the new list is added to
-// reapedPorts for reuse
+// Create a new data group and list of used ports, ports of virtuals that no
+// longer exist are free for new virtuals
 func (r *F5Router) truncateInternalDataGroup() {
 	newDataGroup := make(map[string]*bigipResources.InternalDataGroupRecord)
 	newUsedPorts := make(map[string]*bigipResources.VirtualAddress)
@@ -1101,40 +2517,21 @@ func (r *F5Router) truncateInternalDataGroup() {
 		zap.Object("old-used-ports", r.tier2VSInfo.usedPorts),
 		zap.Object("new-used-ports", newUsedPorts),
 	)
-	for key, value := range r.tier2VSInfo.usedPorts {
-		if _, ok := newUsedPorts[key]; !ok {
-			r.tier2VSInfo.reapedPorts = append(r.tier2VSInfo.reapedPorts, value)
-		}
-	}
 	r.internalDataGroup = newDataGroup
 	r.tier2VSInfo.usedPorts = newUsedPorts
 
 }
 
-func (r *F5Router) makeRouteRule(ru updateHTTP) (*bigipResources.Rule, error) {
-	_u := "scheme://" + ru.URI().String()
+// makeRouteConditions creates the host and path conditions matching a route
+func makeRouteConditions(uri route.Uri) ([]*bigipResources.Condition, error) {
+	_u := "scheme://" + uri.String()
 	_u = strings.TrimSuffix(_u, "/")
 	u, err := url.Parse(_u)
 	if nil != err {
-		return nil, err
-	}
-
-	var b bytes.Buffer
-	b.WriteRune('/')
-	b.WriteString(r.c.BigIP.Partitions[0]) //FIXME update to use multiple partitions
-	b.WriteRune('/')
-	b.WriteString(ru.Name())
-
-	a := bigipResources.Action{
-		Name:        "0",
-		Request:     true,
-		Expression:  ru.Name(),
-		TmName:      "target_vip",
-		Tcl:         true,
-		SetVariable: true,
+		return nil, err
 	}
 
-	uriString := ru.URI().String()
+	uriString := uri.String()
 
 	var c []*bigipResources.Condition
 	if strings.Contains(uriString, "*") {
@@ -1180,28 +2577,84 @@ func (r *F5Router) makeRouteRule(ru updateHTTP) (*bigipResources.Rule, error) {
 			Request:  true,
 			Values:   []string{u.Host},
 		})
+	}
 
-		if 0 != len(u.EscapedPath()) {
-			path := strings.TrimPrefix(u.EscapedPath(), "/")
-			segments := strings.Split(path, "/")
+	// Context paths match segment by segment so a route on /segment1 leaves
+	// out /segment10, wildcard hosts included
+	if 0 != len(u.EscapedPath()) {
+		path := strings.TrimPrefix(u.EscapedPath(), "/")
+		segments := strings.Split(path, "/")
+
+		for i, v := range segments {
+			c = append(c, &bigipResources.Condition{
+				Equals:      true,
+				HTTPURI:     true,
+				PathSegment: true,
+				Name:        strconv.Itoa(len(c)),
+				Index:       i + 1,
+				Request:     true,
+				Values:      []string{v},
+			})
+		}
+	}
 
-			for i, v := range segments {
-				c = append(c, &bigipResources.Condition{
-					Equals:      true,
-					HTTPURI:     true,
-					PathSegment: true,
-					Name:        strconv.Itoa(i + 1),
-					Index:       i + 1,
-					Request:     true,
-					Values:      []string{v},
-				})
-			}
+	return c, nil
+}
+
+func (r *F5Router) makeRouteRule(ru updateHTTP) (*bigipResources.Rule, error) {
+	c, err := makeRouteConditions(ru.URI())
+	if nil != err {
+		return nil, err
+	}
+
+	// Tier2 virtuals outside the first partition are forwarded to by path
+	target := ru.Name()
+	if partition := ru.partitionName(r.c); partition != r.c.BigIP.Partitions[0] {
+		target, err = joinBigipPath(partition, target)
+		if nil != err {
+			return nil, err
+		}
+	}
+
+	a := bigipResources.Action{
+		Name:        "0",
+		Request:     true,
+		Expression:  target,
+		TmName:      "target_vip",
+		Tcl:         true,
+		SetVariable: true,
+	}
+	// Requests for a route bound to a route service are sent to the service
+	// instead of the route's tier2 virtual
+	if rsURL := ru.routeServiceURL(); "" != rsURL {
+		a = bigipResources.Action{
+			Name:      "0",
+			Request:   true,
+			HTTPReply: true,
+			Redirect:  true,
+			Location:  rsURL,
 		}
 	}
 
+	actions := []*bigipResources.Action{&a}
+	// The HTTPS redirect iRule of the HTTP virtual redirects the request once
+	// the variable is set, the HTTPS virtual ignores it
+	if !a.Redirect && ru.routeHTTPSRedirect(r.c) {
+		actions = append(actions, &bigipResources.Action{
+			Name:        "1",
+			Request:     true,
+			Expression:  "1",
+			TmName:      bigipResources.HTTPSRedirectVariable,
+			Tcl:         true,
+			SetVariable: true,
+		})
+	}
+
+	uriString := ru.URI().String()
+
 	rl := bigipResources.Rule{
 		FullURI:     uriString,
-		Actions:     []*bigipResources.Action{&a},
+		Actions:     actions,
 		Conditions:  c,
 		Name:        ru.Name(),
 		Description: makeDescription(uriString, ru.AppID()),
@@ -1224,12 +2677,16 @@ func (r *F5Router) makeRoutePolicy(policyName string) *bigipResources.Policy {
 
 	var wg sync.WaitGroup
 	wg.Add(2)
-	sortRules := func(r bigipResources.RuleMap, rls *bigipResources.Rules, ordinal int) {
-		for _, v := range r {
+	sortRules := func(rm bigipResources.RuleMap, rls *bigipResources.Rules, ordinal int) {
+		for _, v := range rm {
+			// Monitor only routes get no traffic
+			if r.monitorOnly[v.Name] {
+				continue
+			}
 			*rls = append(*rls, v)
 		}
 
-		sort.Sort(sort.Reverse(*rls))
+		sort.Sort(bigipResources.RulesByPrecedence(*rls))
 
 		for _, v := range *rls {
 			v.Ordinal = ordinal
@@ -1254,6 +2711,103 @@ func (r *F5Router) makeRoutePolicy(policyName string) *bigipResources.Policy {
 	return &plcy
 }
 
+// makeSNIPolicy creates the policy routing on the TLS server name, only
+// routes without a context path or route service get a rule since the request
+// is not known on the client hello. Exact hosts come before wildcards so they
+// take precedence.
+func (r *F5Router) makeSNIPolicy() *bigipResources.Policy {
+	plcy := bigipResources.Policy{
+		Controls: []string{"forwarding"},
+		Legacy:   true,
+		Name:     CFSNIRoutingPolicyName,
+		Requires: []string{"client-ssl"},
+		Rules:    []*bigipResources.Rule{},
+		Strategy: "/Common/first-match",
+	}
+
+	ordinal := 0
+	for _, rm := range []bigipResources.RuleMap{r.r, r.wildcards} {
+		rls := bigipResources.Rules{}
+		for uri, v := range rm {
+			if r.monitorOnly[v.Name] || "" != routeContextPath(uri) || isRedirectRule(v) {
+				continue
+			}
+			rl, err := makeSNIRule(uri, v)
+			if nil != err {
+				r.logger.Warn("f5router-sni-rule-error", zap.Error(err))
+				continue
+			}
+			rls = append(rls, rl)
+		}
+		sort.Sort(bigipResources.RulesByPrecedence(rls))
+		for _, rl := range rls {
+			rl.Ordinal = ordinal
+			ordinal++
+		}
+		plcy.Rules = append(plcy.Rules, rls...)
+	}
+
+	r.logger.Debug("f5router-sni-policy-create", zap.Object("policy", plcy))
+	return &plcy
+}
+
+// isRedirectRule returns true for the rule of a route bound to a route
+// service, the redirect needs the HTTP request so the route gets no SNI rule
+func isRedirectRule(rl *bigipResources.Rule) bool {
+	for _, a := range rl.Actions {
+		if a.Redirect {
+			return true
+		}
+	}
+	return false
+}
+
+// makeSNIRule creates the rule matching a route's host on the TLS server name,
+// forwarding to the same tier2 virtual as the route's host rule
+func makeSNIRule(uri route.Uri, hostRule *bigipResources.Rule) (*bigipResources.Rule, error) {
+	conditions, err := makeRouteConditions(uri)
+	if nil != err {
+		return nil, err
+	}
+	var c []*bigipResources.Condition
+	for _, hc := range conditions {
+		if !hc.Host {
+			continue
+		}
+		c = append(c, &bigipResources.Condition{
+			Equals:         hc.Equals,
+			StartsWith:     hc.StartsWith,
+			EndsWith:       hc.EndsWith,
+			SSLExtension:   true,
+			ServerName:     true,
+			SSLClientHello: true,
+			Name:           hc.Name,
+			Index:          hc.Index,
+			Values:         hc.Values,
+		})
+	}
+
+	var a []*bigipResources.Action
+	for _, ha := range hostRule.Actions {
+		// There is no request to redirect on the client hello
+		if bigipResources.HTTPSRedirectVariable == ha.TmName {
+			continue
+		}
+		action := *ha
+		action.Request = false
+		action.SSLClientHello = true
+		a = append(a, &action)
+	}
+
+	return &bigipResources.Rule{
+		FullURI:     hostRule.FullURI,
+		Actions:     a,
+		Conditions:  c,
+		Name:        hostRule.Name,
+		Description: hostRule.Description,
+	}, nil
+}
+
 func (r *F5Router) processRouteAdd(ru updateHTTP) {
 	r.logger.Debug("process-HTTP-route-add", zap.String("name", ru.Name()), zap.String("route", ru.Route()))
 
@@ -1263,17 +2817,36 @@ func (r *F5Router) processRouteAdd(ru updateHTTP) {
 		return
 	}
 
-	// Create default resources and update them if resource updates exist for this route
+	// One malformed endpoint must not fail applying the whole config
+	if nil != ru.endpoint {
+		err = validateMember(ru.endpoint.Address, ru.endpoint.Port)
+		if nil != err {
+			r.logger.Warn("f5router-skipping-invalid-endpoint",
+				zap.String("route", ru.Route()),
+				zap.Error(err),
+			)
+			return
+		}
+	}
+
+	ru.partition, err = r.placeRoute(ru)
+	if nil != err {
+		r.logger.Error("process-HTTP-route-add-error-partition", zap.Error(err))
+		return
+	}
+
+	// Create default resources and update them if a plan was bound to this
+	// route before it was mapped
 	rs, err := ru.CreateResources(r.c)
 	if nil != err {
 		r.logger.Error("process-HTTP-route-add-error-create-resources", zap.Error(err))
 		return
 	}
-	if resources, ok := r.unmappedResourcesMap[ru.Name()]; ok {
-		rs = ru.UpdateResources(rs, resources)
+	if plan, ok := r.unmappedPlans[ru.Name()]; ok {
+		rs = ru.UpdateResources(rs, ru.CreatePlanResources(r.c, plan))
 	}
 
-	err = r.assignVSPort(rs.Virtuals[0])
+	err = r.assignVSPort(rs.Virtuals[0], ru.partition)
 	if nil != err {
 		r.logger.Error(
 			"process-HTTP-route-add-error-assign-vs-port",
@@ -1281,23 +2854,37 @@ func (r *F5Router) processRouteAdd(ru updateHTTP) {
 			zap.Error(err))
 		return
 	}
+	r.routePartitions[ru.Name()] = ru.partition
 
 	if len(rs.Monitors) != 0 {
 		r.addMonitors(rs.Pools[0].Name, rs.Monitors)
 	}
+	if len(rs.Policies) != 0 {
+		r.addPolicy(rs.Policies[0])
+	}
+	if len(rs.IRules) != 0 {
+		r.addIRule(rs.IRules[0])
+	}
 	r.addPool(rs.Pools[0])
 	r.addVirtual(rs.Virtuals[0])
 	r.addRule(ru)
+	r.checkScaleSignals(ru.Name(), ru.Route())
 }
 
 func (r *F5Router) processRouteBind(ru updateHTTP) {
 	name := ru.Name()
 	planID := ru.PlanID()
+	ru.partition = r.routePartition(name)
 	existingPool := r.poolResources[name]
 	existingVirtual := r.virtualResources[name]
 	r.plansMap.lock.Lock()
 	defer r.plansMap.lock.Unlock()
 
+	plan, ok := r.plansMap.plans[planID]
+	r.setMonitorOnly(name, ok && plan.MonitorOnly)
+	delete(r.externalPools, name)
+	defer r.setScaleWatch(name, ru.Route(), plan.ScaleSignals)
+
 	// There is a mapped route (with an endpoint) that will be updated
 	if (existingPool != nil) && (existingVirtual != nil) {
 		var rs bigipResources.Resources
@@ -1307,6 +2894,8 @@ func (r *F5Router) processRouteBind(ru updateHTTP) {
 		copy(members, existingPool.Members)
 		r.removePool(existingPool)
 		r.removeVirtual(name)
+		r.removePolicy(name, existingVirtual)
+		r.removeRouteIRules(name, existingVirtual)
 
 		// Bind updates to this mapped route
 		rs = bigipResources.Resources{
@@ -1314,30 +2903,42 @@ func (r *F5Router) processRouteBind(ru updateHTTP) {
 			Pools:    []*bigipResources.Pool{existingPool},
 		}
 
-		if plan, ok := r.plansMap.plans[planID]; ok {
+		if ok {
 			if len(plan.Pool.HealthMonitors) != 0 {
 				r.removeMonitors(existingPool.Name)
 			}
 			planResources := ru.CreatePlanResources(r.c, plan)
 			rs = ru.UpdateResources(rs, planResources)
+			r.setExternalPool(name, planResources)
 		} else {
 			r.logger.Warn("process-HTTP-route-update-bind-error",
 				zap.String("Update-Mapped-Route-Error",
 					fmt.Sprintf("could not find plan %s for route %s keeping defaults", planID, ru.Route())))
 		}
 
+		// A previous plan may have pointed the virtual at an external pool
+		if !r.externalPools[name] {
+			rs.Virtuals[0].PoolName, _ = joinBigipPath(ru.partition, name)
+		}
+
 		// Members are not updated and should be added back
 		rs.Pools[0].Members = members
 		if len(rs.Monitors) != 0 {
 			r.addMonitors(rs.Pools[0].Name, rs.Monitors)
 		}
+		if len(rs.Policies) != 0 {
+			r.addPolicy(rs.Policies[0])
+		}
+		for _, iRule := range rs.IRules {
+			r.addIRule(iRule)
+		}
 		r.addPool(rs.Pools[0])
 		r.addVirtual(rs.Virtuals[0])
 	} else {
 		// Bind updates to this unmapped route
-		if plan, ok := r.plansMap.plans[planID]; ok {
-			planResources := ru.CreatePlanResources(r.c, plan)
-			r.unmappedResourcesMap[name] = planResources
+		if ok {
+			r.unmappedPlans[name] = plan
+			r.setExternalPool(name, ru.CreatePlanResources(r.c, plan))
 		} else {
 			r.logger.Warn("process-HTTP-route-update-bind-error",
 				zap.String("Update-Unmapped-Route-Error",
@@ -1348,6 +2949,10 @@ func (r *F5Router) processRouteBind(ru updateHTTP) {
 
 func (r *F5Router) processRouteUnbind(ru updateHTTP) {
 	name := ru.Name()
+	ru.partition = r.routePartition(name)
+	r.setMonitorOnly(name, false)
+	delete(r.externalPools, name)
+	delete(r.scaleWatches, name)
 	existingPool := r.poolResources[name]
 	existingVirtual := r.virtualResources[name]
 
@@ -1364,6 +2969,8 @@ func (r *F5Router) processRouteUnbind(ru updateHTTP) {
 
 		// Unbind updates to this mapped route
 		r.removeMonitors(existingPool.Name)
+		r.removePolicy(name, existingVirtual)
+		r.removeRouteIRules(name, existingVirtual)
 		rs, err = ru.CreateBrokerDefaultResources(
 			r.c,
 			existingPool.Description,
@@ -1376,13 +2983,18 @@ func (r *F5Router) processRouteUnbind(ru updateHTTP) {
 			return
 		}
 
-		// Members are not updated and should be added back
+		// Members and metadata are not updated and should be added back
 		rs.Pools[0].Members = members
+		rs.Pools[0].Metadata = existingPool.Metadata
+		rs.Virtuals[0].Metadata = existingVirtual.Metadata
+		if len(rs.Monitors) != 0 {
+			r.addMonitors(rs.Pools[0].Name, rs.Monitors)
+		}
 		r.addPool(rs.Pools[0])
 		r.addVirtual(rs.Virtuals[0])
 	} else {
 		// Unbind updates to this unmapped route
-		delete(r.unmappedResourcesMap, name)
+		delete(r.unmappedPlans, name)
 	}
 }
 
@@ -1395,22 +3007,34 @@ func (r *F5Router) processRouteRemove(ru updateHTTP) {
 		return
 	}
 
+	ru.partition = r.routePartition(ru.Name())
 	rs, err := ru.CreateResources(r.c)
 	if nil != err {
 		r.logger.Error("process-HTTP-route-remove-error", zap.Error(err))
 		return
 	}
+	if r.drainMember(ru, rs.Pools[0]) {
+		return
+	}
+	r.removeRoute(ru, rs)
+}
+
+// removeRoute removes the route's member from its pool, the route's objects
+// are removed with its last member
+func (r *F5Router) removeRoute(ru updateHTTP, rs bigipResources.Resources) {
 	poolRemoved := r.removePool(rs.Pools[0])
 	if poolRemoved {
 		// delete the health monitors associated with this pool
 		r.removeMonitors(rs.Pools[0].Name)
 		// delete the rule for the vip
 		r.removeRule(ru)
-		// delete the tier2 vip
+		// delete the tier2 vip with its route policy and iRule
 		vsName := rs.Virtuals[0].VirtualServerName
+		r.removePolicy(vsName, nil)
+		r.removeRouteIRules(vsName, nil)
 		r.removeVirtual(vsName)
-		// delete the mapping of the vs name to the destination
-		delete(r.tier2VSInfo.usedPorts, vsName)
+		r.setMonitorOnly(vsName, false)
+		delete(r.externalPools, vsName)
 		// the tier2 vip is deleted, remove the internal data group entry for it
 		record, exist := r.internalDataGroup[vsName]
 		if exist {
@@ -1418,24 +3042,37 @@ func (r *F5Router) processRouteRemove(ru updateHTTP) {
 			if nil != err {
 				r.logger.Warn("process-HTTP-route-remove-error", zap.Object("record", record), zap.Error(err))
 			} else {
-				// Add the virtual address to reaped ports for reuse
-				r.tier2VSInfo.reapedPorts = append(r.tier2VSInfo.reapedPorts, va)
+				// Free the destination for other virtuals
+				delete(r.tier2VSInfo.usedPorts, va.String())
 			}
 			delete(r.internalDataGroup, vsName)
 		}
+		delete(r.routePartitions, vsName)
 	}
+	r.checkScaleSignals(ru.Name(), ru.Route())
 }
 
 func (r *F5Router) processTCPRouteAdd(ru updateTCP) {
 	r.logger.Debug("process-TCP-route-add", zap.String("name", ru.Name()), zap.String("route", ru.Route()))
 
+	err := validateMember(ru.member.Address, ru.member.Port)
+	if nil != err {
+		r.logger.Warn("f5router-skipping-invalid-endpoint",
+			zap.String("route", ru.Route()),
+			zap.Error(err),
+		)
+		return
+	}
+
 	rs, err := ru.CreateResources(r.c)
 	if nil != err {
 		r.logger.Error("process-TCP-route-add-error", zap.Error(err))
 		return
 	}
 	r.addPool(rs.Pools[0])
-	r.addVirtual(rs.Virtuals[0])
+	for _, vs := range rs.Virtuals {
+		r.addVirtual(vs)
+	}
 }
 
 func (r *F5Router) processTCPRouteRemove(ru updateTCP) {
@@ -1448,7 +3085,9 @@ func (r *F5Router) processTCPRouteRemove(ru updateTCP) {
 	}
 	poolRemoved := r.removePool(rs.Pools[0])
 	if poolRemoved {
-		r.removeVirtual(rs.Virtuals[0].VirtualServerName)
+		for _, vs := range rs.Virtuals {
+			r.removeVirtual(vs.VirtualServerName)
+		}
 	}
 }
 
@@ -1460,41 +3099,171 @@ func (r *F5Router) removeMonitors(poolName string) {
 	delete(r.monitorResources, poolName)
 }
 
+func (r *F5Router) addPolicy(policy *bigipResources.Policy) {
+	r.policyResources[policy.Name] = policy
+}
+
+// removePolicy deletes a route's own policy and its reference on the
+// route virtual if one is provided
+func (r *F5Router) removePolicy(routeName string, vs *bigipResources.Virtual) {
+	policyName := routeName + RoutePolicySuffix
+	delete(r.policyResources, policyName)
+
+	if nil == vs {
+		return
+	}
+	var policies []*bigipResources.NameRef
+	for _, ref := range vs.Policies {
+		if ref.Name != policyName {
+			policies = append(policies, ref)
+		}
+	}
+	vs.Policies = policies
+}
+
+func (r *F5Router) addIRule(iRule *bigipResources.IRule) {
+	r.ruleResources[iRule.Name] = iRule
+}
+
+// removeRouteIRules deletes the iRules created for a route from its plan and
+// their references on the route virtual if one is provided
+func (r *F5Router) removeRouteIRules(routeName string, vs *bigipResources.Virtual) {
+	iRulePaths := make(map[string]bool)
+	for _, suffix := range routeIRuleSuffixes {
+		iRuleName := routeName + suffix
+		delete(r.ruleResources, iRuleName)
+		iRulePath, _ := joinBigipPath(r.routePartition(routeName), iRuleName)
+		iRulePaths[iRulePath] = true
+	}
+
+	if nil == vs {
+		return
+	}
+	var iRules []string
+	for _, path := range vs.IRules {
+		if !iRulePaths[path] {
+			iRules = append(iRules, path)
+		}
+	}
+	vs.IRules = iRules
+}
+
 func (r *F5Router) addPool(pool *bigipResources.Pool) {
 	key := pool.Name
+	r.cache.invalidate(poolCacheKey(r.objectPartition(key), key))
 
 	p, exists := r.poolResources[key]
 
 	if exists {
-		for _, addr := range p.Members {
-			// Currently only a single update comes through at a time so we always
-			// know to look at the first addr
-			if addr == pool.Members[0] {
-				return
-			}
+		switch {
+		// A weighted member switches the whole pool over to ratio balancing,
+		// members without a weight keep the BIG-IP default ratio of 1
+		case isRatioMode(pool.Balance) && !isRatioMode(p.Balance):
+			p.Balance = pool.Balance
+		// A route override of the default mode, it never switches a pool with
+		// weighted members away from ratio balancing
+		case pool.Balance != r.c.BigIP.LoadBalancingMode &&
+			isRatioMode(pool.Balance) == isRatioMode(p.Balance):
+			p.Balance = pool.Balance
 		}
-		p.Members = append(p.Members, pool.Members...)
+		// Currently only a single update comes through at a time so we always
+		// know to look at the first addr
+		member := pool.Members[0]
+		i := sort.Search(len(p.Members), func(i int) bool {
+			return !memberLess(p.Members[i], member)
+		})
+		if i < len(p.Members) && sameMember(p.Members[i], member) {
+			p.Members[i].Ratio = member.Ratio
+			p.Members[i].ConnectionLimit = member.ConnectionLimit
+			p.Members[i].PriorityGroup = member.PriorityGroup
+			// A draining member added back is enabled again
+			p.Members[i].Session = member.Session
+			p.Members[i].State = member.State
+			r.cancelDrain(key, member)
+		} else {
+			// Members are kept sorted so the pool is written the same way
+			// whatever order its members were added in
+			p.Members = append(p.Members, bigipResources.Member{})
+			copy(p.Members[i+1:], p.Members[i:])
+			p.Members[i] = member
+			r.checkPoolMembers(p)
+		}
+		setMemberRatios(p)
+		r.setMinActiveMembers(p)
 	} else {
+		sort.Slice(pool.Members, func(i, j int) bool {
+			return memberLess(pool.Members[i], pool.Members[j])
+		})
+		setMemberRatios(pool)
+		r.setMinActiveMembers(pool)
 		r.poolResources[key] = pool
+		r.checkPoolMembers(pool)
+	}
+}
+
+// setMemberRatios writes out the ratio of every member of a pool using ratio
+// balancing, members without a weight get the BIG-IP default of 1. Other pools
+// leave the default out, such as after a plan with ratio balancing is unbound.
+func setMemberRatios(pool *bigipResources.Pool) {
+	ratioMode := isRatioMode(pool.Balance)
+	for i := range pool.Members {
+		if ratioMode && 0 == pool.Members[i].Ratio {
+			pool.Members[i].Ratio = 1
+		} else if !ratioMode && 1 == pool.Members[i].Ratio {
+			pool.Members[i].Ratio = 0
+		}
 	}
+}
+
+// setMinActiveMembers turns on priority group activation for pools with
+// members in a priority group. It is left off while the pool has no more
+// members than the threshold since every priority group is active then.
+func (r *F5Router) setMinActiveMembers(pool *bigipResources.Pool) {
+	pool.MinActiveMembers = 0
+	minActive := r.c.BigIP.MinActiveMembers
+	if 0 == minActive || !hasPriorityGroups(pool) {
+		return
+	}
+	if len(pool.Members) <= minActive {
+		r.logger.Debug("f5router-priority-group-activation-off",
+			zap.String("pool", pool.Name),
+			zap.Int("members", len(pool.Members)),
+			zap.Int("min-active-members", minActive),
+		)
+		return
+	}
+	pool.MinActiveMembers = minActive
+}
 
+// hasPriorityGroups returns true when any pool member is in a priority group
+func hasPriorityGroups(pool *bigipResources.Pool) bool {
+	for _, m := range pool.Members {
+		if 0 != m.PriorityGroup {
+			return true
+		}
+	}
+	return false
 }
 
 // removePool returns true when the pool is deleted else false
 func (r *F5Router) removePool(pool *bigipResources.Pool) bool {
 	key := pool.Name
+	r.cache.invalidate(poolCacheKey(r.objectPartition(key), key))
 
 	p, exists := r.poolResources[key]
 	if exists {
 		for i, addr := range p.Members {
 			// Currently only a single update comes through at a time so we always
 			// know to look at the first addr
-			if addr == pool.Members[0] {
-				p.Members[i] = p.Members[len(p.Members)-1]
-				p.Members[len(p.Members)-1] = bigipResources.Member{Address: "", Port: 0, Session: ""}
+			if sameMember(addr, pool.Members[0]) {
+				copy(p.Members[i:], p.Members[i+1:])
+				p.Members[len(p.Members)-1] = bigipResources.Member{}
 				p.Members = p.Members[:len(p.Members)-1]
+				break
 			}
 		}
+		r.setMinActiveMembers(p)
+		r.checkPoolMembers(p)
 		// delete the pool and virtual if there are no members
 		if len(p.Members) == 0 {
 			delete(r.poolResources, key)
@@ -1505,8 +3274,58 @@ func (r *F5Router) removePool(pool *bigipResources.Pool) bool {
 	return false
 }
 
+// checkPoolMembers warns once when a pool grows to the soft member threshold,
+// members are never dropped and the warning is reset once the pool shrinks
+// below the threshold
+func (r *F5Router) checkPoolMembers(pool *bigipResources.Pool) {
+	threshold := r.c.BigIP.PoolMemberWarning
+	if 0 == threshold {
+		return
+	}
+	if len(pool.Members) < threshold {
+		delete(r.poolMemberWarnings, pool.Name)
+		return
+	}
+	if r.poolMemberWarnings[pool.Name] {
+		return
+	}
+	r.poolMemberWarnings[pool.Name] = true
+
+	r.logger.Warn("f5router-pool-member-warning",
+		zap.String("pool", pool.Name),
+		zap.Int("members", len(pool.Members)),
+		zap.Int("threshold", threshold),
+	)
+	if nil != r.reporter {
+		r.reporter.CapturePoolMemberWarning(pool.Name, len(pool.Members))
+	}
+}
+
+// sameMember returns true when both members are the same endpoint, the ratio
+// can change without the endpoint changing
+func sameMember(a, b bigipResources.Member) bool {
+	return a.Address == b.Address && a.Port == b.Port
+}
+
+// memberLess orders pool members by address and port
+func memberLess(a, b bigipResources.Member) bool {
+	if a.Address != b.Address {
+		return a.Address < b.Address
+	}
+	return a.Port < b.Port
+}
+
+// memberRatio is the ratio BIG-IP uses for a member, unset is the default of 1
+func memberRatio(m bigipResources.Member) int {
+	if 0 == m.Ratio {
+		return 1
+	}
+	return m.Ratio
+}
+
 func (r *F5Router) addVirtual(vs *bigipResources.Virtual) {
 	key := vs.VirtualServerName
+	r.cache.invalidate(virtualCacheKey(r.objectPartition(key), key))
 
 	_, exist := r.virtualResources[key]
 	if !exist {
@@ -1515,14 +3334,41 @@ func (r *F5Router) addVirtual(vs *bigipResources.Virtual) {
 }
 
 func (r *F5Router) removeVirtual(key string) {
+	r.cache.invalidate(virtualCacheKey(r.objectPartition(key), key))
 	delete(r.virtualResources, key)
 }
 
+// setExternalPool records whether a route's plan points its virtual at a pool
+// managed outside the controller, the route pool is left out of the config
+func (r *F5Router) setExternalPool(name string, planResources bigipResources.Resources) {
+	if len(planResources.Virtuals) != 0 && planResources.Virtuals[0].PoolName != "" {
+		r.externalPools[name] = true
+	}
+}
+
+// setMonitorOnly sets whether a route is bound to a monitor only plan, its
+// virtual and routing rule are left out of the config while it is
+func (r *F5Router) setMonitorOnly(name string, monitorOnly bool) {
+	if r.monitorOnly[name] == monitorOnly {
+		return
+	}
+	r.routePolicy = nil
+	r.sniPolicy = nil
+	if monitorOnly {
+		r.monitorOnly[name] = true
+	} else {
+		delete(r.monitorOnly, name)
+	}
+}
+
 func (r *F5Router) addRule(ru updateHTTP) {
 	rule, err := r.makeRouteRule(ru)
 	if nil != err {
 		r.logger.Warn("f5router-rule-error", zap.Error(err))
+		return
 	}
+	r.routePolicy = nil
+	r.sniPolicy = nil
 
 	if strings.Contains(ru.URI().String(), "*") {
 		r.wildcards[ru.URI()] = rule
@@ -1540,6 +3386,8 @@ func (r *F5Router) addRule(ru updateHTTP) {
 }
 
 func (r *F5Router) removeRule(ru updateHTTP) {
+	r.routePolicy = nil
+	r.sniPolicy = nil
 	if strings.Contains(ru.URI().String(), "*") {
 		delete(r.wildcards, ru.URI())
 		r.logger.Debug("f5router-wildcard-rule-removed",
@@ -1555,13 +3403,213 @@ func (r *F5Router) removeRule(ru updateHTTP) {
 	}
 }
 
-// UpdateRoute send update information to processor
+// SetRoutes replaces the routes known to the router with the desired set of
+// route adds, the differences are applied by the worker as a single update so
+// the config is written once for the whole set. Plan bindings are left as they
+// are and the routes excluded by the route filter are left out of the set.
+func (r *F5Router) SetRoutes(desired []routeUpdate.RouteUpdate) {
+	managed := make([]routeUpdate.RouteUpdate, 0, len(desired))
+	for _, ru := range desired {
+		if r.routeManaged(ru) {
+			managed = append(managed, ru)
+		}
+	}
+	r.logger.Debug("f5router-setting-routes", zap.Int("routes", len(managed)))
+	r.queue.Add(&routeSet{routes: managed})
+}
+
+// processRouteSet adds the desired routes which are missing or changed then
+// removes the members which are not in the desired set
+func (r *F5Router) processRouteSet(set *routeSet) {
+	desired := make(map[string][]bigipResources.Member)
+	var added, removed int
+
+	for _, ru := range set.routes {
+		if ru.Op() != routeUpdate.Add {
+			r.logger.Warn("f5router-route-set-skipping-update",
+				zap.String("operation", ru.Op().String()),
+				zap.String("route", ru.Route()),
+			)
+			continue
+		}
+		switch u := ru.(type) {
+		case updateHTTP:
+			if nil == u.endpoint {
+				r.logger.Warn("f5router-route-set-skipping-update",
+					zap.String("operation", ru.Op().String()),
+					zap.String("route", ru.Route()),
+				)
+				continue
+			}
+			member := bigipResources.Member{
+				Address:         u.endpoint.Address,
+				Port:            u.endpoint.Port,
+				Ratio:           u.routeWeight(r.c),
+				ConnectionLimit: u.routeConnectionLimit(r.c),
+				PriorityGroup:   u.routePriorityGroup(r.c),
+			}
+			desired[u.Name()] = append(desired[u.Name()], member)
+			if !r.hasMember(u.Name(), member) {
+				r.processRouteAdd(u)
+				added++
+			}
+		case updateTCP:
+			desired[u.Name()] = append(desired[u.Name()], u.member)
+			if !r.hasMember(u.Name(), u.member) {
+				r.processTCPRouteAdd(u)
+				added++
+			}
+		default:
+			r.logger.Warn("f5router-route-set-skipping-update",
+				zap.String("operation", ru.Op().String()),
+				zap.String("route", ru.Route()),
+			)
+		}
+	}
+
+	// Collect the current routes first since removing them updates the maps
+	httpRoutes := make(map[string]route.Uri)
+	for _, rules := range []bigipResources.RuleMap{r.r, r.wildcards} {
+		for uri := range rules {
+			httpRoutes[makeObjectName(uri.String())] = uri
+		}
+	}
+	for name, pool := range r.poolResources {
+		uri, isHTTP := httpRoutes[name]
+		port, isTCP := r.tcpRoutePort(name)
+		if !isHTTP && !isTCP {
+			continue
+		}
+		for _, m := range append([]bigipResources.Member(nil), pool.Members...) {
+			if containsMember(desired[name], m) {
+				continue
+			}
+			if isHTTP {
+				ep := &route.Endpoint{Address: m.Address, Port: m.Port}
+				ru, err := NewUpdate(r.logger, routeUpdate.Remove, uri, ep, "")
+				if nil != err {
+					r.logger.Warn("f5router-route-set-remove-error", zap.Error(err))
+					continue
+				}
+				r.processRouteRemove(ru)
+			} else {
+				ru, err := NewTCPUpdate(r.c, r.logger, routeUpdate.Remove, port, m)
+				if nil != err {
+					r.logger.Warn("f5router-route-set-remove-error", zap.Error(err))
+					continue
+				}
+				r.processTCPRouteRemove(ru)
+			}
+			removed++
+		}
+	}
+
+	r.logger.Info("f5router-routes-set",
+		zap.Int("added", added),
+		zap.Int("removed", removed),
+	)
+}
+
+// hasMember returns true when the named pool already has the member with the
+// same ratio, connection limit and priority group
+func (r *F5Router) hasMember(name string, member bigipResources.Member) bool {
+	pool, ok := r.poolResources[name]
+	if !ok {
+		return false
+	}
+	for _, m := range pool.Members {
+		if sameMember(m, member) && memberRatio(m) == memberRatio(member) &&
+			m.ConnectionLimit == member.ConnectionLimit && m.PriorityGroup == member.PriorityGroup {
+			return true
+		}
+	}
+	return false
+}
+
+// tcpRoutePort returns the route port for the name of a TCP route's pool
+func (r *F5Router) tcpRoutePort(name string) (uint16, bool) {
+	prefix := strings.TrimSuffix(createTCPObjectName(r.c, 0), "0")
+	if !strings.HasPrefix(name, prefix) {
+		return 0, false
+	}
+	port, err := strconv.ParseUint(strings.TrimPrefix(name, prefix), 10, 16)
+	if nil != err {
+		return 0, false
+	}
+	return uint16(port), true
+}
+
+func containsMember(members []bigipResources.Member, member bigipResources.Member) bool {
+	for _, m := range members {
+		if sameMember(m, member) {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdateRoute send update information to processor, with a pending updates
+// limit it waits until the worker makes room for the update, so callers must
+// not hold locks their readers need. Updates of the routes excluded by the
+// route filter are dropped.
 func (r *F5Router) UpdateRoute(ru routeUpdate.RouteUpdate) {
+	if !r.routeManaged(ru) {
+		return
+	}
+	if 0 != r.c.BigIP.MaxPendingUpdates {
+		r.pendingLock.Lock()
+		defer r.pendingLock.Unlock()
+		r.waitForPendingRoom()
+	}
+	r.addRouteUpdate(ru)
+}
+
+// addRouteUpdate queues a route update for the worker, it is not held back by
+// the pending updates limit
+func (r *F5Router) addRouteUpdate(ru routeUpdate.RouteUpdate) {
 	r.logger.Debug("f5router-updating-pool",
 		zap.String("operation", ru.Op().String()),
 		zap.String("route-type", ru.Protocol()),
 		zap.String("route", ru.Route()),
 	)
+	if nil != r.metrics {
+		r.metrics.routeUpdate(ru.Op())
+	}
 	// WARNING: This only accepts hashable types!
 	r.queue.Add(ru)
 }
+
+// waitForPendingRoom blocks while the queue holds the pending updates limit,
+// the queue shutting down releases the waiting updates. The caller holds
+// pendingLock.
+func (r *F5Router) waitForPendingRoom() {
+	limit := r.c.BigIP.MaxPendingUpdates
+	if r.queue.Len() >= limit {
+		r.logger.Debug("f5router-route-update-waiting",
+			zap.Int("pending", r.queue.Len()),
+			zap.Int("limit", limit),
+		)
+	}
+	for r.queue.Len() >= limit && !r.queue.ShuttingDown() {
+		r.pendingCond.Wait()
+	}
+}
+
+// pendingRoom wakes the route updates waiting for room in the queue
+func (r *F5Router) pendingRoom() {
+	if 0 == r.c.BigIP.MaxPendingUpdates {
+		return
+	}
+	r.pendingLock.Lock()
+	r.pendingCond.Broadcast()
+	r.pendingLock.Unlock()
+}
+
+// shutDownQueue stops the worker once the queue is drained, route updates
+// waiting for room are dropped
+func (r *F5Router) shutDownQueue() {
+	r.queue.ShutDown()
+	r.pendingLock.Lock()
+	r.pendingCond.Broadcast()
+	r.pendingLock.Unlock()
+}
@@ -0,0 +1,162 @@
+/*-
+ * Copyright (c) 2018, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package f5router
+
+import (
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/F5Networks/cf-bigip-ctlr/f5router/bigipResources"
+	"github.com/F5Networks/cf-bigip-ctlr/f5router/routeUpdate"
+	"github.com/F5Networks/cf-bigip-ctlr/logger"
+	"github.com/F5Networks/cf-bigip-ctlr/route"
+
+	"github.com/uber-go/zap"
+)
+
+// Benchmarks for the config generation hot path, run with:
+//   go test ./f5router -run XXX -bench . -benchmem
+
+var benchmarkRouteCounts = []int{1000, 10000, 50000}
+
+type discardWriter struct{}
+
+func (dw discardWriter) GetOutputFilename() string {
+	return "discard"
+}
+
+func (dw discardWriter) Write(input []byte) (int, error) {
+	return len(input), nil
+}
+
+// newBenchmarkRouter creates a router holding the requested number of routes,
+// spread across the partitions when any are given
+func newBenchmarkRouter(b *testing.B, routes int, partitions ...string) *F5Router {
+	l := logger.NewLogger(
+		"f5router-benchmark",
+		zap.Output(zap.AddSync(ioutil.Discard)),
+		zap.InfoLevel,
+	)
+	c := makeConfig()
+	c.BigIP.Tier2IPRange = "10.0.0.0/16"
+	if 0 != len(partitions) {
+		c.BigIP.Partitions = partitions
+	}
+
+	r, err := NewF5Router(l, c, discardWriter{}, nil)
+	if nil != err {
+		b.Fatal(err)
+	}
+	// Run would normally set these up from the BIG-IP data group
+	r.internalDataGroup = make(map[string]*bigipResources.InternalDataGroupRecord)
+	r.firstSyncDone = true
+
+	for i := 0; i < routes; i++ {
+		uri := route.Uri(fmt.Sprintf("app-%d.cf.com", i))
+		if i%10 == 0 {
+			uri = route.Uri(fmt.Sprintf("*.app-%d.cf.com", i))
+		}
+		ru, err := NewUpdate(l, routeUpdate.Add, uri, makeEndpoint("127.0.0.1"), "")
+		if nil != err {
+			b.Fatal(err)
+		}
+		r.processRouteAdd(ru)
+	}
+	return r
+}
+
+// BenchmarkRouteUpdate measures a single route update through the worker,
+// including regenerating and writing out the full config
+func BenchmarkRouteUpdate(b *testing.B) {
+	for _, routes := range benchmarkRouteCounts {
+		b.Run(fmt.Sprintf("routes-%d", routes), func(b *testing.B) {
+			r := newBenchmarkRouter(b, routes)
+			ep := makeEndpoint("127.0.0.2")
+
+			updates := make([]updateHTTP, 2*routes)
+			for i := 0; i < routes; i++ {
+				uri := route.Uri(fmt.Sprintf("new-app-%d.cf.com", i))
+				add, err := NewUpdate(r.logger, routeUpdate.Add, uri, ep, "")
+				if nil != err {
+					b.Fatal(err)
+				}
+				remove, err := NewUpdate(r.logger, routeUpdate.Remove, uri, ep, "")
+				if nil != err {
+					b.Fatal(err)
+				}
+				updates[2*i] = add
+				updates[2*i+1] = remove
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				r.queue.Add(updates[n%len(updates)])
+				r.process()
+			}
+		})
+	}
+}
+
+// BenchmarkSerialization measures generating and marshaling the resources
+// without any route changes
+func BenchmarkSerialization(b *testing.B) {
+	for _, routes := range benchmarkRouteCounts {
+		b.Run(fmt.Sprintf("routes-%d", routes), func(b *testing.B) {
+			r := newBenchmarkRouter(b, routes)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				output, err := r.marshalConfig()
+				if nil != err {
+					b.Fatal(err)
+				}
+				if len(output) == 0 {
+					b.Fatal("empty config")
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkParallelSerialization compares writing the partition sections on
+// one and on four workers, the routes are spread across four partitions. Run
+// it with -cpu 1,4 to see the speedup on a machine with four or more cores.
+func BenchmarkParallelSerialization(b *testing.B) {
+	for _, routes := range benchmarkRouteCounts {
+		for _, workers := range []int{1, 4} {
+			b.Run(fmt.Sprintf("routes-%d/workers-%d", routes, workers), func(b *testing.B) {
+				r := newBenchmarkRouter(b, routes, "cf", "cf-1", "cf-2", "cf-3")
+				r.cache.workers = workers
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				for n := 0; n < b.N; n++ {
+					output, err := r.marshalConfig()
+					if nil != err {
+						b.Fatal(err)
+					}
+					if len(output) == 0 {
+						b.Fatal("empty config")
+					}
+				}
+			})
+		}
+	}
+}
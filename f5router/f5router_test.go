@@ -17,20 +17,30 @@
 package f5router
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"math/rand"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/F5Networks/cf-bigip-ctlr/bigipclient"
 	fakeClient "github.com/F5Networks/cf-bigip-ctlr/bigipclient/fakes"
 	"github.com/F5Networks/cf-bigip-ctlr/config"
 	"github.com/F5Networks/cf-bigip-ctlr/f5router/bigipResources"
 	"github.com/F5Networks/cf-bigip-ctlr/f5router/routeUpdate"
+	"github.com/F5Networks/cf-bigip-ctlr/handlers"
+	fakeMetrics "github.com/F5Networks/cf-bigip-ctlr/metrics/fakes"
 	"github.com/F5Networks/cf-bigip-ctlr/route"
 	"github.com/F5Networks/cf-bigip-ctlr/servicebroker/planResources"
 	"github.com/F5Networks/cf-bigip-ctlr/test_util"
@@ -109,6 +119,59 @@ var _ = Describe("F5Router", func() {
 				}
 			})
 		})
+
+		Context("rules by precedence", func() {
+			sorted := func(uris ...string) []string {
+				rls := bigipResources.RulesByPrecedence{}
+				for _, uri := range uris {
+					rls = append(rls, &bigipResources.Rule{FullURI: uri})
+				}
+				sort.Sort(rls)
+				var result []string
+				for _, rl := range rls {
+					result = append(result, rl.FullURI)
+				}
+				return result
+			}
+
+			It("should rank exact hosts before specific and broad wildcards", func() {
+				Expect(sorted(
+					"*.cf.com",
+					"ser*.cf.com",
+					"baz.cf.com/segment1",
+					"*vic*.cf.com",
+					"foo.cf.com",
+					"*.foo.cf.com",
+					"baz.cf.com",
+					"ser*es.cf.com",
+					"bar.cf.com",
+					"*vices.cf.com",
+					"baz.cf.com/segment1/segment2/segment3",
+				)).To(Equal([]string{
+					"foo.cf.com",
+					"baz.cf.com/segment1/segment2/segment3",
+					"baz.cf.com/segment1",
+					"baz.cf.com",
+					"bar.cf.com",
+					"ser*es.cf.com",
+					"*vices.cf.com",
+					"*.foo.cf.com",
+					"ser*.cf.com",
+					"*vic*.cf.com",
+					"*.cf.com",
+				}))
+			})
+
+			It("should rank a longer wildcard host first regardless of its name", func() {
+				// A lexical sort puts the broader *.cf.com first
+				Expect(sorted("*.a.cf.com", "*.cf.com", "*.cf.com/path", "*.a.cf.com/path")).To(Equal([]string{
+					"*.a.cf.com/path",
+					"*.a.cf.com",
+					"*.cf.com/path",
+					"*.cf.com",
+				}))
+			})
+		})
 	})
 
 	Describe("verify configs", func() {
@@ -151,7 +214,7 @@ var _ = Describe("F5Router", func() {
 			Expect(r).To(BeNil())
 			Expect(err).To(HaveOccurred())
 
-			c.BigIP.ExternalAddr = "127.0.0.1"
+			c.BigIP.ExternalAddr = config.AddressList{"127.0.0.1"}
 			r, err = NewF5Router(logger, c, mw, client)
 			Expect(r).NotTo(BeNil())
 			Expect(err).NotTo(HaveOccurred())
@@ -167,7 +230,7 @@ var _ = Describe("F5Router", func() {
 			c.BigIP.User = "admin"
 			c.BigIP.Pass = "pass"
 			c.BigIP.Partitions = []string{"cf"}
-			c.BigIP.ExternalAddr = "127.0.0.1"
+			c.BigIP.ExternalAddr = config.AddressList{"127.0.0.1"}
 
 			c.BigIP.Tier2IPRange = "10.0.0.1"
 			r, err := NewF5Router(logger, c, mw, client)
@@ -222,219 +285,7240 @@ var _ = Describe("F5Router", func() {
 			Expect(r).NotTo(BeNil())
 			Expect(err).NotTo(HaveOccurred())
 		})
+
+		It("should validate the load balancing mode", func() {
+			logger := test_util.NewTestZapLogger("router-test")
+			c := makeConfig()
+
+			for _, mode := range LoadBalancingModes {
+				c.BigIP.LoadBalancingMode = mode
+				r, err := NewF5Router(logger, c, &MockWriter{}, nil)
+				Expect(r).NotTo(BeNil())
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			c.BigIP.LoadBalancingMode = "least-connection"
+			r, err := NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(r).To(BeNil())
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(HavePrefix("invalid load_balancing_mode least-connection"))
+		})
+
+		It("should validate the BIG-IP CA certificate file", func() {
+			logger := test_util.NewTestZapLogger("router-test")
+			c := makeConfig()
+			c.BigIP.CACertFile = "does-not-exist.pem"
+			r, err := NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(r).To(BeNil())
+			Expect(err).To(MatchError(HavePrefix("ca_cert_file does-not-exist.pem is not readable")))
+
+			c.BigIP.CACertFile = "../testdata/fake_driver.py"
+			_, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).To(MatchError("ca_cert_file ../testdata/fake_driver.py holds no PEM certificates"))
+
+			c.BigIP.CACertFile = "../test/assets/certs/uaa-ca.pem"
+			mw := &MockWriter{}
+			r, err = NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+			Expect(err).NotTo(HaveOccurred())
+			stop := runRouter(r)
+			defer stop()
+
+			// Passed on to the driver in the config
+			Expect(string(mw.getOutput())).To(ContainSubstring(
+				`"ssl_verify":true,"ca_cert_file":"../test/assets/certs/uaa-ca.pem"`))
+		})
+
+		It("should validate the connection limits", func() {
+			logger := test_util.NewTestZapLogger("router-test")
+			c := makeConfig()
+			c.BigIP.ConnectionLimit = 100
+			c.BigIP.MaxConnectionLimit = 100
+			r, err := NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(r).NotTo(BeNil())
+			Expect(err).NotTo(HaveOccurred())
+
+			c.BigIP.ConnectionLimit = -1
+			_, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).To(MatchError(ContainSubstring("must not be negative")))
+
+			c.BigIP.ConnectionLimit = 100
+			c.BigIP.MaxConnectionLimit = -1
+			_, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).To(MatchError(ContainSubstring("must not be negative")))
+
+			c.BigIP.ConnectionLimit = 101
+			c.BigIP.MaxConnectionLimit = 100
+			_, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).To(MatchError("connection_limit 101 exceeds max_connection_limit 100"))
+		})
+
+		It("should validate the rate limit", func() {
+			logger := test_util.NewTestZapLogger("router-test")
+			c := makeConfig()
+			c.BigIP.RateLimit = -1
+			r, err := NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(r).To(BeNil())
+			Expect(err).To(MatchError("rate_limit must not be negative: -1"))
+		})
+
+		It("should validate the waf policy", func() {
+			logger := test_util.NewTestZapLogger("router-test")
+			c := makeConfig()
+			c.BigIP.WAF.Enabled = true
+			r, err := NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(r).To(BeNil())
+			Expect(err).To(MatchError("waf requires a policy when enabled"))
+
+			c.BigIP.WAF.Policy = "waf"
+			_, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).To(MatchError(ContainSubstring("invalid waf policy")))
+
+			c.BigIP.WAF.Policy = "/Common/waf"
+			_, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should validate the min active members", func() {
+			logger := test_util.NewTestZapLogger("router-test")
+			c := makeConfig()
+			c.BigIP.MinActiveMembers = -1
+			r, err := NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(r).To(BeNil())
+			Expect(err).To(MatchError("min_active_members must be between 0 and 65535: -1"))
+
+			c.BigIP.MinActiveMembers = 65536
+			_, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).To(MatchError("min_active_members must be between 0 and 65535: 65536"))
+
+			c.BigIP.MinActiveMembers = 2
+			_, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should validate the source address translation", func() {
+			logger := test_util.NewTestZapLogger("router-test")
+			c := makeConfig()
+			c.BigIP.SNAT.Type = "pool"
+			r, err := NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(r).To(BeNil())
+			Expect(err).To(MatchError(ContainSubstring("invalid snat type pool")))
+
+			c.BigIP.SNAT.Type = config.SNAT_POOL
+			r, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(r).To(BeNil())
+			Expect(err).To(MatchError("snat type snat requires a snat pool"))
+
+			c.BigIP.SNAT.Pool = "/Common/cf-snatpool"
+			_, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should validate the VLAN restriction", func() {
+			logger := test_util.NewTestZapLogger("router-test")
+			c := makeConfig()
+			c.BigIP.VLANs.Mode = "some"
+			r, err := NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(r).To(BeNil())
+			Expect(err).To(MatchError(ContainSubstring("invalid vlans mode some")))
+
+			c.BigIP.VLANs.Mode = config.VLAN_ENABLED
+			r, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(r).To(BeNil())
+			Expect(err).To(MatchError("vlans mode enabled requires vlan names"))
+
+			c.BigIP.VLANs.Names = []string{"/Common/external", ""}
+			r, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(r).To(BeNil())
+			Expect(err).To(MatchError("vlans names must not be empty"))
+
+			c.BigIP.VLANs.Names = []string{"/Common/external"}
+			_, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			// Disabled on no VLAN still listens on all of them
+			c.BigIP.VLANs = config.VLANConfig{Mode: config.VLAN_DISABLED}
+			_, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should validate the idle timeout and TCP profile", func() {
+			logger := test_util.NewTestZapLogger("router-test")
+			c := makeConfig()
+			c.BigIP.IdleTimeout = -1
+			r, err := NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(r).To(BeNil())
+			Expect(err).To(MatchError("idle_timeout must not be negative: -1"))
+
+			c.BigIP.IdleTimeout = 0
+			c.BigIP.TCPProfile = "cf-tcp"
+			r, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(r).To(BeNil())
+			Expect(err).To(MatchError(ContainSubstring("invalid tcp_profile")))
+		})
+
+		It("should validate the request logging profile", func() {
+			logger := test_util.NewTestZapLogger("router-test")
+			c := makeConfig()
+			c.BigIP.RequestLoggingProfile = "cf-request-log"
+			r, err := NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(r).To(BeNil())
+			Expect(err).To(MatchError(ContainSubstring("invalid request_logging_profile")))
+		})
+
+		It("should validate the HTTP profile and its header limits", func() {
+			logger := test_util.NewTestZapLogger("router-test")
+			c := makeConfig()
+			c.BigIP.HTTPProfile.MaxHeaderSize = 65536
+			r, err := NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(r).To(BeNil())
+			Expect(err).To(MatchError("http_profile header limits require the name of the profile setting them"))
+
+			c.BigIP.HTTPProfile.Name = "cf-http"
+			_, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).To(MatchError(ContainSubstring("invalid http_profile name")))
+
+			c.BigIP.HTTPProfile.Name = "/Common/cf-http"
+			c.BigIP.HTTPProfile.MaxHeaderCount = -1
+			_, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).To(MatchError(
+				"http_profile max_header_size and max_header_count must not be negative: 65536, -1"))
+
+			c.BigIP.HTTPProfile.MaxHeaderCount = 128
+			_, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should validate the re-encryption server SSL profile", func() {
+			logger := test_util.NewTestZapLogger("router-test")
+			c := makeConfig()
+			c.BigIP.Reencrypt.Enabled = true
+			r, err := NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(r).To(BeNil())
+			Expect(err).To(MatchError("reencrypt requires a server_ssl profile when enabled"))
+
+			c.BigIP.Reencrypt.ServerSSL = "serverssl"
+			_, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).To(MatchError(ContainSubstring("invalid reencrypt server_ssl profile")))
+
+			c.BigIP.Reencrypt.ServerSSL = "/Common/serverssl"
+			_, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should validate the OneConnect profile", func() {
+			logger := test_util.NewTestZapLogger("router-test")
+			c := makeConfig()
+			c.BigIP.OneConnectProfile = "oneconnect"
+			r, err := NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(r).To(BeNil())
+			Expect(err).To(MatchError(ContainSubstring("invalid oneconnect_profile")))
+		})
 	})
 
-	Describe("httpUpdate", func() {
-		var httpUpdate updateHTTP
-		Context("UpdateResources", func() {
-			var oldResources bigipResources.Resources
-			var newResources bigipResources.Resources
+	Describe("HTTPS virtual", func() {
+		var (
+			logger *test_util.TestZapLogger
+			c      *config.Config
+		)
 
-			BeforeEach(func() {
-				httpUpdate = updateHTTP{}
-				policies := []*bigipResources.NameRef{&bigipResources.NameRef{
-					Name:      "test-policy",
-					Partition: "test",
-				}}
-				profiles := []*bigipResources.ProfileRef{&bigipResources.ProfileRef{
-					Name:      "test-profile",
-					Partition: "test",
-					Context:   "all",
-				}}
-				oldResources.Virtuals = []*bigipResources.Virtual{&bigipResources.Virtual{
-					VirtualServerName: "test-route-virtual",
-					PoolName:          "test-route-pool",
-					Profiles:          profiles,
-					Policies:          policies,
-				}}
-				oldResources.Pools = []*bigipResources.Pool{&bigipResources.Pool{
-					Name:         "test-route-pool",
-					Balance:      "round-robin",
-					MonitorNames: []string{"monitor1", "monitor2"},
-				}}
-				oldResources.Monitors = []*bigipResources.Monitor{&bigipResources.Monitor{
-					Name: "test-route-monitor",
-					Type: "http",
-				}}
-				newResources = bigipResources.Resources{}
-			})
+		BeforeEach(func() {
+			logger = test_util.NewTestZapLogger("router-test")
+			c = makeConfig()
+		})
 
-			It("should not update", func() {
-				updatedResources := httpUpdate.UpdateResources(oldResources, newResources)
-				Expect(updatedResources).To(Equal(oldResources))
-			})
+		AfterEach(func() {
+			if nil != logger {
+				logger.Close()
+			}
+		})
 
-			It("should update virtuals profiles and policies only", func() {
-				newPolicies := []*bigipResources.NameRef{&bigipResources.NameRef{
-					Name:      "new-test-policy",
-					Partition: "test",
-				}}
-				newProfiles := []*bigipResources.ProfileRef{&bigipResources.ProfileRef{
-					Name:      "new-test-profile",
-					Partition: "test",
+		clientSSLProfiles := func() []*bigipResources.ProfileRef {
+			r, err := NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			vs, ok := r.virtualResources[HTTPSRouterName]
+			if !ok {
+				return nil
+			}
+			var profiles []*bigipResources.ProfileRef
+			for _, p := range vs.Profiles {
+				if p.Context == "clientside" {
+					profiles = append(profiles, p)
+				}
+			}
+			return profiles
+		}
+
+		It("should not create the HTTPS virtual without SSL profiles", func() {
+			Expect(clientSSLProfiles()).To(BeNil())
+		})
+
+		It("should attach the default clientssl profile", func() {
+			c.BigIP.DefaultClientSSL = "/Common/wildcard-clientssl"
+			Expect(clientSSLProfiles()).To(Equal([]*bigipResources.ProfileRef{
+				&bigipResources.ProfileRef{
+					Name:      "wildcard-clientssl",
+					Partition: "Common",
 					Context:   "clientside",
-				}}
-				newResources.Virtuals = []*bigipResources.Virtual{&bigipResources.Virtual{
-					VirtualServerName: "update-route-virtual",
-					PoolName:          "update-route-pool",
-					Profiles:          newProfiles,
-					Policies:          newPolicies,
-				}}
-				updatedResources := httpUpdate.UpdateResources(oldResources, newResources)
+				}}))
+		})
 
-				Expect(len(updatedResources.Virtuals)).To(Equal(1))
-				Expect(updatedResources.Virtuals[0].VirtualServerName).To(Equal(oldResources.Virtuals[0].VirtualServerName))
-				Expect(updatedResources.Virtuals[0].PoolName).To(Equal(oldResources.Virtuals[0].PoolName))
-				Expect(updatedResources.Virtuals[0].Profiles).To(Equal(newProfiles))
-				Expect(updatedResources.Virtuals[0].Policies).To(Equal(newPolicies))
-				Expect(len(updatedResources.Virtuals[0].Profiles)).To(Equal(1))
-				Expect(len(updatedResources.Virtuals[0].Policies)).To(Equal(1))
+		It("should not attach the default when SSL profiles are configured", func() {
+			c.BigIP.DefaultClientSSL = "/Common/wildcard-clientssl"
+			c.BigIP.SSLProfiles = []string{"/Common/clientssl"}
+			Expect(clientSSLProfiles()).To(Equal([]*bigipResources.ProfileRef{
+				&bigipResources.ProfileRef{
+					Name:      "clientssl",
+					Partition: "Common",
+					Context:   "clientside",
+				}}))
+		})
 
-				Expect(len(updatedResources.Pools)).To(Equal(1))
-				Expect(len(updatedResources.Monitors)).To(Equal(1))
-				Expect(updatedResources.Pools[0]).To(Equal(oldResources.Pools[0]))
-				Expect(updatedResources.Monitors[0]).To(Equal(oldResources.Monitors[0]))
-			})
+		It("should require a valid client SSL profile", func() {
+			for _, profiles := range [][]string{{""}, {"clientssl"}, {"/Common/ssl/clientssl"}} {
+				c.BigIP.SSLProfiles = profiles
+				r, err := NewF5Router(logger, c, &MockWriter{}, nil)
+				Expect(r).To(BeNil())
+				Expect(err).To(MatchError(ContainSubstring("no valid client SSL profile")))
+			}
 
-			It("should update pools balance and monitor names only", func() {
-				newResources.Pools = []*bigipResources.Pool{&bigipResources.Pool{
-					Name:         "update-route-pool",
-					Balance:      "fastest-node",
-					MonitorNames: []string{"monitor3"},
-				}}
-				updatedResources := httpUpdate.UpdateResources(oldResources, newResources)
+			c.BigIP.SSLProfiles = nil
+			c.BigIP.DefaultClientSSL = "wildcard-clientssl"
+			_, err := NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).To(MatchError(ContainSubstring("no valid client SSL profile")))
+		})
 
-				Expect(len(updatedResources.Pools)).To(Equal(1))
-				Expect(updatedResources.Pools[0].Name).To(Equal(oldResources.Pools[0].Name))
-				Expect(updatedResources.Pools[0].Balance).To(Equal(newResources.Pools[0].Balance))
-				Expect(updatedResources.Pools[0].MonitorNames).To(Equal(newResources.Pools[0].MonitorNames))
-				Expect(len(updatedResources.Pools[0].MonitorNames)).To(Equal(1))
+		It("should keep the HTTPS virtual unchanged across route updates", func() {
+			c.BigIP.DefaultClientSSL = "/Common/wildcard-clientssl"
+			mw := &MockWriter{}
+			router, err := NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+			Expect(err).NotTo(HaveOccurred())
+			stop := runRouter(router)
+			defer stop()
 
-				Expect(len(updatedResources.Virtuals)).To(Equal(1))
-				Expect(len(updatedResources.Monitors)).To(Equal(1))
-				Expect(updatedResources.Virtuals[0]).To(Equal(oldResources.Virtuals[0]))
-				Expect(updatedResources.Monitors[0]).To(Equal(oldResources.Monitors[0]))
-			})
+			httpsVirtual := func() string {
+				for _, vs := range mw.getResources("cf").Virtuals {
+					if vs.VirtualServerName == HTTPSRouterName {
+						js, err := json.Marshal(vs)
+						Expect(err).NotTo(HaveOccurred())
+						return string(js)
+					}
+				}
+				return ""
+			}
+			pools := func() []*bigipResources.Pool {
+				return mw.getResources("cf").Pools
+			}
+			add := func(uri route.Uri) {
+				ru, err := NewUpdate(logger, routeUpdate.Add, uri, makeEndpoint("127.0.0.1"), "")
+				Expect(err).NotTo(HaveOccurred())
+				router.UpdateRoute(ru)
+			}
 
-			It("should update monitors", func() {
-				newResources.Monitors = []*bigipResources.Monitor{&bigipResources.Monitor{
-					Name: "update-route-monitor",
-					Type: "tcp",
-				}}
-				updatedResources := httpUpdate.UpdateResources(oldResources, newResources)
+			add("foo.cf.com")
+			Eventually(pools).Should(HaveLen(1))
+			before := httpsVirtual()
+			Expect(before).To(ContainSubstring(`"name":"wildcard-clientssl","partition":"Common","context":"clientside"`))
+			for _, uri := range []route.Uri{"bar.cf.com/path", "*.cf.com"} {
+				add(uri)
+			}
+			Eventually(pools).Should(HaveLen(3))
+			Expect(httpsVirtual()).To(Equal(before))
+		})
+	})
 
-				Expect(len(updatedResources.Virtuals)).To(Equal(1))
-				Expect(len(updatedResources.Pools)).To(Equal(1))
-				Expect(len(updatedResources.Monitors)).To(Equal(1))
-				Expect(updatedResources.Virtuals[0]).To(Equal(oldResources.Virtuals[0]))
-				Expect(updatedResources.Pools[0]).To(Equal(oldResources.Pools[0]))
-				Expect(updatedResources.Monitors[0]).To(Equal(newResources.Monitors[0]))
-			})
+	Describe("virtual server config", func() {
+		var (
+			logger *test_util.TestZapLogger
+			c      *config.Config
+		)
+
+		BeforeEach(func() {
+			logger = test_util.NewTestZapLogger("router-test")
+			c = makeConfig()
 		})
 
-		Context("CreatePlanResources", func() {
-			var plan planResources.Plan
-			var logger *test_util.TestZapLogger
-			var c *config.Config
+		AfterEach(func() {
+			if nil != logger {
+				logger.Close()
+			}
+		})
+
+		destinations := func() map[string]string {
+			mw := &MockWriter{}
+			r, err := NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+			Expect(err).NotTo(HaveOccurred())
+			stop := runRouter(r)
+			defer stop()
+
+			ru, err := NewUpdate(logger, routeUpdate.Add, "foo.cf.com", makeEndpoint("127.0.0.1"), "")
+			Expect(err).NotTo(HaveOccurred())
+			r.UpdateRoute(ru)
+			Eventually(func() []*bigipResources.Pool {
+				return mw.getResources("cf").Pools
+			}).Should(HaveLen(1))
+
+			dests := make(map[string]string)
+			for _, vs := range mw.getResources("cf").Virtuals {
+				// Only the routing virtuals, leave out the one of the route
+				if vs.VirtualServerName != makeObjectName("foo.cf.com") {
+					dests[vs.VirtualServerName] = vs.Destination
+				}
+			}
+			return dests
+		}
+
+		// output returns the config written for a single route
+		output := func() string {
+			mw := &MockWriter{}
+			r, err := NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+			Expect(err).NotTo(HaveOccurred())
+			stop := runRouter(r)
+			defer stop()
+
+			ru, err := NewUpdate(logger, routeUpdate.Add, "foo.cf.com", makeEndpoint("127.0.0.1"), "")
+			Expect(err).NotTo(HaveOccurred())
+			r.UpdateRoute(ru)
+			Eventually(func() []*bigipResources.Pool {
+				return mw.getResources("cf").Pools
+			}).Should(HaveLen(1))
+			return string(mw.getOutput())
+		}
+
+		It("should default the ports of an ExternalAddr only config", func() {
+			Expect(destinations()).To(Equal(map[string]string{
+				HTTPRouterName: "/cf/127.0.0.1:80",
+			}))
+			Expect(c.BigIP.VirtualServer.Address).To(Equal("127.0.0.1"))
+
+			c.BigIP.VirtualServer.HTTPPort = 0
+			c.BigIP.VirtualServer.HTTPSPort = 0
+			c.BigIP.DefaultClientSSL = "/Common/wildcard-clientssl"
+			Expect(destinations()).To(Equal(map[string]string{
+				HTTPRouterName:  "/cf/127.0.0.1:80",
+				HTTPSRouterName: "/cf/127.0.0.1:443",
+			}))
+		})
+
+		It("should create both virtuals on the configured ports", func() {
+			c.BigIP.ExternalAddr = nil
+			c.BigIP.VirtualServer = config.VirtualServerConfig{
+				Address:   "10.1.1.10",
+				HTTPPort:  8080,
+				HTTPSPort: 8443,
+			}
+			c.BigIP.DefaultClientSSL = "/Common/wildcard-clientssl"
+			Expect(destinations()).To(Equal(map[string]string{
+				HTTPRouterName:  "/cf/10.1.1.10:8080",
+				HTTPSRouterName: "/cf/10.1.1.10:8443",
+			}))
+			// TCP routes listen on the virtual server address
+			Expect(c.BigIP.ExternalAddr).To(Equal(config.AddressList{"10.1.1.10"}))
+		})
+
+		It("should prefer the virtual server address over ExternalAddr", func() {
+			c.BigIP.VirtualServer.Address = "10.1.1.10"
+			Expect(destinations()).To(Equal(map[string]string{
+				HTTPRouterName: "/cf/10.1.1.10:80",
+			}))
+			Expect(c.BigIP.ExternalAddr).To(Equal(config.AddressList{"127.0.0.1"}))
+		})
+
+		It("should support IPv6 addresses", func() {
+			c.BigIP.VirtualServer = config.VirtualServerConfig{
+				Address:   "2001:db8::10",
+				HTTPPort:  80,
+				HTTPSPort: 443,
+			}
+			c.BigIP.SSLProfiles = []string{"/Common/clientssl"}
+			Expect(destinations()).To(Equal(map[string]string{
+				HTTPRouterName:  "/cf/2001:db8::10.80",
+				HTTPSRouterName: "/cf/2001:db8::10.443",
+			}))
+		})
+
+		It("should write bracketed, expanded and route domain addresses canonically", func() {
+			c.BigIP.ExternalAddr = config.AddressList{"[2001:DB8:0::10]", "10.1.1.10%2", "2001:db8::20%3"}
+			Expect(destinations()).To(Equal(map[string]string{
+				HTTPRouterName:                     "/cf/2001:db8::10.80",
+				HTTPRouterName + "-10.1.1.10_2":    "/cf/10.1.1.10%2:80",
+				HTTPRouterName + "-2001.db8..20_3": "/cf/2001:db8::20%3.80",
+			}))
+			Expect(c.BigIP.ExternalAddr).To(Equal(config.AddressList{"2001:db8::10", "10.1.1.10%2", "2001:db8::20%3"}))
+
+			c.BigIP.ExternalAddr = config.AddressList{"2001:db8::10", "[2001:db8::10]"}
+			_, err := NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).To(MatchError("duplicate external_addr: [2001:db8::10]"))
+		})
+
+		It("should write an IPv6 only config", func() {
+			c.BigIP.ExternalAddr = config.AddressList{"2001:db8::10"}
+			c.BigIP.Tier2IPRange = "2001:db8:1::/64"
+			mw := &MockWriter{}
+			r, err := NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+			Expect(err).NotTo(HaveOccurred())
+			stop := runRouter(r)
+			defer stop()
+
+			for _, addr := range []string{"2001:db8:2::1", "[2001:DB8:2::2]"} {
+				ru, err := NewUpdate(logger, routeUpdate.Add, "foo.cf.com", makeEndpoint(addr), "")
+				Expect(err).NotTo(HaveOccurred())
+				r.UpdateRoute(ru)
+			}
+
+			Eventually(func() []bigipResources.Member {
+				pools := mw.getResources("cf").Pools
+				if 1 != len(pools) {
+					return nil
+				}
+				return pools[0].Members
+			}).Should(HaveLen(2))
+			rs := mw.getResources("cf")
+			Expect(rs.Pools[0].Members[0].Address).To(Equal("2001:db8:2::1"))
+			Expect(rs.Pools[0].Members[1].Address).To(Equal("2001:db8:2::2"))
+
+			dests := make(map[string]string)
+			for _, vs := range rs.Virtuals {
+				dests[vs.VirtualServerName] = vs.Destination
+			}
+			Expect(dests).To(HaveKeyWithValue(HTTPRouterName, "/cf/2001:db8::10.80"))
+			Expect(dests).To(HaveKey(makeObjectName("foo.cf.com")))
+			Expect(dests[makeObjectName("foo.cf.com")]).To(MatchRegexp(`^/cf/2001:db8:1::[0-9a-f:]*\.[0-9]+$`))
+		})
+
+		It("should create the routing virtuals on each external address", func() {
+			c.BigIP.ExternalAddr = config.AddressList{"127.0.0.1", "10.1.1.10", "2001:db8::10"}
+			c.BigIP.DefaultClientSSL = "/Common/wildcard-clientssl"
+			Expect(destinations()).To(Equal(map[string]string{
+				HTTPRouterName:                    "/cf/127.0.0.1:80",
+				HTTPSRouterName:                   "/cf/127.0.0.1:443",
+				HTTPRouterName + "-10.1.1.10":     "/cf/10.1.1.10:80",
+				HTTPSRouterName + "-10.1.1.10":    "/cf/10.1.1.10:443",
+				HTTPRouterName + "-2001.db8..10":  "/cf/2001:db8::10.80",
+				HTTPSRouterName + "-2001.db8..10": "/cf/2001:db8::10.443",
+			}))
+			Expect(c.BigIP.VirtualServer.Address).To(BeEmpty())
+
+			c.BigIP.VirtualServer.Address = "10.2.2.20"
+			Expect(destinations()).To(Equal(map[string]string{
+				HTTPRouterName:  "/cf/10.2.2.20:80",
+				HTTPSRouterName: "/cf/10.2.2.20:443",
+			}))
+		})
+
+		It("should create the TCP route virtuals on each external address", func() {
+			c.BigIP.ExternalAddr = config.AddressList{"127.0.0.1", "10.1.1.10"}
+			c.RoutingMode = config.TCP
+			c.TCPRouterGroupName = "default-tcp"
+			mw := &MockWriter{}
+			r, err := NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+			Expect(err).NotTo(HaveOccurred())
+			stop := runRouter(r)
+			defer stop()
+
+			member := bigipResources.Member{Address: "10.0.0.1", Port: 5000}
+			tu, err := NewTCPUpdate(c, logger, routeUpdate.Add, 6010, member)
+			Expect(err).NotTo(HaveOccurred())
+			r.UpdateRoute(tu)
+
+			dests := func() map[string]string {
+				d := make(map[string]string)
+				for _, vs := range mw.getResources("cf").Virtuals {
+					Expect(vs.PoolName).To(Equal("/cf/cf-tcp-route-default-tcp-6010"))
+					d[vs.VirtualServerName] = vs.Destination
+				}
+				return d
+			}
+			Eventually(dests).Should(Equal(map[string]string{
+				"cf-tcp-route-default-tcp-6010":           "/cf/127.0.0.1:6010",
+				"cf-tcp-route-default-tcp-6010-10.1.1.10": "/cf/10.1.1.10:6010",
+			}))
+
+			tu, err = NewTCPUpdate(c, logger, routeUpdate.Remove, 6010, member)
+			Expect(err).NotTo(HaveOccurred())
+			r.UpdateRoute(tu)
+			Eventually(dests).Should(BeEmpty())
+		})
+
+		It("should reject duplicate external addresses", func() {
+			c.BigIP.ExternalAddr = config.AddressList{"10.1.1.10", "2001:db8::10", "2001:DB8:0::10"}
+			r, err := NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(r).To(BeNil())
+			Expect(err).To(MatchError("duplicate external_addr: 2001:DB8:0::10"))
+
+			c.BigIP.ExternalAddr = config.AddressList{"10.1.1.10", "10.1.1.11"}
+			_, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should create network virtuals with the external mask", func() {
+			c.BigIP.ExternalAddr = config.AddressList{"10.1.0.0", "2001:db8::"}
+			c.BigIP.ExternalMask = "255.255.0.0"
+			_, err := NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).To(MatchError("external_mask 255.255.0.0 is not an IPv6 mask for 2001:db8::"))
+
+			c.BigIP.ExternalAddr = config.AddressList{"10.1.0.0"}
+			Expect(destinations()).To(Equal(map[string]string{
+				HTTPRouterName: "/cf/10.1.0.0:80",
+			}))
+			Expect(output()).To(ContainSubstring(
+				`"name":"routing-vip-http","ipProtocol":"tcp","enabled":true,` +
+					`"destination":"/cf/10.1.0.0:80","mask":"255.255.0.0"`))
+
+			c.RoutingMode = config.TCP
+			c.TCPRouterGroupName = "default-tcp"
+			mw := &MockWriter{}
+			r, err := NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+			Expect(err).NotTo(HaveOccurred())
+			stop := runRouter(r)
+			defer stop()
+			member := bigipResources.Member{Address: "10.0.0.1", Port: 5000}
+			tu, err := NewTCPUpdate(c, logger, routeUpdate.Add, 6010, member)
+			Expect(err).NotTo(HaveOccurred())
+			r.UpdateRoute(tu)
+			Eventually(func() string {
+				return string(mw.getOutput())
+			}).Should(ContainSubstring(
+				`"name":"cf-tcp-route-default-tcp-6010","pool":"/cf/cf-tcp-route-default-tcp-6010",` +
+					`"ipProtocol":"tcp","enabled":true,"destination":"/cf/10.1.0.0:6010","mask":"255.255.0.0"`))
+
+			c = makeConfig()
+			c.BigIP.ExternalAddr = config.AddressList{"2001:db8::"}
+			c.BigIP.ExternalMask = "ffff:ffff::"
+			Expect(output()).To(ContainSubstring(
+				`"destination":"/cf/2001:db8::.80","mask":"ffff:ffff::"`))
+		})
+
+		It("should keep host virtuals without an external mask", func() {
+			out := output()
+			Expect(out).To(ContainSubstring(`"name":"routing-vip-http"`))
+			Expect(out).NotTo(ContainSubstring(`"mask"`))
+		})
+
+		It("should validate the external mask", func() {
+			c.BigIP.ExternalAddr = config.AddressList{"10.1.1.0"}
+			for mask, msg := range map[string]string{
+				"255.255.255":   "invalid external_mask 255.255.255",
+				"255.0.255.0":   "invalid external_mask 255.0.255.0, the mask bits must be contiguous",
+				"ffff::":        "external_mask ffff:: is not an IPv4 mask for 10.1.1.0",
+				"255.255.254.0": "external_addr 10.1.1.0 has host bits set outside external_mask 255.255.254.0",
+			} {
+				c.BigIP.ExternalMask = mask
+				r, err := NewF5Router(logger, c, &MockWriter{}, nil)
+				Expect(r).To(BeNil())
+				Expect(err).To(MatchError(msg))
+			}
+
+			c.BigIP.ExternalMask = "255.255.255.0"
+			c.BigIP.VirtualServer.Address = "10.2.2.1"
+			_, err := NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).To(MatchError(
+				"external_addr 10.2.2.1 has host bits set outside external_mask 255.255.255.0"))
+
+			c.BigIP.ExternalMask = "255.255.255.255"
+			_, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should validate the address and ports", func() {
+			c.BigIP.ExternalAddr = nil
+			r, err := NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(r).To(BeNil())
+			Expect(err).To(MatchError(ContainSubstring("required parameter missing")))
+
+			c.BigIP.VirtualServer.Address = "cf.example.com"
+			r, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(r).To(BeNil())
+			Expect(err).To(MatchError("invalid address: cf.example.com"))
+
+			c.BigIP.VirtualServer.Address = "10.1.1.10"
+			c.BigIP.ExternalAddr = config.AddressList{"not-an-address"}
+			r, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(r).To(BeNil())
+			Expect(err).To(MatchError("invalid address: not-an-address"))
+
+			c.BigIP.ExternalAddr = config.AddressList{"10.1.1.10"}
+			c.BigIP.VirtualServer.HTTPPort = 443
+			r, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(r).To(BeNil())
+			Expect(err).To(MatchError("virtual_server http_port and https_port must differ: 443"))
+		})
+
+		It("should limit the connection rate of the client facing virtuals", func() {
+			c.BigIP.DefaultClientSSL = "/Common/wildcard-clientssl"
+			r, err := NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			for _, vs := range r.virtualResources {
+				Expect(vs.RateLimit).To(BeZero())
+			}
+			js, err := json.Marshal(r.virtualResources[HTTPRouterName])
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(js)).NotTo(ContainSubstring("rateLimit"))
+
+			c.BigIP.RateLimit = 500
+			r, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(r.virtualResources[HTTPRouterName].RateLimit).To(Equal(500))
+			Expect(r.virtualResources[HTTPSRouterName].RateLimit).To(Equal(500))
+			js, err = json.Marshal(r.virtualResources[HTTPRouterName])
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(js)).To(HaveSuffix(`"rateLimit":500}`))
+
+			member := bigipResources.Member{Address: "10.0.0.1", Port: 5000}
+			tu, err := NewTCPUpdate(c, logger, routeUpdate.Add, 6010, member)
+			Expect(err).NotTo(HaveOccurred())
+			rs, err := tu.CreateResources(c)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rs.Virtuals[0].RateLimit).To(Equal(500))
+
+			// The tier2 virtuals are only reached through the routing virtuals
+			ru, err := NewUpdate(logger, routeUpdate.Add, "foo.cf.com", makeEndpoint("127.0.0.1"), "")
+			Expect(err).NotTo(HaveOccurred())
+			rs, err = ru.CreateResources(c)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rs.Virtuals[0].RateLimit).To(BeZero())
+		})
+
+		It("should mirror the connections of the virtuals when enabled", func() {
+			c.BigIP.DefaultClientSSL = "/Common/wildcard-clientssl"
+			Expect(output()).NotTo(ContainSubstring("connectionMirroring"))
+
+			c.BigIP.ConnectionMirroring = true
+			var written configMatcher
+			Expect(json.Unmarshal([]byte(output()), &written)).To(Succeed())
+			mirrored := make(map[string]bool)
+			for _, vs := range written.Resources["cf"].Virtuals {
+				mirrored[vs.VirtualServerName] = vs.ConnectionMirroring
+			}
+			Expect(mirrored).To(Equal(map[string]bool{
+				HTTPRouterName:               true,
+				HTTPSRouterName:              true,
+				makeObjectName("foo.cf.com"): true,
+			}))
+
+			member := bigipResources.Member{Address: "10.0.0.1", Port: 5000}
+			tu, err := NewTCPUpdate(c, logger, routeUpdate.Add, 6010, member)
+			Expect(err).NotTo(HaveOccurred())
+			rs, err := tu.CreateResources(c)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rs.Virtuals[0].ConnectionMirroring).To(BeTrue())
+
+			ru, err := NewUpdate(logger, routeUpdate.Add, "foo.cf.com", makeEndpoint("127.0.0.1"), "")
+			Expect(err).NotTo(HaveOccurred())
+			rs, err = ru.CreateResources(c)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rs.Virtuals[0].ConnectionMirroring).To(BeTrue())
+		})
+
+		It("should restrict the client facing virtuals to the VLANs", func() {
+			c.BigIP.DefaultClientSSL = "/Common/wildcard-clientssl"
+			r, err := NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			js, err := json.Marshal(r.virtualResources[HTTPRouterName])
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(js)).NotTo(ContainSubstring("vlans"))
+
+			c.BigIP.VLANs = config.VLANConfig{
+				Mode:  config.VLAN_ENABLED,
+				Names: []string{"/Common/external", "/Common/dmz"},
+			}
+			r, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			for _, name := range []string{HTTPRouterName, HTTPSRouterName} {
+				js, err = json.Marshal(r.virtualResources[name])
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(js)).To(ContainSubstring(
+					`"vlans":["/Common/external","/Common/dmz"],"vlansEnabled":true`))
+				Expect(string(js)).NotTo(ContainSubstring("vlansDisabled"))
+			}
+
+			c.BigIP.VLANs.Mode = config.VLAN_DISABLED
+			member := bigipResources.Member{Address: "10.0.0.1", Port: 5000}
+			tu, err := NewTCPUpdate(c, logger, routeUpdate.Add, 6010, member)
+			Expect(err).NotTo(HaveOccurred())
+			rs, err := tu.CreateResources(c)
+			Expect(err).NotTo(HaveOccurred())
+			js, err = json.Marshal(rs.Virtuals[0])
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(js)).To(ContainSubstring(
+				`"vlans":["/Common/external","/Common/dmz"],"vlansDisabled":true`))
+			Expect(string(js)).NotTo(ContainSubstring("vlansEnabled"))
+
+			// The tier2 virtuals are only reached through the routing virtuals
+			ru, err := NewUpdate(logger, routeUpdate.Add, "foo.cf.com", makeEndpoint("127.0.0.1"), "")
+			Expect(err).NotTo(HaveOccurred())
+			rs, err = ru.CreateResources(c)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rs.Virtuals[0].VLANs).To(BeEmpty())
+			Expect(rs.Virtuals[0].VLANsDisabled).To(BeFalse())
+		})
+
+		It("should override connection mirroring with the route tag", func() {
+			c.BigIP.ConnectionMirroring = true
+			mirroring := func(value string) bool {
+				ep := makeEndpoint("127.0.0.1")
+				ep.Tags["connection_mirroring"] = value
+				ru, err := NewUpdate(logger, routeUpdate.Add, "foo.cf.com", ep, "")
+				Expect(err).NotTo(HaveOccurred())
+				rs, err := ru.CreateResources(c)
+				Expect(err).NotTo(HaveOccurred())
+				return rs.Virtuals[0].ConnectionMirroring
+			}
+
+			Expect(mirroring("false")).To(BeFalse())
+			Expect(mirroring("true")).To(BeTrue())
+			Expect(mirroring("sometimes")).To(BeTrue())
+			Eventually(logger).Should(Say(`"skipping-route-connection-mirroring".*sometimes`))
+
+			c.BigIP.ConnectionMirroring = false
+			Expect(mirroring("1")).To(BeTrue())
+			Expect(mirroring("sometimes")).To(BeFalse())
+		})
+
+		It("should set the source address translation of every virtual", func() {
+			c.BigIP.DefaultClientSSL = "/Common/wildcard-clientssl"
+			virtuals := func() []*bigipResources.Virtual {
+				r, err := NewF5Router(logger, c, &MockWriter{}, nil)
+				Expect(err).NotTo(HaveOccurred())
+				vs := []*bigipResources.Virtual{
+					r.virtualResources[HTTPRouterName],
+					r.virtualResources[HTTPSRouterName],
+				}
+
+				member := bigipResources.Member{Address: "10.0.0.1", Port: 5000}
+				tu, err := NewTCPUpdate(c, logger, routeUpdate.Add, 6010, member)
+				Expect(err).NotTo(HaveOccurred())
+				rs, err := tu.CreateResources(c)
+				Expect(err).NotTo(HaveOccurred())
+				vs = append(vs, rs.Virtuals...)
+
+				ru, err := NewUpdate(logger, routeUpdate.Add, "foo.cf.com", makeEndpoint("127.0.0.1"), "")
+				Expect(err).NotTo(HaveOccurred())
+				rs, err = ru.CreateResources(c)
+				Expect(err).NotTo(HaveOccurred())
+				return append(vs, rs.Virtuals...)
+			}
+
+			// automap is the default
+			vs := virtuals()
+			Expect(vs).To(HaveLen(4))
+			for _, v := range vs {
+				Expect(v.SourceAddrTranslation).To(Equal(
+					bigipResources.SourceAddrTranslation{Type: "automap"}))
+			}
+			js, err := json.Marshal(vs[0].SourceAddrTranslation)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(js)).To(Equal(`{"type":"automap"}`))
+
+			c.BigIP.SNAT = config.SNATConfig{Type: config.SNAT_NONE, Pool: "/Common/unused"}
+			for _, v := range virtuals() {
+				Expect(v.SourceAddrTranslation).To(Equal(
+					bigipResources.SourceAddrTranslation{Type: "none"}))
+			}
+
+			c.BigIP.SNAT = config.SNATConfig{Type: config.SNAT_POOL, Pool: "/Common/cf-snatpool"}
+			vs = virtuals()
+			for _, v := range vs {
+				Expect(v.SourceAddrTranslation).To(Equal(
+					bigipResources.SourceAddrTranslation{Type: "snat", Pool: "/Common/cf-snatpool"}))
+			}
+			js, err = json.Marshal(vs[0].SourceAddrTranslation)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(js)).To(Equal(`{"type":"snat","pool":"/Common/cf-snatpool"}`))
+		})
+
+		It("should set the TCP profile and idle timeout of the virtuals", func() {
+			c.BigIP.DefaultClientSSL = "/Common/wildcard-clientssl"
+			tcpProfile := &bigipResources.ProfileRef{Name: "tcp", Partition: "Common", Context: "all"}
+			r, err := NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(r.virtualResources[HTTPRouterName].Profiles).To(ContainElement(tcpProfile))
+			Expect(r.ruleResources).NotTo(HaveKey(bigipResources.IdleTimeoutIRuleName))
+
+			c = makeConfig()
+			c.BigIP.DefaultClientSSL = "/Common/wildcard-clientssl"
+			c.BigIP.TCPProfile = "/Common/cf-tcp-long-idle"
+			c.BigIP.IdleTimeout = 3600
+			r, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(r.ruleResources[bigipResources.IdleTimeoutIRuleName].Code).To(
+				ContainSubstring("IP::idle_timeout 3600"))
+
+			customProfile := &bigipResources.ProfileRef{
+				Name:      "cf-tcp-long-idle",
+				Partition: "Common",
+				Context:   "all",
+			}
+			idleTimeoutPath := "/cf/" + bigipResources.IdleTimeoutIRuleName
+			for _, name := range []string{HTTPRouterName, HTTPSRouterName} {
+				vs := r.virtualResources[name]
+				Expect(vs.Profiles).To(ContainElement(customProfile))
+				Expect(vs.Profiles).NotTo(ContainElement(tcpProfile))
+				Expect(vs.IRules).To(ContainElement(idleTimeoutPath))
+			}
+
+			member := bigipResources.Member{Address: "10.0.0.1", Port: 5000}
+			tu, err := NewTCPUpdate(c, logger, routeUpdate.Add, 6010, member)
+			Expect(err).NotTo(HaveOccurred())
+			rs, err := tu.CreateResources(c)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rs.Virtuals[0].Profiles).To(Equal([]*bigipResources.ProfileRef{customProfile}))
+			Expect(rs.Virtuals[0].IRules).To(Equal([]string{idleTimeoutPath}))
+
+			// The tier2 virtuals use the profile, the timeout is set once the
+			// client connects to the routing virtual
+			ru, err := NewUpdate(logger, routeUpdate.Add, "foo.cf.com", makeEndpoint("127.0.0.1"), "")
+			Expect(err).NotTo(HaveOccurred())
+			rs, err = ru.CreateResources(c)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rs.Virtuals[0].Profiles).To(ContainElement(customProfile))
+			Expect(rs.Virtuals[0].IRules).NotTo(ContainElement(idleTimeoutPath))
+		})
+
+		It("should attach the request logging profile to the HTTP virtuals", func() {
+			c.BigIP.DefaultClientSSL = "/Common/wildcard-clientssl"
+			written := func() string {
+				mw := &MockWriter{}
+				r, err := NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+				Expect(err).NotTo(HaveOccurred())
+				stop := runRouter(r)
+				defer stop()
+				ru, err := NewUpdate(logger, routeUpdate.Add, "foo.cf.com", makeEndpoint("127.0.0.1"), "")
+				Expect(err).NotTo(HaveOccurred())
+				r.UpdateRoute(ru)
+				Eventually(func() []*bigipResources.Pool {
+					return mw.getResources("cf").Pools
+				}).Should(HaveLen(1))
+				return string(mw.getOutput())
+			}
+
+			Expect(written()).NotTo(ContainSubstring("cf-request-log"))
+
+			c.BigIP.RequestLoggingProfile = "/Common/cf-request-log"
+			output := written()
+			profile := `{"name":"cf-request-log","partition":"Common","context":"all"}`
+			Expect(strings.Count(output, profile)).To(Equal(3))
+			Expect(output).To(ContainSubstring(
+				`"profiles":[{"name":"http","partition":"Common","context":"all"},` +
+					`{"name":"tcp","partition":"Common","context":"all"},` + profile + `]`))
+			Expect(written()).To(Equal(output))
+			Eventually(logger).Should(Say("f5router-request-logging-profile-attached"))
+
+			// Plans replacing the profiles keep the request logging profile
+			logProfile := &bigipResources.ProfileRef{Name: "cf-request-log", Partition: "Common", Context: "all"}
+			ru, err := NewUpdate(logger, routeUpdate.Add, "foo.cf.com", makeEndpoint("127.0.0.1"), "")
+			Expect(err).NotTo(HaveOccurred())
+			plan := planResources.Plan{
+				VirtualServer: planResources.VirtualType{
+					Profiles: []string{"/Common/http2", "/Common/cf-request-log"},
+				},
+			}
+			rs := ru.CreatePlanResources(c, plan)
+			Expect(rs.Virtuals[0].Profiles).To(HaveLen(2))
+			Expect(rs.Virtuals[0].Profiles).To(ContainElement(logProfile))
+
+			plan.VirtualServer.Profiles = []string{"/Common/http2"}
+			rs = ru.CreatePlanResources(c, plan)
+			Expect(rs.Virtuals[0].Profiles).To(ContainElement(logProfile))
+
+			// TCP virtuals have no HTTP profile for request logging
+			member := bigipResources.Member{Address: "10.0.0.1", Port: 5000}
+			tu, err := NewTCPUpdate(c, logger, routeUpdate.Add, 6010, member)
+			Expect(err).NotTo(HaveOccurred())
+			rs, err = tu.CreateResources(c)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rs.Virtuals[0].Profiles).NotTo(ContainElement(logProfile))
+		})
+
+		It("should reference the configured HTTP profile with its header limits", func() {
+			c.BigIP.DefaultClientSSL = "/Common/wildcard-clientssl"
+			written := func() string {
+				mw := &MockWriter{}
+				r, err := NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+				Expect(err).NotTo(HaveOccurred())
+				stop := runRouter(r)
+				defer stop()
+				ru, err := NewUpdate(logger, routeUpdate.Add, "foo.cf.com", makeEndpoint("127.0.0.1"), "")
+				Expect(err).NotTo(HaveOccurred())
+				r.UpdateRoute(ru)
+				Eventually(func() []*bigipResources.Pool {
+					return mw.getResources("cf").Pools
+				}).Should(HaveLen(1))
+				return string(mw.getOutput())
+			}
+
+			output := written()
+			Expect(output).NotTo(ContainSubstring("http_max_header"))
+			Expect(strings.Count(output, `{"name":"http","partition":"Common","context":"all"}`)).To(Equal(3))
+
+			c.BigIP.HTTPProfile = config.HTTPProfileConfig{
+				Name:           "/Common/cf-http-large-headers",
+				MaxHeaderSize:  65536,
+				MaxHeaderCount: 128,
+			}
+			output = written()
+			Expect(output).NotTo(ContainSubstring(`{"name":"http","partition":"Common","context":"all"}`))
+			profile := `{"name":"cf-http-large-headers","partition":"Common","context":"all"}`
+			Expect(strings.Count(output, profile)).To(Equal(3))
+			limits := `"metadata":[{"name":"http_max_header_size","value":"65536"},` +
+				`{"name":"http_max_header_count","value":"128"}]`
+			Expect(strings.Count(output, limits)).To(Equal(2))
+			Expect(written()).To(Equal(output))
+			Eventually(logger).Should(Say("f5router-http-profile-attached"))
+
+			c.BigIP.HTTPProfile.MaxHeaderCount = 0
+			Expect(written()).To(ContainSubstring(
+				`"metadata":[{"name":"http_max_header_size","value":"65536"}]`))
+
+			// Only the HTTP profile is replaced, TCP virtuals have none
+			member := bigipResources.Member{Address: "10.0.0.1", Port: 5000}
+			tu, err := NewTCPUpdate(c, logger, routeUpdate.Add, 6010, member)
+			Expect(err).NotTo(HaveOccurred())
+			rs, err := tu.CreateResources(c)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rs.Virtuals[0].Profiles).To(Equal([]*bigipResources.ProfileRef{
+				{Name: "tcp", Partition: "Common", Context: "all"},
+			}))
+			Expect(rs.Virtuals[0].Metadata).To(BeEmpty())
+		})
+
+		It("should attach the OneConnect profile to the HTTP virtuals", func() {
+			c.BigIP.DefaultClientSSL = "/Common/wildcard-clientssl"
+			written := func() string {
+				mw := &MockWriter{}
+				r, err := NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+				Expect(err).NotTo(HaveOccurred())
+				stop := runRouter(r)
+				defer stop()
+				ru, err := NewUpdate(logger, routeUpdate.Add, "foo.cf.com", makeEndpoint("127.0.0.1"), "")
+				Expect(err).NotTo(HaveOccurred())
+				r.UpdateRoute(ru)
+				Eventually(func() []*bigipResources.Pool {
+					return mw.getResources("cf").Pools
+				}).Should(HaveLen(1))
+				return string(mw.getOutput())
+			}
+
+			Expect(written()).NotTo(ContainSubstring("oneconnect"))
+
+			c.BigIP.OneConnectProfile = "/Common/oneconnect"
+			output := written()
+			profile := `{"name":"oneconnect","partition":"Common","context":"all"}`
+			Expect(strings.Count(output, profile)).To(Equal(3))
+			Expect(output).To(ContainSubstring(
+				`"profiles":[{"name":"http","partition":"Common","context":"all"},` +
+					`{"name":"tcp","partition":"Common","context":"all"},` + profile + `]`))
+			Expect(written()).To(Equal(output))
+			Eventually(logger).Should(Say("f5router-oneconnect-profile-attached"))
+
+			// The OneConnect profile comes before the request logging profile
+			c.BigIP.RequestLoggingProfile = "/Common/cf-request-log"
+			Expect(written()).To(ContainSubstring(profile +
+				`,{"name":"cf-request-log","partition":"Common","context":"all"}]`))
+
+			// Plans replacing the profiles keep the OneConnect profile
+			oneConnect := &bigipResources.ProfileRef{Name: "oneconnect", Partition: "Common", Context: "all"}
+			ru, err := NewUpdate(logger, routeUpdate.Add, "foo.cf.com", makeEndpoint("127.0.0.1"), "")
+			Expect(err).NotTo(HaveOccurred())
+			plan := planResources.Plan{
+				VirtualServer: planResources.VirtualType{
+					Profiles: []string{"/Common/http2"},
+				},
+			}
+			rs := ru.CreatePlanResources(c, plan)
+			Expect(rs.Virtuals[0].Profiles).To(ContainElement(oneConnect))
+
+			member := bigipResources.Member{Address: "10.0.0.1", Port: 5000}
+			tu, err := NewTCPUpdate(c, logger, routeUpdate.Add, 6010, member)
+			Expect(err).NotTo(HaveOccurred())
+			rs, err = tu.CreateResources(c)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rs.Virtuals[0].Profiles).NotTo(ContainElement(oneConnect))
+		})
+	})
+
+	Describe("routes sharing the routing virtuals", func() {
+		var (
+			logger *test_util.TestZapLogger
+			c      *config.Config
+		)
+
+		BeforeEach(func() {
+			logger = test_util.NewTestZapLogger("router-test")
+			c = makeConfig()
+			c.BigIP.SSLProfiles = []string{"/Common/clientssl"}
+		})
+
+		AfterEach(func() {
+			if nil != logger {
+				logger.Close()
+			}
+		})
+
+		writtenResources := func() *bigipResources.Resources {
+			mw := &MockWriter{}
+			router, err := NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+			Expect(err).NotTo(HaveOccurred())
+			stop := runRouter(router)
+			defer stop()
+
+			for i, uri := range []route.Uri{"foo.cf.com", "bar.cf.com", "bar.cf.com/path", "*.cf.com"} {
+				ru, err := NewUpdate(logger, routeUpdate.Add, uri, makeEndpoint(fmt.Sprintf("127.0.0.%d", i+1)), "")
+				Expect(err).NotTo(HaveOccurred())
+				router.UpdateRoute(ru)
+			}
+			Eventually(func() []*bigipResources.Pool {
+				return mw.getResources("cf").Pools
+			}).Should(HaveLen(4))
+			return mw.getResources("cf")
+		}
+
+		findVirtual := func(rs *bigipResources.Resources, name string) *bigipResources.Virtual {
+			for _, vs := range rs.Virtuals {
+				if vs.VirtualServerName == name {
+					return vs
+				}
+			}
+			return nil
+		}
+
+		It("should match every host with an explicit rule", func() {
+			rs := writtenResources()
+
+			var policy *bigipResources.Policy
+			for _, p := range rs.Policies {
+				if p.Name == CFRoutingPolicyName {
+					policy = p
+				}
+			}
+			Expect(policy).NotTo(BeNil())
+			Expect(len(policy.Rules)).To(Equal(4))
+
+			names := map[string]bool{}
+			for _, rule := range policy.Rules {
+				Expect(rule.Conditions).NotTo(BeEmpty())
+				Expect(rule.Conditions[0].HTTPHost).To(BeTrue())
+				Expect(rule.Actions).To(HaveLen(1))
+				Expect(rule.Actions[0].TmName).To(Equal("target_vip"))
+				Expect(rule.Actions[0].Expression).To(Equal(rule.Name))
+				Expect(rule.Actions[0].Pool).To(BeEmpty())
+				names[rule.Name] = true
+			}
+			Expect(names).To(Equal(map[string]bool{
+				makeObjectName("foo.cf.com"):      true,
+				makeObjectName("bar.cf.com"):      true,
+				makeObjectName("bar.cf.com/path"): true,
+				makeObjectName("*.cf.com"):        true,
+			}))
+
+			// Only the tier2 virtuals have the route pools as their default
+			for _, name := range []string{HTTPRouterName, HTTPSRouterName} {
+				Expect(findVirtual(rs, name).PoolName).To(BeEmpty())
+			}
+			for _, pool := range rs.Pools {
+				Expect(findVirtual(rs, pool.Name).PoolName).To(Equal("/cf/" + pool.Name))
+			}
+		})
+
+		It("should only use the catch-all as the routing virtuals default pool", func() {
+			c.BigIP.DefaultPool = "Common/catch-all"
+			rs := writtenResources()
+
+			for _, name := range []string{HTTPRouterName, HTTPSRouterName} {
+				Expect(findVirtual(rs, name).PoolName).To(Equal("/Common/catch-all"))
+			}
+			for _, pool := range rs.Pools {
+				Expect(pool.Name).NotTo(Equal("catch-all"))
+			}
+		})
+
+		It("should attach the configured iRules in order after the forwarding iRule", func() {
+			rs := writtenResources()
+			for _, name := range []string{HTTPRouterName, HTTPSRouterName} {
+				Expect(findVirtual(rs, name).IRules).To(Equal([]string{"/cf/forward-to-vip"}))
+			}
+
+			c.BigIP.IRules = []string{"/Common/redirect", "Common/add-headers"}
+			rs = writtenResources()
+			for _, name := range []string{HTTPRouterName, HTTPSRouterName} {
+				Expect(findVirtual(rs, name).IRules).To(Equal(
+					[]string{"/cf/forward-to-vip", "/Common/redirect", "/Common/add-headers"}))
+			}
+			for _, pool := range rs.Pools {
+				Expect(findVirtual(rs, pool.Name).IRules).NotTo(ContainElement("/Common/redirect"))
+			}
+		})
+
+		It("should reject iRules which are not BIG-IP paths", func() {
+			for _, iRules := range [][]string{{""}, {"redirect"}, {"/Common/"}, {"//redirect"}} {
+				c.BigIP.IRules = iRules
+				_, err := NewF5Router(logger, c, &MockWriter{}, nil)
+				Expect(err).To(MatchError(ContainSubstring("invalid irules")))
+			}
+		})
+
+		It("should skip a catch-all which is not a BIG-IP path", func() {
+			c.BigIP.DefaultPool = "catch-all"
+			rs := writtenResources()
+
+			Expect(findVirtual(rs, HTTPRouterName).PoolName).To(BeEmpty())
+		})
+	})
+
+	Describe("context path routes", func() {
+		var logger *test_util.TestZapLogger
+
+		BeforeEach(func() {
+			logger = test_util.NewTestZapLogger("router-test")
+		})
+
+		AfterEach(func() {
+			if nil != logger {
+				logger.Close()
+			}
+		})
+
+		pathSegments := func(conditions []*bigipResources.Condition) []string {
+			var segments []string
+			for i, cond := range conditions {
+				Expect(cond.Name).To(Equal(strconv.Itoa(i)))
+				if cond.PathSegment {
+					Expect(cond.HTTPURI).To(BeTrue())
+					Expect(cond.Equals).To(BeTrue())
+					Expect(cond.Index).To(Equal(len(segments) + 1))
+					segments = append(segments, cond.Values...)
+				}
+			}
+			return segments
+		}
+
+		It("should match the host and each path segment", func() {
+			c, err := makeRouteConditions("baz.cf.com/segment1/segment2/")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c[0].Values).To(Equal([]string{"baz.cf.com"}))
+			Expect(pathSegments(c)).To(Equal([]string{"segment1", "segment2"}))
+
+			c, err = makeRouteConditions("baz.cf.com")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c).To(HaveLen(1))
+		})
+
+		It("should match the path of a wildcard host route", func() {
+			c, err := makeRouteConditions("ser*es.cf.com/segment1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c).To(HaveLen(3))
+			Expect(c[0].StartsWith).To(BeTrue())
+			Expect(c[1].EndsWith).To(BeTrue())
+			Expect(pathSegments(c)).To(Equal([]string{"segment1"}))
+
+			c, err = makeRouteConditions("*.cf.com/segment1/segment2")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(c).To(HaveLen(3))
+			Expect(c[0].EndsWith).To(BeTrue())
+			Expect(pathSegments(c)).To(Equal([]string{"segment1", "segment2"}))
+		})
+
+		It("should order the routing rules by the longest path first", func() {
+			mw := &MockWriter{}
+			router, err := NewF5Router(logger, makeConfig(), mw, &fakeClient.FakeClient{})
+			Expect(err).NotTo(HaveOccurred())
+			stop := runRouter(router)
+			defer stop()
+
+			for i, uri := range []route.Uri{
+				"baz.cf.com/segment1",
+				"*.cf.com/segment1",
+				"baz.cf.com",
+				"baz.cf.com/segment1/segment2/segment3",
+				"*.cf.com",
+			} {
+				ru, err := NewUpdate(logger, routeUpdate.Add, uri, makeEndpoint(fmt.Sprintf("127.0.0.%d", i+1)), "")
+				Expect(err).NotTo(HaveOccurred())
+				router.UpdateRoute(ru)
+			}
+
+			policy := func() *bigipResources.Policy {
+				for _, p := range mw.getResources("cf").Policies {
+					if p.Name == CFRoutingPolicyName {
+						return p
+					}
+				}
+				return nil
+			}
+			Eventually(func() int {
+				if p := policy(); nil != p {
+					return len(p.Rules)
+				}
+				return 0
+			}).Should(Equal(5))
+			var uris []string
+			var segments [][]string
+			for i, rule := range policy().Rules {
+				Expect(rule.Ordinal).To(Equal(i))
+				uris = append(uris, rule.Name)
+				segments = append(segments, pathSegments(rule.Conditions))
+			}
+			Expect(uris).To(Equal([]string{
+				makeObjectName("baz.cf.com/segment1/segment2/segment3"),
+				makeObjectName("baz.cf.com/segment1"),
+				makeObjectName("baz.cf.com"),
+				makeObjectName("*.cf.com/segment1"),
+				makeObjectName("*.cf.com"),
+			}))
+			Expect(segments).To(Equal([][]string{
+				{"segment1", "segment2", "segment3"},
+				{"segment1"},
+				nil,
+				{"segment1"},
+				nil,
+			}))
+		})
+	})
+
+	Describe("SNI routing", func() {
+		var (
+			logger *test_util.TestZapLogger
+			c      *config.Config
+			mw     *MockWriter
+			router *F5Router
+			stop   func()
+		)
+
+		update := func(op routeUpdate.Operation, uri route.Uri) {
+			ru, err := NewUpdate(logger, op, uri, makeEndpoint("127.0.0.1"), "")
+			Expect(err).NotTo(HaveOccurred())
+			router.UpdateRoute(ru)
+		}
+
+		findPolicy := func(name string) *bigipResources.Policy {
+			for _, p := range mw.getResources("cf").Policies {
+				if p.Name == name {
+					return p
+				}
+			}
+			return nil
+		}
+
+		virtualPolicies := func(virtual string) []string {
+			var names []string
+			for _, vs := range mw.getResources("cf").Virtuals {
+				if vs.VirtualServerName != virtual {
+					continue
+				}
+				for _, p := range vs.Policies {
+					names = append(names, p.Name)
+				}
+			}
+			return names
+		}
+
+		sniRules := func() []string {
+			var names []string
+			if policy := findPolicy(CFSNIRoutingPolicyName); nil != policy {
+				for _, rule := range policy.Rules {
+					names = append(names, rule.Name)
+				}
+			}
+			return names
+		}
+
+		BeforeEach(func() {
+			logger = test_util.NewTestZapLogger("router-test")
+			c = makeConfig()
+			c.BigIP.DefaultClientSSL = "/Common/wildcard-clientssl"
+			c.BigIP.SNIRouting = true
+			stop = func() {}
+		})
+
+		JustBeforeEach(func() {
+			var err error
+			mw = &MockWriter{}
+			router, err = NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+			Expect(err).NotTo(HaveOccurred())
+			stop = runRouter(router)
+		})
+
+		AfterEach(func() {
+			stop()
+			if nil != logger {
+				logger.Close()
+			}
+		})
+
+		It("should route hosts on the TLS server name with exact hosts first", func() {
+			for _, uri := range []route.Uri{"*.cf.com", "foo.cf.com", "bar.cf.com/path", "baz.cf.com"} {
+				update(routeUpdate.Add, uri)
+			}
+			Eventually(sniRules).Should(Equal([]string{
+				makeObjectName("foo.cf.com"),
+				makeObjectName("baz.cf.com"),
+				makeObjectName("*.cf.com"),
+			}))
+
+			Expect(virtualPolicies(HTTPSRouterName)).To(Equal([]string{CFRoutingPolicyName, CFSNIRoutingPolicyName}))
+			Expect(virtualPolicies(HTTPRouterName)).NotTo(ContainElement(CFSNIRoutingPolicyName))
+
+			policy := findPolicy(CFSNIRoutingPolicyName)
+			Expect(policy.Requires).To(Equal([]string{"client-ssl"}))
+
+			for i, rule := range policy.Rules {
+				Expect(rule.Ordinal).To(Equal(i))
+				Expect(rule.Actions).To(HaveLen(1))
+				Expect(rule.Actions[0].SSLClientHello).To(BeTrue())
+				Expect(rule.Actions[0].Request).To(BeFalse())
+				Expect(rule.Actions[0].Expression).To(Equal(rule.Name))
+				for _, cond := range rule.Conditions {
+					Expect(cond.SSLExtension && cond.ServerName && cond.SSLClientHello).To(BeTrue())
+					Expect(cond.HTTPHost || cond.HTTPURI || cond.Request).To(BeFalse())
+				}
+			}
+
+			Expect(policy.Rules[0].Conditions).To(Equal([]*bigipResources.Condition{
+				&bigipResources.Condition{
+					Equals:         true,
+					SSLExtension:   true,
+					ServerName:     true,
+					SSLClientHello: true,
+					Name:           "0",
+					Values:         []string{"foo.cf.com"},
+				},
+			}))
+			Expect(policy.Rules[2].Conditions).To(Equal([]*bigipResources.Condition{
+				&bigipResources.Condition{
+					EndsWith:       true,
+					SSLExtension:   true,
+					ServerName:     true,
+					SSLClientHello: true,
+					Name:           "0",
+					Values:         []string{".cf.com"},
+				},
+			}))
+
+			js, err := json.Marshal(policy.Rules[0].Conditions[0])
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(js)).To(Equal(
+				`{"equals":true,"name":"0","index":0,"request":false,"values":["foo.cf.com"],` +
+					`"sslExtension":true,"serverName":true,"sslClientHello":true}`))
+
+			// The host routing rules are unchanged
+			Expect(findPolicy(CFRoutingPolicyName).Rules).To(HaveLen(4))
+
+			update(routeUpdate.Remove, "foo.cf.com")
+			Eventually(sniRules).Should(Equal([]string{
+				makeObjectName("baz.cf.com"),
+				makeObjectName("*.cf.com"),
+			}))
+		})
+
+		Context("when disabled", func() {
+			BeforeEach(func() {
+				c.BigIP.SNIRouting = false
+			})
+
+			It("should not create the SNI policy", func() {
+				update(routeUpdate.Add, "foo.cf.com")
+				Eventually(func() []string {
+					return virtualPolicies(HTTPSRouterName)
+				}).Should(Equal([]string{CFRoutingPolicyName}))
+				Expect(findPolicy(CFSNIRoutingPolicyName)).To(BeNil())
+			})
+		})
+
+		It("should require the HTTPS virtual", func() {
+			c.BigIP.DefaultClientSSL = ""
+			_, err := NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).To(MatchError(ContainSubstring("sni_routing")))
+		})
+	})
+
+	Describe("route services", func() {
+		var (
+			logger *test_util.TestZapLogger
+			c      *config.Config
+			mw     *MockWriter
+			router *F5Router
+			stop   func()
+		)
+
+		addRoute := func(uri route.Uri, routeServiceURL string) {
+			ep := makeEndpoint("127.0.0.1")
+			ep.RouteServiceUrl = routeServiceURL
+			ru, err := NewUpdate(logger, routeUpdate.Add, uri, ep, "")
+			Expect(err).NotTo(HaveOccurred())
+			router.UpdateRoute(ru)
+		}
+
+		findPolicy := func(name string) *bigipResources.Policy {
+			for _, p := range mw.getResources("cf").Policies {
+				if p.Name == name {
+					return p
+				}
+			}
+			return nil
+		}
+
+		rules := func(name string) func() []*bigipResources.Rule {
+			return func() []*bigipResources.Rule {
+				if p := findPolicy(name); nil != p {
+					return p.Rules
+				}
+				return nil
+			}
+		}
+
+		BeforeEach(func() {
+			logger = test_util.NewTestZapLogger("router-test")
+			c = makeConfig()
+			c.BigIP.DefaultClientSSL = "/Common/wildcard-clientssl"
+			c.BigIP.SNIRouting = true
+			var err error
+			mw = &MockWriter{}
+			router, err = NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+			Expect(err).NotTo(HaveOccurred())
+			stop = runRouter(router)
+		})
+
+		AfterEach(func() {
+			stop()
+			if nil != logger {
+				logger.Close()
+			}
+		})
+
+		It("should redirect requests for a route bound to a route service", func() {
+			addRoute("foo.cf.com", "https://rs.cf.com/service")
+			addRoute("bar.cf.com", "")
+
+			Eventually(rules(CFRoutingPolicyName)).Should(HaveLen(2))
+			routingRules := rules(CFRoutingPolicyName)()
+			Expect(routingRules[0].Name).To(Equal(makeObjectName("foo.cf.com")))
+			Expect(routingRules[1].Name).To(Equal(makeObjectName("bar.cf.com")))
+
+			Expect(routingRules[0].Actions).To(Equal([]*bigipResources.Action{
+				&bigipResources.Action{
+					Name:      "0",
+					Request:   true,
+					HTTPReply: true,
+					Redirect:  true,
+					Location:  "https://rs.cf.com/service",
+				},
+			}))
+			js, err := json.Marshal(routingRules[0].Actions[0])
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(js)).To(ContainSubstring(
+				`"httpReply":true,"redirect":true,"location":"https://rs.cf.com/service"`))
+
+			Expect(routingRules[1].Actions).To(Equal([]*bigipResources.Action{
+				&bigipResources.Action{
+					Name:        "0",
+					Request:     true,
+					Expression:  makeObjectName("bar.cf.com"),
+					TmName:      "target_vip",
+					Tcl:         true,
+					SetVariable: true,
+				},
+			}))
+			Expect(routingRules[0].Conditions).To(HaveLen(len(routingRules[1].Conditions)))
+
+			// The redirect needs the request so only the normal route is routed
+			// on the TLS server name
+			sniRules := rules(CFSNIRoutingPolicyName)()
+			Expect(sniRules).To(HaveLen(1))
+			Expect(sniRules[0].Name).To(Equal(makeObjectName("bar.cf.com")))
+		})
+
+		It("should route normally without a valid route service URL", func() {
+			addRoute("foo.cf.com", "rs.cf.com/service")
+
+			Eventually(logger).Should(Say(`skipping-route-service.*"route-service-url":"rs.cf.com/service"`))
+			Eventually(rules(CFRoutingPolicyName)).Should(HaveLen(1))
+			routingRules := rules(CFRoutingPolicyName)()
+			Expect(routingRules[0].Actions[0].Redirect).To(BeFalse())
+			Expect(routingRules[0].Actions[0].TmName).To(Equal("target_vip"))
+		})
+	})
+
+	Describe("HTTPS redirects", func() {
+		var (
+			logger *test_util.TestZapLogger
+			c      *config.Config
+			mw     *MockWriter
+			router *F5Router
+			stop   func()
+		)
+
+		start := func() {
+			var err error
+			mw = &MockWriter{}
+			router, err = NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+			Expect(err).NotTo(HaveOccurred())
+			stop = runRouter(router)
+		}
+
+		addRoute := func(uri route.Uri, tags map[string]string) {
+			ep := makeEndpoint("127.0.0.1")
+			ep.Tags = tags
+			ru, err := NewUpdate(logger, routeUpdate.Add, uri, ep, "")
+			Expect(err).NotTo(HaveOccurred())
+			router.UpdateRoute(ru)
+		}
+
+		waitForPools := func(n int) {
+			EventuallyWithOffset(1, func() []*bigipResources.Pool {
+				return mw.getResources("cf").Pools
+			}).Should(HaveLen(n))
+		}
+
+		findPolicy := func(name string) *bigipResources.Policy {
+			for _, p := range mw.getResources("cf").Policies {
+				if p.Name == name {
+					return p
+				}
+			}
+			return nil
+		}
+
+		// redirected maps the rule names of the policy to whether they
+		// redirect, expected gives the same for the route URIs
+		redirected := func(policyName string) map[string]bool {
+			result := make(map[string]bool)
+			if p := findPolicy(policyName); nil != p {
+				for _, rule := range p.Rules {
+					result[rule.Name] = false
+					for _, a := range rule.Actions {
+						if bigipResources.HTTPSRedirectVariable == a.TmName {
+							result[rule.Name] = true
+						}
+					}
+				}
+			}
+			return result
+		}
+
+		expected := func(uris map[string]bool) map[string]bool {
+			result := make(map[string]bool)
+			for uri, redirect := range uris {
+				result[makeObjectName(uri)] = redirect
+			}
+			return result
+		}
+
+		redirectIRule := func() *bigipResources.IRule {
+			for _, rule := range mw.getResources("cf").IRules {
+				if bigipResources.HTTPSRedirectIRuleName == rule.Name {
+					return rule
+				}
+			}
+			return nil
+		}
+
+		virtualIRules := func(name string) []string {
+			for _, v := range mw.getResources("cf").Virtuals {
+				if v.VirtualServerName == name {
+					return v.IRules
+				}
+			}
+			return nil
+		}
+
+		BeforeEach(func() {
+			logger = test_util.NewTestZapLogger("router-test")
+			c = makeConfig()
+			c.BigIP.DefaultClientSSL = "/Common/wildcard-clientssl"
+			c.BigIP.SNIRouting = true
+			stop = func() {}
+		})
+
+		AfterEach(func() {
+			stop()
+			if nil != logger {
+				logger.Close()
+			}
+		})
+
+		It("should not redirect by default", func() {
+			start()
+			addRoute("foo.cf.com", nil)
+			waitForPools(1)
+			Expect(redirected(CFRoutingPolicyName)).To(Equal(expected(map[string]bool{"foo.cf.com": false})))
+			Expect(virtualIRules(HTTPRouterName)).To(Equal([]string{"/cf/forward-to-vip"}))
+			Expect(redirectIRule()).To(BeNil())
+		})
+
+		It("should redirect every route on the HTTP virtual when enabled", func() {
+			c.BigIP.HTTPSRedirect.Enabled = true
+			start()
+			addRoute("foo.cf.com", nil)
+			addRoute("*.cf.com", nil)
+			waitForPools(2)
+
+			Expect(redirected(CFRoutingPolicyName)).To(Equal(expected(map[string]bool{
+				"foo.cf.com": true,
+				"*.cf.com":   true,
+			})))
+			rules := findPolicy(CFRoutingPolicyName).Rules
+			Expect(rules[0].Actions[1]).To(Equal(&bigipResources.Action{
+				Name:        "1",
+				Request:     true,
+				Expression:  "1",
+				TmName:      "https_redirect",
+				Tcl:         true,
+				SetVariable: true,
+			}))
+			Expect(redirected(CFSNIRoutingPolicyName)).To(Equal(expected(map[string]bool{
+				"foo.cf.com": false,
+				"*.cf.com":   false,
+			})))
+
+			Expect(virtualIRules(HTTPRouterName)).To(Equal([]string{
+				"/cf/cf-https-redirect",
+				"/cf/forward-to-vip",
+			}))
+			Expect(virtualIRules(HTTPSRouterName)).To(Equal([]string{"/cf/forward-to-vip"}))
+			Expect(redirectIRule().Code).To(ContainSubstring(
+				`HTTP::respond 301 Location "https://[getfield [HTTP::host] ":" 1][HTTP::uri]"`))
+		})
+
+		It("should redirect to the HTTPS virtual port", func() {
+			c.BigIP.HTTPSRedirect.Enabled = true
+			c.BigIP.VirtualServer.HTTPSPort = 8443
+			start()
+			addRoute("foo.cf.com", nil)
+			waitForPools(1)
+			Expect(redirectIRule().Code).To(ContainSubstring(
+				`"https://[getfield [HTTP::host] ":" 1]:8443[HTTP::uri]"`))
+		})
+
+		It("should select the redirect of a route from its tag", func() {
+			c.BigIP.HTTPSRedirect.Tag = "https_redirect"
+			start()
+			addRoute("foo.cf.com", map[string]string{"https_redirect": "true"})
+			addRoute("bar.cf.com", map[string]string{"https_redirect": "false"})
+			addRoute("baz.cf.com", nil)
+			addRoute("qux.cf.com", map[string]string{"https_redirect": "sometimes"})
+			waitForPools(4)
+
+			Expect(logger).To(Say(`skipping-route-https-redirect.*"https-redirect":"sometimes"`))
+			Expect(redirected(CFRoutingPolicyName)).To(Equal(expected(map[string]bool{
+				"foo.cf.com": true,
+				"bar.cf.com": false,
+				"baz.cf.com": false,
+				"qux.cf.com": false,
+			})))
+			Expect(redirectIRule()).NotTo(BeNil())
+			stop()
+
+			c.BigIP.HTTPSRedirect.Enabled = true
+			start()
+			addRoute("bar.cf.com", map[string]string{"https_redirect": "false"})
+			addRoute("baz.cf.com", nil)
+			waitForPools(2)
+			Expect(redirected(CFRoutingPolicyName)).To(Equal(expected(map[string]bool{
+				"bar.cf.com": false,
+				"baz.cf.com": true,
+			})))
+		})
+
+		It("should require the HTTPS virtual", func() {
+			c.BigIP.DefaultClientSSL = ""
+			c.BigIP.SNIRouting = false
+			c.BigIP.HTTPSRedirect.Tag = "https_redirect"
+			_, err := NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).To(MatchError(ContainSubstring("https_redirect")))
+		})
+	})
+
+	Describe("forwarded headers", func() {
+		var (
+			logger *test_util.TestZapLogger
+			c      *config.Config
+			mw     *MockWriter
+			router *F5Router
+			stop   func()
+		)
+
+		// start runs a new router and adds the routes in order
+		start := func(uris ...route.Uri) {
+			var err error
+			mw = &MockWriter{}
+			router, err = NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+			Expect(err).NotTo(HaveOccurred())
+			stop = runRouter(router)
+
+			for _, uri := range uris {
+				ru, err := NewUpdate(logger, routeUpdate.Add, uri, makeEndpoint("127.0.0.1"), "")
+				Expect(err).NotTo(HaveOccurred())
+				router.UpdateRoute(ru)
+			}
+			Eventually(func() []*bigipResources.Pool {
+				return mw.getResources("cf").Pools
+			}).Should(HaveLen(len(uris)))
+		}
+
+		virtualIRules := func(name string) []string {
+			for _, v := range mw.getResources("cf").Virtuals {
+				if v.VirtualServerName == name {
+					return v.IRules
+				}
+			}
+			return nil
+		}
+
+		written := func() string {
+			// The generation depends on how the updates were batched into writes
+			input := mw.getInput()
+			input.Global.Generation = 0
+			out, err := json.Marshal(input)
+			Expect(err).NotTo(HaveOccurred())
+			return string(out)
+		}
+
+		BeforeEach(func() {
+			logger = test_util.NewTestZapLogger("router-test")
+			c = makeConfig()
+			c.BigIP.DefaultClientSSL = "/Common/wildcard-clientssl"
+			stop = func() {}
+		})
+
+		AfterEach(func() {
+			stop()
+			if nil != logger {
+				logger.Close()
+			}
+		})
+
+		It("should not insert the headers by default", func() {
+			start("foo.cf.com")
+			for _, name := range []string{HTTPRouterName, HTTPSRouterName} {
+				Expect(virtualIRules(name)).To(Equal([]string{"/cf/forward-to-vip"}))
+			}
+			Expect(written()).NotTo(ContainSubstring(bigipResources.ForwardedHeadersIRuleName))
+		})
+
+		It("should insert the headers on the routing virtuals", func() {
+			c.BigIP.ForwardedHeaders = true
+			c.BigIP.IRules = []string{"/Common/operator-irule"}
+			start("foo.cf.com", "bar.cf.com")
+
+			for _, name := range []string{HTTPRouterName, HTTPSRouterName} {
+				Expect(virtualIRules(name)).To(Equal([]string{
+					"/cf/forward-to-vip",
+					"/cf/cf-forwarded-headers",
+					"/Common/operator-irule",
+				}))
+			}
+
+			var code string
+			for _, rule := range mw.getResources("cf").IRules {
+				if bigipResources.ForwardedHeadersIRuleName == rule.Name {
+					code = rule.Code
+				}
+			}
+			Expect(code).To(ContainSubstring(`HTTP::header insert "X-Forwarded-For" [IP::client_addr]`))
+			Expect(code).To(ContainSubstring(`HTTP::header replace "X-Forwarded-Proto" "https"`))
+			Expect(code).To(ContainSubstring(`HTTP::header replace "X-Forwarded-Proto" "http"`))
+
+			// The same routes in another order write the same config
+			output := written()
+			Expect(output).To(ContainSubstring(`"name":"cf-forwarded-headers"`))
+			stop()
+			start("bar.cf.com", "foo.cf.com")
+			Expect(written()).To(Equal(output))
+		})
+	})
+
+	Describe("monitor only routes", func() {
+		var (
+			logger *test_util.TestZapLogger
+			mw     *MockWriter
+			router *F5Router
+			stop   func()
+		)
+
+		update := func(op routeUpdate.Operation, uri route.Uri, ep *route.Endpoint, planID string) {
+			ru, err := NewUpdate(logger, op, uri, ep, planID)
+			Expect(err).NotTo(HaveOccurred())
+			router.UpdateRoute(ru)
+		}
+
+		routedNames := func() []string {
+			rs := mw.getResources("cf")
+			var names []string
+			for _, p := range rs.Policies {
+				if p.Name == CFRoutingPolicyName {
+					for _, rule := range p.Rules {
+						names = append(names, rule.Name)
+					}
+				}
+			}
+			return names
+		}
+
+		virtualNames := func() []string {
+			var names []string
+			for _, vs := range mw.getResources("cf").Virtuals {
+				names = append(names, vs.VirtualServerName)
+			}
+			return names
+		}
+
+		poolNames := func() []string {
+			var names []string
+			for _, pool := range mw.getResources("cf").Pools {
+				names = append(names, pool.Name)
+			}
+			return names
+		}
+
+		poolMonitors := func(name string) func() []string {
+			return func() []string {
+				for _, pool := range mw.getResources("cf").Pools {
+					if pool.Name == name {
+						return pool.MonitorNames
+					}
+				}
+				return nil
+			}
+		}
+
+		BeforeEach(func() {
+			logger = test_util.NewTestZapLogger("router-test")
+			mw = &MockWriter{}
+			var err error
+			router, err = NewF5Router(logger, makeConfig(), mw, &fakeClient.FakeClient{})
+			Expect(err).NotTo(HaveOccurred())
+			router.AddPlans(map[string]planResources.Plan{
+				"preflight": planResources.Plan{
+					ID:          "preflight",
+					MonitorOnly: true,
+					Pool: planResources.PoolType{
+						HealthMonitors: []bigipResources.Monitor{
+							bigipResources.Monitor{Name: "preflight-http", Type: "http", Interval: 5, Timeout: 16},
+						},
+					},
+				},
+				"live": planResources.Plan{
+					ID:   "live",
+					Pool: planResources.PoolType{Balance: "least-connections-member"},
+				},
+			})
+			stop = runRouter(router)
+		})
+
+		AfterEach(func() {
+			stop()
+			if nil != logger {
+				logger.Close()
+			}
+		})
+
+		It("should create the pool and monitors without routing traffic", func() {
+			update(routeUpdate.Add, "foo.cf.com", makeEndpoint("127.0.0.1"), "")
+			update(routeUpdate.Add, "bar.cf.com", makeEndpoint("127.0.0.2"), "")
+			update(routeUpdate.Bind, "foo.cf.com", nil, "preflight")
+
+			foo := makeObjectName("foo.cf.com")
+			bar := makeObjectName("bar.cf.com")
+			Eventually(poolMonitors(foo)).Should(Equal([]string{"/cf/preflight-http"}))
+			rs := mw.getResources("cf")
+			for _, pool := range rs.Pools {
+				if pool.Name == foo {
+					Expect(pool.Members).To(HaveLen(1))
+				}
+			}
+			Expect(poolNames()).To(ConsistOf(foo, bar))
+			Expect(rs.Monitors).To(HaveLen(1))
+			Expect(routedNames()).To(Equal([]string{bar}))
+			Expect(virtualNames()).NotTo(ContainElement(foo))
+			Expect(virtualNames()).To(ContainElement(bar))
+
+			// Members added while monitor only still only reach the pool
+			update(routeUpdate.Add, "foo.cf.com", makeEndpoint("127.0.0.3"), "")
+			Eventually(func() int {
+				for _, pool := range mw.getResources("cf").Pools {
+					if pool.Name == foo {
+						return len(pool.Members)
+					}
+				}
+				return 0
+			}).Should(Equal(2))
+			Expect(routedNames()).To(Equal([]string{bar}))
+		})
+
+		It("should route traffic once promoted", func() {
+			foo := makeObjectName("foo.cf.com")
+			update(routeUpdate.Add, "foo.cf.com", makeEndpoint("127.0.0.1"), "")
+			update(routeUpdate.Bind, "foo.cf.com", nil, "preflight")
+			Eventually(poolMonitors(foo)).Should(Equal([]string{"/cf/preflight-http"}))
+			Expect(routedNames()).To(BeEmpty())
+
+			update(routeUpdate.Bind, "foo.cf.com", nil, "live")
+			Eventually(routedNames).Should(Equal([]string{foo}))
+			Expect(virtualNames()).To(ContainElement(foo))
+
+			update(routeUpdate.Bind, "foo.cf.com", nil, "preflight")
+			Eventually(routedNames).Should(BeEmpty())
+			update(routeUpdate.Unbind, "foo.cf.com", nil, "")
+			Eventually(routedNames).Should(Equal([]string{foo}))
+			Expect(virtualNames()).To(ContainElement(foo))
+		})
+
+		It("should not route a route bound before it is added", func() {
+			update(routeUpdate.Bind, "foo.cf.com", nil, "preflight")
+			update(routeUpdate.Add, "foo.cf.com", makeEndpoint("127.0.0.1"), "")
+
+			foo := makeObjectName("foo.cf.com")
+			Eventually(poolNames).Should(ContainElement(foo))
+			Expect(routedNames()).To(BeEmpty())
+			Expect(virtualNames()).NotTo(ContainElement(foo))
+
+			// Removing the route forgets it was monitor only
+			update(routeUpdate.Remove, "foo.cf.com", makeEndpoint("127.0.0.1"), "")
+			Eventually(poolNames).ShouldNot(ContainElement(foo))
+			update(routeUpdate.Add, "foo.cf.com", makeEndpoint("127.0.0.1"), "")
+			Eventually(routedNames).Should(Equal([]string{foo}))
+		})
+	})
+
+	Describe("external pool routes", func() {
+		var (
+			logger *test_util.TestZapLogger
+			mw     *MockWriter
+			router *F5Router
+			stop   func()
+		)
+
+		update := func(op routeUpdate.Operation, uri route.Uri, ep *route.Endpoint, planID string) {
+			ru, err := NewUpdate(logger, op, uri, ep, planID)
+			Expect(err).NotTo(HaveOccurred())
+			router.UpdateRoute(ru)
+		}
+
+		virtualPool := func(name string) func() string {
+			return func() string {
+				for _, vs := range mw.getResources("cf").Virtuals {
+					if vs.VirtualServerName == name {
+						return vs.PoolName
+					}
+				}
+				return ""
+			}
+		}
+
+		poolNames := func() []string {
+			var names []string
+			for _, pool := range mw.getResources("cf").Pools {
+				names = append(names, pool.Name)
+			}
+			return names
+		}
+
+		BeforeEach(func() {
+			logger = test_util.NewTestZapLogger("router-test")
+			mw = &MockWriter{}
+			var err error
+			router, err = NewF5Router(logger, makeConfig(), mw, &fakeClient.FakeClient{})
+			Expect(err).NotTo(HaveOccurred())
+			router.AddPlans(map[string]planResources.Plan{
+				"gateway": planResources.Plan{
+					ID:           "gateway",
+					ExternalPool: "/Common/gateway",
+				},
+				"invalid": planResources.Plan{
+					ID:           "invalid",
+					ExternalPool: "gateway",
+				},
+			})
+			stop = runRouter(router)
+		})
+
+		AfterEach(func() {
+			stop()
+			if nil != logger {
+				logger.Close()
+			}
+		})
+
+		It("should reference the external pool without managing a pool", func() {
+			update(routeUpdate.Add, "foo.cf.com", makeEndpoint("127.0.0.1"), "")
+			update(routeUpdate.Add, "bar.cf.com", makeEndpoint("127.0.0.2"), "")
+			update(routeUpdate.Bind, "foo.cf.com", nil, "gateway")
+
+			foo := makeObjectName("foo.cf.com")
+			bar := makeObjectName("bar.cf.com")
+			Eventually(virtualPool(foo)).Should(Equal("/Common/gateway"))
+			Expect(virtualPool(bar)()).To(Equal("/cf/" + bar))
+			Expect(poolNames()).To(Equal([]string{bar}))
+
+			var routed []string
+			for _, p := range mw.getResources("cf").Policies {
+				if p.Name == CFRoutingPolicyName {
+					for _, rule := range p.Rules {
+						routed = append(routed, rule.Name)
+					}
+				}
+			}
+			Expect(routed).To(ConsistOf(foo, bar))
+
+			update(routeUpdate.Unbind, "foo.cf.com", nil, "")
+			Eventually(virtualPool(foo)).Should(Equal("/cf/" + foo))
+			Expect(poolNames()).To(ConsistOf(foo, bar))
+		})
+
+		It("should reference the external pool for a route bound before it is added", func() {
+			update(routeUpdate.Bind, "foo.cf.com", nil, "gateway")
+			update(routeUpdate.Add, "foo.cf.com", makeEndpoint("127.0.0.1"), "")
+
+			foo := makeObjectName("foo.cf.com")
+			Eventually(virtualPool(foo)).Should(Equal("/Common/gateway"))
+			Expect(poolNames()).To(BeEmpty())
+
+			update(routeUpdate.Remove, "foo.cf.com", makeEndpoint("127.0.0.1"), "")
+			Eventually(virtualPool(foo)).Should(BeEmpty())
+			Expect(poolNames()).To(BeEmpty())
+		})
+
+		It("should keep the route pool when the external pool is not valid", func() {
+			update(routeUpdate.Add, "foo.cf.com", makeEndpoint("127.0.0.1"), "")
+			update(routeUpdate.Bind, "foo.cf.com", nil, "invalid")
+
+			foo := makeObjectName("foo.cf.com")
+			Eventually(logger).Should(Say("skipping-external-pool"))
+			Eventually(virtualPool(foo)).Should(Equal("/cf/" + foo))
+			Expect(poolNames()).To(Equal([]string{foo}))
+		})
+	})
+
+	Describe("SetRoutes", func() {
+		var (
+			logger *test_util.TestZapLogger
+			router *F5Router
+			c      *config.Config
+			bw     *breakableWriter
+			stop   func()
+		)
+
+		httpRoute := func(uri route.Uri, addr string) routeUpdate.RouteUpdate {
+			ru, err := NewUpdate(logger, routeUpdate.Add, uri, makeEndpoint(addr), "")
+			Expect(err).NotTo(HaveOccurred())
+			return ru
+		}
+
+		tcpRoute := func(port uint16, memberPort uint16) routeUpdate.RouteUpdate {
+			member := bigipResources.Member{Address: "10.0.0.1", Port: memberPort, Session: "user-enabled"}
+			ru, err := NewTCPUpdate(c, logger, routeUpdate.Add, port, member)
+			Expect(err).NotTo(HaveOccurred())
+			return ru
+		}
+
+		written := func() *bigipResources.Resources {
+			bw.Lock()
+			defer bw.Unlock()
+			var m configMatcher
+			err := json.Unmarshal(bw.out.Bytes(), &m)
+			Expect(err).NotTo(HaveOccurred())
+			return m.Resources["cf"]
+		}
+
+		members := func() map[string][]string {
+			m := make(map[string][]string)
+			for _, pool := range written().Pools {
+				for _, member := range pool.Members {
+					m[pool.Name] = append(m[pool.Name], fmt.Sprintf("%s:%d", member.Address, member.Port))
+				}
+				sort.Strings(m[pool.Name])
+			}
+			return m
+		}
+
+		routes := func() []string {
+			var names []string
+			for _, p := range written().Policies {
+				if p.Name == CFRoutingPolicyName {
+					for _, rule := range p.Rules {
+						names = append(names, rule.Name)
+					}
+				}
+			}
+			return names
+		}
+
+		virtualNames := func() []string {
+			var names []string
+			for _, vs := range written().Virtuals {
+				names = append(names, vs.VirtualServerName)
+			}
+			return names
+		}
+
+		// setRoutes applies the desired routes and checks only one write was
+		// needed for the whole set
+		setRoutes := func(desired ...routeUpdate.RouteUpdate) {
+			writes := bw.writes()
+			router.SetRoutes(desired)
+			Eventually(bw.writes).Should(BeNumerically(">", writes))
+			Expect(bw.writes()).To(Equal(writes + 1))
+		}
+
+		BeforeEach(func() {
+			logger = test_util.NewTestZapLogger("router-test")
+			c = makeConfig()
+			c.TCPRouterGroupName = "default-tcp"
+			bw = &breakableWriter{}
+			var err error
+			router, err = NewF5Router(logger, c, bw, &fakeClient.FakeClient{})
+			Expect(err).NotTo(HaveOccurred())
+			stop = runRouter(router)
+		})
+
+		AfterEach(func() {
+			stop()
+			if nil != logger {
+				logger.Close()
+			}
+		})
+
+		It("should converge to the desired routes with a single write", func() {
+			foo := makeObjectName("foo.cf.com")
+			bar := makeObjectName("bar.cf.com")
+			wild := makeObjectName("*.cf.com")
+			tcp := createTCPObjectName(c, 6010)
+
+			setRoutes(
+				httpRoute("foo.cf.com", "127.0.0.1"),
+				httpRoute("foo.cf.com", "127.0.0.2"),
+				httpRoute("bar.cf.com", "127.0.0.3"),
+				httpRoute("*.cf.com", "127.0.0.4"),
+				tcpRoute(6010, 5000),
+			)
+			Expect(members()).To(Equal(map[string][]string{
+				foo:  {"127.0.0.1:80", "127.0.0.2:80"},
+				bar:  {"127.0.0.3:80"},
+				wild: {"127.0.0.4:80"},
+				tcp:  {"10.0.0.1:5000"},
+			}))
+			Expect(routes()).To(ConsistOf(foo, bar, wild))
+
+			setRoutes(
+				httpRoute("foo.cf.com", "127.0.0.2"),
+				httpRoute("foo.cf.com", "127.0.0.5"),
+				httpRoute("baz.cf.com", "127.0.0.6"),
+				tcpRoute(6010, 5001),
+			)
+			baz := makeObjectName("baz.cf.com")
+			Expect(members()).To(Equal(map[string][]string{
+				foo: {"127.0.0.2:80", "127.0.0.5:80"},
+				baz: {"127.0.0.6:80"},
+				tcp: {"10.0.0.1:5001"},
+			}))
+			Expect(routes()).To(ConsistOf(foo, baz))
+			Expect(virtualNames()).To(ConsistOf(HTTPRouterName, foo, baz, tcp))
+
+			setRoutes()
+			Expect(members()).To(BeEmpty())
+			Expect(routes()).To(BeEmpty())
+			Expect(virtualNames()).To(ConsistOf(HTTPRouterName))
+		})
+
+		It("should update member weights in place", func() {
+			weighted := makeEndpoint("127.0.0.1")
+			weighted.Tags = map[string]string{"weight": "20"}
+			ru, err := NewUpdate(logger, routeUpdate.Add, "foo.cf.com", weighted, "")
+			Expect(err).NotTo(HaveOccurred())
+
+			setRoutes(httpRoute("foo.cf.com", "127.0.0.1"))
+			setRoutes(ru)
+
+			pools := written().Pools
+			Expect(pools).To(HaveLen(1))
+			Expect(pools[0].Members).To(HaveLen(1))
+			Expect(pools[0].Members[0].Ratio).To(Equal(20))
+		})
+
+		It("should update member connection limits in place", func() {
+			limited := makeEndpoint("127.0.0.1")
+			limited.Tags = map[string]string{"connection_limit": "50"}
+			ru, err := NewUpdate(logger, routeUpdate.Add, "foo.cf.com", limited, "")
+			Expect(err).NotTo(HaveOccurred())
+
+			setRoutes(httpRoute("foo.cf.com", "127.0.0.1"))
+			setRoutes(ru)
+
+			pools := written().Pools
+			Expect(pools).To(HaveLen(1))
+			Expect(pools[0].Members).To(HaveLen(1))
+			Expect(pools[0].Members[0].ConnectionLimit).To(Equal(50))
+		})
+
+		It("should skip updates which are not route adds", func() {
+			remove, err := NewUpdate(logger, routeUpdate.Remove, "foo.cf.com", makeEndpoint("127.0.0.1"), "")
+			Expect(err).NotTo(HaveOccurred())
+
+			setRoutes(httpRoute("bar.cf.com", "127.0.0.2"), remove)
+			Expect(members()).To(Equal(map[string][]string{
+				makeObjectName("bar.cf.com"): {"127.0.0.2:80"},
+			}))
+			Eventually(logger).Should(Say("f5router-route-set-skipping-update"))
+		})
+	})
+
+	Describe("multiple partitions", func() {
+		var (
+			logger *test_util.TestZapLogger
+			c      *config.Config
+			mw     *MockWriter
+			router *F5Router
+			stop   func()
+		)
+
+		start := func() {
+			var err error
+			mw = &MockWriter{}
+			router, err = NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+			Expect(err).NotTo(HaveOccurred())
+			stop = runRouter(router)
+		}
+
+		pinned := func(addr string, partition string) *route.Endpoint {
+			ep := makeEndpoint(addr)
+			ep.Tags = map[string]string{"partition": partition}
+			return ep
+		}
+
+		update := func(op routeUpdate.Operation, uri route.Uri, ep *route.Endpoint) {
+			ru, err := NewUpdate(logger, op, uri, ep, "")
+			Expect(err).NotTo(HaveOccurred())
+			router.UpdateRoute(ru)
+		}
+
+		poolNames := func(rs *bigipResources.Resources) []string {
+			var names []string
+			for _, pool := range rs.Pools {
+				names = append(names, pool.Name)
+			}
+			return names
+		}
+
+		virtualNames := func(rs *bigipResources.Resources) []string {
+			var names []string
+			for _, vs := range rs.Virtuals {
+				names = append(names, vs.VirtualServerName)
+			}
+			return names
+		}
+
+		// partitionOf returns the partition the pool was written to
+		partitionOf := func(name string) func() string {
+			return func() string {
+				for partition, rs := range mw.getInput().Resources {
+					for _, pool := range poolNames(rs) {
+						if pool == name {
+							return partition
+						}
+					}
+				}
+				return ""
+			}
+		}
+
+		members := func(partition, name string) func() int {
+			return func() int {
+				for _, pool := range mw.getResources(partition).Pools {
+					if pool.Name == name {
+						return len(pool.Members)
+					}
+				}
+				return 0
+			}
+		}
+
+		BeforeEach(func() {
+			logger = test_util.NewTestZapLogger("router-test")
+			c = makeConfig()
+			c.BigIP.Partitions = []string{"cf", "cf-apps"}
+			stop = func() {}
+		})
+
+		AfterEach(func() {
+			stop()
+			if nil != logger {
+				logger.Close()
+			}
+		})
+
+		It("should place a pinned route in its partition", func() {
+			start()
+			update(routeUpdate.Add, "foo.cf.com", pinned("127.0.0.1", "cf-apps"))
+			name := makeObjectName("foo.cf.com")
+			Eventually(partitionOf(name)).Should(Equal("cf-apps"))
+
+			pm := mw.getInput().Resources
+			Expect(pm).To(HaveLen(2))
+			Expect(poolNames(pm["cf-apps"])).To(Equal([]string{name}))
+			Expect(virtualNames(pm["cf-apps"])).To(Equal([]string{name}))
+			Expect(pm["cf-apps"].Virtuals[0].PoolName).To(Equal("/cf-apps/" + name))
+			Expect(pm["cf-apps"].Virtuals[0].Destination).To(HavePrefix("/cf-apps/10.0.0.1:"))
+
+			// The routing virtual forwards to the tier2 virtual by its path
+			Expect(poolNames(pm["cf"])).To(BeEmpty())
+			Expect(virtualNames(pm["cf"])).To(Equal([]string{HTTPRouterName}))
+			Expect(pm["cf"].Policies).To(HaveLen(1))
+			Expect(pm["cf"].Policies[0].Rules[0].Actions[0].Expression).To(Equal("/cf-apps/" + name))
+			Expect(pm["cf"].InternalDataGroups).NotTo(BeEmpty())
+
+			// The partition is still written once its last route is removed
+			update(routeUpdate.Remove, "foo.cf.com", pinned("127.0.0.1", "cf-apps"))
+			Eventually(partitionOf(name)).Should(BeEmpty())
+			pm = mw.getInput().Resources
+			Expect(pm).To(HaveKey("cf-apps"))
+			Expect(pm["cf-apps"].Pools).To(BeEmpty())
+			Expect(pm["cf-apps"].Virtuals).To(BeEmpty())
+
+			// and the route is free to go to another partition
+			update(routeUpdate.Add, "foo.cf.com", pinned("127.0.0.1", "cf"))
+			Eventually(partitionOf(name)).Should(Equal("cf"))
+		})
+
+		It("should spread routes without a partition tag across the partitions", func() {
+			start()
+			for i := 0; i < 20; i++ {
+				uri := route.Uri(fmt.Sprintf("app-%d.cf.com", i))
+				update(routeUpdate.Add, uri, makeEndpoint("127.0.0.1"))
+			}
+			Eventually(func() int {
+				return len(mw.getResources("cf").Pools) + len(mw.getResources("cf-apps").Pools)
+			}).Should(Equal(20))
+
+			pm := mw.getInput().Resources
+			Expect(pm["cf"].Pools).NotTo(BeEmpty())
+			Expect(pm["cf-apps"].Pools).NotTo(BeEmpty())
+			for _, partition := range []string{"cf", "cf-apps"} {
+				for _, name := range poolNames(pm[partition]) {
+					Expect(virtualNames(pm[partition])).To(ContainElement(name))
+				}
+			}
+
+			// Placement only depends on the route name
+			uri := route.Uri("app-0.cf.com")
+			name := makeObjectName(uri.String())
+			partition := partitionOf(name)()
+			update(routeUpdate.Remove, uri, makeEndpoint("127.0.0.1"))
+			Eventually(partitionOf(name)).Should(BeEmpty())
+			update(routeUpdate.Add, uri, makeEndpoint("127.0.0.1"))
+			Eventually(partitionOf(name)).Should(Equal(partition))
+		})
+
+		It("should reject a partition which is not configured", func() {
+			start()
+			update(routeUpdate.Add, "foo.cf.com", pinned("127.0.0.1", "Common"))
+			Eventually(logger).Should(Say("process-HTTP-route-add-error-partition.*" +
+				"route foo.cf.com partition Common is not one of the configured partitions"))
+
+			update(routeUpdate.Add, "bar.cf.com", pinned("127.0.0.1", "cf"))
+			Eventually(partitionOf(makeObjectName("bar.cf.com"))).Should(Equal("cf"))
+			Expect(partitionOf(makeObjectName("foo.cf.com"))()).To(BeEmpty())
+		})
+
+		It("should not move a route to another partition", func() {
+			start()
+			name := makeObjectName("foo.cf.com")
+			update(routeUpdate.Add, "foo.cf.com", pinned("127.0.0.1", "cf-apps"))
+			Eventually(members("cf-apps", name)).Should(Equal(1))
+			update(routeUpdate.Add, "foo.cf.com", pinned("127.0.0.2", "cf"))
+			Eventually(logger).Should(Say("route foo.cf.com is already in partition cf-apps, not moving it to cf"))
+
+			// Endpoints without the tag join the route in its partition
+			update(routeUpdate.Add, "foo.cf.com", makeEndpoint("127.0.0.3"))
+			Eventually(members("cf-apps", name)).Should(Equal(2))
+			Expect(partitionOf(name)()).To(Equal("cf-apps"))
+		})
+
+		It("should write the shared monitor and iRules to every partition with routes", func() {
+			c.BigIP.HTTPMonitor.Send = "GET / HTTP/1.0\\r\\n\\r\\n"
+			c.SessionPersistence = true
+			start()
+
+			update(routeUpdate.Add, "foo.cf.com", pinned("127.0.0.1", "cf-apps"))
+			Eventually(partitionOf(makeObjectName("foo.cf.com"))).Should(Equal("cf-apps"))
+
+			pm := mw.getInput().Resources
+			for _, partition := range []string{"cf", "cf-apps"} {
+				Expect(pm[partition].Monitors).To(HaveLen(1))
+				Expect(pm[partition].Monitors[0].Name).To(Equal(HTTPMonitorName))
+				var iRules []string
+				for _, rule := range pm[partition].IRules {
+					iRules = append(iRules, rule.Name)
+				}
+				Expect(iRules).To(ContainElement(bigipResources.JsessionidIRuleName))
+			}
+			Expect(pm["cf-apps"].Pools[0].MonitorNames).To(ContainElement("/cf-apps/" + HTTPMonitorName))
+			Expect(pm["cf-apps"].Virtuals[0].IRules).To(Equal([]string{
+				"/cf-apps/" + bigipResources.JsessionidIRuleName}))
+		})
+
+		Context("shared partition", func() {
+			BeforeEach(func() {
+				c.BigIP.Partitions = []string{"cf", "cf-shared", "cf-apps"}
+				c.BigIP.SharedPartition = "cf-shared"
+				c.BigIP.HTTPMonitor.Send = "GET / HTTP/1.0\\r\\n\\r\\n"
+				c.BigIP.HTTPMonitor.ContextPath = true
+				start()
+			})
+
+			It("should validate the shared partition", func() {
+				c.BigIP.SharedPartition = "Common"
+				_, err := NewF5Router(logger, c, &MockWriter{}, nil)
+				Expect(err).To(MatchError(
+					"shared_partition Common is not one of the configured partitions [cf cf-shared cf-apps]"))
+
+				c.BigIP.Partitions = []string{"cf"}
+				c.BigIP.SharedPartition = "cf"
+				_, err = NewF5Router(logger, c, &MockWriter{}, nil)
+				Expect(err).To(MatchError("shared_partition cf requires another partition for the route objects"))
+			})
+
+			It("should write the monitors to the shared partition and the pools to the route partitions", func() {
+				update(routeUpdate.Add, "foo.cf.com", pinned("127.0.0.1", "cf-apps"))
+				update(routeUpdate.Add, "bar.cf.com/app", pinned("127.0.0.2", "cf"))
+				foo := makeObjectName("foo.cf.com")
+				bar := makeObjectName("bar.cf.com/app")
+				Eventually(partitionOf(foo)).Should(Equal("cf-apps"))
+				Eventually(partitionOf(bar)).Should(Equal("cf"))
+
+				pm := mw.getInput().Resources
+				Expect(pm["cf-shared"].Pools).To(BeEmpty())
+				Expect(pm["cf-shared"].Virtuals).To(BeEmpty())
+				var monitors []string
+				for _, monitor := range pm["cf-shared"].Monitors {
+					monitors = append(monitors, monitor.Name)
+				}
+				Expect(monitors).To(HaveLen(2))
+				Expect(monitors).To(ContainElement(HTTPMonitorName))
+				Expect(pm["cf"].Monitors).To(BeEmpty())
+				Expect(pm["cf-apps"].Monitors).To(BeEmpty())
+
+				Expect(poolNames(pm["cf-apps"])).To(Equal([]string{foo}))
+				Expect(pm["cf-apps"].Pools[0].MonitorNames).To(ContainElement("/cf-shared/" + HTTPMonitorName))
+				Expect(pm["cf-apps"].Virtuals[0].PoolName).To(Equal("/cf-apps/" + foo))
+
+				Expect(poolNames(pm["cf"])).To(Equal([]string{bar}))
+				contextMonitor := monitors[0]
+				if contextMonitor == HTTPMonitorName {
+					contextMonitor = monitors[1]
+				}
+				Expect(pm["cf"].Pools[0].MonitorNames).To(ContainElement("/cf-shared/" + contextMonitor))
+			})
+
+			It("should not place routes in the shared partition", func() {
+				for i := 0; i < 20; i++ {
+					uri := route.Uri(fmt.Sprintf("app-%d.cf.com", i))
+					update(routeUpdate.Add, uri, makeEndpoint("127.0.0.1"))
+				}
+				Eventually(func() int {
+					return len(mw.getResources("cf").Pools) + len(mw.getResources("cf-apps").Pools)
+				}).Should(Equal(20))
+				Expect(mw.getResources("cf-shared").Pools).To(BeEmpty())
+
+				update(routeUpdate.Add, "foo.cf.com", pinned("127.0.0.1", "cf-shared"))
+				Eventually(logger).Should(Say("process-HTTP-route-add-error-partition.*" +
+					"route foo.cf.com can not be placed in the shared partition cf-shared"))
+			})
+		})
+	})
+
+	Describe("config generation", func() {
+		var (
+			logger *test_util.TestZapLogger
+			router *F5Router
+			bw     *breakableWriter
+			stop   func()
+		)
+
+		generation := func() uint64 {
+			bw.Lock()
+			defer bw.Unlock()
+			if nil == bw.out || 0 == bw.out.Len() {
+				return 0
+			}
+			var m configMatcher
+			Expect(json.Unmarshal(bw.out.Bytes(), &m)).To(Succeed())
+			return m.Global.Generation
+		}
+
+		update := func(uri route.Uri, addr string) {
+			ru, err := NewUpdate(logger, routeUpdate.Add, uri, makeEndpoint(addr), "")
+			Expect(err).NotTo(HaveOccurred())
+			router.UpdateRoute(ru)
+		}
+
+		BeforeEach(func() {
+			logger = test_util.NewTestZapLogger("router-test")
+			bw = &breakableWriter{}
+			var err error
+			router, err = NewF5Router(logger, makeConfig(), bw, &fakeClient.FakeClient{})
+			Expect(err).NotTo(HaveOccurred())
+			stop = runRouter(router)
+		})
+
+		AfterEach(func() {
+			stop()
+			if nil != logger {
+				logger.Close()
+			}
+		})
+
+		It("should increase with every successful write", func() {
+			update("foo.cf.com", "127.0.0.1")
+			Eventually(generation).Should(Equal(uint64(1)))
+			Eventually(logger).Should(Say(`"f5router-config-written".*"generation":1`))
+
+			update("bar.cf.com", "127.0.0.2")
+			Eventually(generation).Should(Equal(uint64(2)))
+			Eventually(logger).Should(Say(`"f5router-config-written".*"generation":2`))
+
+			// A failed write is retried with the same generation
+			bw.setBroken(true)
+			update("baz.cf.com", "127.0.0.3")
+			Eventually(logger).Should(Say("f5router-config-write-error"))
+			bw.setBroken(false)
+			Eventually(generation).Should(Equal(uint64(3)))
+			Eventually(logger).Should(Say(`"f5router-config-written".*"generation":3`))
+		})
+
+		It("should not write a config unchanged by a route update", func() {
+			update("foo.cf.com", "127.0.0.1")
+			Eventually(generation).Should(Equal(uint64(1)))
+			writes := bw.writes()
+
+			// Adding the same member again has no effect
+			update("foo.cf.com", "127.0.0.1")
+			Eventually(logger).Should(
+				Say(`"f5router-config-unchanged-skipping-write".*"generation":1`))
+			Expect(bw.writes()).To(Equal(writes))
+
+			update("foo.cf.com", "127.0.0.2")
+			Eventually(generation).Should(Equal(uint64(2)))
+
+			// A failed write leaves the written config unknown
+			bw.setBroken(true)
+			update("bar.cf.com", "127.0.0.3")
+			Eventually(logger).Should(Say("f5router-config-write-error"))
+			bw.setBroken(false)
+			Eventually(generation).Should(Equal(uint64(3)))
+			writes = bw.writes()
+			update("bar.cf.com", "127.0.0.3")
+			Eventually(logger).Should(
+				Say(`"f5router-config-unchanged-skipping-write".*"generation":3`))
+			Expect(bw.writes()).To(Equal(writes))
+		})
+
+		It("should be left out of the initial config", func() {
+			bw.Lock()
+			defer bw.Unlock()
+			Expect(bw.out.String()).To(ContainSubstring(`"global"`))
+			Expect(bw.out.String()).NotTo(ContainSubstring("generation"))
+			Expect(bw.out.String()).NotTo(ContainSubstring("full-sync"))
+		})
+
+		It("should mark the first write after startup as a full sync", func() {
+			fullSync := func() bool {
+				bw.Lock()
+				defer bw.Unlock()
+				if nil == bw.out || 0 == bw.out.Len() {
+					return false
+				}
+				var m configMatcher
+				Expect(json.Unmarshal(bw.out.Bytes(), &m)).To(Succeed())
+				return m.Global.FullSync
+			}
+
+			// The marker stays until a full sync has been written
+			bw.setBroken(true)
+			update("foo.cf.com", "127.0.0.1")
+			Eventually(logger).Should(Say("f5router-config-write-error"))
+			bw.setBroken(false)
+			Eventually(generation).Should(Equal(uint64(1)))
+			Expect(fullSync()).To(BeTrue())
+
+			update("bar.cf.com", "127.0.0.2")
+			Eventually(generation).Should(Equal(uint64(2)))
+			Expect(fullSync()).To(BeFalse())
+			bw.Lock()
+			defer bw.Unlock()
+			Expect(bw.out.String()).NotTo(ContainSubstring("full-sync"))
+		})
+	})
+
+	Describe("deterministic output", func() {
+		var logger *test_util.TestZapLogger
+
+		routes := []struct {
+			uri  route.Uri
+			addr string
+		}{
+			{"foo.cf.com", "127.0.0.1"},
+			{"bar.cf.com", "127.0.0.2"},
+			{"*.cf.com", "127.0.0.3"},
+			{"baz.cf.com/path", "127.0.0.4"},
+			{"foo.cf.com", "127.0.0.5"},
+			{"ser*.cf.com", "127.0.0.6"},
+		}
+
+		// output applies the routes in the given order and returns the config
+		// the router writes for them
+		output := func(order []int) []byte {
+			c := makeConfig()
+			c.BigIP.SSLProfiles = []string{"/Common/clientssl"}
+			mw := &MockWriter{}
+			router, err := NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+			Expect(err).NotTo(HaveOccurred())
+			stop := runRouter(router)
+			defer stop()
+
+			for _, i := range order {
+				ru, err := NewUpdate(logger, routeUpdate.Add, routes[i].uri, makeEndpoint(routes[i].addr), "")
+				Expect(err).NotTo(HaveOccurred())
+				router.UpdateRoute(ru)
+			}
+			Eventually(func() int {
+				var members int
+				for _, pool := range mw.getResources("cf").Pools {
+					members += len(pool.Members)
+				}
+				return members
+			}).Should(Equal(len(routes)))
+
+			// The generation depends on how the updates were batched into writes
+			written := mw.getInput()
+			written.Global.Generation = 0
+			out, err := json.Marshal(written)
+			Expect(err).NotTo(HaveOccurred())
+			return out
+		}
+
+		BeforeEach(func() {
+			logger = test_util.NewTestZapLogger("router-test")
+		})
+
+		AfterEach(func() {
+			if nil != logger {
+				logger.Close()
+			}
+		})
+
+		It("should write the same config no matter the order of the routes", func() {
+			expected := output([]int{0, 1, 2, 3, 4, 5})
+			Expect(output([]int{5, 4, 3, 2, 1, 0})).To(Equal(expected))
+			Expect(output([]int{3, 0, 5, 1, 4, 2})).To(Equal(expected))
+			Expect(output([]int{2, 4, 1, 5, 0, 3})).To(Equal(expected))
+		})
+
+		It("should name the objects of a route from its URI alone", func() {
+			Expect(makeObjectName("foo.cf.com")).To(Equal(makeObjectName("foo.cf.com")))
+			Expect(makeObjectName("foo.cf.com")).NotTo(Equal(makeObjectName("foo.cf.com/path")))
+			Expect(makeObjectName("*.cf.com")).To(Equal("cf-cf.com"))
+			Expect(makeObjectName("ser*.cf.com")).To(Equal("cf-ser_.cf.com"))
+			Expect(makeObjectName("localhost")).To(HavePrefix("cf-localhost-"))
+		})
+	})
+
+	Describe("scale signals", func() {
+		var (
+			logger      *test_util.TestZapLogger
+			mw          *MockWriter
+			router      *F5Router
+			stop        func()
+			signalsLock sync.Mutex
+			signals     []ScaleSignal
+		)
+
+		update := func(op routeUpdate.Operation, ep *route.Endpoint, planID string) {
+			ru, err := NewUpdate(logger, op, "foo.cf.com", ep, planID)
+			Expect(err).NotTo(HaveOccurred())
+			router.UpdateRoute(ru)
+		}
+
+		received := func() []ScaleSignal {
+			signalsLock.Lock()
+			defer signalsLock.Unlock()
+			return append([]ScaleSignal(nil), signals...)
+		}
+
+		summary := func() []string {
+			var s []string
+			for _, signal := range received() {
+				s = append(s, fmt.Sprintf("%s members=%d changes=%d", signal.Signal, signal.Members, signal.Changes))
+			}
+			return s
+		}
+
+		poolNames := func() []string {
+			var names []string
+			for _, pool := range mw.getResources("cf").Pools {
+				names = append(names, pool.Name)
+			}
+			return names
+		}
+
+		BeforeEach(func() {
+			logger = test_util.NewTestZapLogger("router-test")
+			mw = &MockWriter{}
+			var err error
+			router, err = NewF5Router(logger, makeConfig(), mw, &fakeClient.FakeClient{})
+			Expect(err).NotTo(HaveOccurred())
+			router.AddPlans(map[string]planResources.Plan{
+				"scaled": planResources.Plan{
+					ID: "scaled",
+					ScaleSignals: &planResources.ScaleSignalType{
+						MinMembers:       2,
+						MaxMembers:       3,
+						MaxMemberChanges: 4,
+					},
+				},
+			})
+			signals = nil
+			router.OnScaleSignal(func(signal ScaleSignal) {
+				signalsLock.Lock()
+				defer signalsLock.Unlock()
+				signals = append(signals, signal)
+			})
+			stop = runRouter(router)
+		})
+
+		AfterEach(func() {
+			stop()
+			if nil != logger {
+				logger.Close()
+			}
+		})
+
+		It("should signal when the route crosses its thresholds", func() {
+			update(routeUpdate.Add, makeEndpoint("127.0.0.1"), "")
+			update(routeUpdate.Bind, nil, "scaled")
+			Eventually(summary).Should(Equal([]string{"min-members members=1 changes=0"}))
+			signal := received()[0]
+			Expect(signal.Route).To(Equal("foo.cf.com"))
+			Expect(signal.Name).To(Equal(makeObjectName("foo.cf.com")))
+			Expect(signal.Threshold).To(Equal(2))
+			Eventually(logger).Should(Say("f5router-scale-signal"))
+
+			update(routeUpdate.Add, makeEndpoint("127.0.0.2"), "")
+			update(routeUpdate.Add, makeEndpoint("127.0.0.3"), "")
+			update(routeUpdate.Add, makeEndpoint("127.0.0.4"), "")
+			// Re-registering a member is not a change
+			update(routeUpdate.Add, makeEndpoint("127.0.0.4"), "")
+			update(routeUpdate.Remove, makeEndpoint("127.0.0.4"), "")
+			update(routeUpdate.Remove, makeEndpoint("127.0.0.3"), "")
+			update(routeUpdate.Remove, makeEndpoint("127.0.0.2"), "")
+			Eventually(summary).Should(Equal([]string{
+				"min-members members=1 changes=0",
+				"max-members members=4 changes=3",
+				"member-changes members=2 changes=5",
+				"min-members members=1 changes=6",
+			}))
+		})
+
+		It("should stop signaling once the route is unbound", func() {
+			update(routeUpdate.Add, makeEndpoint("127.0.0.1"), "")
+			update(routeUpdate.Add, makeEndpoint("127.0.0.2"), "")
+			update(routeUpdate.Bind, nil, "scaled")
+
+			update(routeUpdate.Unbind, nil, "")
+			update(routeUpdate.Remove, makeEndpoint("127.0.0.2"), "")
+			// Updates are processed in order, once bar is written foo was
+			// updated as well
+			ru, err := NewUpdate(logger, routeUpdate.Add, "bar.cf.com", makeEndpoint("127.0.0.3"), "")
+			Expect(err).NotTo(HaveOccurred())
+			router.UpdateRoute(ru)
+			Eventually(poolNames).Should(ContainElement(makeObjectName("bar.cf.com")))
+			Expect(received()).To(BeEmpty())
+		})
+
+		It("should watch a route bound before it is added", func() {
+			update(routeUpdate.Bind, nil, "scaled")
+			Eventually(summary).Should(Equal([]string{"min-members members=0 changes=0"}))
+
+			update(routeUpdate.Add, makeEndpoint("127.0.0.1"), "")
+			update(routeUpdate.Add, makeEndpoint("127.0.0.2"), "")
+			update(routeUpdate.Remove, makeEndpoint("127.0.0.2"), "")
+			Eventually(summary).Should(Equal([]string{
+				"min-members members=0 changes=0",
+				"min-members members=1 changes=3",
+			}))
+		})
+	})
+
+	Describe("endpoint validation", func() {
+		var (
+			logger *test_util.TestZapLogger
+			c      *config.Config
+			mw     *MockWriter
+			router *F5Router
+			stop   func()
+		)
+
+		addRoute := func(uri route.Uri, addr string, port uint16) {
+			ep := makeEndpoint(addr)
+			ep.Port = port
+			ru, err := NewUpdate(logger, routeUpdate.Add, uri, ep, "")
+			Expect(err).NotTo(HaveOccurred())
+			router.UpdateRoute(ru)
+		}
+
+		members := func(name string) []string {
+			var result []string
+			for _, pool := range mw.getResources("cf").Pools {
+				if pool.Name != name {
+					continue
+				}
+				for _, m := range pool.Members {
+					result = append(result, fmt.Sprintf("%s:%d", m.Address, m.Port))
+				}
+			}
+			return result
+		}
+
+		// sync adds a valid route after the others and waits for its pool,
+		// once it is written so are the routes before it
+		sync := func() {
+			addRoute("sentinel.cf.com", "10.0.0.9", 8080)
+			EventuallyWithOffset(1, func() []string {
+				return members(makeObjectName("sentinel.cf.com"))
+			}).ShouldNot(BeEmpty())
+		}
+
+		BeforeEach(func() {
+			logger = test_util.NewTestZapLogger("router-test")
+			c = makeConfig()
+			var err error
+			mw = &MockWriter{}
+			router, err = NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+			Expect(err).NotTo(HaveOccurred())
+			stop = runRouter(router)
+		})
+
+		AfterEach(func() {
+			stop()
+			if nil != logger {
+				logger.Close()
+			}
+		})
+
+		It("should only write the members of valid endpoints", func() {
+			for _, ep := range []struct {
+				addr string
+				port uint16
+			}{
+				{"10.0.0.1", 8080},
+				{"", 8080},
+				{"2001:db8::1", 8080},
+				{"10.0.0.256", 8080},
+				{"app.internal.example.com", 8080},
+				{"not an address", 8080},
+				{"10.0.0.2%3", 8080},
+				{"-bad.example.com", 8080},
+				{"2001:db8:::1", 8080},
+				{"10.0.0.3", 0},
+			} {
+				addRoute("foo.cf.com", ep.addr, ep.port)
+			}
+			sync()
+
+			Expect(members(makeObjectName("foo.cf.com"))).To(Equal([]string{
+				"10.0.0.1:8080",
+				"10.0.0.2%3:8080",
+				"2001:db8::1:8080",
+				"app.internal.example.com:8080",
+			}))
+			for _, invalid := range []string{
+				`invalid member address \\"\\"`,
+				`invalid member address \\"10.0.0.256\\"`,
+				`invalid member address \\"not an address\\"`,
+				`invalid member address \\"-bad.example.com\\"`,
+				`invalid member address \\"2001:db8:::1\\"`,
+				`invalid member port 0 for 10.0.0.3`,
+			} {
+				Expect(logger).To(Say(`f5router-skipping-invalid-endpoint.*"route":"foo.cf.com".*%s`, invalid))
+			}
+		})
+
+		It("should write an IPv6 member the same way however it is given", func() {
+			addRoute("foo.cf.com", "2001:db8::1", 8080)
+			addRoute("foo.cf.com", "[2001:DB8::1]", 8080)
+			addRoute("foo.cf.com", "2001:db8:0:0::1", 8080)
+			addRoute("foo.cf.com", "[2001:db8::2]", 8080)
+			sync()
+			Expect(members(makeObjectName("foo.cf.com"))).To(Equal([]string{
+				"2001:db8::1:8080",
+				"2001:db8::2:8080",
+			}))
+		})
+
+		It("should not create a route without a valid endpoint", func() {
+			addRoute("bar.cf.com", "10.0.0.256", 8080)
+			sync()
+
+			rs := mw.getResources("cf")
+			Expect(rs.Pools).To(HaveLen(1))
+			for _, v := range rs.Virtuals {
+				Expect(v.VirtualServerName).NotTo(Equal(makeObjectName("bar.cf.com")))
+			}
+			for _, p := range rs.Policies {
+				for _, rule := range p.Rules {
+					Expect(rule.Name).To(Equal(makeObjectName("sentinel.cf.com")))
+				}
+			}
+		})
+
+		It("should write members on host names as FQDN nodes when enabled", func() {
+			addRoute("foo.cf.com", "app.internal.example.com.", 8080)
+			sync()
+			for _, pool := range mw.getResources("cf").Pools {
+				for _, m := range pool.Members {
+					Expect(m.FQDN).To(BeNil())
+				}
+			}
+			stop()
+
+			c.BigIP.FQDNMembers = config.FQDNMembersConfig{Enabled: true, Autopopulate: true}
+			mw = &MockWriter{}
+			var err error
+			router, err = NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+			Expect(err).NotTo(HaveOccurred())
+			stop = runRouter(router)
+			addRoute("foo.cf.com", "app.internal.example.com.", 8080)
+			addRoute("foo.cf.com", "10.0.0.1", 8080)
+			addRoute("foo.cf.com", "-bad.example.com", 8080)
+			sync()
+
+			Expect(members(makeObjectName("foo.cf.com"))).To(Equal([]string{
+				"10.0.0.1:8080",
+				"app.internal.example.com.:8080",
+			}))
+			Expect(string(mw.getOutput())).To(ContainSubstring(
+				`{"address":"10.0.0.1","port":8080,"session":"user-enabled"}`))
+			Expect(string(mw.getOutput())).To(ContainSubstring(
+				`{"address":"app.internal.example.com.","port":8080,"session":"user-enabled",` +
+					`"fqdn":{"tmName":"app.internal.example.com","autopopulate":"enabled"}}`))
+
+			tc := *c
+			tc.BigIP.FQDNMembers.Autopopulate = false
+			member := bigipResources.Member{Address: "tcp.internal.example.com", Port: 5000}
+			tu, err := NewTCPUpdate(&tc, logger, routeUpdate.Add, 6010, member)
+			Expect(err).NotTo(HaveOccurred())
+			router.UpdateRoute(tu)
+			Eventually(func() *bigipResources.MemberFQDN {
+				for _, pool := range mw.getResources("cf").Pools {
+					if pool.Name == tu.Name() {
+						return pool.Members[0].FQDN
+					}
+				}
+				return nil
+			}).Should(Equal(&bigipResources.MemberFQDN{
+				Name:         "tcp.internal.example.com",
+				Autopopulate: "disabled",
+			}))
+		})
+
+		It("should require FQDN members for autopopulate", func() {
+			c.BigIP.FQDNMembers.Autopopulate = true
+			r, err := NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(r).To(BeNil())
+			Expect(err).To(MatchError("fqdn_members autopopulate requires fqdn_members enabled"))
+		})
+
+		It("should skip invalid TCP route members", func() {
+			var name string
+			for _, member := range []bigipResources.Member{
+				{Address: "10.0.0.1", Port: 5000},
+				{Address: "10.0.0.1.1", Port: 5000},
+				{Address: "10.0.0.2", Port: 0},
+			} {
+				tu, err := NewTCPUpdate(c, logger, routeUpdate.Add, 6010, member)
+				Expect(err).NotTo(HaveOccurred())
+				name = tu.Name()
+				router.UpdateRoute(tu)
+			}
+			sync()
+			Expect(members(name)).To(Equal([]string{"10.0.0.1:5000"}))
+			Expect(logger).To(Say(`f5router-skipping-invalid-endpoint.*"route":"6010"`))
+		})
+	})
+
+	Describe("pool member warnings", func() {
+		var (
+			logger   *test_util.TestZapLogger
+			c        *config.Config
+			mw       *MockWriter
+			router   *F5Router
+			stop     func()
+			reporter *fakeMetrics.FakeRouterReporter
+		)
+
+		start := func() {
+			var err error
+			router, err = NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+			Expect(err).NotTo(HaveOccurred())
+			router.SetReporter(reporter)
+			stop = runRouter(router)
+		}
+
+		update := func(op routeUpdate.Operation, addr string) {
+			ru, err := NewUpdate(logger, op, "bar.cf.com", makeEndpoint(addr), "")
+			Expect(err).NotTo(HaveOccurred())
+			router.UpdateRoute(ru)
+		}
+
+		members := func() int {
+			for _, pool := range mw.getResources("cf").Pools {
+				return len(pool.Members)
+			}
+			return 0
+		}
+
+		BeforeEach(func() {
+			logger = test_util.NewTestZapLogger("router-test")
+			c = makeConfig()
+			c.BigIP.PoolMemberWarning = 3
+			mw = &MockWriter{}
+			reporter = &fakeMetrics.FakeRouterReporter{}
+			stop = func() {}
+		})
+
+		AfterEach(func() {
+			stop()
+			if nil != logger {
+				logger.Close()
+			}
+		})
+
+		It("should warn at the threshold without dropping members", func() {
+			start()
+			update(routeUpdate.Add, "127.0.0.1")
+			update(routeUpdate.Add, "127.0.0.2")
+			Eventually(members).Should(Equal(2))
+			Expect(reporter.CapturePoolMemberWarningCallCount()).To(Equal(0))
+
+			update(routeUpdate.Add, "127.0.0.3")
+			Eventually(reporter.CapturePoolMemberWarningCallCount).Should(Equal(1))
+			pool, count := reporter.CapturePoolMemberWarningArgsForCall(0)
+			Expect(pool).To(Equal(makeObjectName("bar.cf.com")))
+			Expect(count).To(Equal(3))
+			Eventually(logger).Should(Say("f5router-pool-member-warning"))
+
+			// Only warn again once the pool went below the threshold
+			update(routeUpdate.Add, "127.0.0.4")
+			update(routeUpdate.Add, "127.0.0.5")
+			Eventually(members).Should(Equal(5))
+			Expect(reporter.CapturePoolMemberWarningCallCount()).To(Equal(1))
+
+			update(routeUpdate.Remove, "127.0.0.5")
+			update(routeUpdate.Remove, "127.0.0.4")
+			update(routeUpdate.Remove, "127.0.0.3")
+			Eventually(members).Should(Equal(2))
+			update(routeUpdate.Add, "127.0.0.3")
+			Eventually(reporter.CapturePoolMemberWarningCallCount).Should(Equal(2))
+		})
+
+		It("should not warn when disabled", func() {
+			c.BigIP.PoolMemberWarning = 0
+			start()
+			for i := 1; i <= 5; i++ {
+				update(routeUpdate.Add, fmt.Sprintf("127.0.0.%d", i))
+			}
+			Eventually(members).Should(Equal(5))
+			Expect(reporter.CapturePoolMemberWarningCallCount()).To(Equal(0))
+		})
+
+		It("should not allow a negative threshold", func() {
+			c := makeConfig()
+			c.BigIP.PoolMemberWarning = -1
+			_, err := NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).To(MatchError(ContainSubstring("pool_member_warning")))
+		})
+	})
+
+	Describe("draining pool members", func() {
+		var (
+			logger *test_util.TestZapLogger
+			c      *config.Config
+			router *F5Router
+			mw     *MockWriter
+			stop   func()
+		)
+
+		start := func() {
+			var err error
+			mw = &MockWriter{}
+			router, err = NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+			Expect(err).NotTo(HaveOccurred())
+			stop = runRouter(router)
+		}
+
+		update := func(op routeUpdate.Operation, uri route.Uri, addr string) {
+			ru, err := NewUpdate(logger, op, uri, makeEndpoint(addr), "")
+			Expect(err).NotTo(HaveOccurred())
+			router.UpdateRoute(ru)
+		}
+
+		// written returns the members of the route's pool in the last write,
+		// nil when the pool is gone
+		written := func() []bigipResources.Member {
+			for _, pool := range mw.getResources("cf").Pools {
+				if pool.Name == makeObjectName("bar.cf.com") {
+					return pool.Members
+				}
+			}
+			return nil
+		}
+
+		virtualNames := func() []string {
+			var names []string
+			for _, vs := range mw.getResources("cf").Virtuals {
+				names = append(names, vs.VirtualServerName)
+			}
+			return names
+		}
+
+		enabled := func(addr string) bigipResources.Member {
+			return bigipResources.Member{Address: addr, Port: 80, Session: "user-enabled"}
+		}
+		draining := func(addr string) bigipResources.Member {
+			return bigipResources.Member{Address: addr, Port: 80, Session: "user-disabled", State: "user-down"}
+		}
+
+		BeforeEach(func() {
+			logger = test_util.NewTestZapLogger("router-test")
+			c = makeConfig()
+			c.BigIP.DrainTimeout = 1
+			stop = func() {}
+		})
+
+		AfterEach(func() {
+			stop()
+			if nil != logger {
+				logger.Close()
+			}
+		})
+
+		It("should disable a removed member before removing it", func() {
+			start()
+			update(routeUpdate.Add, "bar.cf.com", "127.0.0.1")
+			update(routeUpdate.Add, "bar.cf.com", "127.0.0.2")
+			Eventually(written).Should(HaveLen(2))
+			update(routeUpdate.Remove, "bar.cf.com", "127.0.0.1")
+			Eventually(written).Should(Equal([]bigipResources.Member{
+				draining("127.0.0.1"),
+				enabled("127.0.0.2"),
+			}))
+			Eventually(logger).Should(Say("f5router-member-draining"))
+
+			// A repeated removal keeps the running drain
+			update(routeUpdate.Remove, "bar.cf.com", "127.0.0.1")
+			update(routeUpdate.Add, "foo.cf.com", "127.0.0.3")
+			Eventually(virtualNames).Should(ContainElement(makeObjectName("foo.cf.com")))
+			Expect(logger).NotTo(Say("f5router-member-draining"))
+
+			// Removed once the drain timeout passes
+			Eventually(written, 3).Should(Equal([]bigipResources.Member{enabled("127.0.0.2")}))
+			Eventually(logger).Should(Say("f5router-member-drained"))
+		})
+
+		It("should remove the route with its last drained member", func() {
+			start()
+			update(routeUpdate.Add, "bar.cf.com", "127.0.0.1")
+			Eventually(written).Should(HaveLen(1))
+			update(routeUpdate.Remove, "bar.cf.com", "127.0.0.1")
+			Eventually(written).Should(Equal([]bigipResources.Member{draining("127.0.0.1")}))
+			Expect(virtualNames()).To(ContainElement(makeObjectName("bar.cf.com")))
+
+			Eventually(written, 3).Should(BeNil())
+			Expect(virtualNames()).NotTo(ContainElement(makeObjectName("bar.cf.com")))
+		})
+
+		It("should enable a member added back while draining", func() {
+			start()
+			update(routeUpdate.Add, "bar.cf.com", "127.0.0.1")
+			update(routeUpdate.Add, "bar.cf.com", "127.0.0.2")
+			Eventually(written).Should(HaveLen(2))
+			update(routeUpdate.Remove, "bar.cf.com", "127.0.0.1")
+			update(routeUpdate.Add, "bar.cf.com", "127.0.0.1")
+			Eventually(logger).Should(Say("f5router-member-drain-canceled"))
+			Eventually(written).Should(Equal([]bigipResources.Member{
+				enabled("127.0.0.1"),
+				enabled("127.0.0.2"),
+			}))
+
+			// The expired drain is ignored
+			Consistently(written, 1.5).Should(Equal([]bigipResources.Member{
+				enabled("127.0.0.1"),
+				enabled("127.0.0.2"),
+			}))
+			Expect(logger).NotTo(Say("f5router-member-drained"))
+		})
+
+		It("should remove members right away by default", func() {
+			c.BigIP.DrainTimeout = 0
+			start()
+			update(routeUpdate.Add, "bar.cf.com", "127.0.0.1")
+			update(routeUpdate.Add, "bar.cf.com", "127.0.0.2")
+			Eventually(written).Should(HaveLen(2))
+			update(routeUpdate.Remove, "bar.cf.com", "127.0.0.1")
+			Eventually(written).Should(Equal([]bigipResources.Member{enabled("127.0.0.2")}))
+			Expect(logger).NotTo(Say("f5router-member-draining"))
+		})
+
+		It("should not allow a negative drain timeout", func() {
+			c := makeConfig()
+			c.BigIP.DrainTimeout = -1
+			_, err := NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).To(MatchError("drain_timeout must not be negative: -1"))
+		})
+	})
+
+	Describe("disabling routes", func() {
+		var (
+			logger *test_util.TestZapLogger
+			router *F5Router
+			mw     *MockWriter
+			stop   func()
+		)
+
+		start := func(c *config.Config) {
+			mw = &MockWriter{}
+			var err error
+			router, err = NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+			Expect(err).NotTo(HaveOccurred())
+			stop = runRouter(router)
+		}
+
+		update := func(op routeUpdate.Operation, uri route.Uri, addr string) {
+			var ep *route.Endpoint
+			if "" != addr {
+				ep = makeEndpoint(addr)
+			}
+			ru, err := NewUpdate(logger, op, uri, ep, "")
+			Expect(err).NotTo(HaveOccurred())
+			router.UpdateRoute(ru)
+		}
+
+		// written returns the members of the route's pool in the last write,
+		// nil when the pool is gone
+		written := func(uri string) func() []bigipResources.Member {
+			return func() []bigipResources.Member {
+				for _, pool := range mw.getResources("cf").Pools {
+					if pool.Name == makeObjectName(uri) {
+						return pool.Members
+					}
+				}
+				return nil
+			}
+		}
+
+		enabled := func(addr string) bigipResources.Member {
+			return bigipResources.Member{Address: addr, Port: 80, Session: "user-enabled"}
+		}
+		disabled := func(addr string) bigipResources.Member {
+			return bigipResources.Member{Address: addr, Port: 80, Session: "user-disabled", State: "user-down"}
+		}
+
+		BeforeEach(func() {
+			logger = test_util.NewTestZapLogger("router-test")
+			start(makeConfig())
+		})
+
+		AfterEach(func() {
+			stop()
+			if nil != logger {
+				logger.Close()
+			}
+		})
+
+		It("should disable the members of a route until it is enabled", func() {
+			update(routeUpdate.Add, "foo.cf.com", "127.0.0.1")
+			update(routeUpdate.Add, "foo.cf.com", "127.0.0.2")
+			update(routeUpdate.Add, "bar.cf.com", "127.0.0.3")
+
+			update(routeUpdate.Disable, "foo.cf.com", "")
+			Eventually(logger).Should(Say("f5router-route-disabled"))
+			Eventually(written("foo.cf.com")).Should(Equal([]bigipResources.Member{
+				disabled("127.0.0.1"),
+				disabled("127.0.0.2"),
+			}))
+			Expect(written("bar.cf.com")()).To(Equal([]bigipResources.Member{enabled("127.0.0.3")}))
+			var virtuals []string
+			for _, vs := range mw.getResources("cf").Virtuals {
+				virtuals = append(virtuals, vs.VirtualServerName)
+			}
+			Expect(virtuals).To(ContainElement(makeObjectName("foo.cf.com")))
+
+			// Members keep changing while the route is disabled
+			update(routeUpdate.Add, "foo.cf.com", "127.0.0.4")
+			update(routeUpdate.Remove, "foo.cf.com", "127.0.0.1")
+			Eventually(written("foo.cf.com")).Should(Equal([]bigipResources.Member{
+				disabled("127.0.0.2"),
+				disabled("127.0.0.4"),
+			}))
+
+			update(routeUpdate.Enable, "foo.cf.com", "")
+			Eventually(logger).Should(Say("f5router-route-enabled"))
+			Eventually(written("foo.cf.com")).Should(Equal([]bigipResources.Member{
+				enabled("127.0.0.2"),
+				enabled("127.0.0.4"),
+			}))
+		})
+
+		It("should keep a route disabled when it is registered again", func() {
+			update(routeUpdate.Add, "foo.cf.com", "127.0.0.1")
+			update(routeUpdate.Disable, "foo.cf.com", "")
+			update(routeUpdate.Remove, "foo.cf.com", "127.0.0.1")
+			update(routeUpdate.Add, "bar.cf.com", "127.0.0.3")
+			Eventually(written("bar.cf.com")).ShouldNot(BeNil())
+			Expect(written("foo.cf.com")()).To(BeNil())
+
+			update(routeUpdate.Add, "foo.cf.com", "127.0.0.1")
+			Eventually(written("foo.cf.com")).Should(Equal([]bigipResources.Member{disabled("127.0.0.1")}))
+		})
+
+		It("should ignore disabling a disabled route", func() {
+			update(routeUpdate.Add, "foo.cf.com", "127.0.0.1")
+			update(routeUpdate.Disable, "foo.cf.com", "")
+			update(routeUpdate.Disable, "foo.cf.com", "")
+			Eventually(logger).Should(Say("f5router-route-disable-unchanged"))
+			update(routeUpdate.Enable, "foo.cf.com", "")
+			Eventually(written("foo.cf.com")).Should(Equal([]bigipResources.Member{enabled("127.0.0.1")}))
+		})
+
+		It("should disable a TCP route", func() {
+			stop()
+			c := makeConfig()
+			c.RoutingMode = config.TCP
+			c.TCPRouterGroupName = "default-tcp"
+			start(c)
+
+			member := bigipResources.Member{Address: "10.0.0.1", Port: 5000}
+			for _, op := range []routeUpdate.Operation{routeUpdate.Add, routeUpdate.Disable} {
+				tu, err := NewTCPUpdate(c, logger, op, 6010, member)
+				Expect(err).NotTo(HaveOccurred())
+				router.UpdateRoute(tu)
+			}
+			members := func() []bigipResources.Member {
+				pools := mw.getResources("cf").Pools
+				Expect(len(pools)).To(BeNumerically("<=", 1))
+				if 0 == len(pools) {
+					return nil
+				}
+				return pools[0].Members
+			}
+			Eventually(members).Should(Equal([]bigipResources.Member{{
+				Address: "10.0.0.1",
+				Port:    5000,
+				Session: "user-disabled",
+				State:   "user-down",
+			}}))
+			Expect(mw.getResources("cf").Virtuals).NotTo(BeEmpty())
+
+			tu, err := NewTCPUpdate(c, logger, routeUpdate.Enable, 6010, member)
+			Expect(err).NotTo(HaveOccurred())
+			router.UpdateRoute(tu)
+			Eventually(func() string {
+				m := members()
+				Expect(m).To(HaveLen(1))
+				return m[0].State
+			}).Should(BeEmpty())
+		})
+	})
+
+	Describe("pending updates limit", func() {
+		var (
+			logger *test_util.TestZapLogger
+			c      *config.Config
+			router *F5Router
+			gw     *gatedWriter
+			stop   func()
+		)
+
+		// newRouter runs a router whose worker is held in its first write
+		// after the initial config until the gate is opened
+		newRouter := func() error {
+			var err error
+			gw = &gatedWriter{gate: make(chan struct{})}
+			router, err = NewF5Router(logger, c, gw, &fakeClient.FakeClient{})
+			if nil != err {
+				return err
+			}
+			stop = runRouter(router)
+			return nil
+		}
+
+		// flood updates the routes from a goroutine each, returning a channel
+		// closed once every update was queued
+		flood := func(routes int) <-chan struct{} {
+			var wg sync.WaitGroup
+			for i := 0; i < routes; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					uri := route.Uri(fmt.Sprintf("app%d.cf.com", i))
+					ru, err := NewUpdate(logger, routeUpdate.Add, uri, makeEndpoint("127.0.0.1"), "")
+					Expect(err).NotTo(HaveOccurred())
+					router.UpdateRoute(ru)
+				}(i)
+			}
+			queued := make(chan struct{})
+			go func() {
+				wg.Wait()
+				close(queued)
+			}()
+			return queued
+		}
+
+		pools := func() []*bigipResources.Pool {
+			return gw.getResources("cf").Pools
+		}
+
+		BeforeEach(func() {
+			logger = test_util.NewTestZapLogger("router-test")
+			c = makeConfig()
+			stop = func() {}
+		})
+
+		AfterEach(func() {
+			if nil != gw {
+				gw.open()
+			}
+			stop()
+			if nil != logger {
+				logger.Close()
+			}
+		})
+
+		It("should not limit the pending updates by default", func() {
+			Expect(newRouter()).To(Succeed())
+			Eventually(flood(50)).Should(BeClosed())
+
+			gw.open()
+			Eventually(pools).Should(HaveLen(50))
+		})
+
+		It("should hold back updates until the worker makes room", func() {
+			c.BigIP.MaxPendingUpdates = 5
+			Expect(newRouter()).To(Succeed())
+			queued := flood(100)
+			Eventually(logger).Should(Say("f5router-route-update-waiting"))
+			Consistently(queued, 200*time.Millisecond).ShouldNot(BeClosed())
+
+			gw.open()
+			Eventually(queued).Should(BeClosed())
+			Eventually(pools).Should(HaveLen(100))
+			names := make(map[string]bool)
+			for _, pool := range pools() {
+				names[pool.Name] = true
+			}
+			for i := 0; i < 100; i++ {
+				Expect(names).To(HaveKey(makeObjectName(fmt.Sprintf("app%d.cf.com", i))))
+			}
+		})
+
+		It("should release the waiting updates on shutdown", func() {
+			c.BigIP.MaxPendingUpdates = 1
+			Expect(newRouter()).To(Succeed())
+			queued := flood(3)
+			Eventually(logger).Should(Say("f5router-route-update-waiting"))
+			Expect(queued).NotTo(BeClosed())
+
+			// The worker is still held, only shutting down the queue releases
+			// the waiting updates
+			stopped := make(chan struct{})
+			go func(stop func()) {
+				defer GinkgoRecover()
+				stop()
+				close(stopped)
+			}(stop)
+			stop = func() {}
+			Eventually(queued).Should(BeClosed())
+			gw.open()
+			Eventually(stopped).Should(BeClosed())
+		})
+
+		It("should not allow a negative limit", func() {
+			c.BigIP.MaxPendingUpdates = -1
+			Expect(newRouter()).To(MatchError("max_pending_updates must not be negative: -1"))
+		})
+	})
+
+	Describe("draining on shutdown", func() {
+		var (
+			logger *test_util.TestZapLogger
+			c      *config.Config
+			mw     *MockWriter
+		)
+
+		members := func() []bigipResources.Member {
+			m := mw.getInput()
+			var all []bigipResources.Member
+			for _, rs := range m.Resources {
+				for _, pool := range rs.Pools {
+					all = append(all, pool.Members...)
+				}
+			}
+			return all
+		}
+
+		// run starts the router with a route and returns the channel to
+		// signal it along with the one closed once Run returned
+		run := func() (chan os.Signal, chan struct{}) {
+			router, err := NewF5Router(logger, c, mw, bigipclient.DefaultClient())
+			Expect(err).NotTo(HaveOccurred())
+			signals := make(chan os.Signal)
+			ready := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				Expect(router.Run(signals, ready)).To(Succeed())
+				close(done)
+			}()
+			Eventually(ready).Should(BeClosed(), "timed out waiting for ready")
+
+			for _, addr := range []string{"127.0.0.1", "127.0.0.2"} {
+				ru, err := NewUpdate(logger, routeUpdate.Add, "foo.cf.com", makeEndpoint(addr), "")
+				Expect(err).NotTo(HaveOccurred())
+				router.UpdateRoute(ru)
+			}
+			Eventually(members).Should(HaveLen(2))
+			return signals, done
+		}
+
+		BeforeEach(func() {
+			logger = test_util.NewTestZapLogger("router-test")
+			c = makeConfig()
+			mw = &MockWriter{}
+		})
+
+		AfterEach(func() {
+			if nil != logger {
+				logger.Close()
+			}
+		})
+
+		It("should write a config with every member disabled before stopping", func() {
+			c.BigIP.ShutdownDrainTimeout = 1
+			signals, done := run()
+
+			start := time.Now()
+			signals <- MockSignal(123)
+			Eventually(members).Should(ConsistOf(
+				bigipResources.Member{Address: "127.0.0.1", Port: 80, Session: "user-disabled", State: "user-down"},
+				bigipResources.Member{Address: "127.0.0.2", Port: 80, Session: "user-disabled", State: "user-down"},
+			))
+			Eventually(done, 3).Should(BeClosed(), "timed out waiting for Run to complete")
+			Expect(time.Since(start)).To(BeNumerically(">=", time.Second))
+			Expect(logger).To(Say("f5router-shutdown-draining"))
+			Expect(logger).To(Say("f5router-shutdown-drained"))
+		})
+
+		It("should drain once when the drainer runs before the router stops", func() {
+			c.BigIP.ShutdownDrainTimeout = 1
+			router, err := NewF5Router(logger, c, mw, bigipclient.DefaultClient())
+			Expect(err).NotTo(HaveOccurred())
+			signals := make(chan os.Signal)
+			ready := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				Expect(router.Run(signals, ready)).To(Succeed())
+				close(done)
+			}()
+			Eventually(ready).Should(BeClosed(), "timed out waiting for ready")
+
+			drainerSignals := make(chan os.Signal)
+			drainerReady := make(chan struct{})
+			drainerDone := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				Expect(NewShutdownDrainer(router).Run(drainerSignals, drainerReady)).To(Succeed())
+				close(drainerDone)
+			}()
+			Eventually(drainerReady).Should(BeClosed())
+
+			drainerSignals <- MockSignal(123)
+			Eventually(drainerDone, 3).Should(BeClosed())
+			Expect(logger).To(Say("f5router-shutdown-drained"))
+
+			// The router stops right away once drained
+			start := time.Now()
+			signals <- MockSignal(123)
+			Eventually(done).Should(BeClosed(), "timed out waiting for Run to complete")
+			Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		})
+
+		It("should skip route updates once drained", func() {
+			c.BigIP.ShutdownDrainTimeout = 1
+			router, err := NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+			Expect(err).NotTo(HaveOccurred())
+			stop := runRouter(router)
+			defer stop()
+
+			ru, err := NewUpdate(logger, routeUpdate.Add, "foo.cf.com", makeEndpoint("127.0.0.1"), "")
+			Expect(err).NotTo(HaveOccurred())
+			router.UpdateRoute(ru)
+			Eventually(members).Should(HaveLen(1))
+
+			drainerSignals := make(chan os.Signal)
+			drainerReady := make(chan struct{})
+			drainerDone := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				Expect(NewShutdownDrainer(router).Run(drainerSignals, drainerReady)).To(Succeed())
+				close(drainerDone)
+			}()
+			Eventually(drainerReady).Should(BeClosed())
+			drainerSignals <- MockSignal(123)
+			Eventually(drainerDone, 3).Should(BeClosed())
+
+			ru, err = NewUpdate(logger, routeUpdate.Add, "foo.cf.com", makeEndpoint("127.0.0.2"), "")
+			Expect(err).NotTo(HaveOccurred())
+			router.UpdateRoute(ru)
+			Eventually(logger).Should(Say("f5router-shutdown-skipping-update"))
+			Expect(members()).To(Equal([]bigipResources.Member{
+				{Address: "127.0.0.1", Port: 80, Session: "user-disabled", State: "user-down"},
+			}))
+		})
+
+		It("should stop right away when disabled", func() {
+			signals, done := run()
+			start := time.Now()
+			signals <- MockSignal(123)
+			Eventually(done).Should(BeClosed(), "timed out waiting for Run to complete")
+			Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+			Expect(members()).To(ConsistOf(
+				bigipResources.Member{Address: "127.0.0.1", Port: 80, Session: "user-enabled"},
+				bigipResources.Member{Address: "127.0.0.2", Port: 80, Session: "user-enabled"},
+			))
+		})
+
+		It("should not allow a negative shutdown drain timeout", func() {
+			c.BigIP.ShutdownDrainTimeout = -1
+			_, err := NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).To(MatchError("shutdown_drain_timeout must not be negative: -1"))
+		})
+	})
+
+	Describe("HTTP monitor", func() {
+		var (
+			logger *test_util.TestZapLogger
+			c      *config.Config
+			mw     *MockWriter
+			router *F5Router
+			stop   func()
+		)
+
+		run := func() {
+			var err error
+			mw = &MockWriter{}
+			router, err = NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+			Expect(err).NotTo(HaveOccurred())
+			stop = runRouter(router)
+		}
+
+		start := func() {
+			run()
+			for _, uri := range []route.Uri{"foo.cf.com", "bar.cf.com"} {
+				ru, err := NewUpdate(logger, routeUpdate.Add, uri, makeEndpoint("127.0.0.1"), "")
+				Expect(err).NotTo(HaveOccurred())
+				router.UpdateRoute(ru)
+			}
+			Eventually(func() []*bigipResources.Pool {
+				return mw.getResources("cf").Pools
+			}).Should(HaveLen(2))
+		}
+
+		written := func() string {
+			return string(mw.getOutput())
+		}
+
+		BeforeEach(func() {
+			logger = test_util.NewTestZapLogger("router-test")
+			c = makeConfig()
+			stop = func() {}
+		})
+
+		AfterEach(func() {
+			stop()
+			if nil != logger {
+				logger.Close()
+			}
+		})
+
+		It("should not create a monitor without a send string", func() {
+			start()
+
+			rs := mw.getResources("cf")
+			Expect(rs.Monitors).To(BeEmpty())
+			for _, pool := range rs.Pools {
+				Expect(pool.MonitorNames).To(Equal([]string{"/Common/tcp_half_open"}))
+			}
+			Expect(written()).NotTo(ContainSubstring(HTTPMonitorName))
+		})
+
+		It("should share one monitor across the HTTP route pools", func() {
+			start()
+			without := written()
+			stop()
+
+			c.BigIP.HTTPMonitor.Send = "GET /health HTTP/1.0\\r\\n\\r\\n"
+			c.BigIP.HTTPMonitor.Recv = "200 OK"
+			start()
+
+			rs := mw.getResources("cf")
+			for _, pool := range rs.Pools {
+				Expect(pool.MonitorNames).To(Equal([]string{"/Common/tcp_half_open", "/cf/cf-http-monitor"}))
+			}
+			Expect(rs.Monitors).To(Equal([]*bigipResources.Monitor{
+				&bigipResources.Monitor{
+					Name:     HTTPMonitorName,
+					Type:     "http",
+					Send:     c.BigIP.HTTPMonitor.Send,
+					Recv:     "200 OK",
+					Interval: 5,
+					Timeout:  16,
+				},
+			}))
+
+			output := written()
+			Expect(output).NotTo(Equal(without))
+			Expect(output).To(ContainSubstring(
+				`"monitors":[{"name":"cf-http-monitor","interval":5,"type":"http",` +
+					`"send":"GET /health HTTP/1.0\\r\\n\\r\\n","recv":"200 OK","timeout":16}]`))
+			Expect(strings.Count(output, `"name":"cf-http-monitor"`)).To(Equal(1))
+		})
+
+		Context("context path monitors", func() {
+			update := func(op routeUpdate.Operation, uri route.Uri, planID string) {
+				var ep *route.Endpoint
+				if op == routeUpdate.Add || op == routeUpdate.Remove {
+					ep = makeEndpoint("127.0.0.1")
+				}
+				ru, err := NewUpdate(logger, op, uri, ep, planID)
+				Expect(err).NotTo(HaveOccurred())
+				router.UpdateRoute(ru)
+			}
+
+			monitorNames := func(uri string) func() []string {
+				return func() []string {
+					for _, pool := range mw.getResources("cf").Pools {
+						if pool.Name == makeObjectName(uri) {
+							return pool.MonitorNames
+						}
+					}
+					return nil
+				}
+			}
+
+			monitors := func() map[string]string {
+				sends := make(map[string]string)
+				for _, m := range mw.getResources("cf").Monitors {
+					sends[m.Name] = m.Send
+				}
+				return sends
+			}
+
+			var pathMonitor string
+
+			BeforeEach(func() {
+				c.BigIP.HTTPMonitor.Send = "GET / HTTP/1.0\\r\\n\\r\\n"
+				pathMonitor = makeContextPathMonitor("/segment1/segment2/segment3", config.MONITOR_HTTP, c.BigIP.HTTPMonitor).Name
+			})
+
+			It("should check context path routes on the root without the flag", func() {
+				start()
+				update(routeUpdate.Add, "baz.cf.com/segment1/segment2/segment3", "")
+				Eventually(monitorNames("baz.cf.com/segment1/segment2/segment3")).Should(
+					Equal([]string{"/Common/tcp_half_open", "/cf/cf-http-monitor"}))
+				Expect(monitors()).To(Equal(map[string]string{HTTPMonitorName: c.BigIP.HTTPMonitor.Send}))
+			})
+
+			It("should check context path routes on their path", func() {
+				c.BigIP.HTTPMonitor.ContextPath = true
+				start()
+				update(routeUpdate.Add, "baz.cf.com/segment1/segment2/segment3", "")
+				update(routeUpdate.Add, "qux.cf.com/segment1/segment2/segment3", "")
+
+				Eventually(monitorNames("qux.cf.com/segment1/segment2/segment3")).Should(
+					Equal([]string{"/Common/tcp_half_open", "/cf/" + pathMonitor}))
+				Expect(monitorNames("foo.cf.com")()).To(
+					Equal([]string{"/Common/tcp_half_open", "/cf/cf-http-monitor"}))
+				Expect(monitorNames("baz.cf.com/segment1/segment2/segment3")()).To(
+					Equal([]string{"/Common/tcp_half_open", "/cf/" + pathMonitor}))
+				Expect(monitors()).To(Equal(map[string]string{
+					HTTPMonitorName: c.BigIP.HTTPMonitor.Send,
+					pathMonitor:     "GET /segment1/segment2/segment3 HTTP/1.0\\r\\n\\r\\n",
+				}))
+
+				update(routeUpdate.Remove, "baz.cf.com/segment1/segment2/segment3", "")
+				Eventually(monitorNames("baz.cf.com/segment1/segment2/segment3")).Should(BeNil())
+				Expect(monitors()).To(HaveKey(pathMonitor))
+				update(routeUpdate.Remove, "qux.cf.com/segment1/segment2/segment3", "")
+				Eventually(monitors).Should(Equal(map[string]string{HTTPMonitorName: c.BigIP.HTTPMonitor.Send}))
+			})
+
+			It("should restore the path monitor when a plan is unbound", func() {
+				c.BigIP.HTTPMonitor.ContextPath = true
+				start()
+				router.AddPlans(map[string]planResources.Plan{
+					"plan1": planResources.Plan{
+						ID: "plan1",
+						Pool: planResources.PoolType{
+							HealthMonitors: []bigipResources.Monitor{
+								bigipResources.Monitor{Name: "plan-monitor", Type: "tcp"},
+							},
+						},
+					},
+				})
+				uri := route.Uri("baz.cf.com/segment1/segment2/segment3")
+				update(routeUpdate.Add, uri, "")
+				Eventually(monitorNames(uri.String())).Should(
+					Equal([]string{"/Common/tcp_half_open", "/cf/" + pathMonitor}))
+
+				update(routeUpdate.Bind, uri, "plan1")
+				Eventually(monitorNames(uri.String())).Should(Equal([]string{"/cf/plan-monitor"}))
+				Expect(monitors()).NotTo(HaveKey(pathMonitor))
+
+				update(routeUpdate.Unbind, uri, "")
+				Eventually(monitorNames(uri.String())).Should(
+					Equal([]string{"/Common/tcp_half_open", "/cf/" + pathMonitor}))
+				Expect(monitors()).To(HaveKey(pathMonitor))
+			})
+		})
+
+		It("should require a timeout longer than the interval", func() {
+			c.BigIP.HTTPMonitor.Send = "GET /"
+			c.BigIP.HTTPMonitor.Timeout = 5
+			_, err := NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).To(MatchError(ContainSubstring("http_monitor")))
+
+			c.BigIP.HTTPMonitor.Interval = 0
+			_, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).To(MatchError(ContainSubstring("http_monitor")))
+		})
+
+		It("should write the configured interval and timeout", func() {
+			c.BigIP.HTTPMonitor.Send = "GET /"
+			for _, timing := range []struct {
+				interval, timeout int
+				valid             bool
+			}{
+				{1, 2, true},
+				{5, 6, true},
+				{10, 31, true},
+				{30, 91, true},
+				{5, 5, false},
+				{6, 5, false},
+				{0, 5, false},
+				{-1, 5, false},
+				{5, 0, false},
+			} {
+				c.BigIP.HTTPMonitor.Interval = timing.interval
+				c.BigIP.HTTPMonitor.Timeout = timing.timeout
+				r, err := NewF5Router(logger, c, &MockWriter{}, nil)
+				if !timing.valid {
+					Expect(r).To(BeNil())
+					Expect(err).To(MatchError(fmt.Sprintf(
+						"http_monitor interval must be positive and less than its timeout: interval %d, timeout %d",
+						timing.interval, timing.timeout)))
+					continue
+				}
+				Expect(err).NotTo(HaveOccurred())
+				m := r.monitorResources[HTTPMonitorName][0]
+				Expect(m.Interval).To(Equal(timing.interval))
+				Expect(m.Timeout).To(Equal(timing.timeout))
+			}
+		})
+
+		It("should check the timing of the route health check monitors", func() {
+			c.BigIP.HTTPMonitor.Interval = 10
+			c.BigIP.HTTPMonitor.Timeout = 10
+			_, err := NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).To(MatchError(ContainSubstring("http_monitor interval")))
+
+			// Without a send string or tags no monitor is created
+			c.BigIP.HTTPMonitor.PathTag = ""
+			c.BigIP.HTTPMonitor.ExpectTag = ""
+			_, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		Context("HTTPS monitors", func() {
+			addRoute := func(uri route.Uri, tags map[string]string) {
+				ep := makeEndpoint("127.0.0.1")
+				ep.Tags = tags
+				ru, err := NewUpdate(logger, routeUpdate.Add, uri, ep, "")
+				Expect(err).NotTo(HaveOccurred())
+				router.UpdateRoute(ru)
+			}
+
+			monitorNames := func(uri string) func() []string {
+				return func() []string {
+					for _, pool := range mw.getResources("cf").Pools {
+						if pool.Name == makeObjectName(uri) {
+							return pool.MonitorNames
+						}
+					}
+					return nil
+				}
+			}
+
+			BeforeEach(func() {
+				c.BigIP.HTTPMonitor.Send = "GET /health HTTP/1.0\\r\\n\\r\\n"
+				c.BigIP.HTTPMonitor.Recv = "200 OK"
+			})
+
+			It("should check the pools over TLS with the server SSL profile", func() {
+				start()
+				httpOutput := written()
+				stop()
+
+				c.BigIP.HTTPMonitor.Type = config.MONITOR_HTTPS
+				c.BigIP.HTTPMonitor.ServerSSL = "/Common/serverssl"
+				c.BigIP.HTTPMonitor.Cipherlist = "DEFAULT:+SHA:+3DES"
+				start()
+
+				rs := mw.getResources("cf")
+				for _, pool := range rs.Pools {
+					Expect(pool.MonitorNames).To(Equal([]string{"/Common/tcp_half_open", "/cf/cf-https-monitor"}))
+				}
+				Expect(rs.Monitors).To(Equal([]*bigipResources.Monitor{
+					&bigipResources.Monitor{
+						Name:       HTTPSMonitorName,
+						Type:       "https",
+						Send:       c.BigIP.HTTPMonitor.Send,
+						Recv:       "200 OK",
+						Interval:   5,
+						Timeout:    16,
+						SSLProfile: "/Common/serverssl",
+						Cipherlist: "DEFAULT:+SHA:+3DES",
+					},
+				}))
+
+				output := written()
+				Expect(output).NotTo(Equal(httpOutput))
+				Expect(output).To(ContainSubstring(
+					`"monitors":[{"name":"cf-https-monitor","interval":5,"type":"https",` +
+						`"send":"GET /health HTTP/1.0\\r\\n\\r\\n","recv":"200 OK","timeout":16,` +
+						`"sslProfile":"/Common/serverssl","cipherlist":"DEFAULT:+SHA:+3DES"}]`))
+				Expect(output).NotTo(ContainSubstring(`"name":"cf-http-monitor"`))
+				Expect(httpOutput).NotTo(ContainSubstring("sslProfile"))
+			})
+
+			It("should require a server SSL profile", func() {
+				c.BigIP.HTTPMonitor.Type = config.MONITOR_HTTPS
+				_, err := NewF5Router(logger, c, &MockWriter{}, nil)
+				Expect(err).To(MatchError("http_monitor type https requires a server_ssl profile"))
+
+				c.BigIP.HTTPMonitor.Type = "tls"
+				_, err = NewF5Router(logger, c, &MockWriter{}, nil)
+				Expect(err).To(MatchError(ContainSubstring("invalid http_monitor type tls")))
+			})
+
+			It("should select the monitor type of a route from its tag", func() {
+				c.BigIP.HTTPMonitor.Tag = "monitor"
+				c.BigIP.HTTPMonitor.ServerSSL = "/Common/serverssl"
+				c.BigIP.HTTPMonitor.ContextPath = true
+				run()
+				addRoute("foo.cf.com", map[string]string{"monitor": "https"})
+				addRoute("bar.cf.com", map[string]string{"monitor": "http"})
+				addRoute("baz.cf.com", nil)
+				addRoute("qux.cf.com/path", map[string]string{"monitor": "https"})
+
+				pathMonitor := makeContextPathMonitor("/path", config.MONITOR_HTTPS, c.BigIP.HTTPMonitor)
+				Expect(pathMonitor.Name).To(HavePrefix(HTTPSMonitorName + "-"))
+				Eventually(monitorNames("qux.cf.com/path")).Should(
+					Equal([]string{"/Common/tcp_half_open", "/cf/" + pathMonitor.Name}))
+				Expect(monitorNames("foo.cf.com")()).To(Equal([]string{"/Common/tcp_half_open", "/cf/cf-https-monitor"}))
+				Expect(monitorNames("bar.cf.com")()).To(Equal([]string{"/Common/tcp_half_open", "/cf/cf-http-monitor"}))
+				Expect(monitorNames("baz.cf.com")()).To(Equal([]string{"/Common/tcp_half_open", "/cf/cf-http-monitor"}))
+
+				types := make(map[string]string)
+				for _, m := range mw.getResources("cf").Monitors {
+					types[m.Name] = m.Type
+				}
+				Expect(types).To(Equal(map[string]string{
+					HTTPMonitorName:  "http",
+					HTTPSMonitorName: "https",
+					pathMonitor.Name: "https",
+				}))
+			})
+
+			It("should not select an HTTPS monitor without a server SSL profile", func() {
+				c.BigIP.HTTPMonitor.Tag = "monitor"
+				run()
+				addRoute("foo.cf.com", map[string]string{"monitor": "https"})
+				addRoute("bar.cf.com", map[string]string{"monitor": "icmp"})
+
+				Eventually(logger).Should(Say(`skipping-route-monitor-type.*"monitor-type":"https"`))
+				Eventually(logger).Should(Say(`skipping-route-monitor-type.*"monitor-type":"icmp"`))
+				Eventually(monitorNames("bar.cf.com")).Should(Equal([]string{"/Common/tcp_half_open", "/cf/cf-http-monitor"}))
+				Expect(monitorNames("foo.cf.com")()).To(Equal([]string{"/Common/tcp_half_open", "/cf/cf-http-monitor"}))
+				Expect(written()).NotTo(ContainSubstring(HTTPSMonitorName))
+			})
+		})
+
+		Context("route health checks", func() {
+			update := func(op routeUpdate.Operation, uri route.Uri, tags map[string]string) {
+				ep := makeEndpoint("127.0.0.1")
+				ep.Tags = tags
+				ru, err := NewUpdate(logger, op, uri, ep, "")
+				Expect(err).NotTo(HaveOccurred())
+				router.UpdateRoute(ru)
+			}
+
+			poolCount := func() int {
+				return len(mw.getResources("cf").Pools)
+			}
+
+			poolMonitor := func(uri string) string {
+				for _, pool := range mw.getResources("cf").Pools {
+					if pool.Name == makeObjectName(uri) {
+						Expect(pool.MonitorNames).To(HaveLen(2))
+						Expect(pool.MonitorNames[0]).To(Equal("/Common/tcp_half_open"))
+						return strings.TrimPrefix(pool.MonitorNames[1], "/cf/")
+					}
+				}
+				Fail("no pool for " + uri)
+				return ""
+			}
+
+			monitors := func() map[string]*bigipResources.Monitor {
+				ms := make(map[string]*bigipResources.Monitor)
+				for _, m := range mw.getResources("cf").Monitors {
+					ms[m.Name] = m
+				}
+				return ms
+			}
+
+			BeforeEach(func() {
+				c.BigIP.HTTPMonitor.Send = "GET /health HTTP/1.0\\r\\n\\r\\n"
+				c.BigIP.HTTPMonitor.Recv = "200 OK"
+			})
+
+			It("should create a monitor for each distinct health check", func() {
+				run()
+				update(routeUpdate.Add, "foo.cf.com", map[string]string{"healthcheck-path": "/status"})
+				update(routeUpdate.Add, "bar.cf.com", map[string]string{
+					"healthcheck-path":   "/ping",
+					"healthcheck-expect": "pong",
+				})
+				update(routeUpdate.Add, "baz.cf.com", map[string]string{"healthcheck-path": "/status"})
+				update(routeUpdate.Add, "qux.cf.com", nil)
+				Eventually(poolCount).Should(Equal(4))
+
+				fooMonitor := poolMonitor("foo.cf.com")
+				barMonitor := poolMonitor("bar.cf.com")
+				Expect(fooMonitor).To(HavePrefix(HTTPMonitorName + "-"))
+				Expect(barMonitor).To(HavePrefix(HTTPMonitorName + "-"))
+				Expect(fooMonitor).NotTo(Equal(barMonitor))
+				Expect(poolMonitor("baz.cf.com")).To(Equal(fooMonitor))
+				Expect(poolMonitor("qux.cf.com")).To(Equal(HTTPMonitorName))
+
+				ms := monitors()
+				Expect(ms).To(HaveLen(3))
+				Expect(ms).To(HaveKey(HTTPMonitorName))
+				Expect(ms[fooMonitor]).To(Equal(&bigipResources.Monitor{
+					Name:     fooMonitor,
+					Type:     "http",
+					Send:     "GET /status HTTP/1.0\\r\\n\\r\\n",
+					Recv:     "200 OK",
+					Interval: 5,
+					Timeout:  16,
+				}))
+				Expect(ms[barMonitor].Send).To(Equal("GET /ping HTTP/1.0\\r\\n\\r\\n"))
+				Expect(ms[barMonitor].Recv).To(Equal("pong"))
+
+				output := written()
+				Expect(strings.Count(output, `"name":"`+fooMonitor+`"`)).To(Equal(1))
+				Expect(strings.Count(output, `"name":"`+barMonitor+`"`)).To(Equal(1))
+
+				update(routeUpdate.Remove, "foo.cf.com", map[string]string{"healthcheck-path": "/status"})
+				Eventually(poolCount).Should(Equal(3))
+				Expect(monitors()).To(HaveKey(fooMonitor))
+				update(routeUpdate.Remove, "baz.cf.com", map[string]string{"healthcheck-path": "/status"})
+				Eventually(monitors).ShouldNot(HaveKey(fooMonitor))
+				Expect(monitors()).To(HaveKey(barMonitor))
+			})
+
+			It("should only replace the receive string with an expect tag", func() {
+				c.BigIP.HTTPMonitor.Send = ""
+				run()
+				update(routeUpdate.Add, "foo.cf.com", map[string]string{"healthcheck-expect": "UP"})
+				update(routeUpdate.Add, "bar.cf.com", nil)
+				Eventually(poolCount).Should(Equal(2))
+
+				ms := monitors()
+				Expect(ms).To(HaveLen(1))
+				m := ms[poolMonitor("foo.cf.com")]
+				Expect(m.Send).To(Equal("GET / HTTP/1.0\\r\\n\\r\\n"))
+				Expect(m.Recv).To(Equal("UP"))
+				for _, pool := range mw.getResources("cf").Pools {
+					if pool.Name == makeObjectName("bar.cf.com") {
+						Expect(pool.MonitorNames).To(Equal([]string{"/Common/tcp_half_open"}))
+					}
+				}
+			})
+
+			It("should use the configured tags", func() {
+				c.BigIP.HTTPMonitor.PathTag = "health"
+				c.BigIP.HTTPMonitor.ExpectTag = ""
+				run()
+				update(routeUpdate.Add, "foo.cf.com", map[string]string{
+					"health":             "/up",
+					"healthcheck-path":   "/status",
+					"healthcheck-expect": "pong",
+				})
+				Eventually(poolCount).Should(Equal(1))
+
+				m := monitors()[poolMonitor("foo.cf.com")]
+				Expect(m.Send).To(Equal("GET /up HTTP/1.0\\r\\n\\r\\n"))
+				Expect(m.Recv).To(Equal("200 OK"))
+			})
+
+			It("should skip invalid health check paths", func() {
+				run()
+				update(routeUpdate.Add, "foo.cf.com", map[string]string{"healthcheck-path": "status"})
+				update(routeUpdate.Add, "bar.cf.com", map[string]string{"healthcheck-path": "/a b"})
+				update(routeUpdate.Add, "baz.cf.com", map[string]string{"healthcheck-path": `/a\r\n`})
+				Eventually(poolCount).Should(Equal(3))
+
+				Expect(logger).To(Say(`skipping-route-healthcheck-path.*"healthcheck-path":"status"`))
+				for _, uri := range []string{"foo.cf.com", "bar.cf.com", "baz.cf.com"} {
+					Expect(poolMonitor(uri)).To(Equal(HTTPMonitorName))
+				}
+				Expect(monitors()).To(HaveLen(1))
+			})
+		})
+	})
+
+	Describe("fallback pool", func() {
+		var (
+			logger *test_util.TestZapLogger
+			c      *config.Config
+			router *F5Router
+			mw     *MockWriter
+			stop   func()
+		)
+
+		newRouter := func() error {
+			var err error
+			mw = &MockWriter{}
+			router, err = NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+			if nil != err {
+				return err
+			}
+			stop = runRouter(router)
+			return nil
+		}
+
+		addRoute := func(uri route.Uri) {
+			ru, err := NewUpdate(logger, routeUpdate.Add, uri, makeEndpoint("127.0.0.1"), "")
+			Expect(err).NotTo(HaveOccurred())
+			router.UpdateRoute(ru)
+		}
+
+		// virtuals returns the written virtuals by name
+		virtuals := func() map[string]*bigipResources.Virtual {
+			vs := make(map[string]*bigipResources.Virtual)
+			for _, v := range mw.getResources("cf").Virtuals {
+				vs[v.VirtualServerName] = v
+			}
+			return vs
+		}
+
+		fallbackIRule := func() *bigipResources.IRule {
+			for _, rule := range mw.getResources("cf").IRules {
+				if rule.Name == bigipResources.FallbackPoolIRuleName {
+					return rule
+				}
+			}
+			return nil
+		}
+
+		BeforeEach(func() {
+			logger = test_util.NewTestZapLogger("router-test")
+			c = makeConfig()
+			c.SessionPersistence = false
+			stop = func() {}
+		})
+
+		AfterEach(func() {
+			stop()
+			if nil != logger {
+				logger.Close()
+			}
+		})
+
+		It("should not fall back by default", func() {
+			Expect(newRouter()).To(Succeed())
+			addRoute("foo.cf.com")
+			Eventually(virtuals).Should(HaveKey(makeObjectName("foo.cf.com")))
+			Expect(virtuals()[makeObjectName("foo.cf.com")].IRules).To(BeEmpty())
+			Expect(fallbackIRule()).To(BeNil())
+		})
+
+		It("should send requests to the fallback pool when the route pool is down", func() {
+			c.BigIP.FallbackPool = "/Common/sorry-page"
+			Expect(newRouter()).To(Succeed())
+			addRoute("foo.cf.com")
+			addRoute("bar.cf.com/path")
+			Eventually(virtuals).Should(HaveKey(makeObjectName("bar.cf.com/path")))
+
+			vs := virtuals()
+			for _, uri := range []string{"foo.cf.com", "bar.cf.com/path"} {
+				Expect(vs[makeObjectName(uri)].IRules).To(Equal([]string{
+					"/cf/" + bigipResources.FallbackPoolIRuleName,
+				}))
+			}
+			rule := fallbackIRule()
+			Expect(rule).NotTo(BeNil())
+			Expect(rule.Code).To(ContainSubstring("[active_members [LB::server pool]] < 1"))
+			Expect(rule.Code).To(ContainSubstring(`pool "/Common/sorry-page"`))
+
+			// The routing virtuals forward to the route virtuals as before
+			Expect(vs[HTTPRouterName].IRules).NotTo(ContainElement(HaveSuffix(bigipResources.FallbackPoolIRuleName)))
+		})
+
+		It("should reject an invalid fallback pool", func() {
+			c.BigIP.FallbackPool = "sorry-page"
+			Expect(newRouter()).To(MatchError(
+				"invalid fallback_pool: skipped names: [sorry-page] need format /[partition]/[name]"))
+		})
+	})
+
+	Describe("default route", func() {
+		var (
+			logger *test_util.TestZapLogger
+			c      *config.Config
+			router *F5Router
+			mw     *MockWriter
+			stop   func()
+		)
+
+		newRouter := func() error {
+			var err error
+			mw = &MockWriter{}
+			router, err = NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+			if nil != err {
+				return err
+			}
+			stop = runRouter(router)
+			return nil
+		}
+
+		update := func(op routeUpdate.Operation, uri route.Uri, address string) {
+			ru, err := NewUpdate(logger, op, uri, makeEndpoint(address), "")
+			Expect(err).NotTo(HaveOccurred())
+			router.UpdateRoute(ru)
+		}
+
+		routingPool := func() string {
+			for _, vs := range mw.getResources("cf").Virtuals {
+				if vs.VirtualServerName == HTTPRouterName {
+					return vs.PoolName
+				}
+			}
+			return "missing routing virtual"
+		}
+
+		BeforeEach(func() {
+			logger = test_util.NewTestZapLogger("router-test")
+			c = makeConfig()
+			c.BigIP.DefaultRoute = "catch-all.cf.com"
+			stop = func() {}
+		})
+
+		AfterEach(func() {
+			stop()
+			if nil != logger {
+				logger.Close()
+			}
+		})
+
+		It("should use the pool of the default route on the routing virtuals", func() {
+			Expect(newRouter()).To(Succeed())
+			update(routeUpdate.Add, "foo.cf.com", "127.0.0.1")
+			update(routeUpdate.Add, "catch-all.cf.com", "127.0.0.2")
+
+			Eventually(routingPool).Should(Equal("/cf/" + makeObjectName("catch-all.cf.com")))
+			Eventually(logger).Should(Say(`"f5router-default-route-applied".*"route":"catch-all.cf.com"`))
+		})
+
+		It("should fall back to the default pool without the default route", func() {
+			c.BigIP.DefaultPool = "/Common/catch-all"
+			Expect(newRouter()).To(Succeed())
+			update(routeUpdate.Add, "foo.cf.com", "127.0.0.1")
+			Eventually(routingPool).Should(Equal("/Common/catch-all"))
+
+			update(routeUpdate.Add, "catch-all.cf.com", "127.0.0.2")
+			Eventually(routingPool).Should(Equal("/cf/" + makeObjectName("catch-all.cf.com")))
+
+			update(routeUpdate.Remove, "catch-all.cf.com", "127.0.0.2")
+			Eventually(routingPool).Should(Equal("/Common/catch-all"))
+			Eventually(logger).Should(Say(`"f5router-default-route-missing".*"pool":"/Common/catch-all"`))
+		})
+
+		It("should report a default route missing from the route set", func() {
+			c.BigIP.DefaultPool = "/Common/catch-all"
+			Expect(newRouter()).To(Succeed())
+			update(routeUpdate.Add, "foo.cf.com", "127.0.0.1")
+			Eventually(logger).Should(Say(
+				`"f5router-default-route-missing".*"route":"catch-all.cf.com","pool":"/Common/catch-all"`))
+			Eventually(routingPool).Should(Equal("/Common/catch-all"))
+
+			// Each update is checked against the route set
+			update(routeUpdate.Add, "catch-all.cf.com", "127.0.0.2")
+			Eventually(logger).Should(Say(`"f5router-default-route-applied".*"route":"catch-all.cf.com"`))
+			Eventually(routingPool).Should(Equal("/cf/" + makeObjectName("catch-all.cf.com")))
+		})
+
+		It("should leave the routing virtuals without a pool when nothing is configured", func() {
+			Expect(newRouter()).To(Succeed())
+			update(routeUpdate.Add, "catch-all.cf.com", "127.0.0.2")
+			update(routeUpdate.Remove, "catch-all.cf.com", "127.0.0.2")
+			Eventually(routingPool).Should(BeEmpty())
+		})
+
+		It("should reject an invalid default route", func() {
+			c.BigIP.DefaultRoute = "*.*.cf.com"
+			Expect(newRouter()).To(MatchError(ContainSubstring("invalid default_route")))
+
+			c.BigIP.DefaultRoute = "catch-all.cf.com"
+			c.RoutingMode = config.TCP
+			c.TCPRouterGroupName = "default-tcp"
+			Expect(newRouter()).To(MatchError("default_route requires HTTP routing"))
+
+			c.RoutingMode = config.HTTP
+			c.BigIP.DefaultPool = "catch-all"
+			Expect(newRouter()).To(MatchError(ContainSubstring("invalid default_pool for the default_route")))
+		})
+	})
+
+	Describe("route filter", func() {
+		var (
+			logger *test_util.TestZapLogger
+			c      *config.Config
+		)
+
+		// The hosts ranked by the rule sort
+		hosts := []route.Uri{
+			"*.cf.com",
+			"ser*.cf.com",
+			"baz.cf.com/segment1",
+			"*vic*.cf.com",
+			"foo.cf.com",
+			"*.foo.cf.com",
+			"baz.cf.com",
+			"ser*es.cf.com",
+			"bar.cf.com",
+			"*vices.cf.com",
+			"baz.cf.com/segment1/segment2/segment3",
+		}
+
+		managed := func() []string {
+			router, err := NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			var result []string
+			for _, uri := range hosts {
+				ru, err := NewUpdate(logger, routeUpdate.Add, uri, makeEndpoint("127.0.0.1"), "")
+				Expect(err).NotTo(HaveOccurred())
+				if router.routeManaged(ru) {
+					result = append(result, uri.String())
+				}
+			}
+			return result
+		}
+
+		BeforeEach(func() {
+			logger = test_util.NewTestZapLogger("router-test")
+			c = makeConfig()
+		})
+
+		AfterEach(func() {
+			if nil != logger {
+				logger.Close()
+			}
+		})
+
+		It("should manage every route by default", func() {
+			Expect(managed()).To(HaveLen(len(hosts)))
+		})
+
+		It("should only manage the included hosts", func() {
+			c.BigIP.RouteFilter.Include = []string{"*.foo.cf.com", "baz.cf.com"}
+			Expect(managed()).To(Equal([]string{
+				"baz.cf.com/segment1",
+				"*.foo.cf.com",
+				"baz.cf.com",
+				"baz.cf.com/segment1/segment2/segment3",
+			}))
+		})
+
+		It("should not manage the excluded hosts", func() {
+			c.BigIP.RouteFilter.Exclude = []string{"ser*", "ba?.cf.com"}
+			Expect(managed()).To(Equal([]string{
+				"*.cf.com",
+				"*vic*.cf.com",
+				"foo.cf.com",
+				"*.foo.cf.com",
+				"*vices.cf.com",
+			}))
+		})
+
+		It("should exclude hosts from the included ones", func() {
+			c.BigIP.RouteFilter.Include = []string{"*.cf.com"}
+			c.BigIP.RouteFilter.Exclude = []string{"*.foo.cf.com", "*vic*"}
+			Expect(managed()).To(Equal([]string{
+				"*.cf.com",
+				"ser*.cf.com",
+				"baz.cf.com/segment1",
+				"foo.cf.com",
+				"baz.cf.com",
+				"ser*es.cf.com",
+				"bar.cf.com",
+				"baz.cf.com/segment1/segment2/segment3",
+			}))
+		})
+
+		It("should ignore the updates of filtered routes", func() {
+			c.BigIP.RouteFilter.Include = []string{"*.foo.cf.com", "bar.cf.com"}
+			mw := &MockWriter{}
+			router, err := NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+			Expect(err).NotTo(HaveOccurred())
+			stop := runRouter(router)
+			defer stop()
+
+			var updates []routeUpdate.RouteUpdate
+			for _, uri := range hosts {
+				ru, err := NewUpdate(logger, routeUpdate.Add, uri, makeEndpoint("127.0.0.1"), "")
+				Expect(err).NotTo(HaveOccurred())
+				router.UpdateRoute(ru)
+				updates = append(updates, ru)
+			}
+			// TCP routes have no host and are always managed
+			tu, err := NewTCPUpdate(c, logger, routeUpdate.Add, 6010,
+				bigipResources.Member{Address: "10.0.0.1", Port: 5000})
+			Expect(err).NotTo(HaveOccurred())
+			router.UpdateRoute(tu)
+
+			pools := func() []string {
+				var names []string
+				for _, pool := range mw.getResources("cf").Pools {
+					names = append(names, pool.Name)
+				}
+				return names
+			}
+			Eventually(pools).Should(ConsistOf(
+				makeObjectName("*.foo.cf.com"),
+				makeObjectName("bar.cf.com"),
+				tu.Name(),
+			))
+			Expect(logger).To(Say(`"f5router-route-filtered".*"route":"\*.cf.com"`))
+
+			// The route set replaces the known routes, only the managed ones
+			// of the set are kept
+			router.SetRoutes(updates)
+			Eventually(logger).Should(Say(`"f5router-routes-set".*"added":0`))
+			Eventually(pools).Should(ConsistOf(
+				makeObjectName("*.foo.cf.com"),
+				makeObjectName("bar.cf.com"),
+			))
+		})
+
+		It("should validate the patterns", func() {
+			c.BigIP.RouteFilter.Include = []string{"[a-"}
+			_, err := NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).To(MatchError(ContainSubstring("invalid route_filter pattern [a-")))
+
+			c.BigIP.RouteFilter.Include = []string{"*.cf.com"}
+			c.BigIP.RouteFilter.Exclude = []string{""}
+			_, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).To(MatchError("invalid route_filter pattern: must not be empty"))
+
+			c.BigIP.RouteFilter.Exclude = []string{"catch-all.*"}
+			c.BigIP.DefaultRoute = "catch-all.cf.com"
+			_, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).To(MatchError("default_route catch-all.cf.com is excluded by the route_filter"))
+		})
+	})
+
+	Describe("stable output", func() {
+		var (
+			logger *test_util.TestZapLogger
+			c      *config.Config
+		)
+
+		type routeMember struct {
+			uri     route.Uri
+			address string
+			tags    map[string]string
+		}
+
+		routes := []routeMember{
+			{"foo.cf.com", "127.0.0.1", map[string]string{"healthcheck-path": "/up"}},
+			{"foo.cf.com", "127.0.0.2", map[string]string{"healthcheck-path": "/up"}},
+			{"foo.cf.com", "127.0.0.3", map[string]string{"healthcheck-path": "/up"}},
+			{"bar.cf.com", "127.0.0.2", map[string]string{"healthcheck-path": "/up"}},
+			{"bar.cf.com/path", "127.0.0.4", map[string]string{"healthcheck-expect": "pong"}},
+			{"baz.cf.com", "127.0.0.5", map[string]string{"partition": "cf-apps"}},
+			{"baz.cf.com", "127.0.0.1", map[string]string{"partition": "cf-apps"}},
+			{"*.cf.com", "127.0.0.6", nil},
+			{"*.apps.cf.com", "127.0.0.7", map[string]string{"weight": "3"}},
+		}
+
+		// written runs a router, adds the route members in the given order and
+		// returns the resources it writes once every member is added. The
+		// global section is left out, its generation depends on how the
+		// updates were batched.
+		written := func(order []int) []byte {
+			mw := &MockWriter{}
+			router, err := NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+			Expect(err).NotTo(HaveOccurred())
+			stop := runRouter(router)
+			defer stop()
+			for _, i := range order {
+				ep := makeEndpoint(routes[i].address)
+				for k, v := range routes[i].tags {
+					ep.Tags[k] = v
+				}
+				ru, err := NewUpdate(logger, routeUpdate.Add, routes[i].uri, ep, "")
+				Expect(err).NotTo(HaveOccurred())
+				router.UpdateRoute(ru)
+			}
+			Eventually(func() int {
+				members := 0
+				for _, rs := range mw.getInput().Resources {
+					for _, pool := range rs.Pools {
+						members += len(pool.Members)
+					}
+				}
+				return members
+			}).Should(Equal(len(routes)))
+
+			var cfg struct {
+				Resources json.RawMessage `json:"resources"`
+			}
+			Expect(json.Unmarshal(mw.getOutput(), &cfg)).To(Succeed())
+			return cfg.Resources
+		}
+
+		BeforeEach(func() {
+			logger = test_util.NewTestZapLogger("router-test")
+			c = makeConfig()
+			c.BigIP.Partitions = []string{"cf", "cf-apps"}
+			c.BigIP.PartitionTag = "partition"
+			c.BigIP.Profiles = []string{"/Common/http", "/Common/tcp"}
+			c.BigIP.HealthMonitors = []string{"/Common/tcp_half_open"}
+			c.BigIP.Metadata = []string{MetadataRouteKey}
+			c.BigIP.SharedNodes = true
+		})
+
+		AfterEach(func() {
+			if nil != logger {
+				logger.Close()
+			}
+		})
+
+		It("should write the same bytes for the same routes every time", func() {
+			order := make([]int, len(routes))
+			for i := range order {
+				order[i] = i
+			}
+			expected := written(order)
+			sum := sha256.Sum256(expected)
+			var pm bigipResources.PartitionMap
+			Expect(json.Unmarshal(expected, &pm)).To(Succeed())
+			Expect(pm["cf"].Monitors).To(HaveLen(1))
+			Expect(pm["cf"].Nodes).NotTo(BeEmpty())
+			Expect(pm["cf-apps"].Monitors).To(HaveLen(1))
+			Expect(pm["cf-apps"].Pools).To(HaveLen(4))
+
+			for i := 0; i < 5; i++ {
+				Expect(sha256.Sum256(written(order))).To(Equal(sum), "write %d", i)
+			}
+
+			// Routers which saw the same members in another order write the
+			// same config
+			for seed := int64(1); seed <= 10; seed++ {
+				order := rand.New(rand.NewSource(seed)).Perm(len(routes))
+				Expect(string(written(order))).To(Equal(string(expected)), "order %v", order)
+			}
+		})
+	})
+
+	Describe("shared nodes", func() {
+		var (
+			logger *test_util.TestZapLogger
+			c      *config.Config
+			router *F5Router
+			mw     *MockWriter
+			stop   func()
+		)
+
+		newRouter := func() {
+			var err error
+			mw = &MockWriter{}
+			router, err = NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+			Expect(err).NotTo(HaveOccurred())
+			stop = runRouter(router)
+		}
+
+		addRoute := func(uri route.Uri, address string) {
+			ru, err := NewUpdate(logger, routeUpdate.Add, uri, makeEndpoint(address), "")
+			Expect(err).NotTo(HaveOccurred())
+			router.UpdateRoute(ru)
+		}
+
+		// written waits for the number of pools and returns the resources
+		// last written
+		written := func(pools int) *bigipResources.Resources {
+			EventuallyWithOffset(1, func() []*bigipResources.Pool {
+				return mw.getResources("cf").Pools
+			}).Should(HaveLen(pools))
+			return mw.getResources("cf")
+		}
+
+		BeforeEach(func() {
+			logger = test_util.NewTestZapLogger("router-test")
+			c = makeConfig()
+			stop = func() {}
+		})
+
+		AfterEach(func() {
+			stop()
+			if nil != logger {
+				logger.Close()
+			}
+		})
+
+		It("should write the member addresses without nodes by default", func() {
+			newRouter()
+			addRoute("foo.cf.com", "127.0.0.1")
+			addRoute("bar.cf.com", "127.0.0.1")
+
+			rs := written(2)
+			Expect(rs.Nodes).To(BeEmpty())
+			for _, pool := range rs.Pools {
+				Expect(pool.Members).To(HaveLen(1))
+				Expect(pool.Members[0].Address).To(Equal("127.0.0.1"))
+				Expect(pool.Members[0].Node).To(BeEmpty())
+			}
+		})
+
+		It("should write a single node for an address shared by pools", func() {
+			c.BigIP.SharedNodes = true
+			newRouter()
+			addRoute("foo.cf.com", "127.0.0.1")
+			addRoute("bar.cf.com", "127.0.0.1")
+			addRoute("baz.cf.com", "127.0.0.2")
+
+			rs := written(3)
+			Expect(rs.Nodes).To(Equal([]*bigipResources.Node{
+				{Name: "127.0.0.1", Address: "127.0.0.1"},
+				{Name: "127.0.0.2", Address: "127.0.0.2"},
+			}))
+			var references []string
+			var first *bigipResources.Pool
+			for _, pool := range rs.Pools {
+				for _, member := range pool.Members {
+					Expect(member.Address).To(BeEmpty())
+					Expect(member.Port).To(Equal(uint16(80)))
+					if "127.0.0.1" == member.Node {
+						references = append(references, pool.Name)
+					}
+				}
+				if makeObjectName("foo.cf.com") == pool.Name {
+					first = pool
+				}
+			}
+			Expect(references).To(HaveLen(2))
+
+			// The router keeps the member addresses and writes the same pool
+			// with every later write
+			addRoute("qux.cf.com", "127.0.0.2")
+			rs = written(4)
+			Expect(rs.Nodes).To(HaveLen(2))
+			Expect(rs.Pools).To(ContainElement(Equal(first)))
+		})
+
+		It("should remove a node once no member references it", func() {
+			c.BigIP.SharedNodes = true
+			newRouter()
+			addRoute("foo.cf.com", "127.0.0.1")
+			addRoute("bar.cf.com", "127.0.0.2")
+			Expect(written(2).Nodes).To(HaveLen(2))
+
+			ru, err := NewUpdate(logger, routeUpdate.Remove, "bar.cf.com", makeEndpoint("127.0.0.2"), "")
+			Expect(err).NotTo(HaveOccurred())
+			router.UpdateRoute(ru)
+
+			Expect(written(1).Nodes).To(Equal([]*bigipResources.Node{
+				{Name: "127.0.0.1", Address: "127.0.0.1"},
+			}))
+		})
+
+		It("should leave FQDN members without a node", func() {
+			c.BigIP.SharedNodes = true
+			c.BigIP.FQDNMembers.Enabled = true
+			newRouter()
+			addRoute("foo.cf.com", "app.internal")
+
+			rs := written(1)
+			Expect(rs.Nodes).To(BeEmpty())
+			Expect(rs.Pools[0].Members[0].Address).To(Equal("app.internal"))
+		})
+	})
+
+	Describe("route persistence", func() {
+		var (
+			logger *test_util.TestZapLogger
+			c      *config.Config
+			mw     *MockWriter
+			router *F5Router
+			stop   func()
+		)
+
+		start := func() {
+			var err error
+			mw = &MockWriter{}
+			router, err = NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+			Expect(err).NotTo(HaveOccurred())
+			stop = runRouter(router)
+		}
+
+		addRoute := func(uri route.Uri, tags map[string]string) {
+			ep := makeEndpoint("127.0.0.1")
+			ep.Tags = tags
+			ru, err := NewUpdate(logger, routeUpdate.Add, uri, ep, "")
+			Expect(err).NotTo(HaveOccurred())
+			router.UpdateRoute(ru)
+		}
+
+		virtual := func(uri string) *bigipResources.Virtual {
+			for _, vs := range mw.getResources("cf").Virtuals {
+				if vs.VirtualServerName == makeObjectName(uri) {
+					return vs
+				}
+			}
+			return nil
+		}
+
+		routed := func(uri string) {
+			EventuallyWithOffset(1, func() *bigipResources.Virtual {
+				return virtual(uri)
+			}).ShouldNot(BeNil())
+		}
+
+		iRule := func(name string) string {
+			for _, rule := range mw.getResources("cf").IRules {
+				if rule.Name == name {
+					return rule.Code
+				}
+			}
+			return ""
+		}
+
+		written := func() string {
+			return string(mw.getOutput())
+		}
+
+		BeforeEach(func() {
+			logger = test_util.NewTestZapLogger("router-test")
+			c = makeConfig()
+			c.SessionPersistence = false
+			stop = func() {}
+		})
+
+		AfterEach(func() {
+			stop()
+			if nil != logger {
+				logger.Close()
+			}
+		})
+
+		It("should not persist sessions by default", func() {
+			start()
+			addRoute("foo.cf.com", nil)
+			routed("foo.cf.com")
+			Expect(virtual("foo.cf.com").Persistence).To(BeNil())
+			Expect(written()).NotTo(ContainSubstring(`"persist"`))
+		})
+
+		It("should attach the persistence profile of the configured type", func() {
+			start()
+			addRoute("foo.cf.com", nil)
+			routed("foo.cf.com")
+			without := written()
+			stop()
+
+			c.BigIP.Persistence.Type = config.PERSISTENCE_COOKIE
+			start()
+			addRoute("foo.cf.com", nil)
+			routed("foo.cf.com")
+			vs := virtual("foo.cf.com")
+			Expect(vs.Persistence).To(Equal([]*bigipResources.NameRef{{Name: "cookie", Partition: "Common"}}))
+			Expect(vs.IRules).To(BeEmpty())
+
+			output := written()
+			Expect(output).To(ContainSubstring(`"persist":[{"name":"cookie","partition":"Common"}]`))
+			Expect(output).NotTo(Equal(without))
+		})
+
+		It("should apply the cookie name and timeout with shared iRules", func() {
+			c.BigIP.Persistence.Type = config.PERSISTENCE_COOKIE
+			c.BigIP.Persistence.CookieName = "cf-sticky"
+			c.BigIP.Persistence.Timeout = 300
+			start()
+			addRoute("foo.cf.com", nil)
+			addRoute("bar.cf.com", map[string]string{"persistence": "source_addr"})
+			addRoute("baz.cf.com", map[string]string{"persistence": "none"})
+			routed("baz.cf.com")
+
+			Expect(virtual("foo.cf.com").IRules).To(Equal([]string{"/cf/cf-cookie-persistence"}))
+			Expect(virtual("bar.cf.com").Persistence).To(
+				Equal([]*bigipResources.NameRef{{Name: "source_addr", Partition: "Common"}}))
+			Expect(virtual("bar.cf.com").IRules).To(Equal([]string{"/cf/cf-source-addr-persistence"}))
+			Expect(virtual("baz.cf.com").Persistence).To(BeNil())
+			Expect(virtual("baz.cf.com").IRules).To(BeEmpty())
+
+			Expect(iRule(bigipResources.CookiePersistenceIRuleName)).To(
+				ContainSubstring(`persist cookie insert "cf-sticky" 0`))
+			Expect(iRule(bigipResources.SourceAddrPersistenceIRuleName)).To(
+				ContainSubstring("persist source_addr 255.255.255.255 300"))
+
+			output := written()
+			Expect(output).To(ContainSubstring(`"name":"cf-cookie-persistence"`))
+			Expect(output).To(ContainSubstring(`"persist":[{"name":"source_addr","partition":"Common"}]`))
+		})
+
+		It("should ignore route tags which are not persistence types", func() {
+			c.BigIP.Persistence.Type = config.PERSISTENCE_SOURCE_ADDR
+			start()
+			addRoute("foo.cf.com", map[string]string{"persistence": "sticky"})
+			routed("foo.cf.com")
+			Expect(virtual("foo.cf.com").Persistence).To(
+				Equal([]*bigipResources.NameRef{{Name: "source_addr", Partition: "Common"}}))
+			Eventually(logger).Should(Say("skipping-route-persistence"))
+		})
+
+		It("should let plan persistence replace the controller persistence", func() {
+			c.BigIP.Persistence.Type = config.PERSISTENCE_COOKIE
+			c.BigIP.Persistence.CookieName = "cf-sticky"
+			start()
+			router.AddPlans(map[string]planResources.Plan{
+				"plan1": planResources.Plan{
+					ID: "plan1",
+					VirtualServer: planResources.VirtualType{
+						Persistence: "/Common/ssl",
+					},
+				},
+			})
+			addRoute("foo.cf.com", nil)
+			routed("foo.cf.com")
+
+			ru, err := NewUpdate(logger, routeUpdate.Bind, "foo.cf.com", nil, "plan1")
+			Expect(err).NotTo(HaveOccurred())
+			router.UpdateRoute(ru)
+
+			Eventually(func() []*bigipResources.NameRef {
+				return virtual("foo.cf.com").Persistence
+			}).Should(Equal([]*bigipResources.NameRef{{Name: "ssl", Partition: "Common"}}))
+			Expect(virtual("foo.cf.com").IRules).NotTo(ContainElement("/cf/cf-cookie-persistence"))
+		})
+
+		It("should reject invalid persistence settings", func() {
+			c.BigIP.Persistence.Type = "sticky"
+			_, err := NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).To(MatchError(ContainSubstring("invalid persistence type sticky")))
+
+			c.BigIP.Persistence.Type = config.PERSISTENCE_COOKIE
+			c.BigIP.Persistence.CookieName = `cf"sticky`
+			_, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).To(MatchError(ContainSubstring("cookie_name")))
+
+			c.BigIP.Persistence.CookieName = ""
+			c.BigIP.Persistence.Timeout = -1
+			_, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).To(MatchError(ContainSubstring("timeout")))
+		})
+	})
+
+	Describe("token authentication", func() {
+		var (
+			logger    *test_util.TestZapLogger
+			c         *config.Config
+			client    *fakeClient.FakeClient
+			tokenFile string
+			tmpDir    string
+		)
+
+		BeforeEach(func() {
+			logger = test_util.NewTestZapLogger("router-test")
+			var err error
+			tmpDir, err = ioutil.TempDir("", "token")
+			Expect(err).NotTo(HaveOccurred())
+			tokenFile = filepath.Join(tmpDir, "token")
+
+			c = makeConfig()
+			c.BigIP.Token = &config.TokenConfig{File: tokenFile}
+			client = &fakeClient.FakeClient{}
+			client.GetWithTokenReturns([]byte("was not found"), nil)
+		})
+
+		AfterEach(func() {
+			if nil != logger {
+				logger.Close()
+			}
+			os.RemoveAll(tmpDir)
+		})
+
+		run := func(router *F5Router) (chan os.Signal, chan struct{}) {
+			signals := make(chan os.Signal)
+			ready := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				Expect(router.Run(signals, ready)).To(Succeed())
+				close(done)
+			}()
+			Eventually(ready).Should(BeClosed(), "timed out waiting for ready")
+			return signals, done
+		}
+
+		It("should require basic credentials or a token file", func() {
+			c.BigIP.Token = nil
+			c.BigIP.Pass = ""
+			_, err := NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).To(MatchError(ContainSubstring("user and pass or token file")))
+
+			c.BigIP.Token = &config.TokenConfig{}
+			_, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).To(MatchError("token file must have value"))
+
+			c.BigIP.Token = &config.TokenConfig{File: tokenFile, LoginProvider: "tmos"}
+			_, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).To(MatchError("token login_provider requires user and pass"))
+
+			c.BigIP.User = ""
+			c.BigIP.Token = &config.TokenConfig{File: tokenFile}
+			_, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should leave the password out of the driver config", func() {
+			Expect(ioutil.WriteFile(tokenFile, []byte("provided-token"), 0600)).To(Succeed())
+			mw := &MockWriter{}
+			router, err := NewF5Router(logger, c, mw, client)
+			Expect(err).NotTo(HaveOccurred())
+
+			signals, done := run(router)
+			output := string(mw.getOutput())
+			Expect(output).NotTo(ContainSubstring(`"password":"pass"`))
+			Expect(output).To(ContainSubstring(`"token":{"file":"` + tokenFile + `"}`))
+			// The router itself keeps the password for logging in
+			Expect(c.BigIP.Pass).To(Equal("pass"))
+
+			signals <- MockSignal(123)
+			Eventually(done).Should(BeClosed(), "timed out waiting for Run to complete")
+		})
+
+		It("should read the BIG-IP with the provided token", func() {
+			Expect(ioutil.WriteFile(tokenFile, []byte("provided-token\n"), 0600)).To(Succeed())
+			router, err := NewF5Router(logger, c, &MockWriter{}, client)
+			Expect(err).NotTo(HaveOccurred())
+
+			signals, done := run(router)
+			Expect(client.LoginCallCount()).To(Equal(0))
+			Expect(client.GetCallCount()).To(Equal(0))
+			Expect(client.GetWithTokenCallCount()).To(Equal(1))
+			url, token := client.GetWithTokenArgsForCall(0)
+			Expect(url).To(HaveSuffix("/data-group/internal/~cf~" + InternalDataGroupName))
+			Expect(token).To(Equal("provided-token"))
+
+			signals <- MockSignal(123)
+			Eventually(done).Should(BeClosed(), "timed out waiting for Run to complete")
+		})
+
+		It("should warn about connection mirroring without a peer device", func() {
+			Expect(ioutil.WriteFile(tokenFile, []byte("provided-token\n"), 0600)).To(Succeed())
+			c.BigIP.ConnectionMirroring = true
+			client.GetWithTokenReturnsOnCall(0, []byte(`{"items":[{"name":"bigip1"}]}`), nil)
+			mw := &MockWriter{}
+			router, err := NewF5Router(logger, c, mw, client)
+			Expect(err).NotTo(HaveOccurred())
+
+			signals, done := run(router)
+			url, _ := client.GetWithTokenArgsForCall(0)
+			Expect(url).To(Equal("http://example.com/mgmt/tm/cm/device"))
+			Eventually(logger).Should(Say(`"f5router-connection-mirroring-single-device".*"devices":1`))
+			// Mirroring is still written on a single device
+			ru, err := NewUpdate(logger, routeUpdate.Add, "foo.cf.com", makeEndpoint("127.0.0.1"), "")
+			Expect(err).NotTo(HaveOccurred())
+			router.UpdateRoute(ru)
+			Eventually(func() bool {
+				for _, vs := range mw.getResources("cf").Virtuals {
+					if vs.VirtualServerName == HTTPRouterName {
+						return vs.ConnectionMirroring
+					}
+				}
+				return false
+			}).Should(BeTrue())
+
+			signals <- MockSignal(123)
+			Eventually(done).Should(BeClosed(), "timed out waiting for Run to complete")
+		})
+
+		It("should log in for the token and keep refreshing it", func() {
+			c.BigIP.Token.LoginProvider = "tmos"
+			client.LoginReturns(bigipclient.Token{Token: "token-1", Timeout: 100 * time.Millisecond}, nil)
+			client.LoginReturnsOnCall(1, bigipclient.Token{}, errors.New("login refused"))
+			router, err := NewF5Router(logger, c, &MockWriter{}, client)
+			Expect(err).NotTo(HaveOccurred())
+
+			signals, done := run(router)
+			url, user, pass, provider := client.LoginArgsForCall(0)
+			Expect(url).To(Equal("http://example.com/mgmt/shared/authn/login"))
+			Expect(user).To(Equal("admin"))
+			Expect(pass).To(Equal("pass"))
+			Expect(provider).To(Equal("tmos"))
+			Expect(ioutil.ReadFile(tokenFile)).To(Equal([]byte("token-1")))
+			_, token := client.GetWithTokenArgsForCall(0)
+			Expect(token).To(Equal("token-1"))
+
+			// The refresh runs at half the token timeout
+			Eventually(client.LoginCallCount).Should(BeNumerically(">=", 2))
+			Eventually(logger).Should(Say(`"f5router-token-refresh-error".*login refused`))
+
+			signals <- MockSignal(123)
+			Eventually(done).Should(BeClosed(), "timed out waiting for Run to complete")
+		})
+
+		It("should fail to start when the login fails", func() {
+			c.BigIP.Token.LoginProvider = "tmos"
+			client.LoginReturns(bigipclient.Token{}, errors.New("login refused"))
+			router, err := NewF5Router(logger, c, &MockWriter{}, client)
+			Expect(err).NotTo(HaveOccurred())
+
+			ready := make(chan struct{})
+			err = router.Run(make(chan os.Signal), ready)
+			Expect(err).To(MatchError(ContainSubstring("login refused")))
+			Expect(ready).NotTo(BeClosed())
+			Expect(client.GetWithTokenCallCount()).To(Equal(0))
+		})
+	})
+
+	Describe("credential sources", func() {
+		var (
+			logger *test_util.TestZapLogger
+			c      *config.Config
+			tmpDir string
+		)
+
+		BeforeEach(func() {
+			logger = test_util.NewTestZapLogger("router-test")
+			var err error
+			tmpDir, err = ioutil.TempDir("", "credentials")
+			Expect(err).NotTo(HaveOccurred())
+
+			c = makeConfig()
+			c.BigIP.User = ""
+			c.BigIP.Pass = ""
+		})
+
+		AfterEach(func() {
+			if nil != logger {
+				logger.Close()
+			}
+			os.RemoveAll(tmpDir)
+			os.Unsetenv("CF_BIGIP_CTLR_TEST_USER")
+			os.Unsetenv("CF_BIGIP_CTLR_TEST_PASS")
+		})
+
+		// driverCredentials returns the credentials in the initial config
+		// written for the driver
+		driverCredentials := func() (string, string) {
+			mw := &MockWriter{}
+			router, err := NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+			Expect(err).NotTo(HaveOccurred())
+			stop := runRouter(router)
+			defer stop()
+			output := mw.getOutput()
+			var cfg struct {
+				BigIP struct {
+					User string `json:"username"`
+					Pass string `json:"password"`
+				} `json:"bigip"`
+			}
+			Expect(json.Unmarshal(output, &cfg)).To(Succeed())
+			return cfg.BigIP.User, cfg.BigIP.Pass
+		}
+
+		It("should read the credentials from files", func() {
+			c.BigIP.UserFile = filepath.Join(tmpDir, "user")
+			c.BigIP.PassFile = filepath.Join(tmpDir, "pass")
+			Expect(ioutil.WriteFile(c.BigIP.UserFile, []byte("file-admin\n"), 0600)).To(Succeed())
+			Expect(ioutil.WriteFile(c.BigIP.PassFile, []byte("file pass\r\n"), 0600)).To(Succeed())
+
+			user, pass := driverCredentials()
+			Expect(user).To(Equal("file-admin"))
+			Expect(pass).To(Equal("file pass"))
+		})
+
+		It("should read the credentials from environment variables", func() {
+			os.Setenv("CF_BIGIP_CTLR_TEST_USER", "env-admin")
+			os.Setenv("CF_BIGIP_CTLR_TEST_PASS", "env-pass")
+			c.BigIP.UserEnv = "CF_BIGIP_CTLR_TEST_USER"
+			c.BigIP.PassEnv = "CF_BIGIP_CTLR_TEST_PASS"
+
+			user, pass := driverCredentials()
+			Expect(user).To(Equal("env-admin"))
+			Expect(pass).To(Equal("env-pass"))
+		})
+
+		It("should prefer the inline credentials", func() {
+			os.Setenv("CF_BIGIP_CTLR_TEST_PASS", "env-pass")
+			c.BigIP.User = "admin"
+			c.BigIP.Pass = "pass"
+			c.BigIP.UserFile = filepath.Join(tmpDir, "missing")
+			c.BigIP.PassEnv = "CF_BIGIP_CTLR_TEST_PASS"
+
+			user, pass := driverCredentials()
+			Expect(user).To(Equal("admin"))
+			Expect(pass).To(Equal("pass"))
+		})
+
+		It("should mix the credential sources", func() {
+			os.Setenv("CF_BIGIP_CTLR_TEST_PASS", "env-pass")
+			c.BigIP.User = "admin"
+			c.BigIP.PassEnv = "CF_BIGIP_CTLR_TEST_PASS"
+
+			user, pass := driverCredentials()
+			Expect(user).To(Equal("admin"))
+			Expect(pass).To(Equal("env-pass"))
+		})
+
+		It("should reject credential sources yielding no credentials", func() {
+			c.BigIP.User = "admin"
+			c.BigIP.PassFile = filepath.Join(tmpDir, "pass")
+			_, err := NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).To(MatchError(ContainSubstring("could not read pass_file")))
+
+			Expect(ioutil.WriteFile(c.BigIP.PassFile, []byte("\n"), 0600)).To(Succeed())
+			_, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).To(MatchError("pass_file is empty: " + c.BigIP.PassFile))
+
+			c.BigIP.PassFile = ""
+			c.BigIP.PassEnv = "CF_BIGIP_CTLR_TEST_PASS"
+			_, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).To(MatchError("pass_env variable is not set: CF_BIGIP_CTLR_TEST_PASS"))
+
+			c.BigIP.PassEnv = ""
+			_, err = NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).To(MatchError(ContainSubstring("user and pass or token file")))
+		})
+
+		It("should reject a credential set from both a file and a variable", func() {
+			os.Setenv("CF_BIGIP_CTLR_TEST_USER", "env-admin")
+			c.BigIP.UserFile = filepath.Join(tmpDir, "user")
+			Expect(ioutil.WriteFile(c.BigIP.UserFile, []byte("file-admin"), 0600)).To(Succeed())
+			c.BigIP.UserEnv = "CF_BIGIP_CTLR_TEST_USER"
+			c.BigIP.Pass = "pass"
+			_, err := NewF5Router(logger, c, &MockWriter{}, nil)
+			Expect(err).To(MatchError("only one of user_file and user_env may be set"))
+		})
+	})
+
+	Describe("tcpUpdate", func() {
+		It("should create a TCP virtual on the route port and a pool of the backends", func() {
+			logger := test_util.NewTestZapLogger("router-test")
+			defer logger.Close()
+			c := makeConfig()
+			c.RoutingMode = config.TCP
+			c.TCPRouterGroupName = "default-tcp"
+
+			member := bigipResources.Member{Address: "10.0.0.1", Port: 5000, Session: "user-enabled"}
+			tu, err := NewTCPUpdate(c, logger, routeUpdate.Add, 6010, member)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(tu.Name()).To(Equal("cf-tcp-route-default-tcp-6010"))
+			Expect(tu.Route()).To(Equal("6010"))
+			Expect(tu.Protocol()).To(Equal("tcp"))
+
+			rs, err := tu.CreateResources(c)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rs.Pools).To(Equal([]*bigipResources.Pool{{
+				Name:        "cf-tcp-route-default-tcp-6010",
+				Balance:     "round-robin",
+				Members:     []bigipResources.Member{member},
+				Description: "route-port: 6010, router-group: default-tcp",
+			}}))
+			Expect(rs.Virtuals).To(Equal([]*bigipResources.Virtual{{
+				VirtualServerName: "cf-tcp-route-default-tcp-6010",
+				PoolName:          "/cf/cf-tcp-route-default-tcp-6010",
+				Mode:              "tcp",
+				Enabled:           true,
+				Destination:       "/cf/127.0.0.1:6010",
+				Profiles: []*bigipResources.ProfileRef{{
+					Name:      "tcp",
+					Partition: "Common",
+					Context:   "all",
+				}},
+				SourceAddrTranslation: bigipResources.SourceAddrTranslation{Type: "automap"},
+			}}))
+		})
+	})
+
+	Describe("httpUpdate", func() {
+		var httpUpdate updateHTTP
+		Context("UpdateResources", func() {
+			var oldResources bigipResources.Resources
+			var newResources bigipResources.Resources
+
+			BeforeEach(func() {
+				httpUpdate = updateHTTP{}
+				policies := []*bigipResources.NameRef{&bigipResources.NameRef{
+					Name:      "test-policy",
+					Partition: "test",
+				}}
+				profiles := []*bigipResources.ProfileRef{&bigipResources.ProfileRef{
+					Name:      "test-profile",
+					Partition: "test",
+					Context:   "all",
+				}}
+				oldResources.Virtuals = []*bigipResources.Virtual{&bigipResources.Virtual{
+					VirtualServerName: "test-route-virtual",
+					PoolName:          "test-route-pool",
+					Profiles:          profiles,
+					Policies:          policies,
+				}}
+				oldResources.Pools = []*bigipResources.Pool{&bigipResources.Pool{
+					Name:         "test-route-pool",
+					Balance:      "round-robin",
+					MonitorNames: []string{"monitor1", "monitor2"},
+				}}
+				oldResources.Monitors = []*bigipResources.Monitor{&bigipResources.Monitor{
+					Name: "test-route-monitor",
+					Type: "http",
+				}}
+				newResources = bigipResources.Resources{}
+			})
+
+			It("should not update", func() {
+				updatedResources := httpUpdate.UpdateResources(oldResources, newResources)
+				Expect(updatedResources).To(Equal(oldResources))
+			})
+
+			It("should update virtuals profiles and policies only", func() {
+				newPolicies := []*bigipResources.NameRef{&bigipResources.NameRef{
+					Name:      "new-test-policy",
+					Partition: "test",
+				}}
+				newProfiles := []*bigipResources.ProfileRef{&bigipResources.ProfileRef{
+					Name:      "new-test-profile",
+					Partition: "test",
+					Context:   "clientside",
+				}}
+				newResources.Virtuals = []*bigipResources.Virtual{&bigipResources.Virtual{
+					VirtualServerName: "update-route-virtual",
+					PoolName:          "update-route-pool",
+					Profiles:          newProfiles,
+					Policies:          newPolicies,
+				}}
+				updatedResources := httpUpdate.UpdateResources(oldResources, newResources)
+
+				Expect(len(updatedResources.Virtuals)).To(Equal(1))
+				Expect(updatedResources.Virtuals[0].VirtualServerName).To(Equal(oldResources.Virtuals[0].VirtualServerName))
+				Expect(updatedResources.Virtuals[0].PoolName).To(Equal(oldResources.Virtuals[0].PoolName))
+				Expect(updatedResources.Virtuals[0].Profiles).To(Equal(newProfiles))
+				Expect(updatedResources.Virtuals[0].Policies).To(Equal(newPolicies))
+				Expect(len(updatedResources.Virtuals[0].Profiles)).To(Equal(1))
+				Expect(len(updatedResources.Virtuals[0].Policies)).To(Equal(1))
+
+				Expect(len(updatedResources.Pools)).To(Equal(1))
+				Expect(len(updatedResources.Monitors)).To(Equal(1))
+				Expect(updatedResources.Pools[0]).To(Equal(oldResources.Pools[0]))
+				Expect(updatedResources.Monitors[0]).To(Equal(oldResources.Monitors[0]))
+			})
+
+			It("should update pools balance and monitor names only", func() {
+				newResources.Pools = []*bigipResources.Pool{&bigipResources.Pool{
+					Name:         "update-route-pool",
+					Balance:      "fastest-node",
+					MonitorNames: []string{"monitor3"},
+				}}
+				updatedResources := httpUpdate.UpdateResources(oldResources, newResources)
+
+				Expect(len(updatedResources.Pools)).To(Equal(1))
+				Expect(updatedResources.Pools[0].Name).To(Equal(oldResources.Pools[0].Name))
+				Expect(updatedResources.Pools[0].Balance).To(Equal(newResources.Pools[0].Balance))
+				Expect(updatedResources.Pools[0].MonitorNames).To(Equal(newResources.Pools[0].MonitorNames))
+				Expect(len(updatedResources.Pools[0].MonitorNames)).To(Equal(1))
+
+				Expect(len(updatedResources.Virtuals)).To(Equal(1))
+				Expect(len(updatedResources.Monitors)).To(Equal(1))
+				Expect(updatedResources.Virtuals[0]).To(Equal(oldResources.Virtuals[0]))
+				Expect(updatedResources.Monitors[0]).To(Equal(oldResources.Monitors[0]))
+			})
+
+			It("should update monitors", func() {
+				newResources.Monitors = []*bigipResources.Monitor{&bigipResources.Monitor{
+					Name: "update-route-monitor",
+					Type: "tcp",
+				}}
+				updatedResources := httpUpdate.UpdateResources(oldResources, newResources)
+
+				Expect(len(updatedResources.Virtuals)).To(Equal(1))
+				Expect(len(updatedResources.Pools)).To(Equal(1))
+				Expect(len(updatedResources.Monitors)).To(Equal(1))
+				Expect(updatedResources.Virtuals[0]).To(Equal(oldResources.Virtuals[0]))
+				Expect(updatedResources.Pools[0]).To(Equal(oldResources.Pools[0]))
+				Expect(updatedResources.Monitors[0]).To(Equal(newResources.Monitors[0]))
+			})
+		})
+
+		Context("metadata", func() {
+			var c *config.Config
+			var ep *route.Endpoint
+			var logger *test_util.TestZapLogger
+
+			BeforeEach(func() {
+				logger = test_util.NewTestZapLogger("metadata-test")
+				c = makeConfig()
+				ep = makeEndpoint("127.0.0.1")
+				ep.Tags = map[string]string{
+					"organization_name": "test-org",
+					"space_name":        "test-space",
+					"app_name":          "test-app",
+					"route_guid":        "test-route-guid",
+				}
+			})
+
+			AfterEach(func() {
+				if nil != logger {
+					logger.Close()
+				}
+			})
+
+			It("should not add metadata when disabled", func() {
+				ru, err := NewUpdate(logger, routeUpdate.Add, "foo.cf.com", ep, "")
+				Expect(err).NotTo(HaveOccurred())
+				rs, err := ru.CreateResources(c)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(rs.Virtuals[0].Metadata).To(BeNil())
+				Expect(rs.Pools[0].Metadata).To(BeNil())
+
+				js, err := json.Marshal(rs)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(js)).NotTo(ContainSubstring("metadata"))
+			})
+
+			It("should add the configured metadata to the pool and virtual", func() {
+				c.BigIP.Metadata = []string{
+					"organization_name",
+					"space_name",
+					"app_name",
+					MetadataAppGUIDKey,
+					MetadataRouteKey,
+					MetadataRouteURIKey,
+					"not-a-tag",
+				}
+				ru, err := NewUpdate(logger, routeUpdate.Add, "foo.cf.com", ep, "")
+				Expect(err).NotTo(HaveOccurred())
+				rs, err := ru.CreateResources(c)
+				Expect(err).NotTo(HaveOccurred())
+
+				expected := []*bigipResources.Metadata{
+					{Name: "organization_name", Value: "test-org"},
+					{Name: "space_name", Value: "test-space"},
+					{Name: "app_name", Value: "test-app"},
+					{Name: "app_guid", Value: "1"},
+					{Name: "route", Value: "test-route-guid"},
+					{Name: "route_uri", Value: "foo.cf.com"},
+				}
+				Expect(rs.Virtuals[0].Metadata).To(Equal(expected))
+				Expect(rs.Pools[0].Metadata).To(Equal(expected))
+
+				js, err := json.Marshal(rs.Pools[0].Metadata[0])
+				Expect(err).NotTo(HaveOccurred())
+				Expect(js).To(MatchJSON(`{"name":"organization_name","value":"test-org"}`))
+			})
+
+			It("should only add the route URI without an endpoint", func() {
+				metadata := makeMetadata([]string{"app_name", MetadataAppGUIDKey, MetadataRouteKey, MetadataRouteURIKey}, "foo.cf.com", nil)
+				Expect(metadata).To(Equal([]*bigipResources.Metadata{
+					{Name: "route_uri", Value: "foo.cf.com"},
+				}))
+			})
+
+			It("should not add the route GUID of endpoints without it", func() {
+				delete(ep.Tags, RouteGUIDTag)
+				metadata := makeMetadata([]string{MetadataRouteKey, MetadataRouteURIKey}, "foo.cf.com", ep)
+				Expect(metadata).To(Equal([]*bigipResources.Metadata{
+					{Name: "route_uri", Value: "foo.cf.com"},
+				}))
+			})
+		})
+
+		Context("waf policy", func() {
+			var c *config.Config
+			var logger *test_util.TestZapLogger
+			var stops []func()
+
+			wafRef := &bigipResources.NameRef{Name: "waf", Partition: "Common"}
+
+			tagged := func(address, policy string) *route.Endpoint {
+				ep := makeEndpoint(address)
+				if policy != "" {
+					ep.Tags = map[string]string{"waf_policy": policy}
+				}
+				return ep
+			}
+
+			createVirtual := func(ep *route.Endpoint) *bigipResources.Virtual {
+				ru, err := NewUpdate(logger, routeUpdate.Add, "foo.cf.com", ep, "")
+				Expect(err).NotTo(HaveOccurred())
+				rs, err := ru.CreateResources(c)
+				Expect(err).NotTo(HaveOccurred())
+				return rs.Virtuals[0]
+			}
+
+			start := func(router *F5Router) {
+				stops = append(stops, runRouter(router))
+			}
+
+			newRouter := func(mw *MockWriter) *F5Router {
+				router, err := NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+				Expect(err).NotTo(HaveOccurred())
+				return router
+			}
+
+			update := func(router *F5Router, op routeUpdate.Operation, ep *route.Endpoint, planID string) {
+				ru, err := NewUpdate(logger, op, "foo.cf.com", ep, planID)
+				Expect(err).NotTo(HaveOccurred())
+				router.UpdateRoute(ru)
+			}
+
+			// checksum runs a new router with the route and returns the
+			// checksum of the config it writes
+			checksum := func() [sha256.Size]byte {
+				mw := &MockWriter{}
+				router := newRouter(mw)
+				start(router)
+				update(router, routeUpdate.Add, tagged("127.0.0.1", ""), "")
+				Eventually(func() []*bigipResources.Pool {
+					return mw.getResources("cf").Pools
+				}).Should(HaveLen(1))
+				return sha256.Sum256(mw.getOutput())
+			}
+
+			BeforeEach(func() {
+				logger = test_util.NewTestZapLogger("waf-test")
+				c = makeConfig()
+				stops = nil
+			})
+
+			AfterEach(func() {
+				for _, stop := range stops {
+					stop()
+				}
+				if nil != logger {
+					logger.Close()
+				}
+			})
+
+			It("should not attach a policy when disabled", func() {
+				vs := createVirtual(tagged("127.0.0.1", ""))
+				Expect(vs.Policies).To(BeNil())
+
+				c.BigIP.WAF.Policy = "/Common/waf"
+				vs = createVirtual(tagged("127.0.0.1", ""))
+				Expect(vs.Policies).To(BeNil())
+
+				js, err := json.Marshal(vs)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(js)).NotTo(ContainSubstring("policies"))
+			})
+
+			It("should attach the configured policy to the route virtual", func() {
+				c.BigIP.WAF.Enabled = true
+				c.BigIP.WAF.Policy = "/Common/waf"
+				vs := createVirtual(tagged("127.0.0.1", ""))
+				Expect(vs.Policies).To(Equal([]*bigipResources.NameRef{wafRef}))
+
+				js, err := json.Marshal(vs)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(js)).To(ContainSubstring(`"policies":[{"name":"waf","partition":"Common"}]`))
+			})
+
+			It("should override the policy with the route tag", func() {
+				c.BigIP.WAF.Enabled = true
+				c.BigIP.WAF.Policy = "/Common/waf"
+				vs := createVirtual(tagged("127.0.0.1", "/cf/strict-waf"))
+				Expect(vs.Policies).To(Equal([]*bigipResources.NameRef{
+					{Name: "strict-waf", Partition: "cf"},
+				}))
+
+				vs = createVirtual(tagged("127.0.0.1", "none"))
+				Expect(vs.Policies).To(BeNil())
+
+				vs = createVirtual(tagged("127.0.0.1", "strict-waf"))
+				Expect(vs.Policies).To(Equal([]*bigipResources.NameRef{wafRef}))
+				Eventually(logger).Should(Say("skipping-route-waf-policy"))
+
+				c.BigIP.WAF.Enabled = false
+				vs = createVirtual(tagged("127.0.0.1", "/Common/waf"))
+				Expect(vs.Policies).To(Equal([]*bigipResources.NameRef{wafRef}))
+			})
+
+			It("should keep the policy after the plan policies when a plan is bound", func() {
+				c.BigIP.WAF.Enabled = true
+				c.BigIP.WAF.Policy = "/Common/waf"
+				mw := &MockWriter{}
+				router := newRouter(mw)
+				router.AddPlans(map[string]planResources.Plan{
+					"plan": planResources.Plan{
+						ID: "plan",
+						VirtualServer: planResources.VirtualType{
+							Policies: []string{"/Common/plan-policy"},
+						},
+					},
+				})
+				start(router)
+				policies := func() []*bigipResources.NameRef {
+					for _, vs := range mw.getResources("cf").Virtuals {
+						if vs.VirtualServerName == makeObjectName("foo.cf.com") {
+							return vs.Policies
+						}
+					}
+					return nil
+				}
+
+				update(router, routeUpdate.Add, tagged("127.0.0.1", ""), "")
+				update(router, routeUpdate.Bind, nil, "plan")
+				Eventually(policies).Should(Equal([]*bigipResources.NameRef{
+					{Name: "plan-policy", Partition: "Common"},
+					wafRef,
+				}))
+
+				update(router, routeUpdate.Unbind, nil, "plan")
+				Eventually(policies).Should(Equal([]*bigipResources.NameRef{wafRef}))
+			})
+
+			It("should only change the config checksum when configured", func() {
+				baseline := checksum()
+
+				c.BigIP.WAF.Policy = "/Common/waf"
+				Expect(checksum()).To(Equal(baseline))
+
+				c.BigIP.WAF.Enabled = true
+				enabled := checksum()
+				Expect(enabled).NotTo(Equal(baseline))
+				Expect(checksum()).To(Equal(enabled))
+			})
+		})
+
+		Context("re-encryption", func() {
+			var c *config.Config
+			var logger *test_util.TestZapLogger
+
+			serverSSL := &bigipResources.ProfileRef{
+				Name:      "serverssl",
+				Partition: "Common",
+				Context:   "serverside",
+			}
+
+			backend := func(port uint16, tls string) *route.Endpoint {
+				ep := makeEndpoint("127.0.0.1")
+				ep.Port = port
+				if tls != "" {
+					ep.Tags = map[string]string{"tls": tls}
+				}
+				return ep
+			}
+
+			createVirtual := func(ep *route.Endpoint) *bigipResources.Virtual {
+				ru, err := NewUpdate(logger, routeUpdate.Add, "foo.cf.com", ep, "")
+				Expect(err).NotTo(HaveOccurred())
+				rs, err := ru.CreateResources(c)
+				Expect(err).NotTo(HaveOccurred())
+				return rs.Virtuals[0]
+			}
+
+			BeforeEach(func() {
+				logger = test_util.NewTestZapLogger("reencrypt-test")
+				c = makeConfig()
+				c.BigIP.Reencrypt.ServerSSL = "/Common/serverssl"
+			})
+
+			AfterEach(func() {
+				if nil != logger {
+					logger.Close()
+				}
+			})
+
+			It("should not re-encrypt when disabled", func() {
+				vs := createVirtual(backend(443, "true"))
+				Expect(vs.Profiles).NotTo(ContainElement(serverSSL))
+				Expect(vs.ServerSSL).To(BeNil())
+			})
+
+			It("should attach the server SSL profile for TLS backends only", func() {
+				c.BigIP.Reencrypt.Enabled = true
+				vs := createVirtual(backend(443, ""))
+				Expect(vs.Profiles).To(Equal(append(defaultProfiles(c), serverSSL)))
+
+				js, err := json.Marshal(vs)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(js)).To(ContainSubstring(
+					`{"name":"tcp","partition":"Common","context":"all"},` +
+						`{"name":"serverssl","partition":"Common","context":"serverside"}]`))
+
+				vs = createVirtual(backend(8443, "true"))
+				Expect(vs.Profiles).To(ContainElement(serverSSL))
+
+				vs = createVirtual(backend(80, ""))
+				Expect(vs.Profiles).To(Equal(defaultProfiles(c)))
+				js, err = json.Marshal(vs)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(js)).NotTo(ContainSubstring("serverssl"))
+
+				vs = createVirtual(backend(443, "false"))
+				Expect(vs.Profiles).NotTo(ContainElement(serverSSL))
+
+				vs = createVirtual(backend(443, "yes please"))
+				Expect(vs.Profiles).To(ContainElement(serverSSL))
+				Eventually(logger).Should(Say("skipping-route-tls"))
+			})
+
+			It("should keep the server SSL profile when a plan replaces the profiles", func() {
+				c.BigIP.Reencrypt.Enabled = true
+				mw := &MockWriter{}
+				router, err := NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+				Expect(err).NotTo(HaveOccurred())
+				router.AddPlans(map[string]planResources.Plan{
+					"plan": planResources.Plan{
+						ID: "plan",
+						VirtualServer: planResources.VirtualType{
+							Profiles: []string{"/Common/http2"},
+						},
+					},
+				})
+				stop := runRouter(router)
+				defer stop()
+
+				for _, ru := range []struct {
+					op     routeUpdate.Operation
+					ep     *route.Endpoint
+					planID string
+				}{
+					{routeUpdate.Add, backend(443, ""), ""},
+					{routeUpdate.Bind, nil, "plan"},
+				} {
+					u, err := NewUpdate(logger, ru.op, "foo.cf.com", ru.ep, ru.planID)
+					Expect(err).NotTo(HaveOccurred())
+					router.UpdateRoute(u)
+				}
+				profiles := func() []*bigipResources.ProfileRef {
+					for _, vs := range mw.getResources("cf").Virtuals {
+						if vs.VirtualServerName == makeObjectName("foo.cf.com") {
+							return vs.Profiles
+						}
+					}
+					return nil
+				}
+				Eventually(profiles).Should(ContainElement(
+					&bigipResources.ProfileRef{Name: "http2", Partition: "Common", Context: "all"}))
+				Expect(profiles()).To(ContainElement(serverSSL))
+			})
+		})
+
+		Context("priority groups", func() {
+			var c *config.Config
+			var logger *test_util.TestZapLogger
+
+			grouped := func(address, group string) *route.Endpoint {
+				ep := makeEndpoint(address)
+				if group != "" {
+					ep.Tags = map[string]string{"priority_group": group}
+				}
+				return ep
+			}
+
+			createPool := func(ep *route.Endpoint) *bigipResources.Pool {
+				ru, err := NewUpdate(logger, routeUpdate.Add, "foo.cf.com", ep, "")
+				Expect(err).NotTo(HaveOccurred())
+				rs, err := ru.CreateResources(c)
+				Expect(err).NotTo(HaveOccurred())
+				return rs.Pools[0]
+			}
+
+			BeforeEach(func() {
+				logger = test_util.NewTestZapLogger("priority-group-test")
+				c = makeConfig()
+			})
+
+			AfterEach(func() {
+				if nil != logger {
+					logger.Close()
+				}
+			})
+
+			It("should not set a priority group without the tag", func() {
+				pool := createPool(grouped("127.0.0.1", ""))
+				Expect(pool.Members[0].PriorityGroup).To(Equal(0))
+
+				js, err := json.Marshal(pool)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(js)).NotTo(ContainSubstring("priorityGroup"))
+				Expect(string(js)).NotTo(ContainSubstring("minActiveMembers"))
+			})
+
+			It("should use the tag as the member priority group", func() {
+				pool := createPool(grouped("127.0.0.1", "10"))
+				Expect(pool.Members[0].PriorityGroup).To(Equal(10))
+
+				js, err := json.Marshal(pool.Members[0])
+				Expect(err).NotTo(HaveOccurred())
+				Expect(js).To(MatchJSON(
+					`{"address":"127.0.0.1","port":80,"session":"user-enabled","priorityGroup":10}`))
+			})
+
+			It("should ignore tags which are not valid priority groups", func() {
+				for _, group := range []string{"high", "-1", "65536"} {
+					pool := createPool(grouped("127.0.0.1", group))
+					Expect(pool.Members[0].PriorityGroup).To(Equal(0))
+				}
+				Eventually(logger).Should(Say("skipping-route-priority-group"))
+			})
+
+			It("should ignore priority groups when disabled", func() {
+				c.BigIP.PriorityGroupTag = ""
+				pool := createPool(grouped("127.0.0.1", "10"))
+				Expect(pool.Members[0].PriorityGroup).To(Equal(0))
+			})
+
+			It("should activate the priority groups once the pool has more members than the threshold", func() {
+				c.BigIP.MinActiveMembers = 2
+				mw := &MockWriter{}
+				router, err := NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+				Expect(err).NotTo(HaveOccurred())
+				stop := runRouter(router)
+				defer stop()
+
+				update := func(op routeUpdate.Operation, ep *route.Endpoint) {
+					ru, err := NewUpdate(logger, op, "foo.cf.com", ep, "")
+					Expect(err).NotTo(HaveOccurred())
+					router.UpdateRoute(ru)
+				}
+				pool := func() *bigipResources.Pool {
+					pools := mw.getResources("cf").Pools
+					if 0 == len(pools) {
+						return &bigipResources.Pool{}
+					}
+					Expect(pools).To(HaveLen(1))
+					return pools[0]
+				}
+				groups := func() map[string]int {
+					g := make(map[string]int)
+					for _, m := range pool().Members {
+						g[m.Address] = m.PriorityGroup
+					}
+					return g
+				}
+
+				update(routeUpdate.Add, grouped("127.0.0.1", "10"))
+				update(routeUpdate.Add, grouped("127.0.0.2", "10"))
+				Eventually(groups).Should(Equal(map[string]int{"127.0.0.1": 10, "127.0.0.2": 10}))
+				Expect(pool().MinActiveMembers).To(Equal(0))
+				Eventually(logger).Should(Say("f5router-priority-group-activation-off"))
+
+				update(routeUpdate.Add, grouped("127.0.0.3", "5"))
+				Eventually(groups).Should(Equal(map[string]int{
+					"127.0.0.1": 10, "127.0.0.2": 10, "127.0.0.3": 5,
+				}))
+				Expect(pool().MinActiveMembers).To(Equal(2))
+
+				js, err := json.Marshal(pool())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(js)).To(ContainSubstring(`"minActiveMembers":2`))
+				Expect(string(js)).To(ContainSubstring(`"members":[` +
+					`{"address":"127.0.0.1","port":80,"session":"user-enabled","priorityGroup":10},` +
+					`{"address":"127.0.0.2","port":80,"session":"user-enabled","priorityGroup":10},` +
+					`{"address":"127.0.0.3","port":80,"session":"user-enabled","priorityGroup":5}]`))
+
+				update(routeUpdate.Add, grouped("127.0.0.3", "10"))
+				Eventually(func() int {
+					return groups()["127.0.0.3"]
+				}).Should(Equal(10))
+
+				update(routeUpdate.Remove, grouped("127.0.0.3", "10"))
+				Eventually(groups).Should(HaveLen(2))
+				Expect(pool().MinActiveMembers).To(Equal(0))
+			})
+
+			It("should not set the threshold for pools without priority groups", func() {
+				c.BigIP.MinActiveMembers = 1
+				mw := &MockWriter{}
+				router, err := NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+				Expect(err).NotTo(HaveOccurred())
+				stop := runRouter(router)
+				defer stop()
+
+				for _, address := range []string{"127.0.0.1", "127.0.0.2", "127.0.0.3"} {
+					ru, err := NewUpdate(logger, routeUpdate.Add, "foo.cf.com", grouped(address, ""), "")
+					Expect(err).NotTo(HaveOccurred())
+					router.UpdateRoute(ru)
+				}
+				Eventually(func() []bigipResources.Member {
+					for _, pool := range mw.getResources("cf").Pools {
+						return pool.Members
+					}
+					return nil
+				}).Should(HaveLen(3))
+				pools := mw.getResources("cf").Pools
+				Expect(pools).To(HaveLen(1))
+				Expect(pools[0].MinActiveMembers).To(Equal(0))
+			})
+		})
+
+		Context("route weights", func() {
+			var c *config.Config
+			var logger *test_util.TestZapLogger
+
+			weighted := func(address, weight string) *route.Endpoint {
+				ep := makeEndpoint(address)
+				if weight != "" {
+					ep.Tags = map[string]string{"weight": weight}
+				}
+				return ep
+			}
+
+			createPool := func(ep *route.Endpoint) *bigipResources.Pool {
+				ru, err := NewUpdate(logger, routeUpdate.Add, "foo.cf.com", ep, "")
+				Expect(err).NotTo(HaveOccurred())
+				rs, err := ru.CreateResources(c)
+				Expect(err).NotTo(HaveOccurred())
+				return rs.Pools[0]
+			}
+
+			BeforeEach(func() {
+				logger = test_util.NewTestZapLogger("weight-test")
+				c = makeConfig()
+			})
+
+			AfterEach(func() {
+				if nil != logger {
+					logger.Close()
+				}
+			})
+
+			It("should weight members equally without a weight", func() {
+				pool := createPool(weighted("127.0.0.1", ""))
+				Expect(pool.Members[0].Ratio).To(Equal(0))
+				Expect(pool.Balance).To(Equal(c.BigIP.LoadBalancingMode))
+
+				js, err := json.Marshal(pool.Members[0])
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(js)).NotTo(ContainSubstring("ratio"))
+			})
+
+			It("should use the weight as the member ratio", func() {
+				pool := createPool(weighted("127.0.0.1", "25"))
+				Expect(pool.Members[0].Ratio).To(Equal(25))
+				Expect(pool.Balance).To(Equal(RatioMemberMode))
+
+				c.BigIP.LoadBalancingMode = "ratio-least-connections-member"
+				pool = createPool(weighted("127.0.0.1", "25"))
+				Expect(pool.Balance).To(Equal("ratio-least-connections-member"))
+			})
+
+			It("should ignore weights which are not valid ratios", func() {
+				for _, weight := range []string{"heavy", "0", "-1", "65536"} {
+					pool := createPool(weighted("127.0.0.1", weight))
+					Expect(pool.Members[0].Ratio).To(Equal(0))
+					Expect(pool.Balance).To(Equal(c.BigIP.LoadBalancingMode))
+				}
+				Eventually(logger).Should(Say("skipping-route-weight"))
+			})
+
+			It("should ignore weights when disabled", func() {
+				c.BigIP.RouteWeightTag = ""
+				pool := createPool(weighted("127.0.0.1", "25"))
+				Expect(pool.Members[0].Ratio).To(Equal(0))
+			})
+
+			It("should shift traffic between weighted members of a route", func() {
+				mw := &MockWriter{}
+				router, err := NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+				Expect(err).NotTo(HaveOccurred())
+				stop := runRouter(router)
+				defer stop()
+
+				update := func(op routeUpdate.Operation, ep *route.Endpoint) {
+					ru, err := NewUpdate(logger, op, "foo.cf.com", ep, "")
+					Expect(err).NotTo(HaveOccurred())
+					router.UpdateRoute(ru)
+				}
+				ratios := func() map[string]int {
+					r := make(map[string]int)
+					for _, pool := range mw.getResources("cf").Pools {
+						for _, m := range pool.Members {
+							r[m.Address] = m.Ratio
+						}
+					}
+					return r
+				}
+				balance := func() string {
+					rs := mw.getResources("cf")
+					Expect(rs.Pools).To(HaveLen(1))
+					return rs.Pools[0].Balance
+				}
+
+				update(routeUpdate.Add, weighted("127.0.0.1", ""))
+				update(routeUpdate.Add, weighted("127.0.0.2", "2"))
+				Eventually(ratios).Should(Equal(map[string]int{"127.0.0.1": 1, "127.0.0.2": 2}))
+				Expect(balance()).To(Equal(RatioMemberMode))
+
+				update(routeUpdate.Add, weighted("127.0.0.1", "90"))
+				update(routeUpdate.Add, weighted("127.0.0.2", "10"))
+				Eventually(ratios).Should(Equal(map[string]int{"127.0.0.1": 90, "127.0.0.2": 10}))
+				Expect(balance()).To(Equal(RatioMemberMode))
+
+				update(routeUpdate.Remove, weighted("127.0.0.1", "50"))
+				Eventually(ratios).Should(Equal(map[string]int{"127.0.0.2": 10}))
+				Expect(balance()).To(Equal(RatioMemberMode))
+			})
+
+			It("should write mixed weight members the same whatever order they were added in", func() {
+				endpoints := []*route.Endpoint{
+					weighted("127.0.0.3", "5"),
+					weighted("127.0.0.1", ""),
+					weighted("127.0.0.4", "20"),
+					weighted("127.0.0.2", "1"),
+				}
+				pool := func(order []int) string {
+					mw := &MockWriter{}
+					router, err := NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+					Expect(err).NotTo(HaveOccurred())
+					stop := runRouter(router)
+					defer stop()
+
+					for _, i := range order {
+						ru, err := NewUpdate(logger, routeUpdate.Add, "foo.cf.com", endpoints[i], "")
+						Expect(err).NotTo(HaveOccurred())
+						router.UpdateRoute(ru)
+					}
+					Eventually(func() int {
+						for _, pool := range mw.getResources("cf").Pools {
+							return len(pool.Members)
+						}
+						return 0
+					}).Should(Equal(len(order)))
+					pools := mw.getResources("cf").Pools
+					Expect(pools).To(HaveLen(1))
+					js, err := json.Marshal(pools[0])
+					Expect(err).NotTo(HaveOccurred())
+					return string(js)
+				}
+
+				written := pool([]int{0, 1, 2, 3})
+				Expect(written).To(ContainSubstring(`"loadBalancingMode":"ratio-member"`))
+				Expect(written).To(ContainSubstring(`"members":[` +
+					`{"address":"127.0.0.1","port":80,"session":"user-enabled","ratio":1},` +
+					`{"address":"127.0.0.2","port":80,"session":"user-enabled","ratio":1},` +
+					`{"address":"127.0.0.3","port":80,"session":"user-enabled","ratio":5},` +
+					`{"address":"127.0.0.4","port":80,"session":"user-enabled","ratio":20}]`))
+				Expect(pool([]int{3, 2, 1, 0})).To(Equal(written))
+				Expect(pool([]int{1, 3, 0, 2})).To(Equal(written))
+			})
+
+			It("should keep members sorted when they are removed", func() {
+				mw := &MockWriter{}
+				router, err := NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+				Expect(err).NotTo(HaveOccurred())
+				stop := runRouter(router)
+				defer stop()
+
+				update := func(op routeUpdate.Operation, address string) {
+					ru, err := NewUpdate(logger, op, "foo.cf.com", weighted(address, ""), "")
+					Expect(err).NotTo(HaveOccurred())
+					router.UpdateRoute(ru)
+				}
+				addresses := func() []string {
+					var a []string
+					for _, pool := range mw.getResources("cf").Pools {
+						for _, m := range pool.Members {
+							a = append(a, m.Address)
+						}
+					}
+					return a
+				}
+
+				for _, address := range []string{"127.0.0.4", "127.0.0.2", "127.0.0.1", "127.0.0.3"} {
+					update(routeUpdate.Add, address)
+				}
+				Eventually(addresses).Should(Equal([]string{"127.0.0.1", "127.0.0.2", "127.0.0.3", "127.0.0.4"}))
+
+				update(routeUpdate.Remove, "127.0.0.1")
+				update(routeUpdate.Remove, "127.0.0.3")
+				Eventually(addresses).Should(Equal([]string{"127.0.0.2", "127.0.0.4"}))
+			})
+		})
+
+		Context("route load balancing modes", func() {
+			var c *config.Config
+			var logger *test_util.TestZapLogger
+
+			tagged := func(address string, tags map[string]string) *route.Endpoint {
+				ep := makeEndpoint(address)
+				ep.Tags = tags
+				return ep
+			}
+
+			createPool := func(ep *route.Endpoint) *bigipResources.Pool {
+				ru, err := NewUpdate(logger, routeUpdate.Add, "foo.cf.com", ep, "")
+				Expect(err).NotTo(HaveOccurred())
+				rs, err := ru.CreateResources(c)
+				Expect(err).NotTo(HaveOccurred())
+				return rs.Pools[0]
+			}
+
+			BeforeEach(func() {
+				logger = test_util.NewTestZapLogger("load-balancing-mode-test")
+				c = makeConfig()
+				c.BigIP.LoadBalancingMode = "least-connections-member"
+			})
+
+			AfterEach(func() {
+				if nil != logger {
+					logger.Close()
+				}
+			})
+
+			It("should use the configured mode without a tag", func() {
+				pool := createPool(tagged("127.0.0.1", nil))
+				Expect(pool.Balance).To(Equal("least-connections-member"))
+			})
+
+			It("should override the configured mode from the route tag", func() {
+				pool := createPool(tagged("127.0.0.1",
+					map[string]string{"f5.loadBalancingMode": "observed-member"}))
+				Expect(pool.Balance).To(Equal("observed-member"))
+
+				js, err := json.Marshal(pool)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(js)).To(ContainSubstring(`"loadBalancingMode":"observed-member"`))
+			})
+
+			It("should ignore modes which are not allowed", func() {
+				pool := createPool(tagged("127.0.0.1",
+					map[string]string{"f5.loadBalancingMode": "fastest"}))
+				Expect(pool.Balance).To(Equal("least-connections-member"))
+				Eventually(logger).Should(Say("skipping-route-load-balancing-mode"))
+			})
+
+			It("should ignore the tag when disabled", func() {
+				c.BigIP.LoadBalancingModeTag = ""
+				pool := createPool(tagged("127.0.0.1",
+					map[string]string{"f5.loadBalancingMode": "observed-member"}))
+				Expect(pool.Balance).To(Equal("least-connections-member"))
+			})
+
+			It("should keep ratio balancing for weighted members", func() {
+				pool := createPool(tagged("127.0.0.1",
+					map[string]string{"f5.loadBalancingMode": "observed-member", "weight": "5"}))
+				Expect(pool.Balance).To(Equal(RatioMemberMode))
+
+				pool = createPool(tagged("127.0.0.1",
+					map[string]string{"f5.loadBalancingMode": "ratio-node", "weight": "5"}))
+				Expect(pool.Balance).To(Equal("ratio-node"))
+			})
+
+			It("should update the mode of an existing pool and its config", func() {
+				mw := &MockWriter{}
+				router, err := NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+				Expect(err).NotTo(HaveOccurred())
+				stop := runRouter(router)
+				defer stop()
+
+				update := func(ep *route.Endpoint) {
+					ru, err := NewUpdate(logger, routeUpdate.Add, "foo.cf.com", ep, "")
+					Expect(err).NotTo(HaveOccurred())
+					router.UpdateRoute(ru)
+				}
+				// pool returns the members and mode of the written pool
+				pool := func() []string {
+					var p []string
+					for _, pool := range mw.getResources("cf").Pools {
+						for _, m := range pool.Members {
+							p = append(p, m.Address)
+						}
+						p = append(p, pool.Balance)
+					}
+					return p
+				}
+
+				update(tagged("127.0.0.1", nil))
+				Eventually(pool).Should(Equal([]string{"127.0.0.1", "least-connections-member"}))
+				before := mw.getOutput()
+
+				update(tagged("127.0.0.2", map[string]string{"f5.loadBalancingMode": "predictive-member"}))
+				Eventually(pool).Should(Equal([]string{"127.0.0.1", "127.0.0.2", "predictive-member"}))
+				after := mw.getOutput()
+				Expect(string(after)).To(ContainSubstring(`"loadBalancingMode":"predictive-member"`))
+				Expect(string(before)).NotTo(ContainSubstring("predictive-member"))
+
+				// Members without the tag keep the override
+				update(tagged("127.0.0.3", nil))
+				Eventually(pool).Should(Equal([]string{"127.0.0.1", "127.0.0.2", "127.0.0.3", "predictive-member"}))
+			})
+
+			It("should set the mode of each route pool from its own annotation", func() {
+				mw := &MockWriter{}
+				router, err := NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+				Expect(err).NotTo(HaveOccurred())
+				stop := runRouter(router)
+				defer stop()
+
+				for uri, ep := range map[route.Uri]*route.Endpoint{
+					"annotated.cf.com": tagged("127.0.0.1", map[string]string{"f5.loadBalancingMode": "fastest-node"}),
+					"plain.cf.com":     tagged("127.0.0.2", nil),
+					"unknown.cf.com":   tagged("127.0.0.3", map[string]string{"f5.loadBalancingMode": "fastest"}),
+				} {
+					ru, err := NewUpdate(logger, routeUpdate.Add, uri, ep, "")
+					Expect(err).NotTo(HaveOccurred())
+					router.UpdateRoute(ru)
+				}
+
+				Eventually(func() map[string]string {
+					modes := map[string]string{}
+					for _, pool := range mw.getResources("cf").Pools {
+						modes[pool.Name] = pool.Balance
+					}
+					return modes
+				}).Should(Equal(map[string]string{
+					makeObjectName("annotated.cf.com"): "fastest-node",
+					makeObjectName("plain.cf.com"):     "least-connections-member",
+					makeObjectName("unknown.cf.com"):   "least-connections-member",
+				}))
+				Expect(logger).To(Say(`skipping-route-load-balancing-mode.*"route":"unknown.cf.com"`))
+			})
+		})
+
+		Context("route connection limits", func() {
+			var c *config.Config
+			var logger *test_util.TestZapLogger
+
+			limited := func(address, limit string) *route.Endpoint {
+				ep := makeEndpoint(address)
+				if limit != "" {
+					ep.Tags = map[string]string{"connection_limit": limit}
+				}
+				return ep
+			}
+
+			createPool := func(ep *route.Endpoint) *bigipResources.Pool {
+				ru, err := NewUpdate(logger, routeUpdate.Add, "foo.cf.com", ep, "")
+				Expect(err).NotTo(HaveOccurred())
+				rs, err := ru.CreateResources(c)
+				Expect(err).NotTo(HaveOccurred())
+				return rs.Pools[0]
+			}
+
+			BeforeEach(func() {
+				logger = test_util.NewTestZapLogger("connection-limit-test")
+				c = makeConfig()
+			})
+
+			AfterEach(func() {
+				if nil != logger {
+					logger.Close()
+				}
+			})
+
+			It("should not limit connections by default", func() {
+				pool := createPool(limited("127.0.0.1", ""))
+				Expect(pool.Members[0].ConnectionLimit).To(Equal(0))
+
+				js, err := json.Marshal(pool.Members[0])
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(js)).NotTo(ContainSubstring("connectionLimit"))
+			})
+
+			It("should use the configured limit", func() {
+				c.BigIP.ConnectionLimit = 100
+				pool := createPool(limited("127.0.0.1", ""))
+				Expect(pool.Members[0].ConnectionLimit).To(Equal(100))
+
+				js, err := json.Marshal(pool.Members[0])
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(js)).To(ContainSubstring(`"connectionLimit":100`))
+			})
+
+			It("should override the configured limit from the route tag", func() {
+				c.BigIP.ConnectionLimit = 100
+				pool := createPool(limited("127.0.0.1", "250"))
+				Expect(pool.Members[0].ConnectionLimit).To(Equal(250))
+
+				pool = createPool(limited("127.0.0.1", "0"))
+				Expect(pool.Members[0].ConnectionLimit).To(Equal(0))
+			})
+
+			It("should ignore limits which are not valid", func() {
+				c.BigIP.ConnectionLimit = 100
+				for _, limit := range []string{"many", "-1", "1.5"} {
+					pool := createPool(limited("127.0.0.1", limit))
+					Expect(pool.Members[0].ConnectionLimit).To(Equal(100))
+				}
+				Eventually(logger).Should(Say("skipping-route-connection-limit"))
+			})
+
+			It("should lower overrides to the max connection limit", func() {
+				c.BigIP.ConnectionLimit = 100
+				c.BigIP.MaxConnectionLimit = 500
+				pool := createPool(limited("127.0.0.1", "500"))
+				Expect(pool.Members[0].ConnectionLimit).To(Equal(500))
+
+				pool = createPool(limited("127.0.0.1", "1000"))
+				Expect(pool.Members[0].ConnectionLimit).To(Equal(500))
+				Eventually(logger).Should(Say(
+					`"lowering-route-connection-limit".*"connection-limit":1000,"max-connection-limit":500`))
+
+				pool = createPool(limited("127.0.0.1", "0"))
+				Expect(pool.Members[0].ConnectionLimit).To(Equal(500))
+			})
+
+			It("should ignore the tag when disabled", func() {
+				c.BigIP.ConnectionLimitTag = ""
+				pool := createPool(limited("127.0.0.1", "250"))
+				Expect(pool.Members[0].ConnectionLimit).To(Equal(0))
+			})
+
+			It("should update the limit of an existing member", func() {
+				mw := &MockWriter{}
+				router, err := NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+				Expect(err).NotTo(HaveOccurred())
+				stop := runRouter(router)
+				defer stop()
+
+				update := func(ep *route.Endpoint) {
+					ru, err := NewUpdate(logger, routeUpdate.Add, "foo.cf.com", ep, "")
+					Expect(err).NotTo(HaveOccurred())
+					router.UpdateRoute(ru)
+				}
+				limits := func() map[string]int {
+					l := make(map[string]int)
+					for _, pool := range mw.getResources("cf").Pools {
+						for _, m := range pool.Members {
+							l[m.Address] = m.ConnectionLimit
+						}
+					}
+					return l
+				}
+
+				update(limited("127.0.0.1", ""))
+				update(limited("127.0.0.2", "10"))
+				Eventually(limits).Should(Equal(map[string]int{"127.0.0.1": 0, "127.0.0.2": 10}))
+				before := mw.getOutput()
+				Expect(string(before)).NotTo(ContainSubstring(`"connectionLimit":20`))
+
+				update(limited("127.0.0.1", "20"))
+				Eventually(limits).Should(Equal(map[string]int{"127.0.0.1": 20, "127.0.0.2": 10}))
+				after := mw.getOutput()
+				Expect(string(after)).To(ContainSubstring(`"connectionLimit":20`))
+				Expect(after).NotTo(Equal(before))
+				Expect(mw.getResources("cf").Pools).To(HaveLen(1))
+			})
+
+			It("should apply the configured limit to TCP route members", func() {
+				c.BigIP.ConnectionLimit = 100
+				member := bigipResources.Member{Address: "10.0.0.1", Port: 5000, Session: "user-enabled"}
+				tu, err := NewTCPUpdate(c, logger, routeUpdate.Add, 6010, member)
+				Expect(err).NotTo(HaveOccurred())
+				rs, err := tu.CreateResources(c)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(rs.Pools[0].Members[0].ConnectionLimit).To(Equal(100))
+			})
+		})
+
+		Context("CreatePlanResources", func() {
+			var plan planResources.Plan
+			var logger *test_util.TestZapLogger
+			var c *config.Config
+
+			BeforeEach(func() {
+				logger := test_util.NewTestZapLogger("create-plan-resources-test")
+				httpUpdate = updateHTTP{logger: logger}
+				plan = planResources.Plan{
+					Name: "test-plan",
+				}
+				c = config.DefaultConfig()
+				c.BigIP.Partitions = []string{"test"}
+			})
+
+			AfterEach(func() {
+				if nil != logger {
+					logger.Close()
+				}
+			})
+
+			It("should create virtual resources from plan", func() {
+				plan.VirtualServer = planResources.VirtualType{
+					Policies:    []string{"/test/policy"},
+					Profiles:    []string{"/test/profile"},
+					SslProfiles: []string{"/test/sslProfile"},
+				}
+				resources := httpUpdate.CreatePlanResources(c, plan)
+
+				Expect(len(resources.Pools)).To(Equal(1))
+				Expect(len(resources.Monitors)).To(Equal(0))
+				Expect(len(resources.Virtuals)).To(Equal(1))
+				Expect(len(resources.Virtuals[0].Policies)).To(Equal(1))
+				Expect(len(resources.Virtuals[0].Profiles)).To(Equal(2))
+
+				Expect(resources.Pools[0]).To(Equal(&bigipResources.Pool{}))
+				Expect(resources.Virtuals[0].Policies).To(Equal([]*bigipResources.NameRef{
+					&bigipResources.NameRef{
+						Name:      "policy",
+						Partition: "test",
+					}}))
+				Expect(resources.Virtuals[0].Profiles[0]).To(Equal(&bigipResources.ProfileRef{
+					Name:      "profile",
+					Partition: "test",
+					Context:   "all",
+				}))
+				Expect(resources.Virtuals[0].Profiles[1]).To(Equal(&bigipResources.ProfileRef{
+					Name:      "sslProfile",
+					Partition: "test",
+					Context:   "serverside",
+				}))
+			})
+
+			It("should not create virtual resources", func() {
+				plan.VirtualServer = planResources.VirtualType{}
+				resources := httpUpdate.CreatePlanResources(c, plan)
+
+				Expect(len(resources.Pools)).To(Equal(1))
+				Expect(len(resources.Virtuals)).To(Equal(1))
+				Expect(len(resources.Monitors)).To(Equal(0))
+
+				Expect(resources.Pools[0]).To(Equal(&bigipResources.Pool{}))
+				Expect(resources.Virtuals[0]).To(Equal(&bigipResources.Virtual{}))
+			})
+
+			It("should create pool resources from plan", func() {
+				monitors := []bigipResources.Monitor{
+					bigipResources.Monitor{
+						Name: "monitor1",
+						Type: "http",
+					},
+					bigipResources.Monitor{
+						Name: "monitor2",
+						Type: "tcp",
+					},
+				}
+				plan.Pool = planResources.PoolType{
+					Balance:        "round-robin",
+					HealthMonitors: monitors,
+				}
+				resources := httpUpdate.CreatePlanResources(c, plan)
+
+				Expect(len(resources.Virtuals)).To(Equal(1))
+				Expect(resources.Virtuals[0]).To(Equal(&bigipResources.Virtual{}))
+				Expect(len(resources.Pools)).To(Equal(1))
+				Expect(len(resources.Monitors)).To(Equal(2))
+				Expect(len(resources.Pools[0].MonitorNames)).To(Equal(2))
+				Expect(resources.Pools[0].Balance).To(Equal("round-robin"))
+				Expect(resources.Pools[0].MonitorNames).To(Equal([]string{"/test/monitor1", "/test/monitor2"}))
+				Expect(*resources.Monitors[0]).To(Equal(monitors[0]))
+				Expect(*resources.Monitors[1]).To(Equal(monitors[1]))
+			})
+
+			It("should not create pool resources", func() {
+				plan.Pool = planResources.PoolType{}
+				resources := httpUpdate.CreatePlanResources(c, plan)
+
+				Expect(len(resources.Virtuals)).To(Equal(1))
+				Expect(len(resources.Pools)).To(Equal(1))
+				Expect(len(resources.Monitors)).To(Equal(0))
+
+				Expect(resources.Pools[0]).To(Equal(&bigipResources.Pool{}))
+				Expect(resources.Virtuals[0]).To(Equal(&bigipResources.Virtual{}))
+			})
+
+			It("should set the pool service down action", func() {
+				for _, action := range []string{"none", "reset", "drop", "reselect"} {
+					plan.Pool = planResources.PoolType{ServiceDownAction: action}
+					resources := httpUpdate.CreatePlanResources(c, plan)
+					Expect(resources.Pools[0].ServiceDownAction).To(Equal(action))
+
+					existing := bigipResources.Resources{
+						Virtuals: []*bigipResources.Virtual{&bigipResources.Virtual{}},
+						Pools:    []*bigipResources.Pool{&bigipResources.Pool{Name: "pool"}},
+					}
+					updated := httpUpdate.UpdateResources(existing, resources)
+
+					js, err := json.Marshal(updated.Pools[0])
+					Expect(err).NotTo(HaveOccurred())
+					Expect(js).To(MatchJSON(`{"name":"pool","loadBalancingMode":"","members":null,` +
+						`"monitors":null,"description":"","serviceDownAction":"` + action + `"}`))
+				}
+			})
+
+			It("should leave the service down action to the driver default", func() {
+				for _, action := range []string{"", "restart"} {
+					plan.Pool = planResources.PoolType{Balance: "round-robin", ServiceDownAction: action}
+					resources := httpUpdate.CreatePlanResources(c, plan)
+
+					js, err := json.Marshal(resources.Pools[0])
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(js)).NotTo(ContainSubstring("serviceDownAction"))
+				}
+			})
+
+			It("should set the pool connection queuing", func() {
+				plan.Pool = planResources.PoolType{
+					QueueOnConnectionLimit: true,
+					QueueDepthLimit:        100,
+					QueueTimeLimit:         5000,
+				}
+				resources := httpUpdate.CreatePlanResources(c, plan)
+
+				existing := bigipResources.Resources{
+					Virtuals: []*bigipResources.Virtual{&bigipResources.Virtual{}},
+					Pools:    []*bigipResources.Pool{&bigipResources.Pool{Name: "pool"}},
+				}
+				updated := httpUpdate.UpdateResources(existing, resources)
+
+				js, err := json.Marshal(updated.Pools[0])
+				Expect(err).NotTo(HaveOccurred())
+				Expect(js).To(MatchJSON(`{"name":"pool","loadBalancingMode":"","members":null,` +
+					`"monitors":null,"description":"","queueOnConnectionLimit":"enabled",` +
+					`"queueDepthLimit":100,"queueTimeLimit":5000}`))
+			})
+
+			It("should leave connection queuing off by default", func() {
+				pools := []planResources.PoolType{
+					planResources.PoolType{Balance: "round-robin"},
+					planResources.PoolType{Balance: "round-robin", QueueDepthLimit: 100, QueueTimeLimit: 5000},
+					planResources.PoolType{QueueOnConnectionLimit: true, QueueDepthLimit: -1},
+				}
+				for _, pool := range pools {
+					plan.Pool = pool
+					resources := httpUpdate.CreatePlanResources(c, plan)
+
+					js, err := json.Marshal(resources.Pools[0])
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(js)).NotTo(ContainSubstring("queue"))
+				}
+			})
+
+			Context("no match action", func() {
+				var expectedMatchRule *bigipResources.Rule
+
+				BeforeEach(func() {
+					httpUpdate.uri = "foo.cf.com/bar"
+					httpUpdate.name = makeObjectName("foo.cf.com/bar")
+					expectedMatchRule = &bigipResources.Rule{
+						FullURI: "foo.cf.com/bar",
+						Actions: []*bigipResources.Action{&bigipResources.Action{
+							Forward: true,
+							Name:    "0",
+							Pool:    "/test/" + httpUpdate.name,
+							Request: true,
+						}},
+						Conditions: []*bigipResources.Condition{
+							&bigipResources.Condition{
+								Equals:   true,
+								Host:     true,
+								HTTPHost: true,
+								Name:     "0",
+								Index:    0,
+								Request:  true,
+								Values:   []string{"foo.cf.com"},
+							},
+							&bigipResources.Condition{
+								Equals:      true,
+								HTTPURI:     true,
+								PathSegment: true,
+								Name:        "1",
+								Index:       1,
+								Request:     true,
+								Values:      []string{"bar"},
+							},
+						},
+						Name:        httpUpdate.name,
+						Ordinal:     0,
+						Description: "route foo.cf.com/bar",
+					}
+				})
+
+				checkNoMatchPolicy := func(
+					resources bigipResources.Resources,
+					expectedAction *bigipResources.Action,
+				) {
+					policyName := httpUpdate.name + RoutePolicySuffix
+
+					Expect(len(resources.Virtuals)).To(Equal(1))
+					Expect(resources.Virtuals[0].Policies).To(Equal([]*bigipResources.NameRef{
+						&bigipResources.NameRef{
+							Name:      policyName,
+							Partition: "test",
+						}}))
+					Expect(len(resources.Policies)).To(Equal(1))
+
+					policy := resources.Policies[0]
+					Expect(policy.Name).To(Equal(policyName))
+					Expect(policy.Strategy).To(Equal("/Common/first-match"))
+					Expect(len(policy.Rules)).To(Equal(2))
+					Expect(policy.Rules[0]).To(Equal(expectedMatchRule))
+
+					defaultRule := policy.Rules[1]
+					Expect(defaultRule.Ordinal).To(Equal(1))
+					Expect(defaultRule.Conditions).To(BeEmpty())
+					Expect(defaultRule.Actions).To(Equal([]*bigipResources.Action{expectedAction}))
+				}
+
+				It("should reject when no rule matches", func() {
+					plan.VirtualServer.NoMatchAction = planResources.NoMatchReject
+					resources := httpUpdate.CreatePlanResources(c, plan)
+
+					checkNoMatchPolicy(resources, &bigipResources.Action{
+						Forward: true,
+						Reset:   true,
+						Name:    "0",
+						Request: true,
+					})
+
+					js, err := json.Marshal(resources.Policies[0].Rules[1].Actions[0])
+					Expect(err).NotTo(HaveOccurred())
+					Expect(js).To(MatchJSON(`{"forward":true,"reset":true,"name":"0","request":true}`))
+				})
+
+				It("should drop when no rule matches", func() {
+					plan.VirtualServer.NoMatchAction = planResources.NoMatchDrop
+					resources := httpUpdate.CreatePlanResources(c, plan)
+
+					checkNoMatchPolicy(resources, &bigipResources.Action{
+						Drop:    true,
+						Name:    "0",
+						Request: true,
+					})
+
+					js, err := json.Marshal(resources.Policies[0].Rules[1].Actions[0])
+					Expect(err).NotTo(HaveOccurred())
+					Expect(js).To(MatchJSON(`{"drop":true,"name":"0","request":true}`))
+				})
+
+				It("should forward to the route pool when no rule matches", func() {
+					plan.VirtualServer.NoMatchAction = planResources.NoMatchDefaultPool
+					resources := httpUpdate.CreatePlanResources(c, plan)
+
+					checkNoMatchPolicy(resources, &bigipResources.Action{
+						Forward: true,
+						Name:    "0",
+						Pool:    "/test/" + httpUpdate.name,
+						Request: true,
+					})
+
+					js, err := json.Marshal(resources.Policies[0].Rules[1].Actions[0])
+					Expect(err).NotTo(HaveOccurred())
+					Expect(js).To(MatchJSON(
+						`{"forward":true,"name":"0","pool":"/test/` + httpUpdate.name + `","request":true}`))
+				})
+
+				It("should keep plan policies ahead of the route policy", func() {
+					plan.VirtualServer.Policies = []string{"/test/policy"}
+					plan.VirtualServer.NoMatchAction = planResources.NoMatchDrop
+					resources := httpUpdate.CreatePlanResources(c, plan)
+
+					Expect(len(resources.Virtuals[0].Policies)).To(Equal(2))
+					Expect(resources.Virtuals[0].Policies[0].Name).To(Equal("policy"))
+					Expect(resources.Virtuals[0].Policies[1].Name).To(Equal(httpUpdate.name + RoutePolicySuffix))
+				})
+
+				It("should skip unknown no match actions", func() {
+					plan.VirtualServer.NoMatchAction = "redirect"
+					resources := httpUpdate.CreatePlanResources(c, plan)
+
+					Expect(resources.Policies).To(BeEmpty())
+					Expect(resources.Virtuals[0]).To(Equal(&bigipResources.Virtual{}))
+				})
+			})
+
+			Context("header match", func() {
+				BeforeEach(func() {
+					httpUpdate.uri = "foo.cf.com"
+					httpUpdate.name = makeObjectName("foo.cf.com")
+					plan.VirtualServer.HeaderMatches = []planResources.HeaderMatch{
+						planResources.HeaderMatch{
+							Header: "X-Api-Version",
+							Values: []string{"2", "2.1"},
+							Pool:   "/Common/api-v2",
+						},
+						planResources.HeaderMatch{
+							Header:   "X-Canary",
+							Operator: planResources.HeaderMatchExists,
+							Pool:     "/test/canary",
+						},
+					}
+				})
+
+				hostCondition := func() *bigipResources.Condition {
+					return &bigipResources.Condition{
+						Equals:   true,
+						Host:     true,
+						HTTPHost: true,
+						Name:     "0",
+						Index:    0,
+						Request:  true,
+						Values:   []string{"foo.cf.com"},
+					}
+				}
+
+				It("should forward matching headers to the header match pools", func() {
+					resources := httpUpdate.CreatePlanResources(c, plan)
+
+					Expect(resources.Virtuals[0].Policies).To(Equal([]*bigipResources.NameRef{
+						&bigipResources.NameRef{
+							Name:      httpUpdate.name + RoutePolicySuffix,
+							Partition: "test",
+						}}))
+					Expect(len(resources.Policies)).To(Equal(1))
+					rules := resources.Policies[0].Rules
+					Expect(len(rules)).To(Equal(3))
+
+					Expect(rules[0]).To(Equal(&bigipResources.Rule{
+						FullURI: "foo.cf.com",
+						Actions: []*bigipResources.Action{&bigipResources.Action{
+							Forward: true,
+							Name:    "0",
+							Pool:    "/Common/api-v2",
+							Request: true,
+						}},
+						Conditions: []*bigipResources.Condition{
+							hostCondition(),
+							&bigipResources.Condition{
+								Equals:     true,
+								HTTPHeader: true,
+								TmName:     "X-Api-Version",
+								Name:       "1",
+								Index:      0,
+								Request:    true,
+								Values:     []string{"2", "2.1"},
+							},
+						},
+						Name:        httpUpdate.name + "-header-0",
+						Ordinal:     0,
+						Description: "route foo.cf.com header X-Api-Version equals",
+					}))
+					Expect(rules[1]).To(Equal(&bigipResources.Rule{
+						FullURI: "foo.cf.com",
+						Actions: []*bigipResources.Action{&bigipResources.Action{
+							Forward: true,
+							Name:    "0",
+							Pool:    "/test/canary",
+							Request: true,
+						}},
+						Conditions: []*bigipResources.Condition{
+							hostCondition(),
+							&bigipResources.Condition{
+								Exists:     true,
+								HTTPHeader: true,
+								TmName:     "X-Canary",
+								Name:       "1",
+								Index:      0,
+								Request:    true,
+								Values:     []string{},
+							},
+						},
+						Name:        httpUpdate.name + "-header-1",
+						Ordinal:     1,
+						Description: "route foo.cf.com header X-Canary exists",
+					}))
+
+					// Requests without a matching header go to the route pool
+					Expect(rules[2].Name).To(Equal(httpUpdate.name))
+					Expect(rules[2].Ordinal).To(Equal(2))
+					Expect(rules[2].Conditions).To(Equal([]*bigipResources.Condition{hostCondition()}))
+					Expect(rules[2].Actions[0].Pool).To(Equal("/test/" + httpUpdate.name))
+
+					js, err := json.Marshal(rules[1].Conditions[1])
+					Expect(err).NotTo(HaveOccurred())
+					Expect(js).To(MatchJSON(
+						`{"exists":true,"httpHeader":true,"tmName":"X-Canary","name":"1","index":0,"request":true,"values":[]}`))
+				})
+
+				It("should apply the no match action after the header matches", func() {
+					plan.VirtualServer.NoMatchAction = planResources.NoMatchReject
+					resources := httpUpdate.CreatePlanResources(c, plan)
+
+					rules := resources.Policies[0].Rules
+					Expect(len(rules)).To(Equal(4))
+					Expect(rules[2].Name).To(Equal(httpUpdate.name))
+					Expect(rules[3].Name).To(Equal("no-match"))
+					Expect(rules[3].Ordinal).To(Equal(3))
+				})
+
+				It("should skip header matches which are not valid", func() {
+					invalid := []planResources.HeaderMatch{
+						planResources.HeaderMatch{Header: "X-Api-Version", Pool: "/Common/api-v2"},
+						planResources.HeaderMatch{Header: "X-Api-Version", Operator: "contains",
+							Values: []string{"2"}, Pool: "/Common/api-v2"},
+						planResources.HeaderMatch{Values: []string{"2"}, Pool: "/Common/api-v2"},
+						planResources.HeaderMatch{Header: "X-Api-Version", Values: []string{"2"}, Pool: "api-v2"},
+					}
+					for _, hm := range invalid {
+						plan.VirtualServer.HeaderMatches = []planResources.HeaderMatch{hm}
+						resources := httpUpdate.CreatePlanResources(c, plan)
+
+						Expect(resources.Policies).To(BeEmpty())
+						Expect(resources.Virtuals[0]).To(Equal(&bigipResources.Virtual{}))
+					}
+				})
+			})
+
+			Context("pool down action", func() {
+				BeforeEach(func() {
+					httpUpdate.uri = "foo.cf.com"
+					httpUpdate.name = makeObjectName("foo.cf.com")
+				})
+
+				checkPoolDownIRule := func(resources bigipResources.Resources, response string) {
+					name := httpUpdate.name + bigipResources.PoolDownIRuleSuffix
+
+					Expect(resources.Virtuals[0].IRules).To(Equal([]string{"/test/" + name}))
+					Expect(resources.IRules).To(Equal([]*bigipResources.IRule{
+						&bigipResources.IRule{
+							Name: name,
+							Code: `
+when HTTP_REQUEST {
+  if { [active_members [LB::server pool]] < 1 } {
+    ` + response + `
+    return
+  }
+}`,
+						}}))
+				}
+
+				It("should serve a maintenance page", func() {
+					plan.VirtualServer.OnPoolDown = &planResources.PoolDownType{
+						Action:  planResources.PoolDownMaintenance,
+						Content: `<h1>Down for "maintenance" [$now]</h1>`,
+					}
+					resources := httpUpdate.CreatePlanResources(c, plan)
+
+					checkPoolDownIRule(resources,
+						`HTTP::respond 503 content "<h1>Down for \"maintenance\" \[\$now\]</h1>" `+
+							`"Content-Type" "text/html" "Connection" "Close"`)
+				})
+
+				It("should redirect to a status URL", func() {
+					plan.VirtualServer.OnPoolDown = &planResources.PoolDownType{
+						Action:   planResources.PoolDownRedirect,
+						Location: "https://status.cf.com",
+					}
+					resources := httpUpdate.CreatePlanResources(c, plan)
+
+					checkPoolDownIRule(resources,
+						`HTTP::respond 302 "Location" "https://status.cf.com" "Connection" "Close"`)
+				})
+
+				It("should return the status code", func() {
+					plan.VirtualServer.OnPoolDown = &planResources.PoolDownType{
+						Action:     planResources.PoolDownStatus,
+						StatusCode: 504,
+					}
+					resources := httpUpdate.CreatePlanResources(c, plan)
+
+					checkPoolDownIRule(resources, `HTTP::respond 504 "Connection" "Close"`)
+				})
+
+				It("should skip pool down actions which are not valid", func() {
+					invalid := []planResources.PoolDownType{
+						planResources.PoolDownType{Action: "retry"},
+						planResources.PoolDownType{Action: planResources.PoolDownRedirect},
+						planResources.PoolDownType{Action: planResources.PoolDownStatus, StatusCode: 99},
+						planResources.PoolDownType{Action: planResources.PoolDownStatus, StatusCode: 600},
+					}
+					for i := range invalid {
+						plan.VirtualServer.OnPoolDown = &invalid[i]
+						resources := httpUpdate.CreatePlanResources(c, plan)
+
+						Expect(resources.IRules).To(BeEmpty())
+						Expect(resources.Virtuals[0]).To(Equal(&bigipResources.Virtual{}))
+					}
+				})
+
+				It("should only emit the iRule for routes bound to the plan", func() {
+					l := httpUpdate.logger
+					c := makeConfig()
+					c.SessionPersistence = true
+					mw := &MockWriter{}
+					router, err := NewF5Router(l, c, mw, &fakeClient.FakeClient{})
+					Expect(err).NotTo(HaveOccurred())
+					plan.ID = "plan1"
+					plan.VirtualServer.OnPoolDown = &planResources.PoolDownType{
+						Action: planResources.PoolDownStatus,
+					}
+					router.AddPlans(map[string]planResources.Plan{"plan1": plan})
+					stop := runRouter(router)
+					defer stop()
+
+					update := func(op routeUpdate.Operation, uri route.Uri, ep *route.Endpoint, planID string) {
+						ru, err := NewUpdate(l, op, uri, ep, planID)
+						Expect(err).NotTo(HaveOccurred())
+						router.UpdateRoute(ru)
+					}
+					rules := func() []string {
+						var names []string
+						for _, rule := range mw.getResources("cf").IRules {
+							names = append(names, rule.Name)
+						}
+						return names
+					}
+					virtualRules := func(name string) func() []string {
+						return func() []string {
+							for _, vs := range mw.getResources("cf").Virtuals {
+								if vs.VirtualServerName == name {
+									return vs.IRules
+								}
+							}
+							return nil
+						}
+					}
 
-			BeforeEach(func() {
-				logger := test_util.NewTestZapLogger("create-plan-resources-test")
-				httpUpdate = updateHTTP{logger: logger}
-				plan = planResources.Plan{
-					Name: "test-plan",
-				}
-				c = config.DefaultConfig()
-				c.BigIP.Partitions = []string{"test"}
+					fooName := makeObjectName("foo.cf.com")
+					barName := makeObjectName("bar.cf.com")
+					poolDownName := fooName + bigipResources.PoolDownIRuleSuffix
+					jsessionPath := "/cf/" + bigipResources.JsessionidIRuleName
+
+					update(routeUpdate.Add, "foo.cf.com", makeEndpoint("127.0.0.1"), "")
+					update(routeUpdate.Add, "bar.cf.com", makeEndpoint("127.0.0.2"), "")
+					update(routeUpdate.Bind, "foo.cf.com", nil, "plan1")
+					Eventually(virtualRules(fooName)).Should(Equal([]string{
+						jsessionPath,
+						"/cf/" + poolDownName,
+					}))
+					Expect(rules()).To(ContainElement(poolDownName))
+					Expect(rules()).NotTo(ContainElement(barName + bigipResources.PoolDownIRuleSuffix))
+					Expect(virtualRules(barName)()).To(Equal([]string{jsessionPath}))
+
+					// Binding again replaces the reference instead of adding another
+					update(routeUpdate.Bind, "foo.cf.com", nil, "plan1")
+					update(routeUpdate.Add, "bar.cf.com", makeEndpoint("127.0.0.3"), "")
+					Eventually(func() int {
+						for _, pool := range mw.getResources("cf").Pools {
+							if pool.Name == barName {
+								return len(pool.Members)
+							}
+						}
+						return 0
+					}).Should(Equal(2))
+					Expect(virtualRules(fooName)()).To(HaveLen(2))
+
+					update(routeUpdate.Unbind, "foo.cf.com", nil, "")
+					Eventually(virtualRules(fooName)).Should(Equal([]string{jsessionPath}))
+					Expect(rules()).NotTo(ContainElement(poolDownName))
+
+					update(routeUpdate.Bind, "foo.cf.com", nil, "plan1")
+					Eventually(rules).Should(ContainElement(poolDownName))
+					update(routeUpdate.Remove, "foo.cf.com", makeEndpoint("127.0.0.1"), "")
+					Eventually(rules).ShouldNot(ContainElement(poolDownName))
+				})
 			})
 
-			AfterEach(func() {
-				if nil != logger {
-					logger.Close()
-				}
+			Context("size limit", func() {
+				BeforeEach(func() {
+					httpUpdate.uri = "foo.cf.com"
+					httpUpdate.name = makeObjectName("foo.cf.com")
+				})
+
+				It("should reject requests over the limit", func() {
+					plan.VirtualServer.MaxRequestBytes = 1024
+					resources := httpUpdate.CreatePlanResources(c, plan)
+
+					name := httpUpdate.name + bigipResources.SizeLimitIRuleSuffix
+					Expect(resources.Virtuals[0].IRules).To(Equal([]string{"/test/" + name}))
+					Expect(resources.IRules).To(Equal([]*bigipResources.IRule{
+						&bigipResources.IRule{
+							Name: name,
+							Code: `
+when HTTP_REQUEST {
+  if { [HTTP::header exists "Content-Length"] && [HTTP::header "Content-Length"] > 1024 } {
+    HTTP::respond 413 "Connection" "Close"
+    return
+  }
+}`,
+						}}))
+				})
+
+				It("should reject requests and responses over the limits", func() {
+					plan.VirtualServer.MaxRequestBytes = 1024
+					plan.VirtualServer.MaxResponseBytes = 2048
+					resources := httpUpdate.CreatePlanResources(c, plan)
+
+					Expect(resources.IRules).To(HaveLen(1))
+					Expect(resources.IRules[0].Code).To(Equal(`
+when HTTP_REQUEST {
+  if { [HTTP::header exists "Content-Length"] && [HTTP::header "Content-Length"] > 1024 } {
+    HTTP::respond 413 "Connection" "Close"
+    return
+  }
+}
+when HTTP_RESPONSE {
+  if { [HTTP::header exists "Content-Length"] && [HTTP::header "Content-Length"] > 2048 } {
+    HTTP::respond 502 "Connection" "Close"
+    return
+  }
+}`))
+				})
+
+				It("should only check responses when only a response limit is set", func() {
+					plan.VirtualServer.MaxResponseBytes = 2048
+					resources := httpUpdate.CreatePlanResources(c, plan)
+
+					Expect(resources.IRules).To(HaveLen(1))
+					Expect(resources.IRules[0].Code).NotTo(ContainSubstring("HTTP_REQUEST"))
+					Expect(resources.IRules[0].Code).To(ContainSubstring("HTTP::respond 502"))
+				})
+
+				It("should not create the iRule without limits", func() {
+					resources := httpUpdate.CreatePlanResources(c, plan)
+
+					Expect(resources.IRules).To(BeEmpty())
+					Expect(resources.Virtuals[0].IRules).To(BeEmpty())
+				})
+
+				It("should skip limits which are not valid", func() {
+					plan.VirtualServer.MaxRequestBytes = -1
+					plan.VirtualServer.MaxResponseBytes = 2048
+					resources := httpUpdate.CreatePlanResources(c, plan)
+
+					Expect(resources.IRules).To(BeEmpty())
+					Expect(resources.Virtuals[0]).To(Equal(&bigipResources.Virtual{}))
+				})
+
+				It("should keep the size limit and pool down iRules for bound routes", func() {
+					l := httpUpdate.logger
+					mw := &MockWriter{}
+					router, err := NewF5Router(l, makeConfig(), mw, &fakeClient.FakeClient{})
+					Expect(err).NotTo(HaveOccurred())
+					plan.ID = "plan1"
+					plan.VirtualServer.MaxRequestBytes = 1024
+					plan.VirtualServer.OnPoolDown = &planResources.PoolDownType{
+						Action: planResources.PoolDownStatus,
+					}
+					router.AddPlans(map[string]planResources.Plan{"plan1": plan})
+					stop := runRouter(router)
+					defer stop()
+
+					update := func(op routeUpdate.Operation, ep *route.Endpoint, planID string) {
+						ru, err := NewUpdate(l, op, "foo.cf.com", ep, planID)
+						Expect(err).NotTo(HaveOccurred())
+						router.UpdateRoute(ru)
+					}
+					rules := func() []string {
+						var names []string
+						for _, rule := range mw.getResources("cf").IRules {
+							names = append(names, rule.Name)
+						}
+						return names
+					}
+					name := makeObjectName("foo.cf.com")
+					virtualRules := func() []string {
+						for _, vs := range mw.getResources("cf").Virtuals {
+							if vs.VirtualServerName == name {
+								return vs.IRules
+							}
+						}
+						return nil
+					}
+
+					update(routeUpdate.Add, makeEndpoint("127.0.0.1"), "")
+					update(routeUpdate.Bind, nil, "plan1")
+
+					jsessionPath := "/cf/" + bigipResources.JsessionidIRuleName
+					Eventually(virtualRules).Should(Equal([]string{
+						jsessionPath,
+						"/cf/" + name + bigipResources.PoolDownIRuleSuffix,
+						"/cf/" + name + bigipResources.SizeLimitIRuleSuffix,
+					}))
+					Expect(rules()).To(ContainElement(name + bigipResources.PoolDownIRuleSuffix))
+					Expect(rules()).To(ContainElement(name + bigipResources.SizeLimitIRuleSuffix))
+
+					update(routeUpdate.Unbind, nil, "")
+					Eventually(virtualRules).Should(Equal([]string{jsessionPath}))
+					Expect(rules()).NotTo(ContainElement(name + bigipResources.PoolDownIRuleSuffix))
+					Expect(rules()).NotTo(ContainElement(name + bigipResources.SizeLimitIRuleSuffix))
+				})
 			})
 
-			It("should create virtual resources from plan", func() {
-				plan.VirtualServer = planResources.VirtualType{
-					Policies:    []string{"/test/policy"},
-					Profiles:    []string{"/test/profile"},
-					SslProfiles: []string{"/test/sslProfile"},
+			Context("analytics profile", func() {
+				analytics := &bigipResources.ProfileRef{
+					Name:      "analytics",
+					Partition: "Common",
+					Context:   "all",
 				}
-				resources := httpUpdate.CreatePlanResources(c, plan)
 
-				Expect(len(resources.Pools)).To(Equal(1))
-				Expect(len(resources.Monitors)).To(Equal(0))
-				Expect(len(resources.Virtuals)).To(Equal(1))
-				Expect(len(resources.Virtuals[0].Policies)).To(Equal(1))
-				Expect(len(resources.Virtuals[0].Profiles)).To(Equal(2))
+				It("should add the analytics profile to the default profiles", func() {
+					plan.VirtualServer.AnalyticsProfile = "/Common/analytics"
+					resources := httpUpdate.CreatePlanResources(c, plan)
 
-				Expect(resources.Pools[0]).To(Equal(&bigipResources.Pool{}))
-				Expect(resources.Virtuals[0].Policies).To(Equal([]*bigipResources.NameRef{
-					&bigipResources.NameRef{
-						Name:      "policy",
-						Partition: "test",
-					}}))
-				Expect(resources.Virtuals[0].Profiles[0]).To(Equal(&bigipResources.ProfileRef{
-					Name:      "profile",
-					Partition: "test",
-					Context:   "all",
-				}))
-				Expect(resources.Virtuals[0].Profiles[1]).To(Equal(&bigipResources.ProfileRef{
-					Name:      "sslProfile",
-					Partition: "test",
-					Context:   "serverside",
-				}))
-			})
+					Expect(resources.Virtuals[0].Profiles).To(Equal(
+						append(defaultProfiles(c), analytics)))
+				})
 
-			It("should not create virtual resources", func() {
-				plan.VirtualServer = planResources.VirtualType{}
-				resources := httpUpdate.CreatePlanResources(c, plan)
+				It("should add the analytics profile to the plan profiles", func() {
+					plan.VirtualServer.Profiles = []string{"/Common/http2"}
+					plan.VirtualServer.AnalyticsProfile = "/Common/analytics"
+					resources := httpUpdate.CreatePlanResources(c, plan)
 
-				Expect(len(resources.Pools)).To(Equal(1))
-				Expect(len(resources.Virtuals)).To(Equal(1))
-				Expect(len(resources.Monitors)).To(Equal(0))
+					Expect(resources.Virtuals[0].Profiles).To(Equal([]*bigipResources.ProfileRef{
+						&bigipResources.ProfileRef{Name: "http2", Partition: "Common", Context: "all"},
+						analytics,
+					}))
+				})
 
-				Expect(resources.Pools[0]).To(Equal(&bigipResources.Pool{}))
-				Expect(resources.Virtuals[0]).To(Equal(&bigipResources.Virtual{}))
+				It("should skip an analytics profile which is not a BIG-IP path", func() {
+					plan.VirtualServer.AnalyticsProfile = "analytics"
+					resources := httpUpdate.CreatePlanResources(c, plan)
+
+					Expect(resources.Virtuals[0].Profiles).To(BeEmpty())
+				})
+
+				It("should only attach the analytics profile to bound routes", func() {
+					l := httpUpdate.logger
+					mw := &MockWriter{}
+					router, err := NewF5Router(l, makeConfig(), mw, &fakeClient.FakeClient{})
+					Expect(err).NotTo(HaveOccurred())
+					plan.ID = "plan1"
+					plan.VirtualServer.AnalyticsProfile = "/Common/analytics"
+					router.AddPlans(map[string]planResources.Plan{"plan1": plan})
+					stop := runRouter(router)
+					defer stop()
+
+					update := func(op routeUpdate.Operation, uri route.Uri, ep *route.Endpoint, planID string) {
+						ru, err := NewUpdate(l, op, uri, ep, planID)
+						Expect(err).NotTo(HaveOccurred())
+						router.UpdateRoute(ru)
+					}
+					profiles := func(uri string) func() []*bigipResources.ProfileRef {
+						return func() []*bigipResources.ProfileRef {
+							for _, vs := range mw.getResources("cf").Virtuals {
+								if vs.VirtualServerName == makeObjectName(uri) {
+									return vs.Profiles
+								}
+							}
+							return nil
+						}
+					}
+					update(routeUpdate.Add, "foo.cf.com", makeEndpoint("127.0.0.1"), "")
+					update(routeUpdate.Add, "bar.cf.com", makeEndpoint("127.0.0.2"), "")
+					update(routeUpdate.Bind, "foo.cf.com", nil, "plan1")
+
+					Eventually(profiles("foo.cf.com")).Should(Equal(append(defaultProfiles(router.c), analytics)))
+					Expect(profiles("bar.cf.com")()).To(Equal(defaultProfiles(router.c)))
+				})
 			})
 
-			It("should create pool resources from plan", func() {
-				monitors := []bigipResources.Monitor{
-					bigipResources.Monitor{
-						Name: "monitor1",
-						Type: "http",
-					},
-					bigipResources.Monitor{
-						Name: "monitor2",
-						Type: "tcp",
-					},
-				}
-				plan.Pool = planResources.PoolType{
-					Balance:        "round-robin",
-					HealthMonitors: monitors,
-				}
-				resources := httpUpdate.CreatePlanResources(c, plan)
+			Context("address translation", func() {
+				It("should enable NAT64 on the virtual", func() {
+					plan.VirtualServer.AddressTranslation = planResources.AddressTranslationNAT64
+					resources := httpUpdate.CreatePlanResources(c, plan)
 
-				Expect(len(resources.Virtuals)).To(Equal(1))
-				Expect(resources.Virtuals[0]).To(Equal(&bigipResources.Virtual{}))
-				Expect(len(resources.Pools)).To(Equal(1))
-				Expect(len(resources.Monitors)).To(Equal(2))
-				Expect(len(resources.Pools[0].MonitorNames)).To(Equal(2))
-				Expect(resources.Pools[0].Balance).To(Equal("round-robin"))
-				Expect(resources.Pools[0].MonitorNames).To(Equal([]string{"/test/monitor1", "/test/monitor2"}))
-				Expect(*resources.Monitors[0]).To(Equal(monitors[0]))
-				Expect(*resources.Monitors[1]).To(Equal(monitors[1]))
+					Expect(resources.Virtuals[0].Nat64).To(Equal("enabled"))
+					data, err := json.Marshal(resources.Virtuals[0])
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(data)).To(ContainSubstring(`"nat64":"enabled"`))
+
+					old := bigipResources.Resources{
+						Virtuals: []*bigipResources.Virtual{&bigipResources.Virtual{VirtualServerName: "foo"}},
+						Pools:    []*bigipResources.Pool{&bigipResources.Pool{Name: "foo"}},
+					}
+					updated := httpUpdate.UpdateResources(old, resources)
+					Expect(updated.Virtuals[0].Nat64).To(Equal("enabled"))
+				})
+
+				It("should not translate addresses by default", func() {
+					for _, mode := range []string{"", planResources.AddressTranslationNone, "nat46"} {
+						plan.VirtualServer.AddressTranslation = mode
+						resources := httpUpdate.CreatePlanResources(c, plan)
+
+						data, err := json.Marshal(resources.Virtuals[0])
+						Expect(err).NotTo(HaveOccurred())
+						Expect(string(data)).NotTo(ContainSubstring("nat64"))
+					}
+				})
 			})
 
-			It("should not create pool resources", func() {
-				plan.Pool = planResources.PoolType{}
-				resources := httpUpdate.CreatePlanResources(c, plan)
+			Context("persistence", func() {
+				It("should attach the fallback along with the persistence profile", func() {
+					plan.VirtualServer.Persistence = "/Common/cookie"
+					plan.VirtualServer.FallbackPersistence = "/Common/source_addr"
+					resources := httpUpdate.CreatePlanResources(c, plan)
 
-				Expect(len(resources.Virtuals)).To(Equal(1))
-				Expect(len(resources.Pools)).To(Equal(1))
-				Expect(len(resources.Monitors)).To(Equal(0))
+					Expect(resources.Virtuals[0].Persistence).To(Equal([]*bigipResources.NameRef{
+						&bigipResources.NameRef{Name: "cookie", Partition: "Common"},
+					}))
+					Expect(resources.Virtuals[0].FallbackPersistence).To(Equal("/Common/source_addr"))
 
-				Expect(resources.Pools[0]).To(Equal(&bigipResources.Pool{}))
-				Expect(resources.Virtuals[0]).To(Equal(&bigipResources.Virtual{}))
+					data, err := json.Marshal(resources.Virtuals[0])
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(data)).To(ContainSubstring(
+						`"persist":[{"name":"cookie","partition":"Common"}],"fallbackPersistence":"/Common/source_addr"`))
+				})
+
+				It("should omit the fallback when it is not configured", func() {
+					plan.VirtualServer.Persistence = "/Common/cookie"
+					resources := httpUpdate.CreatePlanResources(c, plan)
+
+					Expect(resources.Virtuals[0].Persistence).To(HaveLen(1))
+					data, err := json.Marshal(resources.Virtuals[0])
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(data)).NotTo(ContainSubstring("fallbackPersistence"))
+				})
+
+				It("should skip the fallback without a persistence profile", func() {
+					plan.VirtualServer.FallbackPersistence = "/Common/source_addr"
+					resources := httpUpdate.CreatePlanResources(c, plan)
+
+					Expect(resources.Virtuals[0]).To(Equal(&bigipResources.Virtual{}))
+					data, err := json.Marshal(resources.Virtuals[0])
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(data)).NotTo(ContainSubstring("persist"))
+				})
+
+				It("should skip persistence profiles which are not valid", func() {
+					plan.VirtualServer.Persistence = "cookie"
+					plan.VirtualServer.FallbackPersistence = "/Common/source_addr"
+					resources := httpUpdate.CreatePlanResources(c, plan)
+					Expect(resources.Virtuals[0]).To(Equal(&bigipResources.Virtual{}))
+
+					plan.VirtualServer.Persistence = "/Common/cookie"
+					plan.VirtualServer.FallbackPersistence = "source_addr"
+					resources = httpUpdate.CreatePlanResources(c, plan)
+					Expect(resources.Virtuals[0]).To(Equal(&bigipResources.Virtual{}))
+				})
+
+				It("should update the route's persistence", func() {
+					old := bigipResources.Resources{
+						Virtuals: []*bigipResources.Virtual{&bigipResources.Virtual{VirtualServerName: "foo"}},
+						Pools:    []*bigipResources.Pool{&bigipResources.Pool{Name: "foo"}},
+					}
+
+					plan.VirtualServer.Persistence = "/Common/cookie"
+					plan.VirtualServer.FallbackPersistence = "/Common/source_addr"
+					updated := httpUpdate.UpdateResources(old, httpUpdate.CreatePlanResources(c, plan))
+					Expect(updated.Virtuals[0].Persistence).To(HaveLen(1))
+					Expect(updated.Virtuals[0].FallbackPersistence).To(Equal("/Common/source_addr"))
+				})
 			})
 		})
 	})
@@ -547,6 +7631,120 @@ var _ = Describe("F5Router", func() {
 			Eventually(done).Should(BeClosed(), "timed out waiting for Run to complete")
 		})
 
+		It("should write the initial config when started", func() {
+			done := make(chan struct{})
+			os := make(chan os.Signal)
+			ready := make(chan struct{})
+
+			var lock sync.Mutex
+			var writes []string
+			router.OnWrite(func(output []byte, err error) {
+				defer GinkgoRecover()
+				Expect(err).NotTo(HaveOccurred())
+				lock.Lock()
+				defer lock.Unlock()
+				writes = append(writes, string(output))
+			})
+			getWrites := func() []string {
+				lock.Lock()
+				defer lock.Unlock()
+				return append([]string{}, writes...)
+			}
+			// Nothing is written until the router runs
+			Expect(mw.input).To(BeNil())
+
+			go func() {
+				defer GinkgoRecover()
+				err = router.Run(os, ready)
+				Expect(err).NotTo(HaveOccurred())
+				close(done)
+			}()
+			Eventually(ready).Should(BeClosed(), "timed out waiting for ready")
+
+			Expect(getWrites()).To(HaveLen(1))
+			var initial map[string]interface{}
+			Expect(json.Unmarshal([]byte(getWrites()[0]), &initial)).To(Succeed())
+			Expect(initial).To(HaveKey("bigip"))
+			Expect(initial).To(HaveKey("global"))
+			Expect(initial).NotTo(HaveKey("resources"))
+			Expect(string(mw.input)).To(Equal(getWrites()[0]))
+
+			up, _ = NewUpdate(logger, routeUpdate.Add, "foo.cf.com", fooEndpoint, "")
+			router.UpdateRoute(up)
+			Eventually(getWrites).Should(HaveLen(2))
+			Expect(getWrites()[1]).To(ContainSubstring(`"resources"`))
+
+			os <- MockSignal(123)
+			Eventually(done).Should(BeClosed(), "timed out waiting for Run to complete")
+		})
+
+		It("should fail to start when the initial config can't be written", func() {
+			c.InitialWrite.Backoff = time.Millisecond
+			bw := &breakableWriter{}
+			bw.setBroken(true)
+			router, err = NewF5Router(logger, c, bw, client)
+			Expect(err).NotTo(HaveOccurred())
+
+			var writeErr error
+			router.OnWrite(func(output []byte, err error) {
+				writeErr = err
+			})
+			ready := make(chan struct{})
+			err = router.Run(make(chan os.Signal), ready)
+			Expect(err).To(MatchError(ContainSubstring("failed writing initial config")))
+			Expect(err).To(MatchError(ContainSubstring("gave up after 4 attempts")))
+			Expect(writeErr).To(HaveOccurred())
+			Expect(ready).NotTo(BeClosed())
+		})
+
+		It("should retry writing the initial config", func() {
+			c.InitialWrite.Backoff = time.Millisecond
+			mw = &MockWriter{failures: 2}
+			router, err = NewF5Router(logger, c, mw, client)
+			Expect(err).NotTo(HaveOccurred())
+
+			done := make(chan struct{})
+			os := make(chan os.Signal)
+			ready := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				err = router.Run(os, ready)
+				Expect(err).NotTo(HaveOccurred())
+				close(done)
+			}()
+			Eventually(ready).Should(BeClosed(), "timed out waiting for ready")
+			Expect(mw.writes).To(Equal(3))
+			Expect(mw.input).NotTo(BeNil())
+			Eventually(logger).Should(Say("f5router-initial-config-write-retrying"))
+
+			os <- MockSignal(123)
+			Eventually(done).Should(BeClosed(), "timed out waiting for Run to complete")
+		})
+
+		It("should fail to start without retries on the first failed write", func() {
+			c.InitialWrite.Retries = 0
+			mw = &MockWriter{failures: 1}
+			router, err = NewF5Router(logger, c, mw, client)
+			Expect(err).NotTo(HaveOccurred())
+
+			err = router.Run(make(chan os.Signal), make(chan struct{}))
+			Expect(err).To(MatchError("failed writing initial config: mock write failure"))
+			Expect(mw.writes).To(Equal(1))
+		})
+
+		It("should stop retrying the initial config write on a signal", func() {
+			c.InitialWrite.Backoff = time.Minute
+			mw = &MockWriter{failures: 1}
+			router, err = NewF5Router(logger, c, mw, client)
+			Expect(err).NotTo(HaveOccurred())
+
+			os := make(chan os.Signal, 1)
+			os <- MockSignal(123)
+			err = router.Run(os, make(chan struct{}))
+			Expect(err).To(MatchError(ContainSubstring("stopped retrying on signal mock signal")))
+			Expect(mw.writes).To(Equal(1))
+		})
+
 		It("should update routes", func() {
 			done := make(chan struct{})
 			os := make(chan os.Signal)
@@ -649,10 +7847,7 @@ var _ = Describe("F5Router", func() {
 				regularEndpoint1,
 				regularEndpoint2,
 				brokerEndpoint1,
-				brokerEndpoint2,
-				brokerEndpoint3,
-				brokerEndpoint4,
-				brokerEndpoint5 *route.Endpoint
+				brokerEndpoint2 *route.Endpoint
 			)
 
 			BeforeEach(func() {
@@ -660,9 +7855,6 @@ var _ = Describe("F5Router", func() {
 				regularEndpoint2 = makeEndpoint("127.0.0.2")
 				brokerEndpoint1 = makeEndpoint("127.0.1.1")
 				brokerEndpoint2 = makeEndpoint("127.0.1.2")
-				brokerEndpoint3 = makeEndpoint("127.0.1.3")
-				brokerEndpoint4 = makeEndpoint("127.0.1.4")
-				brokerEndpoint5 = makeEndpoint("127.0.1.5")
 
 				// Add broker plans to router
 				plans := make(map[string]planResources.Plan)
@@ -1136,8 +8328,8 @@ var _ = Describe("F5Router", func() {
 				ready := make(chan struct{})
 
 				router, err = NewF5Router(logger, c, mw, client)
-				// Set the current port to the max
-				router.tier2VSInfo.holderPort = 65535
+				// Leave a single port for each address
+				router.tier2VSInfo.minPort = 65535
 
 				go func() {
 					defer GinkgoRecover()
@@ -1157,6 +8349,93 @@ var _ = Describe("F5Router", func() {
 
 			})
 
+			It("should keep running when the writer becomes unusable", func() {
+				done := make(chan struct{})
+				os := make(chan os.Signal)
+				ready := make(chan struct{})
+
+				bw := &breakableWriter{}
+				router, err = NewF5Router(logger, c, bw, client)
+				Expect(err).NotTo(HaveOccurred())
+
+				go func() {
+					defer GinkgoRecover()
+					Expect(func() {
+						err = router.Run(os, ready)
+						Expect(err).NotTo(HaveOccurred())
+						close(done)
+					}).NotTo(Panic())
+				}()
+				Eventually(ready).Should(BeClosed())
+
+				up, _ = NewUpdate(logger, routeUpdate.Add, "foo.cf.com", fooEndpoint, "")
+				router.UpdateRoute(up)
+				Eventually(bw.writes).Should(BeNumerically(">", 1))
+				Expect(router.Healthy()).To(BeTrue())
+
+				bw.setBroken(true)
+				up, _ = NewUpdate(logger, routeUpdate.Add, "bar.cf.com", barEndpoint, "")
+				router.UpdateRoute(up)
+				Eventually(logger).Should(Say("f5router-config-write-error"))
+				Eventually(router.Healthy).Should(BeFalse())
+
+				// The worker retries the write until the writer recovers
+				writes := bw.writes()
+				bw.setBroken(false)
+				Eventually(bw.writes).Should(BeNumerically(">", writes))
+				Eventually(router.Healthy).Should(BeTrue())
+
+				os <- MockSignal(123)
+				Eventually(done).Should(BeClosed(), "timed out waiting for Run to complete")
+			})
+
+			It("should fail the health endpoint while config writes fail", func() {
+				done := make(chan struct{})
+				os := make(chan os.Signal)
+				ready := make(chan struct{})
+
+				bw := &breakableWriter{}
+				router, err = NewF5Router(logger, c, bw, client)
+				Expect(err).NotTo(HaveOccurred())
+				heartbeatOK := int32(1)
+				health := handlers.NewHealthcheck(&heartbeatOK, logger, router.Healthy)
+				status := func() int {
+					resp := httptest.NewRecorder()
+					req := test_util.NewRequest("GET", "example.com", "/health", nil)
+					health.ServeHTTP(resp, req)
+					return resp.Code
+				}
+
+				go func() {
+					defer GinkgoRecover()
+					Expect(func() {
+						err = router.Run(os, ready)
+						Expect(err).NotTo(HaveOccurred())
+						close(done)
+					}).NotTo(Panic())
+				}()
+				Eventually(ready).Should(BeClosed())
+
+				up, _ = NewUpdate(logger, routeUpdate.Add, "foo.cf.com", fooEndpoint, "")
+				router.UpdateRoute(up)
+				Eventually(bw.writes).Should(BeNumerically(">", 1))
+				Expect(status()).To(Equal(http.StatusOK))
+
+				bw.setBroken(true)
+				up, _ = NewUpdate(logger, routeUpdate.Add, "bar.cf.com", barEndpoint, "")
+				router.UpdateRoute(up)
+				// The worker keeps retrying, every failed write leaves it unhealthy
+				for i := 0; i < 3; i++ {
+					Eventually(logger).Should(Say("f5router-config-write-error"))
+					Expect(status()).To(Equal(http.StatusServiceUnavailable))
+				}
+
+				bw.setBroken(false)
+				Eventually(status).Should(Equal(http.StatusOK))
+
+				os <- MockSignal(123)
+				Eventually(done).Should(BeClosed(), "timed out waiting for Run to complete")
+			})
 		})
 		Context("tcp routing", func() {
 			registerTCP := func() {
@@ -1271,6 +8550,9 @@ type testRoutes struct {
 type MockWriter struct {
 	sync.Mutex
 	input []byte
+	// failures counts down the writes returning an error
+	failures int
+	writes   int
 }
 
 type routePair struct {
@@ -1290,7 +8572,13 @@ func (mw *MockWriter) GetOutputFilename() string {
 func (mw *MockWriter) Write(input []byte) (n int, err error) {
 	mw.Lock()
 	defer mw.Unlock()
-	mw.input = input
+	mw.writes++
+	if 0 < mw.failures {
+		mw.failures--
+		return 0, errors.New("mock write failure")
+	}
+	mw.input = make([]byte, len(input))
+	copy(mw.input, input)
 
 	return len(input), nil
 }
@@ -1299,11 +8587,93 @@ func (mw *MockWriter) getInput() *configMatcher {
 	mw.Lock()
 	defer mw.Unlock()
 	var m configMatcher
+	if nil == mw.input {
+		// Nothing is written until the router is running
+		return &m
+	}
 	err := json.Unmarshal(mw.input, &m)
 	Expect(err).To(BeNil())
 	return &m
 }
 
+// getOutput returns the config last written
+func (mw *MockWriter) getOutput() []byte {
+	mw.Lock()
+	defer mw.Unlock()
+	return mw.input
+}
+
+// getResources returns the resources last written for the partition
+func (mw *MockWriter) getResources(partition string) *bigipResources.Resources {
+	if rs, ok := mw.getInput().Resources[partition]; ok {
+		return rs
+	}
+	return &bigipResources.Resources{}
+}
+
+// breakableWriter panics on Write while broken, like a writer whose
+// underlying handle went away
+type breakableWriter struct {
+	sync.Mutex
+	out    *bytes.Buffer
+	count  int
+	broken bool
+}
+
+func (bw *breakableWriter) GetOutputFilename() string {
+	return "breakable-file"
+}
+
+func (bw *breakableWriter) Write(input []byte) (n int, err error) {
+	bw.Lock()
+	defer bw.Unlock()
+	if bw.broken {
+		bw.out = nil
+	} else if nil == bw.out {
+		bw.out = &bytes.Buffer{}
+	}
+	bw.out.Reset()
+	n, err = bw.out.Write(input)
+	bw.count++
+	return n, err
+}
+
+func (bw *breakableWriter) setBroken(broken bool) {
+	bw.Lock()
+	defer bw.Unlock()
+	bw.broken = broken
+}
+
+func (bw *breakableWriter) writes() int {
+	bw.Lock()
+	defer bw.Unlock()
+	return bw.count
+}
+
+// gatedWriter holds every write after the initial config until its gate is
+// opened
+type gatedWriter struct {
+	MockWriter
+	gate chan struct{}
+	once sync.Once
+}
+
+func (gw *gatedWriter) Write(input []byte) (n int, err error) {
+	gw.Lock()
+	initial := 0 == gw.writes
+	gw.Unlock()
+	if !initial {
+		<-gw.gate
+	}
+	return gw.MockWriter.Write(input)
+}
+
+func (gw *gatedWriter) open() {
+	gw.once.Do(func() {
+		close(gw.gate)
+	})
+}
+
 type MockSignal int
 
 func (ms MockSignal) String() string {
@@ -1314,13 +8684,35 @@ func (ms MockSignal) Signal() {
 	return
 }
 
+// runRouter runs the router until the returned function is called
+func runRouter(router *F5Router) func() {
+	done := make(chan struct{})
+	signals := make(chan os.Signal)
+	ready := make(chan struct{})
+
+	go func() {
+		defer GinkgoRecover()
+		Expect(func() {
+			err := router.Run(signals, ready)
+			Expect(err).NotTo(HaveOccurred())
+			close(done)
+		}).NotTo(Panic())
+	}()
+	EventuallyWithOffset(1, ready).Should(BeClosed(), "timed out waiting for ready")
+
+	return func() {
+		signals <- MockSignal(123)
+		EventuallyWithOffset(1, done).Should(BeClosed(), "timed out waiting for Run to complete")
+	}
+}
+
 func makeConfig() *config.Config {
 	c := config.DefaultConfig()
 	c.BigIP.URL = "http://example.com"
 	c.BigIP.User = "admin"
 	c.BigIP.Pass = "pass"
 	c.BigIP.Partitions = []string{"cf"}
-	c.BigIP.ExternalAddr = "127.0.0.1"
+	c.BigIP.ExternalAddr = config.AddressList{"127.0.0.1"}
 	c.BigIP.Tier2IPRange = "10.0.0.1/32"
 
 	return c
@@ -1349,7 +8741,12 @@ func matchConfig(mw *MockWriter, expected []byte, skipBigIPValidation bool) {
 	ExpectWithOffset(1, err).To(BeNil())
 
 	EventuallyWithOffset(1, func() bigipResources.GlobalConfig {
-		return mw.getInput().Global
+		global := mw.getInput().Global
+		// The generation and full sync marker depend on how the updates were
+		// batched into writes
+		global.Generation = 0
+		global.FullSync = false
+		return global
 	}).Should(Equal(matcher.Global))
 
 	if !skipBigIPValidation {
@@ -17,8 +17,13 @@
 package f5router
 
 import (
+	"crypto/sha256"
 	"errors"
 	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/F5Networks/cf-bigip-ctlr/config"
 	"github.com/F5Networks/cf-bigip-ctlr/f5router/bigipResources"
@@ -38,6 +43,15 @@ type updateHTTP struct {
 	name     string
 	protocol string
 	planID   string
+	// partition holding the route objects, set by the router when the
+	// update is processed
+	partition string
+}
+
+// defaultProfiles returns the profiles of a route virtual without a plan
+func defaultProfiles(c *config.Config) []*bigipResources.ProfileRef {
+	profiles := []*bigipResources.ProfileRef{makeHTTPProfile(c), makeTCPProfile(c)}
+	return append(profiles, makeHTTPProfiles(c)...)
 }
 
 func createResources(
@@ -52,34 +66,51 @@ func createResources(
 	var iRule []string
 	rs := bigipResources.Resources{}
 
-	profile := []*bigipResources.ProfileRef{
-		&bigipResources.ProfileRef{
-			Name:      "http",
-			Partition: "Common",
-			Context:   "all",
-		}, &bigipResources.ProfileRef{
-			Name:      "tcp",
-			Partition: "Common",
-			Context:   "all",
-		}}
+	profile := defaultProfiles(c)
+	partition := hu.partitionName(c)
 
 	if c.SessionPersistence {
-		jsessionPath, err := joinBigipPath(c.BigIP.Partitions[0], bigipResources.JsessionidIRuleName)
+		jsessionPath, err := joinBigipPath(partition, bigipResources.JsessionidIRuleName)
 		if nil != err {
 			return rs, err
 		}
 		iRule = append(iRule, jsessionPath)
 	}
+	if "" != c.BigIP.FallbackPool {
+		fallbackPath, err := joinBigipPath(partition, bigipResources.FallbackPoolIRuleName)
+		if nil != err {
+			return rs, err
+		}
+		iRule = append(iRule, fallbackPath)
+	}
 
-	poolPath, err := joinBigipPath(c.BigIP.Partitions[0], hu.name)
+	poolPath, err := joinBigipPath(partition, hu.name)
 	if nil != err {
 		return rs, err
 	}
 
+	var metadata []*bigipResources.Metadata
+	var ratio, connectionLimit, priorityGroup int
+	balance := c.BigIP.LoadBalancingMode
+	mirroring := c.BigIP.ConnectionMirroring
+	persistence := c.BigIP.Persistence.Type
+	monitorType := c.BigIP.HTTPMonitor.Type
+	wafPolicy := defaultWAFPolicy(c)
+	var serverSSL *bigipResources.ProfileRef
 	if hu.endpoint != nil {
-		address = hu.endpoint.Address
+		address = normalizeAddress(hu.endpoint.Address)
 		port = hu.endpoint.Port
 		description = makeDescription(hu.uri.String(), hu.endpoint.ApplicationId)
+		metadata = makeMetadata(c.BigIP.Metadata, hu.uri.String(), hu.endpoint)
+		ratio = hu.routeWeight(c)
+		balance = hu.routeLoadBalancingMode(c)
+		priorityGroup = hu.routePriorityGroup(c)
+		connectionLimit = hu.routeConnectionLimit(c)
+		mirroring = hu.routeConnectionMirroring(c)
+		persistence = hu.routePersistence(c)
+		monitorType = hu.routeMonitorType(c)
+		wafPolicy = hu.routeWAFPolicy(c)
+		serverSSL = hu.routeServerSSL(c)
 	}
 
 	if address == "" || description == "" {
@@ -96,27 +127,555 @@ func createResources(
 		SourceAddress:         c.BigIP.Tier2IPRange,
 		IRules:                iRule,
 		Profiles:              profile,
-		SourceAddrTranslation: bigipResources.SourceAddrTranslation{Type: "automap"},
+		SourceAddrTranslation: makeSourceAddrTranslation(c),
+		ConnectionMirroring:   mirroring,
+		Metadata:              metadata,
+	}
+	err = setRoutePersistence(c, vs, partition, persistence)
+	if nil != err {
+		return rs, err
 	}
+	setWAFPolicy(vs, wafPolicy)
+	setServerSSL(vs, serverSSL)
 
 	rs.Virtuals = append(rs.Virtuals, vs)
+	logHTTPProfiles(hu.logger, c, hu.name)
 
 	member := bigipResources.Member{
-		Address: address,
-		Port:    port,
-		Session: "user-enabled",
+		Address:         address,
+		Port:            port,
+		Session:         "user-enabled",
+		Ratio:           ratio,
+		ConnectionLimit: connectionLimit,
+		PriorityGroup:   priorityGroup,
+		FQDN:            makeMemberFQDN(c, address),
+		Node:            makeMemberNode(c, address),
+	}
+	if ratio != 0 && !isRatioMode(balance) {
+		balance = RatioMemberMode
+	}
+	monitors := fixupNames(c.BigIP.HealthMonitors)
+	if hm := hu.routeHealthCheckMonitor(c, monitorType); nil != hm {
+		hmPath, err := joinBigipPath(monitorPartition(c, partition), hm.Name)
+		if nil != err {
+			return rs, err
+		}
+		rs.Monitors = append(rs.Monitors, hm)
+		monitors = append(monitors, hmPath)
+	} else if "" != c.BigIP.HTTPMonitor.Send {
+		hmName := httpMonitorName(monitorType)
+		if c.BigIP.HTTPMonitor.ContextPath {
+			if path := routeContextPath(hu.uri); "" != path {
+				hm := makeContextPathMonitor(path, monitorType, c.BigIP.HTTPMonitor)
+				hmName = hm.Name
+				rs.Monitors = append(rs.Monitors, hm)
+			}
+		}
+		hmPath, err := joinBigipPath(monitorPartition(c, partition), hmName)
+		if nil != err {
+			return rs, err
+		}
+		monitors = append(monitors, hmPath)
 	}
 	pool := makePool(
 		hu.name,
 		description,
 		[]bigipResources.Member{member},
-		c.BigIP.LoadBalancingMode,
-		fixupNames(c.BigIP.HealthMonitors),
+		balance,
+		monitors,
 	)
+	pool.Metadata = metadata
 	rs.Pools = append(rs.Pools, pool)
 	return rs, nil
 }
 
+// routeHTTPSRedirect returns true when the route's HTTP requests are
+// redirected to HTTPS, the route tag overrides the configured default
+func (hu updateHTTP) routeHTTPSRedirect(c *config.Config) bool {
+	hr := c.BigIP.HTTPSRedirect
+	if hr.Tag == "" {
+		return hr.Enabled
+	}
+	value, ok := hu.endpoint.Tags[hr.Tag]
+	if !ok {
+		return hr.Enabled
+	}
+	redirect, err := strconv.ParseBool(value)
+	if nil != err {
+		hu.logger.Warn("skipping-route-https-redirect",
+			zap.String("route", hu.uri.String()),
+			zap.String("https-redirect", value),
+		)
+		return hr.Enabled
+	}
+	return redirect
+}
+
+// routeServiceURL returns the URL of the route service bound to the route,
+// empty without one or when the URL is not an absolute http or https URL
+func (hu updateHTTP) routeServiceURL() string {
+	if nil == hu.endpoint || "" == hu.endpoint.RouteServiceUrl {
+		return ""
+	}
+	rsURL := hu.endpoint.RouteServiceUrl
+	u, err := url.Parse(rsURL)
+	if nil != err || ("http" != u.Scheme && "https" != u.Scheme) || "" == u.Host {
+		hu.logger.Warn("skipping-route-service",
+			zap.String("route", hu.uri.String()),
+			zap.String("route-service-url", rsURL),
+		)
+		return ""
+	}
+	return rsURL
+}
+
+// routeContextPath returns the path of a context path route, empty for a
+// route on the whole host
+func routeContextPath(uri route.Uri) string {
+	u, err := url.Parse("scheme://" + strings.TrimSuffix(uri.String(), "/"))
+	if nil != err {
+		return ""
+	}
+	return u.EscapedPath()
+}
+
+// makeContextPathMonitor creates the HTTP monitor checking a context path,
+// routes on the same path with the same monitor type share it
+func makeContextPathMonitor(
+	path string,
+	monitorType string,
+	hm config.HTTPMonitorConfig,
+) *bigipResources.Monitor {
+	sum := sha256.Sum256([]byte(path))
+	name := fmt.Sprintf("%s-%x", httpMonitorName(monitorType), sum[:8])
+	return makeHTTPMonitor(name, monitorType, "GET "+path+` HTTP/1.0\r\n\r\n`, hm)
+}
+
+// healthCheckPath matches the health check paths put in a monitor send string
+var healthCheckPath = regexp.MustCompile(`^/[^\s\\]*$`)
+
+// routeHealthCheckMonitor creates the route's own HTTP monitor from the
+// health check path and expect tags of the endpoint, nil without either. The
+// tags replace the request and receive string of the configured monitor,
+// routes with the same values share the monitor.
+func (hu updateHTTP) routeHealthCheckMonitor(
+	c *config.Config,
+	monitorType string,
+) *bigipResources.Monitor {
+	if nil == hu.endpoint {
+		return nil
+	}
+	hm := c.BigIP.HTTPMonitor
+	var path, expect string
+	if "" != hm.PathTag {
+		path = hu.endpoint.Tags[hm.PathTag]
+	}
+	if "" != hm.ExpectTag {
+		expect = hu.endpoint.Tags[hm.ExpectTag]
+	}
+	if "" != path && !healthCheckPath.MatchString(path) {
+		hu.logger.Warn("skipping-route-healthcheck-path",
+			zap.String("route", hu.uri.String()),
+			zap.String("healthcheck-path", path),
+		)
+		path = ""
+	}
+	if "" == path && "" == expect {
+		return nil
+	}
+
+	if "" == path && hm.ContextPath {
+		path = routeContextPath(hu.uri)
+	}
+	send := hm.Send
+	if "" != path {
+		send = "GET " + path + ` HTTP/1.0\r\n\r\n`
+	} else if "" == send {
+		send = `GET / HTTP/1.0\r\n\r\n`
+	}
+	if "" != expect {
+		hm.Recv = expect
+	}
+	sum := sha256.Sum256([]byte(send + "\n" + hm.Recv))
+	name := fmt.Sprintf("%s-%x", httpMonitorName(monitorType), sum[:8])
+	return makeHTTPMonitor(name, monitorType, send, hm)
+}
+
+// routeMonitorType returns the HTTP monitor type from the endpoint's monitor
+// tag, falling back to the configured type. An https monitor needs the server
+// SSL profile so it is only selected when one is configured.
+func (hu updateHTTP) routeMonitorType(c *config.Config) string {
+	hm := c.BigIP.HTTPMonitor
+	if hm.Tag == "" {
+		return hm.Type
+	}
+	monitorType, ok := hu.endpoint.Tags[hm.Tag]
+	if !ok {
+		return hm.Type
+	}
+	if !isMonitorType(monitorType) || (config.MONITOR_HTTPS == monitorType && "" == hm.ServerSSL) {
+		hu.logger.Warn("skipping-route-monitor-type",
+			zap.String("route", hu.uri.String()),
+			zap.String("monitor-type", monitorType),
+		)
+		return hm.Type
+	}
+	return monitorType
+}
+
+// validateHTTPMonitor checks the configured monitor type has what it needs.
+// The interval and timeout are written as configured, they are checked when
+// the send string or the route health check tags can create a monitor.
+func validateHTTPMonitor(hm config.HTTPMonitorConfig) error {
+	monitors := "" != hm.Send || "" != hm.PathTag || "" != hm.ExpectTag
+	if monitors && (hm.Interval < 1 || hm.Timeout <= hm.Interval) {
+		return fmt.Errorf(
+			"http_monitor interval must be positive and less than its timeout: interval %d, timeout %d",
+			hm.Interval, hm.Timeout)
+	}
+	if !isMonitorType(hm.Type) {
+		return fmt.Errorf("invalid http_monitor type %s, allowed values are %s",
+			hm.Type, config.MonitorTypes)
+	}
+	if config.MONITOR_HTTPS == hm.Type && "" == hm.ServerSSL {
+		return errors.New("http_monitor type https requires a server_ssl profile")
+	}
+	return nil
+}
+
+func isMonitorType(monitorType string) bool {
+	for _, t := range config.MonitorTypes {
+		if t == monitorType {
+			return true
+		}
+	}
+	return false
+}
+
+// routeWeight returns the pool member ratio from the endpoint's route weight
+// tag, zero leaves the member at the default equal weighting
+func (hu updateHTTP) routeWeight(c *config.Config) int {
+	if c.BigIP.RouteWeightTag == "" {
+		return 0
+	}
+	weight, ok := hu.endpoint.Tags[c.BigIP.RouteWeightTag]
+	if !ok {
+		return 0
+	}
+	ratio, err := strconv.Atoi(weight)
+	if err != nil || ratio < 1 || ratio > maxMemberRatio {
+		hu.logger.Warn("skipping-route-weight",
+			zap.String("route", hu.uri.String()),
+			zap.String("weight", weight),
+		)
+		return 0
+	}
+	return ratio
+}
+
+// routePriorityGroup returns the pool member priority group from the
+// endpoint's priority group tag, members with a higher group are used first
+func (hu updateHTTP) routePriorityGroup(c *config.Config) int {
+	if c.BigIP.PriorityGroupTag == "" {
+		return 0
+	}
+	value, ok := hu.endpoint.Tags[c.BigIP.PriorityGroupTag]
+	if !ok {
+		return 0
+	}
+	group, err := strconv.Atoi(value)
+	if err != nil || group < 0 || group > maxPriorityGroup {
+		hu.logger.Warn("skipping-route-priority-group",
+			zap.String("route", hu.uri.String()),
+			zap.String("priority-group", value),
+		)
+		return 0
+	}
+	return group
+}
+
+// defaultWAFPolicy returns the WAF policy of the routes without a WAF policy
+// tag, nil when the WAF is not enabled
+func defaultWAFPolicy(c *config.Config) *bigipResources.NameRef {
+	if !c.BigIP.WAF.Enabled {
+		return nil
+	}
+	refs, err := generateNameList([]string{c.BigIP.WAF.Policy})
+	if nil != err {
+		return nil
+	}
+	return refs[0]
+}
+
+// routeWAFPolicy returns the WAF policy from the endpoint's WAF policy tag,
+// none leaves the route without one. Routes without the tag or with an
+// invalid policy name get the configured default.
+func (hu updateHTTP) routeWAFPolicy(c *config.Config) *bigipResources.NameRef {
+	if c.BigIP.WAF.Tag == "" {
+		return defaultWAFPolicy(c)
+	}
+	value, ok := hu.endpoint.Tags[c.BigIP.WAF.Tag]
+	if !ok {
+		return defaultWAFPolicy(c)
+	}
+	if "none" == value {
+		return nil
+	}
+	refs, err := generateNameList([]string{value})
+	if nil != err {
+		hu.logger.Warn("skipping-route-waf-policy",
+			zap.String("route", hu.uri.String()),
+			zap.String("waf-policy", value),
+		)
+		return defaultWAFPolicy(c)
+	}
+	return refs[0]
+}
+
+// setWAFPolicy attaches the WAF policy to the virtual after its other
+// policies, the reference is remembered so it survives a plan replacing them
+func setWAFPolicy(vs *bigipResources.Virtual, waf *bigipResources.NameRef) {
+	vs.WAFPolicy = waf
+	if nil == waf {
+		return
+	}
+	for _, ref := range vs.Policies {
+		if *ref == *waf {
+			return
+		}
+	}
+	vs.Policies = append(vs.Policies, waf)
+}
+
+// routeServerSSL returns the server SSL profile re-encrypting to the route's
+// backends when re-encryption is enabled and they serve TLS. The endpoint's
+// TLS tag marks them, without it or with an invalid value backends on port
+// 443 are TLS.
+func (hu updateHTTP) routeServerSSL(c *config.Config) *bigipResources.ProfileRef {
+	re := c.BigIP.Reencrypt
+	if !re.Enabled {
+		return nil
+	}
+	tls := 443 == hu.endpoint.Port
+	if value, ok := hu.endpoint.Tags[re.Tag]; ok && "" != re.Tag {
+		parsed, err := strconv.ParseBool(value)
+		if nil != err {
+			hu.logger.Warn("skipping-route-tls",
+				zap.String("route", hu.uri.String()),
+				zap.String("tls", value),
+			)
+		} else {
+			tls = parsed
+		}
+	}
+	if !tls {
+		return nil
+	}
+	refs, err := generateProfileList([]string{re.ServerSSL}, "serverside")
+	if nil != err {
+		return nil
+	}
+	return refs[0]
+}
+
+// setServerSSL attaches the server SSL profile to the virtual after its other
+// profiles, the reference is remembered so it survives a plan replacing them
+func setServerSSL(vs *bigipResources.Virtual, serverSSL *bigipResources.ProfileRef) {
+	vs.ServerSSL = serverSSL
+	if nil == serverSSL {
+		return
+	}
+	for _, ref := range vs.Profiles {
+		if *ref == *serverSSL {
+			return
+		}
+	}
+	vs.Profiles = append(vs.Profiles, serverSSL)
+}
+
+// routeConnectionLimit returns the pool member connection limit from the
+// endpoint's connection limit tag, falling back to the configured default.
+// Overrides above the max connection limit, or unlimited, are lowered to it.
+func (hu updateHTTP) routeConnectionLimit(c *config.Config) int {
+	if c.BigIP.ConnectionLimitTag == "" {
+		return c.BigIP.ConnectionLimit
+	}
+	value, ok := hu.endpoint.Tags[c.BigIP.ConnectionLimitTag]
+	if !ok {
+		return c.BigIP.ConnectionLimit
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 0 {
+		hu.logger.Warn("skipping-route-connection-limit",
+			zap.String("route", hu.uri.String()),
+			zap.String("connection-limit", value),
+		)
+		return c.BigIP.ConnectionLimit
+	}
+	if max := c.BigIP.MaxConnectionLimit; 0 != max && (0 == limit || limit > max) {
+		hu.logger.Warn("lowering-route-connection-limit",
+			zap.String("route", hu.uri.String()),
+			zap.Int("connection-limit", limit),
+			zap.Int("max-connection-limit", max),
+		)
+		return max
+	}
+	return limit
+}
+
+// routeConnectionMirroring returns whether the connections of the route are
+// mirrored from the endpoint's connection mirroring tag, falling back to the
+// configured default
+func (hu updateHTTP) routeConnectionMirroring(c *config.Config) bool {
+	if c.BigIP.ConnectionMirroringTag == "" {
+		return c.BigIP.ConnectionMirroring
+	}
+	value, ok := hu.endpoint.Tags[c.BigIP.ConnectionMirroringTag]
+	if !ok {
+		return c.BigIP.ConnectionMirroring
+	}
+	mirroring, err := strconv.ParseBool(value)
+	if err != nil {
+		hu.logger.Warn("skipping-route-connection-mirroring",
+			zap.String("route", hu.uri.String()),
+			zap.String("connection-mirroring", value),
+		)
+		return c.BigIP.ConnectionMirroring
+	}
+	return mirroring
+}
+
+// routeLoadBalancingMode returns the pool load balancing mode from the
+// endpoint's load balancing mode tag, falling back to the configured default
+func (hu updateHTTP) routeLoadBalancingMode(c *config.Config) string {
+	if c.BigIP.LoadBalancingModeTag == "" {
+		return c.BigIP.LoadBalancingMode
+	}
+	mode, ok := hu.endpoint.Tags[c.BigIP.LoadBalancingModeTag]
+	if !ok {
+		return c.BigIP.LoadBalancingMode
+	}
+	if !isLoadBalancingMode(mode) {
+		hu.logger.Warn("skipping-route-load-balancing-mode",
+			zap.String("route", hu.uri.String()),
+			zap.String("mode", mode),
+		)
+		return c.BigIP.LoadBalancingMode
+	}
+	return mode
+}
+
+func isLoadBalancingMode(mode string) bool {
+	for _, m := range LoadBalancingModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// routePersistence returns the persistence type from the endpoint's
+// persistence tag, falling back to the configured type
+func (hu updateHTTP) routePersistence(c *config.Config) string {
+	if c.BigIP.Persistence.Tag == "" {
+		return c.BigIP.Persistence.Type
+	}
+	persistence, ok := hu.endpoint.Tags[c.BigIP.Persistence.Tag]
+	if !ok {
+		return c.BigIP.Persistence.Type
+	}
+	if !isPersistenceType(persistence) {
+		hu.logger.Warn("skipping-route-persistence",
+			zap.String("route", hu.uri.String()),
+			zap.String("persistence", persistence),
+		)
+		return c.BigIP.Persistence.Type
+	}
+	return persistence
+}
+
+// setRoutePersistence attaches the BIG-IP persistence profile of the
+// persistence type along with the iRule in the route's partition applying the
+// configured cookie name or timeout
+func setRoutePersistence(
+	c *config.Config,
+	vs *bigipResources.Virtual,
+	partition string,
+	persistence string,
+) error {
+	var profile, iRuleName string
+	switch persistence {
+	case config.PERSISTENCE_COOKIE:
+		profile = "cookie"
+		if "" != c.BigIP.Persistence.CookieName {
+			iRuleName = bigipResources.CookiePersistenceIRuleName
+		}
+	case config.PERSISTENCE_SOURCE_ADDR:
+		profile = "source_addr"
+		if 0 != c.BigIP.Persistence.Timeout {
+			iRuleName = bigipResources.SourceAddrPersistenceIRuleName
+		}
+	default:
+		return nil
+	}
+
+	vs.Persistence = []*bigipResources.NameRef{{Name: profile, Partition: "Common"}}
+	if "" != iRuleName {
+		iRulePath, err := joinBigipPath(partition, iRuleName)
+		if nil != err {
+			return err
+		}
+		vs.IRules = append(vs.IRules, iRulePath)
+	}
+	return nil
+}
+
+// withoutPersistenceIRules drops the persistence iRules set up by the
+// controller, they only apply along with its own persistence profiles
+func withoutPersistenceIRules(iRules []string) []string {
+	var kept []string
+	for _, path := range iRules {
+		if strings.HasSuffix(path, "/"+bigipResources.CookiePersistenceIRuleName) ||
+			strings.HasSuffix(path, "/"+bigipResources.SourceAddrPersistenceIRuleName) {
+			continue
+		}
+		kept = append(kept, path)
+	}
+	return kept
+}
+
+var persistenceCookieName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// validatePersistence checks the configured persistence type and settings
+func validatePersistence(p config.PersistenceConfig) error {
+	if !isPersistenceType(p.Type) {
+		return fmt.Errorf("invalid persistence type %s, allowed values are %s",
+			p.Type, config.PersistenceTypes)
+	}
+	if "" != p.CookieName && !persistenceCookieName.MatchString(p.CookieName) {
+		return fmt.Errorf("invalid persistence cookie_name %s", p.CookieName)
+	}
+	if p.Timeout < 0 {
+		return fmt.Errorf("persistence timeout must not be negative: %d", p.Timeout)
+	}
+	return nil
+}
+
+func isPersistenceType(persistence string) bool {
+	for _, p := range config.PersistenceTypes {
+		if p == persistence {
+			return true
+		}
+	}
+	return false
+}
+
+// isRatioMode returns true for the load balancing modes which use the member
+// ratios
+func isRatioMode(mode string) bool {
+	return strings.HasPrefix(mode, "ratio-")
+}
+
 // NewUpdate creates a new HTTP route update
 func NewUpdate(
 	logger logger.Logger,
@@ -141,6 +700,14 @@ func NewUpdate(
 			name:     makeObjectName(uri.String()),
 			protocol: "http",
 		}, nil
+	} else if op == routeUpdate.Disable || op == routeUpdate.Enable {
+		return updateHTTP{
+			logger:   l,
+			op:       op,
+			uri:      uri,
+			name:     makeObjectName(uri.String()),
+			protocol: "http",
+		}, nil
 	} else if op == routeUpdate.Bind || op == routeUpdate.Unbind {
 		return updateHTTP{
 			logger:   l,
@@ -155,6 +722,15 @@ func NewUpdate(
 	return updateHTTP{}, fmt.Errorf("unrecognized route update operation: %v ", op)
 }
 
+// partitionName returns the partition holding the route objects, the first
+// configured partition when the update was not placed by the router
+func (hu updateHTTP) partitionName(c *config.Config) string {
+	if "" != hu.partition {
+		return hu.partition
+	}
+	return routeObjectPartitions(c)[0]
+}
+
 // CreateBrokerDefaultResources creates default resources for broker route updates
 func (hu updateHTTP) CreateBrokerDefaultResources(
 	c *config.Config,
@@ -202,10 +778,98 @@ func (hu updateHTTP) CreatePlanResources(c *config.Config, plan planResources.Pl
 		}
 	}
 
+	if plan.VirtualServer.AnalyticsProfile != "" {
+		var analytics []*bigipResources.ProfileRef
+		analytics, err = generateProfileList([]string{plan.VirtualServer.AnalyticsProfile}, "all")
+		if err != nil {
+			hu.logger.Warn("skipping-analytics-profile", zap.Error(err))
+		} else {
+			// Plan profiles replace the defaults, keep them when the plan only
+			// adds the analytics profile
+			if len(plan.VirtualServer.Profiles) == 0 {
+				newProfiles = defaultProfiles(c)
+			}
+			newSslProfiles = append(newSslProfiles, analytics...)
+		}
+	}
+
+	// Plan profiles keep the OneConnect and request logging profiles of the
+	// defaults
+	if len(plan.VirtualServer.Profiles) != 0 {
+		for _, p := range makeHTTPProfiles(c) {
+			if !hasProfile(newProfiles, p) {
+				newProfiles = append(newProfiles, p)
+			}
+		}
+	}
 	newProfiles = append(newProfiles, newSslProfiles...)
 	if len(newProfiles) != 0 {
 		virtual.Profiles = newProfiles
 	}
+	if plan.ExternalPool != "" {
+		var pools []*bigipResources.NameRef
+		pools, err = generateNameList([]string{plan.ExternalPool})
+		if err == nil {
+			virtual.PoolName, err = joinBigipPath(pools[0].Partition, pools[0].Name)
+		}
+		if err != nil {
+			hu.logger.Warn("skipping-external-pool", zap.Error(err))
+		}
+	}
+	if plan.VirtualServer.Persistence != "" || plan.VirtualServer.FallbackPersistence != "" {
+		err = hu.setPersistence(&virtual, plan.VirtualServer)
+		if err != nil {
+			hu.logger.Warn("skipping-persistence-profile", zap.Error(err))
+		}
+	}
+	if plan.VirtualServer.NoMatchAction != "" || len(plan.VirtualServer.HeaderMatches) != 0 {
+		policy, err := hu.makeRoutePolicy(c, plan.VirtualServer)
+		if err != nil {
+			hu.logger.Warn("skipping-route-policy", zap.Error(err))
+		} else {
+			virtual.Policies = append(virtual.Policies, &bigipResources.NameRef{
+				Name:      policy.Name,
+				Partition: hu.partitionName(c),
+			})
+			resources.Policies = append(resources.Policies, policy)
+		}
+	}
+	if plan.VirtualServer.OnPoolDown != nil {
+		iRule, err := hu.makePoolDownIRule(*plan.VirtualServer.OnPoolDown)
+		if err == nil {
+			var iRulePath string
+			iRulePath, err = joinBigipPath(hu.partitionName(c), iRule.Name)
+			if err == nil {
+				virtual.IRules = append(virtual.IRules, iRulePath)
+				resources.IRules = append(resources.IRules, iRule)
+			}
+		}
+		if err != nil {
+			hu.logger.Warn("skipping-pool-down-irule", zap.Error(err))
+		}
+	}
+	switch plan.VirtualServer.AddressTranslation {
+	case "", planResources.AddressTranslationNone:
+	case planResources.AddressTranslationNAT64:
+		virtual.Nat64 = "enabled"
+	default:
+		hu.logger.Warn("skipping-address-translation",
+			zap.String("mode", plan.VirtualServer.AddressTranslation))
+	}
+	if plan.VirtualServer.MaxRequestBytes != 0 || plan.VirtualServer.MaxResponseBytes != 0 {
+		iRule, err := hu.makeSizeLimitIRule(plan.VirtualServer)
+		if err == nil {
+			var iRulePath string
+			iRulePath, err = joinBigipPath(hu.partitionName(c), iRule.Name)
+			if err == nil {
+				virtual.IRules = append(virtual.IRules, iRulePath)
+				resources.IRules = append(resources.IRules, iRule)
+			}
+		}
+		if err != nil {
+			hu.logger.Warn("skipping-size-limit-irule", zap.Error(err))
+		}
+	}
 	resources.Virtuals = append(resources.Virtuals, &virtual)
 
 	// Create bigip pool
@@ -213,6 +877,28 @@ func (hu updateHTTP) CreatePlanResources(c *config.Config, plan planResources.Pl
 	if plan.Pool.Balance != "" {
 		pool.Balance = plan.Pool.Balance
 	}
+	if plan.Pool.ServiceDownAction != "" {
+		if isServiceDownAction(plan.Pool.ServiceDownAction) {
+			pool.ServiceDownAction = plan.Pool.ServiceDownAction
+		} else {
+			hu.logger.Warn("skipping-service-down-action",
+				zap.String("action", plan.Pool.ServiceDownAction))
+		}
+	}
+	if plan.Pool.QueueOnConnectionLimit {
+		if plan.Pool.QueueDepthLimit < 0 || plan.Pool.QueueTimeLimit < 0 {
+			hu.logger.Warn("skipping-connection-queuing",
+				zap.Int("depth-limit", plan.Pool.QueueDepthLimit),
+				zap.Int("time-limit", plan.Pool.QueueTimeLimit))
+		} else {
+			pool.QueueOnConnectionLimit = "enabled"
+			pool.QueueDepthLimit = plan.Pool.QueueDepthLimit
+			pool.QueueTimeLimit = plan.Pool.QueueTimeLimit
+		}
+	} else if plan.Pool.QueueDepthLimit != 0 || plan.Pool.QueueTimeLimit != 0 {
+		hu.logger.Warn("skipping-queue-limits",
+			zap.Error(errors.New("queue limits are only used with queueOnConnectionLimit")))
+	}
 
 	// Create bigip health monitors
 	if len(plan.Pool.HealthMonitors) != 0 {
@@ -224,7 +910,7 @@ func (hu updateHTTP) CreatePlanResources(c *config.Config, plan planResources.Pl
 
 			// Create custom monitor and attach to pool
 			if plan.Pool.HealthMonitors[i].Type != "" {
-				hmName, err := joinBigipPath(c.BigIP.Partitions[0], name)
+				hmName, err := joinBigipPath(monitorPartition(c, hu.partitionName(c)), name)
 				if err != nil {
 					hu.logger.Warn("plan-pool-name-error", zap.Error(err))
 				} else {
@@ -244,6 +930,262 @@ func (hu updateHTTP) CreatePlanResources(c *config.Config, plan planResources.Pl
 	return resources
 }
 
+// makeRoutePolicy creates the route's own policy, forwarding requests with a
+// matching header to the header match pools, forwarding other requests for
+// the route to its pool and applying the no-match action to everything else
+func (hu updateHTTP) makeRoutePolicy(
+	c *config.Config,
+	vs planResources.VirtualType,
+) (*bigipResources.Policy, error) {
+	poolPath, err := joinBigipPath(hu.partitionName(c), hu.name)
+	if nil != err {
+		return nil, err
+	}
+
+	var rules []*bigipResources.Rule
+	for i, hm := range vs.HeaderMatches {
+		rule, err := hu.makeHeaderMatchRule(hm)
+		if nil != err {
+			return nil, err
+		}
+		rule.Name = fmt.Sprintf("%s-header-%d", hu.name, i)
+		rule.Ordinal = i
+		rules = append(rules, rule)
+	}
+
+	conditions, err := makeRouteConditions(hu.uri)
+	if nil != err {
+		return nil, err
+	}
+
+	matchRule := &bigipResources.Rule{
+		FullURI: hu.uri.String(),
+		Actions: []*bigipResources.Action{&bigipResources.Action{
+			Forward: true,
+			Name:    "0",
+			Pool:    poolPath,
+			Request: true,
+		}},
+		Conditions:  conditions,
+		Name:        hu.name,
+		Ordinal:     len(rules),
+		Description: "route " + hu.uri.String(),
+	}
+	rules = append(rules, matchRule)
+
+	if vs.NoMatchAction != "" {
+		defaultAction := bigipResources.Action{
+			Name:    "0",
+			Request: true,
+		}
+		switch vs.NoMatchAction {
+		case planResources.NoMatchReject:
+			defaultAction.Forward = true
+			defaultAction.Reset = true
+		case planResources.NoMatchDrop:
+			defaultAction.Drop = true
+		case planResources.NoMatchDefaultPool:
+			defaultAction.Forward = true
+			defaultAction.Pool = poolPath
+		default:
+			return nil, fmt.Errorf("unsupported no match action: %s", vs.NoMatchAction)
+		}
+
+		rules = append(rules, &bigipResources.Rule{
+			Actions:     []*bigipResources.Action{&defaultAction},
+			Conditions:  []*bigipResources.Condition{},
+			Name:        "no-match",
+			Ordinal:     len(rules),
+			Description: "no match action " + vs.NoMatchAction,
+		})
+	}
+
+	return &bigipResources.Policy{
+		Controls: []string{"forwarding"},
+		Legacy:   true,
+		Name:     hu.name + RoutePolicySuffix,
+		Requires: []string{"http"},
+		Rules:    rules,
+		Strategy: "/Common/first-match",
+	}, nil
+}
+
+// makeHeaderMatchRule creates a rule forwarding requests for the route with a
+// matching header to the header match pool
+func (hu updateHTTP) makeHeaderMatchRule(
+	hm planResources.HeaderMatch,
+) (*bigipResources.Rule, error) {
+	if hm.Header == "" {
+		return nil, errors.New("header match is missing a header name")
+	}
+	pools, err := generateNameList([]string{hm.Pool})
+	if nil != err {
+		return nil, err
+	}
+	poolPath, err := joinBigipPath(pools[0].Partition, pools[0].Name)
+	if nil != err {
+		return nil, err
+	}
+
+	conditions, err := makeRouteConditions(hu.uri)
+	if nil != err {
+		return nil, err
+	}
+	header := &bigipResources.Condition{
+		HTTPHeader: true,
+		TmName:     hm.Header,
+		Name:       strconv.Itoa(len(conditions)),
+		Index:      0,
+		Request:    true,
+	}
+	operator := hm.Operator
+	if operator == "" {
+		operator = planResources.HeaderMatchEquals
+	}
+	switch operator {
+	case planResources.HeaderMatchEquals:
+		if len(hm.Values) == 0 {
+			return nil, fmt.Errorf("header match on %s is missing values", hm.Header)
+		}
+		header.Equals = true
+		header.Values = hm.Values
+	case planResources.HeaderMatchExists:
+		header.Exists = true
+		header.Values = []string{}
+	default:
+		return nil, fmt.Errorf("unsupported header match operator: %s", operator)
+	}
+
+	return &bigipResources.Rule{
+		FullURI: hu.uri.String(),
+		Actions: []*bigipResources.Action{&bigipResources.Action{
+			Forward: true,
+			Name:    "0",
+			Pool:    poolPath,
+			Request: true,
+		}},
+		Conditions:  append(conditions, header),
+		Description: fmt.Sprintf("route %s header %s %s", hu.uri.String(), hm.Header, operator),
+	}, nil
+}
+
+// makePoolDownIRule creates the route's iRule responding to requests when its
+// pool has no available members
+func (hu updateHTTP) makePoolDownIRule(
+	poolDown planResources.PoolDownType,
+) (*bigipResources.IRule, error) {
+	code := poolDown.StatusCode
+	if code != 0 && (code < 100 || code > 599) {
+		return nil, fmt.Errorf("invalid pool down status code: %d", code)
+	}
+
+	var response string
+	switch poolDown.Action {
+	case planResources.PoolDownMaintenance:
+		if code == 0 {
+			code = 503
+		}
+		response = fmt.Sprintf(
+			`HTTP::respond %d content %s "Content-Type" "text/html" "Connection" "Close"`,
+			code,
+			bigipResources.TclQuote(poolDown.Content),
+		)
+	case planResources.PoolDownRedirect:
+		if poolDown.Location == "" {
+			return nil, errors.New("pool down redirect is missing a location")
+		}
+		if code == 0 {
+			code = 302
+		}
+		response = fmt.Sprintf(
+			`HTTP::respond %d "Location" %s "Connection" "Close"`,
+			code,
+			bigipResources.TclQuote(poolDown.Location),
+		)
+	case planResources.PoolDownStatus:
+		if code == 0 {
+			code = 503
+		}
+		response = fmt.Sprintf(`HTTP::respond %d "Connection" "Close"`, code)
+	default:
+		return nil, fmt.Errorf("unsupported pool down action: %s", poolDown.Action)
+	}
+
+	return &bigipResources.IRule{
+		Name: hu.name + bigipResources.PoolDownIRuleSuffix,
+		Code: bigipResources.MakePoolDownIRule(response),
+	}, nil
+}
+
+// makeSizeLimitIRule creates the route's iRule rejecting requests and
+// responses larger than the plan's limits, only bodies with a Content-Length
+// header are checked
+func (hu updateHTTP) makeSizeLimitIRule(
+	vs planResources.VirtualType,
+) (*bigipResources.IRule, error) {
+	if vs.MaxRequestBytes < 0 {
+		return nil, fmt.Errorf("invalid max request bytes: %d", vs.MaxRequestBytes)
+	}
+	if vs.MaxResponseBytes < 0 {
+		return nil, fmt.Errorf("invalid max response bytes: %d", vs.MaxResponseBytes)
+	}
+
+	return &bigipResources.IRule{
+		Name: hu.name + bigipResources.SizeLimitIRuleSuffix,
+		Code: bigipResources.MakeSizeLimitIRule(vs.MaxRequestBytes, vs.MaxResponseBytes),
+	}, nil
+}
+
+// setPersistence sets the route's persistence profile and the fallback used
+// when the primary persistence can't be applied, a fallback is only set along
+// with a primary profile
+func (hu updateHTTP) setPersistence(
+	virtual *bigipResources.Virtual,
+	vs planResources.VirtualType,
+) error {
+	if vs.Persistence == "" {
+		return fmt.Errorf("fallback persistence %s set without a persistence profile",
+			vs.FallbackPersistence)
+	}
+	persistence, err := generateNameList([]string{vs.Persistence})
+	if err != nil {
+		return err
+	}
+	var fallback string
+	if vs.FallbackPersistence != "" {
+		refs, err := generateNameList([]string{vs.FallbackPersistence})
+		if err != nil {
+			return err
+		}
+		fallback, err = joinBigipPath(refs[0].Partition, refs[0].Name)
+		if err != nil {
+			return err
+		}
+	}
+	virtual.Persistence = persistence
+	virtual.FallbackPersistence = fallback
+	return nil
+}
+
+func isServiceDownAction(action string) bool {
+	for _, a := range planResources.ServiceDownActions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// hasProfile returns true when the profile is in the list
+func hasProfile(profiles []*bigipResources.ProfileRef, profile *bigipResources.ProfileRef) bool {
+	for _, p := range profiles {
+		if p.Name == profile.Name && p.Partition == profile.Partition {
+			return true
+		}
+	}
+	return false
+}
+
 // UpdateResources updates old bigip resources into new bigip resources
 func (hu updateHTTP) UpdateResources(
 	oldResources bigipResources.Resources,
@@ -253,11 +1195,30 @@ func (hu updateHTTP) UpdateResources(
 
 	// Update bigip virtual server
 	if len(newResources.Virtuals) != 0 {
+		if newResources.Virtuals[0].PoolName != "" {
+			updatedResources.Virtuals[0].PoolName = newResources.Virtuals[0].PoolName
+		}
 		if len(newResources.Virtuals[0].Profiles) != 0 {
 			updatedResources.Virtuals[0].Profiles = newResources.Virtuals[0].Profiles
+			setServerSSL(updatedResources.Virtuals[0], updatedResources.Virtuals[0].ServerSSL)
 		}
 		if len(newResources.Virtuals[0].Policies) != 0 {
 			updatedResources.Virtuals[0].Policies = newResources.Virtuals[0].Policies
+			setWAFPolicy(updatedResources.Virtuals[0], updatedResources.Virtuals[0].WAFPolicy)
+		}
+		if newResources.Virtuals[0].Nat64 != "" {
+			updatedResources.Virtuals[0].Nat64 = newResources.Virtuals[0].Nat64
+		}
+		if len(newResources.Virtuals[0].Persistence) != 0 {
+			updatedResources.Virtuals[0].Persistence = newResources.Virtuals[0].Persistence
+			updatedResources.Virtuals[0].FallbackPersistence = newResources.Virtuals[0].FallbackPersistence
+			updatedResources.Virtuals[0].IRules = withoutPersistenceIRules(updatedResources.Virtuals[0].IRules)
+		}
+		if len(newResources.Virtuals[0].IRules) != 0 {
+			updatedResources.Virtuals[0].IRules = append(
+				updatedResources.Virtuals[0].IRules,
+				newResources.Virtuals[0].IRules...,
+			)
 		}
 	}
 	// Update bigip pool
@@ -268,11 +1229,27 @@ func (hu updateHTTP) UpdateResources(
 		if len(newResources.Pools[0].MonitorNames) != 0 {
 			updatedResources.Pools[0].MonitorNames = newResources.Pools[0].MonitorNames
 		}
+		if newResources.Pools[0].ServiceDownAction != "" {
+			updatedResources.Pools[0].ServiceDownAction = newResources.Pools[0].ServiceDownAction
+		}
+		if newResources.Pools[0].QueueOnConnectionLimit != "" {
+			updatedResources.Pools[0].QueueOnConnectionLimit = newResources.Pools[0].QueueOnConnectionLimit
+			updatedResources.Pools[0].QueueDepthLimit = newResources.Pools[0].QueueDepthLimit
+			updatedResources.Pools[0].QueueTimeLimit = newResources.Pools[0].QueueTimeLimit
+		}
 	}
 	// Update bigip health monitor
 	if len(newResources.Monitors) != 0 {
 		updatedResources.Monitors = newResources.Monitors
 	}
+	// Update bigip route policy
+	if len(newResources.Policies) != 0 {
+		updatedResources.Policies = newResources.Policies
+	}
+	// Update bigip route iRules
+	if len(newResources.IRules) != 0 {
+		updatedResources.IRules = newResources.IRules
+	}
 
 	return updatedResources
 }
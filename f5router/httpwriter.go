@@ -0,0 +1,157 @@
+/*-
+ * Copyright (c) 2018, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package f5router
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/F5Networks/cf-bigip-ctlr/config"
+	"github.com/F5Networks/cf-bigip-ctlr/logger"
+
+	"github.com/uber-go/zap"
+)
+
+// DefaultHTTPWriterTimeout how long a single config post may take
+const DefaultHTTPWriterTimeout = 10 * time.Second
+
+// HTTPWriter Writer posting the config to an HTTP endpoint, for drivers
+// reading the config from an HTTP source instead of the config file. Posts
+// failing with a connection error or a 5xx or 429 status are retried with a
+// capped backoff, the error is returned once the retries are used up or the
+// writer is stopped.
+type HTTPWriter struct {
+	Client *http.Client
+
+	url        string
+	headers    map[string]string
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	logger     logger.Logger
+	stopped    chan struct{}
+	// sleep waits before a retry, returning false if the writer was stopped
+	sleep func(time.Duration) bool
+}
+
+// NewHTTPWriter creates a writer posting the config as configured
+func NewHTTPWriter(logger logger.Logger, c config.HTTPWriterConfig) *HTTPWriter {
+	hw := &HTTPWriter{
+		Client:     &http.Client{Timeout: DefaultHTTPWriterTimeout},
+		url:        c.URL,
+		headers:    c.Headers,
+		retries:    c.Retries,
+		backoff:    c.Backoff,
+		maxBackoff: c.MaxBackoff,
+		logger:     logger,
+		stopped:    make(chan struct{}),
+	}
+	hw.sleep = hw.wait
+	return hw
+}
+
+// Run implements ifrit.Runner, once signaled the writer stops retrying posts
+// so the router's last writes do not wait out the backoff at shutdown
+func (hw *HTTPWriter) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	close(ready)
+	<-signals
+	close(hw.stopped)
+	return nil
+}
+
+// GetOutputFilename return the URL the config is posted to
+func (hw *HTTPWriter) GetOutputFilename() string {
+	return hw.url
+}
+
+// Write posts the config, retrying transient failures while running
+func (hw *HTTPWriter) Write(input []byte) (n int, err error) {
+	backoff := hw.backoff
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		retry, err = hw.post(input)
+		if nil == err {
+			return len(input), nil
+		}
+		if !retry || attempt == hw.retries {
+			break
+		}
+		hw.logger.Warn("f5router-http-writer-retrying",
+			zap.String("url", hw.url),
+			zap.Int("attempt", attempt+1),
+			zap.Duration("backoff", backoff),
+			zap.Error(err),
+		)
+		if !hw.sleep(backoff) {
+			return 0, fmt.Errorf("%v, stopped retrying on shutdown", err)
+		}
+		backoff *= 2
+		if backoff > hw.maxBackoff {
+			backoff = hw.maxBackoff
+		}
+	}
+	return 0, err
+}
+
+// wait sleeps for the backoff unless the writer is stopped first
+func (hw *HTTPWriter) wait(backoff time.Duration) bool {
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	select {
+	case <-hw.stopped:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// WriteDiff posts the changes since the last config like a full config, the
+// endpoint tells them apart by the changes list in place of the resources
+func (hw *HTTPWriter) WriteDiff(input []byte) (n int, err error) {
+	return hw.Write(input)
+}
+
+// post sends the config once, returning whether a failure is worth retrying
+func (hw *HTTPWriter) post(input []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, hw.url, bytes.NewReader(input))
+	if nil != err {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range hw.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := hw.Client.Do(req)
+	if nil != err {
+		return true, err
+	}
+	// Drain the body so the connection is reused
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("posting config to %s failed with status %d", hw.url, resp.StatusCode)
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, err
+}
@@ -0,0 +1,253 @@
+/*-
+ * Copyright (c) 2018, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package f5router
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"time"
+
+	fakeClient "github.com/F5Networks/cf-bigip-ctlr/bigipclient/fakes"
+	"github.com/F5Networks/cf-bigip-ctlr/config"
+	"github.com/F5Networks/cf-bigip-ctlr/f5router/bigipResources"
+	"github.com/F5Networks/cf-bigip-ctlr/f5router/routeUpdate"
+	"github.com/F5Networks/cf-bigip-ctlr/test_util"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("HTTPWriter", func() {
+	var (
+		logger   *test_util.TestZapLogger
+		server   *httptest.Server
+		mutex    sync.Mutex
+		statuses []int
+		requests []*http.Request
+		bodies   []string
+		sleeps   []time.Duration
+	)
+
+	newWriter := func(c config.HTTPWriterConfig) *HTTPWriter {
+		c.URL = server.URL + "/config"
+		hw := NewHTTPWriter(logger, c)
+		hw.sleep = func(d time.Duration) bool {
+			sleeps = append(sleeps, d)
+			return true
+		}
+		return hw
+	}
+
+	received := func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return bodies
+	}
+
+	BeforeEach(func() {
+		logger = test_util.NewTestZapLogger("http-writer-test")
+		statuses = nil
+		requests = nil
+		bodies = nil
+		sleeps = nil
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			body, _ := ioutil.ReadAll(req.Body)
+			requests = append(requests, req)
+			bodies = append(bodies, string(body))
+			status := http.StatusOK
+			if 0 != len(statuses) {
+				status = statuses[0]
+				statuses = statuses[1:]
+			}
+			w.WriteHeader(status)
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+		if nil != logger {
+			logger.Close()
+		}
+	})
+
+	It("should post the config with the configured headers", func() {
+		hw := newWriter(config.HTTPWriterConfig{
+			Headers: map[string]string{"Authorization": "Bearer secret"},
+		})
+		Expect(hw.GetOutputFilename()).To(Equal(server.URL + "/config"))
+
+		input := []byte(`{"global":{}}`)
+		n, err := hw.Write(input)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(n).To(Equal(len(input)))
+
+		Expect(received()).To(Equal([]string{`{"global":{}}`}))
+		Expect(requests[0].Method).To(Equal(http.MethodPost))
+		Expect(requests[0].URL.Path).To(Equal("/config"))
+		Expect(requests[0].Header.Get("Content-Type")).To(Equal("application/json"))
+		Expect(requests[0].Header.Get("Authorization")).To(Equal("Bearer secret"))
+	})
+
+	It("should retry transient failures with a doubling backoff", func() {
+		statuses = []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}
+		hw := newWriter(config.HTTPWriterConfig{Retries: 3, Backoff: time.Second, MaxBackoff: time.Minute})
+
+		_, err := hw.Write([]byte(`{}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(received()).To(HaveLen(3))
+		Expect(sleeps).To(Equal([]time.Duration{time.Second, 2 * time.Second}))
+		Expect(logger).To(Say(`f5router-http-writer-retrying.*"attempt":1`))
+		Expect(logger).To(Say(`f5router-http-writer-retrying.*"attempt":2`))
+	})
+
+	It("should cap the backoff", func() {
+		statuses = []int{500, 500, 500, 500}
+		hw := newWriter(config.HTTPWriterConfig{Retries: 4, Backoff: time.Second, MaxBackoff: 3 * time.Second})
+
+		_, err := hw.Write([]byte(`{}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sleeps).To(Equal([]time.Duration{
+			time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second,
+		}))
+	})
+
+	It("should stop retrying once signaled", func() {
+		statuses = []int{500, 500}
+		hw := NewHTTPWriter(logger, config.HTTPWriterConfig{
+			URL:        server.URL + "/config",
+			Retries:    3,
+			Backoff:    time.Hour,
+			MaxBackoff: time.Hour,
+		})
+		signals := make(chan os.Signal)
+		ready := make(chan struct{})
+		go hw.Run(signals, ready)
+		Eventually(ready).Should(BeClosed())
+
+		errs := make(chan error, 1)
+		go func() {
+			_, err := hw.Write([]byte(`{}`))
+			errs <- err
+		}()
+		Eventually(logger).Should(Say("f5router-http-writer-retrying"))
+		Consistently(errs).ShouldNot(Receive())
+
+		signals <- MockSignal(123)
+		var err error
+		Eventually(errs).Should(Receive(&err))
+		Expect(err).To(MatchError(ContainSubstring("failed with status 500, stopped retrying on shutdown")))
+
+		// Posts after shutdown are made once
+		_, err = hw.Write([]byte(`{}`))
+		Expect(err).To(MatchError(ContainSubstring("stopped retrying on shutdown")))
+		Expect(received()).To(HaveLen(2))
+	})
+
+	It("should fail once the retries are used up", func() {
+		statuses = []int{500, 502, 503}
+		hw := newWriter(config.HTTPWriterConfig{Retries: 2, Backoff: time.Second})
+
+		n, err := hw.Write([]byte(`{}`))
+		Expect(err).To(MatchError(ContainSubstring("failed with status 503")))
+		Expect(n).To(BeZero())
+		Expect(received()).To(HaveLen(3))
+		Expect(sleeps).To(HaveLen(2))
+	})
+
+	It("should not retry rejected configs", func() {
+		statuses = []int{http.StatusUnauthorized}
+		hw := newWriter(config.HTTPWriterConfig{Retries: 3, Backoff: time.Second})
+
+		_, err := hw.Write([]byte(`{}`))
+		Expect(err).To(MatchError(ContainSubstring("failed with status 401")))
+		Expect(received()).To(HaveLen(1))
+		Expect(sleeps).To(BeEmpty())
+	})
+
+	It("should retry when the endpoint is unreachable", func() {
+		hw := newWriter(config.HTTPWriterConfig{Retries: 1, Backoff: time.Second})
+		server.Close()
+
+		_, err := hw.Write([]byte(`{}`))
+		Expect(err).To(HaveOccurred())
+		Expect(sleeps).To(Equal([]time.Duration{time.Second}))
+	})
+
+	It("should deliver the router config", func() {
+		hw := newWriter(config.HTTPWriterConfig{})
+		router, err := NewF5Router(logger, makeConfig(), hw, &fakeClient.FakeClient{})
+		Expect(err).NotTo(HaveOccurred())
+		stop := runRouter(router)
+		defer stop()
+
+		pools := func() []*bigipResources.Pool {
+			posted := received()
+			var written configMatcher
+			Expect(json.Unmarshal([]byte(posted[len(posted)-1]), &written)).To(Succeed())
+			if rs, ok := written.Resources["cf"]; ok {
+				return rs.Pools
+			}
+			return nil
+		}
+
+		ru, err := NewUpdate(logger, routeUpdate.Add, "foo.cf.com", makeEndpoint("127.0.0.1"), "")
+		Expect(err).NotTo(HaveOccurred())
+		router.UpdateRoute(ru)
+		Eventually(pools).Should(HaveLen(1))
+
+		// A persistent failure is surfaced as a failed config write
+		mutex.Lock()
+		statuses = []int{http.StatusBadRequest}
+		mutex.Unlock()
+		ru, err = NewUpdate(logger, routeUpdate.Add, "bar.cf.com", makeEndpoint("127.0.0.2"), "")
+		Expect(err).NotTo(HaveOccurred())
+		router.UpdateRoute(ru)
+		Eventually(logger).Should(Say(`f5router-config-write-error.*failed with status 400`))
+	})
+
+	It("should post the changed objects with incremental configs", func() {
+		hw := newWriter(config.HTTPWriterConfig{})
+		c := makeConfig()
+		c.BigIP.IncrementalConfig = true
+		router, err := NewF5Router(logger, c, hw, &fakeClient.FakeClient{})
+		Expect(err).NotTo(HaveOccurred())
+		stop := runRouter(router)
+		defer stop()
+		Eventually(received).Should(HaveLen(1))
+
+		for i, addr := range []string{"127.0.0.1", "127.0.0.2"} {
+			ru, err := NewUpdate(logger, routeUpdate.Add, "foo.cf.com", makeEndpoint(addr), "")
+			Expect(err).NotTo(HaveOccurred())
+			router.UpdateRoute(ru)
+			Eventually(received).Should(HaveLen(i + 2))
+		}
+
+		Expect(received()[1]).To(ContainSubstring(`"resources":`))
+		var diff configDiff
+		Expect(json.Unmarshal([]byte(received()[2]), &diff)).To(Succeed())
+		Expect(diff.Changes).To(HaveLen(1))
+		Expect(diff.Changes[0].Kind).To(Equal("pools"))
+		Expect(logger).NotTo(Say("f5router-incremental-config-unsupported"))
+	})
+})
@@ -0,0 +1,149 @@
+/*-
+ * Copyright (c) 2018, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package f5router
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/F5Networks/cf-bigip-ctlr/f5router/routeUpdate"
+)
+
+const (
+	// MetricsEndpointPath path the router metrics are served on
+	MetricsEndpointPath = "/metrics"
+
+	metricsPrefix = "cf_bigip_ctlr_"
+)
+
+// timing running totals of a timed operation, exposed as a summary
+type timing struct {
+	count uint64
+	sum   time.Duration
+}
+
+func (t *timing) observe(d time.Duration) {
+	t.count++
+	t.sum += d
+}
+
+// Metrics counts the router's route updates and config writes and serves them
+// in the Prometheus text format
+type Metrics struct {
+	sync.Mutex
+	routeUpdates map[string]uint64
+	pools        int
+	rules        int
+	marshal      timing
+	write        timing
+	writeErrors  uint64
+}
+
+// NewMetrics creates empty router metrics
+func NewMetrics() *Metrics {
+	return &Metrics{
+		routeUpdates: make(map[string]uint64),
+	}
+}
+
+func (m *Metrics) routeUpdate(op routeUpdate.Operation) {
+	m.Lock()
+	defer m.Unlock()
+	m.routeUpdates[op.String()]++
+}
+
+func (m *Metrics) setResources(pools int, rules int) {
+	m.Lock()
+	defer m.Unlock()
+	m.pools = pools
+	m.rules = rules
+}
+
+func (m *Metrics) observeMarshal(d time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	m.marshal.observe(d)
+}
+
+func (m *Metrics) observeWrite(d time.Duration, err error) {
+	m.Lock()
+	defer m.Unlock()
+	m.write.observe(d)
+	if nil != err {
+		m.writeErrors++
+	}
+}
+
+// ServeHTTP returns the current metrics
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	out := m.text()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	if req.Method == http.MethodGet {
+		w.Write(out)
+	}
+}
+
+// text renders the metrics in the Prometheus text exposition format
+func (m *Metrics) text() []byte {
+	m.Lock()
+	defer m.Unlock()
+
+	var buf bytes.Buffer
+	header := func(name, kind, help string) {
+		fmt.Fprintf(&buf, "# HELP %s%s %s\n", metricsPrefix, name, help)
+		fmt.Fprintf(&buf, "# TYPE %s%s %s\n", metricsPrefix, name, kind)
+	}
+	summary := func(name, help string, t timing) {
+		header(name, "summary", help)
+		fmt.Fprintf(&buf, "%s%s_sum %g\n", metricsPrefix, name, t.sum.Seconds())
+		fmt.Fprintf(&buf, "%s%s_count %d\n", metricsPrefix, name, t.count)
+	}
+
+	header("route_updates_total", "counter", "Route updates received by operation.")
+	ops := make([]string, 0, len(m.routeUpdates))
+	for op := range m.routeUpdates {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	for _, op := range ops {
+		fmt.Fprintf(&buf, "%sroute_updates_total{operation=%q} %d\n",
+			metricsPrefix, op, m.routeUpdates[op])
+	}
+
+	header("pools", "gauge", "Pools managed for routes.")
+	fmt.Fprintf(&buf, "%spools %d\n", metricsPrefix, m.pools)
+	header("rules", "gauge", "Rules of the routing policy.")
+	fmt.Fprintf(&buf, "%srules %d\n", metricsPrefix, m.rules)
+
+	summary("config_marshal_seconds", "Time spent serializing the config.", m.marshal)
+	summary("config_write_seconds", "Time spent writing the config.", m.write)
+	header("config_write_errors_total", "counter", "Config writes that failed.")
+	fmt.Fprintf(&buf, "%sconfig_write_errors_total %d\n", metricsPrefix, m.writeErrors)
+
+	return buf.Bytes()
+}
@@ -0,0 +1,117 @@
+/*-
+ * Copyright (c) 2018, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package f5router
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	fakeClient "github.com/F5Networks/cf-bigip-ctlr/bigipclient/fakes"
+	"github.com/F5Networks/cf-bigip-ctlr/f5router/routeUpdate"
+	"github.com/F5Networks/cf-bigip-ctlr/route"
+	"github.com/F5Networks/cf-bigip-ctlr/test_util"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Metrics", func() {
+	var (
+		logger  *test_util.TestZapLogger
+		router  *F5Router
+		bw      *breakableWriter
+		metrics *Metrics
+		stop    func()
+	)
+
+	scrape := func(method string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, MetricsEndpointPath, nil)
+		metrics.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// update applies the route update and waits for its config write
+	update := func(op routeUpdate.Operation, uri route.Uri, addr string) {
+		ru, err := NewUpdate(logger, op, uri, makeEndpoint(addr), "")
+		Expect(err).NotTo(HaveOccurred())
+		writes := bw.writes()
+		router.UpdateRoute(ru)
+		Eventually(bw.writes).Should(BeNumerically(">", writes))
+	}
+
+	BeforeEach(func() {
+		logger = test_util.NewTestZapLogger("router-test")
+		bw = &breakableWriter{}
+		var err error
+		router, err = NewF5Router(logger, makeConfig(), bw, &fakeClient.FakeClient{})
+		Expect(err).NotTo(HaveOccurred())
+		metrics = NewMetrics()
+		router.SetMetrics(metrics)
+		stop = runRouter(router)
+	})
+
+	AfterEach(func() {
+		stop()
+		if nil != logger {
+			logger.Close()
+		}
+	})
+
+	It("should count route updates and config writes", func() {
+		update(routeUpdate.Add, "foo.cf.com", "127.0.0.1")
+		update(routeUpdate.Add, "bar.cf.com", "127.0.0.2")
+		update(routeUpdate.Remove, "bar.cf.com", "127.0.0.2")
+
+		rec := scrape(http.MethodGet)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Header().Get("Content-Type")).To(Equal("text/plain; version=0.0.4"))
+		body := rec.Body.String()
+		Expect(body).To(ContainSubstring("# TYPE cf_bigip_ctlr_route_updates_total counter\n"))
+		Expect(body).To(ContainSubstring("cf_bigip_ctlr_route_updates_total{operation=\"Add\"} 2\n"))
+		Expect(body).To(ContainSubstring("cf_bigip_ctlr_route_updates_total{operation=\"Remove\"} 1\n"))
+		Expect(body).To(ContainSubstring("cf_bigip_ctlr_pools 1\n"))
+		Expect(body).To(ContainSubstring("cf_bigip_ctlr_rules 1\n"))
+		Expect(body).To(ContainSubstring("cf_bigip_ctlr_config_marshal_seconds_count 3\n"))
+		Expect(body).To(ContainSubstring("cf_bigip_ctlr_config_write_seconds_count 3\n"))
+		Expect(body).To(ContainSubstring("cf_bigip_ctlr_config_write_errors_total 0\n"))
+	})
+
+	It("should count failed config writes", func() {
+		bw.setBroken(true)
+		defer bw.setBroken(false)
+		ru, err := NewUpdate(logger, routeUpdate.Add, "foo.cf.com", makeEndpoint("127.0.0.1"), "")
+		Expect(err).NotTo(HaveOccurred())
+		router.UpdateRoute(ru)
+
+		Eventually(func() string {
+			return scrape(http.MethodGet).Body.String()
+		}).Should(MatchRegexp("cf_bigip_ctlr_config_write_errors_total [1-9][0-9]*\n"))
+		Expect(scrape(http.MethodGet).Body.String()).To(
+			MatchRegexp("cf_bigip_ctlr_config_write_seconds_count [1-9][0-9]*\n"))
+	})
+
+	It("should only serve GET and HEAD", func() {
+		rec := scrape(http.MethodHead)
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Body.Len()).To(BeZero())
+
+		rec = scrape(http.MethodPost)
+		Expect(rec.Code).To(Equal(http.StatusMethodNotAllowed))
+		Expect(rec.Header().Get("Allow")).To(Equal("GET, HEAD"))
+	})
+})
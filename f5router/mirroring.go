@@ -0,0 +1,51 @@
+/*-
+ * Copyright (c) 2018, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package f5router
+
+import (
+	"encoding/json"
+
+	"github.com/uber-go/zap"
+)
+
+// checkConnectionMirroring warns when connection mirroring is configured on
+// a BIG-IP without a peer device to mirror the connections to, the virtuals
+// are still written with mirroring so a single device keeps working
+func (r *F5Router) checkConnectionMirroring() {
+	if !r.c.BigIP.ConnectionMirroring || nil == r.bigIPClient {
+		return
+	}
+
+	data, err := r.getBigIP(r.c.BigIP.URL + "/mgmt/tm/cm/device")
+	if nil != err {
+		r.logger.Warn("f5router-connection-mirroring-ha-check-failed", zap.Error(err))
+		return
+	}
+	var devices struct {
+		Items []json.RawMessage `json:"items"`
+	}
+	err = json.Unmarshal(data, &devices)
+	if nil != err {
+		r.logger.Warn("f5router-connection-mirroring-ha-check-failed", zap.Error(err))
+		return
+	}
+	if len(devices.Items) < 2 {
+		r.logger.Warn("f5router-connection-mirroring-single-device",
+			zap.Int("devices", len(devices.Items)),
+		)
+	}
+}
@@ -0,0 +1,514 @@
+/*-
+ * Copyright (c) 2018, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package f5router
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/F5Networks/cf-bigip-ctlr/config"
+	"github.com/F5Networks/cf-bigip-ctlr/f5router/bigipResources"
+)
+
+// Placeholders marshaled in place of values which change without the rest of
+// the object changing, quotes are escaped inside strings so these can only
+// appear once in the marshaled object
+var (
+	ruleOrdinalMarker = []byte(`,"ordinal":0,`)
+	policyRulesMarker = []byte(`,"rules":[],`)
+)
+
+type (
+	// resourceCache keeps the marshaled form of resources between config
+	// writes so only the resources touched by route updates are marshaled
+	// again, the output is the same as marshaling the resources directly.
+	// The sections of the partitions are written by up to workers goroutines,
+	// lock guards the entries while they do.
+	resourceCache struct {
+		generation uint64
+		entries    map[cacheKey]*cacheEntry
+		workers    int
+		lock       sync.Mutex
+		// bufs holds the output of the sections, reused between writes
+		bufs []*bytes.Buffer
+	}
+
+	// section is one field of a partition's resources, written on its own so
+	// the sections can be written concurrently
+	section struct {
+		name  string
+		write func(buf *bytes.Buffer) error
+	}
+
+	// cacheKey identifies a resource, scope is the partition holding virtuals,
+	// pools and policies or the policy or data group holding rules and records
+	cacheKey struct {
+		kind  string
+		scope string
+		name  string
+	}
+
+	// cacheEntry holds the JSON of obj, split around a marker if one was used
+	cacheEntry struct {
+		obj        interface{}
+		prefix     []byte
+		suffix     []byte
+		generation uint64
+	}
+)
+
+func newResourceCache() *resourceCache {
+	return &resourceCache{
+		entries: make(map[cacheKey]*cacheEntry),
+		workers: runtime.GOMAXPROCS(0),
+	}
+}
+
+func virtualCacheKey(partition string, name string) cacheKey {
+	return cacheKey{kind: "virtual", scope: partition, name: name}
+}
+
+func poolCacheKey(partition string, name string) cacheKey {
+	return cacheKey{kind: "pool", scope: partition, name: name}
+}
+
+// invalidate drops the cached JSON for an object which was updated in place
+func (rc *resourceCache) invalidate(key cacheKey) {
+	delete(rc.entries, key)
+}
+
+// sweep drops the entries which were not written since the last sweep
+func (rc *resourceCache) sweep() {
+	for key, entry := range rc.entries {
+		if entry.generation != rc.generation {
+			delete(rc.entries, key)
+		}
+	}
+	rc.generation++
+}
+
+// lookup returns the entry for key if it was created from obj
+func (rc *resourceCache) lookup(key cacheKey, obj interface{}) *cacheEntry {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	entry, ok := rc.entries[key]
+	if ok && entry.obj == obj {
+		entry.generation = rc.generation
+		return entry
+	}
+	return nil
+}
+
+func (rc *resourceCache) store(
+	key cacheKey,
+	obj interface{},
+	data []byte,
+	marker []byte,
+) (*cacheEntry, error) {
+	entry := &cacheEntry{
+		obj:        obj,
+		prefix:     data,
+		generation: rc.generation,
+	}
+	if nil != marker {
+		i := bytes.Index(data, marker)
+		if -1 == i {
+			return nil, fmt.Errorf("marshaled %s %s is missing marker %s", key.kind, key.name, marker)
+		}
+		entry.prefix = data[:i]
+		entry.suffix = data[i+len(marker):]
+	}
+	rc.lock.Lock()
+	rc.entries[key] = entry
+	rc.lock.Unlock()
+	return entry, nil
+}
+
+// write appends the JSON of obj to buf, marshaling it only when it changed
+func (rc *resourceCache) write(buf *bytes.Buffer, key cacheKey, obj interface{}) error {
+	entry := rc.lookup(key, obj)
+	if nil == entry {
+		data, err := json.Marshal(obj)
+		if nil != err {
+			return err
+		}
+		entry, err = rc.store(key, obj, data, nil)
+		if nil != err {
+			return err
+		}
+	}
+	buf.Write(entry.prefix)
+	return nil
+}
+
+// writeRule appends the JSON of a rule, the ordinal changes whenever rules are
+// added ahead of it so it is written separately from the cached rule
+func (rc *resourceCache) writeRule(buf *bytes.Buffer, key cacheKey, rule *bigipResources.Rule) error {
+	entry := rc.lookup(key, rule)
+	if nil == entry {
+		rl := *rule
+		rl.Ordinal = 0
+		data, err := json.Marshal(&rl)
+		if nil != err {
+			return err
+		}
+		entry, err = rc.store(key, rule, data, ruleOrdinalMarker)
+		if nil != err {
+			return err
+		}
+	}
+	buf.Write(entry.prefix)
+	buf.WriteString(`,"ordinal":`)
+	buf.WriteString(strconv.Itoa(rule.Ordinal))
+	buf.WriteByte(',')
+	buf.Write(entry.suffix)
+	return nil
+}
+
+// writePolicy appends the JSON of a policy with each of its rules cached
+func (rc *resourceCache) writePolicy(
+	buf *bytes.Buffer,
+	partition string,
+	policy *bigipResources.Policy,
+) error {
+	key := cacheKey{kind: "policy", scope: partition, name: policy.Name}
+	entry := rc.lookup(key, policy)
+	if nil == entry {
+		plcy := *policy
+		plcy.Rules = []*bigipResources.Rule{}
+		data, err := json.Marshal(&plcy)
+		if nil != err {
+			return err
+		}
+		entry, err = rc.store(key, policy, data, policyRulesMarker)
+		if nil != err {
+			return err
+		}
+	}
+	buf.Write(entry.prefix)
+	if nil == policy.Rules {
+		buf.WriteString(`,"rules":null,`)
+	} else {
+		buf.WriteString(`,"rules":`)
+		scope, err := joinBigipPath(partition, policy.Name)
+		if nil != err {
+			return err
+		}
+		err = writeList(buf, len(policy.Rules), func(i int) error {
+			rule := policy.Rules[i]
+			return rc.writeRule(buf, cacheKey{kind: "rule", scope: scope, name: rule.Name}, rule)
+		})
+		if nil != err {
+			return err
+		}
+		buf.WriteByte(',')
+	}
+	buf.Write(entry.suffix)
+	return nil
+}
+
+// resourceField is a field of bigipResources.Resources with the name and
+// omitempty option of its JSON tag
+type resourceField struct {
+	name      string
+	index     int
+	omitEmpty bool
+}
+
+// resourceFields holds the marshaled fields of bigipResources.Resources in
+// field order, the sections of a partition are derived from them so a field
+// added to Resources is written like encoding/json would write it
+var resourceFields = func() []resourceField {
+	var fields []resourceField
+	t := reflect.TypeOf(bigipResources.Resources{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if "-" == tag || "" != f.PkgPath {
+			continue
+		}
+		opts := strings.Split(tag, ",")
+		field := resourceField{name: opts[0], index: i}
+		if "" == field.name {
+			field.name = f.Name
+		}
+		for _, opt := range opts[1:] {
+			if "omitempty" == opt {
+				field.omitEmpty = true
+			}
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}()
+
+// isEmptyValue reports whether encoding/json omits v from an omitempty field
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// sections returns the marshaled fields of a partition's resources
+func (rc *resourceCache) sections(partition string, rs *bigipResources.Resources) []section {
+	var sections []section
+	v := reflect.ValueOf(rs).Elem()
+	for _, field := range resourceFields {
+		value := v.Field(field.index)
+		if field.omitEmpty && isEmptyValue(value) {
+			continue
+		}
+		sections = append(sections, section{field.name, rc.sectionWriter(partition, value.Interface())})
+	}
+	return sections
+}
+
+// sectionWriter returns the writer of a section, the kinds of resources which
+// change one at a time are written from the cache and the rest are marshaled
+func (rc *resourceCache) sectionWriter(partition string, resources interface{}) func(buf *bytes.Buffer) error {
+	switch rs := resources.(type) {
+	case []*bigipResources.Virtual:
+		if nil != rs {
+			return func(buf *bytes.Buffer) error {
+				return writeList(buf, len(rs), func(i int) error {
+					return rc.write(buf, virtualCacheKey(partition, rs[i].VirtualServerName), rs[i])
+				})
+			}
+		}
+	case []*bigipResources.Pool:
+		if nil != rs {
+			return func(buf *bytes.Buffer) error {
+				return writeList(buf, len(rs), func(i int) error {
+					return rc.write(buf, poolCacheKey(partition, rs[i].Name), rs[i])
+				})
+			}
+		}
+	case []*bigipResources.Policy:
+		if nil != rs {
+			return func(buf *bytes.Buffer) error {
+				return writeList(buf, len(rs), func(i int) error {
+					return rc.writePolicy(buf, partition, rs[i])
+				})
+			}
+		}
+	case []*bigipResources.InternalDataGroup:
+		if nil != rs {
+			return func(buf *bytes.Buffer) error {
+				return writeList(buf, len(rs), func(i int) error {
+					return rc.writeInternalDataGroup(buf, rs[i])
+				})
+			}
+		}
+	}
+	return func(buf *bytes.Buffer) error {
+		return writeMarshaled(buf, resources)
+	}
+}
+
+// writeSections writes every section into its own buffer, the sections are
+// spread across the workers when there is more than one
+func (rc *resourceCache) writeSections(sections []section) ([]*bytes.Buffer, error) {
+	for len(rc.bufs) < len(sections) {
+		rc.bufs = append(rc.bufs, &bytes.Buffer{})
+	}
+	bufs := rc.bufs[:len(sections)]
+	for _, buf := range bufs {
+		buf.Reset()
+	}
+	errs := make([]error, len(sections))
+
+	workers := rc.workers
+	if workers > len(sections) {
+		workers = len(sections)
+	}
+	if workers <= 1 {
+		for i, s := range sections {
+			if err := s.write(bufs[i]); nil != err {
+				return nil, err
+			}
+		}
+		return bufs, nil
+	}
+
+	next := make(chan int, len(sections))
+	for i := range sections {
+		next <- i
+	}
+	close(next)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				errs[i] = sections[i].write(bufs[i])
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if nil != err {
+			return nil, err
+		}
+	}
+	return bufs, nil
+}
+
+func (rc *resourceCache) writeInternalDataGroup(
+	buf *bytes.Buffer,
+	dg *bigipResources.InternalDataGroup,
+) error {
+	buf.WriteString(`{"name":`)
+	if err := writeMarshaled(buf, dg.Name); nil != err {
+		return err
+	}
+	buf.WriteString(`,"records":`)
+	if nil == dg.Records {
+		buf.WriteString("null")
+	} else {
+		err := writeList(buf, len(dg.Records), func(i int) error {
+			record := dg.Records[i]
+			return rc.write(buf, cacheKey{kind: "record", scope: dg.Name, name: record.Name}, record)
+		})
+		if nil != err {
+			return err
+		}
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+// writeConfig appends the config sections for the driver, the same output as
+// marshaling a map of the bigip, global and resources sections
+func (rc *resourceCache) writeConfig(
+	buf *bytes.Buffer,
+	bigip config.BigIPConfig,
+	global bigipResources.GlobalConfig,
+	pm bigipResources.PartitionMap,
+) error {
+	buf.WriteString(`{"bigip":`)
+	if err := writeMarshaled(buf, bigip); nil != err {
+		return err
+	}
+	buf.WriteString(`,"global":`)
+	if err := writeMarshaled(buf, global); nil != err {
+		return err
+	}
+	buf.WriteString(`,"resources":`)
+	if nil == pm {
+		buf.WriteString("null")
+	} else {
+		partitions := make([]string, 0, len(pm))
+		for partition := range pm {
+			partitions = append(partitions, partition)
+		}
+		sort.Strings(partitions)
+
+		// The sections of every partition are written first, then put
+		// together in the partition and field order
+		var all []section
+		counts := make([]int, len(partitions))
+		for i, partition := range partitions {
+			if nil != pm[partition] {
+				sections := rc.sections(partition, pm[partition])
+				counts[i] = len(sections)
+				all = append(all, sections...)
+			}
+		}
+		bufs, err := rc.writeSections(all)
+		if nil != err {
+			return err
+		}
+
+		buf.WriteByte('{')
+		for i, partition := range partitions {
+			if 0 != i {
+				buf.WriteByte(',')
+			}
+			if err := writeMarshaled(buf, partition); nil != err {
+				return err
+			}
+			buf.WriteByte(':')
+			if nil == pm[partition] {
+				buf.WriteString("null")
+				continue
+			}
+			buf.WriteByte('{')
+			for j := 0; j < counts[i]; j++ {
+				if 0 != j {
+					buf.WriteByte(',')
+				}
+				buf.WriteByte('"')
+				buf.WriteString(all[0].name)
+				buf.WriteString(`":`)
+				buf.Write(bufs[0].Bytes())
+				all, bufs = all[1:], bufs[1:]
+			}
+			buf.WriteByte('}')
+		}
+		buf.WriteByte('}')
+	}
+	buf.WriteByte('}')
+
+	rc.sweep()
+	return nil
+}
+
+// writeMarshaled appends the JSON of a value which is not cached
+func writeMarshaled(buf *bytes.Buffer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if nil != err {
+		return err
+	}
+	buf.Write(data)
+	return nil
+}
+
+// writeList appends a JSON array of n items written by item
+func writeList(buf *bytes.Buffer, n int, item func(i int) error) error {
+	buf.WriteByte('[')
+	for i := 0; i < n; i++ {
+		if 0 != i {
+			buf.WriteByte(',')
+		}
+		if err := item(i); nil != err {
+			return err
+		}
+	}
+	buf.WriteByte(']')
+	return nil
+}
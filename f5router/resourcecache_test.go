@@ -0,0 +1,287 @@
+/*-
+ * Copyright (c) 2018, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package f5router
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+
+	fakeClient "github.com/F5Networks/cf-bigip-ctlr/bigipclient/fakes"
+	"github.com/F5Networks/cf-bigip-ctlr/f5router/bigipResources"
+	"github.com/F5Networks/cf-bigip-ctlr/f5router/routeUpdate"
+	"github.com/F5Networks/cf-bigip-ctlr/route"
+	"github.com/F5Networks/cf-bigip-ctlr/servicebroker/planResources"
+	"github.com/F5Networks/cf-bigip-ctlr/test_util"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("resourceCache", func() {
+	var (
+		logger *test_util.TestZapLogger
+		mw     *MockWriter
+		router *F5Router
+		stop   func()
+	)
+
+	// expectSameOutput checks the written config against marshaling the
+	// same resources directly
+	expectSameOutput := func() {
+		output := mw.getOutput()
+		var written configMatcher
+		err := json.Unmarshal(output, &written)
+		Expect(err).NotTo(HaveOccurred())
+
+		expected, err := json.Marshal(map[string]interface{}{
+			"global":    written.Global,
+			"bigip":     written.BigIP,
+			"resources": written.Resources,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(output)).To(Equal(string(expected)))
+	}
+
+	// members returns the written members of the route pool
+	members := func(uri route.Uri) func() []string {
+		return func() []string {
+			var addrs []string
+			for _, pool := range mw.getResources("cf").Pools {
+				if pool.Name != makeObjectName(string(uri)) {
+					continue
+				}
+				for _, m := range pool.Members {
+					addrs = append(addrs, m.Address)
+				}
+			}
+			return addrs
+		}
+	}
+
+	// policies returns the written policies of the route virtual
+	policies := func(uri route.Uri) func() []string {
+		return func() []string {
+			var names []string
+			for _, vs := range mw.getResources("cf").Virtuals {
+				if vs.VirtualServerName != makeObjectName(string(uri)) {
+					continue
+				}
+				for _, p := range vs.Policies {
+					names = append(names, p.Name)
+				}
+			}
+			return names
+		}
+	}
+
+	update := func(op routeUpdate.Operation, uri route.Uri, ep *route.Endpoint, planID string) {
+		ru, err := NewUpdate(logger, op, uri, ep, planID)
+		Expect(err).NotTo(HaveOccurred())
+		router.UpdateRoute(ru)
+	}
+
+	BeforeEach(func() {
+		logger = test_util.NewTestZapLogger("resource-cache-test")
+		c := makeConfig()
+		c.BigIP.Metadata = []string{MetadataRouteKey}
+		mw = &MockWriter{}
+
+		var err error
+		router, err = NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+		Expect(err).NotTo(HaveOccurred())
+		router.AddPlans(map[string]planResources.Plan{
+			"plan1": planResources.Plan{
+				ID: "plan1",
+				VirtualServer: planResources.VirtualType{
+					NoMatchAction: planResources.NoMatchReject,
+				},
+			},
+		})
+		stop = runRouter(router)
+	})
+
+	AfterEach(func() {
+		stop()
+		if nil != logger {
+			logger.Close()
+		}
+	})
+
+	It("should match marshaling the resources directly", func() {
+		update(routeUpdate.Add, "foo.cf.com", makeEndpoint("127.0.0.1"), "")
+		update(routeUpdate.Add, "bar.cf.com/path", makeEndpoint("127.0.0.2"), "")
+		update(routeUpdate.Add, "*.cf.com", makeEndpoint("127.0.0.3"), "")
+		Eventually(members("*.cf.com")).Should(Equal([]string{"127.0.0.3"}))
+		expectSameOutput()
+
+		// Pool updated in place, the other resources come from the cache
+		update(routeUpdate.Add, "foo.cf.com", makeEndpoint("127.0.0.4"), "")
+		Eventually(members("foo.cf.com")).Should(Equal([]string{"127.0.0.1", "127.0.0.4"}))
+		expectSameOutput()
+
+		// Rule ordinals shift when rules are added ahead of existing ones
+		update(routeUpdate.Add, "zoo.cf.com", makeEndpoint("127.0.0.5"), "")
+		Eventually(members("zoo.cf.com")).Should(Equal([]string{"127.0.0.5"}))
+		expectSameOutput()
+
+		// Virtual updated in place with a new policy
+		update(routeUpdate.Bind, "bar.cf.com/path", nil, "plan1")
+		Eventually(policies("bar.cf.com/path")).ShouldNot(BeEmpty())
+		expectSameOutput()
+
+		update(routeUpdate.Remove, "foo.cf.com", makeEndpoint("127.0.0.1"), "")
+		update(routeUpdate.Remove, "zoo.cf.com", makeEndpoint("127.0.0.5"), "")
+		Eventually(members("zoo.cf.com")).Should(BeEmpty())
+		Expect(members("foo.cf.com")()).To(Equal([]string{"127.0.0.4"}))
+		expectSameOutput()
+
+		update(routeUpdate.Unbind, "bar.cf.com/path", nil, "")
+		Eventually(policies("bar.cf.com/path")).Should(BeEmpty())
+		expectSameOutput()
+	})
+
+	It("should match marshaling the resources of the expected configs", func() {
+		Expect(expectedConfigs).NotTo(BeEmpty())
+		cache := newResourceCache()
+		for i, fixture := range expectedConfigs {
+			var config configMatcher
+			Expect(json.Unmarshal(fixture, &config)).To(Succeed(), "config %d", i)
+			expected, err := json.Marshal(map[string]interface{}{
+				"bigip":     config.BigIP,
+				"global":    config.Global,
+				"resources": config.Resources,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			// The second write comes from the entries of the first
+			for j := 0; j < 2; j++ {
+				var buf bytes.Buffer
+				err = cache.writeConfig(&buf, config.BigIP, config.Global, config.Resources)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(buf.String()).To(Equal(string(expected)), "config %d", i)
+			}
+		}
+	})
+
+	It("should keep objects of different partitions apart", func() {
+		stop()
+		c := makeConfig()
+		c.BigIP.Partitions = []string{"cf", "cf-apps"}
+		c.BigIP.HTTPMonitor.Send = "GET / HTTP/1.0\\r\\n\\r\\n"
+		mw = &MockWriter{}
+		var err error
+		router, err = NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+		Expect(err).NotTo(HaveOccurred())
+		stop = runRouter(router)
+
+		appMembers := func() []string {
+			var addrs []string
+			for _, pool := range mw.getResources("cf-apps").Pools {
+				for _, m := range pool.Members {
+					addrs = append(addrs, m.Address)
+				}
+			}
+			return addrs
+		}
+
+		ep := makeEndpoint("127.0.0.1")
+		ep.Tags = map[string]string{"partition": "cf-apps"}
+		update(routeUpdate.Add, "foo.cf.com", ep, "")
+		update(routeUpdate.Add, "bar.cf.com", makeEndpoint("127.0.0.2"), "")
+		Eventually(members("bar.cf.com")).Should(Equal([]string{"127.0.0.2"}))
+		Expect(appMembers()).To(Equal([]string{"127.0.0.1"}))
+		expectSameOutput()
+
+		update(routeUpdate.Add, "foo.cf.com", makeEndpoint("127.0.0.3"), "")
+		Eventually(appMembers).Should(Equal([]string{"127.0.0.1", "127.0.0.3"}))
+		expectSameOutput()
+		stop()
+		stop = func() {}
+
+		name := makeObjectName("foo.cf.com")
+		Expect(router.cache.entries).To(HaveKey(poolCacheKey("cf-apps", name)))
+		Expect(router.cache.entries).NotTo(HaveKey(poolCacheKey("cf", name)))
+	})
+
+	It("should write the same output with parallel workers", func() {
+		stop()
+		c := makeConfig()
+		partitions := []string{"cf", "cf-apps", "cf-other"}
+		c.BigIP.Partitions = partitions
+		c.BigIP.Tier2IPRange = "10.0.0.0/16"
+		mw = &MockWriter{}
+		var err error
+		router, err = NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+		Expect(err).NotTo(HaveOccurred())
+		router.cache.workers = 8
+		stop = runRouter(router)
+
+		for i := 0; i < 200; i++ {
+			uri := route.Uri(fmt.Sprintf("app-%d.cf.com", i))
+			if 0 == i%10 {
+				uri = route.Uri(fmt.Sprintf("*.app-%d.cf.com", i))
+			}
+			ep := makeEndpoint("127.0.0.1")
+			ep.Tags = map[string]string{"partition": partitions[i%len(partitions)]}
+			update(routeUpdate.Add, uri, ep, "")
+		}
+		Eventually(func() int {
+			var pools int
+			for _, partition := range partitions {
+				pools += len(mw.getResources(partition).Pools)
+			}
+			return pools
+		}).Should(Equal(200))
+		expectSameOutput()
+		stop()
+		stop = func() {}
+
+		pm := mw.getInput().Resources
+		write := func(workers int) [sha256.Size]byte {
+			router.cache.workers = workers
+			var buf bytes.Buffer
+			err := router.cache.writeConfig(&buf, router.c.BigIP, bigipResources.GlobalConfig{}, pm)
+			Expect(err).NotTo(HaveOccurred())
+			return sha256.Sum256(buf.Bytes())
+		}
+		serial := write(1)
+		// Once from an empty cache and once from the cached entries
+		router.cache = newResourceCache()
+		Expect(write(8)).To(Equal(serial))
+		Expect(write(8)).To(Equal(serial))
+		Expect(write(1)).To(Equal(serial))
+	})
+
+	It("should drop entries for removed resources", func() {
+		update(routeUpdate.Add, "foo.cf.com", makeEndpoint("127.0.0.1"), "")
+		update(routeUpdate.Add, "bar.cf.com", makeEndpoint("127.0.0.2"), "")
+		Eventually(members("foo.cf.com")).Should(Equal([]string{"127.0.0.1"}))
+		update(routeUpdate.Remove, "foo.cf.com", makeEndpoint("127.0.0.1"), "")
+		Eventually(members("foo.cf.com")).Should(BeEmpty())
+		stop()
+		stop = func() {}
+
+		Expect(router.cache.entries).To(HaveKey(virtualCacheKey("cf", makeObjectName("bar.cf.com"))))
+		Expect(router.cache.entries).To(HaveKey(poolCacheKey("cf", makeObjectName("bar.cf.com"))))
+		name := makeObjectName("foo.cf.com")
+		Expect(router.cache.entries).NotTo(HaveKey(virtualCacheKey("cf", name)))
+		Expect(router.cache.entries).NotTo(HaveKey(poolCacheKey("cf", name)))
+		Expect(router.cache.entries).NotTo(HaveKey(cacheKey{kind: "record", scope: InternalDataGroupName, name: name}))
+	})
+})
@@ -17,14 +17,16 @@
 package f5router
 
 import (
+	"net"
 	"regexp"
+	"strings"
 )
 
+// address is of the form: <ipv4_or_ipv6>[%<routeDomainID>]
+var idRdRegex = regexp.MustCompile(`^([^%]*)%(\d+)$`)
+
 func splitIPWithRouteDomain(address string) (ip string, rd string) {
 	// Split the address into the ip and routeDomain (optional) parts
-	//     address is of the form: <ipv4_or_ipv6>[%<routeDomainID>]
-	idRdRegex := regexp.MustCompile(`^([^%]*)%(\d+)$`)
-
 	match := idRdRegex.FindStringSubmatch(address)
 	if match != nil {
 		ip = match[1]
@@ -35,3 +37,23 @@ func splitIPWithRouteDomain(address string) (ip string, rd string) {
 	}
 	return
 }
+
+// normalizeAddress returns an IP address with an optional route domain in its
+// canonical form and without the brackets of an IPv6 address taken from a
+// URL, so the same address is always written the same way. Other addresses,
+// such as host names, are returned as they are.
+func normalizeAddress(address string) string {
+	unbracketed := address
+	if strings.HasPrefix(address, "[") && strings.HasSuffix(address, "]") {
+		unbracketed = address[1 : len(address)-1]
+	}
+	ip, rd := splitIPWithRouteDomain(unbracketed)
+	parsed := net.ParseIP(ip)
+	if nil == parsed {
+		return address
+	}
+	if "" != rd {
+		return parsed.String() + "%" + rd
+	}
+	return parsed.String()
+}
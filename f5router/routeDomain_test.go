@@ -58,4 +58,22 @@ var _ = Describe("Route Domain", func() {
 			Expect(rd).To(Equal(td.expectedRD))
 		}
 	})
+
+	It("normalizeAddress", func() {
+		for address, expected := range map[string]string{
+			"":                  "",
+			"1.2.3.4":           "1.2.3.4",
+			"1.2.3.4%56":        "1.2.3.4%56",
+			"2001:DB8:0::1":     "2001:db8::1",
+			"[2001:db8::1]":     "2001:db8::1",
+			"[2001:db8::1]%2":   "[2001:db8::1]%2",
+			"2001:db8:0:0::1%2": "2001:db8::1%2",
+			"::ffff:10.0.0.1":   "10.0.0.1",
+			"app.example.com":   "app.example.com",
+			"[app.example.com]": "[app.example.com]",
+			"2001:db8:::1":      "2001:db8:::1",
+		} {
+			Expect(normalizeAddress(address)).To(Equal(expected), address)
+		}
+	})
 })
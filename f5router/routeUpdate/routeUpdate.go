@@ -48,6 +48,10 @@ const (
 	Bind
 	// Unbind
 	Unbind
+	// Disable operation taking a route offline while keeping its objects
+	Disable
+	// Enable operation bringing a disabled route back
+	Enable
 )
 
 func (op Operation) String() string {
@@ -60,6 +64,10 @@ func (op Operation) String() string {
 		return "Bind"
 	case Unbind:
 		return "Unbind"
+	case Disable:
+		return "Disable"
+	case Enable:
+		return "Enable"
 	}
 	return "Unknown"
 }
@@ -30,7 +30,9 @@ var _ = Describe("routeUpdate", func() {
 		Expect(Remove.String()).To(Equal("Remove"))
 		Expect(Bind.String()).To(Equal("Bind"))
 		Expect(Unbind.String()).To(Equal("Unbind"))
-		op = 4
+		Expect(Disable.String()).To(Equal("Disable"))
+		Expect(Enable.String()).To(Equal("Enable"))
+		op = 6
 		Expect(op.String()).To(Equal("Unknown"))
 	})
 })
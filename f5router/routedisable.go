@@ -0,0 +1,69 @@
+/*-
+ * Copyright (c) 2018, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package f5router
+
+import (
+	"github.com/F5Networks/cf-bigip-ctlr/f5router/bigipResources"
+	"github.com/F5Networks/cf-bigip-ctlr/f5router/routeUpdate"
+
+	"github.com/uber-go/zap"
+)
+
+// processRouteDisable takes a route offline or brings it back. A disabled
+// route keeps its objects and members, its pool is written with every member
+// disabled until the route is enabled again. Members added or removed in the
+// meantime are kept track of as usual, and the route stays disabled when its
+// last member goes away and it is registered again.
+func (r *F5Router) processRouteDisable(ru routeUpdate.RouteUpdate) {
+	name := ru.Name()
+	disable := routeUpdate.Disable == ru.Op()
+	if disable == r.disabledRoutes[name] {
+		r.logger.Debug("f5router-route-disable-unchanged",
+			zap.String("route", ru.Route()),
+			zap.Bool("disabled", disable),
+		)
+		return
+	}
+
+	r.cache.invalidate(poolCacheKey(r.objectPartition(name), name))
+	if disable {
+		r.disabledRoutes[name] = true
+		r.logger.Info("f5router-route-disabled",
+			zap.String("route", ru.Route()),
+			zap.Int("members", r.memberCount(name)),
+		)
+	} else {
+		delete(r.disabledRoutes, name)
+		r.logger.Info("f5router-route-enabled",
+			zap.String("route", ru.Route()),
+			zap.Int("members", r.memberCount(name)),
+		)
+	}
+}
+
+// disabledPool returns a copy of the pool with every member disabled, the
+// members of the pool itself keep their state for when the route is enabled
+func disabledPool(pool *bigipResources.Pool) *bigipResources.Pool {
+	p := *pool
+	p.Members = make([]bigipResources.Member, len(pool.Members))
+	copy(p.Members, pool.Members)
+	for i := range p.Members {
+		p.Members[i].Session = "user-disabled"
+		p.Members[i].State = "user-down"
+	}
+	return &p
+}
@@ -0,0 +1,145 @@
+/*-
+ * Copyright (c) 2018, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package f5router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/F5Networks/cf-bigip-ctlr/f5router/routeUpdate"
+	"github.com/F5Networks/cf-bigip-ctlr/logger"
+
+	"github.com/uber-go/zap"
+)
+
+const (
+	// RouteEventAdd operation of a route event for an added route member
+	RouteEventAdd = "add"
+	// RouteEventRemove operation of a route event for a removed route member
+	RouteEventRemove = "remove"
+)
+
+// RouteEvent machine readable record of a route member added or removed,
+// Members is the number of members of the route once the update was applied
+type RouteEvent struct {
+	Time        time.Time `json:"time"`
+	Operation   string    `json:"operation"`
+	Protocol    string    `json:"protocol"`
+	Route       string    `json:"route"`
+	Host        string    `json:"host,omitempty"`
+	ContextPath string    `json:"contextPath,omitempty"`
+	Port        uint16    `json:"port,omitempty"`
+	Member      string    `json:"member,omitempty"`
+	Members     int       `json:"members"`
+}
+
+// OnRouteEvent registers a callback invoked from the worker for every route
+// add and remove update it processed, no events are created without one
+func (r *F5Router) OnRouteEvent(cb func(event RouteEvent)) {
+	r.onRouteEvent = cb
+}
+
+// routeEvent hands the event of a processed route update to the callback
+func (r *F5Router) routeEvent(ru routeUpdate.RouteUpdate) {
+	if nil == r.onRouteEvent {
+		return
+	}
+
+	event := RouteEvent{
+		Time:     time.Now().UTC(),
+		Protocol: ru.Protocol(),
+		Route:    ru.Route(),
+		Members:  r.memberCount(ru.Name()),
+	}
+	switch ru.Op() {
+	case routeUpdate.Add:
+		event.Operation = RouteEventAdd
+	case routeUpdate.Remove:
+		event.Operation = RouteEventRemove
+	default:
+		return
+	}
+	switch u := ru.(type) {
+	case updateHTTP:
+		event.Host = strings.SplitN(u.Route(), "/", 2)[0]
+		event.ContextPath = routeContextPath(u.uri)
+		if nil != u.endpoint {
+			event.Member = net.JoinHostPort(normalizeAddress(u.endpoint.Address), strconv.Itoa(int(u.endpoint.Port)))
+		}
+	case updateTCP:
+		event.Port = u.routePort
+		event.Member = net.JoinHostPort(u.member.Address, strconv.Itoa(int(u.member.Port)))
+	}
+	r.onRouteEvent(event)
+}
+
+// RouteEventSink publishes route events as JSON, appended to a file one event
+// per line when one is set and logged otherwise
+type RouteEventSink struct {
+	logger logger.Logger
+	mutex  sync.Mutex
+	file   *os.File
+}
+
+// NewRouteEventSink creates a route event sink, an empty file only logs the
+// events
+func NewRouteEventSink(logger logger.Logger, file string) (*RouteEventSink, error) {
+	es := &RouteEventSink{logger: logger}
+	if "" != file {
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if nil != err {
+			return nil, fmt.Errorf("could not open route event file: %v", err)
+		}
+		es.file = f
+	}
+	return es, nil
+}
+
+// Emit publishes the event, failures are logged since events must not stop
+// the routing updates
+func (es *RouteEventSink) Emit(event RouteEvent) {
+	output, err := json.Marshal(event)
+	if nil != err {
+		es.logger.Warn("f5router-route-event-marshal-error", zap.Error(err))
+		return
+	}
+
+	if nil == es.file {
+		es.logger.Info("f5router-route-event", zap.String("event", string(output)))
+		return
+	}
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+	_, err = es.file.Write(append(output, '\n'))
+	if nil != err {
+		es.logger.Warn("f5router-route-event-write-error", zap.Error(err))
+	}
+}
+
+// Close closes the event file
+func (es *RouteEventSink) Close() error {
+	if nil == es.file {
+		return nil
+	}
+	return es.file.Close()
+}
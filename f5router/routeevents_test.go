@@ -0,0 +1,215 @@
+/*-
+ * Copyright (c) 2018, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package f5router
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	fakeClient "github.com/F5Networks/cf-bigip-ctlr/bigipclient/fakes"
+	"github.com/F5Networks/cf-bigip-ctlr/config"
+	"github.com/F5Networks/cf-bigip-ctlr/f5router/bigipResources"
+	"github.com/F5Networks/cf-bigip-ctlr/f5router/routeUpdate"
+	"github.com/F5Networks/cf-bigip-ctlr/route"
+	"github.com/F5Networks/cf-bigip-ctlr/test_util"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("RouteEvents", func() {
+	var (
+		logger *test_util.TestZapLogger
+		c      *config.Config
+		router *F5Router
+		mw     *MockWriter
+		stop   func()
+		lock   sync.Mutex
+		events []RouteEvent
+	)
+
+	record := func(event RouteEvent) {
+		lock.Lock()
+		defer lock.Unlock()
+		events = append(events, event)
+	}
+
+	recorded := func() []RouteEvent {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]RouteEvent(nil), events...)
+	}
+
+	start := func(cb func(event RouteEvent)) {
+		var err error
+		mw = &MockWriter{}
+		router, err = NewF5Router(logger, c, mw, &fakeClient.FakeClient{})
+		Expect(err).NotTo(HaveOccurred())
+		if nil != cb {
+			router.OnRouteEvent(cb)
+		}
+		stop = runRouter(router)
+	}
+
+	update := func(op routeUpdate.Operation, uri route.Uri, address string) {
+		ru, err := NewUpdate(logger, op, uri, makeEndpoint(address), "")
+		Expect(err).NotTo(HaveOccurred())
+		router.UpdateRoute(ru)
+	}
+
+	BeforeEach(func() {
+		logger = test_util.NewTestZapLogger("route-events-test")
+		c = makeConfig()
+		stop = func() {}
+		events = nil
+	})
+
+	AfterEach(func() {
+		stop()
+		if nil != logger {
+			logger.Close()
+		}
+	})
+
+	It("should not create events without a callback", func() {
+		start(nil)
+		update(routeUpdate.Add, "foo.cf.com", "127.0.0.1")
+		Eventually(func() []*bigipResources.Pool {
+			return mw.getResources("cf").Pools
+		}).Should(HaveLen(1))
+		Expect(recorded()).To(BeEmpty())
+	})
+
+	It("should create an event for every HTTP route add and remove", func() {
+		start(record)
+
+		update(routeUpdate.Add, "foo.cf.com/path", "127.0.0.1")
+		update(routeUpdate.Add, "foo.cf.com/path", "127.0.0.2")
+		update(routeUpdate.Add, "bar.cf.com", "127.0.0.3")
+		update(routeUpdate.Remove, "foo.cf.com/path", "127.0.0.1")
+		update(routeUpdate.Remove, "bar.cf.com", "127.0.0.3")
+
+		Eventually(recorded).Should(HaveLen(5))
+		events := recorded()
+		for _, event := range events {
+			Expect(event.Protocol).To(Equal("http"))
+			Expect(event.Time).NotTo(BeZero())
+		}
+		summary := func(e RouteEvent) []interface{} {
+			return []interface{}{e.Operation, e.Route, e.Host, e.ContextPath, e.Member, e.Members}
+		}
+		Expect(summary(events[0])).To(Equal([]interface{}{"add", "foo.cf.com/path", "foo.cf.com", "/path", "127.0.0.1:80", 1}))
+		Expect(summary(events[1])).To(Equal([]interface{}{"add", "foo.cf.com/path", "foo.cf.com", "/path", "127.0.0.2:80", 2}))
+		Expect(summary(events[2])).To(Equal([]interface{}{"add", "bar.cf.com", "bar.cf.com", "", "127.0.0.3:80", 1}))
+		Expect(summary(events[3])).To(Equal([]interface{}{"remove", "foo.cf.com/path", "foo.cf.com", "/path", "127.0.0.1:80", 1}))
+		Expect(summary(events[4])).To(Equal([]interface{}{"remove", "bar.cf.com", "bar.cf.com", "", "127.0.0.3:80", 0}))
+	})
+
+	It("should create an event for every TCP route add and remove", func() {
+		c.RoutingMode = config.TCP
+		c.TCPRouterGroupName = "default-tcp"
+		start(record)
+
+		member := bigipResources.Member{Address: "10.0.0.1", Port: 5000}
+		for _, op := range []routeUpdate.Operation{routeUpdate.Add, routeUpdate.Remove} {
+			tu, err := NewTCPUpdate(c, logger, op, 6010, member)
+			Expect(err).NotTo(HaveOccurred())
+			router.UpdateRoute(tu)
+		}
+
+		Eventually(recorded).Should(HaveLen(2))
+		events := recorded()
+		Expect(events[0].Time).NotTo(BeZero())
+		Expect(events[0]).To(Equal(RouteEvent{
+			Time:      events[0].Time,
+			Operation: "add",
+			Protocol:  "tcp",
+			Route:     "6010",
+			Port:      6010,
+			Member:    "10.0.0.1:5000",
+			Members:   1,
+		}))
+		Expect(events[1].Operation).To(Equal("remove"))
+		Expect(events[1].Members).To(BeZero())
+	})
+
+	Context("sink", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "route-events-test")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("should log the events without a file", func() {
+			es, err := NewRouteEventSink(logger, "")
+			Expect(err).NotTo(HaveOccurred())
+			es.Emit(RouteEvent{Operation: "add", Protocol: "http", Route: "foo.cf.com"})
+			Expect(logger).To(Say(`"message":"f5router-route-event".*\\"operation\\":\\"add\\"`))
+			Expect(es.Close()).To(Succeed())
+		})
+
+		It("should append one JSON event per line to the file", func() {
+			file := filepath.Join(dir, "events.json")
+			Expect(ioutil.WriteFile(file, []byte("{}\n"), 0644)).To(Succeed())
+			es, err := NewRouteEventSink(logger, file)
+			Expect(err).NotTo(HaveOccurred())
+
+			start(func(event RouteEvent) {
+				es.Emit(event)
+				record(event)
+			})
+			update(routeUpdate.Add, "foo.cf.com", "127.0.0.1")
+			update(routeUpdate.Remove, "foo.cf.com", "127.0.0.1")
+			Eventually(recorded).Should(HaveLen(2))
+			Expect(es.Close()).To(Succeed())
+
+			f, err := os.Open(file)
+			Expect(err).NotTo(HaveOccurred())
+			defer f.Close()
+			var lines []RouteEvent
+			scanner := bufio.NewScanner(f)
+			for scanner.Scan() {
+				var event RouteEvent
+				Expect(json.Unmarshal(scanner.Bytes(), &event)).To(Succeed())
+				lines = append(lines, event)
+			}
+			Expect(lines).To(HaveLen(3))
+			Expect(lines[1].Operation).To(Equal("add"))
+			Expect(lines[1].Host).To(Equal("foo.cf.com"))
+			Expect(lines[1].Members).To(Equal(1))
+			Expect(lines[2].Operation).To(Equal("remove"))
+			Expect(lines[2].Members).To(BeZero())
+		})
+
+		It("should fail when the file cannot be opened", func() {
+			es, err := NewRouteEventSink(logger, filepath.Join(dir, "missing", "events.json"))
+			Expect(es).To(BeNil())
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
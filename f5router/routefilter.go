@@ -0,0 +1,89 @@
+/*-
+ * Copyright (c) 2018, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package f5router
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/F5Networks/cf-bigip-ctlr/config"
+	"github.com/F5Networks/cf-bigip-ctlr/f5router/routeUpdate"
+
+	"github.com/uber-go/zap"
+)
+
+// validateRouteFilter checks the host patterns of the route filter, and that
+// the default route is not filtered out
+func validateRouteFilter(c *config.Config) error {
+	f := c.BigIP.RouteFilter
+	for _, patterns := range [][]string{f.Include, f.Exclude} {
+		for _, pattern := range patterns {
+			if "" == pattern {
+				return errors.New("invalid route_filter pattern: must not be empty")
+			}
+			_, err := path.Match(pattern, "")
+			if nil != err {
+				return fmt.Errorf("invalid route_filter pattern %s: %v", pattern, err)
+			}
+		}
+	}
+	if "" != c.BigIP.DefaultRoute && !hostManaged(f, routeHost(c.BigIP.DefaultRoute)) {
+		return fmt.Errorf("default_route %s is excluded by the route_filter", c.BigIP.DefaultRoute)
+	}
+	return nil
+}
+
+// routeHost returns the host of a route URI
+func routeHost(uri string) string {
+	return strings.ToLower(strings.SplitN(uri, "/", 2)[0])
+}
+
+// hostManaged returns true when the host passes the route filter
+func hostManaged(f config.RouteFilterConfig, host string) bool {
+	included := 0 == len(f.Include)
+	for _, pattern := range f.Include {
+		if ok, _ := path.Match(strings.ToLower(pattern), host); ok {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+	for _, pattern := range f.Exclude {
+		if ok, _ := path.Match(strings.ToLower(pattern), host); ok {
+			return false
+		}
+	}
+	return true
+}
+
+// routeManaged returns true when the router manages the route of the update,
+// updates of the routes filtered out are dropped before they are queued
+func (r *F5Router) routeManaged(ru routeUpdate.RouteUpdate) bool {
+	u, ok := ru.(updateHTTP)
+	if !ok || hostManaged(r.c.BigIP.RouteFilter, routeHost(u.Route())) {
+		return true
+	}
+	r.logger.Debug("f5router-route-filtered",
+		zap.String("operation", ru.Op().String()),
+		zap.String("route", ru.Route()),
+	)
+	return false
+}
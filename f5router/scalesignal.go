@@ -0,0 +1,151 @@
+/*-
+ * Copyright (c) 2018, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package f5router
+
+import (
+	"time"
+
+	"github.com/F5Networks/cf-bigip-ctlr/servicebroker/planResources"
+
+	"github.com/uber-go/zap"
+)
+
+const (
+	// ScaleSignalMaxMembers route pool has more members than the plan maximum
+	ScaleSignalMaxMembers = "max-members"
+	// ScaleSignalMinMembers route pool has fewer members than the plan minimum
+	ScaleSignalMinMembers = "min-members"
+	// ScaleSignalMemberChanges route pool members changed more often within the
+	// window than the plan allows
+	ScaleSignalMemberChanges = "member-changes"
+
+	// defaultScaleSignalWindow window for counting member changes when the plan
+	// does not set one
+	defaultScaleSignalWindow = 60 * time.Second
+)
+
+type (
+	// ScaleSignal event for external autoscaling, emitted once when a route
+	// crosses one of its plan's thresholds and again only after the route has
+	// gone back within it
+	ScaleSignal struct {
+		Route     string `json:"route"`
+		Name      string `json:"name"`
+		Signal    string `json:"signal"`
+		Members   int    `json:"members"`
+		Changes   int    `json:"changes"`
+		Threshold int    `json:"threshold"`
+	}
+
+	// scaleWatch tracks the members of a route bound to a plan with scale
+	// thresholds
+	scaleWatch struct {
+		thresholds planResources.ScaleSignalType
+		members    int
+		changes    []time.Time
+		crossed    map[string]bool
+	}
+)
+
+// OnScaleSignal registers a callback invoked from the worker for every scale
+// signal
+func (r *F5Router) OnScaleSignal(cb func(signal ScaleSignal)) {
+	r.onScaleSignal = cb
+}
+
+// setScaleWatch starts watching a route for the plan's scale thresholds, a nil
+// thresholds stops watching it
+func (r *F5Router) setScaleWatch(name string, uri string, thresholds *planResources.ScaleSignalType) {
+	if nil == thresholds {
+		delete(r.scaleWatches, name)
+		return
+	}
+	r.scaleWatches[name] = &scaleWatch{
+		thresholds: *thresholds,
+		members:    r.memberCount(name),
+		crossed:    make(map[string]bool),
+	}
+	r.checkScaleSignals(name, uri)
+}
+
+func (r *F5Router) memberCount(name string) int {
+	if pool, ok := r.poolResources[name]; ok {
+		return len(pool.Members)
+	}
+	return 0
+}
+
+// checkScaleSignals records a change in the members of a watched route and
+// emits the signals for the thresholds it crossed
+func (r *F5Router) checkScaleSignals(name string, uri string) {
+	watch, ok := r.scaleWatches[name]
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	members := r.memberCount(name)
+	if members != watch.members {
+		watch.members = members
+		watch.changes = append(watch.changes, now)
+	}
+	window := defaultScaleSignalWindow
+	if 0 != watch.thresholds.Window {
+		window = time.Duration(watch.thresholds.Window) * time.Second
+	}
+	expired := 0
+	for expired < len(watch.changes) && now.Sub(watch.changes[expired]) > window {
+		expired++
+	}
+	watch.changes = watch.changes[expired:]
+
+	t := watch.thresholds
+	signal := ScaleSignal{
+		Route:   uri,
+		Name:    name,
+		Members: members,
+		Changes: len(watch.changes),
+	}
+	signal.Signal, signal.Threshold = ScaleSignalMaxMembers, t.MaxMembers
+	r.scaleSignal(watch, signal, 0 != t.MaxMembers && members > t.MaxMembers)
+	signal.Signal, signal.Threshold = ScaleSignalMinMembers, t.MinMembers
+	r.scaleSignal(watch, signal, 0 != t.MinMembers && members < t.MinMembers)
+	signal.Signal, signal.Threshold = ScaleSignalMemberChanges, t.MaxMemberChanges
+	r.scaleSignal(watch, signal, 0 != t.MaxMemberChanges && len(watch.changes) > t.MaxMemberChanges)
+}
+
+func (r *F5Router) scaleSignal(watch *scaleWatch, signal ScaleSignal, crossed bool) {
+	if !crossed {
+		delete(watch.crossed, signal.Signal)
+		return
+	}
+	if watch.crossed[signal.Signal] {
+		return
+	}
+	watch.crossed[signal.Signal] = true
+
+	r.logger.Info("f5router-scale-signal",
+		zap.String("route", signal.Route),
+		zap.String("signal", signal.Signal),
+		zap.Int("members", signal.Members),
+		zap.Int("changes", signal.Changes),
+		zap.Int("threshold", signal.Threshold),
+	)
+	if nil != r.onScaleSignal {
+		r.onScaleSignal(signal)
+	}
+}
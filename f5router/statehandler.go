@@ -0,0 +1,121 @@
+/*-
+ * Copyright (c) 2018, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package f5router
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/F5Networks/cf-bigip-ctlr/f5router/bigipResources"
+	"github.com/F5Networks/cf-bigip-ctlr/logger"
+
+	"github.com/uber-go/zap"
+)
+
+const (
+	// StateEndpointPath path the managed routes and pools are served on
+	StateEndpointPath = "/state"
+)
+
+type (
+	// ManagedState snapshot of the routes and pools the router manages
+	ManagedState struct {
+		Routes []ManagedRoute         `json:"routes"`
+		Pools  []*bigipResources.Pool `json:"pools"`
+	}
+
+	// ManagedRoute HTTP route and the name of its pool and virtual
+	ManagedRoute struct {
+		URI       string `json:"uri"`
+		Name      string `json:"name"`
+		Partition string `json:"partition"`
+	}
+)
+
+// State returns a snapshot of the HTTP routes and of the pools with their
+// members, taken between two work items so it is consistent
+func (r *F5Router) State() ManagedState {
+	r.stateLock.RLock()
+	defer r.stateLock.RUnlock()
+
+	state := ManagedState{
+		Routes: []ManagedRoute{},
+		Pools:  []*bigipResources.Pool{},
+	}
+	for _, rules := range []bigipResources.RuleMap{r.r, r.wildcards} {
+		for uri, rule := range rules {
+			state.Routes = append(state.Routes, ManagedRoute{
+				URI:       uri.String(),
+				Name:      rule.Name,
+				Partition: r.objectPartition(rule.Name),
+			})
+		}
+	}
+	sort.Slice(state.Routes, func(i, j int) bool {
+		return state.Routes[i].URI < state.Routes[j].URI
+	})
+
+	// The worker changes the members in place once the lock is released
+	for _, pool := range r.poolResources {
+		p := *pool
+		p.Members = append([]bigipResources.Member{}, pool.Members...)
+		state.Pools = append(state.Pools, &p)
+	}
+	sort.Slice(state.Pools, func(i, j int) bool {
+		return state.Pools[i].Name < state.Pools[j].Name
+	})
+	return state
+}
+
+// StateHandler serves the routes and pools the router manages as JSON for
+// debugging, it never changes them
+type StateHandler struct {
+	router *F5Router
+	logger logger.Logger
+}
+
+// NewStateHandler creates a state handler for the router
+func NewStateHandler(logger logger.Logger, router *F5Router) *StateHandler {
+	return &StateHandler{
+		router: router,
+		logger: logger,
+	}
+}
+
+// ServeHTTP returns the current managed state
+func (sh *StateHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	output, err := json.Marshal(sh.router.State())
+	if nil != err {
+		sh.logger.Warn("f5router-state-handler-marshal-error", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(output)
+	if nil != err {
+		sh.logger.Warn("f5router-state-handler-write-error", zap.Error(err))
+	}
+}
@@ -0,0 +1,165 @@
+/*-
+ * Copyright (c) 2018, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package f5router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	fakeClient "github.com/F5Networks/cf-bigip-ctlr/bigipclient/fakes"
+	"github.com/F5Networks/cf-bigip-ctlr/f5router/bigipResources"
+	"github.com/F5Networks/cf-bigip-ctlr/f5router/routeUpdate"
+	"github.com/F5Networks/cf-bigip-ctlr/route"
+	"github.com/F5Networks/cf-bigip-ctlr/test_util"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("StateHandler", func() {
+	var (
+		logger *test_util.TestZapLogger
+		mw     *MockWriter
+		router *F5Router
+		sh     *StateHandler
+		stop   func()
+	)
+
+	serve := func(method string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, StateEndpointPath, nil)
+		Expect(err).NotTo(HaveOccurred())
+		rec := httptest.NewRecorder()
+		sh.ServeHTTP(rec, req)
+		return rec
+	}
+
+	state := func() ManagedState {
+		rec := serve("GET")
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Header().Get("Content-Type")).To(Equal("application/json"))
+		var s ManagedState
+		Expect(json.Unmarshal(rec.Body.Bytes(), &s)).To(Succeed())
+		return s
+	}
+
+	update := func(op routeUpdate.Operation, uri route.Uri, address string) {
+		ru, err := NewUpdate(logger, op, uri, makeEndpoint(address), "")
+		Expect(err).NotTo(HaveOccurred())
+		router.UpdateRoute(ru)
+	}
+
+	routeURIs := func(s ManagedState) []string {
+		var uris []string
+		for _, r := range s.Routes {
+			uris = append(uris, r.URI)
+		}
+		return uris
+	}
+
+	servedURIs := func() []string {
+		return routeURIs(state())
+	}
+
+	members := func(s ManagedState, uri string) []bigipResources.Member {
+		for _, pool := range s.Pools {
+			if pool.Name == makeObjectName(uri) {
+				return pool.Members
+			}
+		}
+		return nil
+	}
+
+	BeforeEach(func() {
+		var err error
+		logger = test_util.NewTestZapLogger("state-handler-test")
+		mw = &MockWriter{}
+		router, err = NewF5Router(logger, makeConfig(), mw, &fakeClient.FakeClient{})
+		Expect(err).NotTo(HaveOccurred())
+		sh = NewStateHandler(logger, router)
+		stop = runRouter(router)
+	})
+
+	AfterEach(func() {
+		stop()
+		if nil != logger {
+			logger.Close()
+		}
+	})
+
+	It("should serve an empty state before any routes", func() {
+		rec := serve("GET")
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(rec.Body.String()).To(Equal(`{"routes":[],"pools":[]}`))
+	})
+
+	It("should only allow GET", func() {
+		rec := serve("POST")
+		Expect(rec.Code).To(Equal(http.StatusMethodNotAllowed))
+		Expect(rec.Header().Get("Allow")).To(Equal("GET"))
+	})
+
+	It("should serve the routes and pool members after route updates", func() {
+		update(routeUpdate.Add, "foo.cf.com", "127.0.0.1")
+		update(routeUpdate.Add, "foo.cf.com", "127.0.0.2")
+		update(routeUpdate.Add, "bar.cf.com/path", "127.0.0.3")
+		update(routeUpdate.Add, "*.baz.cf.com", "127.0.0.4")
+		Eventually(servedURIs).Should(Equal([]string{"*.baz.cf.com", "bar.cf.com/path", "foo.cf.com"}))
+
+		s := state()
+		for _, r := range s.Routes {
+			Expect(r.Name).To(Equal(makeObjectName(r.URI)))
+			Expect(r.Partition).To(Equal("cf"))
+		}
+		Expect(s.Pools).To(HaveLen(3))
+		foo := members(s, "foo.cf.com")
+		Expect(foo).To(HaveLen(2))
+		Expect(foo[0].Address).To(Equal("127.0.0.1"))
+		Expect(foo[1].Address).To(Equal("127.0.0.2"))
+
+		update(routeUpdate.Remove, "foo.cf.com", "127.0.0.1")
+		update(routeUpdate.Remove, "foo.cf.com", "127.0.0.2")
+		update(routeUpdate.Remove, "bar.cf.com/path", "127.0.0.3")
+		Eventually(servedURIs).Should(Equal([]string{"*.baz.cf.com"}))
+		Expect(state().Pools).To(HaveLen(1))
+	})
+
+	It("should not change the written config", func() {
+		update(routeUpdate.Add, "foo.cf.com", "127.0.0.1")
+		Eventually(func() []*bigipResources.Pool {
+			return mw.getResources("cf").Pools
+		}).Should(HaveLen(1))
+		written := mw.getOutput()
+
+		state()
+		Consistently(mw.getOutput).Should(Equal(written))
+	})
+
+	It("should return a snapshot the router does not change later", func() {
+		update(routeUpdate.Add, "foo.cf.com", "127.0.0.1")
+		Eventually(func() []bigipResources.Member {
+			return members(router.State(), "foo.cf.com")
+		}).Should(HaveLen(1))
+		s := router.State()
+		update(routeUpdate.Add, "foo.cf.com", "127.0.0.2")
+
+		Eventually(func() []bigipResources.Member {
+			return members(router.State(), "foo.cf.com")
+		}).Should(HaveLen(2))
+		Expect(s.Pools[0].Members).To(HaveLen(1))
+	})
+})
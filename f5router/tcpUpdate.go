@@ -17,6 +17,7 @@
 package f5router
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
 
@@ -44,6 +45,12 @@ func NewTCPUpdate(
 	routePort uint16,
 	member bigipResources.Member,
 ) (updateTCP, error) {
+	if 0 == member.ConnectionLimit {
+		member.ConnectionLimit = c.BigIP.ConnectionLimit
+	}
+	member.Address = normalizeAddress(member.Address)
+	member.FQDN = makeMemberFQDN(c, member.Address)
+	member.Node = makeMemberNode(c, member.Address)
 
 	return updateTCP{
 		c:         c,
@@ -56,17 +63,10 @@ func NewTCPUpdate(
 	}, nil
 }
 
+// CreateResources creates the route's pool and a virtual on each external
+// address
 func (tu updateTCP) CreateResources(c *config.Config) (bigipResources.Resources, error) {
 	rs := bigipResources.Resources{}
-	va := &bigipResources.VirtualAddress{
-		BindAddr: tu.c.BigIP.ExternalAddr,
-		Port:     int32(tu.routePort),
-	}
-
-	dest, err := verifyDestAddress(va, tu.c.BigIP.Partitions[0])
-	if err != nil {
-		return rs, err
-	}
 
 	// FIXME need to handle multiple tcp router groups
 	poolDescrip := fmt.Sprintf("route-port: %d, router-group: %s", tu.routePort, c.TCPRouterGroupName)
@@ -74,31 +74,47 @@ func (tu updateTCP) CreateResources(c *config.Config) (bigipResources.Resources,
 		fixupNames(c.BigIP.HealthMonitors))
 	rs.Pools = append(rs.Pools, pool)
 
-	profile := []*bigipResources.ProfileRef{
-		&bigipResources.ProfileRef{
-			Name:      "tcp",
-			Partition: "Common",
-			Context:   "all",
-		}}
+	profile := []*bigipResources.ProfileRef{makeTCPProfile(c)}
 
 	poolPath, err := joinBigipPath(c.BigIP.Partitions[0], tu.name)
 	if nil != err {
 		return bigipResources.Resources{}, err
 	}
 
-	vs := &bigipResources.Virtual{
-		VirtualServerName:     tu.name,
-		PoolName:              poolPath,
-		Mode:                  "tcp",
-		Enabled:               true,
-		Destination:           dest,
-		Profiles:              profile,
-		SourceAddrTranslation: bigipResources.SourceAddrTranslation{Type: "automap"},
+	var iRules []string
+	if 0 != c.BigIP.IdleTimeout {
+		path, _ := joinBigipPath(c.BigIP.Partitions[0], bigipResources.IdleTimeoutIRuleName)
+		iRules = []string{path}
 	}
 
-	if nil != vs {
+	for i, addr := range tu.c.BigIP.ExternalAddr {
+		va := &bigipResources.VirtualAddress{
+			BindAddr: addr,
+			Port:     int32(tu.routePort),
+		}
+		dest, err := verifyDestAddress(va, tu.c.BigIP.Partitions[0])
+		if err != nil {
+			return bigipResources.Resources{}, err
+		}
+		vs := &bigipResources.Virtual{
+			VirtualServerName:     addressVirtualName(tu.name, i, addr),
+			PoolName:              poolPath,
+			Mode:                  "tcp",
+			Enabled:               true,
+			Destination:           dest,
+			Mask:                  c.BigIP.ExternalMask,
+			Profiles:              profile,
+			IRules:                iRules,
+			SourceAddrTranslation: makeSourceAddrTranslation(c),
+			RateLimit:             c.BigIP.RateLimit,
+			ConnectionMirroring:   c.BigIP.ConnectionMirroring,
+		}
+		setVLANs(c, vs)
 		rs.Virtuals = append(rs.Virtuals, vs)
 	}
+	if 0 == len(rs.Virtuals) {
+		return bigipResources.Resources{}, errors.New("no external address for the TCP route virtual")
+	}
 	return rs, nil
 }
 
@@ -0,0 +1,176 @@
+/*-
+ * Copyright (c) 2018, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package f5router
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/F5Networks/cf-bigip-ctlr/config"
+
+	"github.com/uber-go/zap"
+)
+
+const (
+	// tokenLoginPath BIG-IP endpoint handing out auth tokens
+	tokenLoginPath = "/mgmt/shared/authn/login"
+	// tokenRetryInterval delay before logging in again after a failed refresh
+	tokenRetryInterval = 10 * time.Second
+)
+
+// resolveCredentials sets the basic credentials not given inline from their
+// file or environment variable, the inline values take precedence
+func resolveCredentials(bigip *config.BigIPConfig) error {
+	var err error
+	bigip.User, err = resolveCredential("user", bigip.User, bigip.UserFile, bigip.UserEnv)
+	if nil != err {
+		return err
+	}
+	bigip.Pass, err = resolveCredential("pass", bigip.Pass, bigip.PassFile, bigip.PassEnv)
+	return err
+}
+
+// resolveCredential returns the inline value when set, otherwise the content
+// of the file without trailing newlines or the environment variable. A source
+// that is set must not be empty and only one of them may be set.
+func resolveCredential(name, inline, file, env string) (string, error) {
+	if "" != inline {
+		return inline, nil
+	}
+	if "" != file && "" != env {
+		return "", fmt.Errorf("only one of %s_file and %s_env may be set", name, name)
+	}
+	if "" != file {
+		b, err := ioutil.ReadFile(file)
+		if nil != err {
+			return "", fmt.Errorf("could not read %s_file: %v", name, err)
+		}
+		value := strings.TrimRight(string(b), "\r\n")
+		if "" == value {
+			return "", fmt.Errorf("%s_file is empty: %s", name, file)
+		}
+		return value, nil
+	}
+	if "" != env {
+		value := os.Getenv(env)
+		if "" == value {
+			return "", fmt.Errorf("%s_env variable is not set: %s", name, env)
+		}
+		return value, nil
+	}
+	return "", nil
+}
+
+// validateAuth checks the BIG-IP is given either basic credentials or a token
+// file, logging in for the token needs the basic credentials
+func validateAuth(bigip config.BigIPConfig) error {
+	basic := "" != bigip.User && "" != bigip.Pass
+	if nil == bigip.Token {
+		if !basic {
+			return errors.New("required parameter missing; user and pass or token file must have value")
+		}
+		return nil
+	}
+	if "" == bigip.Token.File {
+		return errors.New("token file must have value")
+	}
+	if "" != bigip.Token.LoginProvider && !basic {
+		return errors.New("token login_provider requires user and pass")
+	}
+	return nil
+}
+
+// driverBigIP the BIG-IP config handed to the driver, the password is left out
+// when the driver authenticates with the token
+func (r *F5Router) driverBigIP() config.BigIPConfig {
+	bigip := r.c.BigIP
+	if nil != bigip.Token {
+		bigip.Pass = ""
+	}
+	return bigip
+}
+
+// tokenLogin whether the controller logs in for the token itself instead of
+// reading one provided from elsewhere
+func (r *F5Router) tokenLogin() bool {
+	return nil != r.c.BigIP.Token && "" != r.c.BigIP.Token.LoginProvider
+}
+
+// refreshToken logs in for a new token and writes it to the token file,
+// returning how long until it should be refreshed again
+func (r *F5Router) refreshToken() (time.Duration, error) {
+	token, err := r.bigIPClient.Login(
+		r.c.BigIP.URL+tokenLoginPath,
+		r.c.BigIP.User,
+		r.c.BigIP.Pass,
+		r.c.BigIP.Token.LoginProvider,
+	)
+	if nil != err {
+		return 0, err
+	}
+	err = writeTokenFile(r.c.BigIP.Token.File, token.Token)
+	if nil != err {
+		return 0, fmt.Errorf("failed writing token file: %v", err)
+	}
+	r.logger.Info("f5router-token-refreshed",
+		zap.String("file", r.c.BigIP.Token.File),
+		zap.Duration("timeout", token.Timeout),
+	)
+	// Refresh halfway through the token's lifetime so the driver never reads
+	// an expired one
+	return token.Timeout / 2, nil
+}
+
+// writeTokenFile replaces the token file in a single rename so the driver never
+// reads a partially written token
+func writeTokenFile(path string, token string) error {
+	tmp := path + ".tmp"
+	err := ioutil.WriteFile(tmp, []byte(token), 0600)
+	if nil != err {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (r *F5Router) readToken() (string, error) {
+	data, err := ioutil.ReadFile(r.c.BigIP.Token.File)
+	if nil != err {
+		return "", err
+	}
+	token := strings.TrimSpace(string(data))
+	if "" == token {
+		return "", fmt.Errorf("token file %s is empty", r.c.BigIP.Token.File)
+	}
+	return token, nil
+}
+
+// getBigIP fetches url from the BIG-IP with the token when one is configured,
+// otherwise with the basic credentials
+func (r *F5Router) getBigIP(url string) ([]byte, error) {
+	if nil == r.c.BigIP.Token {
+		return r.bigIPClient.Get(url, r.c.BigIP.User, r.c.BigIP.Pass)
+	}
+	token, err := r.readToken()
+	if nil != err {
+		return nil, err
+	}
+	return r.bigIPClient.GetWithToken(url, token)
+}
@@ -13,12 +13,16 @@ import (
 
 type healthcheck struct {
 	heartbeatOK *int32
+	checks      []func() bool
 	logger      logger.Logger
 }
 
-func NewHealthcheck(heartbeatOK *int32, logger logger.Logger) http.Handler {
+// NewHealthcheck reports healthy while the heartbeat is ok and every check
+// passes, such as the config driver still running
+func NewHealthcheck(heartbeatOK *int32, logger logger.Logger, checks ...func() bool) http.Handler {
 	return &healthcheck{
 		heartbeatOK: heartbeatOK,
+		checks:      checks,
 		logger:      logger,
 	}
 }
@@ -35,6 +39,14 @@ func (h *healthcheck) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	for _, check := range h.checks {
+		if !check() {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			r.Close = true
+			return
+		}
+	}
+
 	rw.WriteHeader(http.StatusOK)
 	rw.Write([]byte("ok\n"))
 	r.Close = true
@@ -54,6 +54,30 @@ var _ = Describe("Healthcheck", func() {
 		Expect(resp.Header().Get("Expires")).To(Equal("0"))
 	})
 
+	Context("when a health check fails", func() {
+		var healthy bool
+
+		BeforeEach(func() {
+			healthy = false
+			handler = handlers.NewHealthcheck(&heartbeatOK, logger,
+				func() bool { return true },
+				func() bool { return healthy },
+			)
+		})
+
+		It("responds with a 503 Service Unavailable", func() {
+			handler.ServeHTTP(resp, req)
+			Expect(resp.Code).To(Equal(503))
+			Expect(req.Close).To(BeTrue())
+		})
+
+		It("responds with 200 OK once the check passes", func() {
+			healthy = true
+			handler.ServeHTTP(resp, req)
+			Expect(resp.Code).To(Equal(200))
+		})
+	})
+
 	Context("when draining is in progress", func() {
 		BeforeEach(func() {
 			heartbeatOK = 0
@@ -41,6 +41,7 @@ import (
 	"github.com/nats-io/nats"
 	"github.com/tedsuo/ifrit"
 	"github.com/tedsuo/ifrit/grouper"
+	"github.com/tedsuo/ifrit/http_server"
 	"github.com/tedsuo/ifrit/sigmon"
 	"github.com/uber-go/zap"
 )
@@ -141,33 +142,65 @@ func main() {
 		writer.Close()
 	}()
 
-	bigIPClient := bigipclient.DefaultClient()
+	handlers := make(map[string]http.Handler)
 
-	f5Router, err := f5router.NewF5Router(logger.Session("f5router"), c, writer, bigIPClient)
-	if nil != err {
-		logger.Fatal("f5router-failed-initialization", zap.Error(err))
+	var routerWriter f5router.Writer = writer
+	var httpWriter *f5router.HTTPWriter
+	if c.DryRun.Enabled {
+		// The config is only logged, the driver never sees it
+		logger.Warn("dry-run-enabled", zap.String("file", c.DryRun.File))
+		routerWriter = f5router.NewDryRunWriter(logger.Session("f5dryrun"), c.DryRun.File)
+	} else if c.HTTPWriter.URL != "" {
+		// The driver reading the posted config runs outside the controller
+		logger.Info("http-writer-enabled", zap.String("url", c.HTTPWriter.URL))
+		httpWriter = f5router.NewHTTPWriter(logger.Session("f5httpwriter"), c.HTTPWriter)
+		routerWriter = httpWriter
+	}
+	if c.EnableConfigEndpoint {
+		configHandler := f5router.NewConfigHandler(logger.Session("f5config-handler"), routerWriter)
+		handlers[f5router.ConfigEndpointPath] = configHandler
+		routerWriter = configHandler
 	}
 
-	var dp string
-	if 0 != len(c.BigIP.DriverCmd) {
-		logger.Warn(
-			"f5-driver-config",
-			zap.String("DEPRECATED", "driver_path: option may no longer work as expected."))
-		dp = c.BigIP.DriverCmd
+	bigIPClient, err := bigipclient.NewClient(c.BigIP.SSLVerify, c.BigIP.CACertFile)
+	if nil != err {
+		logger.Fatal("bigip-client-failed-initialization", zap.Error(err))
+	}
 
-		_, err = os.Stat(dp)
-		if os.IsNotExist(err) {
-			logger.Fatal("driver-file-does-not-exist", zap.Error(err))
+	f5Router, err := f5router.NewF5Router(logger.Session("f5router"), c, routerWriter, bigIPClient)
+	if nil != err {
+		logger.Fatal("f5router-failed-initialization", zap.Error(err))
+	}
+	f5Router.SetReporter(metricsReporter)
+	if c.RouteEvents.Enabled {
+		eventSink, err := f5router.NewRouteEventSink(logger.Session("f5route-events"), c.RouteEvents.File)
+		if nil != err {
+			logger.Fatal("route-events-failed-initialization", zap.Error(err))
 		}
-	} else {
-		dp = f5router.DefaultCmd
+		defer eventSink.Close()
+		f5Router.OnRouteEvent(eventSink.Emit)
+	}
+	if c.EnableStateEndpoint {
+		handlers[f5router.StateEndpointPath] = f5router.NewStateHandler(logger.Session("f5state-handler"), f5Router)
 	}
 
-	driver := f5router.NewDriver(
-		writer.GetOutputFilename(),
-		dp,
-		logger.Session("python-driver"),
-	)
+	var metricsServer ifrit.Runner
+	if c.MetricsAddr != "" {
+		routerMetrics := f5router.NewMetrics()
+		f5Router.SetMetrics(routerMetrics)
+		mux := http.NewServeMux()
+		mux.Handle(f5router.MetricsEndpointPath, routerMetrics)
+		metricsServer = http_server.New(c.MetricsAddr, mux)
+	}
+
+	var driver *f5router.Driver
+	// Failed config writes make the controller unhealthy with every writer
+	healthChecks := []func() bool{f5Router.Healthy}
+	if !c.DryRun.Enabled && c.HTTPWriter.URL == "" {
+		driver = setupDriver(logger, c, writer.GetOutputFilename())
+		f5Router.OnWrite(driver.ConfigWritten)
+		healthChecks = append(healthChecks, driver.Healthy)
+	}
 
 	var brokerHandler http.Handler
 	if c.BrokerMode {
@@ -209,6 +242,10 @@ func main() {
 		// Subscribe to the nats client
 		subscriber := createSubscriber(logger, c, natsClient, registry, startMsgChan, routerGroupGUID)
 		members = append(members, grouper.Member{Name: "subscriber", Runner: subscriber})
+		if c.FileSD.Path != "" {
+			fileSDWriter := rregistry.NewFileSDWriter(logger.Session("file-sd-writer"), c, registry)
+			members = append(members, grouper.Member{Name: "file-sd-writer", Runner: fileSDWriter})
+		}
 	}
 
 	// routingTable is for tcp routing routes - if not in http only mode
@@ -229,6 +266,8 @@ func main() {
 		routingTable,
 		varz,
 		brokerHandler,
+		handlers,
+		healthChecks...,
 	)
 	if nil != err {
 		logger.Fatal("failed-starting-controller", zap.Error(err))
@@ -237,7 +276,25 @@ func main() {
 	// controller handles StartResponseDelayInterval - start it before configuration ops
 	members = append(members, grouper.Member{Name: "controller", Runner: controller})
 	members = append(members, grouper.Member{Name: "f5router", Runner: f5Router})
-	members = append(members, grouper.Member{Name: "f5driver", Runner: driver})
+	if nil != driver {
+		members = append(members, grouper.Member{Name: "f5writer", Runner: writer})
+		members = append(members, grouper.Member{Name: "f5driver", Runner: driver})
+	}
+	// Stopped ahead of the router so its last writes are not retried
+	if nil != httpWriter {
+		members = append(members, grouper.Member{Name: "f5httpwriter", Runner: httpWriter})
+	}
+	if nil != metricsServer {
+		members = append(members, grouper.Member{Name: "metrics", Runner: metricsServer})
+	}
+	// Members are stopped in reverse order, the drainer goes last so the
+	// drained config is written while the writer and driver still run
+	if 0 != c.BigIP.ShutdownDrainTimeout {
+		members = append(members, grouper.Member{
+			Name:   "f5drainer",
+			Runner: f5router.NewShutdownDrainer(f5Router),
+		})
+	}
 
 	group := grouper.NewOrdered(os.Interrupt, members)
 
@@ -320,6 +377,38 @@ func setupRoutingAPIClient(
 	return client, nil
 }
 
+// setupDriver creates the driver applying the config written to configFile
+func setupDriver(
+	logger cfLogger.Logger,
+	c *config.Config,
+	configFile string,
+) *f5router.Driver {
+	var dp string
+	if 0 != len(c.BigIP.DriverCmd) {
+		logger.Warn(
+			"f5-driver-config",
+			zap.String("DEPRECATED", "driver_path: option may no longer work as expected."))
+		dp = c.BigIP.DriverCmd
+	} else {
+		dp = f5router.DefaultCmd
+	}
+
+	driver, err := f5router.NewDriver(
+		configFile,
+		dp,
+		c.BigIP.DriverPython,
+		logger.Session("python-driver"),
+	)
+	if nil != err {
+		logger.Fatal("driver-failed-initialization", zap.Error(err))
+	}
+	driver.Startup = c.DriverStartup
+	driver.Restart = c.DriverRestart
+	driver.Apply = c.ApplyCommand
+	driver.BigIP = c.BigIP
+	return driver
+}
+
 func setupRouteFetcher(
 	logger cfLogger.Logger,
 	c *config.Config,
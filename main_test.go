@@ -101,7 +101,7 @@ var _ = Describe("Router Integration", func() {
 			User:         "test",
 			Pass:         "insecure",
 			Partitions:   []string{"cloud-foundry"},
-			ExternalAddr: "127.0.0.1",
+			ExternalAddr: config.AddressList{"127.0.0.1"},
 			DriverCmd:    "testdata/fake_driver.py",
 			Tier2IPRange: "10.0.0.1/32",
 		}
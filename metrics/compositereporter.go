@@ -43,6 +43,13 @@ type RouteRegistryReporter interface {
 	CaptureLookupTime(t time.Duration)
 	CaptureRegistryMessage(msg ComponentTagged)
 	CaptureUnregistryMessage(msg ComponentTagged)
+	CaptureRejectedStaleUpdate()
+}
+
+// RouterReporter reports on the resources generated by the router
+//go:generate counterfeiter -o fakes/fake_router_reporter.go . RouterReporter
+type RouterReporter interface {
+	CapturePoolMemberWarning(pool string, members int)
 }
 
 //go:generate counterfeiter -o fakes/fake_combinedreporter.go . CombinedReporter
@@ -30,6 +30,9 @@ type FakeRouteRegistryReporter struct {
 	captureUnregistryMessageArgsForCall []struct {
 		msg metrics.ComponentTagged
 	}
+	CaptureRejectedStaleUpdateStub        func()
+	captureRejectedStaleUpdateMutex       sync.RWMutex
+	captureRejectedStaleUpdateArgsForCall []struct{}
 }
 
 func (fake *FakeRouteRegistryReporter) CaptureRouteStats(totalRoutes int, msSinceLastUpdate uint64) {
@@ -125,4 +128,19 @@ func (fake *FakeRouteRegistryReporter) CaptureUnregistryMessageArgsForCall(i int
 	return fake.captureUnregistryMessageArgsForCall[i].msg
 }
 
+func (fake *FakeRouteRegistryReporter) CaptureRejectedStaleUpdate() {
+	fake.captureRejectedStaleUpdateMutex.Lock()
+	fake.captureRejectedStaleUpdateArgsForCall = append(fake.captureRejectedStaleUpdateArgsForCall, struct{}{})
+	fake.captureRejectedStaleUpdateMutex.Unlock()
+	if fake.CaptureRejectedStaleUpdateStub != nil {
+		fake.CaptureRejectedStaleUpdateStub()
+	}
+}
+
+func (fake *FakeRouteRegistryReporter) CaptureRejectedStaleUpdateCallCount() int {
+	fake.captureRejectedStaleUpdateMutex.RLock()
+	defer fake.captureRejectedStaleUpdateMutex.RUnlock()
+	return len(fake.captureRejectedStaleUpdateArgsForCall)
+}
+
 var _ metrics.RouteRegistryReporter = new(FakeRouteRegistryReporter)
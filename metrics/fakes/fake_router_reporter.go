@@ -0,0 +1,43 @@
+// This file was generated by counterfeiter
+package fakes
+
+import (
+	"sync"
+
+	"github.com/F5Networks/cf-bigip-ctlr/metrics"
+)
+
+type FakeRouterReporter struct {
+	CapturePoolMemberWarningStub        func(pool string, members int)
+	capturePoolMemberWarningMutex       sync.RWMutex
+	capturePoolMemberWarningArgsForCall []struct {
+		pool    string
+		members int
+	}
+}
+
+func (fake *FakeRouterReporter) CapturePoolMemberWarning(pool string, members int) {
+	fake.capturePoolMemberWarningMutex.Lock()
+	fake.capturePoolMemberWarningArgsForCall = append(fake.capturePoolMemberWarningArgsForCall, struct {
+		pool    string
+		members int
+	}{pool, members})
+	fake.capturePoolMemberWarningMutex.Unlock()
+	if fake.CapturePoolMemberWarningStub != nil {
+		fake.CapturePoolMemberWarningStub(pool, members)
+	}
+}
+
+func (fake *FakeRouterReporter) CapturePoolMemberWarningCallCount() int {
+	fake.capturePoolMemberWarningMutex.RLock()
+	defer fake.capturePoolMemberWarningMutex.RUnlock()
+	return len(fake.capturePoolMemberWarningArgsForCall)
+}
+
+func (fake *FakeRouterReporter) CapturePoolMemberWarningArgsForCall(i int) (string, int) {
+	fake.capturePoolMemberWarningMutex.RLock()
+	defer fake.capturePoolMemberWarningMutex.RUnlock()
+	return fake.capturePoolMemberWarningArgsForCall[i].pool, fake.capturePoolMemberWarningArgsForCall[i].members
+}
+
+var _ metrics.RouterReporter = new(FakeRouterReporter)
@@ -102,6 +102,14 @@ func (m *MetricsReporter) CaptureUnregistryMessage(msg ComponentTagged) {
 	m.sender.IncrementCounter(componentName)
 }
 
+func (m *MetricsReporter) CaptureRejectedStaleUpdate() {
+	m.sender.IncrementCounter("rejected_stale_updates")
+}
+
+func (m *MetricsReporter) CapturePoolMemberWarning(pool string, members int) {
+	m.sender.IncrementCounter("pool_member_warnings")
+}
+
 func (m *MetricsReporter) CaptureWebSocketUpdate() {
 	m.batcher.BatchIncrementCounter("websocket_upgrades")
 }
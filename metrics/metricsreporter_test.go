@@ -364,6 +364,26 @@ var _ = Describe("MetricsReporter", func() {
 		})
 	})
 
+	Describe("Rejected stale updates", func() {
+		It("increments the counter metric", func() {
+			metricReporter.CaptureRejectedStaleUpdate()
+			metricReporter.CaptureRejectedStaleUpdate()
+
+			Expect(sender.IncrementCounterCallCount()).To(Equal(2))
+			Expect(sender.IncrementCounterArgsForCall(0)).To(Equal("rejected_stale_updates"))
+			Expect(sender.IncrementCounterArgsForCall(1)).To(Equal("rejected_stale_updates"))
+		})
+	})
+
+	Describe("Pool member warnings", func() {
+		It("increments the counter metric", func() {
+			metricReporter.CapturePoolMemberWarning("cf-foo", 100)
+
+			Expect(sender.IncrementCounterCallCount()).To(Equal(1))
+			Expect(sender.IncrementCounterArgsForCall(0)).To(Equal("pool_member_warnings"))
+		})
+	})
+
 	Describe("Unregister messages", func() {
 		var endpoint *route.Endpoint
 		Context("when unregister msg with component name is incremented", func() {
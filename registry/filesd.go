@@ -0,0 +1,160 @@
+/*-
+ * Copyright (c) 2018, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/F5Networks/cf-bigip-ctlr/config"
+	"github.com/F5Networks/cf-bigip-ctlr/logger"
+	"github.com/F5Networks/cf-bigip-ctlr/registry/container"
+	"github.com/F5Networks/cf-bigip-ctlr/route"
+
+	"github.com/uber-go/zap"
+)
+
+const (
+	// FileSDRouteLabel label holding the route of the targets
+	FileSDRouteLabel = "route"
+	// FileSDHostLabel label holding the host of the route
+	FileSDHostLabel = "host"
+)
+
+// FileSDGroup is a Prometheus file based service discovery target group
+type FileSDGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// FileSDGroups returns a target group for each route in the trie holding the
+// route's members, sorted by route
+func FileSDGroups(t *container.Trie) []FileSDGroup {
+	pools := t.ToMap()
+	uris := make([]string, 0, len(pools))
+	for uri := range pools {
+		uris = append(uris, uri.String())
+	}
+	sort.Strings(uris)
+
+	groups := make([]FileSDGroup, 0, len(uris))
+	for _, uri := range uris {
+		var targets []string
+		pools[route.Uri(uri)].Each(func(e *route.Endpoint) {
+			targets = append(targets, e.CanonicalAddr())
+		})
+		if len(targets) == 0 {
+			continue
+		}
+		sort.Strings(targets)
+
+		groups = append(groups, FileSDGroup{
+			Targets: targets,
+			Labels: map[string]string{
+				FileSDRouteLabel: uri,
+				FileSDHostLabel:  strings.SplitN(uri, "/", 2)[0],
+			},
+		})
+	}
+	return groups
+}
+
+// FileSDGroups returns the file based service discovery target groups for
+// the registered routes
+func (r *RouteRegistry) FileSDGroups() []FileSDGroup {
+	r.RLock()
+	defer r.RUnlock()
+
+	return FileSDGroups(r.byURI)
+}
+
+// FileSDWriter writes the registered routes as Prometheus file based service
+// discovery targets on an interval
+type FileSDWriter struct {
+	logger   logger.Logger
+	registry *RouteRegistry
+	path     string
+	interval time.Duration
+	last     []byte
+}
+
+// NewFileSDWriter creates a FileSDWriter writing to the configured file_sd path
+func NewFileSDWriter(
+	logger logger.Logger,
+	c *config.Config,
+	registry *RouteRegistry,
+) *FileSDWriter {
+	return &FileSDWriter{
+		logger:   logger,
+		registry: registry,
+		path:     c.FileSD.Path,
+		interval: c.FileSD.Interval,
+	}
+}
+
+// Write writes out the target groups if they changed since the last write,
+// the file is replaced in one step so Prometheus never reads a partial file
+func (w *FileSDWriter) Write() error {
+	data, err := json.Marshal(w.registry.FileSDGroups())
+	if nil != err {
+		return err
+	}
+	if nil != w.last && bytes.Equal(data, w.last) {
+		return nil
+	}
+
+	tmp := w.path + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if nil != err {
+		return err
+	}
+	err = os.Rename(tmp, w.path)
+	if nil != err {
+		os.Remove(tmp)
+		return err
+	}
+	w.last = data
+	w.logger.Debug("file-sd-written", zap.String("path", w.path))
+	return nil
+}
+
+// Run writes the target groups on start and then on every interval
+func (w *FileSDWriter) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	if err := w.Write(); nil != err {
+		w.logger.Error("file-sd-write-error", zap.Error(err))
+	}
+	close(ready)
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := w.Write(); nil != err {
+				w.logger.Error("file-sd-write-error", zap.Error(err))
+			}
+		case <-signals:
+			return nil
+		}
+	}
+}
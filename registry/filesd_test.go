@@ -0,0 +1,152 @@
+/*-
+ * Copyright (c) 2018, F5 Networks, Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package registry_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/F5Networks/cf-bigip-ctlr/config"
+	"github.com/F5Networks/cf-bigip-ctlr/metrics/fakes"
+	. "github.com/F5Networks/cf-bigip-ctlr/registry"
+	"github.com/F5Networks/cf-bigip-ctlr/registry/container"
+	"github.com/F5Networks/cf-bigip-ctlr/route"
+	"github.com/F5Networks/cf-bigip-ctlr/test_util"
+
+	"code.cloudfoundry.org/routing-api/models"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/tedsuo/ifrit"
+)
+
+var _ = Describe("FileSD", func() {
+	makeEndpoint := func(addr string, port uint16) *route.Endpoint {
+		return route.NewEndpoint("app-id", addr, port, "", "", nil, -1, "", models.ModificationTag{})
+	}
+
+	Describe("FileSDGroups", func() {
+		It("lists the members of each route labeled by route and host", func() {
+			trie := container.NewTrie()
+
+			foo := route.NewPool(0, "")
+			foo.Put(makeEndpoint("192.168.1.2", 8080))
+			foo.Put(makeEndpoint("192.168.1.1", 8080))
+			trie.Insert("foo.cf.com", foo)
+
+			fooPath := route.NewPool(0, "/path")
+			fooPath.Put(makeEndpoint("192.168.1.3", 8080))
+			trie.Insert("foo.cf.com/path", fooPath)
+
+			wild := route.NewPool(0, "")
+			wild.Put(makeEndpoint("192.168.1.4", 9090))
+			trie.Insert("*.cf.com", wild)
+
+			trie.Insert("empty.cf.com", route.NewPool(0, ""))
+
+			Expect(FileSDGroups(trie)).To(Equal([]FileSDGroup{
+				{
+					Targets: []string{"192.168.1.4:9090"},
+					Labels:  map[string]string{"route": "*.cf.com", "host": "*.cf.com"},
+				},
+				{
+					Targets: []string{"192.168.1.1:8080", "192.168.1.2:8080"},
+					Labels:  map[string]string{"route": "foo.cf.com", "host": "foo.cf.com"},
+				},
+				{
+					Targets: []string{"192.168.1.3:8080"},
+					Labels:  map[string]string{"route": "foo.cf.com/path", "host": "foo.cf.com"},
+				},
+			}))
+		})
+
+		It("is empty without routes", func() {
+			Expect(FileSDGroups(container.NewTrie())).To(BeEmpty())
+		})
+	})
+
+	Describe("FileSDWriter", func() {
+		var (
+			dir      string
+			path     string
+			c        *config.Config
+			logger   *test_util.TestZapLogger
+			registry *RouteRegistry
+			writer   *FileSDWriter
+		)
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "file-sd")
+			Expect(err).NotTo(HaveOccurred())
+			path = filepath.Join(dir, "targets.json")
+
+			c = config.DefaultConfig()
+			c.FileSD.Path = path
+			c.FileSD.Interval = 10 * time.Millisecond
+			logger = test_util.NewTestZapLogger("file-sd-test")
+			registry = NewRouteRegistry(logger, c, nil, new(fakes.FakeRouteRegistryReporter), "")
+			writer = NewFileSDWriter(logger, c, registry)
+		})
+
+		AfterEach(func() {
+			logger.Close()
+			os.RemoveAll(dir)
+		})
+
+		It("writes the target groups", func() {
+			registry.Register("foo.cf.com", makeEndpoint("192.168.1.1", 8080))
+			Expect(writer.Write()).To(Succeed())
+
+			written, err := ioutil.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(written).To(MatchJSON(
+				`[{"targets":["192.168.1.1:8080"],"labels":{"route":"foo.cf.com","host":"foo.cf.com"}}]`))
+			Expect(path + ".tmp").NotTo(BeAnExistingFile())
+		})
+
+		It("writes an empty list without routes", func() {
+			Expect(writer.Write()).To(Succeed())
+
+			written, err := ioutil.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(written).To(MatchJSON(`[]`))
+		})
+
+		It("keeps the file up to date while running", func() {
+			process := ifrit.Invoke(writer)
+			defer func() {
+				process.Signal(os.Interrupt)
+				Eventually(process.Wait()).Should(Receive(BeNil()))
+			}()
+			Expect(path).To(BeARegularFile())
+
+			registry.Register("foo.cf.com", makeEndpoint("192.168.1.1", 8080))
+			Eventually(func() string {
+				written, _ := ioutil.ReadFile(path)
+				return string(written)
+			}).Should(ContainSubstring("192.168.1.1:8080"))
+
+			registry.Unregister("foo.cf.com", makeEndpoint("192.168.1.1", 8080))
+			Eventually(func() string {
+				written, _ := ioutil.ReadFile(path)
+				return string(written)
+			}).Should(Equal("[]"))
+		})
+	})
+})
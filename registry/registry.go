@@ -18,6 +18,7 @@ import (
 	"github.com/F5Networks/cf-bigip-ctlr/registry/container"
 	"github.com/F5Networks/cf-bigip-ctlr/route"
 
+	"code.cloudfoundry.org/routing-api/models"
 	"github.com/uber-go/zap"
 )
 
@@ -38,6 +39,13 @@ type Registry interface {
 
 type PruneStatus int
 
+// endpointTag is the newest modification tag seen for an endpoint of a uri,
+// kept around after removal so late updates can not resurrect the endpoint
+type endpointTag struct {
+	tag       models.ModificationTag
+	removedAt time.Time
+}
+
 const (
 	CONNECTED = PruneStatus(iota)
 	DISCONNECTED
@@ -66,6 +74,18 @@ type RouteRegistry struct {
 	routerGroupGUID string
 
 	listener routeUpdate.Listener
+	// updateLock orders the registry changes and holds the route updates
+	// waiting to be sent to the listener. It is taken before the RWMutex, so
+	// the listener may block without holding up registry reads.
+	updateLock     sync.Mutex
+	pendingUpdates []routeUpdate.RouteUpdate
+
+	// Access to endpointTags should be governed by the RWMutex of RouteRegistry
+	rejectStaleUpdates  bool
+	replaceOnGUIDChange bool
+	endpointTags        map[string]*endpointTag
+
+	endpointFilter config.EndpointFilterConfig
 
 	c *config.Config
 }
@@ -88,6 +108,10 @@ func NewRouteRegistry(
 	r.reporter = reporter
 	r.routerGroupGUID = routerGroupGUID
 	r.listener = listener
+	r.rejectStaleUpdates = c.StaleUpdateAction == config.STALE_UPDATE_REJECT
+	r.replaceOnGUIDChange = c.GUIDChangeAction != config.GUID_CHANGE_KEEP
+	r.endpointTags = make(map[string]*endpointTag)
+	r.endpointFilter = c.EndpointFilter
 	r.c = c
 	return r
 }
@@ -95,27 +119,78 @@ func NewRouteRegistry(
 func (r *RouteRegistry) Register(uri route.Uri, endpoint *route.Endpoint) {
 	t := time.Now()
 
+	r.updateLock.Lock()
+	defer r.updateLock.Unlock()
+	defer r.sendUpdates()
 	r.Lock()
 
 	routekey := uri.RouteKey()
 
-	var updateRoute bool
+	tagKey := endpointTagKey(routekey, endpoint)
+	if r.isStaleUpdate(tagKey, endpoint) {
+		r.Unlock()
+		r.reporter.CaptureRegistryMessage(endpoint)
+		r.rejectStaleUpdate("register", uri, endpoint)
+		return
+	}
+
+	var existing *route.Endpoint
 	pool := r.byURI.Find(routekey)
 	if pool == nil {
 		contextPath := parseContextPath(uri)
 		pool = route.NewPool(r.dropletStaleThreshold/4, contextPath)
 		r.byURI.Insert(routekey, pool)
 		r.logger.Debug("uri-added", zap.Stringer("uri", routekey))
-		updateRoute = true
 	} else {
-		if nil == pool.FindById(endpoint.CanonicalAddr()) {
-			updateRoute = true
-		}
+		existing = pool.FindById(endpoint.CanonicalAddr())
+	}
+
+	// A different guid for the same address is a new instance reusing it.
+	// The modification tag index breaks the tie, a registration with a lower
+	// index than the existing endpoint is an older instance arriving late.
+	guidChanged := isGUIDChange(existing, endpoint)
+	if guidChanged && (!r.replaceOnGUIDChange || isOlderIndex(existing, endpoint)) {
+		r.Unlock()
+		r.reporter.CaptureRegistryMessage(endpoint)
+		r.logger.Info("endpoint-guid-change-ignored",
+			zap.Stringer("uri", routekey),
+			zap.String("backend", endpoint.CanonicalAddr()),
+			zap.Object("existing_modification_tag", existing.ModificationTag),
+			zap.Object("modification_tag", endpoint.ModificationTag),
+		)
+		return
 	}
 
 	endpointAdded := pool.Put(endpoint)
-	if endpointAdded && updateRoute && nil != r.listener {
-		r.updateRouter(routeUpdate.Add, routekey, endpoint)
+	if endpointAdded {
+		r.recordTag(tagKey, endpoint, false)
+	}
+	if endpointAdded && guidChanged {
+		r.logger.Info("endpoint-replaced",
+			zap.Stringer("uri", routekey),
+			zap.String("backend", endpoint.CanonicalAddr()),
+			zap.Object("existing_modification_tag", existing.ModificationTag),
+			zap.Object("modification_tag", endpoint.ModificationTag),
+		)
+	}
+	if endpointAdded && nil != r.listener {
+		// Filtered endpoints stay in the registry so they become members once
+		// their tags match and stop being members once they no longer match
+		wasMember := nil != existing && r.endpointFilter.Matches(existing.Tags)
+		isMember := r.endpointFilter.Matches(endpoint.Tags)
+		if isMember && (!wasMember || guidChanged) {
+			// The router keeps one member per address, adding the replacement
+			// updates it in place
+			r.updateRouter(routeUpdate.Add, routekey, endpoint)
+		} else if !isMember && wasMember {
+			r.updateRouter(routeUpdate.Remove, routekey, existing)
+		}
+		if !isMember {
+			r.logger.Debug("endpoint-filtered",
+				zap.Stringer("uri", routekey),
+				zap.String("backend", endpoint.CanonicalAddr()),
+			)
+		}
 	}
 
 	r.timeOfLastUpdate = t
@@ -155,12 +230,24 @@ func (r *RouteRegistry) Unregister(uri route.Uri, endpoint *route.Endpoint) {
 		zap.Object("modification_tag", endpoint.ModificationTag),
 	}
 
+	r.updateLock.Lock()
+	defer r.updateLock.Unlock()
+	defer r.sendUpdates()
 	r.Lock()
 
 	uri = uri.RouteKey()
 
+	tagKey := endpointTagKey(uri, endpoint)
+	if r.isStaleUpdate(tagKey, endpoint) {
+		r.Unlock()
+		r.reporter.CaptureUnregistryMessage(endpoint)
+		r.rejectStaleUpdate("unregister", uri, endpoint)
+		return
+	}
+
 	pool := r.byURI.Find(uri)
 	if pool != nil {
+		existing := pool.FindById(endpoint.CanonicalAddr())
 		endpointRemoved := pool.Remove(endpoint)
 		emptiedPool := pool.IsEmpty()
 
@@ -169,7 +256,8 @@ func (r *RouteRegistry) Unregister(uri route.Uri, endpoint *route.Endpoint) {
 		}
 
 		if endpointRemoved {
-			if nil != r.listener {
+			r.recordTag(tagKey, endpoint, true)
+			if nil != r.listener && r.endpointFilter.Matches(existing.Tags) {
 				r.updateRouter(routeUpdate.Remove, uri, endpoint)
 			}
 			r.logger.Debug("endpoint-unregistered", zapData...)
@@ -304,6 +392,9 @@ func (r *RouteRegistry) MarshalJSON() ([]byte, error) {
 }
 
 func (r *RouteRegistry) pruneStaleDroplets() {
+	r.updateLock.Lock()
+	defer r.updateLock.Unlock()
+	defer r.sendUpdates()
 	r.Lock()
 	defer r.Unlock()
 
@@ -327,6 +418,8 @@ func (r *RouteRegistry) pruneStaleDroplets() {
 		routerGroupGUID = "-"
 	}
 
+	r.pruneEndpointTags()
+
 	r.byURI.EachNodeWithPool(func(t *container.Trie) {
 		endpoints := t.Pool.PruneEndpoints(r.dropletStaleThreshold)
 		t.Snip()
@@ -334,7 +427,8 @@ func (r *RouteRegistry) pruneStaleDroplets() {
 			addresses := []string{}
 			for _, e := range endpoints {
 				addresses = append(addresses, e.CanonicalAddr())
-				if nil != r.listener {
+				r.recordTag(endpointTagKey(route.Uri(t.ToPath()), e), e, true)
+				if nil != r.listener && r.endpointFilter.Matches(e.Tags) {
 					r.updateRouter(routeUpdate.Remove, route.Uri(t.ToPath()), e)
 				}
 			}
@@ -361,6 +455,8 @@ func (r *RouteRegistry) freshenRoutes() {
 	})
 }
 
+// updateRouter holds a route update until sendUpdates, the caller holds
+// updateLock
 func (r *RouteRegistry) updateRouter(
 	updateType routeUpdate.Operation,
 	uri route.Uri,
@@ -373,10 +469,89 @@ func (r *RouteRegistry) updateRouter(
 			zap.String("operation", routeUpdate.Remove.String()),
 		)
 	} else {
+		r.pendingUpdates = append(r.pendingUpdates, update)
+	}
+}
+
+// sendUpdates sends the held route updates to the listener, which may wait
+// for room for them. The caller holds updateLock but not the RWMutex.
+func (r *RouteRegistry) sendUpdates() {
+	updates := r.pendingUpdates
+	r.pendingUpdates = nil
+	for _, update := range updates {
 		r.listener.UpdateRoute(update)
 	}
 }
 
+// isGUIDChange returns true when an endpoint is registered for the address of
+// an existing endpoint with a different modification tag guid
+func isGUIDChange(existing, endpoint *route.Endpoint) bool {
+	if nil == existing {
+		return false
+	}
+	guid := existing.ModificationTag.Guid
+	return guid != "" && endpoint.ModificationTag.Guid != "" && guid != endpoint.ModificationTag.Guid
+}
+
+// isOlderIndex returns true when the endpoint has a lower modification tag
+// index than the existing endpoint, regardless of their guids
+func isOlderIndex(existing, endpoint *route.Endpoint) bool {
+	return endpoint.ModificationTag.Index < existing.ModificationTag.Index
+}
+
+func endpointTagKey(uri route.Uri, endpoint *route.Endpoint) string {
+	return uri.String() + "|" + endpoint.CanonicalAddr()
+}
+
+// isStaleUpdate returns true if the endpoint carries a modification tag older
+// than the newest one seen for it, only tags with the same guid are comparable
+func (r *RouteRegistry) isStaleUpdate(key string, endpoint *route.Endpoint) bool {
+	if !r.rejectStaleUpdates {
+		return false
+	}
+
+	seen, ok := r.endpointTags[key]
+	if !ok {
+		return false
+	}
+
+	tag := endpoint.ModificationTag
+	return tag.Guid != "" && tag.Guid == seen.tag.Guid && tag.Index < seen.tag.Index
+}
+
+func (r *RouteRegistry) recordTag(key string, endpoint *route.Endpoint, removed bool) {
+	if !r.rejectStaleUpdates {
+		return
+	}
+
+	seen := &endpointTag{tag: endpoint.ModificationTag}
+	if removed {
+		seen.removedAt = time.Now()
+	}
+	r.endpointTags[key] = seen
+}
+
+// pruneEndpointTags forgets the tags of endpoints removed longer ago than the
+// droplet stale threshold
+func (r *RouteRegistry) pruneEndpointTags() {
+	staleTime := time.Now().Add(-r.dropletStaleThreshold)
+	for key, seen := range r.endpointTags {
+		if !seen.removedAt.IsZero() && seen.removedAt.Before(staleTime) {
+			delete(r.endpointTags, key)
+		}
+	}
+}
+
+func (r *RouteRegistry) rejectStaleUpdate(op string, uri route.Uri, endpoint *route.Endpoint) {
+	r.reporter.CaptureRejectedStaleUpdate()
+	r.logger.Info("endpoint-stale-update-rejected",
+		zap.String("operation", op),
+		zap.Stringer("uri", uri),
+		zap.String("backend", endpoint.CanonicalAddr()),
+		zap.Object("modification_tag", endpoint.ModificationTag),
+	)
+}
+
 func parseContextPath(uri route.Uri) string {
 	contextPath := "/"
 	split := strings.SplitN(strings.TrimPrefix(uri.String(), "/"), "/", 2)
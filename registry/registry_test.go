@@ -10,6 +10,8 @@ import (
 	"time"
 
 	"github.com/F5Networks/cf-bigip-ctlr/config"
+	"github.com/F5Networks/cf-bigip-ctlr/f5router/routeUpdate"
+	routeFakes "github.com/F5Networks/cf-bigip-ctlr/f5router/routeUpdate/fakes"
 	"github.com/F5Networks/cf-bigip-ctlr/logger"
 	"github.com/F5Networks/cf-bigip-ctlr/metrics/fakes"
 	. "github.com/F5Networks/cf-bigip-ctlr/registry"
@@ -359,6 +361,72 @@ var _ = Describe("RouteRegistry", func() {
 				})
 			})
 
+			Context("when updates arrive out of order", func() {
+				var (
+					newer *route.Endpoint
+					older *route.Endpoint
+				)
+
+				BeforeEach(func() {
+					newer = route.NewEndpoint("", "1.1.1.1", 1234, "", "", nil, -1, "",
+						models.ModificationTag{Guid: "abc", Index: 2})
+					older = route.NewEndpoint("", "1.1.1.1", 1234, "", "", nil, -1, "",
+						models.ModificationTag{Guid: "abc", Index: 1})
+					r.Register("foo.com", newer)
+				})
+
+				It("ignores a stale unregister", func() {
+					r.Unregister("foo.com", older)
+
+					Expect(r.NumEndpoints()).To(Equal(1))
+					Expect(reporter.CaptureRejectedStaleUpdateCallCount()).To(Equal(1))
+					Expect(logger).To(gbytes.Say(`endpoint-stale-update-rejected.*unregister`))
+				})
+
+				It("does not resurrect a removed endpoint with a stale register", func() {
+					removed := route.NewEndpoint("", "1.1.1.1", 1234, "", "", nil, -1, "",
+						models.ModificationTag{Guid: "abc", Index: 3})
+					r.Unregister("foo.com", removed)
+					Expect(r.NumEndpoints()).To(Equal(0))
+
+					r.Register("foo.com", newer)
+					r.Register("foo.com", older)
+
+					Expect(r.NumUris()).To(Equal(0))
+					Expect(r.NumEndpoints()).To(Equal(0))
+					Expect(reporter.CaptureRejectedStaleUpdateCallCount()).To(Equal(2))
+				})
+
+				It("accepts a newer register after removal", func() {
+					r.Unregister("foo.com", newer)
+					Expect(r.NumEndpoints()).To(Equal(0))
+
+					newest := route.NewEndpoint("", "1.1.1.1", 1234, "", "", nil, -1, "",
+						models.ModificationTag{Guid: "abc", Index: 3})
+					r.Register("foo.com", newest)
+
+					Expect(r.NumEndpoints()).To(Equal(1))
+					Expect(reporter.CaptureRejectedStaleUpdateCallCount()).To(Equal(0))
+				})
+
+				Context("when stale updates are accepted", func() {
+					BeforeEach(func() {
+						configObj.StaleUpdateAction = config.STALE_UPDATE_ACCEPT
+						r = NewRouteRegistry(logger, configObj, nil, reporter, routerGroupGuid)
+						r.Register("foo.com", newer)
+					})
+
+					It("registers a removed endpoint again with a stale register", func() {
+						r.Unregister("foo.com", newer)
+						Expect(r.NumEndpoints()).To(Equal(0))
+
+						r.Register("foo.com", older)
+
+						Expect(r.NumEndpoints()).To(Equal(1))
+						Expect(reporter.CaptureRejectedStaleUpdateCallCount()).To(Equal(0))
+					})
+				})
+			})
 		})
 	})
 
@@ -1109,6 +1177,234 @@ var _ = Describe("RouteRegistry", func() {
 		})
 	})
 
+	Context("endpoint filter", func() {
+		var listener *routeFakes.FakeListener
+
+		tagged := func(addr string, index uint32, tags map[string]string) *route.Endpoint {
+			return route.NewEndpoint("", addr, 1234, "", "", tags, -1, "",
+				models.ModificationTag{Guid: "abc", Index: index})
+		}
+
+		updates := func() []string {
+			var ops []string
+			for i := 0; i < listener.UpdateRouteCallCount(); i++ {
+				ru := listener.UpdateRouteArgsForCall(i)
+				ops = append(ops, ru.Op().String()+" "+ru.Route())
+			}
+			return ops
+		}
+
+		BeforeEach(func() {
+			configObj.EndpointFilter.Allow = map[string][]string{"tier": []string{"production"}}
+			configObj.EndpointFilter.Deny = map[string][]string{"canary": []string{"true"}}
+			listener = &routeFakes.FakeListener{}
+			r = NewRouteRegistry(logger, configObj, listener, reporter, routerGroupGuid)
+		})
+
+		It("only adds matching endpoints as pool members", func() {
+			r.Register("foo.com", tagged("1.1.1.1", 1, map[string]string{"tier": "production"}))
+			r.Register("foo.com", tagged("1.1.1.2", 1, map[string]string{"tier": "staging"}))
+			r.Register("foo.com", tagged("1.1.1.3", 1, nil))
+			r.Register("foo.com", tagged("1.1.1.4", 1, map[string]string{"tier": "production", "canary": "true"}))
+
+			Expect(updates()).To(Equal([]string{"Add foo.com"}))
+			configObj.BigIP.Partitions = []string{"cf"}
+			ru := listener.UpdateRouteArgsForCall(0)
+			rs, err := ru.CreateResources(configObj)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rs.Pools[0].Members[0].Address).To(Equal("1.1.1.1"))
+
+			// Filtered endpoints are still tracked by the registry
+			Expect(r.NumEndpoints()).To(Equal(4))
+		})
+
+		It("adds an endpoint once its tags match", func() {
+			r.Register("foo.com", tagged("1.1.1.1", 1, map[string]string{"tier": "staging"}))
+			Expect(updates()).To(BeEmpty())
+
+			r.Register("foo.com", tagged("1.1.1.1", 2, map[string]string{"tier": "production"}))
+			Expect(updates()).To(Equal([]string{"Add foo.com"}))
+		})
+
+		It("removes a member once its tags no longer match", func() {
+			r.Register("foo.com", tagged("1.1.1.1", 1, map[string]string{"tier": "production"}))
+			r.Register("foo.com", tagged("1.1.1.1", 2, map[string]string{"tier": "production", "canary": "true"}))
+
+			Expect(updates()).To(Equal([]string{"Add foo.com", "Remove foo.com"}))
+			Expect(r.NumEndpoints()).To(Equal(1))
+		})
+
+		It("does not remove endpoints which were never members", func() {
+			r.Register("foo.com", tagged("1.1.1.1", 1, map[string]string{"tier": "staging"}))
+			r.Unregister("foo.com", tagged("1.1.1.1", 1, nil))
+
+			Expect(updates()).To(BeEmpty())
+			Expect(r.NumEndpoints()).To(Equal(0))
+		})
+
+		It("removes members on unregister", func() {
+			r.Register("foo.com", tagged("1.1.1.1", 1, map[string]string{"tier": "production"}))
+			r.Unregister("foo.com", tagged("1.1.1.1", 1, nil))
+
+			Expect(updates()).To(Equal([]string{"Add foo.com", "Remove foo.com"}))
+		})
+	})
+
+	Context("when the listener queue is full", func() {
+		var (
+			listener *routeFakes.FakeListener
+			room     chan struct{}
+		)
+
+		BeforeEach(func() {
+			// The listener waits for room the way a router at its pending
+			// updates limit does
+			room = make(chan struct{})
+			listener = &routeFakes.FakeListener{}
+			listener.UpdateRouteStub = func(routeUpdate.RouteUpdate) {
+				<-room
+			}
+			r = NewRouteRegistry(logger, configObj, listener, reporter, routerGroupGuid)
+		})
+
+		It("serves reads while an update waits for room", func() {
+			registered := make(chan struct{})
+			go func() {
+				r.Register("foo.com", fooEndpoint)
+				close(registered)
+			}()
+			Eventually(listener.UpdateRouteCallCount).Should(Equal(1))
+			Consistently(registered).ShouldNot(BeClosed())
+
+			read := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				Expect(r.Lookup("foo.com").IsEmpty()).To(BeFalse())
+				Expect(r.NumUris()).To(Equal(1))
+				Expect(r.NumEndpoints()).To(Equal(1))
+				close(read)
+			}()
+			Eventually(read).Should(BeClosed())
+
+			close(room)
+			Eventually(registered).Should(BeClosed())
+		})
+
+		It("sends the updates in registry order", func() {
+			close(room)
+			r.Register("foo.com", fooEndpoint)
+			r.Unregister("foo.com", fooEndpoint)
+			r.Register("bar.com", barEndpoint)
+
+			Expect(listener.UpdateRouteCallCount()).To(Equal(3))
+			var ops []string
+			for i := 0; i < listener.UpdateRouteCallCount(); i++ {
+				ru := listener.UpdateRouteArgsForCall(i)
+				ops = append(ops, ru.Op().String()+" "+ru.Route())
+			}
+			Expect(ops).To(Equal([]string{"Add foo.com", "Remove foo.com", "Add bar.com"}))
+		})
+	})
+
+	Context("when modification tag guids differ for the same address", func() {
+		var listener *routeFakes.FakeListener
+
+		tagged := func(guid string, index uint32) *route.Endpoint {
+			return route.NewEndpoint("", "1.1.1.1", 1234, "", "", nil, -1, "",
+				models.ModificationTag{Guid: guid, Index: index})
+		}
+
+		updates := func() []string {
+			var ops []string
+			for i := 0; i < listener.UpdateRouteCallCount(); i++ {
+				ru := listener.UpdateRouteArgsForCall(i)
+				ops = append(ops, ru.Op().String()+" "+ru.Route())
+			}
+			return ops
+		}
+
+		current := func() models.ModificationTag {
+			Expect(r.NumEndpoints()).To(Equal(1))
+			return r.Lookup("foo.com").Endpoints("", "").Next().ModificationTag
+		}
+
+		BeforeEach(func() {
+			listener = &routeFakes.FakeListener{}
+			r = NewRouteRegistry(logger, configObj, listener, reporter, routerGroupGuid)
+			r.Register("foo.com", tagged("abc", 5))
+		})
+
+		It("replaces the existing endpoint with the newest guid", func() {
+			r.Register("foo.com", tagged("def", 6))
+
+			Expect(current()).To(Equal(models.ModificationTag{Guid: "def", Index: 6}))
+			Expect(logger).To(gbytes.Say("endpoint-replaced"))
+
+			// The replacement updates the single member for the address
+			Expect(updates()).To(Equal([]string{"Add foo.com", "Add foo.com"}))
+			configObj.BigIP.Partitions = []string{"cf"}
+			rs, err := listener.UpdateRouteArgsForCall(1).CreateResources(configObj)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rs.Pools[0].Members).To(HaveLen(1))
+			Expect(rs.Pools[0].Members[0].Address).To(Equal("1.1.1.1"))
+		})
+
+		It("replaces the existing endpoint on a guid change with the same index", func() {
+			r.Register("foo.com", tagged("def", 5))
+
+			Expect(current()).To(Equal(models.ModificationTag{Guid: "def", Index: 5}))
+			Expect(logger).To(gbytes.Say("endpoint-replaced"))
+		})
+
+		It("keeps the existing endpoint when the older index arrives second", func() {
+			r.Register("foo.com", tagged("def", 3))
+
+			Expect(current()).To(Equal(models.ModificationTag{Guid: "abc", Index: 5}))
+			Expect(logger).To(gbytes.Say("endpoint-guid-change-ignored"))
+			Expect(updates()).To(Equal([]string{"Add foo.com"}))
+		})
+
+		It("replaces an older index arriving first", func() {
+			r.Register("bar.com", route.NewEndpoint("", "1.1.1.2", 1234, "", "", nil, -1, "",
+				models.ModificationTag{Guid: "def", Index: 3}))
+			r.Register("bar.com", route.NewEndpoint("", "1.1.1.2", 1234, "", "", nil, -1, "",
+				models.ModificationTag{Guid: "abc", Index: 5}))
+
+			ep := r.Lookup("bar.com").Endpoints("", "").Next()
+			Expect(ep.ModificationTag).To(Equal(models.ModificationTag{Guid: "abc", Index: 5}))
+			Expect(logger).To(gbytes.Say("endpoint-replaced"))
+		})
+
+		It("orders updates with the same guid by index", func() {
+			r.Register("foo.com", tagged("def", 7))
+			r.Register("foo.com", tagged("def", 6))
+
+			Expect(current()).To(Equal(models.ModificationTag{Guid: "def", Index: 7}))
+			Expect(reporter.CaptureRejectedStaleUpdateCallCount()).To(Equal(1))
+		})
+
+		Context("when guid changes are configured to keep the existing endpoint", func() {
+			BeforeEach(func() {
+				configObj.GUIDChangeAction = config.GUID_CHANGE_KEEP
+				listener = &routeFakes.FakeListener{}
+				r = NewRouteRegistry(logger, configObj, listener, reporter, routerGroupGuid)
+				r.Register("foo.com", tagged("abc", 5))
+			})
+
+			It("keeps the existing endpoint until it is removed", func() {
+				r.Register("foo.com", tagged("def", 0))
+
+				Expect(current()).To(Equal(models.ModificationTag{Guid: "abc", Index: 5}))
+				Expect(logger).To(gbytes.Say("endpoint-guid-change-ignored"))
+				Expect(updates()).To(Equal([]string{"Add foo.com"}))
+
+				r.Unregister("foo.com", tagged("abc", 5))
+				r.Register("foo.com", tagged("def", 0))
+				Expect(current()).To(Equal(models.ModificationTag{Guid: "def", Index: 0}))
+			})
+		})
+	})
+
 	It("marshals", func() {
 		m := route.NewEndpoint("", "192.168.1.1", 1234, "", "", nil, -1, "https://my-routeService.com", modTag)
 		r.Register("foo", m)
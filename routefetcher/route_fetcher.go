@@ -7,6 +7,7 @@ package routefetcher
 import (
 	"errors"
 	"os"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -31,6 +32,7 @@ type RouteClient interface {
 type RouteFetcher struct {
 	UaaClient                          uaa_client.Client
 	FetchRoutesInterval                time.Duration
+	MinFetchRoutesInterval             time.Duration
 	SubscriptionRetryIntervalInSeconds int
 
 	logger          logger.Logger
@@ -41,6 +43,11 @@ type RouteFetcher struct {
 	eventChannel    chan interface{}
 	routeClient     RouteClient
 
+	fetchLock    sync.Mutex
+	lastFetch    time.Time
+	fetchPending bool
+	done         chan struct{}
+
 	clock clock.Clock
 }
 
@@ -64,11 +71,13 @@ func NewRouteFetcher(
 	return &RouteFetcher{
 		UaaClient:                          uaaClient,
 		FetchRoutesInterval:                cfg.PruneStaleDropletsInterval / 2,
+		MinFetchRoutesInterval:             cfg.MinFetchRoutesInterval,
 		SubscriptionRetryIntervalInSeconds: subscriptionRetryInterval,
 
 		client:       client,
 		logger:       logger,
 		eventChannel: make(chan interface{}, 1024),
+		done:         make(chan struct{}),
 		clock:        clock,
 		routeClient:  rc,
 	}
@@ -85,7 +94,7 @@ func (r *RouteFetcher) Run(signals <-chan os.Signal, ready chan<- struct{}) erro
 	for {
 		select {
 		case <-ticker.C():
-			err := r.routeClient.FetchRoutes()
+			err := r.RequestFetch()
 			if err != nil {
 				r.logger.Error("failed-to-fetch-routes", zap.Error(err))
 			}
@@ -95,6 +104,7 @@ func (r *RouteFetcher) Run(signals <-chan os.Signal, ready chan<- struct{}) erro
 		case <-signals:
 			r.logger.Info("stopping")
 			atomic.StoreInt32(&r.stopEventSource, 1)
+			close(r.done)
 			if es := r.eventSource.Load(); es != nil {
 				var err error
 				switch es.(type) {
@@ -115,6 +125,56 @@ func (r *RouteFetcher) Run(signals <-chan os.Signal, ready chan<- struct{}) erro
 	}
 }
 
+// RequestFetch fetches all routes from the routing api. Requests made within
+// MinFetchRoutesInterval of the last fetch are coalesced into a single fetch
+// once the interval has passed so the routes are not fetched back to back.
+func (r *RouteFetcher) RequestFetch() error {
+	r.fetchLock.Lock()
+	now := r.clock.Now()
+	wait := r.lastFetch.Add(r.MinFetchRoutesInterval).Sub(now)
+	if r.MinFetchRoutesInterval <= 0 || r.lastFetch.IsZero() || wait <= 0 {
+		r.lastFetch = now
+		r.fetchLock.Unlock()
+		return r.routeClient.FetchRoutes()
+	}
+
+	if r.fetchPending {
+		r.fetchLock.Unlock()
+		r.logger.Debug("fetch-routes-coalesced")
+		return nil
+	}
+	r.fetchPending = true
+	scheduledAfter := r.lastFetch
+	timer := r.clock.NewTimer(wait)
+	r.fetchLock.Unlock()
+
+	r.logger.Debug("fetch-routes-delayed", zap.Duration("wait", wait))
+	go func() {
+		select {
+		case <-timer.C():
+		case <-r.done:
+			timer.Stop()
+			return
+		}
+
+		r.fetchLock.Lock()
+		r.fetchPending = false
+		// Another request already fetched once the interval passed
+		if r.lastFetch != scheduledAfter {
+			r.fetchLock.Unlock()
+			return
+		}
+		r.lastFetch = r.clock.Now()
+		r.fetchLock.Unlock()
+
+		err := r.routeClient.FetchRoutes()
+		if err != nil {
+			r.logger.Error("failed-to-fetch-routes", zap.Error(err))
+		}
+	}()
+	return nil
+}
+
 func (r *RouteFetcher) startEventCycle() {
 	go func() {
 		forceUpdate := false
@@ -166,7 +226,7 @@ func (r *RouteFetcher) subscribeToHTTP() error {
 	}
 	r.logger.Info("successfully-subscribed-to-routing-api-event-stream-HTTP")
 
-	err = r.routeClient.FetchRoutes()
+	err = r.RequestFetch()
 	if err != nil {
 		r.logger.Error("failed-to-refresh-HTTP-routes", zap.Error(err))
 	}
@@ -202,7 +262,7 @@ func (r *RouteFetcher) subscribeToTCP() error {
 	}
 	r.logger.Info("successfully-subscribed-to-routing-api-event-stream-TCP")
 
-	err = r.routeClient.FetchRoutes()
+	err = r.RequestFetch()
 	if err != nil {
 		r.logger.Error("failed-to-refresh-TCP-routes", zap.Error(err))
 	}
@@ -444,6 +444,47 @@ var _ = Describe("RouteFetcher", func() {
 			})
 		})
 
+		Describe("RequestFetch", func() {
+			BeforeEach(func() {
+				uaaClient.FetchTokenReturns(token, nil)
+				client.RoutesReturns(response, nil)
+			})
+
+			Context("with a minimum interval", func() {
+				BeforeEach(func() {
+					fetcher.MinFetchRoutesInterval = time.Second
+				})
+
+				It("coalesces requests made within the interval", func() {
+					Expect(fetcher.RequestFetch()).To(Succeed())
+					Expect(fetcher.RequestFetch()).To(Succeed())
+					Expect(fetcher.RequestFetch()).To(Succeed())
+					Expect(client.RoutesCallCount()).To(Equal(1))
+					Consistently(client.RoutesCallCount).Should(Equal(1))
+
+					clock.WaitForWatcherAndIncrement(time.Second)
+					Eventually(client.RoutesCallCount).Should(Equal(2))
+					Consistently(client.RoutesCallCount).Should(Equal(2))
+				})
+
+				It("fetches immediately once the interval has passed", func() {
+					Expect(fetcher.RequestFetch()).To(Succeed())
+					clock.Increment(time.Second)
+					Expect(fetcher.RequestFetch()).To(Succeed())
+					Expect(client.RoutesCallCount()).To(Equal(2))
+				})
+			})
+
+			Context("without a minimum interval", func() {
+				It("fetches on every request", func() {
+					Expect(fetcher.RequestFetch()).To(Succeed())
+					Expect(fetcher.RequestFetch()).To(Succeed())
+					Expect(fetcher.RequestFetch()).To(Succeed())
+					Expect(client.RoutesCallCount()).To(Equal(3))
+				})
+			})
+		})
+
 		Describe("HandleEvent", func() {
 			Context("When the event is an Upsert", func() {
 				It("registers the route from the registry", func() {
@@ -59,6 +59,12 @@ type entry struct {
 	backends map[BackendServerKey]*BackendServerDetails
 }
 
+// removedBackend identifies a backend deleted from a route
+type removedBackend struct {
+	routingKey RoutingKey
+	backendKey BackendServerKey
+}
+
 // RoutingTable holds all tcp routing information
 type RoutingTable struct {
 	sync.RWMutex
@@ -69,6 +75,14 @@ type RoutingTable struct {
 	pruneStaleDropletsInterval time.Duration
 	dropletStaleThreshold      time.Duration
 	listener                   routeUpdate.Listener
+	// updateLock orders the table changes and holds the route updates
+	// waiting to be sent to the listener. It is taken before the RWMutex, so
+	// the listener may block without holding up table reads.
+	updateLock     sync.Mutex
+	pendingUpdates []routeUpdate.RouteUpdate
+	// removed holds the details of deleted backends so a stale upsert
+	// arriving after the delete does not add them back
+	removed map[removedBackend]*BackendServerDetails
 }
 
 // NewRoutingTable returns a new RoutingTable
@@ -80,6 +94,7 @@ func NewRoutingTable(logger logger.Logger, c *config.Config, listener routeUpdat
 		pruneStaleDropletsInterval: c.PruneStaleDropletsInterval,
 		dropletStaleThreshold:      c.DropletStaleThreshold,
 		listener:                   listener,
+		removed:                    make(map[removedBackend]*BackendServerDetails),
 	}
 }
 
@@ -117,6 +132,13 @@ func (d BackendServerDetails) deleteSucceededBy(other *BackendServerDetails) boo
 	return d.ModificationTag == other.ModificationTag || d.ModificationTag.SucceededBy(&other.ModificationTag)
 }
 
+// olderThan returns true if the details carry a modification tag strictly
+// older than other, only tags with the same guid are comparable
+func (d BackendServerDetails) olderThan(other *BackendServerDetails) bool {
+	tag := d.ModificationTag
+	return tag.Guid != "" && tag.Guid == other.ModificationTag.Guid && tag.Index < other.ModificationTag.Index
+}
+
 // expired returns true if the backend has passed it's ttl
 func (d BackendServerDetails) expired(defaultTTL time.Duration) bool {
 	ttl := d.TTL
@@ -141,8 +163,17 @@ func (e entry) pruneBackends(defaultTTL time.Duration) []BackendServerKey {
 
 // pruneEntries removes stale backends - only call through pruning cycle
 func (table *RoutingTable) pruneEntries(defaultTTL time.Duration) {
+	table.updateLock.Lock()
+	defer table.updateLock.Unlock()
+	defer table.sendUpdates()
 	table.Lock()
 	defer table.Unlock()
+	staleTime := time.Now().Add(-table.dropletStaleThreshold)
+	for key, details := range table.removed {
+		if details.UpdatedTime.Before(staleTime) {
+			delete(table.removed, key)
+		}
+	}
 	for routeKey, entry := range table.entries {
 		removed := entry.pruneBackends(defaultTTL)
 		if len(removed) > 0 && table.listener != nil {
@@ -171,8 +202,22 @@ func (table *RoutingTable) serverKeyDetailsFromInfo(
 func (table *RoutingTable) UpsertBackendServerKey(key RoutingKey, info BackendServerInfo) bool {
 	var update bool
 	logger := table.logger.Session("upsert-backend")
+	table.updateLock.Lock()
+	defer table.updateLock.Unlock()
+	defer table.sendUpdates()
 	table.Lock()
 	defer table.Unlock()
+
+	newBackendKey, newBackendDetails := table.serverKeyDetailsFromInfo(info)
+	removed := removedBackend{routingKey: key, backendKey: newBackendKey}
+	if removedDetails, ok := table.removed[removed]; ok {
+		if newBackendDetails.olderThan(removedDetails) {
+			logger.Debug("skipping-stale-event", zap.Object("removed", removedDetails), zap.Object("new", newBackendDetails))
+			return false
+		}
+		delete(table.removed, removed)
+	}
+
 	existingEntry, routingKeyFound := table.entries[key]
 	if !routingKeyFound {
 		logger.Debug("routing-key-not-found", zap.Object("routing-key", key))
@@ -181,7 +226,6 @@ func (table *RoutingTable) UpsertBackendServerKey(key RoutingKey, info BackendSe
 		update = true
 	}
 
-	newBackendKey, newBackendDetails := table.serverKeyDetailsFromInfo(info)
 	currentBackendDetails, backendFound := existingEntry.backends[newBackendKey]
 
 	if !backendFound ||
@@ -207,6 +251,9 @@ func (table *RoutingTable) UpsertBackendServerKey(key RoutingKey, info BackendSe
 func (table *RoutingTable) DeleteBackendServerKey(key RoutingKey, info BackendServerInfo) bool {
 	var update bool
 	logger := table.logger.Session("delete-backend")
+	table.updateLock.Lock()
+	defer table.updateLock.Unlock()
+	defer table.sendUpdates()
 	table.Lock()
 	defer table.Unlock()
 	backendServerKey, newDetails := table.serverKeyDetailsFromInfo(info)
@@ -221,6 +268,9 @@ func (table *RoutingTable) DeleteBackendServerKey(key RoutingKey, info BackendSe
 			if len(existingEntry.backends) == 0 {
 				delete(table.entries, key)
 			}
+			if table.c.StaleUpdateAction == config.STALE_UPDATE_REJECT {
+				table.removed[removedBackend{routingKey: key, backendKey: backendServerKey}] = newDetails
+			}
 			update = true
 		}
 		logger.Debug("skipping-stale-event", zap.Object("old", existingDetails), zap.Object("new", newDetails))
@@ -298,6 +348,8 @@ func (table *RoutingTable) BackendExists(key RoutingKey, bk BackendServerKey) bo
 	return backendFound
 }
 
+// updateRouter holds a route update until sendUpdates, the caller holds
+// updateLock
 func (table *RoutingTable) updateRouter(
 	op routeUpdate.Operation,
 	routePort uint16,
@@ -317,6 +369,16 @@ func (table *RoutingTable) updateRouter(
 			zap.Error(err),
 		)
 	} else {
+		table.pendingUpdates = append(table.pendingUpdates, update)
+	}
+}
+
+// sendUpdates sends the held route updates to the listener, which may wait
+// for room for them. The caller holds updateLock but not the RWMutex.
+func (table *RoutingTable) sendUpdates() {
+	updates := table.pendingUpdates
+	table.pendingUpdates = nil
+	for _, update := range updates {
 		table.listener.UpdateRoute(update)
 	}
 }
@@ -6,6 +6,7 @@ package routingtable_test
 
 import (
 	"github.com/F5Networks/cf-bigip-ctlr/config"
+	"github.com/F5Networks/cf-bigip-ctlr/f5router/routeUpdate"
 	"github.com/F5Networks/cf-bigip-ctlr/f5router/routeUpdate/fakes"
 	"github.com/F5Networks/cf-bigip-ctlr/routingtable"
 	"github.com/F5Networks/cf-bigip-ctlr/test_util"
@@ -235,6 +236,83 @@ var _ = Describe("RoutingTable", func() {
 		})
 	})
 
+	Describe("out of order updates", func() {
+		var (
+			routingKey routingtable.RoutingKey
+			backendKey routingtable.BackendServerKey
+		)
+
+		tagged := func(index uint32) routingtable.BackendServerInfo {
+			return createBackendServerInfo("some-ip", 1234,
+				routing_api_models.ModificationTag{Guid: "abc", Index: index})
+		}
+
+		BeforeEach(func() {
+			routingKey = routingtable.RoutingKey{Port: 12}
+			backendKey = routingtable.BackendServerKey{Address: "some-ip", Port: 1234}
+		})
+
+		It("keeps the newest upsert", func() {
+			Expect(routingTable.UpsertBackendServerKey(routingKey, tagged(3))).To(BeTrue())
+			Expect(routingTable.UpsertBackendServerKey(routingKey, tagged(1))).To(BeFalse())
+			Expect(routingTable.UpsertBackendServerKey(routingKey, tagged(2))).To(BeFalse())
+			Expect(routingTable.BackendExists(routingKey, backendKey)).To(BeTrue())
+			Expect(routeListener.UpdateRouteCallCount()).To(Equal(1))
+		})
+
+		It("does not add a deleted backend back with a stale upsert", func() {
+			Expect(routingTable.UpsertBackendServerKey(routingKey, tagged(1))).To(BeTrue())
+			Expect(routingTable.DeleteBackendServerKey(routingKey, tagged(3))).To(BeTrue())
+
+			// The upsert of index 2 was delayed past the delete
+			Expect(routingTable.UpsertBackendServerKey(routingKey, tagged(2))).To(BeFalse())
+			Expect(logger).To(gbytes.Say("skipping-stale-event"))
+			Expect(routingTable.RouteExists(routingKey)).To(BeFalse())
+			Expect(routeListener.UpdateRouteCallCount()).To(Equal(2))
+
+			Expect(routingTable.UpsertBackendServerKey(routingKey, tagged(4))).To(BeTrue())
+			Expect(routingTable.BackendExists(routingKey, backendKey)).To(BeTrue())
+			Expect(routeListener.UpdateRouteCallCount()).To(Equal(3))
+		})
+
+		It("adds a deleted backend back with a different guid", func() {
+			Expect(routingTable.UpsertBackendServerKey(routingKey, tagged(1))).To(BeTrue())
+			Expect(routingTable.DeleteBackendServerKey(routingKey, tagged(3))).To(BeTrue())
+
+			replaced := createBackendServerInfo("some-ip", 1234,
+				routing_api_models.ModificationTag{Guid: "def", Index: 0})
+			Expect(routingTable.UpsertBackendServerKey(routingKey, replaced)).To(BeTrue())
+			Expect(routingTable.BackendExists(routingKey, backendKey)).To(BeTrue())
+		})
+
+		It("forgets deleted backends after the droplet stale threshold", func() {
+			c.DropletStaleThreshold = 0
+			routingTable = routingtable.NewRoutingTable(logger, c, routeListener)
+			Expect(routingTable.UpsertBackendServerKey(routingKey, tagged(1))).To(BeTrue())
+			Expect(routingTable.DeleteBackendServerKey(routingKey, tagged(3))).To(BeTrue())
+
+			routingTable.StartPruningCycle()
+			defer routingTable.StopPruningCycle()
+			Eventually(func() bool {
+				return routingTable.UpsertBackendServerKey(routingKey, tagged(2))
+			}).Should(BeTrue())
+		})
+
+		Context("when stale updates are accepted", func() {
+			BeforeEach(func() {
+				c.StaleUpdateAction = config.STALE_UPDATE_ACCEPT
+				routingTable = routingtable.NewRoutingTable(logger, c, routeListener)
+			})
+
+			It("adds a deleted backend back with a stale upsert", func() {
+				Expect(routingTable.UpsertBackendServerKey(routingKey, tagged(1))).To(BeTrue())
+				Expect(routingTable.DeleteBackendServerKey(routingKey, tagged(3))).To(BeTrue())
+				Expect(routingTable.UpsertBackendServerKey(routingKey, tagged(2))).To(BeTrue())
+				Expect(routingTable.BackendExists(routingKey, backendKey)).To(BeTrue())
+			})
+		})
+	})
+
 	Describe("PruningCycle", func() {
 		var (
 			routingKey1 routingtable.RoutingKey
@@ -289,6 +367,35 @@ var _ = Describe("RoutingTable", func() {
 			})
 		})
 	})
+
+	Describe("when the listener queue is full", func() {
+		It("serves reads while an update waits for room", func() {
+			room := make(chan struct{})
+			routeListener.UpdateRouteStub = func(routeUpdate.RouteUpdate) {
+				<-room
+			}
+			routingKey := routingtable.RoutingKey{Port: 12}
+			upserted := make(chan struct{})
+			go func() {
+				routingTable.UpsertBackendServerKey(routingKey, createBackendServerInfo("some-ip", 1234, modificationTag))
+				close(upserted)
+			}()
+			Eventually(routeListener.UpdateRouteCallCount).Should(Equal(1))
+			Consistently(upserted).ShouldNot(BeClosed())
+
+			read := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				Expect(routingTable.NumberOfRoutes()).To(Equal(1))
+				Expect(routingTable.RouteExists(routingKey)).To(BeTrue())
+				close(read)
+			}()
+			Eventually(read).Should(BeClosed())
+
+			close(room)
+			Eventually(upserted).Should(BeClosed())
+		})
+	})
 })
 
 func createBackendServerInfo(
@@ -53,10 +53,34 @@ var _ = Describe("Schema", func() {
         "virtualServer": {
           "policies": ["potato", "eggs"],
           "profiles": ["bacon"],
-          "sslProfiles": ["foo"]
+          "sslProfiles": ["foo"],
+          "analyticsProfile": "/Common/analytics",
+          "persistence": "/Common/cookie",
+          "fallbackPersistence": "/Common/source_addr",
+          "noMatchAction": "reject",
+          "headerMatches": [{
+            "header": "X-Api-Version",
+            "values": ["2"],
+            "pool": "/Common/api-v2"
+          }, {
+            "header": "X-Canary",
+            "operator": "exists",
+            "pool": "/Common/canary"
+          }],
+          "onPoolDown": {
+            "action": "maintenance",
+            "content": "<h1>Down for maintenance</h1>"
+          },
+          "maxRequestBytes": 1048576,
+          "maxResponseBytes": 10485760,
+          "addressTranslation": "nat64"
         },
         "pool": {
           "balance": "ratio-member",
+          "serviceDownAction": "reselect",
+          "queueOnConnectionLimit": true,
+          "queueDepthLimit": 100,
+          "queueTimeLimit": 5000,
           "healthMonitors": [{
             "name": "0",
             "interval": 1,
@@ -68,9 +92,23 @@ var _ = Describe("Schema", func() {
       }, {
         "name": "test3",
         "description":"again argg",
+        "monitorOnly": true,
         "pool": {
           "healthMonitors": [{"name": "/Common/http"}]
         }
+      }, {
+        "name": "test4",
+        "description": "shared gateway",
+        "externalPool": "/Common/gateway"
+      }, {
+        "name": "test5",
+        "description": "autoscaled",
+        "scaleSignals": {
+          "minMembers": 2,
+          "maxMembers": 10,
+          "maxMemberChanges": 5,
+          "window": 120
+        }
       }]
     }`
 	})
@@ -102,4 +140,116 @@ var _ = Describe("Schema", func() {
 		Eventually(logger).Should(gbytes.Say("schema-not-valid"))
 	})
 
+	It("fails against an unknown no match action", func() {
+		config := `{"plans":[{"description":"arggg","name":"test","virtualServer":{"noMatchAction":"potato"}}]}`
+		val, err := schema.VerifySchema(config, logger)
+		Expect(val).To(BeFalse())
+		Expect(err).To(BeNil())
+	})
+
+	It("fails against an invalid external pool", func() {
+		configs := []string{
+			`{"plans":[{"description":"arggg","name":"test","externalPool":""}]}`,
+			`{"plans":[{"description":"arggg","name":"test","externalPool":"gateway"}]}`,
+			`{"plans":[{"description":"arggg","name":"test","externalPool":"/Common/gateway","pool":{"balance":"round-robin"}}]}`,
+		}
+		for _, config := range configs {
+			val, err := schema.VerifySchema(config, logger)
+			Expect(val).To(BeFalse())
+			Expect(err).To(BeNil())
+		}
+	})
+
+	It("fails against invalid scale signal thresholds", func() {
+		configs := []string{
+			`{"plans":[{"description":"arggg","name":"test","scaleSignals":{}}]}`,
+			`{"plans":[{"description":"arggg","name":"test","scaleSignals":{"maxMembers":0}}]}`,
+			`{"plans":[{"description":"arggg","name":"test","scaleSignals":{"minMembers":1,"window":60}}]}`,
+		}
+		for _, config := range configs {
+			val, err := schema.VerifySchema(config, logger)
+			Expect(val).To(BeFalse())
+			Expect(err).To(BeNil())
+		}
+	})
+
+	It("fails against a monitor only plan without a pool", func() {
+		config := `{"plans":[{"description":"arggg","name":"test","monitorOnly":true,"virtualServer":{"policies":["potato"]}}]}`
+		val, err := schema.VerifySchema(config, logger)
+		Expect(val).To(BeFalse())
+		Expect(err).To(BeNil())
+	})
+
+	It("fails against an unknown address translation mode", func() {
+		config := `{"plans":[{"description":"arggg","name":"test","virtualServer":{"addressTranslation":"nat46"}}]}`
+		val, err := schema.VerifySchema(config, logger)
+		Expect(val).To(BeFalse())
+		Expect(err).To(BeNil())
+	})
+
+	It("fails against queue limits without connection queuing", func() {
+		configs := []string{
+			`{"plans":[{"description":"arggg","name":"test","pool":{"balance":"round-robin","queueDepthLimit":100}}]}`,
+			`{"plans":[{"description":"arggg","name":"test","pool":{"queueOnConnectionLimit":true,"queueTimeLimit":-1}}]}`,
+		}
+		for _, config := range configs {
+			val, err := schema.VerifySchema(config, logger)
+			Expect(val).To(BeFalse())
+			Expect(err).To(BeNil())
+		}
+	})
+
+	It("fails against an unknown service down action", func() {
+		config := `{"plans":[{"description":"arggg","name":"test","pool":{"serviceDownAction":"restart"}}]}`
+		val, err := schema.VerifySchema(config, logger)
+		Expect(val).To(BeFalse())
+		Expect(err).To(BeNil())
+	})
+
+	It("fails against a fallback persistence without a persistence profile", func() {
+		config := `{"plans":[{"description":"arggg","name":"test","virtualServer":{"profiles":["bacon"],"fallbackPersistence":"/Common/source_addr"}}]}`
+		val, err := schema.VerifySchema(config, logger)
+		Expect(val).To(BeFalse())
+		Expect(err).To(BeNil())
+	})
+
+	It("fails against an invalid size limit", func() {
+		configs := []string{
+			`{"plans":[{"description":"arggg","name":"test","virtualServer":{"maxRequestBytes":0}}]}`,
+			`{"plans":[{"description":"arggg","name":"test","virtualServer":{"maxResponseBytes":-1}}]}`,
+			`{"plans":[{"description":"arggg","name":"test","virtualServer":{"maxRequestBytes":"1MB"}}]}`,
+		}
+		for _, config := range configs {
+			val, err := schema.VerifySchema(config, logger)
+			Expect(val).To(BeFalse())
+			Expect(err).To(BeNil())
+		}
+	})
+
+	It("fails against an invalid pool down action", func() {
+		configs := []string{
+			`{"plans":[{"description":"arggg","name":"test","virtualServer":{"onPoolDown":{"action":"retry"}}}]}`,
+			`{"plans":[{"description":"arggg","name":"test","virtualServer":{"onPoolDown":{"action":"redirect"}}}]}`,
+			`{"plans":[{"description":"arggg","name":"test","virtualServer":{"onPoolDown":{"action":"status","statusCode":600}}}]}`,
+		}
+		for _, config := range configs {
+			val, err := schema.VerifySchema(config, logger)
+			Expect(val).To(BeFalse())
+			Expect(err).To(BeNil())
+		}
+	})
+
+	It("fails against an invalid header match", func() {
+		configs := []string{
+			`{"plans":[{"description":"arggg","name":"test","virtualServer":{"headerMatches":[{"header":"X-Api-Version","pool":"/Common/api-v2"}]}}]}`,
+			`{"plans":[{"description":"arggg","name":"test","virtualServer":{"headerMatches":[{"header":"X-Api-Version","operator":"contains","values":["2"],"pool":"/Common/api-v2"}]}}]}`,
+			`{"plans":[{"description":"arggg","name":"test","virtualServer":{"headerMatches":[{"header":"X-Api-Version","values":["2"],"pool":"api-v2"}]}}]}`,
+		}
+		for _, config := range configs {
+			val, err := schema.VerifySchema(config, logger)
+			Expect(val).To(BeFalse())
+			Expect(err).To(BeNil())
+		}
+	})
+
 })
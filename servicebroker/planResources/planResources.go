@@ -20,31 +20,113 @@ import (
 	"github.com/F5Networks/cf-bigip-ctlr/f5router/bigipResources"
 )
 
+const (
+	// NoMatchReject resets the connection when no route policy rule matches
+	NoMatchReject = "reject"
+	// NoMatchDrop drops the request when no route policy rule matches
+	NoMatchDrop = "drop"
+	// NoMatchDefaultPool forwards to the route pool when no route policy rule matches
+	NoMatchDefaultPool = "default-pool"
+
+	// HeaderMatchEquals matches when the header has one of the values
+	HeaderMatchEquals = "equals"
+	// HeaderMatchExists matches when the header is present
+	HeaderMatchExists = "exists"
+
+	// PoolDownMaintenance serves a maintenance page when the route pool is down
+	PoolDownMaintenance = "maintenance"
+	// PoolDownRedirect redirects to a status URL when the route pool is down
+	PoolDownRedirect = "redirect"
+	// PoolDownStatus returns a status code when the route pool is down
+	PoolDownStatus = "status"
+
+	// AddressTranslationNone leaves the client address family unchanged
+	AddressTranslationNone = "none"
+	// AddressTranslationNAT64 translates IPv6 clients to IPv4 pool members
+	AddressTranslationNAT64 = "nat64"
+)
+
+// NoMatchActions are the actions of a route policy when none of its rules
+// match
+var NoMatchActions = []string{NoMatchReject, NoMatchDrop, NoMatchDefaultPool}
+
+// ServiceDownActions are the BIG-IP actions on existing connections when a
+// pool member goes down
+var ServiceDownActions = []string{"none", "reset", "drop", "reselect"}
+
 type (
 	// Plans holds our plans
 	Plans struct {
 		Plans []Plan `json:"plans"`
 	}
 
-	// Plan used for per route config
+	// Plan used for per route config, routes bound to a monitor only plan keep
+	// their pool and monitors but get no traffic until they are unbound or
+	// bound to another plan. Routes bound to a plan with an external pool send
+	// their traffic to that existing BIG-IP pool instead of their own.
 	Plan struct {
-		Name          string      `json:"name,omitempty"`
-		Description   string      `json:"description,omitempty"`
-		Pool          PoolType    `json:"pool,omitempty"`
-		VirtualServer VirtualType `json:"virtualServer,omitempty"`
+		Name          string           `json:"name,omitempty"`
+		Description   string           `json:"description,omitempty"`
+		Pool          PoolType         `json:"pool,omitempty"`
+		VirtualServer VirtualType      `json:"virtualServer,omitempty"`
+		MonitorOnly   bool             `json:"monitorOnly,omitempty"`
+		ExternalPool  string           `json:"externalPool,omitempty"`
+		ScaleSignals  *ScaleSignalType `json:"scaleSignals,omitempty"`
 		ID            string
 	}
 
-	// PoolType holds pool info
+	// PoolType holds pool info, the queue limits are only used along with
+	// QueueOnConnectionLimit and the time limit is in milliseconds
 	PoolType struct {
-		Balance        string                   `json:"balance,omitempty"`
-		HealthMonitors []bigipResources.Monitor `json:"healthMonitors,omitempty"`
+		Balance                string                   `json:"balance,omitempty"`
+		HealthMonitors         []bigipResources.Monitor `json:"healthMonitors,omitempty"`
+		ServiceDownAction      string                   `json:"serviceDownAction,omitempty"`
+		QueueOnConnectionLimit bool                     `json:"queueOnConnectionLimit,omitempty"`
+		QueueDepthLimit        int                      `json:"queueDepthLimit,omitempty"`
+		QueueTimeLimit         int                      `json:"queueTimeLimit,omitempty"`
 	}
 
 	// VirtualType holds virtual info
 	VirtualType struct {
-		Policies    []string `json:"policies,omitempty"`
-		Profiles    []string `json:"profiles,omitempty"`
-		SslProfiles []string `json:"sslProfiles,omitempty"`
+		Policies            []string      `json:"policies,omitempty"`
+		Profiles            []string      `json:"profiles,omitempty"`
+		SslProfiles         []string      `json:"sslProfiles,omitempty"`
+		AnalyticsProfile    string        `json:"analyticsProfile,omitempty"`
+		Persistence         string        `json:"persistence,omitempty"`
+		FallbackPersistence string        `json:"fallbackPersistence,omitempty"`
+		NoMatchAction       string        `json:"noMatchAction,omitempty"`
+		HeaderMatches       []HeaderMatch `json:"headerMatches,omitempty"`
+		OnPoolDown          *PoolDownType `json:"onPoolDown,omitempty"`
+		MaxRequestBytes     int64         `json:"maxRequestBytes,omitempty"`
+		MaxResponseBytes    int64         `json:"maxResponseBytes,omitempty"`
+		AddressTranslation  string        `json:"addressTranslation,omitempty"`
+	}
+
+	// ScaleSignalType holds the thresholds for signaling external autoscaling
+	// from a route's pool, member changes are counted over the window in
+	// seconds and a zero threshold is not checked
+	ScaleSignalType struct {
+		MinMembers       int `json:"minMembers,omitempty"`
+		MaxMembers       int `json:"maxMembers,omitempty"`
+		MaxMemberChanges int `json:"maxMemberChanges,omitempty"`
+		Window           int `json:"window,omitempty"`
+	}
+
+	// PoolDownType holds the response for requests to a route whose pool has
+	// no available members
+	PoolDownType struct {
+		Action     string `json:"action"`
+		Content    string `json:"content,omitempty"`
+		Location   string `json:"location,omitempty"`
+		StatusCode int    `json:"statusCode,omitempty"`
+	}
+
+	// HeaderMatch steers requests for the route with a matching request
+	// header to an existing BIG-IP pool
+	HeaderMatch struct {
+		Header   string   `json:"header"`
+		Operator string   `json:"operator,omitempty"`
+		Values   []string `json:"values,omitempty"`
+		Pool     string   `json:"pool"`
 	}
 )
@@ -55,6 +55,10 @@ func (broker *ServiceBroker) processPlans(val string) (map[string]planResources.
 	}
 
 	for _, plan := range plans.Plans {
+		err = validatePlan(plan)
+		if nil != err {
+			return nil, err
+		}
 		guid, err := uuid.GenerateUUID()
 		if nil != err {
 			broker.logger.Warn("process-plans-error", zap.Error(err), zap.Object("skipping-plan", plan))
@@ -65,6 +69,21 @@ func (broker *ServiceBroker) processPlans(val string) (map[string]planResources.
 	return planMap, nil
 }
 
+// validatePlan rejects the plan values the router can not apply, so a bad
+// plan fails when the plans are processed instead of when a route is bound
+func validatePlan(plan planResources.Plan) error {
+	action := plan.VirtualServer.NoMatchAction
+	if action == "" {
+		return nil
+	}
+	for _, a := range planResources.NoMatchActions {
+		if action == a {
+			return nil
+		}
+	}
+	return fmt.Errorf("plan %s: unsupported no match action: %s", plan.Name, action)
+}
+
 func (broker *ServiceBroker) prepPlansforAPIResponse() []brokerapi.ServicePlan {
 	var apiPlans []brokerapi.ServicePlan
 	for id, plan := range broker.plans {
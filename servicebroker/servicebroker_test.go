@@ -317,5 +317,15 @@ var _ = Describe("ServiceBroker", func() {
 			service := broker.Services(ctx)[0]
 			Expect(len(service.Plans)).To(Equal(1))
 		})
+
+		It("errors when a plan has an unsupported no match action", func() {
+			os.Setenv("SERVICE_BROKER_CONFIG",
+				`{"plans":[{"description":"arggg","name":"test","virtualServer":{"noMatchAction":"potato"}}]}`)
+
+			err := broker.ProcessPlans()
+			Expect(err).To(HaveOccurred())
+			Expect(router.AddPlansCallCount()).To(Equal(0))
+			Expect(broker.Services(ctx)[0].Plans).To(BeEmpty())
+		})
 	})
 })
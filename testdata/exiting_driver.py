@@ -0,0 +1,28 @@
+import signal
+import sys
+
+# Exits normally on the first starts, counted in a file next to the config
+# file, the same as a driver which finished its work
+config_file = sys.argv[sys.argv.index('--config-file') + 1]
+starts_file = config_file + '.starts'
+try:
+    with open(starts_file) as f:
+        starts = int(f.read())
+except (IOError, ValueError):
+    starts = 0
+with open(starts_file, 'w') as f:
+    f.write(str(starts + 1))
+
+if starts < 2:
+    sys.stderr.write('[INFO] Driver done\n')
+    sys.exit(0)
+
+
+def receive_signal(signum, stack):
+    print('Received:', signum)
+
+
+signal.signal(signal.SIGTERM, receive_signal)
+signal.signal(signal.SIGINT, receive_signal)
+
+signal.pause()
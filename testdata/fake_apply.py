@@ -0,0 +1,13 @@
+import sys
+
+# Records the config file it was run with next to the config file, the same
+# as tooling which applies the config to the BIG-IP
+config_file = sys.argv[sys.argv.index('--file') + 1]
+with open(config_file + '.applied', 'a') as f:
+    f.write(config_file + '\n')
+
+if '--fail' in sys.argv:
+    sys.stderr.write('[ERROR] BIG-IP rejected the config\n')
+    sys.exit(1)
+
+sys.stderr.write('[INFO] applied ' + config_file + '\n')
@@ -0,0 +1,28 @@
+import signal
+import sys
+
+# Fails the first starts, counted in a file next to the config file, the
+# same as a driver which can't reach the BIG-IP yet
+config_file = sys.argv[sys.argv.index('--config-file') + 1]
+starts_file = config_file + '.starts'
+try:
+    with open(starts_file) as f:
+        starts = int(f.read())
+except (IOError, ValueError):
+    starts = 0
+with open(starts_file, 'w') as f:
+    f.write(str(starts + 1))
+
+if starts < 2:
+    sys.stderr.write('[ERROR] BIG-IP is unreachable\n')
+    sys.exit(1)
+
+
+def receive_signal(signum, stack):
+    print('Received:', signum)
+
+
+signal.signal(signal.SIGTERM, receive_signal)
+signal.signal(signal.SIGINT, receive_signal)
+
+signal.pause()